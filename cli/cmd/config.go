@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// describeTimeout bounds each agent's --describe run during validation.
+const describeTimeout = 10 * time.Second
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the shared config",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the shared config for mistakes",
+	Long: `Check the shared config against its schema, confirm that every agent
+configured under agents is installed on PATH, and flag env entries that no
+installed agent declares.`,
+	Args: cobra.NoArgs,
+	RunE: runConfigValidate,
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	path := configFilePath()
+	if path == "" {
+		return errors.New("failed to determine the shared config path")
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml", ".toml":
+		return fmt.Errorf("config validate supports only JSON config files, not %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		fmt.Printf("No config file at %s; agents use built-in defaults\n", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	config := make(map[string]any)
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	report := validateSharedConfig(config)
+	fmt.Printf("Validating %s\n", path)
+	for _, e := range report.errors {
+		fmt.Printf("  ✗ %s\n", e)
+	}
+	for _, w := range report.warnings {
+		fmt.Printf("  ! %s\n", w)
+	}
+
+	fmt.Println()
+	if len(report.errors) > 0 {
+		fmt.Printf("%d errors, %d warnings\n", len(report.errors), len(report.warnings))
+		os.Exit(1)
+	}
+	if len(report.warnings) > 0 {
+		fmt.Printf("No errors, %d warnings\n", len(report.warnings))
+		return nil
+	}
+	fmt.Println("Config is valid")
+	return nil
+}
+
+// configReport holds the problems found in a shared config. Errors fail
+// validation; warnings do not.
+type configReport struct {
+	errors   []string
+	warnings []string
+}
+
+// validateSharedConfig checks config against the schema, checks that the
+// agents it configures (under agents and each profile's agents) are on
+// PATH, and flags env keys the agents do not declare.
+func validateSharedConfig(config map[string]any) configReport {
+	report := configReport{errors: validateConfig(config)}
+
+	agents := make(map[string]*installedAgent)
+	checkAgentNamespaces("agents", config["agents"], agents, &report)
+	profiles, _ := config["profiles"].(map[string]any)
+	for _, name := range sortedKeys(profiles) {
+		profile, _ := profiles[name].(map[string]any)
+		checkAgentNamespaces("profiles."+name+".agents", profile["agents"], agents, &report)
+	}
+
+	// defaults.env applies to every agent, so a key is only suspect if no
+	// agent that could be described declares it.
+	declared := make(map[string]bool)
+	described := false
+	for _, a := range agents {
+		if a != nil && a.env != nil {
+			described = true
+			for k := range a.env {
+				declared[k] = true
+			}
+		}
+	}
+	if !described {
+		return report
+	}
+	checkDefaultsEnv("defaults", config["defaults"], declared, &report)
+	for _, name := range sortedKeys(profiles) {
+		profile, _ := profiles[name].(map[string]any)
+		checkDefaultsEnv("profiles."+name+".defaults", profile["defaults"], declared, &report)
+	}
+	return report
+}
+
+// installedAgent is an agent found on PATH and the env names it declares.
+// env is nil if its --describe output could not be read.
+type installedAgent struct {
+	path string
+	env  map[string]bool
+}
+
+// checkAgentNamespaces checks each agent namespace under prefix, looking
+// agents up once and remembering them in agents.
+func checkAgentNamespaces(prefix string, v any, agents map[string]*installedAgent, report *configReport) {
+	namespaces, _ := v.(map[string]any)
+	for _, name := range sortedKeys(namespaces) {
+		a, seen := agents[name]
+		if !seen {
+			path, err := exec.LookPath(name)
+			if err != nil {
+				report.errors = append(report.errors, fmt.Sprintf("%s.%s: agent %s is not installed on PATH", prefix, name, name))
+				agents[name] = nil
+				continue
+			}
+			a = &installedAgent{path: path}
+			if a.env, err = describeAgentEnv(path); err != nil {
+				report.warnings = append(report.warnings, fmt.Sprintf("%s.%s: could not read --describe: %v", prefix, name, err))
+			}
+			agents[name] = a
+		}
+		if a == nil || a.env == nil {
+			continue
+		}
+		ns, _ := namespaces[name].(map[string]any)
+		env, _ := ns["env"].(map[string]any)
+		for _, key := range sortedKeys(env) {
+			if !a.env[key] {
+				report.warnings = append(report.warnings, fmt.Sprintf("%s.%s.env.%s is not declared by %s", prefix, name, key, name))
+			}
+		}
+	}
+}
+
+// checkDefaultsEnv flags keys of a defaults namespace's env that no
+// described agent declares.
+func checkDefaultsEnv(prefix string, v any, declared map[string]bool, report *configReport) {
+	ns, _ := v.(map[string]any)
+	env, _ := ns["env"].(map[string]any)
+	for _, key := range sortedKeys(env) {
+		if !declared[key] {
+			report.warnings = append(report.warnings, fmt.Sprintf("%s.env.%s is not declared by any installed agent", prefix, key))
+		}
+	}
+}
+
+// describeAgentEnv runs the agent with --describe and returns the env
+// names it declares, including the SFA_SVC_<NAME>_URL, _HOST, and _PORT
+// overrides of the services it uses.
+func describeAgentEnv(path string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), describeTimeout)
+	defer cancel()
+	runner := resolveRunner(path)
+	out, err := exec.CommandContext(ctx, runner[0], append(runner[1:], "--describe")...).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var desc struct {
+		Env []struct {
+			Name string `json:"name"`
+		} `json:"env"`
+		Services []string `json:"services"`
+	}
+	if err := json.Unmarshal(out, &desc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	env := make(map[string]bool)
+	for _, e := range desc.Env {
+		env[e.Name] = true
+	}
+	for _, svc := range desc.Services {
+		name := strings.ToUpper(strings.ReplaceAll(svc, "-", "_"))
+		for _, suffix := range []string{"URL", "HOST", "PORT"} {
+			env[fmt.Sprintf("SFA_SVC_%s_%s", name, suffix)] = true
+		}
+	}
+	return env, nil
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// installFakeAgent writes an executable to dir that prints describe for
+// --describe.
+func installFakeAgent(t *testing.T, dir, name, describe string) {
+	t.Helper()
+	script := "#!/bin/sh\necho '" + describe + "'\n"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateSharedConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake agents are shell scripts")
+	}
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	installFakeAgent(t, dir, "api-client", `{"name":"api-client","env":[{"name":"API_URL"},{"name":"API_KEY"}],"services":["postgres-db"]}`)
+	installFakeAgent(t, dir, "broken", `not json`)
+
+	config := map[string]any{
+		"defaults": map[string]any{
+			"timeout": "slow",
+			"env":     map[string]any{"API_URL": "https://x", "STRAY": "1"},
+		},
+		"agents": map[string]any{
+			"api-client": map[string]any{"env": map[string]any{
+				"API_KEY":                 "k",
+				"SFA_SVC_POSTGRES_DB_URL": "postgres://localhost",
+				"TYPO_KEY":                "v",
+			}},
+			"broken":  map[string]any{"env": map[string]any{"ANY": "1"}},
+			"missing": map[string]any{},
+		},
+		"profiles": map[string]any{
+			"prod": map[string]any{"agents": map[string]any{
+				"api-client": map[string]any{"env": map[string]any{"API_HOST": "h"}},
+			}},
+		},
+	}
+	report := validateSharedConfig(config)
+
+	wantErrors := []string{
+		"defaults.timeout must be a number, got a string",
+		"agents.missing: agent missing is not installed on PATH",
+	}
+	if strings.Join(report.errors, "\n") != strings.Join(wantErrors, "\n") {
+		t.Errorf("errors = %q, want %q", report.errors, wantErrors)
+	}
+
+	wantWarnings := []string{
+		"agents.api-client.env.TYPO_KEY is not declared by api-client",
+		"agents.broken: could not read --describe",
+		"profiles.prod.agents.api-client.env.API_HOST is not declared by api-client",
+		"defaults.env.STRAY is not declared by any installed agent",
+	}
+	if len(report.warnings) != len(wantWarnings) {
+		t.Fatalf("warnings = %q, want %q", report.warnings, wantWarnings)
+	}
+	for i, w := range wantWarnings {
+		if !strings.HasPrefix(report.warnings[i], w) {
+			t.Errorf("warning %d = %q, want prefix %q", i, report.warnings[i], w)
+		}
+	}
+}
+
+func TestValidateSharedConfigNoAgents(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	config := map[string]any{
+		"defaults": map[string]any{"env": map[string]any{"ANY": "1"}},
+		"unknown":  true,
+	}
+	report := validateSharedConfig(config)
+	if len(report.errors) != 1 || report.errors[0] != "unknown key unknown" {
+		t.Errorf("errors = %q", report.errors)
+	}
+	if len(report.warnings) != 0 {
+		t.Errorf("expected no env warnings without installed agents, got %q", report.warnings)
+	}
+}
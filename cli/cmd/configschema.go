@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+)
+
+// configSchema describes one value in the shared config. It mirrors the
+// SDK's configschema.go; keep the two in sync.
+type configSchema struct {
+	kind   string                  // "object", "string", "number", "boolean", "scalar", or "any"
+	fields map[string]configSchema // known keys of an object
+	values *configSchema           // schema of every other key's value; nil rejects other keys
+}
+
+var (
+	anyValue    = configSchema{kind: "any"}
+	stringValue = configSchema{kind: "string"}
+	numberValue = configSchema{kind: "number"}
+	boolValue   = configSchema{kind: "boolean"}
+	scalarValue = configSchema{kind: "scalar"}
+)
+
+// envSchema is an env namespace: variable names to values.
+var envSchema = configSchema{kind: "object", values: &scalarValue}
+
+// namespaceServicesSchema is a namespace's services section: where the
+// agent's services run, which compose profiles to start, a list or a
+// comma-separated string, and how long persistent services may sit idle.
+var namespaceServicesSchema = configSchema{kind: "object", fields: map[string]configSchema{
+	"host":     stringValue,
+	"context":  stringValue,
+	"profiles": anyValue,
+	"idleTTL":  stringValue,
+}}
+
+// namespaceSchema is defaults and each agent namespace. Agents read their
+// own keys from it, so unknown keys are allowed.
+var namespaceSchema = configSchema{
+	kind: "object",
+	fields: map[string]configSchema{
+		"timeout":      numberValue,
+		"outputFormat": stringValue,
+		"verbose":      boolValue,
+		"env":          envSchema,
+		"services":     namespaceServicesSchema,
+	},
+	values: &anyValue,
+}
+
+// contextLimitSchema is one scope of contextStore.quota.
+var contextLimitSchema = configSchema{kind: "object", fields: map[string]configSchema{
+	"maxEntries": numberValue,
+	"maxBytes":   numberValue,
+}}
+
+// profileSchema is one named profile: the parts of the config it overrides.
+var profileSchema = configSchema{
+	kind: "object",
+	fields: map[string]configSchema{
+		"defaults": namespaceSchema,
+		"agents":   {kind: "object", values: &namespaceSchema},
+	},
+}
+
+// sharedConfigSchema is the shape of the shared config file.
+var sharedConfigSchema = configSchema{
+	kind: "object",
+	fields: map[string]configSchema{
+		"apiKeys":    {kind: "object", values: &stringValue},
+		"models":     {kind: "object", values: &stringValue},
+		"mcpServers": {kind: "object", values: &stringValue},
+		"defaults":   namespaceSchema,
+		"agents":     {kind: "object", values: &namespaceSchema},
+		"logging": {kind: "object", fields: map[string]configSchema{
+			"file":        stringValue,
+			"maxSize":     numberValue,
+			"retainFiles": numberValue,
+			"perAgent":    scalarValue,
+			"buffer":      scalarValue,
+			"sinks":       anyValue,
+		}},
+		"contextStore": {kind: "object", fields: map[string]configSchema{
+			"path":       stringValue,
+			"url":        stringValue,
+			"region":     stringValue,
+			"endpoint":   stringValue,
+			"summarizer": stringValue,
+			"encrypt":    boolValue,
+			"retention":  {kind: "object", values: &stringValue},
+			"quota": {kind: "object", fields: map[string]configSchema{
+				"agent":      contextLimitSchema,
+				"session":    contextLimitSchema,
+				"onExceeded": stringValue,
+			}},
+		}},
+		"metrics": {kind: "object", fields: map[string]configSchema{
+			"file": stringValue,
+		}},
+		"secrets": {kind: "object", fields: map[string]configSchema{
+			"recipient": stringValue,
+		}},
+		"services": {kind: "object", fields: map[string]configSchema{
+			"engine":  stringValue,
+			"host":    stringValue,
+			"context": stringValue,
+		}},
+		"profiles": {kind: "object", values: &profileSchema},
+	},
+}
+
+// validateConfig checks a decoded config against sharedConfigSchema and
+// returns one message per unknown key or type mismatch, in key order.
+func validateConfig(config map[string]any) []string {
+	var problems []string
+	sharedConfigSchema.check("", config, &problems)
+	return problems
+}
+
+func (s configSchema) check(path string, v any, problems *[]string) {
+	if !s.accepts(v) {
+		*problems = append(*problems, fmt.Sprintf("%s must be %s, got %s", path, s.describe(), configKind(v)))
+		return
+	}
+	m, ok := v.(map[string]any)
+	if !ok || s.kind != "object" {
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		child := k
+		if path != "" {
+			child = path + "." + k
+		}
+		if field, ok := s.fields[k]; ok {
+			field.check(child, m[k], problems)
+		} else if s.values != nil {
+			s.values.check(child, m[k], problems)
+		} else {
+			*problems = append(*problems, fmt.Sprintf("unknown key %s", child))
+		}
+	}
+}
+
+func (s configSchema) accepts(v any) bool {
+	switch s.kind {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "scalar":
+		switch v.(type) {
+		case string, float64, bool:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+func (s configSchema) describe() string {
+	switch s.kind {
+	case "object":
+		return "an object"
+	case "scalar":
+		return "a string, number, or boolean"
+	}
+	return "a " + s.kind
+}
+
+// configKind names the JSON type of a decoded value.
+func configKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,535 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	contextStatsJSON     bool
+	contextExportAgent   string
+	contextExportSess    string
+	contextExportFormat  string
+	contextExportOutput  string
+	contextShowGraph     bool
+	contextShowDepth     int
+	contextSessionsJSON  bool
+	contextSessionsAgent string
+)
+
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Inspect, export, and import the context store",
+}
+
+var contextStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show context store usage per agent against its quota",
+	Long: `Show how many entries and bytes each agent holds in the context store, and in
+its largest session, against the limits contextStore.quota sets.`,
+	Args: cobra.NoArgs,
+	RunE: runContextStats,
+}
+
+var contextExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export context entries as a JSON document or tarball",
+	Long: `Export the context entries of an agent, a session, or the whole store, to hand
+them to another machine or teammate with "sfa context import".`,
+	Args: cobra.NoArgs,
+	RunE: runContextExport,
+}
+
+var contextImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import context entries exported by sfa context export",
+	Long: `Import an export, JSON or tarball, into the context store. Entries whose path
+the store already has are left as they are. Use - to read the export from stdin.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runContextImport,
+}
+
+var contextShowCmd = &cobra.Command{
+	Use:   "show <entry>",
+	Short: "Show a context entry, or the graph of entries linked to it",
+	Long: `Print a context entry, given by its path relative to the store root or its file.
+With --graph, draw the entries it links to and those linking to it instead,
+following links --depth deep.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runContextShow,
+}
+
+var contextSessionsCmd = &cobra.Command{
+	Use:   "sessions [session-id]",
+	Short: "List the sessions in the context store, or the entries of one",
+	Long: `List the sessions with entries in the context store, the most recently active
+first, with their entry counts, time ranges, and the agents that took part.
+Given a session ID, list that session's entries instead, oldest first.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runContextSessions,
+}
+
+func init() {
+	contextShowCmd.Flags().BoolVar(&contextShowGraph, "graph", false, "Draw the entries linked to and from the entry")
+	contextShowCmd.Flags().IntVar(&contextShowDepth, "depth", 2, "Links to follow from the entry with --graph; -1 follows every link")
+	contextSessionsCmd.Flags().BoolVar(&contextSessionsJSON, "json", false, "Print sessions, or a session's entries, as JSON")
+	contextSessionsCmd.Flags().StringVar(&contextSessionsAgent, "agent", "", "Only this agent's entries")
+	contextStatsCmd.Flags().BoolVar(&contextStatsJSON, "json", false, "Print usage as JSON")
+	contextExportCmd.Flags().StringVar(&contextExportAgent, "agent", "", "Export only this agent's entries")
+	contextExportCmd.Flags().StringVar(&contextExportSess, "session", "", "Export only entries of this session")
+	contextExportCmd.Flags().StringVar(&contextExportFormat, "format", "json", "Export format: json or tar (gzipped)")
+	contextExportCmd.Flags().StringVarP(&contextExportOutput, "output", "o", "", "Write the export to this file instead of stdout")
+	contextCmd.AddCommand(contextStatsCmd)
+	contextCmd.AddCommand(contextExportCmd)
+	contextCmd.AddCommand(contextImportCmd)
+	contextCmd.AddCommand(contextShowCmd)
+	contextCmd.AddCommand(contextSessionsCmd)
+}
+
+// contextUsage counts the entries of one scope.
+type contextUsage struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// contextLimit mirrors the SDK's quota for one scope; zero is unlimited.
+type contextLimit struct {
+	MaxEntries int   `json:"maxEntries,omitempty"`
+	MaxBytes   int64 `json:"maxBytes,omitempty"`
+}
+
+// contextQuota mirrors the SDK's contextStore.quota.
+type contextQuota struct {
+	Agent      contextLimit `json:"agent"`
+	Session    contextLimit `json:"session"`
+	OnExceeded string       `json:"onExceeded"`
+}
+
+type contextAgentUsage struct {
+	Agent string `json:"agent"`
+	contextUsage
+	Sessions map[string]contextUsage `json:"sessions,omitempty"`
+}
+
+type contextStats struct {
+	Store  string               `json:"store"`
+	Quota  contextQuota         `json:"quota"`
+	Agents []*contextAgentUsage `json:"agents"`
+}
+
+// resolveContextQuota reads contextStore.quota from the shared config.
+func resolveContextQuota(config map[string]any) contextQuota {
+	cs, _ := config["contextStore"].(map[string]any)
+	raw, _ := cs["quota"].(map[string]any)
+	limit := func(key string) contextLimit {
+		m, _ := raw[key].(map[string]any)
+		entries, _ := m["maxEntries"].(float64)
+		bytes, _ := m["maxBytes"].(float64)
+		return contextLimit{MaxEntries: int(entries), MaxBytes: int64(bytes)}
+	}
+	quota := contextQuota{Agent: limit("agent"), Session: limit("session"), OnExceeded: "evict"}
+	if mode, _ := raw["onExceeded"].(string); mode == "reject" {
+		quota.OnExceeded = mode
+	}
+	return quota
+}
+
+// contextStoreUsage tallies the entries of each agent in the store, at
+// <agent>/*.md, and of each of its sessions, at <agent>/<session>/*.md.
+func contextStoreUsage(store string) ([]*contextAgentUsage, error) {
+	byAgent := make(map[string]*contextAgentUsage)
+	err := filepath.Walk(store, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		rel, err := filepath.Rel(store, path)
+		if err != nil {
+			return nil
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) < 2 {
+			return nil
+		}
+		u := byAgent[parts[0]]
+		if u == nil {
+			u = &contextAgentUsage{Agent: parts[0]}
+			byAgent[parts[0]] = u
+		}
+		u.Entries++
+		u.Bytes += info.Size()
+		if len(parts) == 3 {
+			if u.Sessions == nil {
+				u.Sessions = make(map[string]contextUsage)
+			}
+			s := u.Sessions[parts[1]]
+			s.Entries++
+			s.Bytes += info.Size()
+			u.Sessions[parts[1]] = s
+		}
+		return nil
+	})
+	usage := make([]*contextAgentUsage, 0, len(byAgent))
+	for _, u := range byAgent {
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Agent < usage[j].Agent })
+	return usage, err
+}
+
+// largestSession returns the usage of the session with the most bytes.
+func (u *contextAgentUsage) largestSession() (string, contextUsage) {
+	var id string
+	var largest contextUsage
+	for s, su := range u.Sessions {
+		if id == "" || su.Bytes > largest.Bytes || (su.Bytes == largest.Bytes && s < id) {
+			id, largest = s, su
+		}
+	}
+	return id, largest
+}
+
+func runContextStats(cmd *cobra.Command, args []string) error {
+	config := loadSharedConfig()
+	store, err := resolveContextStore(config)
+	if err != nil {
+		return err
+	}
+	usage, err := contextStoreUsage(store)
+	if err != nil {
+		return fmt.Errorf("failed to read context store %s: %w", store, err)
+	}
+	stats := contextStats{Store: store, Quota: resolveContextQuota(config), Agents: usage}
+
+	if contextStatsJSON {
+		data, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("Context store: %s\n", store)
+	if len(usage) == 0 {
+		fmt.Println("No context entries")
+		return nil
+	}
+	printContextStats(os.Stdout, stats)
+	return nil
+}
+
+// printContextStats writes a per-agent usage table; each figure is shown
+// against its limit when the quota sets one.
+func printContextStats(out io.Writer, stats contextStats) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "AGENT\tENTRIES\tSIZE\tSESSIONS\tLARGEST SESSION\tSESSION ENTRIES\tSESSION SIZE")
+	for _, u := range stats.Agents {
+		session, su := u.largestSession()
+		sessionEntries, sessionSize := "-", "-"
+		if session == "" {
+			session = "-"
+		} else {
+			sessionEntries = usageOf(su.Entries, stats.Quota.Session.MaxEntries)
+			sessionSize = sizeOf(su.Bytes, stats.Quota.Session.MaxBytes)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			u.Agent, usageOf(u.Entries, stats.Quota.Agent.MaxEntries), sizeOf(u.Bytes, stats.Quota.Agent.MaxBytes),
+			len(u.Sessions), session, sessionEntries, sessionSize)
+	}
+	_ = w.Flush()
+}
+
+// usageOf renders a count, against its limit if there is one.
+func usageOf(n, limit int) string {
+	if limit <= 0 {
+		return fmt.Sprintf("%d", n)
+	}
+	return fmt.Sprintf("%d/%d", n, limit)
+}
+
+// sizeOf renders a byte count, against its limit if there is one.
+func sizeOf(n, limit int64) string {
+	if limit <= 0 {
+		return formatBytes(n)
+	}
+	return formatBytes(n) + "/" + formatBytes(limit)
+}
+
+// contextBundle mirrors the SDK's JSON export format.
+type contextBundle struct {
+	Version    int                  `json:"version"`
+	ExportedAt string               `json:"exportedAt"`
+	Agent      string               `json:"agent,omitempty"`
+	SessionID  string               `json:"sessionId,omitempty"`
+	Entries    []contextBundleEntry `json:"entries"`
+}
+
+type contextBundleEntry struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// collectContextExport returns the entries of agent and session ("" for
+// all), by path relative to the store.
+func collectContextExport(store, agent, session string) ([]contextBundleEntry, error) {
+	root := store
+	if agent != "" {
+		root = filepath.Join(store, agent)
+	}
+	entries := []contextBundleEntry{}
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".md") {
+			return nil
+		}
+		rel, err := filepath.Rel(store, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		parts := strings.Split(rel, "/")
+		if session != "" && (len(parts) != 3 || parts[1] != session) {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, contextBundleEntry{Path: rel, Content: string(data)})
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, err
+}
+
+// encodeContextExport writes entries in format, "json" or "tar".
+func encodeContextExport(w io.Writer, entries []contextBundleEntry, agent, session, format string, now time.Time) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(contextBundle{
+			Version:    1,
+			ExportedAt: now.UTC().Format(time.RFC3339),
+			Agent:      agent,
+			SessionID:  session,
+			Entries:    entries,
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	case "tar":
+		gz := gzip.NewWriter(w)
+		tw := tar.NewWriter(gz)
+		for _, e := range entries {
+			if err := tw.WriteHeader(&tar.Header{Name: e.Path, Mode: 0644, Size: int64(len(e.Content)), ModTime: now}); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(tw, e.Content); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+	return fmt.Errorf("unknown export format %q (use json or tar)", format)
+}
+
+func runContextExport(cmd *cobra.Command, args []string) error {
+	store, err := resolveContextStore(loadSharedConfig())
+	if err != nil {
+		return err
+	}
+	entries, err := collectContextExport(store, contextExportAgent, contextExportSess)
+	if err != nil {
+		return fmt.Errorf("failed to read context store %s: %w", store, err)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeContextExport(&buf, entries, contextExportAgent, contextExportSess, contextExportFormat, time.Now()); err != nil {
+		return err
+	}
+	if contextExportOutput == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if err := os.WriteFile(contextExportOutput, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d context entr%s to %s\n", len(entries), pluralY(len(entries)), contextExportOutput)
+	return nil
+}
+
+// decodeContextExport reads an export in either format, told apart by the
+// gzip magic number.
+func decodeContextExport(data []byte) ([]contextBundleEntry, error) {
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		var bundle contextBundle
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return nil, fmt.Errorf("invalid context export: %w", err)
+		}
+		if bundle.Version != 1 {
+			return nil, fmt.Errorf("unsupported context export version %d", bundle.Version)
+		}
+		return bundle.Entries, nil
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid context export: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var entries []contextBundleEntry
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid context export: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid context export: %w", err)
+		}
+		entries = append(entries, contextBundleEntry{Path: hdr.Name, Content: string(content)})
+	}
+}
+
+// validContextPath reports whether p is where an entry may live:
+// <agent>/<file>.md or <agent>/<session>/<file>.md, with nothing that
+// leaves the store.
+func validContextPath(p string) bool {
+	if p != path.Clean(p) || path.IsAbs(p) || !strings.HasSuffix(p, ".md") {
+		return false
+	}
+	parts := strings.Split(p, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.HasPrefix(part, ".") || strings.Contains(part, `\`) {
+			return false
+		}
+	}
+	return true
+}
+
+// importContext writes the entries of an export into the store, leaving
+// those whose path it already has, and returns how many it wrote and left.
+func importContext(store string, data []byte) (written, skipped int, err error) {
+	entries, err := decodeContextExport(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range entries {
+		if !validContextPath(e.Path) || !strings.HasPrefix(e.Content, "---\n") {
+			return 0, 0, fmt.Errorf("invalid context export: %q is not a context entry", e.Path)
+		}
+	}
+
+	for _, e := range entries {
+		dest := filepath.Join(store, filepath.FromSlash(e.Path))
+		if _, err := os.Stat(dest); err == nil {
+			skipped++
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return written, skipped, err
+		}
+		if err := os.WriteFile(dest, []byte(e.Content), 0644); err != nil {
+			return written, skipped, err
+		}
+		written++
+	}
+	if written > 0 {
+		staleContextIndex(store)
+	}
+	return written, skipped, nil
+}
+
+// staleContextIndex drops the entry tables of the store's SQLite index, so
+// the SDKs rebuild them from the files on their next search while keeping
+// the embeddings, or removes the index if it cannot.
+func staleContextIndex(store string) {
+	index := filepath.Join(store, ".index.db")
+	if _, err := os.Stat(index); err != nil {
+		return
+	}
+	runContextIndexSQL(index, "DROP TABLE IF EXISTS entries;\nDROP TABLE IF EXISTS entries_fts;\n")
+}
+
+// runContextIndexSQL runs a script against a store's SQLite index with the
+// sqlite3 command, the same index the SDKs share. Without sqlite3, or if
+// the script fails, it removes the index, which the SDKs rebuild from the
+// files on their next search.
+func runContextIndexSQL(index, script string) {
+	sqlite, err := exec.LookPath("sqlite3")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: sqlite3 not found; removing the context index %s, which is rebuilt on the next search\n", index)
+		os.Remove(index)
+		return
+	}
+	cmd := exec.Command(sqlite, "-bail", "-cmd", ".timeout 5000", index)
+	cmd.Stdin = strings.NewReader(script)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: cannot update the context index %s (%s); removing it, it is rebuilt on the next search\n", index, strings.TrimSpace(string(out)))
+		os.Remove(index)
+	}
+}
+
+func runContextImport(cmd *cobra.Command, args []string) error {
+	var data []byte
+	var err error
+	if args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+	store, err := resolveContextStore(loadSharedConfig())
+	if err != nil {
+		return err
+	}
+	written, skipped, err := importContext(store, data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d context entr%s into %s", written, pluralY(written), store)
+	if skipped > 0 {
+		fmt.Printf(" (%d already present)", skipped)
+	}
+	fmt.Println()
+	return nil
+}
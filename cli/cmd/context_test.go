@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContextStoreUsage(t *testing.T) {
+	store := t.TempDir()
+	write := func(rel, content string) {
+		t.Helper()
+		path := filepath.Join(store, rel)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("reviewer/a.md", "12345")
+	write("reviewer/s1/b.md", "123")
+	write("reviewer/s1/c.md", "1")
+	write("reviewer/s2/d.md", "1234567")
+	write("fixer/e.md", "12")
+	write(".index.db", "not an entry")
+
+	usage, err := contextStoreUsage(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 2 || usage[0].Agent != "fixer" || usage[1].Agent != "reviewer" {
+		t.Fatalf("unexpected agents: %+v", usage)
+	}
+	r := usage[1]
+	if r.Entries != 4 || r.Bytes != 16 || len(r.Sessions) != 2 {
+		t.Errorf("reviewer usage = %+v", r)
+	}
+	if s := r.Sessions["s1"]; s.Entries != 2 || s.Bytes != 4 {
+		t.Errorf("session s1 usage = %+v", s)
+	}
+	if id, s := r.largestSession(); id != "s2" || s.Bytes != 7 {
+		t.Errorf("largest session = %s %+v", id, s)
+	}
+
+	if usage, err := contextStoreUsage(filepath.Join(store, "missing")); err != nil || len(usage) != 0 {
+		t.Errorf("missing store: %v, %v", usage, err)
+	}
+}
+
+func TestResolveContextQuota(t *testing.T) {
+	config := map[string]any{"contextStore": map[string]any{"quota": map[string]any{
+		"agent":      map[string]any{"maxEntries": float64(100), "maxBytes": float64(2048)},
+		"onExceeded": "reject",
+	}}}
+	q := resolveContextQuota(config)
+	if q.Agent.MaxEntries != 100 || q.Agent.MaxBytes != 2048 || q.Session.MaxEntries != 0 || q.OnExceeded != "reject" {
+		t.Errorf("resolveContextQuota() = %+v", q)
+	}
+	if q := resolveContextQuota(map[string]any{}); q.OnExceeded != "evict" {
+		t.Errorf("default onExceeded = %q, want evict", q.OnExceeded)
+	}
+}
+
+func TestPrintContextStats(t *testing.T) {
+	stats := contextStats{
+		Quota: contextQuota{Agent: contextLimit{MaxEntries: 10}, Session: contextLimit{MaxBytes: 2048}},
+		Agents: []*contextAgentUsage{
+			{Agent: "fixer", contextUsage: contextUsage{Entries: 1, Bytes: 10}},
+			{Agent: "reviewer", contextUsage: contextUsage{Entries: 3, Bytes: 1536}, Sessions: map[string]contextUsage{"s1": {Entries: 2, Bytes: 1024}}},
+		},
+	}
+	var buf bytes.Buffer
+	printContextStats(&buf, stats)
+	out := buf.String()
+	for _, want := range []string{"1/10", "3/10", "1.5 KiB", "s1", "1.0 KiB/2.0 KiB"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestContextExportImport(t *testing.T) {
+	src := t.TempDir()
+	entry := "---\nagent: reviewer\ntype: finding\n---\n\nbody\n"
+	for _, rel := range []string{"reviewer/s1/a.md", "reviewer/s2/b.md", "fixer/s1/c.md"} {
+		path := filepath.Join(src, rel)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(entry), 0644)
+	}
+
+	entries, err := collectContextExport(src, "", "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Path != "fixer/s1/c.md" || entries[1].Path != "reviewer/s1/a.md" {
+		t.Fatalf("session export = %+v", entries)
+	}
+
+	for _, format := range []string{"json", "tar"} {
+		var buf bytes.Buffer
+		if err := encodeContextExport(&buf, entries, "", "s1", format, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		dst := t.TempDir()
+		written, skipped, err := importContext(dst, buf.Bytes())
+		if err != nil || written != 2 || skipped != 0 {
+			t.Fatalf("%s import: %d written, %d skipped, %v", format, written, skipped, err)
+		}
+		if data, _ := os.ReadFile(filepath.Join(dst, "reviewer", "s1", "a.md")); string(data) != entry {
+			t.Errorf("%s import wrote %q", format, data)
+		}
+		if written, skipped, _ := importContext(dst, buf.Bytes()); written != 0 || skipped != 2 {
+			t.Errorf("%s reimport: %d written, %d skipped", format, written, skipped)
+		}
+	}
+
+	if err := encodeContextExport(io.Discard, entries, "", "", "zip", time.Now()); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestValidContextPath(t *testing.T) {
+	for p, want := range map[string]bool{
+		"reviewer/a.md":    true,
+		"reviewer/s1/a.md": true,
+		"../a.md":          false,
+		"reviewer/../a.md": false,
+		"/reviewer/a.md":   false,
+		".index.db":        false,
+		"reviewer/.a.md":   false,
+		"a/b/c/d.md":       false,
+		"reviewer/a.txt":   false,
+	} {
+		if got := validContextPath(p); got != want {
+			t.Errorf("validContextPath(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Entries written with contextStore.encrypt have their body sealed by the
+// SDK: "sfa-enc:v1:" and base64 of a nonce and AES-256-GCM ciphertext, under
+// the key in SFA_CONTEXT_KEY or the keychain item "context-key".
+
+const (
+	contextSealPrefix = "sfa-enc:v1:"
+	contextKeyAccount = "context-key"
+)
+
+// loadContextKey returns the context store encryption key.
+func loadContextKey() ([]byte, error) {
+	encoded := os.Getenv("SFA_CONTEXT_KEY")
+	if encoded == "" {
+		var err error
+		encoded, err = keyring.get(keyringService, contextKeyAccount)
+		if errors.Is(err, errKeyringNotFound) {
+			return nil, errors.New("the entry is encrypted, but the context key is not set (SFA_CONTEXT_KEY or the keychain)")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("keyring lookup for the context key failed: %w", err)
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("the context key is not a base64 256-bit key")
+	}
+	return key, nil
+}
+
+// openContextEntry returns an entry file with its body decrypted, or as it
+// is if the body is not encrypted.
+func openContextEntry(data []byte) ([]byte, error) {
+	lines := strings.Split(string(data), "\n")
+	fences := 0
+	for i, line := range lines {
+		if line == "---" && fences < 2 {
+			fences++
+			continue
+		}
+		sealed, ok := strings.CutPrefix(strings.TrimSpace(line), contextSealPrefix)
+		if fences < 2 || !ok {
+			continue
+		}
+		key, err := loadContextKey()
+		if err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := base64.StdEncoding.DecodeString(sealed)
+		if err != nil || len(raw) < aead.NonceSize() {
+			return nil, errors.New("malformed encrypted entry")
+		}
+		plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+		if err != nil {
+			return nil, errors.New("cannot decrypt entry: encrypted with a different context key")
+		}
+		lines[i] = string(plain)
+		break
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestOpenContextEntry(t *testing.T) {
+	fake := useFakeKeyring(t)
+	t.Setenv("SFA_CONTEXT_KEY", "")
+	key := []byte(strings.Repeat("k", 32))
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aead.NonceSize())
+	sealed := contextSealPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte("Token leaks\n\ninto logs"), nil))
+	entry := "---\nagent: reviewer\ntype: finding\n---\n\n" + sealed + "\n\n## Changelog\n\n- 2026-02-21T14:30:22Z [fixer]: Appended to the content\n"
+
+	if _, err := openContextEntry([]byte(entry)); err == nil || !strings.Contains(err.Error(), "not set") {
+		t.Errorf("without a key: %v", err)
+	}
+	fake.items[keyringService+"|"+contextKeyAccount] = base64.StdEncoding.EncodeToString(key)
+	got, err := openContextEntry([]byte(entry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "---\nagent: reviewer\ntype: finding\n---\n\nToken leaks\n\ninto logs\n\n## Changelog\n\n- 2026-02-21T14:30:22Z [fixer]: Appended to the content\n"
+	if string(got) != want {
+		t.Errorf("openContextEntry() =\n%s\nwant\n%s", got, want)
+	}
+
+	t.Setenv("SFA_CONTEXT_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if _, err := openContextEntry([]byte(entry)); err == nil || !strings.Contains(err.Error(), "different context key") {
+		t.Errorf("with another key: %v", err)
+	}
+	plain := "---\ntype: note\n---\n\nPlain\n"
+	if got, err := openContextEntry([]byte(plain)); err != nil || string(got) != plain {
+		t.Errorf("plain entry = %q, %v", got, err)
+	}
+}
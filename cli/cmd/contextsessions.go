@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// contextSessionEntry is an entry of a session, at
+// <agent>/<session>/<file>.md.
+type contextSessionEntry struct {
+	Path      string `json:"path"`
+	Agent     string `json:"agent"`
+	Type      string `json:"type"`
+	Timestamp string `json:"timestamp"`
+}
+
+// contextSession mirrors the SDK's ListSessions: a session with entries in
+// the store.
+type contextSession struct {
+	ID      string   `json:"id"`
+	Entries int      `json:"entries"`
+	First   string   `json:"first"`
+	Last    string   `json:"last"`
+	Agents  []string `json:"agents"`
+}
+
+// contextFields returns the scalar values of keys in the frontmatter of a
+// context entry.
+func contextFields(file string, keys ...string) map[string]string {
+	fields := make(map[string]string)
+	f, err := os.Open(file)
+	if err != nil {
+		return fields
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || scanner.Text() != "---" {
+		return fields
+	}
+	for scanner.Scan() && scanner.Text() != "---" {
+		key, val, ok := strings.Cut(scanner.Text(), ":")
+		for _, k := range keys {
+			if ok && key == k {
+				fields[k] = strings.Trim(strings.TrimSpace(val), `"'`)
+			}
+		}
+	}
+	return fields
+}
+
+// contextSessionEntries returns the entries of every session in the store,
+// or of one session or agent when given, oldest first.
+func contextSessionEntries(store, session, agent string) ([]contextSessionEntry, error) {
+	var entries []contextSessionEntry
+	err := filepath.Walk(store, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		rel, err := filepath.Rel(store, path)
+		if err != nil {
+			return nil
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) != 3 || (session != "" && parts[1] != session) || (agent != "" && parts[0] != agent) {
+			return nil
+		}
+		fields := contextFields(path, "type", "timestamp")
+		entries = append(entries, contextSessionEntry{
+			Path:      filepath.ToSlash(rel),
+			Agent:     parts[0],
+			Type:      fields["type"],
+			Timestamp: fields["timestamp"],
+		})
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Timestamp != entries[j].Timestamp {
+			return entries[i].Timestamp < entries[j].Timestamp
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, err
+}
+
+// contextSessions groups entries by session, the most recently active
+// first.
+func contextSessions(entries []contextSessionEntry) []contextSession {
+	byID := make(map[string]*contextSession)
+	for _, e := range entries {
+		id := strings.Split(e.Path, "/")[1]
+		s := byID[id]
+		if s == nil {
+			s = &contextSession{ID: id, First: e.Timestamp}
+			byID[id] = s
+		}
+		// Entries are oldest first
+		s.Entries++
+		s.Last = e.Timestamp
+		if !slices.Contains(s.Agents, e.Agent) {
+			s.Agents = append(s.Agents, e.Agent)
+		}
+	}
+	sessions := make([]contextSession, 0, len(byID))
+	for _, s := range byID {
+		sort.Strings(s.Agents)
+		sessions = append(sessions, *s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Last != sessions[j].Last {
+			return sessions[i].Last > sessions[j].Last
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions
+}
+
+// printContextSessions writes a table of sessions.
+func printContextSessions(out io.Writer, sessions []contextSession) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SESSION\tENTRIES\tFIRST\tLAST\tAGENTS")
+	for _, s := range sessions {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", s.ID, s.Entries, s.First, s.Last, strings.Join(s.Agents, ", "))
+	}
+	_ = w.Flush()
+}
+
+// printContextSessionEntries writes a table of a session's entries.
+func printContextSessionEntries(out io.Writer, entries []contextSessionEntry) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "TIMESTAMP\tAGENT\tTYPE\tPATH")
+	for _, e := range entries {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Timestamp, e.Agent, e.Type, e.Path)
+	}
+	_ = w.Flush()
+}
+
+func runContextSessions(cmd *cobra.Command, args []string) error {
+	store, err := resolveContextStore(loadSharedConfig())
+	if err != nil {
+		return err
+	}
+	session := ""
+	if len(args) == 1 {
+		session = args[0]
+	}
+	entries, err := contextSessionEntries(store, session, contextSessionsAgent)
+	if err != nil {
+		return fmt.Errorf("failed to read context store %s: %w", store, err)
+	}
+
+	if session != "" {
+		if len(entries) == 0 {
+			return fmt.Errorf("no context entries in session %s", session)
+		}
+		if contextSessionsJSON {
+			data, _ := json.MarshalIndent(entries, "", "  ")
+			fmt.Println(string(data))
+			return nil
+		}
+		printContextSessionEntries(os.Stdout, entries)
+		return nil
+	}
+
+	sessions := contextSessions(entries)
+	if contextSessionsJSON {
+		data, _ := json.MarshalIndent(sessions, "", "  ")
+		fmt.Println(string(data))
+		return nil
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No sessions")
+		return nil
+	}
+	printContextSessions(os.Stdout, sessions)
+	return nil
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContextSessions(t *testing.T) {
+	store := t.TempDir()
+	write := func(rel, timestamp string) {
+		t.Helper()
+		p := filepath.Join(store, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		data := "---\nagent: x\ntimestamp: " + timestamp + "\ntype: finding\n---\n\nbody\n"
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("reviewer/s1/a.md", "2026-02-21T14:00:00Z")
+	write("fixer/s1/b.md", "2026-02-21T15:00:00Z")
+	write("reviewer/s1/c.md", "2026-02-21T14:30:00Z")
+	write("reviewer/s2/d.md", "2026-02-21T16:00:00Z")
+	write("reviewer/outside.md", "2026-02-21T17:00:00Z")
+
+	entries, err := contextSessionEntries(store, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := contextSessions(entries)
+	if len(sessions) != 2 || sessions[0].ID != "s2" {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+	s1 := sessions[1]
+	if s1.Entries != 3 || s1.First != "2026-02-21T14:00:00Z" || s1.Last != "2026-02-21T15:00:00Z" || strings.Join(s1.Agents, ",") != "fixer,reviewer" {
+		t.Errorf("s1 = %+v", s1)
+	}
+
+	var buf bytes.Buffer
+	printContextSessions(&buf, sessions)
+	if !strings.Contains(buf.String(), "s1       3        2026-02-21T14:00:00Z  2026-02-21T15:00:00Z  fixer, reviewer") {
+		t.Errorf("table =\n%s", buf.String())
+	}
+
+	entries, err = contextSessionEntries(store, "s1", "reviewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Path != "reviewer/s1/a.md" || entries[1].Path != "reviewer/s1/c.md" || entries[0].Type != "finding" {
+		t.Errorf("entries of s1 = %+v", entries)
+	}
+}
@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// contextNode is an entry of the link graph, mirroring the SDK's
+// RelatedContext.
+type contextNode struct {
+	Path  string
+	Type  string
+	Links []string // normalized, without the entry itself or repeats
+	Depth int
+}
+
+// contextGraph is an entry and those within some number of links of it.
+type contextGraph struct {
+	Root      string
+	Nodes     map[string]*contextNode
+	Missing   map[string]bool     // linked paths the store has no entry at
+	backlinks map[string][]string // the paths linking to each path
+	parent    map[string]string   // the node each node was reached from
+}
+
+// contextEntryPath returns the path relative to the store root of the
+// entry at p, a relative path as links name it or a file in the store, or
+// of the entry with the ID p, its file name without ".md".
+func contextEntryPath(store, p string) (string, error) {
+	if p != "" && !strings.ContainsAny(p, `/\*?[`) && !strings.HasPrefix(p, ".") && !strings.HasSuffix(p, ".md") {
+		var found []string
+		for _, pattern := range []string{"*", filepath.Join("*", "*")} {
+			matches, _ := filepath.Glob(filepath.Join(store, pattern, p+".md"))
+			for _, m := range matches {
+				if rel, err := filepath.Rel(store, m); err == nil && validContextPath(filepath.ToSlash(rel)) {
+					found = append(found, filepath.ToSlash(rel))
+				}
+			}
+		}
+		switch len(found) {
+		case 0:
+			return "", fmt.Errorf("context entry not found: %s", p)
+		case 1:
+			return found[0], nil
+		}
+		return "", fmt.Errorf("context entry ID %s is ambiguous: it is each of %s", p, strings.Join(found, ", "))
+	}
+
+	rel := filepath.ToSlash(filepath.Clean(p))
+	if filepath.IsAbs(p) || !validContextPath(rel) {
+		root, err := filepath.Abs(store)
+		if err != nil {
+			return "", err
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return "", err
+		}
+		if rel, err = filepath.Rel(root, abs); err != nil {
+			return "", fmt.Errorf("%s is not an entry of the context store", p)
+		}
+	}
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	if !validContextPath(rel) {
+		return "", fmt.Errorf("%s is not an entry of the context store", p)
+	}
+	return rel, nil
+}
+
+// contextLinkPath normalizes a link as an entry records it, possibly
+// quoted or with backslashes.
+func contextLinkPath(link string) string {
+	link = strings.Trim(strings.TrimSpace(link), `"'`)
+	return path.Clean(strings.ReplaceAll(link, `\`, "/"))
+}
+
+// contextLinks returns the type and links in the frontmatter of a context
+// entry, as a list or inline.
+func contextLinks(file string) (typ string, links []string) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", nil
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || scanner.Text() != "---" {
+		return "", nil
+	}
+	inLinks := false
+	for scanner.Scan() && scanner.Text() != "---" {
+		line := scanner.Text()
+		if item, ok := strings.CutPrefix(strings.TrimSpace(line), "- "); ok && strings.HasPrefix(line, " ") {
+			if inLinks {
+				links = append(links, item)
+			}
+			continue
+		}
+		key, val, _ := strings.Cut(line, ":")
+		val = strings.TrimSpace(val)
+		inLinks = key == "links"
+		switch key {
+		case "type":
+			typ = val
+		case "links":
+			if inner, ok := strings.CutPrefix(val, "["); ok {
+				for _, l := range strings.Split(strings.TrimSuffix(inner, "]"), ",") {
+					if l = strings.TrimSpace(l); l != "" {
+						links = append(links, l)
+					}
+				}
+			}
+		}
+	}
+	return typ, links
+}
+
+// buildContextGraph reads the entries of store and returns the graph of
+// those within depth links of root, following links both ways; depth < 0
+// follows every link.
+func buildContextGraph(store, root string, depth int) (*contextGraph, error) {
+	entries := make(map[string]*contextNode)
+	err := filepath.Walk(store, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".md") {
+			return nil
+		}
+		rel, err := filepath.Rel(store, p)
+		if err != nil || !validContextPath(filepath.ToSlash(rel)) {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		typ, links := contextLinks(p)
+		n := &contextNode{Path: rel, Type: typ}
+		for _, l := range links {
+			if to := contextLinkPath(l); to != rel && !slices.Contains(n.Links, to) {
+				n.Links = append(n.Links, to)
+			}
+		}
+		entries[rel] = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if entries[root] == nil {
+		return nil, fmt.Errorf("context entry not found: %s", root)
+	}
+
+	g := &contextGraph{
+		Root:      root,
+		Nodes:     map[string]*contextNode{root: entries[root]},
+		Missing:   make(map[string]bool),
+		backlinks: make(map[string][]string),
+		parent:    make(map[string]string),
+	}
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, from := range paths {
+		for _, to := range entries[from].Links {
+			g.backlinks[to] = append(g.backlinks[to], from)
+		}
+	}
+
+	queue := []string{root}
+	for len(queue) > 0 {
+		cur := g.Nodes[queue[0]]
+		queue = queue[1:]
+		for _, l := range cur.Links {
+			if entries[l] == nil {
+				g.Missing[l] = true
+			}
+		}
+		if depth >= 0 && cur.Depth >= depth {
+			continue
+		}
+		for _, next := range append(slices.Clone(cur.Links), g.backlinks[cur.Path]...) {
+			n := entries[next]
+			if n == nil || g.Nodes[next] != nil {
+				continue
+			}
+			n.Depth = cur.Depth + 1
+			g.Nodes[next] = n
+			g.parent[next] = cur.Path
+			queue = append(queue, next)
+		}
+	}
+	return g, nil
+}
+
+// renderContextGraph draws the graph as a tree from its root, two spaces
+// deeper per link: → for a link from the entry above, ← for a link to it.
+// An entry reached more than one way is drawn in full once.
+func renderContextGraph(w io.Writer, g *contextGraph) {
+	root := g.Nodes[g.Root]
+	fmt.Fprintf(w, "%s  %s\n", root.Path, root.Type)
+	drawn := map[string]bool{root.Path: true}
+	var draw func(n *contextNode, indent int)
+	draw = func(n *contextNode, indent int) {
+		line := func(arrow, p string) {
+			if p == g.parent[n.Path] {
+				return // the link n was reached by
+			}
+			prefix := strings.Repeat("  ", indent) + arrow + " " + p
+			switch other := g.Nodes[p]; {
+			case g.Missing[p] && other == nil:
+				fmt.Fprintf(w, "%s  (missing)\n", prefix)
+			case other == nil:
+				// Beyond the depth
+			case g.parent[p] == n.Path && !drawn[p]:
+				drawn[p] = true
+				fmt.Fprintf(w, "%s  %s\n", prefix, other.Type)
+				draw(other, indent+1)
+			default:
+				fmt.Fprintf(w, "%s  %s  (repeated)\n", prefix, other.Type)
+			}
+		}
+		for _, l := range n.Links {
+			line("→", l)
+		}
+		back := g.backlinks[n.Path]
+		sort.Strings(back)
+		for _, b := range back {
+			line("←", b)
+		}
+	}
+	draw(root, 1)
+}
+
+func runContextShow(cmd *cobra.Command, args []string) error {
+	store, err := resolveContextStore(loadSharedConfig())
+	if err != nil {
+		return err
+	}
+	rel, err := contextEntryPath(store, args[0])
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(store, filepath.FromSlash(rel)))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("context entry not found: %s", rel)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !contextShowGraph {
+		if data, err = openContextEntry(data); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	g, err := buildContextGraph(store, rel, contextShowDepth)
+	if err != nil {
+		return fmt.Errorf("failed to read context store %s: %w", store, err)
+	}
+	renderContextGraph(os.Stdout, g)
+	return nil
+}
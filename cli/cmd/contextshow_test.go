@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContextGraph(t *testing.T) {
+	store := t.TempDir()
+	write := func(rel, frontmatter string) {
+		t.Helper()
+		p := filepath.Join(store, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte("---\nagent: x\ntype: finding\n"+frontmatter+"---\n\nbody\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Links as the Go SDK writes them, and as the TypeScript SDK does
+	write("reviewer/spec.md", "")
+	write("reviewer/finding.md", "links:\n  - reviewer/spec.md\n  - reviewer/gone.md\n")
+	write("fixer/s1/fix.md", "tags: []\nlinks:\n  - \"reviewer/finding.md\"\n")
+	write("summarizer/summary.md", "links: [fixer/s1/fix.md, reviewer/spec.md]\n")
+	write("other/unrelated.md", "")
+
+	g, err := buildContextGraph(store, "reviewer/finding.md", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Nodes) != 3 || g.Nodes["fixer/s1/fix.md"] == nil || g.Nodes["reviewer/spec.md"].Depth != 1 || !g.Missing["reviewer/gone.md"] {
+		t.Errorf("graph at depth 1 = %+v", g)
+	}
+
+	g, err = buildContextGraph(store, "reviewer/finding.md", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	renderContextGraph(&buf, g)
+	want := `reviewer/finding.md  finding
+  → reviewer/spec.md  finding
+    ← summarizer/summary.md  finding
+      → fixer/s1/fix.md  finding  (repeated)
+  → reviewer/gone.md  (missing)
+  ← fixer/s1/fix.md  finding
+    ← summarizer/summary.md  finding  (repeated)
+`
+	if buf.String() != want {
+		t.Errorf("renderContextGraph() =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	if _, err := buildContextGraph(store, "reviewer/gone.md", 1); err == nil {
+		t.Error("expected an error for a missing entry")
+	}
+}
+
+func TestContextEntryPath(t *testing.T) {
+	store := t.TempDir()
+	if got, err := contextEntryPath(store, filepath.Join(store, "reviewer", "a.md")); err != nil || got != "reviewer/a.md" {
+		t.Errorf("absolute path = %q, %v", got, err)
+	}
+	if got, err := contextEntryPath(store, "reviewer/s1/a.md"); err != nil || got != "reviewer/s1/a.md" {
+		t.Errorf("relative path = %q, %v", got, err)
+	}
+	os.MkdirAll(filepath.Join(store, "reviewer", "s1"), 0755)
+	os.WriteFile(filepath.Join(store, "reviewer", "s1", "20260221T143022-auth.md"), nil, 0644)
+	if got, err := contextEntryPath(store, "20260221T143022-auth"); err != nil || got != "reviewer/s1/20260221T143022-auth.md" {
+		t.Errorf("ID = %q, %v", got, err)
+	}
+	os.WriteFile(filepath.Join(store, "reviewer", "20260221T143022-auth.md"), nil, 0644)
+	if _, err := contextEntryPath(store, "20260221T143022-auth"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("expected an ambiguous ID, got %v", err)
+	}
+	for _, p := range []string{filepath.Join(t.TempDir(), "reviewer", "a.md"), "../reviewer/a.md", "a.md", "20260101T000000-none"} {
+		if _, err := contextEntryPath(store, p); err == nil {
+			t.Errorf("expected %q to be refused", p)
+		}
+	}
+}
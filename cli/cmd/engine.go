@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Container engines services can run on.
+const (
+	engineDocker = "docker"
+	enginePodman = "podman"
+)
+
+// containerEngine runs service containers: the engine's own CLI for
+// networks and containers, and its compose command for projects. It mirrors
+// the SDK's engine.go; keep the two in sync.
+type containerEngine struct {
+	name    string   // engineDocker or enginePodman, also its binary
+	compose []string // compose command, e.g. docker compose or podman-compose
+	env     []string // KEY=value pointing the engine at a configured daemon
+}
+
+// command returns an engine CLI command, e.g. docker network ls.
+func (e containerEngine) command(args ...string) *exec.Cmd {
+	return e.withEnv(exec.Command(e.name, args...))
+}
+
+// composeCommand returns a compose command for the project in composePath.
+func (e containerEngine) composeCommand(composePath string, args ...string) *exec.Cmd {
+	argv := append(append(append([]string(nil), e.compose[1:]...), "-f", composePath), args...)
+	return e.withEnv(exec.Command(e.compose[0], argv...))
+}
+
+func (e containerEngine) withEnv(cmd *exec.Cmd) *exec.Cmd {
+	if len(e.env) > 0 {
+		cmd.Env = append(os.Environ(), e.env...)
+	}
+	return cmd
+}
+
+// engineCandidates lists each engine's compose commands in preference order.
+var engineCandidates = map[string][][]string{
+	engineDocker: {{"docker", "compose"}},
+	enginePodman: {{"podman", "compose"}, {"podman-compose"}},
+}
+
+// engineRemoteVars names the variables that point each engine at a daemon
+// address and at a named context (Podman calls them connections).
+var engineRemoteVars = map[string]struct{ host, context string }{
+	engineDocker: {"DOCKER_HOST", "DOCKER_CONTEXT"},
+	enginePodman: {"CONTAINER_HOST", "CONTAINER_CONNECTION"},
+}
+
+// commandWorks reports whether argv runs successfully with env added to the
+// environment; a variable so tests can stand in for installed engines.
+var commandWorks = func(env []string, argv ...string) bool {
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return false
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd.Run() == nil
+}
+
+// containerEngineSetting returns the engine to use and where it was chosen:
+// SFA_CONTAINER_ENGINE > config services.engine > "" to detect one.
+func containerEngineSetting(config map[string]any) (string, string) {
+	if name := os.Getenv("SFA_CONTAINER_ENGINE"); name != "" {
+		return name, "SFA_CONTAINER_ENGINE"
+	}
+	if services, ok := config["services"].(map[string]any); ok {
+		if name, ok := services["engine"].(string); ok && name != "" {
+			return name, "services.engine"
+		}
+	}
+	return "", ""
+}
+
+// engineRemoteSetting returns the daemon address or context agentName's
+// services run on: services.host or services.context from the agent's
+// namespace, then defaults, then the top-level services section. Both are
+// "" to leave the engine's own environment and current context alone.
+func engineRemoteSetting(config map[string]any, agentName string) (host, context string, err error) {
+	var sections []any
+	if agents, ok := config["agents"].(map[string]any); ok && agentName != "" {
+		if ns, ok := agents[agentName].(map[string]any); ok {
+			sections = append(sections, ns["services"])
+		}
+	}
+	if defaults, ok := config["defaults"].(map[string]any); ok {
+		sections = append(sections, defaults["services"])
+	}
+	sections = append(sections, config["services"])
+
+	for _, section := range sections {
+		s, _ := section.(map[string]any)
+		host, _ = s["host"].(string)
+		context, _ = s["context"].(string)
+		switch {
+		case host != "" && context != "":
+			return "", "", fmt.Errorf("services.host and services.context are both set; use one")
+		case host != "" || context != "":
+			return host, context, nil
+		}
+	}
+	return "", "", nil
+}
+
+// remoteEngineEnv returns the variables pointing engine at host or context.
+// The other variable is cleared so an inherited one cannot override it.
+func remoteEngineEnv(engine, host, context string) []string {
+	if host == "" && context == "" {
+		return nil
+	}
+	vars := engineRemoteVars[engine]
+	return []string{vars.host + "=" + host, vars.context + "=" + context}
+}
+
+// resolveContainerEngine returns the configured engine, or with none
+// configured, Docker if it is available and Podman otherwise, pointed at
+// agentName's configured daemon or context ("" for the shared one).
+func resolveContainerEngine(config map[string]any, agentName string) (containerEngine, error) {
+	name, source := containerEngineSetting(config)
+	candidates := []string{engineDocker, enginePodman}
+	if name != "" {
+		if _, ok := engineCandidates[name]; !ok {
+			return containerEngine{}, fmt.Errorf("%s: unknown container engine %q (use %s or %s)", source, name, engineDocker, enginePodman)
+		}
+		candidates = []string{name}
+	}
+	host, context, err := engineRemoteSetting(config, agentName)
+	if err != nil {
+		return containerEngine{}, err
+	}
+
+	withoutCompose := ""
+	for _, engine := range candidates {
+		env := remoteEngineEnv(engine, host, context)
+		if !commandWorks(env, engine, "version") {
+			continue
+		}
+		for _, compose := range engineCandidates[engine] {
+			if commandWorks(env, append(append([]string(nil), compose...), "version")...) {
+				return containerEngine{name: engine, compose: compose, env: env}, nil
+			}
+		}
+		if withoutCompose == "" {
+			withoutCompose = engine
+		}
+	}
+
+	switch {
+	case withoutCompose != "":
+		return containerEngine{}, fmt.Errorf("%s Compose is not available. Install it to manage SFA services", engineTitle(withoutCompose))
+	case host != "":
+		return containerEngine{}, fmt.Errorf("cannot reach the container engine at %s. Check that it is running and reachable from here", host)
+	case context != "":
+		return containerEngine{}, fmt.Errorf("cannot reach the container engine for context %s. Check that the context exists and its daemon is reachable", context)
+	case name != "":
+		return containerEngine{}, fmt.Errorf("%s is not installed or not running. Install it, or unset %s, to manage SFA services", engineTitle(name), source)
+	default:
+		return containerEngine{}, fmt.Errorf("no container engine found. Install Docker or Podman to manage SFA services")
+	}
+}
+
+// engineTitle returns the engine's display name.
+func engineTitle(name string) string {
+	if name == enginePodman {
+		return "Podman"
+	}
+	return "Docker"
+}
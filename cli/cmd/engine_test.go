@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveContainerEngine(t *testing.T) {
+	orig := commandWorks
+	t.Cleanup(func() { commandWorks = orig })
+	installed := map[string]bool{"podman version": true, "podman-compose version": true}
+	commandWorks = func(env []string, argv ...string) bool { return installed[strings.Join(argv, " ")] }
+
+	t.Setenv("SFA_CONTAINER_ENGINE", "")
+	engine, err := resolveContainerEngine(nil, "")
+	if err != nil || engine.name != enginePodman {
+		t.Fatalf("expected a fallback to podman, got %+v, %v", engine, err)
+	}
+	if got := strings.Join(engine.composeCommand("/x/compose.yaml", "down", "-v").Args, " "); got != "podman-compose -f /x/compose.yaml down -v" {
+		t.Errorf("compose command = %s", got)
+	}
+
+	if _, err := resolveContainerEngine(map[string]any{"services": map[string]any{"engine": "docker"}}, ""); err == nil {
+		t.Error("expected configured docker to fail when it is not installed")
+	}
+
+	config := map[string]any{
+		"services": map[string]any{"host": "tcp://10.0.0.5:2376"},
+		"agents":   map[string]any{"reporter": map[string]any{"services": map[string]any{"context": "devbox"}}},
+	}
+	if engine, _ := resolveContainerEngine(config, "reporter"); strings.Join(engine.env, " ") != "CONTAINER_HOST= CONTAINER_CONNECTION=devbox" {
+		t.Errorf("expected the agent's podman connection, got %+v", engine)
+	}
+	if engine, _ := resolveContainerEngine(config, ""); strings.Join(engine.env, " ") != "CONTAINER_HOST=tcp://10.0.0.5:2376 CONTAINER_CONNECTION=" {
+		t.Errorf("expected the shared host, got %+v", engine)
+	}
+}
@@ -0,0 +1,366 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	gcDryRun           bool
+	gcSessionRetention string
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove expired SFA data",
+	Long: `Remove expired result cache entries written by cacheable agents, and context
+store entries past the retention contextStore.retention sets for their type,
+and session manifests older than --session-retention.`,
+	RunE: runGC,
+}
+
+func init() {
+	gcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "Report what would be removed without deleting")
+	gcCmd.Flags().StringVar(&gcSessionRetention, "session-retention", "30d", "Keep session manifests this long (forever, 30d, or 12h)")
+}
+
+// gcResult tallies what a collection pass removed.
+type gcResult struct {
+	Files int
+	Bytes int64
+}
+
+func runGC(cmd *cobra.Command, args []string) error {
+	sessionRetention, err := parseRetention(gcSessionRetention)
+	if err != nil {
+		return fmt.Errorf("--session-retention: %w", err)
+	}
+	cacheDir, err := dataDir("cache")
+	if err != nil {
+		return err
+	}
+
+	res, err := gcCache(cacheDir, time.Now(), gcDryRun)
+	if err != nil {
+		return err
+	}
+
+	config := loadSharedConfig()
+	store, err := resolveContextStore(config)
+	if err != nil {
+		return err
+	}
+	ctxRes, err := gcContext(store, contextRetention(config), time.Now(), gcDryRun)
+	if err != nil {
+		return err
+	}
+
+	sessDir, err := sessionsDir()
+	if err != nil {
+		return err
+	}
+	sessRes, err := gcSessions(sessDir, sessionRetention, time.Now(), gcDryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "Removed"
+	if gcDryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d expired cache entr%s (%s)\n", verb, res.Files, pluralY(res.Files), formatBytes(res.Bytes))
+	fmt.Printf("%s %d expired context entr%s (%s)\n", verb, ctxRes.Files, pluralY(ctxRes.Files), formatBytes(ctxRes.Bytes))
+	fmt.Printf("%s %d expired session%s (%s)\n", verb, sessRes.Files, pluralS(sessRes.Files), formatBytes(sessRes.Bytes))
+	return nil
+}
+
+// gcCache deletes cache entries whose expiresAt is before now, along with
+// entries that can no longer be parsed.
+func gcCache(dir string, now time.Time, dryRun bool) (gcResult, error) {
+	var res gcResult
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+
+		if !cacheEntryExpired(path, now) {
+			return nil
+		}
+		res.Files++
+		res.Bytes += info.Size()
+		if !dryRun {
+			os.Remove(path)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// cacheEntryExpired reports whether a cache file is past its expiry or unreadable.
+func cacheEntryExpired(path string, now time.Time) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return true
+	}
+	var rec struct {
+		ExpiresAt string `json:"expiresAt"`
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return true
+	}
+	expires, err := time.Parse(time.RFC3339, rec.ExpiresAt)
+	if err != nil {
+		return true
+	}
+	return now.After(expires)
+}
+
+// gcSessions deletes session manifests whose session ended, or for a
+// session that never closed was last updated, longer than retention ago,
+// with their lock files. Lock files without a manifest are removed too.
+// A retention of 0 keeps every session.
+func gcSessions(dir string, retention time.Duration, now time.Time, dryRun bool) (gcResult, error) {
+	var res gcResult
+	if retention == 0 {
+		return res, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return res, err
+	}
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if lock, ok := strings.CutSuffix(path, ".json.lock"); ok {
+			if _, err := os.Stat(lock + ".json"); os.IsNotExist(err) && !dryRun {
+				os.Remove(path)
+			}
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(path, ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		last := info.ModTime()
+		var m sessionManifest
+		if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &m) == nil {
+			if ended, err := time.Parse(time.RFC3339, m.EndedAt); err == nil {
+				last = ended
+			}
+		}
+		if now.Sub(last) <= retention {
+			continue
+		}
+		res.Files++
+		res.Bytes += info.Size()
+		if !dryRun {
+			os.Remove(path)
+			os.Remove(path + ".lock")
+		}
+	}
+	return res, nil
+}
+
+// resolveContextStore returns the context store directory, as the SDKs
+// resolve it: SFA_CONTEXT_STORE, then contextStore.path, then the default.
+func resolveContextStore(config map[string]any) (string, error) {
+	if p := os.Getenv("SFA_CONTEXT_STORE"); p != "" {
+		return p, nil
+	}
+	if cs, ok := config["contextStore"].(map[string]any); ok {
+		if p, ok := cs["path"].(string); ok && p != "" {
+			return p, nil
+		}
+	}
+	return dataDir("context")
+}
+
+// parseRetention reads a retention period: "forever", a number of days
+// such as "30d", or a Go duration such as "12h". Forever is 0.
+func parseRetention(v string) (time.Duration, error) {
+	if v == "forever" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid retention %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid retention %q", v)
+	}
+	return d, nil
+}
+
+// contextRetention returns contextStore.retention by entry type, with
+// "default" for types not listed. Invalid periods are warned about and
+// ignored, keeping those entries.
+func contextRetention(config map[string]any) map[string]time.Duration {
+	cs, _ := config["contextStore"].(map[string]any)
+	raw, _ := cs["retention"].(map[string]any)
+	retention := make(map[string]time.Duration, len(raw))
+	for key, v := range raw {
+		s, _ := v.(string)
+		d, err := parseRetention(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: ignoring contextStore.retention.%s: %v (use forever, 30d, or 12h)\n", key, err)
+			continue
+		}
+		retention[key] = d
+	}
+	return retention
+}
+
+// contextFrontmatter returns the type and timestamp in the frontmatter of
+// a context entry.
+func contextFrontmatter(path string) (typ, timestamp string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || scanner.Text() != "---" {
+		return "", ""
+	}
+	for scanner.Scan() && scanner.Text() != "---" {
+		key, val, _ := strings.Cut(scanner.Text(), ": ")
+		switch key {
+		case "type":
+			typ = strings.TrimSpace(val)
+		case "timestamp":
+			timestamp = strings.TrimSpace(val)
+		}
+	}
+	return typ, timestamp
+}
+
+// gcContext deletes context entries past the retention for their type,
+// aged from their frontmatter timestamp or else their modification time,
+// with their attachments, and removes them from the store's SQLite index.
+func gcContext(store string, retention map[string]time.Duration, now time.Time, dryRun bool) (gcResult, error) {
+	var res gcResult
+	if len(retention) == 0 {
+		return res, nil
+	}
+	var removed []string
+	err := filepath.Walk(store, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		typ, ts := contextFrontmatter(path)
+		keep, ok := retention[typ]
+		if !ok {
+			keep = retention["default"]
+		}
+		written, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			written = info.ModTime()
+		}
+		if keep == 0 || now.Sub(written) <= keep {
+			return nil
+		}
+		attachments := strings.TrimSuffix(path, ".md") + ".attachments"
+		res.Files++
+		res.Bytes += info.Size() + dirSize(attachments)
+		if !dryRun && os.Remove(path) == nil {
+			os.RemoveAll(attachments)
+			removed = append(removed, path)
+		}
+		return nil
+	})
+	if len(removed) > 0 {
+		unindexContext(store, removed)
+	}
+	return res, err
+}
+
+// dirSize is the total size of the files under dir, 0 if there is none.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// unindexContext removes entries from the store's SQLite index, .index.db,
+// or removes the index if it cannot, so the SDKs rebuild it without them.
+func unindexContext(store string, paths []string) {
+	index := filepath.Join(store, ".index.db")
+	if _, err := os.Stat(index); err != nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("BEGIN;\n")
+	for _, path := range paths {
+		rel, err := filepath.Rel(store, path)
+		if err != nil {
+			continue
+		}
+		q := "'" + strings.ReplaceAll(filepath.ToSlash(rel), "'", "''") + "'"
+		fmt.Fprintf(&b, "DELETE FROM entries WHERE path = %s;\nDELETE FROM entries_fts WHERE path = %s;\nDELETE FROM embeddings WHERE path = %s;\n", q, q, q)
+	}
+	b.WriteString("COMMIT;\n")
+	runContextIndexSQL(index, b.String())
+}
+
+func pluralY(n int) string {
+	if n == 1 {
+		return "y"
+	}
+	return "ies"
+}
+
+func pluralS(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+// formatBytes renders a byte count with a binary unit suffix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGCCache(t *testing.T) {
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "summarizer")
+	os.MkdirAll(agentDir, 0755)
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	os.WriteFile(filepath.Join(agentDir, "fresh.json"), []byte(`{"expiresAt":"2026-03-01T13:00:00Z"}`), 0600)
+	os.WriteFile(filepath.Join(agentDir, "stale.json"), []byte(`{"expiresAt":"2026-03-01T11:00:00Z"}`), 0600)
+	os.WriteFile(filepath.Join(agentDir, "corrupt.json"), []byte(`{`), 0600)
+
+	res, err := gcCache(dir, now, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Files != 2 {
+		t.Errorf("expected 2 files in dry run, got %d", res.Files)
+	}
+	if _, err := os.Stat(filepath.Join(agentDir, "stale.json")); err != nil {
+		t.Error("dry run should not delete files")
+	}
+
+	if _, err := gcCache(dir, now, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(agentDir, "stale.json")); !os.IsNotExist(err) {
+		t.Error("expected stale entry to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(agentDir, "fresh.json")); err != nil {
+		t.Error("expected fresh entry to remain")
+	}
+}
+
+func TestGCCacheMissingDir(t *testing.T) {
+	res, err := gcCache(filepath.Join(t.TempDir(), "missing"), time.Now(), false)
+	if err != nil || res.Files != 0 {
+		t.Errorf("expected no-op on missing dir, got %+v / %v", res, err)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	if got := formatBytes(512); got != "512 B" {
+		t.Errorf("expected 512 B, got %s", got)
+	}
+	if got := formatBytes(1536); got != "1.5 KiB" {
+		t.Errorf("expected 1.5 KiB, got %s", got)
+	}
+}
+
+func TestGCContext(t *testing.T) {
+	store := t.TempDir()
+	agentDir := filepath.Join(store, "reviewer")
+	os.MkdirAll(agentDir, 0755)
+	write := func(name, typ, ts string) string {
+		path := filepath.Join(agentDir, name)
+		os.WriteFile(path, []byte("---\nagent: reviewer\ntimestamp: "+ts+"\ntype: "+typ+"\n---\n\nbody\n"), 0644)
+		return path
+	}
+	oldFinding := write("old-finding.md", "finding", "2026-01-01T00:00:00Z")
+	newFinding := write("new-finding.md", "finding", "2026-02-28T00:00:00Z")
+	oldSummary := write("old-summary.md", "summary", "2025-01-01T00:00:00Z")
+	oldDecision := write("old-decision.md", "decision", "2025-06-01T00:00:00Z")
+	attachments := filepath.Join(agentDir, "old-finding.attachments")
+	os.MkdirAll(attachments, 0755)
+	os.WriteFile(filepath.Join(attachments, "fix.diff"), []byte("-a\n+b\n"), 0644)
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	retention := contextRetention(map[string]any{"contextStore": map[string]any{"retention": map[string]any{
+		"finding": "30d", "summary": "forever", "default": "180d",
+	}}})
+
+	res, err := gcContext(store, retention, now, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Files != 2 {
+		t.Errorf("expected 2 entries in dry run, got %d", res.Files)
+	}
+	if _, err := os.Stat(oldFinding); err != nil {
+		t.Error("dry run should not delete entries")
+	}
+
+	if _, err := gcContext(store, retention, now, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, path := range []string{oldFinding, oldDecision, attachments} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", filepath.Base(path))
+		}
+	}
+	for _, path := range []string{newFinding, oldSummary} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to remain", filepath.Base(path))
+		}
+	}
+}
+
+func TestGCSessions(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string, mtime time.Time) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(body), 0644)
+		os.Chtimes(path, mtime, mtime)
+		return path
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	old := write("old.json", `{"sessionId":"old","status":"completed","endedAt":"2026-01-01T00:00:00Z"}`, now)
+	oldLock := write("old.json.lock", "", now)
+	recent := write("recent.json", `{"sessionId":"recent","status":"failed","endedAt":"2026-02-27T00:00:00Z"}`, now)
+	stale := write("stale.json", `{"sessionId":"stale","status":"running"}`, now.Add(-60*24*time.Hour))
+	running := write("running.json", `{"sessionId":"running","status":"running"}`, now.Add(-time.Hour))
+	orphan := write("gone.json.lock", "", now)
+
+	res, err := gcSessions(dir, 30*24*time.Hour, now, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Files != 2 {
+		t.Errorf("expected 2 sessions in dry run, got %d", res.Files)
+	}
+	if _, err := os.Stat(old); err != nil {
+		t.Error("dry run should not delete sessions")
+	}
+
+	if _, err := gcSessions(dir, 30*24*time.Hour, now, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, path := range []string{old, oldLock, stale, orphan} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", filepath.Base(path))
+		}
+	}
+	for _, path := range []string{recent, running} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to remain", filepath.Base(path))
+		}
+	}
+
+	if res, _ := gcSessions(dir, 0, now.Add(365*24*time.Hour), false); res.Files != 0 {
+		t.Errorf("forever retention should keep every session, removed %d", res.Files)
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	for in, want := range map[string]time.Duration{"forever": 0, "7d": 7 * 24 * time.Hour, "36h": 36 * time.Hour} {
+		if got, err := parseRetention(in); err != nil || got != want {
+			t.Errorf("parseRetention(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "0d", "week", "-2h"} {
+		if _, err := parseRetention(in); err == nil {
+			t.Errorf("parseRetention(%q) should fail", in)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph <session-id>",
+	Short: "Show the agent invocation tree for a session",
+	Long:  "Read the execution log and render which version of which agent ran at each hop of a session.",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runGraph,
+}
+
+// graphNode is one invocation in a session's call tree.
+type graphNode struct {
+	label    string
+	startMs  int64
+	entry    *logEntry
+	children []*graphNode
+}
+
+func runGraph(cmd *cobra.Command, args []string) error {
+	logPath, err := resolveLogFile()
+	if err != nil {
+		return err
+	}
+
+	entries, err := readExecutionLog(logPath)
+	if err != nil {
+		return fmt.Errorf("failed to read execution log %s: %w", logPath, err)
+	}
+
+	roots := buildGraph(entries, args[0])
+	if len(roots) == 0 {
+		return fmt.Errorf("no log entries found for session %s", args[0])
+	}
+
+	renderGraph(os.Stdout, roots, 0)
+	return nil
+}
+
+// buildGraph arranges a session's log entries into invocation trees.
+// Each entry's call chain is its path from the root; entries written by older
+// SDKs without callChainDetail fall back to plain agent names.
+func buildGraph(entries []logEntry, sessionID string) []*graphNode {
+	nodes := make(map[string]*graphNode)
+	var roots []*graphNode
+
+	var ensure func(path []string) *graphNode
+	ensure = func(path []string) *graphNode {
+		key := strings.Join(path, ",")
+		if n, ok := nodes[key]; ok {
+			return n
+		}
+		label, startMs := parseHop(path[len(path)-1])
+		n := &graphNode{label: label, startMs: startMs}
+		nodes[key] = n
+		if len(path) == 1 {
+			roots = append(roots, n)
+		} else {
+			parent := ensure(path[:len(path)-1])
+			parent.children = append(parent.children, n)
+		}
+		return n
+	}
+
+	for i := range entries {
+		e := &entries[i]
+		if e.SessionID != sessionID {
+			continue
+		}
+		path := e.CallChainDetail
+		if len(path) == 0 {
+			path = e.CallChain
+		}
+		if len(path) == 0 {
+			path = []string{e.Agent}
+		}
+		ensure(path).entry = e
+	}
+
+	sortNodes(roots)
+	return roots
+}
+
+// sortNodes orders siblings by start time, recursively.
+func sortNodes(nodes []*graphNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].startMs < nodes[j].startMs
+	})
+	for _, n := range nodes {
+		sortNodes(n.children)
+	}
+}
+
+// parseHop extracts "name@version" and the start time from an encoded hop
+// (name@version:depth:start_ms). Bare names are returned unchanged.
+func parseHop(hop string) (string, int64) {
+	at := strings.Index(hop, "@")
+	if at < 0 {
+		return hop, 0
+	}
+	parts := strings.Split(hop[at+1:], ":")
+	if len(parts) < 3 {
+		return hop, 0
+	}
+	version := strings.Join(parts[:len(parts)-2], ":")
+	startMs, _ := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	label := hop[:at]
+	if version != "" {
+		label += "@" + version
+	}
+	return label, startMs
+}
+
+// renderGraph writes the tree with two-space indentation per depth.
+func renderGraph(w io.Writer, nodes []*graphNode, depth int) {
+	for _, n := range nodes {
+		status := "(no log entry)"
+		if n.entry != nil {
+			status = fmt.Sprintf("exit %d  %dms", n.entry.ExitCode, n.entry.DurationMs)
+		}
+		fmt.Fprintf(w, "%s%s  %s\n", strings.Repeat("  ", depth), n.label, status)
+		renderGraph(w, n.children, depth+1)
+	}
+}
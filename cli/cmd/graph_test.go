@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseHop(t *testing.T) {
+	label, start := parseHop("reviewer@1.2.0:1:1700000000000")
+	if label != "reviewer@1.2.0" || start != 1700000000000 {
+		t.Errorf("unexpected parse: %q %d", label, start)
+	}
+
+	label, start = parseHop("legacy")
+	if label != "legacy" || start != 0 {
+		t.Errorf("expected bare name passthrough, got %q %d", label, start)
+	}
+}
+
+func TestBuildGraph(t *testing.T) {
+	root := "planner@1.0.0:0:1000"
+	entries := []logEntry{
+		{Agent: "summarizer", SessionID: "s1", ExitCode: 0, DurationMs: 50,
+			CallChainDetail: []string{root, "summarizer@0.1.0:1:3000"}},
+		{Agent: "reviewer", SessionID: "s1", ExitCode: 1, DurationMs: 80,
+			CallChainDetail: []string{root, "reviewer@0.3.0:1:2000"}},
+		{Agent: "planner", SessionID: "s1", ExitCode: 0, DurationMs: 200,
+			CallChainDetail: []string{root}},
+		{Agent: "other", SessionID: "s2", CallChain: []string{"other"}},
+	}
+
+	roots := buildGraph(entries, "s1")
+	if len(roots) != 1 {
+		t.Fatalf("expected 1 root, got %d", len(roots))
+	}
+	if roots[0].label != "planner@1.0.0" || roots[0].entry == nil {
+		t.Fatalf("unexpected root %+v", roots[0])
+	}
+	if len(roots[0].children) != 2 {
+		t.Fatalf("expected 2 children, got %d", len(roots[0].children))
+	}
+	// Children are ordered by start time, not log order
+	if roots[0].children[0].label != "reviewer@0.3.0" {
+		t.Errorf("expected reviewer first, got %s", roots[0].children[0].label)
+	}
+
+	var buf bytes.Buffer
+	renderGraph(&buf, roots, 0)
+	out := buf.String()
+	if !strings.Contains(out, "planner@1.0.0  exit 0  200ms\n  reviewer@0.3.0  exit 1  80ms\n") {
+		t.Errorf("unexpected render:\n%s", out)
+	}
+}
+
+func TestBuildGraphLegacyEntries(t *testing.T) {
+	entries := []logEntry{
+		{Agent: "child", SessionID: "s1", CallChain: []string{"parent", "child"}},
+	}
+
+	roots := buildGraph(entries, "s1")
+	if len(roots) != 1 || roots[0].label != "parent" || roots[0].entry != nil {
+		t.Fatalf("expected placeholder parent root, got %+v", roots)
+	}
+	if len(roots[0].children) != 1 || roots[0].children[0].entry == nil {
+		t.Error("expected child node with entry")
+	}
+}
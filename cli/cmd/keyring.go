@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringService is the keychain service the SDK stores secrets under; the
+// CLI shares it to manage the config encryption key.
+const keyringService = "single-file-agents"
+
+// errKeyringNotFound means the keychain has no item for the account.
+var errKeyringNotFound = errors.New("not found in keyring")
+
+// secretStore reads and writes keychain items.
+type secretStore interface {
+	get(service, account string) (string, error)
+	set(service, account, value string) error
+}
+
+// keyring is the platform keychain: macOS Keychain, libsecret, or Windows
+// Credential Manager. Tests replace it.
+var keyring secretStore = systemKeyring{}
+
+// keyringToolError describes a failed keychain command, distinguishing a
+// missing tool from a failure it reported.
+func keyringToolError(err error, stderr string) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("keyring tool is not installed: %w", err)
+	}
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// systemKeyring uses the macOS Keychain through the security tool.
+type systemKeyring struct{}
+
+func (systemKeyring) get(service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 44 { // errSecItemNotFound
+			return "", errKeyringNotFound
+		}
+		return "", keyringToolError(err, stderr.String())
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (systemKeyring) set(service, account, value string) error {
+	var stderr bytes.Buffer
+	// -U updates an existing item; the password is passed as an argument
+	// because security cannot read it from stdin
+	cmd := exec.Command("security", "add-generic-password", "-U", "-s", service, "-a", account, "-w", value)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return keyringToolError(err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,553 @@
+package cmd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsStatsJSON      bool
+	logsStatsMetrics   bool
+	logsStatsBySession bool
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Inspect execution logs and metrics",
+}
+
+var logsStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Aggregate execution statistics per agent",
+	Long: `Aggregate the execution log into per-agent success rates, p50 and p95
+durations, invocations per day, and the most common failure exit codes,
+optionally per session. With --metrics, aggregate the metrics file written
+by agents into execution counts, failure rates, durations, subagent counts,
+and service startup times instead.`,
+	Args: cobra.NoArgs,
+	RunE: runLogsStats,
+}
+
+func init() {
+	logsStatsCmd.Flags().BoolVar(&logsStatsJSON, "json", false, "Print statistics as JSON")
+	logsStatsCmd.Flags().BoolVar(&logsStatsMetrics, "metrics", false, "Aggregate the metrics file instead of the execution log")
+	logsStatsCmd.Flags().BoolVar(&logsStatsBySession, "by-session", false, "Group the execution log's statistics by session as well as agent")
+	logsStatsCmd.MarkFlagsMutuallyExclusive("metrics", "by-session")
+	logsCmd.AddCommand(logsStatsCmd)
+}
+
+// logEntry mirrors the SDK's execution log entry schema.
+type logEntry struct {
+	SchemaVersion   int            `json:"schemaVersion"`
+	Timestamp       string         `json:"timestamp"`
+	Agent           string         `json:"agent"`
+	Version         string         `json:"version"`
+	ExitCode        int            `json:"exitCode"`
+	DurationMs      int64          `json:"durationMs"`
+	Depth           int            `json:"depth"`
+	CallChain       []string       `json:"callChain"`
+	CallChainDetail []string       `json:"callChainDetail,omitempty"`
+	InputSummary    string         `json:"inputSummary"`
+	OutputSummary   string         `json:"outputSummary"`
+	SessionID       string         `json:"sessionId"`
+	Meta            map[string]any `json:"meta,omitempty"`
+}
+
+// logSchemaVersion mirrors the SDK's LogSchemaVersion: entries of older
+// versions are upgraded as they are read, and newer ones read as is.
+const logSchemaVersion = 2
+
+// logMigrations[i] upgrades the fields of an entry of schema version i+1
+// to version i+2, as the SDK's do.
+var logMigrations = []func(fields map[string]json.RawMessage){
+	// 1 → 2: callChain is always a list, empty rather than null or missing
+	func(fields map[string]json.RawMessage) {
+		if c, ok := fields["callChain"]; !ok || string(c) == "null" {
+			fields["callChain"] = json.RawMessage("[]")
+		}
+	},
+}
+
+// decodeLogEntry parses one line of the execution log, upgrading an entry
+// of an older schema version to logSchemaVersion.
+func decodeLogEntry(line []byte) (logEntry, error) {
+	var e logEntry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return e, err
+	}
+	if e.SchemaVersion >= logSchemaVersion {
+		return e, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return e, err
+	}
+	for v := max(e.SchemaVersion, 1); v < logSchemaVersion; v++ {
+		logMigrations[v-1](fields)
+	}
+	fields["schemaVersion"] = json.RawMessage(strconv.Itoa(logSchemaVersion))
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return e, err
+	}
+	var upgraded logEntry
+	err = json.Unmarshal(data, &upgraded)
+	return upgraded, err
+}
+
+// configFilePath returns the shared config file path.
+// Priority: SFA_CONFIG env > config.json in $XDG_CONFIG_HOME/single-file-agents
+// or ~/.config/single-file-agents.
+func configFilePath() string {
+	if p := os.Getenv("SFA_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := xdgDir("XDG_CONFIG_HOME", ".config")
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// loadSharedConfig reads the shared config, returning an empty map on any error.
+func loadSharedConfig() map[string]any {
+	config := make(map[string]any)
+	data, err := os.ReadFile(configFilePath())
+	if err != nil {
+		return config
+	}
+	_ = json.Unmarshal(data, &config)
+	return config
+}
+
+// resolveLogFile returns the execution log path.
+// Priority: SFA_LOG_FILE env > config logging.file > default.
+func resolveLogFile() (string, error) {
+	if p := os.Getenv("SFA_LOG_FILE"); p != "" {
+		return p, nil
+	}
+	if lm, ok := loadSharedConfig()["logging"].(map[string]any); ok {
+		if f, ok := lm["file"].(string); ok && f != "" {
+			return f, nil
+		}
+	}
+	return dataDir("logs", "executions.jsonl")
+}
+
+// readExecutionLog reads the entries of the execution log at path and of
+// its rotated files. With shared config logging.perAgent "only", agents
+// log to a file of their own in a directory beside it, so those are read
+// as well.
+func readExecutionLog(path string) ([]logEntry, error) {
+	logs := []string{path}
+	if lm, _ := loadSharedConfig()["logging"].(map[string]any); lm["perAgent"] == "only" {
+		agents, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*", filepath.Base(path)))
+		logs = append(logs, agents...)
+	}
+
+	var entries []logEntry
+	found := false
+	for _, log := range logs {
+		for _, file := range append(rotatedLogFiles(log), log) {
+			e, err := readLogEntries(file)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e...)
+			found = true
+		}
+	}
+	if !found {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return entries, nil
+}
+
+// rotatedLogFiles returns the rotated files of the log at path, oldest
+// first: name.<time>.jsonl as the Go SDK names them, or name-<time>.jsonl
+// as the TypeScript SDK does, each gzipped with .gz after it.
+func rotatedLogFiles(path string) []string {
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(path, ext)
+	var files []string
+	for _, pattern := range []string{".*" + ext, ".*" + ext + ".gz", "-*" + ext, "-*" + ext + ".gz"} {
+		matches, _ := filepath.Glob(name + pattern)
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// readLogEntries reads all parseable entries from a JSONL log file, or a
+// gzipped one ending in .gz. Malformed lines are skipped.
+func readLogEntries(path string) ([]logEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var entries []logEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		e, err := decodeLogEntry(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// metricsSample mirrors the SDK's metrics file record.
+type metricsSample struct {
+	Timestamp        string `json:"timestamp"`
+	Agent            string `json:"agent"`
+	Version          string `json:"version"`
+	SessionID        string `json:"sessionId"`
+	ExitCode         int    `json:"exitCode"`
+	DurationMs       int64  `json:"durationMs"`
+	Subagents        int    `json:"subagents"`
+	SubagentFailures int    `json:"subagentFailures"`
+	ServiceStartupMs int64  `json:"serviceStartupMs,omitempty"`
+}
+
+// agentStats aggregates metrics samples for one agent.
+type agentStats struct {
+	Agent             string  `json:"agent"`
+	Executions        int     `json:"executions"`
+	Failures          int     `json:"failures"`
+	SuccessRate       float64 `json:"successRate"`
+	AvgDurationMs     int64   `json:"avgDurationMs"`
+	MaxDurationMs     int64   `json:"maxDurationMs"`
+	Subagents         int     `json:"subagents"`
+	SubagentFailures  int     `json:"subagentFailures"`
+	AvgServiceStartMs int64   `json:"avgServiceStartupMs,omitempty"`
+	serviceStarts     int
+	totalDurationMs   int64
+	totalServiceMs    int64
+}
+
+// resolveMetricsFile returns the metrics file path.
+// Priority: SFA_METRICS_FILE env > config metrics.file > next to the execution log.
+func resolveMetricsFile() (string, error) {
+	if p := os.Getenv("SFA_METRICS_FILE"); p != "" {
+		return p, nil
+	}
+	if mc, ok := loadSharedConfig()["metrics"].(map[string]any); ok {
+		if f, ok := mc["file"].(string); ok && f != "" {
+			return f, nil
+		}
+	}
+	logFile, err := resolveLogFile()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(logFile), "metrics.jsonl"), nil
+}
+
+// readMetricsSamples reads all parseable samples from the metrics file.
+// Malformed lines are skipped.
+func readMetricsSamples(path string) ([]metricsSample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []metricsSample
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s metricsSample
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil || s.Agent == "" {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	return samples, scanner.Err()
+}
+
+// aggregateMetrics groups samples by agent, sorted by agent name.
+func aggregateMetrics(samples []metricsSample) []*agentStats {
+	byAgent := make(map[string]*agentStats)
+	for _, s := range samples {
+		st, ok := byAgent[s.Agent]
+		if !ok {
+			st = &agentStats{Agent: s.Agent}
+			byAgent[s.Agent] = st
+		}
+		st.Executions++
+		if s.ExitCode != 0 {
+			st.Failures++
+		}
+		st.totalDurationMs += s.DurationMs
+		if s.DurationMs > st.MaxDurationMs {
+			st.MaxDurationMs = s.DurationMs
+		}
+		st.Subagents += s.Subagents
+		st.SubagentFailures += s.SubagentFailures
+		if s.ServiceStartupMs > 0 {
+			st.serviceStarts++
+			st.totalServiceMs += s.ServiceStartupMs
+		}
+	}
+
+	stats := make([]*agentStats, 0, len(byAgent))
+	for _, st := range byAgent {
+		st.SuccessRate = float64(st.Executions-st.Failures) / float64(st.Executions)
+		st.AvgDurationMs = st.totalDurationMs / int64(st.Executions)
+		if st.serviceStarts > 0 {
+			st.AvgServiceStartMs = st.totalServiceMs / int64(st.serviceStarts)
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Agent < stats[j].Agent })
+	return stats
+}
+
+// logStats mirrors the SDK's LogStats: the execution log entries of one
+// agent, or of one agent in one session.
+type logStats struct {
+	Agent         string          `json:"agent"`
+	SessionID     string          `json:"sessionId,omitempty"`
+	Executions    int             `json:"executions"`
+	Failures      int             `json:"failures"`
+	SuccessRate   float64         `json:"successRate"`
+	P50DurationMs int64           `json:"p50DurationMs"`
+	P95DurationMs int64           `json:"p95DurationMs"`
+	PerDay        map[string]int  `json:"perDay"`
+	FailureCodes  []exitCodeCount `json:"failureCodes,omitempty"`
+	durations     []int64
+}
+
+// exitCodeCount is how many executions exited with a non-zero code.
+type exitCodeCount struct {
+	ExitCode int `json:"exitCode"`
+	Count    int `json:"count"`
+}
+
+// aggregateLogEntries groups execution log entries by agent, or by agent
+// and session, sorted by agent and then session, as the SDK's
+// AggregateLogs does.
+func aggregateLogEntries(entries []logEntry, bySession bool) []*logStats {
+	groups := make(map[[2]string]*logStats)
+	codes := make(map[*logStats]map[int]int)
+	for _, e := range entries {
+		key := [2]string{e.Agent, ""}
+		if bySession {
+			key[1] = e.SessionID
+		}
+		st, ok := groups[key]
+		if !ok {
+			st = &logStats{Agent: key[0], SessionID: key[1], PerDay: make(map[string]int)}
+			groups[key] = st
+			codes[st] = make(map[int]int)
+		}
+		st.Executions++
+		st.durations = append(st.durations, e.DurationMs)
+		if e.ExitCode != 0 {
+			st.Failures++
+			codes[st][e.ExitCode]++
+		}
+		if ts, err := time.Parse(time.RFC3339Nano, e.Timestamp); err == nil {
+			st.PerDay[ts.UTC().Format(time.DateOnly)]++
+		}
+	}
+
+	stats := make([]*logStats, 0, len(groups))
+	for _, st := range groups {
+		st.SuccessRate = float64(st.Executions-st.Failures) / float64(st.Executions)
+		sort.Slice(st.durations, func(i, j int) bool { return st.durations[i] < st.durations[j] })
+		st.P50DurationMs = percentile(st.durations, 50)
+		st.P95DurationMs = percentile(st.durations, 95)
+		for code, n := range codes[st] {
+			st.FailureCodes = append(st.FailureCodes, exitCodeCount{ExitCode: code, Count: n})
+		}
+		sort.Slice(st.FailureCodes, func(i, j int) bool {
+			a, b := st.FailureCodes[i], st.FailureCodes[j]
+			return a.Count > b.Count || (a.Count == b.Count && a.ExitCode < b.ExitCode)
+		})
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Agent != stats[j].Agent {
+			return stats[i].Agent < stats[j].Agent
+		}
+		return stats[i].SessionID < stats[j].SessionID
+	})
+	return stats
+}
+
+// percentile returns the nearest-rank pth percentile of sorted values.
+func percentile(sorted []int64, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100 // ceil(p/100 * n)
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func runLogsStats(cmd *cobra.Command, args []string) error {
+	if logsStatsMetrics {
+		return runMetricsStats()
+	}
+	path, err := resolveLogFile()
+	if err != nil {
+		return err
+	}
+
+	entries, err := readExecutionLog(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read execution log %s: %w", path, err)
+	}
+	stats := aggregateLogEntries(entries, logsStatsBySession)
+
+	if logsStatsJSON {
+		data, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(stats) == 0 {
+		fmt.Println("No executions logged")
+		return nil
+	}
+	printLogStats(os.Stdout, stats, logsStatsBySession)
+	return nil
+}
+
+// printLogStats writes a table of per-agent statistics, then one of
+// invocations per day.
+func printLogStats(out io.Writer, stats []*logStats, bySession bool) {
+	session := func(st *logStats) string {
+		if bySession {
+			return st.SessionID + "\t"
+		}
+		return ""
+	}
+	sessionHeader := ""
+	if bySession {
+		sessionHeader = "SESSION\t"
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "AGENT\t"+sessionHeader+"RUNS\tFAILED\tSUCCESS\tP50\tP95\tTOP FAILURES")
+	for _, st := range stats {
+		failures := make([]string, 0, 3)
+		for i, c := range st.FailureCodes {
+			if i == 3 {
+				break
+			}
+			failures = append(failures, fmt.Sprintf("exit %d (%d)", c.ExitCode, c.Count))
+		}
+		top := strings.Join(failures, ", ")
+		if top == "" {
+			top = "-"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s%d\t%d\t%.1f%%\t%s\t%s\t%s\n",
+			st.Agent, session(st), st.Executions, st.Failures, st.SuccessRate*100,
+			formatMs(st.P50DurationMs), formatMs(st.P95DurationMs), top)
+	}
+	_ = w.Flush()
+
+	_, _ = fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "DAY\tAGENT\t"+sessionHeader+"RUNS")
+	type dayRow struct {
+		day string
+		st  *logStats
+	}
+	var rows []dayRow
+	for _, st := range stats {
+		for day := range st.PerDay {
+			rows = append(rows, dayRow{day, st})
+		}
+	}
+	// Stats are already sorted by agent and session; keep that within a day
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].day < rows[j].day })
+	for _, r := range rows {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s%d\n", r.day, r.st.Agent, session(r.st), r.st.PerDay[r.day])
+	}
+	_ = w.Flush()
+}
+
+// runMetricsStats prints the statistics of the metrics file.
+func runMetricsStats() error {
+	path, err := resolveMetricsFile()
+	if err != nil {
+		return err
+	}
+
+	samples, err := readMetricsSamples(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read metrics file %s: %w", path, err)
+	}
+	stats := aggregateMetrics(samples)
+
+	if logsStatsJSON {
+		data, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(stats) == 0 {
+		fmt.Println("No metrics recorded")
+		return nil
+	}
+	printAgentStats(os.Stdout, stats)
+	return nil
+}
+
+// printAgentStats writes a per-agent statistics table.
+func printAgentStats(out io.Writer, stats []*agentStats) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "AGENT\tRUNS\tFAILED\tSUCCESS\tAVG\tMAX\tSUBAGENTS\tSVC STARTUP")
+	for _, st := range stats {
+		svc := "-"
+		if st.AvgServiceStartMs > 0 {
+			svc = formatMs(st.AvgServiceStartMs)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%.1f%%\t%s\t%s\t%d\t%s\n",
+			st.Agent, st.Executions, st.Failures, st.SuccessRate*100,
+			formatMs(st.AvgDurationMs), formatMs(st.MaxDurationMs), st.Subagents, svc)
+	}
+	_ = w.Flush()
+}
+
+// formatMs renders a millisecond duration compactly (e.g. "850ms", "2.4s").
+func formatMs(ms int64) string {
+	if ms < 1000 {
+		return fmt.Sprintf("%dms", ms)
+	}
+	return fmt.Sprintf("%.1fs", float64(ms)/1000)
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAggregateMetrics(t *testing.T) {
+	samples := []metricsSample{
+		{Agent: "reviewer", ExitCode: 0, DurationMs: 1000, Subagents: 2},
+		{Agent: "reviewer", ExitCode: 1, DurationMs: 3000, Subagents: 1, SubagentFailures: 1, ServiceStartupMs: 800},
+		{Agent: "planner", ExitCode: 0, DurationMs: 500},
+	}
+
+	stats := aggregateMetrics(samples)
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 agents, got %d", len(stats))
+	}
+	if stats[0].Agent != "planner" {
+		t.Errorf("expected agents sorted by name, got %s first", stats[0].Agent)
+	}
+
+	r := stats[1]
+	if r.Executions != 2 || r.Failures != 1 || r.SuccessRate != 0.5 {
+		t.Errorf("unexpected counts: %+v", r)
+	}
+	if r.AvgDurationMs != 2000 || r.MaxDurationMs != 3000 {
+		t.Errorf("unexpected durations: %+v", r)
+	}
+	if r.Subagents != 3 || r.SubagentFailures != 1 || r.AvgServiceStartMs != 800 {
+		t.Errorf("unexpected subagent/service figures: %+v", r)
+	}
+}
+
+func TestReadMetricsSamplesSkipsMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.jsonl")
+	content := `{"agent":"a","exitCode":0,"durationMs":10}
+not json
+{"exitCode":0}
+{"agent":"b","exitCode":2,"durationMs":20}
+`
+	os.WriteFile(path, []byte(content), 0644)
+
+	samples, err := readMetricsSamples(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(samples) != 2 {
+		t.Errorf("expected 2 valid samples, got %d", len(samples))
+	}
+}
+
+func TestResolveMetricsFileFollowsLogFile(t *testing.T) {
+	t.Setenv("SFA_METRICS_FILE", "")
+	t.Setenv("SFA_LOG_FILE", "/var/tmp/sfa/executions.jsonl")
+	t.Setenv("SFA_CONFIG", filepath.Join(t.TempDir(), "missing.json"))
+
+	got, err := resolveMetricsFile()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "/var/tmp/sfa/metrics.jsonl" {
+		t.Errorf("expected metrics next to log file, got %q", got)
+	}
+}
+
+func TestReadExecutionLogPerAgentOnly(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.json")
+	os.WriteFile(config, []byte(`{"logging":{"perAgent":"only"}}`), 0644)
+	t.Setenv("SFA_CONFIG", config)
+	logPath := filepath.Join(dir, "logs", "executions.jsonl")
+	os.MkdirAll(filepath.Join(dir, "logs", "planner"), 0755)
+	os.WriteFile(filepath.Join(dir, "logs", "planner", "executions.jsonl"), []byte(`{"agent":"planner","sessionId":"s1"}`+"\n"), 0644)
+
+	entries, err := readExecutionLog(logPath)
+	if err != nil || len(entries) != 1 || entries[0].Agent != "planner" {
+		t.Errorf("readExecutionLog() = %+v, %v", entries, err)
+	}
+}
+
+func TestReadExecutionLogCompressedRotations(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SFA_CONFIG", filepath.Join(dir, "missing.json"))
+	logPath := filepath.Join(dir, "executions.jsonl")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`{"agent":"planner","sessionId":"s1"}` + "\n"))
+	gz.Close()
+	os.WriteFile(filepath.Join(dir, "executions.20260101T000000.jsonl.gz"), buf.Bytes(), 0644)
+	os.WriteFile(logPath, []byte(`{"agent":"summarizer","sessionId":"s1"}`+"\n"), 0644)
+
+	entries, err := readExecutionLog(logPath)
+	if err != nil || len(entries) != 2 || entries[0].Agent != "planner" {
+		t.Errorf("readExecutionLog() = %+v, %v", entries, err)
+	}
+	if _, err := readExecutionLog(filepath.Join(dir, "missing.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("missing log: %v", err)
+	}
+}
+
+func TestPrintAgentStats(t *testing.T) {
+	var buf bytes.Buffer
+	printAgentStats(&buf, []*agentStats{
+		{Agent: "planner", Executions: 4, Failures: 1, SuccessRate: 0.75, AvgDurationMs: 850, MaxDurationMs: 2400},
+	})
+
+	out := buf.String()
+	for _, want := range []string{"AGENT", "planner", "75.0%", "850ms", "2.4s"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestAggregateLogEntries(t *testing.T) {
+	entries := []logEntry{
+		{Timestamp: "2026-02-21T10:00:00Z", Agent: "reviewer", SessionID: "s1", DurationMs: 100},
+		{Timestamp: "2026-02-21T11:00:00Z", Agent: "reviewer", SessionID: "s1", ExitCode: 1, DurationMs: 400},
+		{Timestamp: "2026-02-22T09:00:00Z", Agent: "reviewer", SessionID: "s2", ExitCode: 3, DurationMs: 300},
+		{Timestamp: "2026-02-22T10:00:00Z", Agent: "reviewer", SessionID: "s2", ExitCode: 1, DurationMs: 200},
+		{Timestamp: "2026-02-22T10:00:00Z", Agent: "planner", SessionID: "s1", DurationMs: 50},
+	}
+
+	stats := aggregateLogEntries(entries, false)
+	if len(stats) != 2 || stats[0].Agent != "planner" {
+		t.Fatalf("stats = %+v", stats)
+	}
+	r := stats[1]
+	if r.Executions != 4 || r.Failures != 3 || r.SuccessRate != 0.25 || r.P50DurationMs != 200 || r.P95DurationMs != 400 {
+		t.Errorf("reviewer = %+v", r)
+	}
+	if r.PerDay["2026-02-21"] != 2 || r.PerDay["2026-02-22"] != 2 {
+		t.Errorf("perDay = %v", r.PerDay)
+	}
+	if len(r.FailureCodes) != 2 || r.FailureCodes[0] != (exitCodeCount{ExitCode: 1, Count: 2}) {
+		t.Errorf("failureCodes = %v", r.FailureCodes)
+	}
+
+	bySession := aggregateLogEntries(entries, true)
+	if len(bySession) != 3 || bySession[2].SessionID != "s2" || bySession[2].Executions != 2 {
+		t.Errorf("by session = %+v", bySession)
+	}
+
+	var buf bytes.Buffer
+	printLogStats(&buf, bySession, true)
+	out := buf.String()
+	for _, want := range []string{"SESSION", "exit 1 (1), exit 3 (1)", "2026-02-22  planner   s1       1", "50.0%"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestReadLogEntriesUpgradesOldSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "executions.jsonl")
+	os.WriteFile(path, []byte(`{"timestamp":"2026-01-01T10:00:00Z","agent":"parser"}`+"\n"+
+		`{"schemaVersion":2,"timestamp":"2026-01-02T10:00:00Z","agent":"parser","callChain":["parser"]}`+"\n"), 0644)
+
+	entries, err := readLogEntries(path)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("entries = %+v, %v", entries, err)
+	}
+	for _, e := range entries {
+		if e.SchemaVersion != logSchemaVersion || e.CallChain == nil {
+			t.Errorf("entry = %+v", e)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// appDirName is SFA's directory under the XDG base directories.
+const appDirName = "single-file-agents"
+
+// dataDir returns elem joined under the SFA data directory, resolved as the
+// SDKs resolve it: SFA_DATA_HOME > $XDG_DATA_HOME/single-file-agents >
+// ~/.local/share/single-file-agents.
+func dataDir(elem ...string) (string, error) {
+	base := os.Getenv("SFA_DATA_HOME")
+	if base == "" {
+		var err error
+		if base, err = xdgDir("XDG_DATA_HOME", ".local", "share"); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(append([]string{base}, elem...)...), nil
+}
+
+// xdgDir returns single-file-agents under the base directory named by env,
+// or under home/fallback when env is unset or, against the XDG spec,
+// relative.
+func xdgDir(env string, fallback ...string) (string, error) {
+	if p := os.Getenv(env); filepath.IsAbs(p) {
+		return filepath.Join(p, appDirName), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %w", err)
+	}
+	return filepath.Join(append(append([]string{home}, fallback...), appDirName)...), nil
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDataDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SFA_DATA_HOME", "")
+	t.Setenv("XDG_DATA_HOME", "")
+
+	got, err := dataDir("sessions")
+	if err != nil || got != filepath.Join(home, ".local", "share", "single-file-agents", "sessions") {
+		t.Errorf("default: got %s, %v", got, err)
+	}
+
+	t.Setenv("XDG_DATA_HOME", "/xdg/data")
+	if got, _ := dataDir("cache"); got != filepath.FromSlash("/xdg/data/single-file-agents/cache") {
+		t.Errorf("XDG_DATA_HOME: got %s", got)
+	}
+
+	t.Setenv("SFA_DATA_HOME", "/srv/sfa")
+	t.Setenv("SFA_LOG_FILE", "")
+	t.Setenv("SFA_CONFIG", filepath.Join(home, "missing.json"))
+	if got, _ := resolveLogFile(); got != filepath.FromSlash("/srv/sfa/logs/executions.jsonl") {
+		t.Errorf("SFA_DATA_HOME: got %s", got)
+	}
+}
+
+func TestConfigFilePathXDG(t *testing.T) {
+	t.Setenv("SFA_CONFIG", "")
+	t.Setenv("XDG_CONFIG_HOME", "/xdg/config")
+	if got := configFilePath(); got != filepath.FromSlash("/xdg/config/single-file-agents/config.json") {
+		t.Errorf("got %s", got)
+	}
+	t.Setenv("XDG_CONFIG_HOME", "relative")
+	t.Setenv("HOME", "/home/u")
+	if got := configFilePath(); got != filepath.FromSlash("/home/u/.config/single-file-agents/config.json") {
+		t.Errorf("expected relative XDG_CONFIG_HOME ignored, got %s", got)
+	}
+}
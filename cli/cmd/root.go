@@ -19,4 +19,11 @@ func init() {
 	rootCmd.AddCommand(validateCmd)
 	rootCmd.AddCommand(servicesCmd)
 	rootCmd.AddCommand(updateCmd)
+	rootCmd.AddCommand(graphCmd)
+	rootCmd.AddCommand(sessionCmd)
+	rootCmd.AddCommand(gcCmd)
+	rootCmd.AddCommand(contextCmd)
+	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(secretsCmd)
+	rootCmd.AddCommand(configCmd)
 }
@@ -0,0 +1,285 @@
+package cmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// The SDK stores secret env values in the shared config as "enc:v1:" plus
+// base64 of an ephemeral X25519 public key, a nonce, and AES-256-GCM
+// ciphertext bound to "<agent>/<VAR>". The AES key is HKDF-SHA256 of the
+// X25519 shared secret, salted with the ephemeral and recipient public keys.
+// This file must stay byte-compatible with the SDK's configcrypt.go.
+
+const (
+	encryptedPrefix  = "enc:v1:"
+	configKeyAccount = "config-key"
+	configKeyInfo    = "sfa-config-secret-v1"
+)
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage encrypted secrets in the shared config",
+}
+
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "Create or rotate the config encryption key",
+	Long: `Generate a new config encryption key, store it in the OS keychain, and
+re-encrypt every encrypted value in the shared config with it. The first run
+enables encryption: setup encrypts secret values from then on.`,
+	Args: cobra.NoArgs,
+	RunE: runRotateKey,
+}
+
+func init() {
+	secretsCmd.AddCommand(rotateKeyCmd)
+}
+
+func runRotateKey(cmd *cobra.Command, args []string) error {
+	path := configFilePath()
+	if path == "" {
+		return errors.New("failed to determine the shared config path")
+	}
+	if filepath.Ext(path) != ".json" {
+		return fmt.Errorf("rotate-key supports only JSON config files, not %s", path)
+	}
+
+	n, created, err := rotateConfigKey(path)
+	if err != nil {
+		return err
+	}
+	if created {
+		fmt.Println("Created a config encryption key; secret values are encrypted the next time setup saves them")
+	} else {
+		fmt.Println("Rotated the config encryption key")
+	}
+	if n == 1 {
+		fmt.Printf("Re-encrypted 1 secret value in %s\n", path)
+	} else {
+		fmt.Printf("Re-encrypted %d secret values in %s\n", n, path)
+	}
+	return nil
+}
+
+// rotateConfigKey replaces the config key, re-encrypts the config at path
+// with it, and reports how many values were re-encrypted and whether the
+// key is new. The old key is restored if the config cannot be written.
+func rotateConfigKey(path string) (int, bool, error) {
+	config := make(map[string]any)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, false, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &config); err != nil {
+			return 0, false, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	oldEncoded, err := keyring.get(keyringService, configKeyAccount)
+	if err != nil && !errors.Is(err, errKeyringNotFound) {
+		return 0, false, fmt.Errorf("keyring lookup for the config encryption key failed: %w", err)
+	}
+	var oldKey *ecdh.PrivateKey
+	if oldEncoded != "" {
+		if oldKey, err = decodeConfigKey(oldEncoded); err != nil {
+			return 0, false, err
+		}
+	}
+
+	newKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return 0, false, err
+	}
+	n, err := reencryptSecrets(config, oldKey, newKey.PublicKey())
+	if err != nil {
+		return 0, false, err
+	}
+	sm, _ := config["secrets"].(map[string]any)
+	if sm == nil {
+		sm = make(map[string]any)
+		config["secrets"] = sm
+	}
+	sm["recipient"] = base64.StdEncoding.EncodeToString(newKey.PublicKey().Bytes())
+
+	if err := keyring.set(keyringService, configKeyAccount, base64.StdEncoding.EncodeToString(newKey.Bytes())); err != nil {
+		return 0, false, fmt.Errorf("failed to store the config encryption key in the keyring: %w", err)
+	}
+	if err := writeConfigAtomic(path, config); err != nil {
+		if oldEncoded != "" {
+			if rerr := keyring.set(keyringService, configKeyAccount, oldEncoded); rerr != nil {
+				return 0, false, fmt.Errorf("failed to write %s: %v (and restoring the old key failed: %v)", path, err, rerr)
+			}
+		}
+		return 0, false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return n, oldKey == nil, nil
+}
+
+// reencryptSecrets decrypts every encrypted agents.<name>.env value with
+// oldKey and encrypts it for recipient, in place. It fails without changing
+// anything if a value cannot be decrypted.
+func reencryptSecrets(config map[string]any, oldKey *ecdh.PrivateKey, recipient *ecdh.PublicKey) (int, error) {
+	type sealedValue struct {
+		env     map[string]any
+		name    string
+		account string
+	}
+	var sealed []sealedValue
+	agents, _ := config["agents"].(map[string]any)
+	for _, agent := range sortedKeys(agents) {
+		am, _ := agents[agent].(map[string]any)
+		env, _ := am["env"].(map[string]any)
+		for _, name := range sortedKeys(env) {
+			if val, ok := env[name].(string); ok && strings.HasPrefix(val, encryptedPrefix) {
+				sealed = append(sealed, sealedValue{env, name, agent + "/" + name})
+			}
+		}
+	}
+	if len(sealed) > 0 && oldKey == nil {
+		return 0, errors.New("the config has encrypted values but the keychain has no config encryption key to decrypt them")
+	}
+
+	plain := make([]string, len(sealed))
+	for i, s := range sealed {
+		p, err := decryptSecret(oldKey, s.account, s.env[s.name].(string))
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", s.account, err)
+		}
+		plain[i] = p
+	}
+	resealed := make([]string, len(sealed))
+	for i, s := range sealed {
+		v, err := encryptSecret(recipient, s.account, plain[i])
+		if err != nil {
+			return 0, err
+		}
+		resealed[i] = v
+	}
+	for i, s := range sealed {
+		s.env[s.name] = resealed[i]
+	}
+	return len(sealed), nil
+}
+
+func decodeConfigKey(encoded string) (*ecdh.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err == nil {
+		var key *ecdh.PrivateKey
+		if key, err = ecdh.X25519().NewPrivateKey(raw); err == nil {
+			return key, nil
+		}
+	}
+	return nil, errors.New("the config encryption key in the keychain is malformed")
+}
+
+// encryptSecret encrypts plaintext for recipient, bound to account.
+func encryptSecret(recipient *ecdh.PublicKey, account, plaintext string) (string, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	aead, err := secretAEAD(eph, recipient, eph.PublicKey(), recipient)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := append(eph.PublicKey().Bytes(), nonce...)
+	out = aead.Seal(out, nonce, []byte(plaintext), []byte(account))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(out), nil
+}
+
+// decryptSecret reverses encryptSecret with the private key.
+func decryptSecret(key *ecdh.PrivateKey, account, val string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(val, encryptedPrefix))
+	if err != nil || len(raw) < 32 {
+		return "", errors.New("malformed encrypted value")
+	}
+	eph, err := ecdh.X25519().NewPublicKey(raw[:32])
+	if err != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+	aead, err := secretAEAD(key, eph, eph, key.PublicKey())
+	if err != nil {
+		return "", err
+	}
+	rest := raw[32:]
+	if len(rest) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plain, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], []byte(account))
+	if err != nil {
+		return "", errors.New("cannot decrypt: encrypted with a different key or for a different variable")
+	}
+	return string(plain), nil
+}
+
+func secretAEAD(priv *ecdh.PrivateKey, peer, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	aesKey, err := hkdf.Key(sha256.New, shared, salt, configKeyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeConfigAtomic writes config as indented JSON through a temp file in
+// the same directory, so a failed write leaves the old file intact.
+func writeConfigAtomic(path string, config map[string]any) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
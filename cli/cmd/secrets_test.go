@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeKeyring struct {
+	items   map[string]string
+	failSet bool
+}
+
+func (f *fakeKeyring) get(service, account string) (string, error) {
+	val, ok := f.items[service+"|"+account]
+	if !ok {
+		return "", errKeyringNotFound
+	}
+	return val, nil
+}
+
+func (f *fakeKeyring) set(service, account, value string) error {
+	if f.failSet {
+		return errors.New("keyring locked")
+	}
+	f.items[service+"|"+account] = value
+	return nil
+}
+
+func useFakeKeyring(t *testing.T) *fakeKeyring {
+	t.Helper()
+	fake := &fakeKeyring{items: map[string]string{}}
+	prev := keyring
+	keyring = fake
+	t.Cleanup(func() { keyring = prev })
+	return fake
+}
+
+// sdkKey and sdkSealed are a key and a value encrypted with it by the Go
+// SDK, for code-reviewer/API_KEY.
+const (
+	sdkKey    = "AQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYGRobHB0eHyA="
+	sdkSealed = "enc:v1:7+ZxefpskyDhccSCvHb4RW959g/RM5z5G1aSieGfOQ6BEn3sqbaJB0BNPKEWjt2lb3QXN6p/SiEUuiv2FeOLjg9CUeG8r1U="
+)
+
+func TestDecryptSDKSecret(t *testing.T) {
+	key, err := decodeConfigKey(sdkKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := decryptSecret(key, "code-reviewer/API_KEY", sdkSealed)
+	if err != nil || plain != "sk-test-123" {
+		t.Fatalf("expected SDK value to decrypt, got %q, %v", plain, err)
+	}
+	if _, err := decryptSecret(key, "code-reviewer/OTHER", sdkSealed); err == nil {
+		t.Error("expected a different account to fail")
+	}
+}
+
+func TestRotateConfigKey(t *testing.T) {
+	fake := useFakeKeyring(t)
+	fake.items[keyringService+"|"+configKeyAccount] = sdkKey
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{
+  "agents": {"code-reviewer": {"env": {"API_KEY": "`+sdkSealed+`", "API_URL": "https://api.example"}}}
+}`), 0644)
+
+	n, created, err := rotateConfigKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || created {
+		t.Errorf("expected 1 re-encrypted value with an existing key, got %d, created=%v", n, created)
+	}
+
+	newEncoded := fake.items[keyringService+"|"+configKeyAccount]
+	if newEncoded == sdkKey {
+		t.Fatal("expected a new key in the keyring")
+	}
+	newKey, err := decodeConfigKey(newEncoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config map[string]any
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	recipient := config["secrets"].(map[string]any)["recipient"]
+	if recipient != base64.StdEncoding.EncodeToString(newKey.PublicKey().Bytes()) {
+		t.Errorf("expected secrets.recipient to be the new public key, got %v", recipient)
+	}
+	env := config["agents"].(map[string]any)["code-reviewer"].(map[string]any)["env"].(map[string]any)
+	if env["API_URL"] != "https://api.example" {
+		t.Errorf("expected plaintext values untouched, got %v", env["API_URL"])
+	}
+	plain, err := decryptSecret(newKey, "code-reviewer/API_KEY", env["API_KEY"].(string))
+	if err != nil || plain != "sk-test-123" {
+		t.Errorf("expected value re-encrypted for the new key, got %q, %v", plain, err)
+	}
+}
+
+func TestRotateConfigKeyCreatesKey(t *testing.T) {
+	fake := useFakeKeyring(t)
+	path := filepath.Join(t.TempDir(), "sfa", "config.json")
+
+	n, created, err := rotateConfigKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || !created {
+		t.Errorf("expected a new key and nothing re-encrypted, got %d, created=%v", n, created)
+	}
+	if _, ok := fake.items[keyringService+"|"+configKeyAccount]; !ok {
+		t.Error("expected the key in the keyring")
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), `"recipient"`) {
+		t.Errorf("expected secrets.recipient in config, got:\n%s", data)
+	}
+}
+
+func TestRotateConfigKeyFailures(t *testing.T) {
+	fake := useFakeKeyring(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	original := `{"agents": {"code-reviewer": {"env": {"API_KEY": "` + sdkSealed + `"}}}}`
+	os.WriteFile(path, []byte(original), 0644)
+
+	// No key to decrypt existing values
+	if _, _, err := rotateConfigKey(path); err == nil || !strings.Contains(err.Error(), "no config encryption key") {
+		t.Errorf("expected missing key error, got %v", err)
+	}
+
+	// The keyring refuses the new key: nothing changes
+	fake.items[keyringService+"|"+configKeyAccount] = sdkKey
+	fake.failSet = true
+	if _, _, err := rotateConfigKey(path); err == nil {
+		t.Error("expected keyring failure")
+	}
+	if data, _ := os.ReadFile(path); string(data) != original {
+		t.Errorf("expected config untouched, got:\n%s", data)
+	}
+	if fake.items[keyringService+"|"+configKeyAccount] != sdkKey {
+		t.Error("expected old key kept")
+	}
+}
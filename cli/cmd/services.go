@@ -4,15 +4,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
-var servicesAll bool
+var (
+	servicesAll     bool
+	servicesVolumes bool
+	pruneVolumes    bool
+	pruneIdle       time.Duration
+)
 
 var servicesCmd = &cobra.Command{
 	Use:   "services",
@@ -22,6 +28,7 @@ var servicesCmd = &cobra.Command{
 var servicesListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List running SFA-managed docker services",
+	Long:  "List running SFA-managed services, or with --volumes the named volumes agents keep their service data in.",
 	RunE:  runServicesList,
 }
 
@@ -32,10 +39,24 @@ var servicesDownCmd = &cobra.Command{
 	RunE:  runServicesDown,
 }
 
+var servicesPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove idle services and unused shared networks and volumes created by SFA agents",
+	Long: `Stop persistent services left unused for longer than their agent's services.idleTTL, or with --idle, than the given duration.
+Then remove the shared sfa-<name> networks that agents create for their services once no container is attached to them.
+With --volumes, also remove the agents' named volumes that no container uses, deleting the service data in them.`,
+	Args: cobra.NoArgs,
+	RunE: runServicesPrune,
+}
+
 func init() {
 	servicesDownCmd.Flags().BoolVar(&servicesAll, "all", false, "Stop all SFA-managed services")
+	servicesListCmd.Flags().BoolVar(&servicesVolumes, "volumes", false, "List named volumes instead of services")
+	servicesPruneCmd.Flags().BoolVar(&pruneVolumes, "volumes", false, "Also remove unused named volumes and their data")
+	servicesPruneCmd.Flags().DurationVar(&pruneIdle, "idle", 0, "Stop persistent services unused for this long, e.g. 2h, whatever their idleTTL")
 	servicesCmd.AddCommand(servicesListCmd)
 	servicesCmd.AddCommand(servicesDownCmd)
+	servicesCmd.AddCommand(servicesPruneCmd)
 }
 
 type containerInfo struct {
@@ -50,50 +71,70 @@ type containerInfo struct {
 	ServiceName string `json:"-"`
 }
 
-func checkDocker() error {
-	cmd := exec.Command("docker", "info")
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker is not available. Ensure Docker is installed and running")
-	}
-	return nil
+// serviceEngine returns the container engine agentName's services run on,
+// or with agentName "", the shared one, chosen as the SDKs choose it.
+func serviceEngine(agentName string) (containerEngine, error) {
+	return resolveContainerEngine(loadSharedConfig(), agentName)
 }
 
-func getSFAContainers() ([]containerInfo, error) {
-	cmd := exec.Command("docker", "ps",
+func getSFAContainers(engine containerEngine) ([]containerInfo, error) {
+	cmd := engine.command("ps",
 		"--filter", "label=sfa.agent",
 		"--format", "{{json .}}",
 	)
 	out, err := cmd.Output()
 	if err != nil {
-		return nil, fmt.Errorf("failed to query docker: %w", err)
+		return nil, fmt.Errorf("failed to query %s: %w", engine.name, err)
 	}
+	return parseContainers(string(out)), nil
+}
 
-	var containers []containerInfo
-	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
-		if line == "" {
-			continue
-		}
-
-		// Parse the JSON line — docker ps --format json gives flat fields
-		var raw map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &raw); err != nil {
-			continue
+// parseContainers reads ps --format json output: a JSON object per line
+// from Docker, or a JSON array from some Podman versions. Docker gives
+// labels and ports as strings and Podman as a map and a list.
+func parseContainers(out string) []containerInfo {
+	var raws []map[string]interface{}
+	out = strings.TrimSpace(out)
+	if strings.HasPrefix(out, "[") {
+		_ = json.Unmarshal([]byte(out), &raws)
+	} else {
+		for _, line := range strings.Split(out, "\n") {
+			var raw map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &raw); err == nil {
+				raws = append(raws, raw)
+			}
 		}
+	}
 
+	var containers []containerInfo
+	for _, raw := range raws {
 		c := containerInfo{
 			ID:     getStr(raw, "ID"),
 			Names:  getStr(raw, "Names"),
 			Status: getStr(raw, "Status"),
 			Ports:  getStr(raw, "Ports"),
+			Labels: parseLabels(getStr(raw, "Labels")),
+		}
+		if c.ID == "" {
+			c.ID = getStr(raw, "Id")
+		}
+		if names, ok := raw["Names"].([]interface{}); ok {
+			c.Names = joinStrings(names)
+		}
+		if labels, ok := raw["Labels"].(map[string]interface{}); ok {
+			for k, v := range labels {
+				if s, ok := v.(string); ok {
+					c.Labels[k] = s
+				}
+			}
+		}
+		if ports, ok := raw["Ports"].([]interface{}); ok {
+			c.Ports = formatPodmanPorts(ports)
 		}
 
-		// Parse labels to get agent name and service name
-		labelsStr := getStr(raw, "Labels")
-		labels := parseLabels(labelsStr)
-		c.AgentName = labels["sfa.agent"]
-		c.ServiceName = labels["com.docker.compose.service"]
+		// Labels give the agent name and service name
+		c.AgentName = c.Labels["sfa.agent"]
+		c.ServiceName = c.Labels["com.docker.compose.service"]
 		if c.ServiceName == "" {
 			c.ServiceName = c.Names
 		}
@@ -101,7 +142,7 @@ func getSFAContainers() ([]containerInfo, error) {
 		containers = append(containers, c)
 	}
 
-	return containers, nil
+	return containers
 }
 
 func getStr(m map[string]interface{}, key string) string {
@@ -113,6 +154,37 @@ func getStr(m map[string]interface{}, key string) string {
 	return ""
 }
 
+func joinStrings(values []interface{}) string {
+	var parts []string
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// formatPodmanPorts formats Podman port mappings as Docker prints them,
+// e.g. 0.0.0.0:5432->5432/tcp.
+func formatPodmanPorts(ports []interface{}) string {
+	var parts []string
+	for _, p := range ports {
+		m, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		host, _ := m["host_ip"].(string)
+		if host == "" {
+			host = "0.0.0.0"
+		}
+		hostPort, _ := m["host_port"].(float64)
+		containerPort, _ := m["container_port"].(float64)
+		protocol, _ := m["protocol"].(string)
+		parts = append(parts, fmt.Sprintf("%s:%d->%d/%s", host, int(hostPort), int(containerPort), protocol))
+	}
+	return strings.Join(parts, ", ")
+}
+
 func parseLabels(s string) map[string]string {
 	labels := make(map[string]string)
 	for _, pair := range strings.Split(s, ",") {
@@ -125,72 +197,130 @@ func parseLabels(s string) map[string]string {
 }
 
 func runServicesList(cmd *cobra.Command, args []string) error {
-	if err := checkDocker(); err != nil {
+	dir, err := dataDir("services")
+	if err != nil {
 		return err
 	}
+	states := readServiceStates(dir)
 
-	containers, err := getSFAContainers()
+	engine, err := serviceEngine("")
+	if err != nil {
+		// Without an engine, the recorded state is all there is to show
+		if servicesVolumes || len(states) == 0 {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "warning: %v; showing recorded state\n", err)
+		printServiceRows(serviceRows(nil, states, func(string) (string, bool) { return "unknown", true }))
+		return nil
+	}
+
+	if servicesVolumes {
+		return listServiceVolumes(engine)
+	}
+
+	containers, err := getSFAContainers(engine)
 	if err != nil {
 		return err
 	}
 
-	if len(containers) == 0 {
+	rows := serviceRows(containers, states, func(id string) (string, bool) {
+		return inspectContainerStatus(engine, id)
+	})
+	if len(rows) == 0 {
 		fmt.Println("No SFA services running")
 		return nil
 	}
+	printServiceRows(rows)
+	return nil
+}
 
+// inspectContainerStatus returns a container's state, such as running or
+// exited, or ok false if the engine has no such container.
+func inspectContainerStatus(engine containerEngine, id string) (string, bool) {
+	if id == "" {
+		return "", false
+	}
+	out, err := engine.command("inspect", "--format", "{{.State.Status}}", id).Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
+
+// printServiceRows prints services as a table, with a DRIFT column only
+// if the engine disagrees with the recorded state somewhere.
+func printServiceRows(rows []serviceRow) {
+	drift := false
+	for _, r := range rows {
+		drift = drift || r.Drift != ""
+	}
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	_, _ = fmt.Fprintln(w, "AGENT\tSERVICE\tSTATUS\tPORTS")
-	for _, c := range containers {
-		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.AgentName, c.ServiceName, c.Status, c.Ports)
+	if drift {
+		_, _ = fmt.Fprintln(w, "AGENT\tSERVICE\tSTATUS\tPORTS\tDRIFT")
+	} else {
+		_, _ = fmt.Fprintln(w, "AGENT\tSERVICE\tSTATUS\tPORTS")
+	}
+	for _, r := range rows {
+		if drift {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Agent, r.Service, r.Status, r.Ports, r.Drift)
+		} else {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Agent, r.Service, r.Status, r.Ports)
+		}
 	}
 	_ = w.Flush()
-
-	return nil
 }
 
 func runServicesDown(cmd *cobra.Command, args []string) error {
-	if err := checkDocker(); err != nil {
+	if !servicesAll && len(args) == 0 {
+		return fmt.Errorf("specify an agent name or use --all")
+	}
+	agentName := ""
+	if !servicesAll {
+		agentName = args[0]
+	}
+	engine, err := serviceEngine(agentName)
+	if err != nil {
 		return err
 	}
 
 	if servicesAll {
-		return stopAllServices()
-	}
-
-	if len(args) == 0 {
-		return fmt.Errorf("specify an agent name or use --all")
+		return stopAllServices(engine)
 	}
-
-	return stopAgentServices(args[0])
+	return stopAgentServices(engine, agentName)
 }
 
-func stopAgentServices(agentName string) error {
-	// Use docker compose down with the agent's compose file
-	homeDir, err := os.UserHomeDir()
+func stopAgentServices(engine containerEngine, agentName string) error {
+	// Use compose down with the agent's compose file, modern name first
+	dir, err := dataDir("services", agentName)
 	if err != nil {
-		return fmt.Errorf("failed to determine home directory: %w", err)
+		return err
 	}
-
-	composeFile := filepath.Join(homeDir, ".local", "share", "single-file-agents", "services", agentName, "docker-compose.yml")
-
-	if _, err := os.Stat(composeFile); os.IsNotExist(err) {
-		return fmt.Errorf("no compose file found for agent %q at %s", agentName, composeFile)
+	composeFile := ""
+	for _, name := range []string{"compose.yaml", "docker-compose.yml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			composeFile = filepath.Join(dir, name)
+			break
+		}
+	}
+	if composeFile == "" {
+		return fmt.Errorf("no compose file found for agent %q in %s", agentName, dir)
 	}
 
-	c := exec.Command("docker", "compose", "-f", composeFile, "down", "-v")
+	c := engine.composeCommand(composeFile, "down", "-v")
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	if err := c.Run(); err != nil {
 		return fmt.Errorf("failed to stop services for %s: %w", agentName, err)
 	}
+	os.Remove(filepath.Join(dir, lastUsedFile))
+	os.Remove(filepath.Join(dir, "state.json"))
 
 	fmt.Printf("Stopped services for %s\n", agentName)
 	return nil
 }
 
-func stopAllServices() error {
-	containers, err := getSFAContainers()
+func stopAllServices(engine containerEngine) error {
+	containers, err := getSFAContainers(engine)
 	if err != nil {
 		return err
 	}
@@ -208,7 +338,7 @@ func stopAllServices() error {
 
 	// Stop and remove all SFA containers
 	stopArgs := append([]string{"stop"}, ids...)
-	c := exec.Command("docker", stopArgs...)
+	c := engine.command(stopArgs...)
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	if err := c.Run(); err != nil {
@@ -216,13 +346,297 @@ func stopAllServices() error {
 	}
 
 	rmArgs := append([]string{"rm", "-f", "-v"}, ids...)
-	c = exec.Command("docker", rmArgs...)
+	c = engine.command(rmArgs...)
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	if err := c.Run(); err != nil {
 		return fmt.Errorf("failed to remove containers: %w", err)
 	}
 
+	// Nothing recorded is expected to run any more
+	if dir, err := dataDir("services"); err == nil {
+		for agent := range readServiceStates(dir) {
+			os.Remove(filepath.Join(dir, agent, "state.json"))
+			os.Remove(filepath.Join(dir, agent, lastUsedFile))
+		}
+	}
+
 	fmt.Printf("Stopped %d SFA container(s)\n", len(ids))
 	return nil
 }
+
+func runServicesPrune(cmd *cobra.Command, args []string) error {
+	if err := pruneIdleServices(loadSharedConfig(), pruneIdle, time.Now()); err != nil {
+		return err
+	}
+	engine, err := serviceEngine("")
+	if err != nil {
+		return err
+	}
+	if err := pruneNetworks(engine); err != nil {
+		return err
+	}
+	if pruneVolumes {
+		return pruneServiceVolumes(engine)
+	}
+	return nil
+}
+
+// lastUsedFile, in an agent's services directory, holds when the SDKs last
+// used its persistent services, as RFC 3339.
+const lastUsedFile = "last-used"
+
+// idleAgent is an agent whose persistent services have gone unused.
+type idleAgent struct {
+	Name   string
+	Unused time.Duration
+}
+
+// serviceIdleTTL returns an agent's services.idleTTL, from its namespace or
+// else defaults, as the SDKs read it; zero if unset or invalid.
+func serviceIdleTTL(config map[string]any, agentName string) time.Duration {
+	var sections []any
+	if agents, ok := config["agents"].(map[string]any); ok {
+		if ns, ok := agents[agentName].(map[string]any); ok {
+			sections = append(sections, ns["services"])
+		}
+	}
+	if defaults, ok := config["defaults"].(map[string]any); ok {
+		sections = append(sections, defaults["services"])
+	}
+	for _, section := range sections {
+		s, _ := section.(map[string]any)
+		if v, ok := s["idleTTL"].(string); ok {
+			ttl, err := time.ParseDuration(v)
+			if err != nil || ttl < 0 {
+				return 0
+			}
+			return ttl
+		}
+	}
+	return 0
+}
+
+// idleServices returns the agents under dir, the services data directory,
+// whose persistent services were last used longer ago than idle, or with
+// idle zero, than their idle TTL.
+func idleServices(dir string, config map[string]any, idle time.Duration, now time.Time) []idleAgent {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var agents []idleAgent
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name(), lastUsedFile))
+		if err != nil {
+			continue
+		}
+		lastUsed, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+		if err != nil {
+			continue
+		}
+		ttl := idle
+		if ttl == 0 {
+			ttl = serviceIdleTTL(config, entry.Name())
+		}
+		if unused := now.Sub(lastUsed); ttl > 0 && unused >= ttl {
+			agents = append(agents, idleAgent{Name: entry.Name(), Unused: unused})
+		}
+	}
+	return agents
+}
+
+// pruneIdleServices stops the persistent services idleServices finds, each
+// on its agent's engine. Named volumes are kept unless --volumes removes
+// them afterwards.
+func pruneIdleServices(config map[string]any, idle time.Duration, now time.Time) error {
+	dir, err := dataDir("services")
+	if err != nil {
+		return err
+	}
+	for _, agent := range idleServices(dir, config, idle, now) {
+		engine, err := resolveContainerEngine(config, agent.Name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Stopping services for %s, unused for %s\n", agent.Name, agent.Unused.Round(time.Minute))
+		if err := stopAgentServices(engine, agent.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneNetworks removes shared networks no container is attached to.
+func pruneNetworks(engine containerEngine) error {
+	out, err := engine.command("network", "ls",
+		"--filter", "label=sfa.network",
+		"--format", "{{.Name}}",
+	).Output()
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", engine.name, err)
+	}
+	names := strings.Fields(string(out))
+	if len(names) == 0 {
+		fmt.Println("No SFA networks to prune")
+		return nil
+	}
+
+	// A network is unused once no container, running or stopped, is on it
+	var unused []string
+	for _, name := range names {
+		out, err := engine.command("ps", "-a", "-q", "--filter", "network="+name).Output()
+		if err != nil {
+			return fmt.Errorf("failed to query %s: %w", engine.name, err)
+		}
+		if strings.TrimSpace(string(out)) == "" {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) == 0 {
+		fmt.Printf("All %d SFA network(s) are in use\n", len(names))
+		return nil
+	}
+
+	rmArgs := append([]string{"network", "rm"}, unused...)
+	c := engine.command(rmArgs...)
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("failed to remove networks: %w", err)
+	}
+
+	for _, name := range unused {
+		fmt.Printf("Removed network %s\n", name)
+	}
+	return nil
+}
+
+// volumeInfo is a named volume an agent created for its services.
+type volumeInfo struct {
+	Name     string // engine volume, sfa-<agent>-<volume>
+	Agent    string
+	Services string // comma-separated services that mount it
+	Volume   string // name in the agent's service definitions
+}
+
+// getSFAVolumes returns the volumes labelled by SFA agents, by agent and name.
+func getSFAVolumes(engine containerEngine) ([]volumeInfo, error) {
+	out, err := engine.command("volume", "ls", "-q", "--filter", "label=sfa.agent").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", engine.name, err)
+	}
+	names := strings.Fields(string(out))
+	if len(names) == 0 {
+		return nil, nil
+	}
+	out, err = engine.command(append([]string{"volume", "inspect"}, names...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect volumes: %w", err)
+	}
+	return parseVolumes(out)
+}
+
+// parseVolumes reads volume inspect output, a JSON array from both Docker
+// and Podman.
+func parseVolumes(out []byte) ([]volumeInfo, error) {
+	var raw []struct {
+		Name   string            `json:"Name"`
+		Labels map[string]string `json:"Labels"`
+	}
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse volume inspect output: %w", err)
+	}
+	volumes := make([]volumeInfo, 0, len(raw))
+	for _, v := range raw {
+		volumes = append(volumes, volumeInfo{
+			Name:     v.Name,
+			Agent:    v.Labels["sfa.agent"],
+			Services: v.Labels["sfa.service"],
+			Volume:   v.Labels["sfa.volume"],
+		})
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		if volumes[i].Agent != volumes[j].Agent {
+			return volumes[i].Agent < volumes[j].Agent
+		}
+		return volumes[i].Volume < volumes[j].Volume
+	})
+	return volumes, nil
+}
+
+// volumeInUse reports whether any container, running or stopped, mounts
+// the volume.
+func volumeInUse(engine containerEngine, name string) (bool, error) {
+	out, err := engine.command("ps", "-a", "-q", "--filter", "volume="+name).Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to query %s: %w", engine.name, err)
+	}
+	return strings.TrimSpace(string(out)) != "", nil
+}
+
+func listServiceVolumes(engine containerEngine) error {
+	volumes, err := getSFAVolumes(engine)
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 {
+		fmt.Println("No SFA volumes")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "AGENT\tVOLUME\tSERVICES\tIN USE\tNAME")
+	for _, v := range volumes {
+		inUse, err := volumeInUse(engine, v.Name)
+		if err != nil {
+			return err
+		}
+		used := "no"
+		if inUse {
+			used = "yes"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Agent, v.Volume, v.Services, used, v.Name)
+	}
+	_ = w.Flush()
+	return nil
+}
+
+// pruneServiceVolumes removes SFA volumes no container mounts.
+func pruneServiceVolumes(engine containerEngine) error {
+	volumes, err := getSFAVolumes(engine)
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 {
+		fmt.Println("No SFA volumes to prune")
+		return nil
+	}
+
+	var unused []string
+	for _, v := range volumes {
+		inUse, err := volumeInUse(engine, v.Name)
+		if err != nil {
+			return err
+		}
+		if !inUse {
+			unused = append(unused, v.Name)
+		}
+	}
+	if len(unused) == 0 {
+		fmt.Printf("All %d SFA volume(s) are in use\n", len(volumes))
+		return nil
+	}
+
+	c := engine.command(append([]string{"volume", "rm"}, unused...)...)
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("failed to remove volumes: %w", err)
+	}
+	for _, name := range unused {
+		fmt.Printf("Removed volume %s\n", name)
+	}
+	return nil
+}
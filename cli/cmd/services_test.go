@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseContainers(t *testing.T) {
+	docker := `{"ID":"abc","Names":"code-reviewer-postgres-1","Status":"Up 2 minutes","Ports":"0.0.0.0:5432->5432/tcp","Labels":"com.docker.compose.service=postgres,sfa.agent=code-reviewer"}` + "\n"
+	podman := `[{"Id":"def","Names":["researcher-redis-1"],"Status":"Up 5 seconds","Ports":[{"host_ip":"","host_port":6379,"container_port":6379,"protocol":"tcp"}],"Labels":{"com.docker.compose.service":"redis","sfa.agent":"researcher"}}]`
+
+	for name, tc := range map[string]struct {
+		out  string
+		want containerInfo
+	}{
+		"docker": {docker, containerInfo{ID: "abc", AgentName: "code-reviewer", ServiceName: "postgres", Ports: "0.0.0.0:5432->5432/tcp"}},
+		"podman": {podman, containerInfo{ID: "def", AgentName: "researcher", ServiceName: "redis", Ports: "0.0.0.0:6379->6379/tcp"}},
+	} {
+		containers := parseContainers(tc.out)
+		if len(containers) != 1 {
+			t.Fatalf("%s: expected one container, got %d", name, len(containers))
+		}
+		c := containers[0]
+		if c.ID != tc.want.ID || c.AgentName != tc.want.AgentName || c.ServiceName != tc.want.ServiceName || c.Ports != tc.want.Ports {
+			t.Errorf("%s: got %+v, want %+v", name, c, tc.want)
+		}
+	}
+
+	if got := parseContainers(""); len(got) != 0 {
+		t.Errorf("expected no containers, got %v", got)
+	}
+}
+
+func TestParseVolumes(t *testing.T) {
+	out := []byte(`[
+  {"Name": "sfa-reporter-pgdata", "Driver": "local", "Labels": {"sfa.agent": "reporter", "sfa.service": "backup,db", "sfa.volume": "pgdata"}},
+  {"Name": "sfa-archivist-cache", "Driver": "local", "Labels": {"sfa.agent": "archivist", "sfa.service": "cache", "sfa.volume": "cache"}}
+]`)
+	volumes, err := parseVolumes(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 || volumes[0].Agent != "archivist" || volumes[1].Services != "backup,db" || volumes[1].Volume != "pgdata" {
+		t.Errorf("volumes = %+v", volumes)
+	}
+	if _, err := parseVolumes([]byte("not json")); err == nil {
+		t.Error("expected invalid output to fail")
+	}
+}
+
+func TestIdleServices(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	for agent, unused := range map[string]time.Duration{"db-agent": 3 * time.Hour, "search": 45 * time.Minute} {
+		os.MkdirAll(filepath.Join(dir, agent), 0700)
+		lastUsed := now.Add(-unused).Format(time.RFC3339)
+		os.WriteFile(filepath.Join(dir, agent, lastUsedFile), []byte(lastUsed+"\n"), 0600)
+	}
+	os.MkdirAll(filepath.Join(dir, "ephemeral"), 0700)
+
+	config := map[string]any{
+		"defaults": map[string]any{"services": map[string]any{"idleTTL": "2h"}},
+		"agents":   map[string]any{"search": map[string]any{"services": map[string]any{"idleTTL": "30m"}}},
+	}
+	got := idleServices(dir, config, 0, now)
+	if len(got) != 2 || got[0].Name != "db-agent" || got[0].Unused != 3*time.Hour || got[1].Name != "search" {
+		t.Errorf("with configured TTLs = %+v, want db-agent and search", got)
+	}
+
+	if got := idleServices(dir, nil, 0, now); len(got) != 0 {
+		t.Errorf("without TTLs = %+v, want none", got)
+	}
+	if got := idleServices(dir, nil, time.Hour, now); len(got) != 1 || got[0].Name != "db-agent" {
+		t.Errorf("with --idle 1h = %+v, want db-agent", got)
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// servicesState is the state.json the SDKs write beside an agent's
+// compose.yaml: the services they last brought up.
+type servicesState struct {
+	Agent       string                        `json:"agent"`
+	Host        string                        `json:"host"`
+	ComposeHash string                        `json:"composeHash"`
+	StartedAt   string                        `json:"startedAt"`
+	Services    map[string]serviceStateRecord `json:"services"`
+}
+
+// serviceStateRecord is one service in state.json.
+type serviceStateRecord struct {
+	Container string   `json:"container"`
+	Name      string   `json:"name"`
+	Ports     []string `json:"ports"`
+}
+
+// serviceRow is one line of sfa services list. Drift is "" when the engine
+// agrees with the recorded state.
+type serviceRow struct {
+	Agent   string
+	Service string
+	Status  string
+	Ports   string
+	Drift   string
+}
+
+// readServiceStates returns the state.json of each agent under dir, the
+// services data directory, by agent name. Unreadable files are skipped.
+func readServiceStates(dir string) map[string]servicesState {
+	states := make(map[string]servicesState)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return states
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name(), "state.json"))
+		if err != nil {
+			continue
+		}
+		var state servicesState
+		if json.Unmarshal(data, &state) == nil {
+			states[entry.Name()] = state
+		}
+	}
+	return states
+}
+
+// sameContainer reports whether two container IDs, either possibly
+// truncated, name the same container.
+func sameContainer(a, b string) bool {
+	return a != "" && b != "" && (strings.HasPrefix(a, b) || strings.HasPrefix(b, a))
+}
+
+// serviceRows compares the running containers with the recorded states.
+// A recorded service with no running container is looked up by ID with
+// inspect, which returns its status, or ok false if the container is gone;
+// it may still be running if the label filter missed it. Running containers
+// of a recorded agent that the state does not list are flagged as well.
+func serviceRows(containers []containerInfo, states map[string]servicesState, inspect func(id string) (status string, ok bool)) []serviceRow {
+	var rows []serviceRow
+	seen := make(map[string]bool)
+	for _, c := range containers {
+		row := serviceRow{Agent: c.AgentName, Service: c.ServiceName, Status: c.Status, Ports: c.Ports}
+		if state, ok := states[c.AgentName]; ok {
+			rec, listed := state.Services[c.ServiceName]
+			switch {
+			case !listed:
+				row.Drift = "not in state"
+			case !sameContainer(rec.Container, c.ID):
+				row.Drift = "container replaced"
+			}
+			seen[c.AgentName+"/"+c.ServiceName] = listed
+		}
+		rows = append(rows, row)
+	}
+
+	for _, agent := range slices.Sorted(maps.Keys(states)) {
+		state := states[agent]
+		for _, name := range slices.Sorted(maps.Keys(state.Services)) {
+			if seen[agent+"/"+name] {
+				continue
+			}
+			rec := state.Services[name]
+			row := serviceRow{Agent: agent, Service: name, Status: "missing", Ports: strings.Join(rec.Ports, ", "), Drift: "expected running"}
+			if status, ok := inspect(rec.Container); ok {
+				row.Status = status
+				if status == "running" {
+					row.Drift = ""
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
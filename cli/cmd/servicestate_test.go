@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadServiceStates(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "reporter"), 0700)
+	os.WriteFile(filepath.Join(dir, "reporter", "state.json"), []byte(`{"agent":"reporter","host":"localhost","composeHash":"abc","startedAt":"2026-05-01T12:00:00Z","services":{"db":{"container":"3f2a9c","ports":["54321:5432"]}}}`), 0600)
+	os.MkdirAll(filepath.Join(dir, "broken"), 0700)
+	os.WriteFile(filepath.Join(dir, "broken", "state.json"), []byte("{"), 0600)
+	os.MkdirAll(filepath.Join(dir, "untracked"), 0700)
+
+	states := readServiceStates(dir)
+	if len(states) != 1 || states["reporter"].Services["db"].Container != "3f2a9c" {
+		t.Errorf("states = %+v, want only reporter", states)
+	}
+}
+
+func TestServiceRows(t *testing.T) {
+	states := map[string]servicesState{
+		"reporter": {Agent: "reporter", Services: map[string]serviceStateRecord{
+			"db":     {Container: "3f2a9c0d11e2"},
+			"cache":  {Container: "77b1e0"},
+			"search": {Container: "9c01ab", Ports: []string{"9200:9200"}},
+			"queue":  {Container: "5d5d5d"},
+		}},
+	}
+	containers := []containerInfo{
+		{ID: "3f2a9c0d11e2", AgentName: "reporter", ServiceName: "db", Status: "Up 2 minutes"},
+		{ID: "abcdef", AgentName: "reporter", ServiceName: "admin", Status: "Up 1 minute"},
+		{ID: "123456", AgentName: "researcher", ServiceName: "redis", Status: "Up 5 seconds"},
+	}
+	inspect := func(id string) (string, bool) {
+		switch id {
+		case "77b1e0":
+			return "exited", true
+		case "5d5d5d":
+			return "running", true // renamed, so the label filter missed it
+		}
+		return "", false
+	}
+
+	want := []serviceRow{
+		{Agent: "reporter", Service: "db", Status: "Up 2 minutes"},
+		{Agent: "reporter", Service: "admin", Status: "Up 1 minute", Drift: "not in state"},
+		{Agent: "researcher", Service: "redis", Status: "Up 5 seconds"},
+		{Agent: "reporter", Service: "cache", Status: "exited", Drift: "expected running"},
+		{Agent: "reporter", Service: "queue", Status: "running"},
+		{Agent: "reporter", Service: "search", Status: "missing", Ports: "9200:9200", Drift: "expected running"},
+	}
+	got := serviceRows(containers, states, inspect)
+	if len(got) != len(want) {
+		t.Fatalf("rows = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
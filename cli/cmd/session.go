@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var sessionJSON bool
+
+var sessionCmd = &cobra.Command{
+	Use:   "session",
+	Short: "Inspect multi-agent sessions",
+}
+
+var sessionListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recorded sessions, most recent first",
+	RunE:  runSessionList,
+}
+
+var sessionShowCmd = &cobra.Command{
+	Use:   "show <session-id>",
+	Short: "Show a session's participants, cost, and context entries",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSessionShow,
+}
+
+func init() {
+	sessionShowCmd.Flags().BoolVar(&sessionJSON, "json", false, "Print the raw session manifest")
+	sessionCmd.AddCommand(sessionListCmd)
+	sessionCmd.AddCommand(sessionShowCmd)
+}
+
+// sessionManifest mirrors the SDK's sessions/<id>.json schema.
+type sessionManifest struct {
+	SessionID      string               `json:"sessionId"`
+	RootAgent      string               `json:"rootAgent"`
+	StartedAt      string               `json:"startedAt"`
+	EndedAt        string               `json:"endedAt,omitempty"`
+	Status         string               `json:"status"`
+	Participants   []sessionParticipant `json:"participants"`
+	Cost           map[string]float64   `json:"cost,omitempty"`
+	ContextEntries []string             `json:"contextEntries,omitempty"`
+}
+
+type sessionParticipant struct {
+	Hop       string             `json:"hop"`
+	Agent     string             `json:"agent"`
+	Version   string             `json:"version"`
+	Depth     int                `json:"depth"`
+	StartedAt string             `json:"startedAt"`
+	EndedAt   string             `json:"endedAt,omitempty"`
+	ExitCode  *int               `json:"exitCode,omitempty"`
+	Cost      map[string]float64 `json:"cost,omitempty"`
+}
+
+// sessionsDir returns the directory holding session manifests.
+func sessionsDir() (string, error) {
+	return dataDir("sessions")
+}
+
+// loadSessions reads all parseable manifests in dir, most recent first.
+func loadSessions(dir string) ([]sessionManifest, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []sessionManifest
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var m sessionManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			continue
+		}
+		sessions = append(sessions, m)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt > sessions[j].StartedAt
+	})
+	return sessions, nil
+}
+
+func runSessionList(cmd *cobra.Command, args []string) error {
+	dir, err := sessionsDir()
+	if err != nil {
+		return err
+	}
+
+	sessions, err := loadSessions(dir)
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No sessions recorded")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SESSION\tROOT\tSTATUS\tSTARTED\tAGENTS")
+	for _, s := range sessions {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", s.SessionID, s.RootAgent, s.Status, s.StartedAt, len(s.Participants))
+	}
+	_ = w.Flush()
+	return nil
+}
+
+func runSessionShow(cmd *cobra.Command, args []string) error {
+	dir, err := sessionsDir()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, args[0]+".json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no session manifest found for %s", args[0])
+		}
+		return err
+	}
+
+	if sessionJSON {
+		fmt.Print(string(data))
+		return nil
+	}
+
+	var m sessionManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("failed to parse session manifest %s: %w", path, err)
+	}
+	printSession(os.Stdout, m)
+	return nil
+}
+
+// printSession writes a human-readable summary of a session manifest.
+func printSession(w io.Writer, m sessionManifest) {
+	fmt.Fprintf(w, "Session:  %s\n", m.SessionID)
+	fmt.Fprintf(w, "Root:     %s\n", m.RootAgent)
+	fmt.Fprintf(w, "Status:   %s\n", m.Status)
+	fmt.Fprintf(w, "Started:  %s\n", m.StartedAt)
+	if m.EndedAt != "" {
+		fmt.Fprintf(w, "Ended:    %s\n", m.EndedAt)
+	}
+	if len(m.Cost) > 0 {
+		fmt.Fprintf(w, "Cost:     %s\n", formatCostMap(m.Cost))
+	}
+
+	fmt.Fprintln(w, "\nParticipants:")
+	for _, p := range m.Participants {
+		exit := "running"
+		if p.ExitCode != nil {
+			exit = fmt.Sprintf("exit %d", *p.ExitCode)
+		}
+		label := p.Agent
+		if p.Version != "" {
+			label += "@" + p.Version
+		}
+		fmt.Fprintf(w, "  %s%s  %s\n", strings.Repeat("  ", p.Depth), label, exit)
+	}
+
+	if len(m.ContextEntries) > 0 {
+		fmt.Fprintln(w, "\nContext entries:")
+		for _, e := range m.ContextEntries {
+			fmt.Fprintf(w, "  %s\n", e)
+		}
+	}
+}
+
+// formatCostMap renders costs as "units=amount" pairs sorted by unit.
+func formatCostMap(costs map[string]float64) string {
+	units := make([]string, 0, len(costs))
+	for u := range costs {
+		units = append(units, u)
+	}
+	sort.Strings(units)
+	parts := make([]string, 0, len(units))
+	for _, u := range units {
+		parts = append(parts, fmt.Sprintf("%s=%g", u, costs[u]))
+	}
+	return strings.Join(parts, " ")
+}
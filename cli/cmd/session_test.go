@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSessionsSortsMostRecentFirst(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.json"), []byte(`{"sessionId":"a","startedAt":"2026-01-01T00:00:00Z"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"sessionId":"b","startedAt":"2026-03-01T00:00:00Z"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "bad.json"), []byte(`not json`), 0644)
+
+	sessions, err := loadSessions(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+	if sessions[0].SessionID != "b" {
+		t.Errorf("expected most recent session first, got %s", sessions[0].SessionID)
+	}
+}
+
+func TestPrintSession(t *testing.T) {
+	exit := 0
+	m := sessionManifest{
+		SessionID: "s1",
+		RootAgent: "planner",
+		Status:    "completed",
+		Cost:      map[string]float64{"usd": 0.42, "tokens": 1200},
+		Participants: []sessionParticipant{
+			{Agent: "planner", Version: "1.0.0", ExitCode: &exit},
+			{Agent: "reviewer", Depth: 1},
+		},
+		ContextEntries: []string{"/store/planner/s1/plan.md"},
+	}
+
+	var buf bytes.Buffer
+	printSession(&buf, m)
+	out := buf.String()
+
+	for _, want := range []string{
+		"Cost:     tokens=1200 usd=0.42",
+		"  planner@1.0.0  exit 0",
+		"    reviewer  running",
+		"/store/planner/s1/plan.md",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
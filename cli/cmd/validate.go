@@ -194,6 +194,26 @@ func checkDescribe(runner []string) []validationResult {
 		}
 	}
 
+	// Validate config schema entries if present
+	if configRaw, ok := desc["config"]; ok {
+		configArr, isArr := configRaw.([]interface{})
+		if !isArr {
+			results = append(results, validationResult{"config is an array", false, fmt.Sprintf("got %T", configRaw)})
+		} else {
+			results = append(results, validationResult{"config is an array", true, ""})
+			for i, entry := range configArr {
+				entryMap, isMap := entry.(map[string]interface{})
+				if !isMap {
+					results = append(results, validationResult{fmt.Sprintf("config[%d] is an object", i), false, "not an object"})
+					continue
+				}
+				if _, ok := entryMap["key"].(string); !ok {
+					results = append(results, validationResult{fmt.Sprintf("config[%d] has key", i), false, "missing"})
+				}
+			}
+		}
+	}
+
 	return results
 }
 
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -14,10 +15,8 @@ type Agent struct {
 
 // DefineAgent creates a new Agent from the given definition.
 func DefineAgent(def AgentDef) *Agent {
-	// Apply defaults
-	if def.TrustLevel == "" {
-		def.TrustLevel = TrustSandboxed
-	}
+	// Apply defaults. TrustLevel stays as declared: an agent that declares
+	// none is described as sandboxed, but not sandboxed at runtime.
 	if def.ServiceLifecycle == "" {
 		def.ServiceLifecycle = ServicePersistent
 	}
@@ -33,11 +32,21 @@ func (a *Agent) Run() {
 	if err != nil {
 		exitWithError(err.Error(), ExitInvalidUsage)
 	}
+	configureStderrLog(args.Flags)
+
+	// Sandboxed agents re-execute inside isolated namespaces before doing any work
+	sandboxed := shouldSandbox(a.def, args.Flags)
+	if sandboxed {
+		if len(a.def.Services) > 0 && sandboxSupported {
+			exitWithError(sandboxForbids("start services").Error(), ExitPermissionDeny)
+		}
+		enterSandbox(a.def.Name, args.Flags.Serve == "" && args.Flags.GRPC == "")
+	}
 
 	// Warn about unknown flags
 	if len(args.Unknown) > 0 {
 		for _, u := range args.Unknown {
-			writeDiagnostic(fmt.Sprintf("warning: unknown flag %s", u))
+			stderrLog.Warn(fmt.Sprintf("unknown flag %s", u))
 		}
 	}
 
@@ -53,12 +62,20 @@ func (a *Agent) Run() {
 		os.Exit(ExitSuccess)
 	}
 
+	// --profile selects a config profile; exported so subagents inherit it
+	if args.Flags.Profile != "" {
+		os.Setenv("SFA_PROFILE", args.Flags.Profile)
+	}
+
 	// Load and merge config
-	config := loadConfig()
-	mergedConfig := mergeConfig(config, a.def.Name)
+	config := loadLayeredConfig()
+	if err := checkProfile(config); err != nil {
+		exitWithError(err.Error(), ExitInvalidUsage)
+	}
+	mergedConfig := mergeAgentConfig(config, a.def)
 
 	// Resolve environment variables
-	resolved := resolveEnv(a.def.Env, a.def.Name, config)
+	resolved := resolveEnv(agentEnvDecls(a.def), a.def.Name, config)
 	injectEnv(resolved)
 
 	// --describe
@@ -69,6 +86,13 @@ func (a *Agent) Run() {
 		os.Exit(ExitSuccess)
 	}
 
+	// --show-config: print what this run would use, and from where
+	if args.Flags.ShowConfig {
+		shown := buildShownConfig(a.def, args, config, mergedConfig, resolved)
+		fmt.Print(shown.render(args.Flags.OutputFormat))
+		os.Exit(ExitSuccess)
+	}
+
 	// Validate required custom options
 	for _, opt := range a.def.Options {
 		if opt.Required {
@@ -84,134 +108,387 @@ func (a *Agent) Run() {
 
 	// --setup
 	if args.Flags.Setup {
-		runSetup(a.def.Name, a.def.Env, args.Flags.NonInteractive)
+		runSetup(a.def.Name, agentEnvDecls(a.def), args.Flags)
 		return // runSetup calls os.Exit
 	}
 
 	// --services-down
 	if args.Flags.ServicesDown {
-		handleServicesDown(a.def.Name)
+		handleServicesDown(a.def.Name, config)
 		return // handleServicesDown calls os.Exit
 	}
 
+	// --explain: print the execution plan without running anything
+	if args.Flags.Explain {
+		input, err := readInput(args.Flags)
+		if err != nil {
+			exitWithError(err.Error(), ExitInvalidUsage)
+		}
+		plan := buildExplainPlan(a.def, args, resolved, resolveLoggingConfig(config, args.Flags.NoLog), input)
+		fmt.Print(plan.render(args.Flags.OutputFormat))
+		os.Exit(ExitSuccess)
+	}
+
+	// Resolve secret references (vault:, op://, registered providers); fail fast
+	if err := resolveSecretRefs(resolved); err != nil {
+		if sandboxActive() {
+			err = fmt.Errorf("%w\nthe sandbox blocks subprocesses and, outside --serve and --grpc, network access; pass secrets as plain values, or set %s=off", err, sandboxEnv)
+		}
+		exitWithError(err.Error(), ExitFailure)
+	}
+
 	// Validate required env vars
 	missing := validateEnv(a.def.Env, resolved)
 	if len(missing) > 0 {
 		exitWithError(formatMissingEnvError(a.def.Name, missing), ExitInvalidUsage)
 	}
+	if invalid := invalidEnv(a.def.Env, resolved); len(invalid) > 0 {
+		exitWithError(formatInvalidEnvError(a.def.Name, invalid), ExitInvalidUsage)
+	}
+
+	// --resume: pin the session whose checkpoint we continue
+	checkpointDir := resolveCheckpointDir()
+	if err := resolveResumeSession(checkpointDir, a.def.Name, args.Flags.Resume); err != nil {
+		exitWithError(err.Error(), ExitInvalidUsage)
+	}
 
 	// Safety: depth, loop detection, session
-	safety, err := initSafety(a.def.Name, args.Flags.MaxDepth)
+	safety, err := initSafety(a.def.Name, a.def.Version, args.Flags.MaxDepth, a.def.LoopPolicy)
 	if err != nil {
 		exitWithError(err.Error(), ExitFailure)
 	}
 
-	// Setup timeout and signals
-	ctx, cancel := setupTimeout(a.def.Name, args.Flags.Timeout)
+	// Cost tracking against SFA_BUDGET
+	costs, err := newCostTracker(os.Getenv("SFA_BUDGET"))
+	if err != nil {
+		exitWithError(err.Error(), ExitInvalidUsage)
+	}
+
+	// Load the checkpoint to re-deliver when resuming
+	var resumeState json.RawMessage
+	if os.Getenv("SFA_RESUME") == "1" {
+		resumeState, err = loadCheckpoint(checkpointDir, safety.SessionID, a.def.Name)
+		if err != nil {
+			stderrLog.Warn(fmt.Sprintf("ignoring unreadable checkpoint: %v", err))
+		} else if resumeState != nil {
+			emitProgress(a.def.Name, "resuming from checkpoint")
+		}
+	}
+
+	// Record participation in the session manifest
+	session := newSessionTracker(resolveSessionsDir(), safety)
+	session.start(safety.SessionID)
+
+	// Setup timeout and signals (a server runs until signalled; --timeout applies per request)
+	timeout := args.Flags.Timeout
+	if args.Flags.resident() {
+		timeout = 0
+	}
+	ctx, cancel := setupTimeout(a.def.Name, timeout)
 	defer cancel()
-	cleanupSignals := setupSignalHandlers(a.def.Name, cancel)
-	defer cleanupSignals()
+
+	// Tracing: root span for this run, exported via OTLP when SFA_OTEL_ENDPOINT is set
+	tracer := newTracer(a.def.Name, a.def.Version)
+	runSpan := tracer.start(ctx, "sfa.run "+a.def.Name)
+	runSpan.setAttr("sfa.agent.name", a.def.Name)
+	runSpan.setAttr("sfa.agent.version", a.def.Version)
+	runSpan.setAttr("sfa.session_id", safety.SessionID)
+	runSpan.setAttr("sfa.depth", safety.Depth)
+	ctx = contextWithSpan(ctx, runSpan)
+	costs.cancel = cancel
+	signals := setupSignalHandlers(a.def.Name, cancel)
+	defer signals.stop()
+
+	// Cleanups run once before exit, whether via the normal path or a
+	// termination signal; registered in reverse of their run order.
+	signals.onCleanup(func(exitCode int) {
+		runSpan.setAttr("sfa.exit_code", exitCode)
+		runSpan.finish(fmt.Errorf("exited with code %d", exitCode))
+		tracer.flush()
+	})
+	execMeta := &executionMeta{started: startTime}
+	signals.onCleanup(func(exitCode int) {
+		costs.writeReport()
+		execMeta.writeInvocationReport(a.def.Name, a.def.Version)
+		session.finish(exitCode, costs.snapshot())
+	})
+
+	// Session services outlive this invocation until the root agent exits
+	heldServices := ""
+	signals.onCleanup(func(int) {
+		if heldServices != "" || safety.Depth == 0 {
+			endSessionServices(safety.SessionID, heldServices, safety.Depth == 0, config)
+		}
+	})
+	signals.setReloader(func() (map[string]any, map[string]string) {
+		config := loadLayeredConfig()
+		resolved := resolveEnv(agentEnvDecls(a.def), a.def.Name, config)
+		injectEnv(resolved)
+		if err := resolveSecretRefs(resolved); err != nil {
+			stderrLog.Warn(err.Error())
+		}
+		return mergeAgentConfig(config, a.def), resolved.Values
+	})
+	signals.setStatus(func() []string {
+		lines := []string{
+			fmt.Sprintf("elapsed %s, session %s, depth %d", time.Since(startTime).Round(time.Second), safety.SessionID, safety.Depth),
+			"call chain " + formatHops(safety.Hops),
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			lines = append(lines, fmt.Sprintf("%s until timeout", time.Until(deadline).Round(time.Second)))
+		}
+		if totals := costs.snapshot(); totals != nil {
+			lines = append(lines, "cost "+formatCosts(totals))
+		}
+		return lines
+	})
 
 	// Resolve logging config
 	logConfig := resolveLoggingConfig(config, args.Flags.NoLog)
+	if !logConfig.Suppressed {
+		logConfig.Sinks = append(logConfig.Sinks, a.def.LogSinks...)
+	}
+	activeLogConfig.Store(logConfig)
+	signals.onCleanup(func(int) { closeLogSinks(logConfig) })
+
+	// Metrics: Prometheus endpoint on --metrics-port, samples appended to the metrics file
+	metrics := newMetricsRegistry()
+	if args.Flags.MetricsPort > 0 {
+		stopMetrics, err := serveMetrics(metrics, args.Flags.MetricsPort)
+		if err != nil {
+			exitWithError(err.Error(), ExitFailure)
+		}
+		defer stopMetrics()
+	}
 
 	// Resolve context store
 	contextStorePath := resolveContextStorePath(config)
+	contextStore, err := openContextStore(config)
+	if err != nil {
+		exitWithError(err.Error(), ExitInvalidUsage)
+	}
 
-	// Start services if declared
-	if len(a.def.Services) > 0 {
+	// Read input (a server receives input per request)
+	var input string
+	if !args.Flags.resident() {
+		input, err = readInput(args.Flags)
+		if err != nil {
+			exitWithError(err.Error(), ExitInvalidUsage)
+		}
+		if a.def.ContextRequired && input == "" {
+			exitWithError("this agent requires context input (pipe data or use --context/--context-file)", ExitInvalidUsage)
+		}
+	}
+
+	// Restrict filesystem writes and subprocess exec before agent code runs
+	if sandboxed {
+		writable := sandboxWritablePaths(logConfig, resolveMetricsFile(config, logConfig), contextStorePath, args.Flags.OutputFile)
+		if err := applySandbox(writable); err != nil {
+			exitWithError(fmt.Sprintf("%v: sandbox unavailable; declare a higher trust level, or set SFA_SANDBOX=off to run unisolated", err), ExitPermissionDeny)
+		}
+	}
+
+	// Look up a cached result for deterministic agents
+	cacheDir := resolveCacheDir()
+	var cacheKey string
+	var cached *AgentResult
+	if a.def.Cacheable && !args.Flags.NoCache && !args.Flags.resident() {
+		cacheKey = computeCacheKey(a.def.Name, a.def.Version, input, args.Custom)
+		cached = lookupCache(cacheDir, a.def.Name, cacheKey)
+	}
+
+	// Start services if declared (not needed when serving from cache)
+	var services *serviceMonitor
+	if len(a.def.Services) > 0 && cached == nil {
 		emitProgress(a.def.Name, "starting services...")
-		if err := startServices(a.def.Name, a.def.Version, a.def.Services, resolved); err != nil {
+		svcSpan := startSpan(ctx, "sfa.services.start")
+		svcSpan.setAttr("sfa.services.count", len(a.def.Services))
+		svcStart := time.Now()
+		reapIdleServices(a.def.Name, config, svcStart)
+		profiles := resolveServiceProfiles(args.Flags.ServicesProfiles, config, a.def.Name, a.def.Services)
+		// startServices reports a dependency on a service no profile starts
+		active, _ := activeServices(a.def.Services, profiles)
+		services = newServiceMonitor(a.def.Name, active, config, allServicesExternal(a.def.Services))
+		err := startServices(a.def.Name, a.def.Version, withAgentNetworks(a.def.Services, a.def.Networks), profiles, resolved, config, resolveServiceStartTimeout(a.def.ServiceStartTimeout))
+		svcSpan.finish(err)
+		metrics.recordServiceStartup(a.def.Name, time.Since(svcStart))
+		if err != nil {
+			runSpan.finish(err)
+			signals.runCleanups(ExitFailure)
 			exitWithError(err.Error(), ExitFailure)
 		}
+		if a.def.ServiceLifecycle == ServiceSession {
+			holdSessionServices(safety.SessionID, a.def.Name)
+			heldServices = a.def.Name
+		}
+		// Persistent services count as used from start to exit, so the
+		// idle TTL runs from the end of the last run
+		if a.def.ServiceLifecycle == ServicePersistent {
+			recordServiceUse(a.def.Name)
+		}
+		signals.onCleanup(func(int) {
+			if a.def.ServiceLifecycle == ServicePersistent {
+				recordServiceUse(a.def.Name)
+			}
+			stopServices(a.def.Name, a.def.ServiceLifecycle, a.def.Services, config)
+		})
+		// Cleanups run in reverse, so watching stops before teardown
+		signals.onCleanup(func(int) { services.stop() })
 		emitProgress(a.def.Name, "services ready")
 	}
 
-	// Read input
-	input, err := readInput(args.Flags)
-	if err != nil {
-		exitWithError(err.Error(), ExitInvalidUsage)
+	// Everything executions share, whether one CLI run or many server requests
+	runner := &executor{
+		def:           a.def,
+		flags:         args.Flags,
+		resolved:      resolved,
+		config:        mergedConfig,
+		metrics:       metrics,
+		signals:       signals,
+		permissions:   newPermissionPolicy(a.def.Name, a.def.TrustLevel, args.Flags),
+		prompts:       newPrompter(a.def.Name, args.Flags),
+		contextStore:  contextStore,
+		checkpointDir: checkpointDir,
+		rateLimitDir:  resolveRateLimitDir(),
+		services:      services,
 	}
 
-	// Check context required
-	if a.def.ContextRequired && input == "" {
-		exitWithError("this agent requires context input (pipe data or use --context/--context-file)", ExitInvalidUsage)
+	// --serve, --stdio-protocol, --grpc: handle requests until signalled (or
+	// stdin closes), then run cleanups and exit
+	if args.Flags.resident() {
+		server := newAgentServer(runner, safety, args.Custom, sandboxed, logConfig, resolveMetricsFile(config, logConfig))
+		var err error
+		if args.Flags.StdioProtocol {
+			// stdout carries protocol frames only; stray writes go to stderr
+			out := os.Stdout
+			os.Stdout = os.Stderr
+			err = server.serveStdio(ctx, os.Stdin, out)
+		} else if args.Flags.GRPC != "" {
+			err = server.serveGRPC(ctx, args.Flags.GRPC)
+		} else {
+			err = server.serveHTTP(ctx, args.Flags.Serve)
+		}
+		exitCode := ExitSuccess
+		if err != nil {
+			stderrLog.Error(err.Error())
+			exitCode = ExitFailure
+		} else if sigCode, interrupted := signals.exitCode(); interrupted {
+			exitCode = sigCode
+		}
+		runSpan.setAttr("sfa.exit_code", exitCode)
+		runSpan.finish(nil)
+		signals.runCleanups(exitCode)
+		os.Exit(exitCode)
 	}
 
 	// Emit starting
 	emitProgress(a.def.Name, "starting")
 
 	// Build execute context
-	execCtx := &ExecuteContext{
-		Input:        input,
-		Options:      args.Custom,
-		Env:          resolved.Values,
-		Config:       mergedConfig,
-		Ctx:          ctx,
-		Depth:        safety.Depth,
-		SessionID:    safety.SessionID,
-		AgentName:    a.def.Name,
-		AgentVersion: a.def.Version,
-		Progress: func(message string) {
-			emitProgress(a.def.Name, message)
-		},
-		Invoke: func(agentName string, opts *InvokeOpts) (*InvokeResult, error) {
-			return invokeAgent(agentName, safety, ctx, opts)
-		},
-		WriteContext: func(entry ContextEntry) (string, error) {
-			return writeContextEntry(entry, a.def.Name, safety.SessionID, contextStorePath)
-		},
-		SearchContext: func(query ContextQuery) ([]ContextResult, error) {
-			return searchContextEntries(query, contextStorePath)
-		},
-	}
+	var beat *heartbeat
+	execCtx := runner.executeContext(&execution{
+		ctx:     ctx,
+		safety:  safety,
+		costs:   costs,
+		session: session,
+		meta:    execMeta,
+		touch:   func() { beat.touch() },
+	}, input, args.Custom)
+	execCtx.ResumeState = resumeState
 
 	// Execute
-	result, execErr := a.def.Execute(execCtx)
+	var result any
+	var execErr error
+	if cached != nil {
+		emitProgress(a.def.Name, "cache hit")
+		result = *cached
+	} else {
+		beat = startHeartbeat(ctx, a.def.Name, a.def.HeartbeatInterval, startTime)
+		result, execErr = a.def.Execute(execCtx)
+		beat.stop()
+	}
 
-	// Determine exit code
+	// Determine exit code and the structured error reported in JSON mode
 	exitCode := ExitSuccess
 	var outputStr string
+	var failure *AgentError
 
-	if execErr != nil {
-		if ctx.Err() != nil {
-			exitCode = ExitTimeout
-			emitProgress(a.def.Name, "timeout exceeded")
-		} else {
-			exitCode = ExitFailure
-		}
-		writeDiagnostic(fmt.Sprintf("error: %v", execErr))
-	}
-
-	// Stop services if ephemeral
-	if len(a.def.Services) > 0 {
-		stopServices(a.def.Name, a.def.ServiceLifecycle, a.def.Services)
+	if sigCode, interrupted := signals.exitCode(); interrupted {
+		exitCode = sigCode
+		failure = toAgentError(execErr, ErrCodeInterrupted, true)
+	} else {
+		exitCode, failure = classifyFailure(a.def.Name, execErr, ctx, costs, sandboxed)
 	}
 
 	// Format output
+	totals := costs.snapshot()
 	if result != nil {
-		switch v := result.(type) {
-		case AgentResult:
-			if v.Error != "" && exitCode == ExitSuccess {
+		var ar AgentResult
+		ar, exitCode, failure = agentResult(result, exitCode, failure)
+		if cacheKey != "" && cached == nil && exitCode == ExitSuccess {
+			storeCache(cacheDir, a.def.Name, a.def.Version, cacheKey, ar, a.def.CacheTTL)
+		}
+		attachCost(&ar, totals)
+		outputStr = formatResult(ar, failure, args.Flags.OutputFormat)
+	} else if failure != nil && args.Flags.OutputFormat == OutputJSON {
+		outputStr = formatResult(AgentResult{}, failure, OutputJSON)
+	}
+
+	// Write the result to --output-file atomically, so readers never see a
+	// partial result; failing to do so fails the run
+	if outputStr != "" && args.Flags.OutputFile != "" {
+		if err := writeFileAtomic(args.Flags.OutputFile, []byte(outputStr), 0644); err != nil {
+			stderrLog.Error(fmt.Sprintf("failed to write output file: %v", err))
+			if exitCode == ExitSuccess {
 				exitCode = ExitFailure
 			}
-			outputStr = formatResult(v, args.Flags.OutputFormat)
-		default:
-			wrapped := AgentResult{Result: v}
-			outputStr = formatResult(wrapped, args.Flags.OutputFormat)
 		}
 	}
 
+	// A completed run has nothing left to resume
+	if exitCode == ExitSuccess {
+		clearCheckpoint(checkpointDir, safety.SessionID, a.def.Name)
+	}
+
 	// Log execution
 	logEntry := createLogEntry(
 		a.def.Name, a.def.Version, exitCode, startTime,
 		safety.Depth, safety.CallChain, safety.SessionID,
-		input, outputStr,
+		input, outputStr, resolved,
 	)
+	logEntry.CallChainDetail = strings.Split(encodeCallChain(safety.Hops), ",")
+	meta := execMeta.snapshot()
+	if totals != nil {
+		meta["cost"] = totals
+	}
+	if cached != nil {
+		meta["cache"] = "hit"
+	} else if cacheKey != "" {
+		meta["cache"] = "miss"
+	}
+	if len(meta) > 0 {
+		logEntry.Meta = maskLogMeta(meta, resolved)
+	}
 	writeLogEntry(logEntry, logConfig)
 
-	// Write result to stdout
-	if outputStr != "" {
+	// Record execution metrics
+	duration := time.Since(startTime)
+	metrics.recordExecution(a.def.Name, exitCode, duration)
+	appendMetricsSample(resolveMetricsFile(config, logConfig),
+		metrics.sample(a.def.Name, a.def.Version, safety.SessionID, exitCode, duration))
+
+	// Close the run span, then run cleanups: stop ephemeral services, report
+	// costs to the parent agent, finish the session, and export the trace
+	runSpan.setAttr("sfa.exit_code", exitCode)
+	if execErr != nil {
+		runSpan.finish(execErr)
+	} else if exitCode == ExitSuccess {
+		runSpan.finish(nil)
+	}
+	signals.runCleanups(exitCode)
+
+	// Write result to stdout (unless redirected to --output-file without --tee)
+	if outputStr != "" && (args.Flags.OutputFile == "" || args.Flags.Tee) {
 		fmt.Print(outputStr)
 	}
 
@@ -225,11 +502,28 @@ func (a *Agent) Run() {
 	os.Exit(exitCode)
 }
 
+// jsonResult is the JSON output shape: an AgentResult whose error is structured.
+type jsonResult struct {
+	Result   any            `json:"result"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Warnings []string       `json:"warnings,omitempty"`
+	Error    *AgentError    `json:"error,omitempty"`
+}
+
 // formatResult converts an AgentResult to a string based on the output format.
-func formatResult(result AgentResult, format OutputFormat) string {
+// In JSON mode a failure is reported as a structured "error" object.
+func formatResult(result AgentResult, failure *AgentError, format OutputFormat) string {
 	switch format {
 	case OutputJSON:
-		data, err := json.Marshal(result)
+		if failure == nil && result.Error != "" {
+			failure = &AgentError{Code: ErrCodeExecutionFailed, Message: result.Error}
+		}
+		data, err := json.Marshal(jsonResult{
+			Result:   result.Result,
+			Metadata: result.Metadata,
+			Warnings: result.Warnings,
+			Error:    failure,
+		})
 		if err != nil {
 			return fmt.Sprintf("%v", result.Result)
 		}
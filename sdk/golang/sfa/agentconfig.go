@@ -0,0 +1,130 @@
+package sfa
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// mergeAgentConfig merges the config for def and checks it against
+// def.ConfigSchema, warning about values it had to replace.
+func mergeAgentConfig(config map[string]any, def *AgentDef) map[string]any {
+	merged := mergeConfig(config, def.Name)
+	for _, problem := range applyConfigSchema(merged, def.ConfigSchema) {
+		stderrLog.Warn(problem)
+	}
+	return merged
+}
+
+// applyConfigSchema fills in defaults for unset keys and coerces values to
+// their declared types in place: numeric and boolean strings (as written in
+// YAML or env overrides) become numbers and booleans, and scalars become
+// strings. A value that cannot be coerced is replaced by the default, or
+// removed if there is none, and reported.
+func applyConfigSchema(merged map[string]any, defs []ConfigDef) []string {
+	var problems []string
+	for _, def := range defs {
+		val, ok := merged[def.Key]
+		if !ok || val == nil {
+			if def.Default != nil {
+				merged[def.Key] = normalizeConfigDefault(def.Default)
+			}
+			continue
+		}
+		coerced, ok := coerceConfigValue(def.Type, val)
+		if ok {
+			merged[def.Key] = coerced
+			continue
+		}
+		problem := fmt.Sprintf("config key %s must be %s, got %s", def.Key, configTypeName(def.Type), configKind(val))
+		if def.Default != nil {
+			merged[def.Key] = normalizeConfigDefault(def.Default)
+			problem += "; using the default"
+		} else {
+			delete(merged, def.Key)
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// coerceConfigValue converts val to typ, reporting whether it could.
+func coerceConfigValue(typ string, val any) (any, bool) {
+	switch typ {
+	case "string":
+		switch v := val.(type) {
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	case "number":
+		switch v := val.(type) {
+		case float64:
+			return v, true
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, true
+			}
+		}
+	case "boolean":
+		switch v := val.(type) {
+		case bool:
+			return v, true
+		case string:
+			if b, ok := parseEnvBool(v); ok {
+				return b, true
+			}
+		}
+	case "object":
+		if _, ok := val.(map[string]any); ok {
+			return val, true
+		}
+	case "array":
+		if _, ok := val.([]any); ok {
+			return val, true
+		}
+	case "":
+		return val, true
+	}
+	return nil, false
+}
+
+// normalizeConfigDefault converts Go integer defaults to the float64 that
+// decoded config numbers use, so agents see one type either way.
+func normalizeConfigDefault(v any) any {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return v
+}
+
+func configTypeName(typ string) string {
+	if typ == "object" || typ == "array" {
+		return "an " + typ
+	}
+	return "a " + typ
+}
+
+// describeConfigSchema lists the schema for --describe.
+func describeConfigSchema(defs []ConfigDef) []map[string]any {
+	list := make([]map[string]any, 0, len(defs))
+	for _, def := range defs {
+		entry := map[string]any{"key": def.Key}
+		if def.Type != "" {
+			entry["type"] = def.Type
+		}
+		if def.Description != "" {
+			entry["description"] = def.Description
+		}
+		if def.Default != nil {
+			entry["default"] = def.Default
+		}
+		list = append(list, entry)
+	}
+	return list
+}
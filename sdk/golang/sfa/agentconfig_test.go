@@ -0,0 +1,84 @@
+package sfa
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyConfigSchema(t *testing.T) {
+	defs := []ConfigDef{
+		{Key: "timeout", Type: "number", Default: 60},
+		{Key: "verbose", Type: "boolean"},
+		{Key: "model", Type: "string", Default: "small"},
+		{Key: "version", Type: "string"},
+		{Key: "retries", Type: "number", Default: 3},
+		{Key: "tags", Type: "array"},
+		{Key: "extra"},
+	}
+	merged := map[string]any{
+		"timeout": "90",
+		"verbose": "yes",
+		"version": 2.0,
+		"retries": "many",
+		"tags":    "ci",
+		"extra":   map[string]any{"a": 1.0},
+		"other":   "kept",
+	}
+
+	problems := applyConfigSchema(merged, defs)
+	want := map[string]any{
+		"timeout": 90.0,
+		"verbose": true,
+		"model":   "small",
+		"version": "2",
+		"retries": 3.0,
+		"extra":   map[string]any{"a": 1.0},
+		"other":   "kept",
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("got %v\nwant %v", merged, want)
+	}
+	got := strings.Join(problems, "\n")
+	if len(problems) != 2 ||
+		!strings.Contains(got, "config key retries must be a number, got a string; using the default") ||
+		!strings.Contains(got, "config key tags must be an array, got a string") {
+		t.Errorf("unexpected problems:\n%s", got)
+	}
+}
+
+func TestMergeAgentConfigWarns(t *testing.T) {
+	def := &AgentDef{Name: "a", ConfigSchema: []ConfigDef{{Key: "timeout", Type: "number", Default: 30}}}
+	config := map[string]any{"agents": map[string]any{"a": map[string]any{"timeout": "soon"}}}
+
+	var merged map[string]any
+	stderr := captureStderr(t, func() { merged = mergeAgentConfig(config, def) })
+	if merged["timeout"] != 30.0 {
+		t.Errorf("expected default, got %v", merged["timeout"])
+	}
+	if !strings.Contains(stderr, "warning: config key timeout must be a number") {
+		t.Errorf("expected warning, got %q", stderr)
+	}
+}
+
+func TestDescribeConfigSchema(t *testing.T) {
+	def := &AgentDef{
+		Name:    "a",
+		Version: "1.0.0",
+		ConfigSchema: []ConfigDef{
+			{Key: "model", Type: "string", Default: "small", Description: "Model alias"},
+			{Key: "raw"},
+		},
+	}
+	desc := generateDescribe(def, nil, nil)
+	want := []map[string]any{
+		{"key": "model", "type": "string", "default": "small", "description": "Model alias"},
+		{"key": "raw"},
+	}
+	if !reflect.DeepEqual(desc["config"], want) {
+		t.Errorf("unexpected config schema: %v", desc["config"])
+	}
+	if _, ok := generateDescribe(&AgentDef{Name: "b"}, nil, nil)["config"]; ok {
+		t.Error("expected no config field without a schema")
+	}
+}
@@ -0,0 +1,167 @@
+package sfa
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceImage returns the image a service runs: its Image, or for a
+// service built from source without one, sfa-<agent>-<service>.
+func serviceImage(agentName, name string, svc ServiceDef) string {
+	if svc.Image == "" && svc.Build != nil {
+		return "sfa-" + agentName + "-" + name
+	}
+	return svc.Image
+}
+
+// buildContextDir returns a build's context as an absolute path, relative
+// paths being taken from the working directory, the agent's project.
+func buildContextDir(build *BuildDef) (string, error) {
+	context := build.Context
+	if context == "" {
+		context = "."
+	}
+	return filepath.Abs(context)
+}
+
+// checkBuild rejects a build whose context or Dockerfile is missing,
+// before Docker is invoked.
+func checkBuild(name string, build *BuildDef) error {
+	dir, err := buildContextDir(build)
+	if err != nil {
+		return fmt.Errorf("service %s: build context: %w", name, err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("service %s: build context %s is not a directory", name, dir)
+	}
+	dockerfile := build.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	if !filepath.IsAbs(dockerfile) {
+		dockerfile = filepath.Join(dir, dockerfile)
+	}
+	if _, err := os.Stat(dockerfile); err != nil {
+		return fmt.Errorf("service %s: Dockerfile %s not found", name, dockerfile)
+	}
+	return nil
+}
+
+// buildInputsHash hashes what a build depends on: the Dockerfile path, the
+// build args, and the path and content of each file in the context, .git
+// excepted. A changed hash means the image must be rebuilt.
+func buildInputsHash(build *BuildDef) (string, error) {
+	dir, err := buildContextDir(build)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "dockerfile %s\n", build.Dockerfile)
+	for _, k := range sortedKeys(build.Args) {
+		fmt.Fprintf(h, "arg %s=%s\n", k, build.Args[k])
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fmt.Fprintf(h, "file %s\n", filepath.ToSlash(rel))
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash build context %s: %w", dir, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:16], nil
+}
+
+// writeComposeBuild writes a service's build section. The inputs hash is
+// a label on the image, so buildServices can tell a stale image, and in
+// the service block, so a rebuilt service's container is recreated.
+func writeComposeBuild(b *strings.Builder, name string, build *BuildDef) error {
+	if err := checkBuild(name, build); err != nil {
+		return err
+	}
+	dir, _ := buildContextDir(build)
+	hash, err := buildInputsHash(build)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", name, err)
+	}
+	b.WriteString("    build:\n")
+	b.WriteString(fmt.Sprintf("      context: %q\n", dir))
+	if build.Dockerfile != "" {
+		b.WriteString(fmt.Sprintf("      dockerfile: %q\n", build.Dockerfile))
+	}
+	if len(build.Args) > 0 {
+		b.WriteString("      args:\n")
+		for _, k := range sortedKeys(build.Args) {
+			b.WriteString(fmt.Sprintf("        %s: %q\n", k, build.Args[k]))
+		}
+	}
+	b.WriteString("      labels:\n")
+	b.WriteString(fmt.Sprintf("        sfa.build-hash: %q\n", hash))
+	return nil
+}
+
+// imageBuildHash returns the sfa.build-hash label of an image, or "" if
+// the engine does not have it; a variable so tests can stand in for the
+// engine.
+var imageBuildHash = func(engine containerEngine, image string) string {
+	out, err := engine.command("image", "inspect", "--format", `{{index .Config.Labels "sfa.build-hash"}}`, image).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// buildServices runs compose build for the services built from source
+// whose image is missing or was built from other inputs, reporting the
+// build as progress lines.
+func buildServices(engine containerEngine, agentName, composePath string, services map[string]ServiceDef) error {
+	var stale []string
+	for _, name := range sortedKeys(services) {
+		svc := services[name]
+		if svc.Build == nil {
+			continue
+		}
+		hash, err := buildInputsHash(svc.Build)
+		if err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+		if imageBuildHash(engine, serviceImage(agentName, name, svc)) != hash {
+			stale = append(stale, name)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	names := strings.Join(stale, ", ")
+	emitProgress(agentName, fmt.Sprintf("service %s: building", names))
+	start := time.Now()
+	cmd := engine.composeCommand(composePath, append([]string{"build"}, stale...)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("service %s: failed to build: %w", names, err)
+	}
+	emitProgress(agentName, fmt.Sprintf("service %s: built in %s", names, formatElapsed(time.Since(start))))
+	return nil
+}
@@ -0,0 +1,98 @@
+package sfa
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildProject writes a Dockerfile project into a temporary working
+// directory.
+func buildProject(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	prev, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(prev) })
+	dir, _ = os.Getwd() // as the SDK sees it, through any symlinks
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM alpine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMaterializeComposeBuild(t *testing.T) {
+	t.Setenv("SFA_DATA_HOME", t.TempDir())
+	dir := buildProject(t)
+	services := map[string]ServiceDef{
+		"api": {Build: &BuildDef{Args: map[string]string{"VERSION": "1.2"}}},
+	}
+	path, err := materializeCompose("builder", "1.0.0", services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := buildInputsHash(services["api"].Build)
+	want := "    image: sfa-builder-api\n    build:\n      context: \"" + dir + "\"\n      args:\n        VERSION: \"1.2\"\n      labels:\n        sfa.build-hash: \"" + hash + "\"\n"
+	if !strings.Contains(string(data), want) {
+		t.Errorf("expected %q in compose file:\n%s", want, data)
+	}
+
+	bad := map[string]ServiceDef{"api": {Build: &BuildDef{Dockerfile: "missing.Dockerfile"}}}
+	if _, err := materializeCompose("builder", "1.0.0", bad); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected a missing Dockerfile to be rejected, got %v", err)
+	}
+}
+
+func TestBuildInputsHash(t *testing.T) {
+	dir := buildProject(t)
+	build := &BuildDef{}
+	first, err := buildInputsHash(build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("ref: main\n"), 0644)
+	if again, _ := buildInputsHash(build); again != first {
+		t.Error("expected .git to be left out of the hash")
+	}
+
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644)
+	changed, _ := buildInputsHash(build)
+	if changed == first {
+		t.Error("expected a new file to change the hash")
+	}
+	if withArg, _ := buildInputsHash(&BuildDef{Args: map[string]string{"A": "1"}}); withArg == changed {
+		t.Error("expected a build arg to change the hash")
+	}
+}
+
+func TestBuildServicesSkipsCurrentImages(t *testing.T) {
+	buildProject(t)
+	orig := imageBuildHash
+	t.Cleanup(func() { imageBuildHash = orig })
+	var inspected []string
+	services := map[string]ServiceDef{
+		"api": {Image: "example/api:dev", Build: &BuildDef{}},
+		"db":  {Image: "postgres:16"},
+	}
+	hash, _ := buildInputsHash(services["api"].Build)
+	imageBuildHash = func(_ containerEngine, image string) string {
+		inspected = append(inspected, image)
+		return hash
+	}
+	if err := buildServices(containerEngine{name: EngineDocker}, "builder", "compose.yaml", services); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(inspected, ",") != "example/api:dev" {
+		t.Errorf("inspected %v, want only example/api:dev", inspected)
+	}
+}
@@ -0,0 +1,91 @@
+package sfa
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultCacheTTL applies when a cacheable agent does not set CacheTTL.
+const defaultCacheTTL = time.Hour
+
+// cacheRecord is the on-disk form of a cached result.
+type cacheRecord struct {
+	Key       string      `json:"key"`
+	Agent     string      `json:"agent"`
+	Version   string      `json:"version"`
+	CreatedAt string      `json:"createdAt"`
+	ExpiresAt string      `json:"expiresAt"`
+	Result    AgentResult `json:"result"`
+}
+
+// resolveCacheDir returns the result cache directory.
+func resolveCacheDir() string {
+	return dataDir("cache")
+}
+
+// computeCacheKey hashes everything that determines a deterministic agent's
+// result: its identity, the input, and the custom options.
+func computeCacheKey(agentName, version, input string, options map[string]any) string {
+	opts, _ := json.Marshal(options) // map keys are sorted by encoding/json
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", agentName, version, opts)
+	h.Write([]byte(input))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// cachePath returns the file holding a cached result.
+func cachePath(dir, agentName, key string) string {
+	return filepath.Join(dir, agentName, key+".json")
+}
+
+// lookupCache returns the cached result for key, or nil on a miss or expiry.
+func lookupCache(dir, agentName, key string) *AgentResult {
+	if dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(cachePath(dir, agentName, key))
+	if err != nil {
+		return nil
+	}
+
+	var rec cacheRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil
+	}
+	expires, err := time.Parse(time.RFC3339, rec.ExpiresAt)
+	if err != nil || time.Now().After(expires) {
+		return nil
+	}
+	return &rec.Result
+}
+
+// storeCache saves a successful result. Best-effort: failures are warned to stderr.
+func storeCache(dir, agentName, version, key string, result AgentResult, ttl time.Duration) {
+	if dir == "" {
+		return
+	}
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	now := time.Now().UTC()
+	data, err := json.Marshal(cacheRecord{
+		Key:       key,
+		Agent:     agentName,
+		Version:   version,
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: now.Add(ttl).Format(time.RFC3339),
+		Result:    result,
+	})
+	if err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to cache result: %v", err))
+		return
+	}
+	if err := writeFileAtomic(cachePath(dir, agentName, key), data, 0600); err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to cache result: %v", err))
+	}
+}
@@ -0,0 +1,66 @@
+package sfa
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestComputeCacheKey(t *testing.T) {
+	k1 := computeCacheKey("agent", "1.0.0", "input", map[string]any{"a": 1, "b": "x"})
+	k2 := computeCacheKey("agent", "1.0.0", "input", map[string]any{"b": "x", "a": 1})
+	if k1 != k2 {
+		t.Error("expected option order not to affect the key")
+	}
+
+	if k1 == computeCacheKey("agent", "1.0.1", "input", map[string]any{"a": 1, "b": "x"}) {
+		t.Error("expected version to change the key")
+	}
+	if k1 == computeCacheKey("agent", "1.0.0", "other", map[string]any{"a": 1, "b": "x"}) {
+		t.Error("expected input to change the key")
+	}
+	if k1 == computeCacheKey("agent", "1.0.0", "input", map[string]any{"a": 2, "b": "x"}) {
+		t.Error("expected options to change the key")
+	}
+}
+
+func TestCacheStoreAndLookup(t *testing.T) {
+	dir := t.TempDir()
+	key := computeCacheKey("agent", "1.0.0", "in", nil)
+
+	if lookupCache(dir, "agent", key) != nil {
+		t.Fatal("expected miss on empty cache")
+	}
+
+	storeCache(dir, "agent", "1.0.0", key, AgentResult{Result: "done"}, time.Minute)
+
+	hit := lookupCache(dir, "agent", key)
+	if hit == nil {
+		t.Fatal("expected cache hit")
+	}
+	if hit.Result != "done" {
+		t.Errorf("expected cached result 'done', got %v", hit.Result)
+	}
+}
+
+func TestCacheExpired(t *testing.T) {
+	dir := t.TempDir()
+	key := computeCacheKey("agent", "1.0.0", "in", nil)
+	storeCache(dir, "agent", "1.0.0", key, AgentResult{Result: "old"}, time.Nanosecond)
+
+	time.Sleep(1100 * time.Millisecond) // expiresAt has second granularity
+	if lookupCache(dir, "agent", key) != nil {
+		t.Error("expected expired entry to miss")
+	}
+}
+
+func TestCacheCorruptEntry(t *testing.T) {
+	dir := t.TempDir()
+	key := "corrupt"
+	os.MkdirAll(dir+"/agent", 0755)
+	os.WriteFile(cachePath(dir, "agent", key), []byte("{not json"), 0600)
+
+	if lookupCache(dir, "agent", key) != nil {
+		t.Error("expected corrupt entry to miss")
+	}
+}
@@ -0,0 +1,126 @@
+package sfa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CallHop is one agent invocation in the call chain.
+// Version and Start are empty when the hop was recorded by an agent that
+// only populated the legacy name-only SFA_CALL_CHAIN.
+type CallHop struct {
+	Name    string
+	Version string
+	Depth   int
+	Start   time.Time
+}
+
+// String encodes the hop as name@version:depth:start_ms for SFA_CALL_CHAIN_DETAIL.
+func (h CallHop) String() string {
+	start := int64(0)
+	if !h.Start.IsZero() {
+		start = h.Start.UnixMilli()
+	}
+	return fmt.Sprintf("%s@%s:%d:%d", h.Name, h.Version, h.Depth, start)
+}
+
+// Label returns name@version, or just the name when the version is unknown.
+func (h CallHop) Label() string {
+	if h.Version == "" {
+		return h.Name
+	}
+	return h.Name + "@" + h.Version
+}
+
+// parseCallHop decodes a hop from name@version:depth:start_ms.
+// A bare name (legacy form) yields a hop with only Name set.
+func parseCallHop(s string) CallHop {
+	s = strings.TrimSpace(s)
+	at := strings.Index(s, "@")
+	if at < 0 {
+		return CallHop{Name: s}
+	}
+
+	hop := CallHop{Name: s[:at]}
+	rest := s[at+1:]
+
+	// Split from the right so versions containing ':' survive
+	parts := strings.Split(rest, ":")
+	if len(parts) < 3 {
+		hop.Version = rest
+		return hop
+	}
+	hop.Version = strings.Join(parts[:len(parts)-2], ":")
+	hop.Depth, _ = strconv.Atoi(parts[len(parts)-2])
+	if ms, err := strconv.ParseInt(parts[len(parts)-1], 10, 64); err == nil && ms > 0 {
+		hop.Start = time.UnixMilli(ms).UTC()
+	}
+	return hop
+}
+
+// parseCallChain reconstructs hops from SFA_CALL_CHAIN (names) and
+// SFA_CALL_CHAIN_DETAIL (encoded hops). Names are authoritative: agents built
+// against older SDKs append only their name, so detail entries are matched to
+// names in order and any name without a matching detail gets a bare hop.
+func parseCallChain(names, detail string) []CallHop {
+	var nameList []string
+	if names != "" {
+		for _, n := range strings.Split(names, ",") {
+			nameList = append(nameList, parseCallHop(n).Name)
+		}
+	}
+
+	var detailHops []CallHop
+	if detail != "" {
+		for _, d := range strings.Split(detail, ",") {
+			detailHops = append(detailHops, parseCallHop(d))
+		}
+	}
+
+	hops := make([]CallHop, 0, len(nameList))
+	next := 0
+	for _, name := range nameList {
+		matched := false
+		for j := next; j < len(detailHops); j++ {
+			if detailHops[j].Name == name {
+				hops = append(hops, detailHops[j])
+				next = j + 1
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			hops = append(hops, CallHop{Name: name})
+		}
+	}
+	return hops
+}
+
+// hopNames returns the agent names of the given hops.
+func hopNames(hops []CallHop) []string {
+	names := make([]string, len(hops))
+	for i, h := range hops {
+		names[i] = h.Name
+	}
+	return names
+}
+
+// encodeCallChain renders hops for SFA_CALL_CHAIN_DETAIL.
+func encodeCallChain(hops []CallHop) string {
+	parts := make([]string, len(hops))
+	for i, h := range hops {
+		parts[i] = h.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// formatHops renders hops as a human-readable path for errors and progress.
+func formatHops(hops []CallHop) string {
+	labels := make([]string, len(hops))
+	for i, h := range hops {
+		labels[i] = h.Label()
+	}
+	return strings.Join(labels, " → ")
+}
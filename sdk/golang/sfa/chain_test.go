@@ -0,0 +1,80 @@
+package sfa
+
+import (
+	"testing"
+	"time"
+)
+
+// hopsOf builds bare hops from agent names.
+func hopsOf(names ...string) []CallHop {
+	hops := make([]CallHop, len(names))
+	for i, n := range names {
+		hops[i] = CallHop{Name: n}
+	}
+	return hops
+}
+
+func TestCallHopRoundTrip(t *testing.T) {
+	start := time.UnixMilli(1700000000123).UTC()
+	hop := CallHop{Name: "reviewer", Version: "1.2.0", Depth: 2, Start: start}
+
+	encoded := hop.String()
+	if encoded != "reviewer@1.2.0:2:1700000000123" {
+		t.Fatalf("unexpected encoding %q", encoded)
+	}
+
+	parsed := parseCallHop(encoded)
+	if parsed != hop {
+		t.Errorf("expected %+v, got %+v", hop, parsed)
+	}
+}
+
+func TestParseCallHopLegacyName(t *testing.T) {
+	hop := parseCallHop("planner")
+	if hop.Name != "planner" || hop.Version != "" || !hop.Start.IsZero() {
+		t.Errorf("expected bare hop, got %+v", hop)
+	}
+	if hop.Label() != "planner" {
+		t.Errorf("expected label planner, got %q", hop.Label())
+	}
+}
+
+func TestParseCallHopVersionWithColon(t *testing.T) {
+	hop := parseCallHop("svc@1.0.0:build:7:3:1700000000000")
+	if hop.Version != "1.0.0:build:7" || hop.Depth != 3 {
+		t.Errorf("expected version with colons preserved, got %+v", hop)
+	}
+}
+
+func TestParseCallChainMixed(t *testing.T) {
+	// legacy-agent was built against an older SDK and appended only its name
+	hops := parseCallChain("planner,legacy-agent,reviewer",
+		"planner@1.0.0:0:1700000000000,reviewer@0.2.0:2:1700000002000")
+
+	if len(hops) != 3 {
+		t.Fatalf("expected 3 hops, got %d", len(hops))
+	}
+	if hops[0].Label() != "planner@1.0.0" {
+		t.Errorf("expected planner@1.0.0, got %q", hops[0].Label())
+	}
+	if hops[1].Label() != "legacy-agent" {
+		t.Errorf("expected bare legacy-agent, got %q", hops[1].Label())
+	}
+	if hops[2].Label() != "reviewer@0.2.0" || hops[2].Depth != 2 {
+		t.Errorf("expected reviewer@0.2.0 at depth 2, got %+v", hops[2])
+	}
+}
+
+func TestParseCallChainNamesAuthoritative(t *testing.T) {
+	hops := parseCallChain("", "stale@1.0.0:0:1700000000000")
+	if len(hops) != 0 {
+		t.Errorf("expected detail without names to be ignored, got %v", hops)
+	}
+}
+
+func TestFormatHops(t *testing.T) {
+	hops := []CallHop{{Name: "a", Version: "1.0.0"}, {Name: "b"}}
+	if got := formatHops(hops); got != "a@1.0.0 → b" {
+		t.Errorf("unexpected format %q", got)
+	}
+}
@@ -0,0 +1,149 @@
+package sfa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// resumeLatest is the --resume value used when no session ID is given.
+const resumeLatest = "latest"
+
+// checkpointRecord is the on-disk form of a checkpoint.
+type checkpointRecord struct {
+	SessionID string          `json:"sessionId"`
+	Agent     string          `json:"agent"`
+	Version   string          `json:"version"`
+	Timestamp string          `json:"timestamp"`
+	State     json.RawMessage `json:"state"`
+}
+
+// resolveCheckpointDir returns the directory holding checkpoints.
+func resolveCheckpointDir() string {
+	return dataDir("checkpoints")
+}
+
+// checkpointPath returns the checkpoint file for an agent within a session.
+// Callers validate sessionID first.
+func checkpointPath(dir, sessionID, agentName string) string {
+	return filepath.Join(dir, sessionID, agentName+".json")
+}
+
+// saveCheckpoint serializes state and atomically replaces the agent's checkpoint.
+func saveCheckpoint(dir, sessionID, agentName, version string, state any) error {
+	if dir == "" {
+		return fmt.Errorf("failed to determine checkpoint directory")
+	}
+	if err := validateSessionID(sessionID); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to serialize checkpoint: %w", err)
+	}
+
+	data, err := json.Marshal(checkpointRecord{
+		SessionID: sessionID,
+		Agent:     agentName,
+		Version:   version,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		State:     raw,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize checkpoint: %w", err)
+	}
+
+	if err := writeFileAtomic(checkpointPath(dir, sessionID, agentName), data, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// loadCheckpoint returns the saved state for an agent within a session,
+// or nil if no checkpoint exists.
+func loadCheckpoint(dir, sessionID, agentName string) (json.RawMessage, error) {
+	if err := validateSessionID(sessionID); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(checkpointPath(dir, sessionID, agentName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var rec checkpointRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	return rec.State, nil
+}
+
+// clearCheckpoint removes an agent's checkpoint after a successful run.
+func clearCheckpoint(dir, sessionID, agentName string) {
+	if validateSessionID(sessionID) != nil {
+		return
+	}
+	path := checkpointPath(dir, sessionID, agentName)
+	os.Remove(path)
+	os.Remove(filepath.Dir(path)) // only succeeds once the session has no checkpoints left
+}
+
+// latestCheckpointSession finds the session of the agent's most recent checkpoint.
+func latestCheckpointSession(dir, agentName string) (string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*", agentName+".json"))
+
+	var latest string
+	var latestMod time.Time
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil || validateSessionID(filepath.Base(filepath.Dir(m))) != nil {
+			continue
+		}
+		if latest == "" || info.ModTime().After(latestMod) {
+			latest = m
+			latestMod = info.ModTime()
+		}
+	}
+	if latest == "" {
+		return "", false
+	}
+	return filepath.Base(filepath.Dir(latest)), true
+}
+
+// resolveResumeSession decides which session a --resume run continues and
+// pins it via SFA_SESSION_ID before safety initialization. Subagents inherit
+// SFA_RESUME=1 so they pick up their own checkpoints in the same session.
+func resolveResumeSession(dir, agentName, resume string) error {
+	if resume == "" {
+		return nil
+	}
+	os.Setenv("SFA_RESUME", "1")
+
+	if os.Getenv("SFA_SESSION_ID") != "" {
+		return nil
+	}
+
+	if resume != resumeLatest {
+		if err := validateSessionID(resume); err != nil {
+			return fmt.Errorf("invalid --resume: %w", err)
+		}
+		if _, err := os.Stat(checkpointPath(dir, resume, agentName)); err != nil {
+			return fmt.Errorf("no checkpoint found for %s in session %s", agentName, resume)
+		}
+		os.Setenv("SFA_SESSION_ID", resume)
+		return nil
+	}
+
+	if sessionID, ok := latestCheckpointSession(dir, agentName); ok {
+		os.Setenv("SFA_SESSION_ID", sessionID)
+		return nil
+	}
+	stderrLog.Warn(fmt.Sprintf("no checkpoint found for %s, starting fresh", agentName))
+	return nil
+}
@@ -0,0 +1,120 @@
+package sfa
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testProgress struct {
+	Processed []string `json:"processed"`
+	Cursor    int      `json:"cursor"`
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	state := testProgress{Processed: []string{"a.go", "b.go"}, Cursor: 2}
+
+	if err := saveCheckpoint(dir, "sess-1", "indexer", "1.0.0", state); err != nil {
+		t.Fatalf("failed to save checkpoint: %v", err)
+	}
+
+	raw, err := loadCheckpoint(dir, "sess-1", "indexer")
+	if err != nil {
+		t.Fatalf("failed to load checkpoint: %v", err)
+	}
+	var got testProgress
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("failed to decode state: %v", err)
+	}
+	if got.Cursor != 2 || len(got.Processed) != 2 {
+		t.Errorf("unexpected state %+v", got)
+	}
+
+	info, err := os.Stat(checkpointPath(dir, "sess-1", "indexer"))
+	if err != nil {
+		t.Fatalf("checkpoint file missing: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected 0600 permissions, got %o", info.Mode().Perm())
+	}
+}
+
+func TestLoadCheckpointMissing(t *testing.T) {
+	raw, err := loadCheckpoint(t.TempDir(), "sess-1", "indexer")
+	if err != nil || raw != nil {
+		t.Errorf("expected no state and no error, got %s / %v", raw, err)
+	}
+}
+
+func TestClearCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	saveCheckpoint(dir, "sess-1", "indexer", "1.0.0", map[string]int{"n": 1})
+	clearCheckpoint(dir, "sess-1", "indexer")
+
+	if _, err := os.Stat(checkpointPath(dir, "sess-1", "indexer")); !os.IsNotExist(err) {
+		t.Error("expected checkpoint to be removed")
+	}
+}
+
+func TestResolveResumeSessionLatest(t *testing.T) {
+	dir := t.TempDir()
+	saveCheckpoint(dir, "old-session", "indexer", "1.0.0", 1)
+	saveCheckpoint(dir, "new-session", "indexer", "1.0.0", 2)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(checkpointPath(dir, "new-session", "indexer"), future, future)
+
+	os.Unsetenv("SFA_SESSION_ID")
+	defer os.Unsetenv("SFA_SESSION_ID")
+	defer os.Unsetenv("SFA_RESUME")
+
+	if err := resolveResumeSession(dir, "indexer", resumeLatest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := os.Getenv("SFA_SESSION_ID"); got != "new-session" {
+		t.Errorf("expected new-session, got %q", got)
+	}
+	if os.Getenv("SFA_RESUME") != "1" {
+		t.Error("expected SFA_RESUME=1 for subagents")
+	}
+}
+
+func TestResolveResumeSessionExplicitMissing(t *testing.T) {
+	os.Unsetenv("SFA_SESSION_ID")
+	defer os.Unsetenv("SFA_RESUME")
+
+	if err := resolveResumeSession(t.TempDir(), "indexer", "nope"); err == nil {
+		t.Error("expected error for unknown session")
+	}
+}
+
+func TestCheckpointRejectsInvalidSession(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "checkpoints")
+	outside := filepath.Join(root, "x", "indexer.json")
+	if err := os.MkdirAll(filepath.Dir(outside), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outside, []byte(`{"state":{}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Unsetenv("SFA_SESSION_ID")
+	defer os.Unsetenv("SFA_RESUME")
+	if err := resolveResumeSession(dir, "indexer", "../x"); err == nil || !strings.Contains(err.Error(), "invalid --resume") {
+		t.Errorf("expected invalid --resume error, got: %v", err)
+	}
+	if _, err := loadCheckpoint(dir, "../x", "indexer"); err == nil {
+		t.Error("expected loadCheckpoint to reject the session ID")
+	}
+	if err := saveCheckpoint(dir, "../x", "indexer", "1.0.0", map[string]int{"n": 1}); err == nil {
+		t.Error("expected saveCheckpoint to reject the session ID")
+	}
+	clearCheckpoint(dir, "../x", "indexer")
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("clearCheckpoint removed a file outside the checkpoint directory: %v", err)
+	}
+}
@@ -11,22 +11,45 @@ import (
 
 // StandardFlags holds the parsed standard SFA CLI flags.
 type StandardFlags struct {
-	Help           bool
-	Version        bool
-	Verbose        bool
-	Quiet          bool
-	OutputFormat   OutputFormat
-	Timeout        int
-	Describe       bool
-	Setup          bool
-	NoLog          bool
-	MaxDepth       int
-	ServicesDown   bool
-	Yes            bool
-	NonInteractive bool
-	Context        string
-	ContextFile    string
-	MCP            bool
+	Help             bool
+	Version          bool
+	Verbose          bool
+	Quiet            bool
+	OutputFormat     OutputFormat
+	Timeout          int
+	Describe         bool
+	Setup            bool
+	NoLog            bool
+	MaxDepth         int
+	ServicesDown     bool
+	ServicesProfiles []string // compose profiles to start besides the default services
+	Yes              bool
+	NonInteractive   bool
+	Context          string
+	ContextFile      string
+	MCP              bool
+	Resume           string // session ID, or "latest" for bare --resume
+	NoCache          bool
+	MetricsPort      int // 0 disables the Prometheus endpoint
+	Explain          bool
+	ShowConfig       bool     // print the effective config and env with their sources
+	OutputFile       string   // write the result here (atomically) instead of stdout
+	Tee              bool     // with OutputFile, also write the result to stdout
+	Serve            string   // listen address for HTTP server mode; empty runs once
+	StdioProtocol    bool     // serve JSON-RPC requests over stdin/stdout
+	GRPC             string   // listen address for the gRPC AgentService
+	SetupSet         []string // --setup --set KEY=VALUE pairs
+	SetupFromJSON    string   // --setup --from-json file of values ("-" for stdin)
+	SetupExport      string   // --setup --export .env file to write ("-" for stdout)
+	SetupImport      string   // --setup --import .env file to read
+	SetupGlobal      bool     // --setup --global edits shared defaults, not the agent's namespace
+	Profile          string   // named config profile; also read from SFA_PROFILE
+}
+
+// resident reports whether the agent stays running to handle many requests
+// instead of executing once.
+func (f StandardFlags) resident() bool {
+	return f.Serve != "" || f.StdioProtocol || f.GRPC != ""
 }
 
 // ParsedArgs is the result of parsing CLI arguments.
@@ -35,6 +58,7 @@ type ParsedArgs struct {
 	Custom     map[string]any
 	Positional []string
 	Unknown    []string
+	Given      map[string]bool // standard and custom flags set on the command line
 }
 
 // parseArgs parses CLI arguments into standard flags, custom options, and positional args.
@@ -54,11 +78,29 @@ func parseArgs(argv []string, customOptions []OptionDef) (*ParsedArgs, error) {
 	noLog := fs.Bool("no-log", false, "Suppress execution logging")
 	maxDepth := fs.Int("max-depth", 5, "Maximum invocation depth")
 	servicesDown := fs.Bool("services-down", false, "Tear down Docker services")
+	servicesProfiles := fs.StringSlice("services-profile", nil, "Also start services in this compose profile (repeatable)")
 	yes := fs.Bool("yes", false, "Auto-confirm prompts")
 	nonInteractive := fs.Bool("non-interactive", false, "Non-interactive mode")
 	contextFlag := fs.String("context", "", "Context input string")
 	contextFile := fs.String("context-file", "", "Context input file path")
 	mcp := fs.Bool("mcp", false, "Run as MCP server")
+	resume := fs.String("resume", "", "Resume from the last checkpoint")
+	fs.Lookup("resume").NoOptDefVal = resumeLatest
+	noCache := fs.Bool("no-cache", false, "Bypass the result cache")
+	metricsPort := fs.Int("metrics-port", 0, "Expose Prometheus metrics on this port")
+	explain := fs.Bool("explain", false, "Print the execution plan and exit")
+	showConfig := fs.Bool("show-config", false, "Print the effective configuration and exit")
+	outputFile := fs.String("output-file", "", "Write the result to a file instead of stdout")
+	tee := fs.Bool("tee", false, "With --output-file, also write the result to stdout")
+	serve := fs.String("serve", "", "Run as an HTTP server on this address")
+	stdioProtocol := fs.Bool("stdio-protocol", false, "Handle JSON-RPC requests over stdin/stdout")
+	grpcAddr := fs.String("grpc", "", "Serve the gRPC AgentService on this address")
+	setupSet := fs.StringArray("set", nil, "With --setup, set KEY=VALUE without prompting (repeatable)")
+	setupFromJSON := fs.String("from-json", "", "With --setup, read values from a JSON file")
+	setupExport := fs.String("export", "", "With --setup, write the configuration to a .env file")
+	setupImport := fs.String("import", "", "With --setup, read the configuration from a .env file")
+	setupGlobal := fs.Bool("global", false, "With --setup, edit shared defaults instead of the agent's settings")
+	profile := fs.String("profile", "", "Use a named config profile")
 
 	// Custom option flags
 	customPtrs := make(map[string]any)
@@ -117,6 +159,11 @@ func parseArgs(argv []string, customOptions []OptionDef) (*ParsedArgs, error) {
 		return nil, err
 	}
 
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
 	// Collect unknown flags (pflag doesn't provide a clean way, so we detect them)
 	var unknown []string
 	// Check for flags that weren't defined
@@ -193,28 +240,83 @@ func parseArgs(argv []string, customOptions []OptionDef) (*ParsedArgs, error) {
 		return nil, fmt.Errorf("invalid output format: %s (expected json or text)", *outputFormat)
 	}
 
+	if *metricsPort < 0 || *metricsPort > 65535 {
+		return nil, fmt.Errorf("invalid metrics port: %d", *metricsPort)
+	}
+
+	if *tee && *outputFile == "" {
+		return nil, fmt.Errorf("--tee requires --output-file")
+	}
+
+	modes := 0
+	for _, on := range []bool{*serve != "", *stdioProtocol, *grpcAddr != ""} {
+		if on {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return nil, fmt.Errorf("only one of --serve, --stdio-protocol, and --grpc may be used")
+	}
+
+	setupModes := 0
+	for _, on := range []bool{len(*setupSet) > 0 || *setupFromJSON != "", *setupExport != "", *setupImport != ""} {
+		if on {
+			setupModes++
+		}
+	}
+	if setupModes > 0 && !*setup {
+		return nil, fmt.Errorf("--set, --from-json, --export, and --import require --setup")
+	}
+	if setupModes > 1 {
+		return nil, fmt.Errorf("only one of --set/--from-json, --export, and --import may be used")
+	}
+	if *setupGlobal && !*setup {
+		return nil, fmt.Errorf("--global requires --setup")
+	}
+	if *setupGlobal && (*setupExport != "" || *setupImport != "") {
+		return nil, fmt.Errorf("--global cannot be used with --export or --import")
+	}
+
 	return &ParsedArgs{
 		Flags: StandardFlags{
-			Help:           *help,
-			Version:        *version,
-			Verbose:        *verbose,
-			Quiet:          *quiet,
-			OutputFormat:   of,
-			Timeout:        *timeout,
-			Describe:       *describe,
-			Setup:          *setup,
-			NoLog:          *noLog,
-			MaxDepth:       *maxDepth,
-			ServicesDown:   *servicesDown,
-			Yes:            *yes,
-			NonInteractive: *nonInteractive,
-			Context:        *contextFlag,
-			ContextFile:    *contextFile,
-			MCP:            *mcp,
+			Help:             *help,
+			Version:          *version,
+			Verbose:          *verbose,
+			Quiet:            *quiet,
+			OutputFormat:     of,
+			Timeout:          *timeout,
+			Describe:         *describe,
+			Setup:            *setup,
+			NoLog:            *noLog,
+			MaxDepth:         *maxDepth,
+			ServicesDown:     *servicesDown,
+			ServicesProfiles: *servicesProfiles,
+			Yes:              *yes,
+			NonInteractive:   *nonInteractive,
+			Context:          *contextFlag,
+			ContextFile:      *contextFile,
+			MCP:              *mcp,
+			Resume:           *resume,
+			NoCache:          *noCache,
+			MetricsPort:      *metricsPort,
+			Explain:          *explain,
+			ShowConfig:       *showConfig,
+			OutputFile:       *outputFile,
+			Tee:              *tee,
+			Serve:            *serve,
+			StdioProtocol:    *stdioProtocol,
+			GRPC:             *grpcAddr,
+			SetupSet:         *setupSet,
+			SetupFromJSON:    *setupFromJSON,
+			SetupExport:      *setupExport,
+			SetupImport:      *setupImport,
+			SetupGlobal:      *setupGlobal,
+			Profile:          *profile,
 		},
 		Custom:     custom,
 		Positional: fs.Args(),
 		Unknown:    unknown,
+		Given:      given,
 	}, nil
 }
 
@@ -264,12 +366,29 @@ func generateHelp(def *AgentDef) string {
 	b.WriteString("  --context STRING      Context input string\n")
 	b.WriteString("  --context-file PATH   Context input file path\n")
 	b.WriteString("  --setup               Interactive environment variable setup\n")
+	b.WriteString("  --set KEY=VALUE       With --setup, set a value without prompting\n")
+	b.WriteString("  --from-json PATH      With --setup, read values from a JSON file\n")
+	b.WriteString("  --export PATH         With --setup, write the configuration to a .env file\n")
+	b.WriteString("  --import PATH         With --setup, read the configuration from a .env file\n")
+	b.WriteString("  --global              With --setup, edit shared defaults for all agents\n")
+	b.WriteString("  --profile NAME        Use a named config profile\n")
 	b.WriteString("  --no-log              Suppress execution logging\n")
 	b.WriteString("  --max-depth N         Maximum invocation depth (default: 5)\n")
 	b.WriteString("  --services-down       Tear down Docker services\n")
+	b.WriteString("  --services-profile P  Also start services in compose profile P\n")
 	b.WriteString("  --yes                 Auto-confirm prompts\n")
 	b.WriteString("  --non-interactive     Non-interactive mode\n")
 	b.WriteString("  --mcp                 Run as MCP server\n")
+	b.WriteString("  --resume[=SESSION]    Resume from the last checkpoint\n")
+	b.WriteString("  --no-cache            Bypass the result cache\n")
+	b.WriteString("  --metrics-port PORT   Expose Prometheus metrics on PORT\n")
+	b.WriteString("  --explain             Print the execution plan and exit\n")
+	b.WriteString("  --show-config         Print the effective configuration with sources and exit\n")
+	b.WriteString("  --output-file PATH    Write the result to PATH instead of stdout\n")
+	b.WriteString("  --tee                 With --output-file, also write the result to stdout\n")
+	b.WriteString("  --serve ADDR          Run as an HTTP server on ADDR (e.g. :8080, loopback only)\n")
+	b.WriteString("  --stdio-protocol      Handle JSON-RPC requests over stdin/stdout\n")
+	b.WriteString("  --grpc ADDR           Serve the gRPC AgentService on ADDR (TLS)\n")
 
 	if len(def.Options) > 0 {
 		b.WriteString("\nAGENT OPTIONS:\n")
@@ -311,14 +430,20 @@ func generateDescribe(def *AgentDef, resolvedEnv map[string]string, secrets map[
 		"description": def.Description,
 	}
 
-	if def.TrustLevel != "" {
-		desc["trustLevel"] = string(def.TrustLevel)
-	}
+	desc["trustLevel"] = string(def.describedTrustLevel())
 
 	if def.ContextRequired {
 		desc["contextRequired"] = true
 	}
 
+	if def.Cacheable {
+		desc["cacheable"] = true
+	}
+
+	if def.LoopPolicy.Mode != "" && def.LoopPolicy.Mode != LoopDeny {
+		desc["loopPolicy"] = def.LoopPolicy.String()
+	}
+
 	if len(def.Env) > 0 {
 		envList := make([]map[string]any, 0, len(def.Env))
 		for _, e := range def.Env {
@@ -329,6 +454,18 @@ func generateDescribe(def *AgentDef, resolvedEnv map[string]string, secrets map[
 			if e.Secret {
 				entry["secret"] = true
 			}
+			if e.Source != "" {
+				entry["source"] = e.Source
+			}
+			if e.Type != "" {
+				entry["type"] = e.Type
+			}
+			if len(e.Enum) > 0 {
+				entry["enum"] = e.Enum
+			}
+			if e.Pattern != "" {
+				entry["pattern"] = e.Pattern
+			}
 			if e.Description != "" {
 				entry["description"] = e.Description
 			}
@@ -374,17 +511,26 @@ func generateDescribe(def *AgentDef, resolvedEnv map[string]string, secrets map[
 		desc["options"] = optList
 	}
 
+	if len(def.ConfigSchema) > 0 {
+		desc["config"] = describeConfigSchema(def.ConfigSchema)
+	}
+
 	if len(def.Services) > 0 {
 		desc["requiresDocker"] = true
 		svcNames := make([]string, 0, len(def.Services))
-		for name := range def.Services {
+		for name, svc := range def.Services {
 			svcNames = append(svcNames, name)
+			if svc.GPUs != "" {
+				desc["requiresGPU"] = true
+			}
 		}
 		desc["services"] = svcNames
 	} else {
 		desc["requiresDocker"] = false
 	}
 
+	desc["errors"] = describeErrors(def.Errors)
+
 	desc["mcpSupported"] = false
 
 	return desc
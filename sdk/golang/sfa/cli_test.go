@@ -1,6 +1,7 @@
 package sfa
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -41,6 +42,7 @@ func TestParseArgsAllStandardFlags(t *testing.T) {
 		"--verbose", "--quiet", "--output-format", "json",
 		"--timeout", "60", "--setup", "--no-log",
 		"--max-depth", "3", "--services-down", "--yes",
+		"--services-profile", "admin", "--services-profile", "debug,seed",
 		"--non-interactive", "--mcp",
 		"--context", "hello world",
 		"--context-file", "/tmp/ctx.txt",
@@ -73,6 +75,9 @@ func TestParseArgsAllStandardFlags(t *testing.T) {
 	if !args.Flags.ServicesDown {
 		t.Error("expected services-down")
 	}
+	if got := strings.Join(args.Flags.ServicesProfiles, ","); got != "admin,debug,seed" {
+		t.Errorf("expected services-profile admin,debug,seed, got %s", got)
+	}
 	if !args.Flags.Yes {
 		t.Error("expected yes")
 	}
@@ -158,6 +163,52 @@ func TestParseArgsCustomOptionWithAlias(t *testing.T) {
 	}
 }
 
+func TestParseArgsResume(t *testing.T) {
+	args, err := parseArgs([]string{"--resume"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Flags.Resume != resumeLatest {
+		t.Errorf("expected bare --resume to mean latest, got %q", args.Flags.Resume)
+	}
+
+	args, err = parseArgs([]string{"--resume=sess-42"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Flags.Resume != "sess-42" {
+		t.Errorf("expected sess-42, got %q", args.Flags.Resume)
+	}
+}
+
+func TestParseArgsMetricsPort(t *testing.T) {
+	args, err := parseArgs([]string{"--metrics-port", "9464"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Flags.MetricsPort != 9464 {
+		t.Errorf("expected 9464, got %d", args.Flags.MetricsPort)
+	}
+
+	if _, err := parseArgs([]string{"--metrics-port", "70000"}, nil); err == nil {
+		t.Error("expected error for out-of-range port")
+	}
+}
+
+func TestParseArgsOutputFile(t *testing.T) {
+	args, err := parseArgs([]string{"--output-file", "out.json", "--tee"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Flags.OutputFile != "out.json" || !args.Flags.Tee {
+		t.Errorf("expected output file with tee, got %q %v", args.Flags.OutputFile, args.Flags.Tee)
+	}
+
+	if _, err := parseArgs([]string{"--tee"}, nil); err == nil {
+		t.Error("expected error for --tee without --output-file")
+	}
+}
+
 func TestReadInputFromContext(t *testing.T) {
 	input, err := readInput(StandardFlags{Context: "test data"})
 	if err != nil {
@@ -264,3 +315,46 @@ func containsSubstr(s, sub string) bool {
 	}
 	return false
 }
+
+func TestParseArgsResidentModes(t *testing.T) {
+	args, err := parseArgs([]string{"--stdio-protocol"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !args.Flags.StdioProtocol || !args.Flags.resident() {
+		t.Error("expected --stdio-protocol to make the agent resident")
+	}
+	args, _ = parseArgs([]string{"--serve", ":8080"}, nil)
+	if args.Flags.Serve != ":8080" || !args.Flags.resident() {
+		t.Error("expected --serve to make the agent resident")
+	}
+	if _, err := parseArgs([]string{"--serve", ":8080", "--grpc", ":9090"}, nil); err == nil {
+		t.Error("expected error combining resident modes")
+	}
+}
+
+func TestParseArgsSetupValues(t *testing.T) {
+	args, err := parseArgs([]string{"--setup", "--set", "A=1", "--set", "B=x=y", "--from-json", "vals.json"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args.Flags.SetupSet) != 2 || args.Flags.SetupSet[1] != "B=x=y" {
+		t.Errorf("unexpected --set values: %v", args.Flags.SetupSet)
+	}
+	if args.Flags.SetupFromJSON != "vals.json" {
+		t.Errorf("unexpected --from-json: %q", args.Flags.SetupFromJSON)
+	}
+	if _, err := parseArgs([]string{"--set", "A=1"}, nil); err == nil {
+		t.Error("expected error for --set without --setup")
+	}
+}
+
+func TestParseArgsProfile(t *testing.T) {
+	args, err := parseArgs([]string{"--profile", "prod"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Flags.Profile != "prod" || len(args.Unknown) != 0 {
+		t.Errorf("unexpected --profile parse: %q, unknown %v", args.Flags.Profile, args.Unknown)
+	}
+}
@@ -0,0 +1,164 @@
+package sfa
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// composeHash returns a SHA256 hash of the compose content for change detection.
+func composeHash(content string) string {
+	h := sha256.Sum256([]byte(content))
+	return fmt.Sprintf("%x", h)
+}
+
+// composeHashes is the .template-hash file beside compose.yaml: the hash
+// of the whole compose file and its .env, then each service's.
+type composeHashes struct {
+	File     string
+	Services map[string]string
+}
+
+// hashCompose hashes compose content. A service's hash covers its block
+// and the values of the variables it references, so a new image tag,
+// port, or rotated password changes only that service's hash.
+func hashCompose(content string) composeHashes {
+	hashes := composeHashes{
+		File:     composeHash(content + composeEnvFile(content)),
+		Services: make(map[string]string),
+	}
+	for name, block := range composeServiceBlocks(content) {
+		hashes.Services[name] = composeHash(block + composeEnvFile(block))
+	}
+	return hashes
+}
+
+// composeServiceBlocks splits the services section of a compose file
+// written by materializeCompose into each service's lines.
+func composeServiceBlocks(content string) map[string]string {
+	blocks := make(map[string]string)
+	inServices := false
+	var name string
+	for _, line := range strings.SplitAfter(content, "\n") {
+		switch {
+		case strings.HasPrefix(line, "services:"):
+			inServices = true
+		case line != "" && line[0] != ' ':
+			inServices = false
+		case inServices && strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "   "):
+			name = strings.TrimSuffix(strings.TrimSpace(line), ":")
+			blocks[name] = line
+		case inServices && name != "":
+			blocks[name] += line
+		}
+	}
+	return blocks
+}
+
+// format renders the .template-hash file: the file hash on the first line,
+// so the TypeScript SDK can compare it, then "<service> <hash>" lines.
+func (h composeHashes) format() string {
+	var b strings.Builder
+	b.WriteString(h.File + "\n")
+	for _, name := range sortedKeys(h.Services) {
+		b.WriteString(fmt.Sprintf("%s %s\n", name, h.Services[name]))
+	}
+	return b.String()
+}
+
+// readComposeHashes reads the hashes saved by the last run, or ok false if
+// there are none.
+func readComposeHashes(path string) (hashes composeHashes, ok bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			stderrLog.Warn(fmt.Sprintf("ignoring unreadable %s: %v", path, err))
+		}
+		return composeHashes{}, false
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	hashes = composeHashes{File: strings.TrimSpace(lines[0]), Services: make(map[string]string)}
+	for _, line := range lines[1:] {
+		if name, hash, found := strings.Cut(strings.TrimSpace(line), " "); found {
+			hashes.Services[name] = hash
+		}
+	}
+	return hashes, hashes.File != ""
+}
+
+// changedServices compares saved hashes with the current ones, returning
+// the services whose definition changed and those no longer declared.
+// Hashes from before per-service hashes were saved mark every service
+// changed when the file differs.
+func changedServices(saved, current composeHashes) (changed, removed []string) {
+	if saved.File == current.File {
+		return nil, nil
+	}
+	for name, hash := range current.Services {
+		if old, ok := saved.Services[name]; !ok && len(saved.Services) > 0 {
+			continue // new service; compose up creates it
+		} else if old != hash {
+			changed = append(changed, name)
+		}
+	}
+	for name := range saved.Services {
+		if _, ok := current.Services[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return changed, removed
+}
+
+// recreateChangedServices stops and removes the containers of services
+// whose compose definition changed since the last run, so the compose up
+// that follows recreates them instead of leaving stale containers running
+// (Podman's compose does not recreate them itself). It returns whether
+// services were removed from the definition, whose containers compose up
+// should remove as orphans. The new hashes are saved by saveComposeHashes
+// once compose up succeeds.
+func recreateChangedServices(engine containerEngine, agentName, composePath string) (orphans bool, err error) {
+	content, err := os.ReadFile(composePath)
+	if err != nil {
+		return false, err
+	}
+	saved, ok := readComposeHashes(composeHashPath(composePath))
+	if !ok {
+		return false, nil
+	}
+	changed, removed := changedServices(saved, hashCompose(string(content)))
+	if len(changed) > 0 {
+		emitProgress(agentName, fmt.Sprintf("service definition changed, recreating %s", strings.Join(changed, ", ")))
+		cmd := engine.composeCommand(composePath, append([]string{"rm", "-s", "-f"}, changed...)...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return false, fmt.Errorf("failed to remove changed services: %w", err)
+		}
+	}
+	if len(removed) > 0 {
+		emitProgress(agentName, fmt.Sprintf("services no longer declared, removing %s", strings.Join(removed, ", ")))
+	}
+	return len(removed) > 0, nil
+}
+
+// saveComposeHashes records the hashes of the compose file just brought up.
+func saveComposeHashes(composePath string) {
+	content, err := os.ReadFile(composePath)
+	if err == nil {
+		err = writeFileAtomic(composeHashPath(composePath), []byte(hashCompose(string(content)).format()), 0600)
+	}
+	if err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to save compose hashes: %v", err))
+	}
+}
+
+// composeHashPath returns the .template-hash file beside a compose file.
+func composeHashPath(composePath string) string {
+	return filepath.Join(filepath.Dir(composePath), ".template-hash")
+}
@@ -0,0 +1,66 @@
+package sfa
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChangedServices(t *testing.T) {
+	t.Setenv("SFA_DATA_HOME", t.TempDir())
+	t.Setenv("DB_PASSWORD", "one")
+	services := map[string]ServiceDef{
+		"db":    {Image: "postgres:16", Ports: []string{"5432:5432"}, Environment: map[string]string{"POSTGRES_PASSWORD": "${DB_PASSWORD}"}},
+		"cache": {Image: "redis:7"},
+		"old":   {Image: "memcached:1"},
+	}
+	hashesFor := func(services map[string]ServiceDef) composeHashes {
+		path, err := materializeCompose("hasher", "1.0.0", services)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hashCompose(string(content))
+	}
+	saved := hashesFor(services)
+	if got := strings.Join(sortedKeys(saved.Services), ","); got != "cache,db,old" {
+		t.Fatalf("hashed services = %s", got)
+	}
+	if changed, removed := changedServices(saved, hashesFor(services)); changed != nil || removed != nil {
+		t.Errorf("expected no changes, got %v, %v", changed, removed)
+	}
+
+	delete(services, "old")
+	services["cache"] = ServiceDef{Image: "redis:7.2"}
+	services["queue"] = ServiceDef{Image: "rabbitmq:3"}
+	t.Setenv("DB_PASSWORD", "two")
+	changed, removed := changedServices(saved, hashesFor(services))
+	if strings.Join(changed, ",") != "cache,db" || strings.Join(removed, ",") != "old" {
+		t.Errorf("changed = %v, removed = %v; want [cache db], [old]", changed, removed)
+	}
+
+	// A file hash alone, as the TypeScript SDK saves it, marks all changed
+	changed, _ = changedServices(composeHashes{File: "abc"}, hashesFor(services))
+	if strings.Join(changed, ",") != "cache,db,queue" {
+		t.Errorf("changed = %v, want all services", changed)
+	}
+}
+
+func TestComposeHashesRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".template-hash")
+	if _, ok := readComposeHashes(path); ok {
+		t.Error("expected no hashes before any were saved")
+	}
+	want := composeHashes{File: "f00", Services: map[string]string{"db": "d0", "cache": "c0"}}
+	if err := os.WriteFile(path, []byte(want.format()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := readComposeHashes(path)
+	if !ok || got.File != "f00" || got.Services["db"] != "d0" || got.Services["cache"] != "c0" {
+		t.Errorf("read %+v, %v; want %+v", got, ok, want)
+	}
+}
@@ -1,63 +1,133 @@
 package sfa
 
 import (
-	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 )
 
+// configFileNames are the shared config files looked for in the config
+// directory, in order; the first that exists is used.
+var configFileNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
 // getConfigPath returns the shared config file path.
-// Priority: SFA_CONFIG env > ~/.config/single-file-agents/config.json.
+// Priority: SFA_CONFIG env > the first existing config.{json,yaml,yml,toml}
+// in the config directory (configDir) > config.json there.
 func getConfigPath() string {
 	if p := os.Getenv("SFA_CONFIG"); p != "" {
 		return p
 	}
-	home, err := os.UserHomeDir()
-	if err != nil {
+	dir := configDir()
+	if dir == "" {
 		return ""
 	}
-	return filepath.Join(home, ".config", "single-file-agents", "config.json")
+	for _, name := range configFileNames {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return filepath.Join(dir, name)
+		}
+	}
+	return filepath.Join(dir, configFileNames[0])
 }
 
-// loadConfig reads and parses the shared config file.
-// Returns an empty map if the file doesn't exist or can't be parsed.
+// loadConfig reads and parses the shared config file as JSON, YAML, or
+// TOML by its extension, warning about anything that doesn't match the
+// schema. Returns an empty map if the file doesn't exist or can't be parsed;
+// a read or parse failure is also warned.
 func loadConfig() map[string]any {
 	path := getConfigPath()
 	if path == "" {
 		return make(map[string]any)
 	}
 
-	data, err := os.ReadFile(path)
+	config, err := readConfigFile(path)
 	if err != nil {
+		stderrLog.Warn(err.Error())
 		return make(map[string]any)
 	}
+	warnConfigProblems(path, config)
 
-	var config map[string]any
-	if err := json.Unmarshal(data, &config); err != nil {
-		return make(map[string]any)
+	return config
+}
+
+// readConfigFile decodes the config file at path. A missing file is an
+// empty config.
+func readConfigFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return make(map[string]any), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config %s: %w", path, err)
 	}
 
-	return config
+	config, err := decodeConfig(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("ignoring malformed config %s: %w", path, err)
+	}
+	if config == nil {
+		config = make(map[string]any)
+	}
+	return config, nil
 }
 
-// saveConfig writes the config to the shared config file.
+// warnConfigProblems reports schema violations in a config file on stderr.
+func warnConfigProblems(path string, config map[string]any) {
+	for _, problem := range validateConfig(config) {
+		stderrLog.Warn(fmt.Sprintf("config %s: %s", path, problem))
+	}
+}
+
+// saveConfig replaces the shared config file with config, in the file's
+// format. Setup uses updateConfig instead, so concurrent writers don't
+// clobber each other.
 func saveConfig(config map[string]any) error {
 	path := getConfigPath()
 	if path == "" {
 		return nil
 	}
 
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+	data, err := encodeConfig(path, config)
+	if err != nil {
 		return err
 	}
+	return withFileLock(path, func() error {
+		return writeFileAtomic(path, data, configFileMode(path))
+	})
+}
 
-	data, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		return err
+// updateConfig applies fn to the shared config and writes it back. The file
+// is re-read under an advisory lock and replaced atomically, so concurrent
+// updates to different keys (such as two agents' namespaces) both survive.
+// A file that cannot be read or parsed is left alone.
+func updateConfig(fn func(config map[string]any) error) error {
+	path := getConfigPath()
+	if path == "" {
+		return nil
 	}
-	data = append(data, '\n')
 
-	return os.WriteFile(path, data, 0644)
+	return withFileLock(path, func() error {
+		config, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+		if err := fn(config); err != nil {
+			return err
+		}
+		data, err := encodeConfig(path, config)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, data, configFileMode(path))
+	})
+}
+
+// configFileMode keeps an existing config file's permissions, so a file
+// the user restricted stays restricted.
+func configFileMode(path string) os.FileMode {
+	if info, err := os.Stat(path); err == nil {
+		return info.Mode().Perm()
+	}
+	return 0644
 }
 
 // mergeConfig returns a merged config from defaults and the agent namespace.
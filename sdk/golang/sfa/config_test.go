@@ -2,8 +2,10 @@ package sfa
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 )
 
@@ -125,3 +127,57 @@ func TestGetConfigPathFromEnv(t *testing.T) {
 		t.Errorf("expected /custom/config.json, got %s", path)
 	}
 }
+
+func TestUpdateConfigConcurrent(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("SFA_CONFIG", configPath)
+	if err := writeTestFile(configPath, `{"defaults": {"timeout": 30}}`); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(configPath, 0600)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := saveAgentEnv(fmt.Sprintf("agent-%d", i), nil, map[string]any{"N": fmt.Sprint(i)})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	config := loadConfig()
+	for i := 0; i < 20; i++ {
+		if got := agentEnvNamespace(config, fmt.Sprintf("agent-%d", i))["N"]; got != fmt.Sprint(i) {
+			t.Errorf("agent-%d: expected its update to survive, got %v", i, got)
+		}
+	}
+	if config["defaults"].(map[string]any)["timeout"] != 30.0 {
+		t.Error("expected existing keys kept")
+	}
+	if info, _ := os.Stat(configPath); info.Mode().Perm() != 0600 {
+		t.Errorf("expected file mode kept, got %v", info.Mode().Perm())
+	}
+}
+
+func TestUpdateConfigLeavesMalformedFile(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("SFA_CONFIG", configPath)
+	if err := writeTestFile(configPath, `{"defaults": `); err != nil {
+		t.Fatal(err)
+	}
+
+	err := updateConfig(func(config map[string]any) error {
+		config["defaults"] = map[string]any{}
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected an error for a malformed config")
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != `{"defaults": ` {
+		t.Errorf("expected malformed file untouched, got %q", data)
+	}
+}
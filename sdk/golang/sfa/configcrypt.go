@@ -0,0 +1,196 @@
+package sfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Secret env values in the shared config may be stored encrypted, as
+// "enc:v1:" followed by base64 of an ephemeral X25519 public key, a nonce,
+// and AES-256-GCM ciphertext. The AES key is HKDF-SHA256 of the X25519
+// shared secret with the config key's public half (the "recipient", kept in
+// config at secrets.recipient). The private half lives in the OS keychain,
+// so anyone can encrypt but only the owner's machine can decrypt. The
+// ciphertext is bound to "<agent>/<VAR>", so values cannot be swapped.
+
+const (
+	encryptedPrefix  = "enc:v1:"
+	configKeyAccount = "config-key" // keychain account of the private key
+	configKeyInfo    = "sfa-config-secret-v1"
+)
+
+// configKey caches the private key read from the keychain.
+var configKey struct {
+	sync.Mutex
+	key *ecdh.PrivateKey
+}
+
+// isEncrypted reports whether a config value is an encrypted secret.
+func isEncrypted(val string) bool {
+	return strings.HasPrefix(val, encryptedPrefix)
+}
+
+// configRecipient returns the public key named by secrets.recipient, or
+// nil if encryption is not enabled.
+func configRecipient(config map[string]any) (*ecdh.PublicKey, error) {
+	sm, _ := config["secrets"].(map[string]any)
+	r, _ := sm["recipient"].(string)
+	if r == "" {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets.recipient: %w", err)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets.recipient: %w", err)
+	}
+	return pub, nil
+}
+
+// encryptSecret encrypts plaintext for recipient, bound to the agent/VAR
+// account it is stored under.
+func encryptSecret(recipient *ecdh.PublicKey, account, plaintext string) (string, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	aead, err := secretAEAD(eph, recipient, eph.PublicKey(), recipient)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := append(eph.PublicKey().Bytes(), nonce...)
+	out = aead.Seal(out, nonce, []byte(plaintext), []byte(account))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(out), nil
+}
+
+// decryptSecret reverses encryptSecret with the private key.
+func decryptSecret(key *ecdh.PrivateKey, account, val string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(val, encryptedPrefix))
+	if err != nil || len(raw) < 32 {
+		return "", errors.New("malformed encrypted value")
+	}
+	eph, err := ecdh.X25519().NewPublicKey(raw[:32])
+	if err != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+	aead, err := secretAEAD(key, eph, eph, key.PublicKey())
+	if err != nil {
+		return "", err
+	}
+	rest := raw[32:]
+	if len(rest) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plain, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], []byte(account))
+	if err != nil {
+		return "", errors.New("cannot decrypt: encrypted with a different key or for a different variable")
+	}
+	return string(plain), nil
+}
+
+// secretAEAD derives the AES-GCM cipher from the X25519 exchange between
+// priv and peer, salted with both the ephemeral and recipient public keys.
+func secretAEAD(priv *ecdh.PrivateKey, peer, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	block, err := aes.NewCipher(hkdfSHA256(shared, salt, []byte(configKeyInfo), 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdfSHA256 implements RFC 5869 for output up to one hash length, which is
+// all a single AES key needs.
+func hkdfSHA256(secret, salt, info []byte, n int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:n]
+}
+
+// loadConfigKey reads the private config key from the keychain once per
+// process.
+func loadConfigKey() (*ecdh.PrivateKey, error) {
+	configKey.Lock()
+	defer configKey.Unlock()
+	if configKey.key != nil {
+		return configKey.key, nil
+	}
+	encoded, err := keyring.get(keyringService, configKeyAccount)
+	if errors.Is(err, errKeyringNotFound) {
+		return nil, errors.New("the config encryption key is not in the keychain (run 'sfa secrets rotate-key' to create one)")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("keyring lookup for the config encryption key failed: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("the config encryption key in the keychain is malformed")
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, errors.New("the config encryption key in the keychain is malformed")
+	}
+	configKey.key = key
+	return key, nil
+}
+
+// decryptConfigValue returns val, decrypted if it is encrypted.
+func decryptConfigValue(agentName, varName, val string) (string, error) {
+	if !isEncrypted(val) {
+		return val, nil
+	}
+	key, err := loadConfigKey()
+	if err != nil {
+		return "", err
+	}
+	plain, err := decryptSecret(key, keyringAccount(agentName, varName), val)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", varName, err)
+	}
+	return plain, nil
+}
+
+// sealSecrets encrypts the plaintext values of an agent's secret env vars
+// in config, if secrets.recipient enables encryption. Secret references
+// (vault:, op://) are left as they are.
+func sealSecrets(config map[string]any, agentName string, declarations []EnvDef) error {
+	recipient, err := configRecipient(config)
+	if err != nil || recipient == nil {
+		return err
+	}
+	envMap := agentEnvNamespace(config, agentName)
+	for _, decl := range declarations {
+		val, ok := envMap[decl.Name].(string)
+		if !decl.Secret || !ok || val == "" || isEncrypted(val) || secretProviderFor(val) != nil {
+			continue
+		}
+		sealed, err := encryptSecret(recipient, keyringAccount(agentName, decl.Name), val)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", decl.Name, err)
+		}
+		envMap[decl.Name] = sealed
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package sfa
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useConfigKey stores a fresh config key in a fake keyring and returns it.
+func useConfigKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	fake := useFakeKeyring(t)
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.items[keyringService+"|"+configKeyAccount] = base64.StdEncoding.EncodeToString(key.Bytes())
+	configKey.key = nil
+	t.Cleanup(func() { configKey.key = nil })
+	return key
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	key := useConfigKey(t)
+	sealed, err := encryptSecret(key.PublicKey(), "agent/API_KEY", "sk-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncrypted(sealed) || strings.Contains(sealed, "sk-123") {
+		t.Fatalf("unexpected ciphertext %q", sealed)
+	}
+	plain, err := decryptConfigValue("agent", "API_KEY", sealed)
+	if err != nil || plain != "sk-123" {
+		t.Fatalf("expected round trip, got %q, %v", plain, err)
+	}
+
+	// Bound to the variable it was stored under
+	if _, err := decryptConfigValue("agent", "OTHER_KEY", sealed); err == nil {
+		t.Error("expected a value moved to another variable to fail")
+	}
+	other, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	if _, err := decryptSecret(other, "agent/API_KEY", sealed); err == nil {
+		t.Error("expected the wrong key to fail")
+	}
+	if _, err := decryptSecret(key, "agent/API_KEY", encryptedPrefix+"AAAA"); err == nil {
+		t.Error("expected a malformed value to fail")
+	}
+}
+
+func TestSealSecrets(t *testing.T) {
+	key := useConfigKey(t)
+	decls := []EnvDef{
+		{Name: "API_KEY", Secret: true},
+		{Name: "VAULT_KEY", Secret: true},
+		{Name: "API_URL"},
+	}
+	config := map[string]any{
+		"agents": map[string]any{"agent": map[string]any{"env": map[string]any{
+			"API_KEY":   "sk-123",
+			"VAULT_KEY": "vault:secret/data/app#key",
+			"API_URL":   "https://api.example",
+		}}},
+	}
+
+	// No recipient: nothing is encrypted
+	if err := sealSecrets(config, "agent", decls); err != nil {
+		t.Fatal(err)
+	}
+	if agentEnvNamespace(config, "agent")["API_KEY"] != "sk-123" {
+		t.Fatal("expected plaintext without secrets.recipient")
+	}
+
+	config["secrets"] = map[string]any{"recipient": base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())}
+	if err := sealSecrets(config, "agent", decls); err != nil {
+		t.Fatal(err)
+	}
+	env := agentEnvNamespace(config, "agent")
+	sealed := env["API_KEY"].(string)
+	if !isEncrypted(sealed) {
+		t.Errorf("expected API_KEY to be encrypted, got %q", sealed)
+	}
+	if env["VAULT_KEY"] != "vault:secret/data/app#key" || env["API_URL"] != "https://api.example" {
+		t.Errorf("expected references and non-secrets untouched, got %v", env)
+	}
+
+	// Sealing again leaves encrypted values alone
+	if err := sealSecrets(config, "agent", decls); err != nil || env["API_KEY"] != sealed {
+		t.Errorf("expected encrypted value unchanged, got %v, %v", env["API_KEY"], err)
+	}
+
+	resolved := resolveEnv(decls, "agent", config)
+	if resolved.Values["API_KEY"] != "sk-123" || !resolved.Secrets["API_KEY"] {
+		t.Errorf("expected resolveEnv to decrypt and mask, got %q", resolved.Values["API_KEY"])
+	}
+}
+
+func TestResolveEnvUndecryptable(t *testing.T) {
+	useFakeKeyring(t)
+	configKey.key = nil
+	config := map[string]any{
+		"agents": map[string]any{"agent": map[string]any{"env": map[string]any{
+			"API_KEY": encryptedPrefix + "AAAA",
+		}}},
+		"defaults": map[string]any{"env": map[string]any{"API_KEY": "from-defaults"}},
+	}
+	var resolved *ResolvedEnv
+	out := captureStderr(t, func() {
+		resolved = resolveEnv([]EnvDef{{Name: "API_KEY", Secret: true}}, "agent", config)
+	})
+	if !strings.Contains(out, "rotate-key") {
+		t.Errorf("expected a warning about the missing key, got %q", out)
+	}
+	if resolved.Values["API_KEY"] != "from-defaults" {
+		t.Errorf("expected fall-through to defaults, got %q", resolved.Values["API_KEY"])
+	}
+}
+
+func TestApplySetupValuesEncrypts(t *testing.T) {
+	key := useConfigKey(t)
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	recipient := base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+	if err := writeTestFile(configPath, `{"secrets": {"recipient": "`+recipient+`"}}`); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SFA_CONFIG", configPath)
+
+	decls := []EnvDef{{Name: "API_KEY", Secret: true}}
+	if err := applySetupValues("agent", decls, map[string]string{"API_KEY": "sk-123"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(configPath)
+	if strings.Contains(string(data), "sk-123") {
+		t.Fatalf("expected no plaintext secret in config:\n%s", data)
+	}
+	values, err := configuredValues("agent", decls)
+	if err != nil || values["API_KEY"] != "sk-123" {
+		t.Errorf("expected configuredValues to decrypt, got %v, %v", values, err)
+	}
+}
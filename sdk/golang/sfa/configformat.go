@@ -0,0 +1,1162 @@
+package sfa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The shared config may be JSON, YAML, or TOML, chosen by file extension.
+// YAML and TOML are decoded into the same shapes encoding/json produces
+// (map[string]any, []any, string, float64, bool, nil) so the rest of the
+// SDK never sees the difference. Only the subset of each format that config
+// files need is supported: YAML anchors, aliases, tags, and duplicate keys
+// are errors rather than misread, as are multiple documents and numbers
+// that YAML 1.1 and 1.2 readers disagree on, and TOML dates are kept as
+// strings.
+
+// configFormat returns "json", "yaml", or "toml" for a config path.
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// decodeConfig parses data in the format of path.
+func decodeConfig(path string, data []byte) (map[string]any, error) {
+	switch configFormat(path) {
+	case "yaml":
+		return parseYAML(string(data))
+	case "toml":
+		return parseTOML(string(data))
+	}
+	var config map[string]any
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// encodeConfig renders config in the format of path.
+func encodeConfig(path string, config map[string]any) ([]byte, error) {
+	switch configFormat(path) {
+	case "yaml":
+		var b strings.Builder
+		writeYAMLMap(&b, config, 0)
+		return []byte(b.String()), nil
+	case "toml":
+		var b strings.Builder
+		if err := writeTOMLTable(&b, config, nil); err != nil {
+			return nil, err
+		}
+		return []byte(b.String()), nil
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// --- YAML ---
+
+type yamlLine struct {
+	no     int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML parses block mappings and sequences, flow collections, plain
+// and quoted scalars, and | and > block scalars.
+func parseYAML(data string) (map[string]any, error) {
+	p := &yamlParser{}
+	raw := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	for i := 0; i < len(raw); i++ {
+		line := raw[i]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		switch {
+		case trimmed == "---" || strings.HasPrefix(trimmed, "--- "):
+			if len(p.lines) > 0 || trimmed != "---" {
+				return nil, fmt.Errorf("line %d: multiple documents are not supported", i+1)
+			}
+			continue
+		case trimmed == "...":
+			return nil, fmt.Errorf("line %d: document end markers are not supported", i+1)
+		case strings.HasPrefix(line, "%"):
+			return nil, fmt.Errorf("line %d: directives are not supported", i+1)
+		}
+		if strings.HasPrefix(line, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		text := stripYAMLComment(strings.TrimRight(line[indent:], " "))
+		p.lines = append(p.lines, yamlLine{no: i + 1, indent: indent, text: text})
+
+		// Keep block scalar bodies verbatim, including blank lines
+		if isBlockScalarIndicator(text) {
+			for i+1 < len(raw) {
+				next := raw[i+1]
+				nextIndent := len(next) - len(strings.TrimLeft(next, " "))
+				if strings.TrimSpace(next) != "" && nextIndent <= indent {
+					break
+				}
+				i++
+				p.lines = append(p.lines, yamlLine{no: i + 1, indent: -1, text: next})
+			}
+		}
+	}
+	if len(p.lines) == 0 {
+		return map[string]any{}, nil
+	}
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].no)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("top level must be a mapping")
+	}
+	return m, nil
+}
+
+// isBlockScalarIndicator reports whether a line ends in a | or > header.
+func isBlockScalarIndicator(text string) bool {
+	for _, suffix := range []string{"|", "|-", "|+", ">", ">-", ">+"} {
+		if text == "- "+suffix || strings.HasSuffix(text, ": "+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripYAMLComment removes a trailing " #" comment outside quotes.
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return strings.TrimRight(s[:i], " ")
+		}
+	}
+	return s
+}
+
+// block parses the mapping or sequence whose lines start at indent.
+func (p *yamlParser) block(indent int) (any, error) {
+	if isSeqItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) mapping(indent int) (any, error) {
+	m := make(map[string]any)
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.no)
+		}
+		if isSeqItem(line.text) {
+			return nil, fmt.Errorf("line %d: expected a mapping key", line.no)
+		}
+		key, rest, err := splitYAMLKey(line.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line.no, err)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.no, key)
+		}
+		p.pos++
+		v, err := p.value(indent, rest, line.no, true)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+func (p *yamlParser) sequence(indent int) (any, error) {
+	list := []any{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || !isSeqItem(line.text) {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.no)
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if _, _, err := splitYAMLKey(rest); err == nil && !strings.HasPrefix(rest, "[") && !strings.HasPrefix(rest, "{") {
+			// "- key: value" starts a mapping indented to the key
+			p.lines[p.pos] = yamlLine{no: line.no, indent: line.indent + len(line.text) - len(rest), text: rest}
+			v, err := p.mapping(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		p.pos++
+		v, err := p.value(indent, rest, line.no, false)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// value parses what follows a key or "- ": an inline value, a block scalar,
+// or a nested block. Under a mapping key, a sequence may share the key's
+// indentation.
+func (p *yamlParser) value(indent int, rest string, lineNo int, underKey bool) (any, error) {
+	if rest != "" && (rest[0] == '|' || rest[0] == '>') {
+		return p.blockScalar(rest)
+	}
+	if rest != "" {
+		v, err := parseYAMLInline(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		return v, nil
+	}
+	if p.pos < len(p.lines) {
+		next := p.lines[p.pos]
+		if next.indent > indent || (underKey && next.indent == indent && isSeqItem(next.text)) {
+			return p.block(next.indent)
+		}
+	}
+	return nil, nil
+}
+
+// blockScalar joins the verbatim lines after a | or > header.
+func (p *yamlParser) blockScalar(header string) (any, error) {
+	var body []string
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == -1 {
+		body = append(body, p.lines[p.pos].text)
+		p.pos++
+	}
+	// Indentation is set by the first non-blank line
+	strip := -1
+	for _, l := range body {
+		if strings.TrimSpace(l) != "" {
+			strip = len(l) - len(strings.TrimLeft(l, " "))
+			break
+		}
+	}
+	for i, l := range body {
+		if len(l) >= strip && strip >= 0 {
+			body[i] = l[strip:]
+		} else {
+			body[i] = strings.TrimLeft(l, " ")
+		}
+	}
+	for len(body) > 0 && body[len(body)-1] == "" && !strings.HasSuffix(header, "+") {
+		body = body[:len(body)-1]
+	}
+
+	var s string
+	if header[0] == '|' {
+		s = strings.Join(body, "\n")
+	} else {
+		// Folded: single newlines become spaces, blank lines stay newlines
+		var b strings.Builder
+		for i, l := range body {
+			switch {
+			case l == "":
+				b.WriteByte('\n')
+			case i > 0 && body[i-1] != "":
+				b.WriteByte(' ')
+				b.WriteString(l)
+			default:
+				b.WriteString(l)
+			}
+		}
+		s = b.String()
+	}
+	if !strings.HasSuffix(header, "-") && len(body) > 0 {
+		s += "\n"
+	}
+	return s, nil
+}
+
+// splitYAMLKey splits "key: rest" (the key may be quoted).
+func splitYAMLKey(text string) (string, string, error) {
+	if text != "" && (text[0] == '"' || text[0] == '\'') {
+		end := closingYAMLQuote(text)
+		if end < 0 {
+			return "", "", errors.New("unterminated quoted key")
+		}
+		key, err := parseYAMLScalar(text[:end+1])
+		if err != nil {
+			return "", "", err
+		}
+		rest := text[end+1:]
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", errors.New("expected ':' after key")
+		}
+		return fmt.Sprint(key), strings.TrimSpace(rest[1:]), nil
+	}
+	idx := strings.Index(text, ": ")
+	if strings.HasSuffix(text, ":") && (idx < 0 || idx == len(text)-1) {
+		idx = len(text) - 1
+	}
+	if idx <= 0 {
+		return "", "", errors.New("expected 'key: value'")
+	}
+	key := strings.TrimSpace(text[:idx])
+	if err := unsupportedYAMLNode(key); err != nil {
+		return "", "", err
+	}
+	return key, strings.TrimSpace(text[idx+1:]), nil
+}
+
+// unsupportedYAMLNode rejects a plain scalar that starts with an anchor,
+// alias, or tag, which the parser would otherwise read as a string.
+func unsupportedYAMLNode(s string) error {
+	switch s[0] {
+	case '&':
+		return fmt.Errorf("anchors are not supported: %s", s)
+	case '*':
+		return fmt.Errorf("aliases are not supported: %s", s)
+	case '!':
+		return fmt.Errorf("tags are not supported: %s", s)
+	}
+	return nil
+}
+
+func closingYAMLQuote(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case q == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// parseYAMLInline parses a flow collection or scalar.
+func parseYAMLInline(s string) (any, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, errors.New("unterminated flow sequence")
+		}
+		items, err := splitFlow(s[1 : len(s)-1])
+		if err != nil {
+			return nil, err
+		}
+		list := []any{}
+		for _, item := range items {
+			v, err := parseYAMLInline(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case strings.HasPrefix(s, "{"):
+		if !strings.HasSuffix(s, "}") {
+			return nil, errors.New("unterminated flow mapping")
+		}
+		items, err := splitFlow(s[1 : len(s)-1])
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]any)
+		for _, item := range items {
+			key, rest, err := splitYAMLKey(item)
+			if err != nil {
+				return nil, err
+			}
+			v, err := parseYAMLInline(rest)
+			if err != nil {
+				return nil, err
+			}
+			if rest == "" {
+				v = nil
+			}
+			if _, dup := m[key]; dup {
+				return nil, fmt.Errorf("duplicate key %q", key)
+			}
+			m[key] = v
+		}
+		return m, nil
+	}
+	return parseYAMLScalar(s)
+}
+
+// splitFlow splits a flow collection body on top-level commas.
+func splitFlow(s string) ([]string, error) {
+	var items []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, errors.New("unbalanced flow collection")
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items, nil
+}
+
+// parseYAMLScalar resolves quoted strings, null, booleans, and numbers
+// (YAML 1.2 core schema); anything else is a plain string.
+func parseYAMLScalar(s string) (any, error) {
+	if s == "" {
+		return nil, nil
+	}
+	switch s[0] {
+	case '"':
+		if closingYAMLQuote(s) != len(s)-1 {
+			return nil, fmt.Errorf("malformed quoted string %s", s)
+		}
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("malformed quoted string %s", s)
+		}
+		return v, nil
+	case '\'':
+		if closingYAMLQuote(s) != len(s)-1 {
+			return nil, fmt.Errorf("malformed quoted string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	if err := unsupportedYAMLNode(s); err != nil {
+		return nil, err
+	}
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	switch strings.ToLower(strings.TrimLeft(s, "+-")) {
+	case ".inf", ".nan":
+		return nil, fmt.Errorf("infinity and NaN are not supported: %s", s)
+	}
+	if f, ok := parseYAMLNumber(s); ok {
+		return f, nil
+	}
+	if _, ok := parseConfigNumber(s); ok {
+		// 1_000, 0b11, and -0x1F are numbers to YAML 1.1 readers but
+		// strings in the core schema; refuse rather than pick one.
+		return nil, fmt.Errorf("ambiguous number %s: quote it or write it in decimal", s)
+	}
+	return s, nil
+}
+
+// yamlFloat is the YAML 1.2 core schema's decimal integer and float syntax,
+// under which 0755 is 755 rather than octal.
+var yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+
+// parseYAMLNumber parses a core schema integer (decimal, 0o octal, or 0x
+// hex) or float as float64 like encoding/json does.
+func parseYAMLNumber(s string) (float64, bool) {
+	base := 0
+	switch {
+	case strings.HasPrefix(s, "0o"):
+		base = 8
+	case strings.HasPrefix(s, "0x"):
+		base = 16
+	}
+	if base != 0 {
+		i, err := strconv.ParseUint(s[2:], base, 64)
+		return float64(i), err == nil
+	}
+	if !yamlFloat.MatchString(s) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// parseConfigNumber parses a decimal, 0x/0o/0b integer, or float, with
+// optional _ separators, as float64 like encoding/json does.
+func parseConfigNumber(s string) (float64, bool) {
+	clean := strings.ReplaceAll(s, "_", "")
+	if clean == "" || strings.ContainsAny(clean[:1], "abcdefABCDEF") {
+		return 0, false
+	}
+	if i, err := strconv.ParseInt(clean, 0, 64); err == nil {
+		return float64(i), true
+	}
+	if strings.ContainsAny(clean, "xXoObB") {
+		return 0, false
+	}
+	if f, err := strconv.ParseFloat(clean, 64); err == nil && !strings.ContainsAny(clean, "nN") {
+		return f, true
+	}
+	return 0, false
+}
+
+// writeYAMLMap writes a block mapping with sorted keys.
+func writeYAMLMap(b *strings.Builder, m map[string]any, indent int) {
+	pad := strings.Repeat(" ", indent)
+	for _, key := range sortedKeys(m) {
+		b.WriteString(pad + yamlScalar(key) + ":")
+		writeYAMLValue(b, m[key], indent)
+	}
+}
+
+// writeYAMLValue writes the rest of a "key:" or "-" line.
+func writeYAMLValue(b *strings.Builder, v any, indent int) {
+	switch v := v.(type) {
+	case map[string]any:
+		if len(v) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		b.WriteString("\n")
+		writeYAMLMap(b, v, indent+2)
+	case []any:
+		if len(v) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		b.WriteString("\n")
+		pad := strings.Repeat(" ", indent+2)
+		for _, item := range v {
+			b.WriteString(pad + "-")
+			writeYAMLValue(b, item, indent+2)
+		}
+	default:
+		b.WriteString(" " + yamlScalar(v) + "\n")
+	}
+}
+
+// yamlScalar formats a scalar, quoting strings that would otherwise read
+// back as something else.
+func yamlScalar(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		if parsed, err := parseYAMLInline(v); err == nil && parsed == v && v == strings.TrimSpace(v) &&
+			!strings.ContainsAny(v, ":#\"'\n\t") && !strings.ContainsAny(v[:1], "-?,[]{}&*!|>%@`") {
+			return v
+		}
+		return strconv.Quote(v)
+	}
+	return strconv.Quote(fmt.Sprint(v))
+}
+
+// --- TOML ---
+
+// parseTOML parses key/value pairs, [tables], [[arrays of tables]], dotted
+// and quoted keys, strings (including multi-line), numbers, booleans,
+// arrays, and inline tables.
+func parseTOML(data string) (map[string]any, error) {
+	root := make(map[string]any)
+	current := root
+	s := strings.ReplaceAll(data, "\r\n", "\n")
+	line := 1
+
+	for len(s) > 0 {
+		var stmt string
+		stmt, s = cutTOMLStatement(s)
+		stmtLine := line
+		line += strings.Count(stmt, "\n") + 1
+		stmt = strings.TrimSpace(stripTOMLComment(stmt))
+		if stmt == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(stmt, "[["):
+			if !strings.HasSuffix(stmt, "]]") {
+				return nil, fmt.Errorf("line %d: malformed array of tables header", stmtLine)
+			}
+			keys, err := splitTOMLKey(stmt[2 : len(stmt)-2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", stmtLine, err)
+			}
+			parent, err := tomlTable(root, keys[:len(keys)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", stmtLine, err)
+			}
+			last := keys[len(keys)-1]
+			list, _ := parent[last].([]any)
+			if parent[last] != nil && list == nil {
+				return nil, fmt.Errorf("line %d: %s is not an array of tables", stmtLine, last)
+			}
+			current = make(map[string]any)
+			parent[last] = append(list, current)
+		case strings.HasPrefix(stmt, "["):
+			if !strings.HasSuffix(stmt, "]") {
+				return nil, fmt.Errorf("line %d: malformed table header", stmtLine)
+			}
+			keys, err := splitTOMLKey(stmt[1 : len(stmt)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", stmtLine, err)
+			}
+			if current, err = tomlTable(root, keys); err != nil {
+				return nil, fmt.Errorf("line %d: %v", stmtLine, err)
+			}
+		default:
+			if err := setTOMLPair(current, stmt); err != nil {
+				return nil, fmt.Errorf("line %d: %v", stmtLine, err)
+			}
+		}
+	}
+	return root, nil
+}
+
+// cutTOMLStatement returns the next logical line, which continues past
+// newlines inside multi-line strings, arrays, and inline tables.
+func cutTOMLStatement(s string) (string, string) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case strings.HasPrefix(s[i:], `"""`) || strings.HasPrefix(s[i:], "'''"):
+			delim := s[i : i+3]
+			end := strings.Index(s[i+3:], delim)
+			if end < 0 {
+				return s, ""
+			}
+			i += 3 + end + 2
+			for i+1 < len(s) && s[i+1] == delim[0] { // """" ends with a quote
+				i++
+			}
+		case c == '"' || c == '\'':
+			for i++; i < len(s) && s[i] != c && s[i] != '\n'; i++ {
+				if c == '"' && s[i] == '\\' {
+					i++
+				}
+			}
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == '\n' && depth <= 0:
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+// stripTOMLComment removes # comments outside strings.
+func stripTOMLComment(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.HasPrefix(s[i:], `"""`) || strings.HasPrefix(s[i:], "'''"):
+			end := strings.Index(s[i+3:], s[i:i+3])
+			if end < 0 {
+				return b.String() + s[i:]
+			}
+			stop := i + 3 + end + 3
+			for stop < len(s) && s[stop] == s[i] {
+				stop++
+			}
+			b.WriteString(s[i:stop])
+			i = stop - 1
+		case c == '"' || c == '\'':
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if c == '"' && s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				j = len(s) - 1
+			}
+			b.WriteString(s[i : j+1])
+			i = j
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			if i < len(s) {
+				b.WriteByte('\n')
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// splitTOMLKey splits a dotted key into its bare or quoted parts.
+func splitTOMLKey(s string) ([]string, error) {
+	var keys []string
+	s = strings.TrimSpace(s)
+	for {
+		if s == "" {
+			return nil, errors.New("empty key")
+		}
+		var key string
+		switch s[0] {
+		case '"', '\'':
+			end := 1
+			for ; end < len(s) && s[end] != s[0]; end++ {
+				if s[0] == '"' && s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, errors.New("unterminated quoted key")
+			}
+			v, err := parseTOMLString(s[:end+1])
+			if err != nil {
+				return nil, err
+			}
+			key, s = v, strings.TrimSpace(s[end+1:])
+		default:
+			end := strings.IndexAny(s, ". \t")
+			if end < 0 {
+				end = len(s)
+			}
+			key = s[:end]
+			for _, c := range key {
+				if c != '_' && c != '-' && (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+					return nil, fmt.Errorf("invalid bare key %q", key)
+				}
+			}
+			s = strings.TrimSpace(s[end:])
+		}
+		keys = append(keys, key)
+		if s == "" {
+			return keys, nil
+		}
+		if s[0] != '.' {
+			return nil, fmt.Errorf("unexpected %q in key", s)
+		}
+		s = strings.TrimSpace(s[1:])
+	}
+}
+
+// tomlTable walks keys from root, creating tables and descending into the
+// last element of arrays of tables.
+func tomlTable(root map[string]any, keys []string) (map[string]any, error) {
+	t := root
+	for _, key := range keys {
+		switch v := t[key].(type) {
+		case nil:
+			next := make(map[string]any)
+			t[key] = next
+			t = next
+		case map[string]any:
+			t = v
+		case []any:
+			var last map[string]any
+			if len(v) > 0 {
+				last, _ = v[len(v)-1].(map[string]any)
+			}
+			if last == nil {
+				return nil, fmt.Errorf("%s is not a table", key)
+			}
+			t = last
+		default:
+			return nil, fmt.Errorf("%s is not a table", key)
+		}
+	}
+	return t, nil
+}
+
+// setTOMLPair parses "key = value" into t.
+func setTOMLPair(t map[string]any, stmt string) error {
+	eq := tomlKeyEnd(stmt)
+	if eq < 0 {
+		return errors.New("expected key = value")
+	}
+	keys, err := splitTOMLKey(stmt[:eq])
+	if err != nil {
+		return err
+	}
+	v, err := parseTOMLValue(strings.TrimSpace(stmt[eq+1:]))
+	if err != nil {
+		return fmt.Errorf("%s: %v", strings.Join(keys, "."), err)
+	}
+	parent, err := tomlTable(t, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := parent[last]; exists {
+		return fmt.Errorf("duplicate key %s", strings.Join(keys, "."))
+	}
+	parent[last] = v
+	return nil
+}
+
+// tomlKeyEnd returns the index of the = ending a (possibly quoted) key.
+func tomlKeyEnd(s string) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '=':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseTOMLValue(s string) (any, error) {
+	switch {
+	case s == "":
+		return nil, errors.New("missing value")
+	case s[0] == '"' || s[0] == '\'':
+		return parseTOMLString(s)
+	case s[0] == '[':
+		if !strings.HasSuffix(s, "]") {
+			return nil, errors.New("unterminated array")
+		}
+		items, err := splitFlow(strings.TrimSpace(s[1 : len(s)-1]))
+		if err != nil {
+			return nil, err
+		}
+		list := []any{}
+		for _, item := range items {
+			v, err := parseTOMLValue(strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case s[0] == '{':
+		if !strings.HasSuffix(s, "}") {
+			return nil, errors.New("unterminated inline table")
+		}
+		items, err := splitFlow(s[1 : len(s)-1])
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]any)
+		for _, item := range items {
+			if err := setTOMLPair(m, item); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	}
+	switch s {
+	case "inf", "+inf", "-inf", "nan", "+nan", "-nan":
+		return nil, fmt.Errorf("%s cannot be represented in config", s)
+	}
+	if f, ok := parseConfigNumber(s); ok {
+		return f, nil
+	}
+	if len(s) >= 10 && s[4] == '-' && s[7] == '-' {
+		return s, nil // dates and times are kept as written
+	}
+	return nil, fmt.Errorf("invalid value %q", s)
+}
+
+// parseTOMLString parses a basic, literal, or multi-line string.
+func parseTOMLString(s string) (string, error) {
+	for _, delim := range []string{`"""`, "'''"} {
+		if strings.HasPrefix(s, delim) {
+			if len(s) < 6 || !strings.HasSuffix(s, delim) {
+				return "", errors.New("unterminated multi-line string")
+			}
+			body := strings.TrimPrefix(s[3:len(s)-3], "\n")
+			if delim == "'''" {
+				return body, nil
+			}
+			// A trailing backslash joins lines, dropping the whitespace after it
+			var b strings.Builder
+			lines := strings.Split(body, "\n")
+			for i := 0; i < len(lines); i++ {
+				l := lines[i]
+				if strings.HasSuffix(l, `\`) && !strings.HasSuffix(l, `\\`) {
+					b.WriteString(l[:len(l)-1])
+					for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) == "" {
+						i++
+					}
+					if i+1 < len(lines) {
+						lines[i+1] = strings.TrimLeft(lines[i+1], " \t")
+					}
+					continue
+				}
+				b.WriteString(l)
+				if i < len(lines)-1 {
+					b.WriteByte('\n')
+				}
+			}
+			return unescapeTOML(b.String())
+		}
+	}
+	if len(s) < 2 || s[len(s)-1] != s[0] {
+		return "", fmt.Errorf("malformed string %s", s)
+	}
+	if s[0] == '\'' {
+		return s[1 : len(s)-1], nil
+	}
+	return unescapeTOML(s[1 : len(s)-1])
+}
+
+func unescapeTOML(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", errors.New("trailing backslash in string")
+		}
+		switch s[i] {
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case '"', '\\':
+			b.WriteByte(s[i])
+		case 'u', 'U':
+			n := 4
+			if s[i] == 'U' {
+				n = 8
+			}
+			if i+n >= len(s) {
+				return "", errors.New("truncated unicode escape")
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid unicode escape \\%c%s", s[i], s[i+1:i+1+n])
+			}
+			b.WriteRune(rune(r))
+			i += n
+		default:
+			return "", fmt.Errorf("invalid escape \\%c", s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// writeTOMLTable writes t's scalars and arrays, then its subtables under
+// [path] headers and arrays of tables under [[path]] headers. Null values
+// have no TOML form and are omitted.
+func writeTOMLTable(b *strings.Builder, t map[string]any, path []string) error {
+	var tables, arrays []string
+	for _, key := range sortedKeys(t) {
+		switch v := t[key].(type) {
+		case nil:
+			continue
+		case map[string]any:
+			tables = append(tables, key)
+			continue
+		case []any:
+			if len(v) > 0 && allTables(v) {
+				arrays = append(arrays, key)
+				continue
+			}
+		}
+		s, err := tomlValue(t[key])
+		if err != nil {
+			return fmt.Errorf("%s: %v", strings.Join(append(path, key), "."), err)
+		}
+		b.WriteString(tomlKey(key) + " = " + s + "\n")
+	}
+	for _, key := range tables {
+		sub := append(append([]string{}, path...), key)
+		header := make([]string, len(sub))
+		for i, k := range sub {
+			header[i] = tomlKey(k)
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString("[" + strings.Join(header, ".") + "]\n")
+		if err := writeTOMLTable(b, t[key].(map[string]any), sub); err != nil {
+			return err
+		}
+	}
+	for _, key := range arrays {
+		sub := append(append([]string{}, path...), key)
+		header := make([]string, len(sub))
+		for i, k := range sub {
+			header[i] = tomlKey(k)
+		}
+		for _, item := range t[key].([]any) {
+			if b.Len() > 0 {
+				b.WriteByte('\n')
+			}
+			b.WriteString("[[" + strings.Join(header, ".") + "]]\n")
+			if err := writeTOMLTable(b, item.(map[string]any), sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func allTables(list []any) bool {
+	for _, item := range list {
+		if _, ok := item.(map[string]any); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// tomlValue formats an inline value.
+func tomlValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return tomlString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	case map[string]any:
+		parts := make([]string, 0, len(v))
+		for _, key := range sortedKeys(v) {
+			if v[key] == nil {
+				continue
+			}
+			s, err := tomlValue(v[key])
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, tomlKey(key)+" = "+s)
+		}
+		return "{" + strings.Join(parts, ", ") + "}", nil
+	case nil:
+		return "", errors.New("null values cannot be written as TOML")
+	}
+	return tomlString(fmt.Sprint(v)), nil
+}
+
+func tomlKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+	for _, c := range key {
+		if c != '_' && c != '-' && (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return tomlString(key)
+		}
+	}
+	return key
+}
+
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
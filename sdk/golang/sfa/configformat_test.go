@@ -0,0 +1,190 @@
+package sfa
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// sampleJSON, sampleYAML, and sampleTOML all decode to the same config.
+const sampleJSON = `{
+  "defaults": {"timeout": 30, "verbose": false, "env": {"REGION": "us-east-1"}},
+  "logging": {"file": "/var/log/sfa.jsonl", "maxSize": 1048576},
+  "agents": {
+    "code-reviewer": {
+      "model": "claude: fast #1",
+      "tags": ["ci", "lint"],
+      "env": {"API_URL": "https://api.example.com/v1"}
+    }
+  },
+  "services": [{"name": "db", "port": 5432}, {"name": "cache", "port": 6379}],
+  "notes": "line one\nline two\n"
+}`
+
+const sampleYAML = `# shared config
+defaults:
+  timeout: 30
+  verbose: false
+  env:
+    REGION: us-east-1
+logging:
+  file: /var/log/sfa.jsonl   # rotated daily
+  maxSize: 1048576
+agents:
+  code-reviewer:
+    model: "claude: fast #1"
+    tags: [ci, 'lint']
+    env: {API_URL: https://api.example.com/v1}
+services:
+- name: db
+  port: 5432
+- name: cache
+  port: 6379
+notes: |
+  line one
+  line two
+`
+
+const sampleTOML = `# shared config
+notes = """
+line one
+line two
+"""
+
+[defaults]
+timeout = 30
+verbose = false
+env.REGION = "us-east-1"
+
+[logging]
+file = '/var/log/sfa.jsonl' # rotated daily
+maxSize = 1_048_576
+
+[agents.code-reviewer]
+model = "claude: fast #1"
+tags = [
+  "ci",
+  "lint", # trailing comma allowed
+]
+env = { API_URL = "https://api.example.com/v1" }
+
+[[services]]
+name = "db"
+port = 5432
+
+[[services]]
+name = "cache"
+port = 6379
+`
+
+func TestDecodeConfigFormats(t *testing.T) {
+	want, err := decodeConfig("config.json", []byte(sampleJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, data := range map[string]string{"config.yaml": sampleYAML, "config.toml": sampleTOML} {
+		got, err := decodeConfig(path, []byte(data))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			g, _ := json.Marshal(got)
+			w, _ := json.Marshal(want)
+			t.Errorf("%s decoded to\n%s\nwant\n%s", path, g, w)
+		}
+	}
+}
+
+func TestEncodeConfigRoundTrips(t *testing.T) {
+	want, _ := decodeConfig("config.json", []byte(sampleJSON))
+	want["quoted"] = map[string]any{"empty": "", "bool": "true", "num": "42", "dash": "-x", "key with space": "a\tb"}
+	for _, path := range []string{"config.yaml", "config.toml"} {
+		data, err := encodeConfig(path, want)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		got, err := decodeConfig(path, data)
+		if err != nil {
+			t.Fatalf("%s: re-decoding %v:\n%s", path, err, data)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s did not round-trip:\n%s", path, data)
+		}
+	}
+}
+
+func TestDecodeConfigErrors(t *testing.T) {
+	for path, data := range map[string]string{
+		"bad.yaml":     "a: 1\n   b: 2\n",
+		"tab.yaml":     "a:\n\tb: 1\n",
+		"anchor.yaml":  "base: &x 1\nother: 2\n",
+		"block.yaml":   "base: &defaults\n  timeout: 30\n",
+		"alias.yaml":   "a: 1\nb: *x\n",
+		"merge.yaml":   "agents:\n  reviewer:\n    <<: *defaults\n",
+		"tag.yaml":     "a: !!str 1\n",
+		"flowtag.yaml": "a: [1, !x 2]\n",
+		"dupkey.yaml":  "a: 1\nb: 2\na: 3\n",
+		"dupnest.yaml": "agents:\n  x:\n    timeout: 1\n    timeout: 2\n",
+		"dupflow.yaml": "env: {A: 1, A: 2}\n",
+		"anchkey.yaml": "&k key: 1\n",
+		"under.yaml":   "maxSize: 1_048_576\n",
+		"binary.yaml":  "mode: 0b11\n",
+		"inf.yaml":     "limit: .inf\n",
+		"nan.yaml":     "limit: [-.NaN]\n",
+		"docs.yaml":    "a: 1\n---\nb: 2\n",
+		"end.yaml":     "a: 1\n...\n",
+		"dir.yaml":     "%YAML 1.2\n---\na: 1\n",
+		"bad.toml":     "a = \n",
+		"dup.toml":     "a = 1\na = 2\n",
+		"str.toml":     "a = \"unterminated\n",
+	} {
+		if _, err := decodeConfig(path, []byte(data)); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
+
+func TestLoadAndSaveYAMLConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := writeTestFile(path, sampleYAML); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("SFA_CONFIG", path)
+	defer os.Unsetenv("SFA_CONFIG")
+
+	config := loadConfig()
+	if config["defaults"].(map[string]any)["timeout"] != 30.0 {
+		t.Fatalf("unexpected config: %v", config)
+	}
+	agentEnvNamespace(config, "code-reviewer")["MODEL"] = "opus"
+	if err := saveConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if data[0] == '{' {
+		t.Fatalf("expected YAML, got JSON:\n%s", data)
+	}
+	if got := agentEnvNamespace(loadConfig(), "code-reviewer")["MODEL"]; got != "opus" {
+		t.Errorf("expected saved value, got %v", got)
+	}
+}
+
+func TestGetConfigPathFindsYAML(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("SFA_CONFIG", "")
+	dir := filepath.Join(home, ".config", "single-file-agents")
+	if got := getConfigPath(); got != filepath.Join(dir, "config.json") {
+		t.Errorf("expected config.json default, got %s", got)
+	}
+	os.MkdirAll(dir, 0755)
+	if err := writeTestFile(filepath.Join(dir, "config.toml"), "a = 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := getConfigPath(); got != filepath.Join(dir, "config.toml") {
+		t.Errorf("expected config.toml, got %s", got)
+	}
+}
@@ -0,0 +1,69 @@
+package sfa
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	config, err := decodeConfig("config.json", []byte(`{
+  "defaults": {"timeout": "60", "customKey": [1], "env": {"A": "x", "B": 2, "C": {"nested": true}}},
+  "agents": {"reviewer": {"verbose": "yes", "model": "large"}, "broken": 5},
+  "loging": {"file": "/tmp/x"},
+  "logging": {"file": "/tmp/log.jsonl", "maxSize": 10, "rotate": true},
+  "apiKeys": {"openai": 123},
+  "contextStore": "/tmp/ctx"
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"agents.broken must be an object, got a number",
+		"agents.reviewer.verbose must be a boolean, got a string",
+		"apiKeys.openai must be a string, got a number",
+		"contextStore must be an object, got a string",
+		"defaults.env.C must be a string, number, or boolean, got an object",
+		"defaults.timeout must be a number, got a string",
+		"unknown key logging.rotate",
+		"unknown key loging",
+	}
+	if got := validateConfig(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("got problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	valid, _ := decodeConfig("config.yaml", []byte(sampleYAML))
+	delete(valid, "services")
+	delete(valid, "notes")
+	if problems := validateConfig(valid); len(problems) != 0 {
+		t.Errorf("expected a valid config, got %v", problems)
+	}
+}
+
+func TestLoadConfigWarns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.Setenv("SFA_CONFIG", path)
+	defer os.Unsetenv("SFA_CONFIG")
+
+	if err := writeTestFile(path, `{"defaults": {"timeout": 30}, "unexpected": 1}`); err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]any
+	stderr := captureStderr(t, func() { config = loadConfig() })
+	if !strings.Contains(stderr, "unknown key unexpected") {
+		t.Errorf("expected unknown key warning, got %q", stderr)
+	}
+	if config["defaults"] == nil {
+		t.Error("expected a config with problems to still load")
+	}
+
+	if err := writeTestFile(path, `{"defaults": `); err != nil {
+		t.Fatal(err)
+	}
+	stderr = captureStderr(t, func() { config = loadConfig() })
+	if !strings.Contains(stderr, "malformed config") || len(config) != 0 {
+		t.Errorf("expected malformed config warning and empty map, got %q %v", stderr, config)
+	}
+}
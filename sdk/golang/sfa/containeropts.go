@@ -0,0 +1,110 @@
+package sfa
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Restart policies for ServiceDef.Restart, as in Docker Compose. An empty
+// policy means RestartNo. RestartOnFailure may be limited to a number of
+// retries, e.g. "on-failure:3".
+const (
+	RestartNo            = "no"
+	RestartAlways        = "always"
+	RestartOnFailure     = "on-failure"
+	RestartUnlessStopped = "unless-stopped"
+)
+
+// ulimitNames are the limits Docker accepts, as setrlimit names them.
+var ulimitNames = map[string]bool{
+	"core": true, "cpu": true, "data": true, "fsize": true, "locks": true,
+	"memlock": true, "msgqueue": true, "nice": true, "nofile": true, "nproc": true,
+	"rss": true, "rtprio": true, "rttime": true, "sigpending": true, "stack": true,
+}
+
+// checkRestart rejects a restart policy compose would refuse.
+func checkRestart(name, restart string) error {
+	switch restart {
+	case "", RestartNo, RestartAlways, RestartOnFailure, RestartUnlessStopped:
+		return nil
+	}
+	if retries, ok := strings.CutPrefix(restart, RestartOnFailure+":"); ok {
+		if n, err := strconv.Atoi(retries); err == nil && n > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("service %s: invalid restart policy %q (use no, always, on-failure, on-failure:<retries>, or unless-stopped)", name, restart)
+}
+
+// checkExtraHosts rejects entries that are not host:ip, where ip may be
+// host-gateway for the engine's host.
+func checkExtraHosts(name string, hosts []string) error {
+	for _, entry := range hosts {
+		host, ip, ok := strings.Cut(entry, ":")
+		if !ok || host == "" || (ip != "host-gateway" && net.ParseIP(ip) == nil) {
+			return fmt.Errorf("service %s: invalid extra host %q (use host:ip or host:host-gateway)", name, entry)
+		}
+	}
+	return nil
+}
+
+// checkUlimits rejects unknown limits and a soft limit above the hard one.
+func checkUlimits(name string, ulimits map[string]Ulimit) error {
+	for _, limit := range sortedKeys(ulimits) {
+		u := ulimits[limit]
+		if !ulimitNames[limit] {
+			return fmt.Errorf("service %s: unknown ulimit %q", name, limit)
+		}
+		if u.Soft < -1 || u.Hard < -1 {
+			return fmt.Errorf("service %s: ulimit %s: limits are -1 for unlimited or a count", name, limit)
+		}
+		// -1 is unlimited, above any count
+		if u.Hard != 0 && u.Hard != -1 && (u.Soft == -1 || u.Soft > u.Hard) {
+			return fmt.Errorf("service %s: ulimit %s: soft limit %d exceeds hard limit %d", name, limit, u.Soft, u.Hard)
+		}
+	}
+	return nil
+}
+
+// writeComposeContainerOptions writes a service's user, restart policy,
+// extra hosts, and ulimits, after checking them.
+func writeComposeContainerOptions(b *strings.Builder, name string, svc ServiceDef) error {
+	if err := checkRestart(name, svc.Restart); err != nil {
+		return err
+	}
+	if err := checkExtraHosts(name, svc.ExtraHosts); err != nil {
+		return err
+	}
+	if err := checkUlimits(name, svc.Ulimits); err != nil {
+		return err
+	}
+
+	if svc.User != "" {
+		b.WriteString(fmt.Sprintf("    user: %q\n", svc.User))
+	}
+	if svc.Restart != "" {
+		b.WriteString(fmt.Sprintf("    restart: %q\n", svc.Restart))
+	}
+	if len(svc.ExtraHosts) > 0 {
+		b.WriteString("    extra_hosts:\n")
+		for _, entry := range svc.ExtraHosts {
+			b.WriteString(fmt.Sprintf("      - %q\n", entry))
+		}
+	}
+	if len(svc.Ulimits) > 0 {
+		b.WriteString("    ulimits:\n")
+		for _, limit := range sortedKeys(svc.Ulimits) {
+			u := svc.Ulimits[limit]
+			if u.Hard == 0 || u.Hard == u.Soft {
+				b.WriteString(fmt.Sprintf("      %s: %d\n", limit, u.Soft))
+				continue
+			}
+			b.WriteString(fmt.Sprintf("      %s:\n", limit))
+			b.WriteString(fmt.Sprintf("        soft: %d\n", u.Soft))
+			b.WriteString(fmt.Sprintf("        hard: %d\n", u.Hard))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package sfa
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMaterializeComposeContainerOptions(t *testing.T) {
+	t.Setenv("SFA_DATA_HOME", t.TempDir())
+	services := map[string]ServiceDef{
+		"search": {
+			Image:      "elasticsearch:8.13.0",
+			User:       "1000:1000",
+			Restart:    RestartUnlessStopped,
+			ExtraHosts: []string{"host.docker.internal:host-gateway", "kafka:10.0.0.5"},
+			Ulimits:    map[string]Ulimit{"nofile": {Soft: 65536, Hard: 65536}, "memlock": {Soft: -1, Hard: -1}, "nproc": {Soft: 4096, Hard: 8192}},
+		},
+	}
+	path, err := materializeCompose("indexer", "1.0.0", services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `    user: "1000:1000"
+    restart: "unless-stopped"
+    extra_hosts:
+      - "host.docker.internal:host-gateway"
+      - "kafka:10.0.0.5"
+    ulimits:
+      memlock: -1
+      nofile: 65536
+      nproc:
+        soft: 4096
+        hard: 8192
+`
+	if !strings.Contains(string(data), want) {
+		t.Errorf("expected %q in compose file:\n%s", want, data)
+	}
+
+	for _, bad := range []ServiceDef{
+		{Image: "x", Restart: "sometimes"},
+		{Image: "x", Restart: "on-failure:0"},
+		{Image: "x", ExtraHosts: []string{"kafka"}},
+		{Image: "x", ExtraHosts: []string{"kafka:not-an-ip"}},
+		{Image: "x", Ulimits: map[string]Ulimit{"files": {Soft: 1}}},
+		{Image: "x", Ulimits: map[string]Ulimit{"nofile": {Soft: 2048, Hard: 1024}}},
+		{Image: "x", Ulimits: map[string]Ulimit{"nofile": {Soft: -1, Hard: 1024}}},
+	} {
+		if _, err := materializeCompose("indexer", "1.0.0", map[string]ServiceDef{"search": bad}); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+	if err := checkRestart("search", "on-failure:3"); err != nil {
+		t.Errorf("expected on-failure:3 to pass, got %v", err)
+	}
+}
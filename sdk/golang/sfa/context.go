@@ -1,11 +1,14 @@
 package sfa
 
 import (
-	"bufio"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -26,11 +29,10 @@ func resolveContextStorePath(config map[string]any) string {
 		}
 	}
 
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "/tmp/sfa-context"
+	if dir := dataDir("context"); dir != "" {
+		return dir
 	}
-	return filepath.Join(home, ".local", "share", "single-file-agents", "context")
+	return "/tmp/sfa-context"
 }
 
 // writeContextEntry writes a context entry as a markdown file with YAML frontmatter.
@@ -46,42 +48,34 @@ func writeContextEntry(entry ContextEntry, agentName, sessionID, storePath strin
 		return "", fmt.Errorf("failed to create context directory: %w", err)
 	}
 
-	// Build filename: compact timestamp + slug
-	ts := time.Now().UTC().Format("20060102T150405")
-	filename := fmt.Sprintf("%s-%s.md", ts, entry.Slug)
-	filePath := filepath.Join(dir, filename)
-
-	// Build content with YAML frontmatter
-	var b strings.Builder
-	b.WriteString("---\n")
-	b.WriteString(fmt.Sprintf("agent: %s\n", agentName))
-	if sessionID != "" {
-		b.WriteString(fmt.Sprintf("sessionId: %s\n", sessionID))
-	}
-	b.WriteString(fmt.Sprintf("timestamp: %s\n", time.Now().UTC().Format(time.RFC3339)))
-	b.WriteString(fmt.Sprintf("type: %s\n", string(entry.Type)))
-
-	if len(entry.Tags) > 0 {
-		b.WriteString("tags:\n")
-		for _, tag := range entry.Tags {
-			b.WriteString(fmt.Sprintf("  - %s\n", tag))
+	// Build filename: compact timestamp + slug, with a nonce if another
+	// entry took the name first
+	now := time.Now().UTC()
+	var filePath string
+	for attempt := 0; ; attempt++ {
+		filePath = filepath.Join(dir, contextEntryName(now, entry.Slug, attempt))
+		f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) && attempt < contextNameAttempts {
+			continue
 		}
-	}
-
-	if len(entry.Links) > 0 {
-		b.WriteString("links:\n")
-		for _, link := range entry.Links {
-			b.WriteString(fmt.Sprintf("  - %s\n", link))
+		if err != nil {
+			return "", fmt.Errorf("failed to write context entry: %w", err)
 		}
+		_, err = f.WriteString(formatContextEntry(entry, agentName, sessionID, now))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = writeContextAttachments(filePath, entry.Attachments)
+		}
+		if err != nil {
+			removeContextEntryFile(filePath)
+			return "", fmt.Errorf("failed to write context entry: %w", err)
+		}
+		break
 	}
 
-	b.WriteString("---\n\n")
-	b.WriteString(entry.Content)
-	b.WriteString("\n")
-
-	if err := os.WriteFile(filePath, []byte(b.String()), 0644); err != nil {
-		return "", fmt.Errorf("failed to write context entry: %w", err)
-	}
+	indexContextFile(storePath, filePath)
 
 	absPath, err := filepath.Abs(filePath)
 	if err != nil {
@@ -90,19 +84,97 @@ func writeContextEntry(entry ContextEntry, agentName, sessionID, storePath strin
 	return absPath, nil
 }
 
+// contextNameAttempts bounds the nonces tried for an entry's file name
+// before a write gives up.
+const contextNameAttempts = 10
+
+// contextEntryName returns the file name of an entry written at now:
+// <timestamp>-<slug>.md, then, as attempt counts the names already taken,
+// <timestamp>-<slug>-<nonce>.md, so entries written in the same second with
+// the same slug never overwrite each other.
+func contextEntryName(now time.Time, slug string, attempt int) string {
+	if attempt == 0 {
+		return fmt.Sprintf("%s-%s.md", now.Format("20060102T150405"), slug)
+	}
+	nonce := make([]byte, 3)
+	rand.Read(nonce)
+	return fmt.Sprintf("%s-%s-%s.md", now.Format("20060102T150405"), slug, hex.EncodeToString(nonce))
+}
+
+// formatContextEntry renders an entry as written at now: YAML frontmatter,
+// then the content.
+func formatContextEntry(entry ContextEntry, agentName, sessionID string, now time.Time) string {
+	return formatContextDocument(&contextDocument{
+		Agent:       agentName,
+		SessionID:   sessionID,
+		Timestamp:   now.Format(time.RFC3339),
+		Type:        entry.Type,
+		Tags:        entry.Tags,
+		Links:       entry.Links,
+		Metadata:    entry.Metadata,
+		Attachments: contextAttachmentNames(entry.Attachments),
+		Content:     entry.Content,
+	})
+}
+
 // searchContextEntries searches the context store for entries matching the query.
-// Uses ripgrep for text queries when available, falls back to Go-native search.
-// Returns results sorted by timestamp descending (most recent first).
+// Uses the store's SQLite index when the sqlite3 command is installed (warning
+// once when it is not), then ripgrep for text queries when available, and
+// falls back to Go-native search.
+// Returns results sorted by timestamp descending (most recent first), or with
+// ContextSortRelevance and a Query, by relevance. ContextSortSimilarity uses
+// the embeddings provider and falls back to relevance without one.
 func searchContextEntries(query ContextQuery, storePath string) ([]ContextResult, error) {
+	query, err := normalizeContextSort(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Sort == ContextSortSimilarity {
+		provider := embeddingsFromEnv()
+		if provider != nil {
+			results, err := searchContextSimilar(query, storePath, provider)
+			if err == nil {
+				return finishContextResults(results, query), nil
+			}
+			stderrLog.Warn(fmt.Sprintf("similarity search failed, ranking by relevance instead: %v", err))
+		}
+		query.Sort = ContextSortRelevance
+	}
+
+	if results, err := searchContextIndex(query, storePath); err == nil {
+		return finishContextResults(results, query), nil
+	}
+
 	// If there's a text query, try ripgrep first for speed
-	if query.Query != "" {
+	if query.Query != "" && query.Sort == ContextSortNewest {
 		if results, err := searchWithRipgrep(query, storePath); err == nil {
-			return results, nil
+			return finishContextResults(results, query), nil
 		}
 		// ripgrep unavailable or failed — fall back to native search
 	}
 
-	return searchNative(query, storePath)
+	results, err := searchNative(query, storePath)
+	if err != nil {
+		return nil, err
+	}
+	return finishContextResults(results, query), nil
+}
+
+// normalizeContextSort checks a query's Sort and fills in its default. A
+// relevance or similarity sort without words to rank by sorts newest first.
+func normalizeContextSort(query ContextQuery) (ContextQuery, error) {
+	switch query.Sort {
+	case "", ContextSortNewest:
+		query.Sort = ContextSortNewest
+	case ContextSortRelevance, ContextSortSimilarity:
+		if len(contextTerms(query.Query)) == 0 {
+			query.Sort = ContextSortNewest
+		}
+	default:
+		return query, fmt.Errorf("unknown context sort %q (use newest, relevance, or similarity)", query.Sort)
+	}
+	return query, nil
 }
 
 // searchWithRipgrep uses ripgrep to find matching files, then applies metadata filters.
@@ -137,10 +209,13 @@ func searchWithRipgrep(query ContextQuery, storePath string) ([]ContextResult, e
 		if query.Agent != "" && entry.Agent != query.Agent {
 			continue
 		}
+		if query.SessionID != "" && entry.SessionID != query.SessionID {
+			continue
+		}
 		if query.Type != "" && entry.Type != query.Type {
 			continue
 		}
-		if len(query.Tags) > 0 && !hasAnyTag(entry.Tags, query.Tags) {
+		if !matchesContextFilters(entry, query) {
 			continue
 		}
 		results = append(results, *entry)
@@ -169,33 +244,71 @@ func searchNative(query ContextQuery, storePath string) ([]ContextResult, error)
 			return nil // skip unparseable files
 		}
 
-		if query.Agent != "" && entry.Agent != query.Agent {
-			return nil
-		}
-		if query.Type != "" && entry.Type != query.Type {
-			return nil
+		if matchesContextQuery(entry, query) {
+			results = append(results, *entry)
 		}
-		if len(query.Tags) > 0 && !hasAnyTag(entry.Tags, query.Tags) {
-			return nil
-		}
-		if query.Query != "" {
-			if !strings.Contains(strings.ToLower(entry.Content), strings.ToLower(query.Query)) {
-				return nil
-			}
-		}
-
-		results = append(results, *entry)
 		return nil
 	})
 
 	if err != nil {
 		return nil, err
 	}
+	return orderContextResults(results, query), nil
+}
 
+// matchesContextQuery reports whether an entry passes the query's filters.
+// Relevance searches match the words of Query when ranking instead.
+func matchesContextQuery(entry *ContextResult, query ContextQuery) bool {
+	if query.Agent != "" && entry.Agent != query.Agent {
+		return false
+	}
+	if query.SessionID != "" && entry.SessionID != query.SessionID {
+		return false
+	}
+	if query.Type != "" && entry.Type != query.Type {
+		return false
+	}
+	if !matchesContextFilters(entry, query) {
+		return false
+	}
+	if query.Query != "" && query.Sort != ContextSortRelevance {
+		if !strings.Contains(strings.ToLower(entry.Content), strings.ToLower(query.Query)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesContextFilters reports whether an entry has the query's tags and
+// was written in its time range.
+func matchesContextFilters(entry *ContextResult, query ContextQuery) bool {
+	if len(query.Tags) > 0 {
+		if query.AllTags && !hasAllTags(entry.Tags, query.Tags) {
+			return false
+		}
+		if !query.AllTags && !hasAnyTag(entry.Tags, query.Tags) {
+			return false
+		}
+	}
+	if !query.Since.IsZero() || !query.Until.IsZero() {
+		at, err := time.Parse(time.RFC3339, entry.Timestamp)
+		if err != nil || (!query.Since.IsZero() && at.Before(query.Since)) || (!query.Until.IsZero() && !at.Before(query.Until)) {
+			return false
+		}
+	}
+	return true
+}
+
+// orderContextResults sorts matching entries newest first, or for a
+// relevance search, ranks them.
+func orderContextResults(results []ContextResult, query ContextQuery) []ContextResult {
+	if query.Sort == ContextSortRelevance {
+		return rankContextResults(results, contextTerms(query.Query))
+	}
 	sort.Slice(results, func(i, j int) bool {
 		return results[i].Timestamp > results[j].Timestamp
 	})
-	return results, nil
+	return results
 }
 
 // parseContextFile reads and parses a context entry markdown file.
@@ -205,63 +318,24 @@ func parseContextFile(path string) (*ContextResult, error) {
 		return nil, err
 	}
 	defer f.Close()
+	return parseContextEntry(path, f)
+}
 
-	result := &ContextResult{FilePath: path}
-	scanner := bufio.NewScanner(f)
-
-	// Parse YAML frontmatter
-	inFrontmatter := false
-	var contentLines []string
-	currentKey := ""
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		if line == "---" {
-			if !inFrontmatter {
-				inFrontmatter = true
-				continue
-			}
-			inFrontmatter = false
-			continue
-		}
-
-		if inFrontmatter {
-			trimmed := strings.TrimSpace(line)
-			if strings.HasPrefix(trimmed, "- ") && currentKey != "" {
-				val := strings.TrimPrefix(trimmed, "- ")
-				switch currentKey {
-				case "tags":
-					result.Tags = append(result.Tags, val)
-				case "links":
-					result.Links = append(result.Links, val)
-				}
-				continue
-			}
-			if idx := strings.Index(line, ": "); idx >= 0 {
-				key := strings.TrimSpace(line[:idx])
-				val := strings.TrimSpace(line[idx+2:])
-				currentKey = key
-				switch key {
-				case "agent":
-					result.Agent = val
-				case "sessionId":
-					result.SessionID = val
-				case "timestamp":
-					result.Timestamp = val
-				case "type":
-					result.Type = ContextType(val)
-				}
-			} else if strings.HasSuffix(trimmed, ":") {
-				currentKey = strings.TrimSuffix(trimmed, ":")
-			}
-			continue
+// parseContextEntry parses a context entry read from r, reporting location
+// as its FilePath.
+func parseContextEntry(location string, r io.Reader) (*ContextResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	result := &ContextResult{FilePath: location}
+	frontmatter, body, ok := splitContextFrontmatter(string(data))
+	if ok {
+		if err := parseContextFrontmatter(result, frontmatter); err != nil {
+			return nil, fmt.Errorf("%s: %w", location, err)
 		}
-
-		contentLines = append(contentLines, line)
 	}
-
-	result.Content = strings.TrimSpace(strings.Join(contentLines, "\n"))
+	result.Content = strings.TrimSpace(body)
 	return result, nil
 }
 
@@ -276,3 +350,13 @@ func hasAnyTag(entryTags, queryTags []string) bool {
 	}
 	return false
 }
+
+// hasAllTags checks if the entry has every one of the query tags.
+func hasAllTags(entryTags, queryTags []string) bool {
+	for _, qt := range queryTags {
+		if !slices.Contains(entryTags, qt) {
+			return false
+		}
+	}
+	return true
+}
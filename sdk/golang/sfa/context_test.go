@@ -1,10 +1,14 @@
 package sfa
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestWriteContextEntry(t *testing.T) {
@@ -67,6 +71,57 @@ func TestWriteContextEntry(t *testing.T) {
 	}
 }
 
+func TestWriteContextEntryConcurrent(t *testing.T) {
+	tmpDir := t.TempDir()
+	// Build the index, so the writes race to add to it too
+	if _, err := searchContextEntries(ContextQuery{}, tmpDir); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 20
+	paths := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entry := ContextEntry{Type: ContextFinding, Slug: "same", Content: fmt.Sprintf("finding %d", i)}
+			paths[i], errs[i] = writeContextEntry(entry, "test-agent", "session-1", tmpDir)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	for i, path := range paths {
+		if errs[i] != nil {
+			t.Fatalf("write %d failed: %v", i, errs[i])
+		}
+		if seen[path] {
+			t.Errorf("two writes returned %s", path)
+		}
+		seen[path] = true
+		if data, _ := os.ReadFile(path); !strings.Contains(string(data), fmt.Sprintf("finding %d\n", i)) {
+			t.Errorf("entry %d was overwritten: %q", i, data)
+		}
+	}
+	results, err := searchContextEntries(ContextQuery{Agent: "test-agent"}, tmpDir)
+	if err != nil || len(results) != n {
+		t.Errorf("search found %d entries, %v, want %d", len(results), err, n)
+	}
+}
+
+func TestContextEntryName(t *testing.T) {
+	now := time.Date(2026, 2, 21, 14, 30, 22, 0, time.UTC)
+	if got := contextEntryName(now, "leak", 0); got != "20260221T143022-leak.md" {
+		t.Errorf("first name = %q", got)
+	}
+	got := contextEntryName(now, "leak", 1)
+	if !regexp.MustCompile(`^20260221T143022-leak-[0-9a-f]{6}\.md$`).MatchString(got) {
+		t.Errorf("retried name = %q", got)
+	}
+}
+
 func TestSearchContextEntries(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -136,6 +191,56 @@ func TestSearchContextEntries(t *testing.T) {
 	}
 }
 
+func TestSearchContextPaging(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2026, 2, 21, 14, 0, 0, 0, time.UTC)
+	for i, tags := range [][]string{{"db"}, {"db", "auth"}, {"auth"}, {"db", "auth"}, {"db"}} {
+		slug := fmt.Sprintf("d%d", i)
+		data := formatContextEntry(ContextEntry{Type: ContextDecision, Tags: tags, Content: slug}, "planner", "s1", base.Add(time.Duration(i)*time.Hour))
+		path := filepath.Join(dir, "planner", "s1", slug+".md")
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slugs := func(results []ContextResult) string {
+		var s []string
+		for _, r := range results {
+			s = append(s, strings.TrimSpace(r.Content))
+		}
+		return strings.Join(s, ",")
+	}
+
+	for _, tc := range []struct {
+		query ContextQuery
+		want  string
+	}{
+		{ContextQuery{Limit: 2}, "d4,d3"},
+		{ContextQuery{Offset: 2, Limit: 2}, "d2,d1"},
+		{ContextQuery{Offset: 4, Limit: 2}, "d0"},
+		{ContextQuery{Offset: 9}, ""},
+		{ContextQuery{Tags: []string{"db", "auth"}}, "d4,d3,d2,d1,d0"},
+		{ContextQuery{Tags: []string{"db", "auth"}, AllTags: true}, "d3,d1"},
+		{ContextQuery{Since: base.Add(time.Hour), Until: base.Add(3 * time.Hour)}, "d2,d1"},
+		{ContextQuery{Since: base.Add(90 * time.Minute), Tags: []string{"db"}, Limit: 1}, "d4"},
+	} {
+		results, err := searchContextEntries(tc.query, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := slugs(results); got != tc.want {
+			t.Errorf("search %+v = %q, want %q", tc.query, got, tc.want)
+		}
+		native, err := searchNative(tc.query, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := slugs(finishContextResults(native, tc.query)); got != tc.want {
+			t.Errorf("native search %+v = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
 func TestSearchContextEmptyStore(t *testing.T) {
 	tmpDir := t.TempDir()
 
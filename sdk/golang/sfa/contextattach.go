@@ -0,0 +1,176 @@
+package sfa
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// An entry's attachments are kept beside its file, in a directory named for
+// it with ".attachments" in place of ".md":
+//
+//	reviewer/s1/20260221T143022-leak.md
+//	reviewer/s1/20260221T143022-leak.attachments/fix.diff
+//
+// The entry's frontmatter lists their names under attachments.
+
+// ErrContextAttachmentNotFound is returned by ReadAttachment when the entry
+// has no attachment of the name given.
+var ErrContextAttachmentNotFound = errors.New("context attachment not found")
+
+// contextAttachmentPattern keeps attachment names to a safe file name. Names
+// ending in .md are refused, as the store would read them as entries.
+var contextAttachmentPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+// contextAttachmentDir returns the directory of the attachments of the
+// entry at entryPath: a file path, a path relative to the store root, or
+// a URL.
+func contextAttachmentDir(entryPath string) string {
+	return strings.TrimSuffix(entryPath, ".md") + ".attachments"
+}
+
+// contextAttachmentPath returns where the attachment name of the entry at
+// entryPath is kept.
+func contextAttachmentPath(entryPath, name string) string {
+	if strings.Contains(entryPath, "://") {
+		return contextAttachmentDir(entryPath) + "/" + name
+	}
+	return filepath.Join(contextAttachmentDir(entryPath), name)
+}
+
+// contextAttachments returns the attachments named in the frontmatter of
+// the entry at location, or with no location, their names only.
+func contextAttachments(location string, names []string) []ContextAttachment {
+	if len(names) == 0 {
+		return nil
+	}
+	attachments := make([]ContextAttachment, len(names))
+	for i, name := range names {
+		attachments[i].Name = name
+		if location != "" {
+			attachments[i].Path = contextAttachmentPath(location, name)
+		}
+	}
+	return attachments
+}
+
+// contextAttachmentNames returns the names of attachments.
+func contextAttachmentNames(attachments []ContextAttachment) []string {
+	var names []string
+	for _, a := range attachments {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+// validateContextAttachments checks the names of an entry's attachments,
+// and that each gives a Path or Bytes.
+func validateContextAttachments(attachments []ContextAttachment) error {
+	seen := make(map[string]bool)
+	for _, a := range attachments {
+		if !contextAttachmentPattern.MatchString(a.Name) || strings.HasSuffix(strings.ToLower(a.Name), ".md") {
+			return &ContextEntryError{Field: "attachments", Reason: fmt.Sprintf("%q must be letters, digits, and . _ -, starting with a letter or digit, at most 100 characters, and not end in .md", a.Name)}
+		}
+		if seen[a.Name] {
+			return &ContextEntryError{Field: "attachments", Reason: fmt.Sprintf("%q is given twice", a.Name)}
+		}
+		seen[a.Name] = true
+		if (a.Path == "") == (a.Bytes == nil) {
+			return &ContextEntryError{Field: "attachments", Reason: fmt.Sprintf("%q must give one of Path or Bytes", a.Name)}
+		}
+	}
+	return nil
+}
+
+// loadContextAttachments reads the files of attachments given by Path, so
+// that stores are handed the Bytes of each.
+func loadContextAttachments(attachments []ContextAttachment) ([]ContextAttachment, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+	loaded := make([]ContextAttachment, len(attachments))
+	for i, a := range attachments {
+		loaded[i] = ContextAttachment{Name: a.Name, Bytes: a.Bytes}
+		if a.Path == "" {
+			continue
+		}
+		data, err := os.ReadFile(a.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attachment %s: %w", a.Name, err)
+		}
+		loaded[i].Bytes = data
+	}
+	return loaded, nil
+}
+
+// writeContextAttachments writes the attachments of the entry at file.
+func writeContextAttachments(file string, attachments []ContextAttachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+	dir := contextAttachmentDir(file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		if err := os.WriteFile(filepath.Join(dir, a.Name), a.Bytes, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contextAttachmentsSize is the total size of the attachments of the entry
+// at file.
+func contextAttachmentsSize(file string) int64 {
+	var size int64
+	entries, _ := os.ReadDir(contextAttachmentDir(file))
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && !e.IsDir() {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// removeContextEntryFile removes an entry's file and its attachments.
+func removeContextEntryFile(file string) error {
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	os.RemoveAll(contextAttachmentDir(file))
+	return nil
+}
+
+func (s *localContextStore) readAttachment(path, name string) ([]byte, error) {
+	if !contextAttachmentPattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrContextAttachmentNotFound, name)
+	}
+	file, err := contextEntryFile(s.path, path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(contextAttachmentPath(file, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s of %s", ErrContextAttachmentNotFound, name, path)
+	}
+	return data, err
+}
+
+func (s *remoteContextStore) readAttachment(path, name string) ([]byte, error) {
+	if !contextAttachmentPattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrContextAttachmentNotFound, name)
+	}
+	rel, err := s.entryPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.backend.get(contextAttachmentDir(rel) + "/" + name)
+	if errors.Is(err, ErrContextEntryNotFound) {
+		return nil, fmt.Errorf("%w: %s of %s", ErrContextAttachmentNotFound, name, path)
+	}
+	return data, err
+}
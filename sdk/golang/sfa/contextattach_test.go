@@ -0,0 +1,120 @@
+package sfa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContextAttachments(t *testing.T) {
+	store := t.TempDir()
+	def := &AgentDef{Name: "reviewer"}
+	e := &executor{
+		def:          def,
+		resolved:     resolveEnv(nil, def.Name, map[string]any{}),
+		contextStore: &localContextStore{path: store},
+	}
+	ctx := e.executeContext(&execution{ctx: context.Background(), safety: &SafetyState{SessionID: "s1"}}, "", nil)
+
+	diff := filepath.Join(t.TempDir(), "fix.diff")
+	os.WriteFile(diff, []byte("-token\n+masked\n"), 0644)
+	png := []byte{0x89, 'P', 'N', 'G', 0, 0xff}
+	path, err := ctx.WriteContext(ContextEntry{
+		Type:        ContextFinding,
+		Slug:        "leak",
+		Content:     "The token leaks into logs",
+		Attachments: []ContextAttachment{{Name: "fix.diff", Path: diff}, {Name: "shot.png", Bytes: png}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := strings.TrimSuffix(path, ".md") + ".attachments"
+	if data, _ := os.ReadFile(filepath.Join(dir, "shot.png")); !bytes.Equal(data, png) {
+		t.Errorf("stored shot.png = %v", data)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "attachments:\n  - fix.diff\n  - shot.png\n") {
+		t.Errorf("entry file =\n%s", data)
+	}
+
+	result, err := ctx.ReadContext(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ContextAttachment{{Name: "fix.diff", Path: filepath.Join(dir, "fix.diff")}, {Name: "shot.png", Path: filepath.Join(dir, "shot.png")}}
+	if len(result.Attachments) != 2 || result.Attachments[0].Name != want[0].Name || result.Attachments[1].Path != want[1].Path {
+		t.Errorf("attachments = %+v, want %+v", result.Attachments, want)
+	}
+	if results, _ := ctx.SearchContext(ContextQuery{Agent: "reviewer"}); len(results) != 1 || len(results[0].Attachments) != 2 {
+		t.Errorf("search = %+v", results)
+	}
+	if data, err := ctx.ReadAttachment(path, "fix.diff"); err != nil || string(data) != "-token\n+masked\n" {
+		t.Errorf("ReadAttachment() = %q, %v", data, err)
+	}
+	if _, err := ctx.ReadAttachment(path, "missing.txt"); !errors.Is(err, ErrContextAttachmentNotFound) {
+		t.Errorf("missing attachment: %v", err)
+	}
+	if _, err := ctx.ReadAttachment(path, "../leak.md"); !errors.Is(err, ErrContextAttachmentNotFound) {
+		t.Errorf("attachment outside the entry: %v", err)
+	}
+
+	// Updates keep the attachments
+	if err := ctx.AppendContext(path, "Masked in v2"); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := ctx.ReadContext(path); len(result.Attachments) != 2 {
+		t.Errorf("attachments after an update = %+v", result.Attachments)
+	}
+
+	for _, attachments := range [][]ContextAttachment{
+		{{Name: "notes.md", Bytes: []byte("x")}},
+		{{Name: "../x", Bytes: []byte("x")}},
+		{{Name: "a.txt", Bytes: []byte("x")}, {Name: "a.txt", Bytes: []byte("y")}},
+		{{Name: "a.txt"}},
+	} {
+		_, err := ctx.WriteContext(ContextEntry{Type: ContextFinding, Slug: "bad", Content: "x", Attachments: attachments})
+		var entryErr *ContextEntryError
+		if !errors.As(err, &entryErr) || entryErr.Field != "attachments" {
+			t.Errorf("attachments %+v: %v", attachments, err)
+		}
+	}
+
+	// Retention removes the attachments with the entry
+	removed, err := pruneContextStore(store, map[string]time.Duration{"default": time.Hour}, time.Now().Add(2*time.Hour))
+	if err != nil || len(removed) != 1 {
+		t.Fatalf("pruned %v, %v", removed, err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("attachments were left after pruning: %v", err)
+	}
+}
+
+func TestRemoteContextAttachments(t *testing.T) {
+	srv, objects := contextObjectServer(t, "")
+	u, _ := url.Parse(srv.URL)
+	store := &remoteContextStore{url: srv.URL, backend: newHTTPObjectBackend(u, "")}
+	path, err := store.write(ContextEntry{Type: ContextArtifact, Slug: "report", Content: "Weekly report",
+		Attachments: []ContextAttachment{{Name: "data.csv", Bytes: []byte("a,b\n1,2\n")}}}, "reporter", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := strings.TrimPrefix(strings.TrimSuffix(path, ".md"), srv.URL+"/") + ".attachments/data.csv"
+	if objects[rel] != "a,b\n1,2\n" {
+		t.Errorf("server holds %v", objects)
+	}
+	result, err := store.read(path)
+	if err != nil || len(result.Attachments) != 1 || result.Attachments[0].Path != srv.URL+"/"+rel {
+		t.Errorf("read = %+v, %v", result, err)
+	}
+	if data, err := store.readAttachment(path, "data.csv"); err != nil || string(data) != "a,b\n1,2\n" {
+		t.Errorf("readAttachment() = %q, %v", data, err)
+	}
+	if results, _ := store.search(ContextQuery{}); len(results) != 1 {
+		t.Errorf("the attachment was read as an entry: %+v", results)
+	}
+}
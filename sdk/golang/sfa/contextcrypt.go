@@ -0,0 +1,284 @@
+package sfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// With contextStore.encrypt, entry bodies and attachments are stored as
+// "sfa-enc:v1:" followed by base64 of a nonce and AES-256-GCM ciphertext. The frontmatter
+// stays in plaintext, so entries are still found by agent, session, type,
+// tags, and time; the index holds the sealed body. The 32-byte key is
+// SFA_CONTEXT_KEY, base64, or else the keychain item "context-key", created
+// on the first encrypted write. It is not the config key, so rotating that
+// leaves entries readable.
+
+const (
+	contextSealPrefix = "sfa-enc:v1:"
+	contextKeyAccount = "context-key" // keychain account of the key
+)
+
+// ErrContextKeyMissing is returned when an entry is encrypted and neither
+// SFA_CONTEXT_KEY nor the keychain holds the key.
+var ErrContextKeyMissing = errors.New("the context store encryption key is not set")
+
+// contextKey caches the key read from the environment or keychain.
+var contextKey struct {
+	sync.Mutex
+	key []byte
+}
+
+// resolveContextEncryption reports whether config contextStore.encrypt asks
+// for entry bodies to be encrypted.
+func resolveContextEncryption(config map[string]any) bool {
+	cs, _ := config["contextStore"].(map[string]any)
+	encrypt, _ := cs["encrypt"].(bool)
+	return encrypt
+}
+
+// isSealedContext reports whether an entry body is encrypted.
+func isSealedContext(content string) bool {
+	return strings.HasPrefix(strings.TrimSpace(content), contextSealPrefix)
+}
+
+// loadContextKey returns the context key, creating it in the keychain if
+// create is set and there is none.
+func loadContextKey(create bool) ([]byte, error) {
+	contextKey.Lock()
+	defer contextKey.Unlock()
+	if contextKey.key != nil {
+		return contextKey.key, nil
+	}
+	if encoded := os.Getenv("SFA_CONTEXT_KEY"); encoded != "" {
+		key, err := decodeContextKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("SFA_CONTEXT_KEY: %w", err)
+		}
+		contextKey.key = key
+		return key, nil
+	}
+
+	lookup := func() error {
+		encoded, err := keyring.get(keyringService, contextKeyAccount)
+		if err == nil {
+			contextKey.key, err = decodeContextKey(encoded)
+			if err != nil {
+				return fmt.Errorf("the context key in the keychain: %w", err)
+			}
+			return nil
+		}
+		if !errors.Is(err, errKeyringNotFound) {
+			return fmt.Errorf("keyring lookup for the context key failed: %w", err)
+		}
+		if !create {
+			return fmt.Errorf("%w (set SFA_CONTEXT_KEY or write an entry with contextStore.encrypt to create one)", ErrContextKeyMissing)
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		if err := keyring.set(keyringService, contextKeyAccount, base64.StdEncoding.EncodeToString(key)); err != nil {
+			return fmt.Errorf("failed to store the context key in the keyring: %w", err)
+		}
+		contextKey.key = key
+		return nil
+	}
+	// Agents writing their first entries at once must not each create a key
+	var err error
+	if lock := dataDir(contextKeyAccount); create && lock != "" {
+		err = withFileLock(lock, lookup)
+	} else {
+		err = lookup()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return contextKey.key, nil
+}
+
+// decodeContextKey decodes a base64 256-bit key.
+func decodeContextKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("not a base64 256-bit key")
+	}
+	return key, nil
+}
+
+// contextAEAD returns the AES-256-GCM cipher of key.
+func contextAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealContextContent encrypts an entry body.
+func sealContextContent(key []byte, content string) (string, error) {
+	aead, err := contextAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return contextSealPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(content), nil)), nil
+}
+
+// openContextContent decrypts an entry body, returning one that is not
+// encrypted as it is.
+func openContextContent(content string) (string, error) {
+	if !isSealedContext(content) {
+		return content, nil
+	}
+	key, err := loadContextKey(false)
+	if err != nil {
+		return "", err
+	}
+	aead, err := contextAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(content), contextSealPrefix))
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted entry")
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt entry: encrypted with a different context key")
+	}
+	return string(plain), nil
+}
+
+// openContextResult decrypts a result's content in place.
+func openContextResult(r *ContextResult) error {
+	content, err := openContextContent(r.Content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.FilePath, err)
+	}
+	r.Content = content
+	return nil
+}
+
+// sealedContextStore encrypts the bodies of the entries written to the
+// store it wraps and decrypts those read from it.
+type sealedContextStore struct {
+	contextStore
+}
+
+func (s *sealedContextStore) write(entry ContextEntry, agentName, sessionID string) (string, error) {
+	key, err := loadContextKey(true)
+	if err != nil {
+		return "", err
+	}
+	if entry.Content, err = sealContextContent(key, entry.Content); err != nil {
+		return "", err
+	}
+	attachments := make([]ContextAttachment, len(entry.Attachments))
+	for i, a := range entry.Attachments {
+		sealed, err := sealContextContent(key, string(a.Bytes))
+		if err != nil {
+			return "", err
+		}
+		attachments[i] = ContextAttachment{Name: a.Name, Bytes: []byte(sealed)}
+	}
+	entry.Attachments = attachments
+	return s.contextStore.write(entry, agentName, sessionID)
+}
+
+func (s *sealedContextStore) readAttachment(path, name string) ([]byte, error) {
+	data, err := s.contextStore.readAttachment(path, name)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := openContextContent(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s of %s: %w", name, path, err)
+	}
+	return []byte(plain), nil
+}
+
+func (s *sealedContextStore) read(pathOrID string) (*ContextResult, error) {
+	result, err := s.contextStore.read(pathOrID)
+	if err != nil {
+		return nil, err
+	}
+	if err := openContextResult(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// search finds the entries passing the query's metadata filters in the
+// store, then decrypts them to match and rank the text of Query. Similarity
+// searches rank by relevance, as the provider would be sent the plaintext.
+func (s *sealedContextStore) search(query ContextQuery) ([]ContextResult, error) {
+	query, err := normalizeContextSort(query)
+	if err != nil {
+		return nil, err
+	}
+	if query.Query == "" {
+		results, err := s.contextStore.search(query)
+		if err != nil {
+			return nil, err
+		}
+		for i := range results {
+			if err := openContextResult(&results[i]); err != nil {
+				return nil, err
+			}
+		}
+		return results, nil
+	}
+
+	if query.Sort == ContextSortSimilarity {
+		query.Sort = ContextSortRelevance
+	}
+	filter := query
+	filter.Query, filter.Sort, filter.Offset, filter.Limit = "", ContextSortNewest, 0, 0
+	results, err := s.contextStore.search(filter)
+	if err != nil {
+		return nil, err
+	}
+	var matched []ContextResult
+	for i := range results {
+		if err := openContextResult(&results[i]); err != nil {
+			return nil, err
+		}
+		if matchesContextQuery(&results[i], query) {
+			matched = append(matched, results[i])
+		}
+	}
+	return finishContextResults(orderContextResults(matched, query), query), nil
+}
+
+// rewrite lets edit change the plaintext of the entry, sealing it again.
+func (s *sealedContextStore) rewrite(path string, edit func(doc *contextDocument) bool) error {
+	key, err := loadContextKey(true)
+	if err != nil {
+		return err
+	}
+	var editErr error
+	err = s.contextStore.rewrite(path, func(doc *contextDocument) bool {
+		if doc.Content, editErr = openContextContent(doc.Content); editErr != nil {
+			return false
+		}
+		if !edit(doc) {
+			return false
+		}
+		doc.Content, editErr = sealContextContent(key, doc.Content)
+		return editErr == nil
+	})
+	if err == nil && editErr != nil {
+		err = fmt.Errorf("%s: %w", path, editErr)
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package sfa
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSealedContextStore(t *testing.T) {
+	fake := useFakeKeyring(t)
+	t.Setenv("SFA_CONTEXT_KEY", "")
+	t.Setenv("SFA_CONTEXT_STORE_URL", "")
+	t.Setenv("SFA_CONTEXT_STORE", "")
+	t.Setenv("SFA_DATA_HOME", t.TempDir())
+	contextKey.key = nil
+	t.Cleanup(func() { contextKey.key = nil })
+
+	dir := t.TempDir()
+	store, err := openContextStore(map[string]any{"contextStore": map[string]any{"path": dir, "encrypt": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := store.write(ContextEntry{Type: ContextFinding, Tags: []string{"auth"}, Slug: "leak", Content: "The session token leaks into logs",
+		Attachments: []ContextAttachment{{Name: "log.txt", Bytes: []byte("token=abc123")}}}, "reviewer", "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.items[keyringService+"|"+contextKeyAccount] == "" {
+		t.Error("the first encrypted write did not create the context key")
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "token") || !strings.Contains(string(data), "\n  - auth\n") || !strings.Contains(string(data), contextSealPrefix) {
+		t.Errorf("entry file =\n%s\nwant plaintext frontmatter and a sealed body", data)
+	}
+	store.write(ContextEntry{Type: ContextFinding, Tags: []string{"auth"}, Slug: "other", Content: "Unrelated"}, "reviewer", "s1")
+	if data, _ := os.ReadFile(contextAttachmentPath(path, "log.txt")); strings.Contains(string(data), "abc123") {
+		t.Error("the attachment is stored in plaintext")
+	}
+	if data, err := store.readAttachment(path, "log.txt"); err != nil || string(data) != "token=abc123" {
+		t.Errorf("readAttachment() = %q, %v", data, err)
+	}
+
+	if r, err := store.read(path); err != nil || r.Content != "The session token leaks into logs" {
+		t.Errorf("read = %+v, %v", r, err)
+	}
+	results, err := store.search(ContextQuery{Tags: []string{"auth"}, Query: "TOKEN"})
+	if err != nil || len(results) != 1 || results[0].FilePath != path || !strings.Contains(results[0].Snippet, "**token**") {
+		t.Errorf("search = %+v, %v", results, err)
+	}
+	if results, _ := store.search(ContextQuery{Query: "leaks tokens", Sort: ContextSortRelevance}); len(results) != 1 {
+		t.Errorf("relevance search = %+v", results)
+	}
+
+	if err := store.rewrite(path, func(doc *contextDocument) bool { return doc.appendContent("Fixed in v2") != "" }); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "Fixed") {
+		t.Error("rewrite stored the content in plaintext")
+	}
+	if r, _ := store.read(path); !strings.HasSuffix(r.Content, "\n\nFixed in v2") {
+		t.Errorf("content after rewrite = %q", r.Content)
+	}
+
+	// Another key cannot read the entries; no key at all is reported
+	contextKey.key = nil
+	t.Setenv("SFA_CONTEXT_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if _, err := store.read(path); err == nil || !strings.Contains(err.Error(), "different context key") {
+		t.Errorf("read with another key: %v", err)
+	}
+	contextKey.key = nil
+	t.Setenv("SFA_CONTEXT_KEY", "")
+	delete(fake.items, keyringService+"|"+contextKeyAccount)
+	if _, err := store.search(ContextQuery{}); !errors.Is(err, ErrContextKeyMissing) {
+		t.Errorf("search without a key: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package sfa
+
+import (
+	"slices"
+	"strings"
+)
+
+// contextEntryHash identifies an entry by its type, tags, and content, so
+// that a write repeating an entry is recognized. Tag order and surrounding
+// whitespace do not matter.
+func contextEntryHash(typ ContextType, tags []string, content string) string {
+	sorted := slices.Clone(tags)
+	slices.Sort(sorted)
+	return contentHash(string(typ) + "\n" + strings.Join(sorted, "\n") + "\n\n" + strings.TrimSpace(content))
+}
+
+// findDuplicateContext returns the path of the agent's entry in the session
+// with the type, tags, and content of entry, or "" if there is none.
+// Entries outside a session are compared with the agent's others outside
+// one.
+func findDuplicateContext(store contextStore, entry ContextEntry, agentName, sessionID string) (string, error) {
+	results, err := store.search(ContextQuery{Agent: agentName, SessionID: sessionID, Type: entry.Type, Tags: entry.Tags, AllTags: true})
+	if err != nil {
+		return "", err
+	}
+	hash := contextEntryHash(entry.Type, entry.Tags, entry.Content)
+	for _, r := range results {
+		if r.SessionID == sessionID && contextEntryHash(r.Type, r.Tags, r.Content) == hash {
+			return r.FilePath, nil
+		}
+	}
+	return "", nil
+}
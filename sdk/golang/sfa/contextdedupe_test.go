@@ -0,0 +1,37 @@
+package sfa
+
+import "testing"
+
+func TestFindDuplicateContext(t *testing.T) {
+	store := &localContextStore{path: t.TempDir()}
+	entry := ContextEntry{Type: ContextFinding, Tags: []string{"auth", "security"}, Slug: "leak", Content: "Token leaks into logs"}
+	path, err := store.write(entry, "reviewer", "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		entry   ContextEntry
+		agent   string
+		session string
+		want    string
+	}{
+		{"identical", entry, "reviewer", "s1", path},
+		{"tags reordered, other slug", ContextEntry{Type: ContextFinding, Tags: []string{"security", "auth"}, Slug: "again", Content: "\nToken leaks into logs\n"}, "reviewer", "s1", path},
+		{"other content", ContextEntry{Type: ContextFinding, Tags: entry.Tags, Content: "Token leaks into traces"}, "reviewer", "s1", ""},
+		{"other type", ContextEntry{Type: ContextDecision, Tags: entry.Tags, Content: entry.Content}, "reviewer", "s1", ""},
+		{"fewer tags", ContextEntry{Type: ContextFinding, Tags: []string{"auth"}, Content: entry.Content}, "reviewer", "s1", ""},
+		{"other session", entry, "reviewer", "s2", ""},
+		{"outside a session", entry, "reviewer", "", ""},
+		{"other agent", entry, "fixer", "s1", ""},
+	} {
+		got, err := findDuplicateContext(store, tc.entry, tc.agent, tc.session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: findDuplicateContext() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
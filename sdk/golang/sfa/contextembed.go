@@ -0,0 +1,252 @@
+package sfa
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// embeddingsBatch is how many texts one request to the embeddings endpoint
+// carries.
+const embeddingsBatch = 64
+
+// contextEmbeddingsSchema creates the embeddings table of the context
+// index: one vector per entry, of the model named, for the content with
+// the hash given, so a changed entry or model is embedded again. Vectors
+// are little-endian float32s.
+const contextEmbeddingsSchema = `CREATE TABLE IF NOT EXISTS embeddings (
+  path TEXT PRIMARY KEY,
+  model TEXT NOT NULL,
+  hash TEXT NOT NULL,
+  vector BLOB NOT NULL
+);
+`
+
+// embeddingsProvider is an OpenAI-compatible embeddings endpoint, as
+// served by OpenAI, Ollama, vLLM, and others.
+type embeddingsProvider struct {
+	URL    string
+	Model  string
+	APIKey string
+	client *http.Client
+}
+
+// embeddingsFromEnv returns the provider SFA_EMBEDDINGS_URL,
+// SFA_EMBEDDINGS_MODEL, and SFA_EMBEDDINGS_API_KEY configure, or nil
+// without a URL.
+func embeddingsFromEnv() *embeddingsProvider {
+	url := os.Getenv("SFA_EMBEDDINGS_URL")
+	if url == "" {
+		return nil
+	}
+	return &embeddingsProvider{
+		URL:    url,
+		Model:  os.Getenv("SFA_EMBEDDINGS_MODEL"),
+		APIKey: os.Getenv("SFA_EMBEDDINGS_API_KEY"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// embed returns the embeddings of texts, in order.
+func (p *embeddingsProvider) embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingsBatch {
+		batch := texts[start:min(start+embeddingsBatch, len(texts))]
+		body, _ := json.Marshal(map[string]any{"model": p.Model, "input": batch})
+		req, err := http.NewRequest(http.MethodPost, p.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if p.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+p.APIKey)
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("embeddings endpoint is unavailable: %w", err)
+		}
+		var out struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float32 `json:"embedding"`
+			} `json:"data"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("embeddings endpoint returned %s", resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid embeddings response: %w", err)
+		}
+		if len(out.Data) != len(batch) {
+			return nil, fmt.Errorf("embeddings endpoint returned %d vectors for %d texts", len(out.Data), len(batch))
+		}
+		sort.Slice(out.Data, func(i, j int) bool { return out.Data[i].Index < out.Data[j].Index })
+		for _, d := range out.Data {
+			vectors = append(vectors, d.Embedding)
+		}
+	}
+	return vectors, nil
+}
+
+// contentHash identifies the content an embedding is of.
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:8])
+}
+
+// encodeVector returns a vector as the hex of its little-endian float32s.
+func encodeVector(v []float32) string {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return hex.EncodeToString(buf)
+}
+
+// decodeVector reverses encodeVector; nil if s is not a vector.
+func decodeVector(s string) []float32 {
+	buf, err := hex.DecodeString(s)
+	if err != nil || len(buf)%4 != 0 {
+		return nil
+	}
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, or 0
+// if their lengths differ or either is zero.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// searchContextSimilar answers a ContextSortSimilarity query from the
+// store's index: entries passing the other filters, most similar to Query
+// first. Entries without a current embedding are embedded and stored first.
+func searchContextSimilar(query ContextQuery, storePath string, provider *embeddingsProvider) ([]ContextResult, error) {
+	sql := "SELECT " + contextIndexColumns + ", m.model AS model, m.hash AS hash, hex(m.vector) AS vector FROM entries e LEFT JOIN embeddings m ON m.path = e.path"
+	if where := contextIndexFilters(query); len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	out, err := queryContextIndex(storePath, contextEmbeddingsSchema+sql+";\n")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := parseContextIndexRows(out)
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(rows))
+	var stale []int
+	for i, row := range rows {
+		if row.Model == provider.Model && row.Hash == contentHash(row.Content) {
+			vectors[i] = decodeVector(row.Vector)
+		}
+		if vectors[i] == nil {
+			stale = append(stale, i)
+		}
+	}
+	if len(stale) > 0 {
+		texts := make([]string, len(stale))
+		for j, i := range stale {
+			texts[j] = rows[i].Content
+		}
+		embedded, err := provider.embed(texts)
+		if err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		b.WriteString("BEGIN;\n")
+		for j, i := range stale {
+			vectors[i] = embedded[j]
+			b.WriteString(fmt.Sprintf("INSERT OR REPLACE INTO embeddings VALUES (%s, %s, %s, X'%s');\n",
+				sqlQuote(rows[i].Path), sqlQuote(provider.Model), sqlQuote(contentHash(rows[i].Content)), encodeVector(embedded[j])))
+		}
+		b.WriteString("COMMIT;\n")
+		// The vectors are used either way; failing to keep them only costs
+		// embedding them again
+		if _, err := runSQLite(storePath, b.String()); err != nil {
+			stderrLog.Warn(fmt.Sprintf("failed to store context embeddings: %v", err))
+		}
+	}
+
+	results := make([]ContextResult, len(rows))
+	for i, row := range rows {
+		results[i] = row.result(storePath)
+	}
+	return sortBySimilarity(results, vectors, query.Query, provider)
+}
+
+// rankContextSimilar orders results most similar to text first, embedding
+// every one of them; it serves stores without an index to keep vectors in.
+func rankContextSimilar(results []ContextResult, text string, provider *embeddingsProvider) ([]ContextResult, error) {
+	if len(results) == 0 {
+		return results, nil
+	}
+	texts := make([]string, len(results))
+	for i, r := range results {
+		texts[i] = r.Content
+	}
+	vectors, err := provider.embed(texts)
+	if err != nil {
+		return nil, err
+	}
+	return sortBySimilarity(results, vectors, text, provider)
+}
+
+// sortBySimilarity orders results, whose embeddings are vectors, by their
+// similarity to text, newest first among equals.
+func sortBySimilarity(results []ContextResult, vectors [][]float32, text string, provider *embeddingsProvider) ([]ContextResult, error) {
+	q, err := provider.embed([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	if len(q) != 1 || len(q[0]) == 0 {
+		return nil, errors.New("embeddings endpoint returned an empty vector")
+	}
+	type scored struct {
+		result ContextResult
+		score  float64
+	}
+	ranked := make([]scored, len(results))
+	for i, r := range results {
+		ranked[i] = scored{r, cosineSimilarity(q[0], vectors[i])}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].result.Timestamp > ranked[j].result.Timestamp
+	})
+	for i, r := range ranked {
+		results[i] = r.result
+	}
+	return results, nil
+}
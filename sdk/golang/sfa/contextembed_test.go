@@ -0,0 +1,132 @@
+package sfa
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEncodeVector(t *testing.T) {
+	v := []float32{1, -0.5, 3.25}
+	got := decodeVector(encodeVector(v))
+	if len(got) != 3 || got[0] != 1 || got[1] != -0.5 || got[2] != 3.25 {
+		t.Errorf("decodeVector(encodeVector(%v)) = %v", v, got)
+	}
+	if decodeVector("abc") != nil {
+		t.Error("decodeVector() of odd hex should be nil")
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	if got := cosineSimilarity([]float32{1, 0}, []float32{2, 0}); got != 1 {
+		t.Errorf("parallel = %v, want 1", got)
+	}
+	if got := cosineSimilarity([]float32{1, 0}, []float32{0, 1}); got != 0 {
+		t.Errorf("orthogonal = %v, want 0", got)
+	}
+	if got := cosineSimilarity([]float32{1}, []float32{1, 0}); got != 0 {
+		t.Errorf("mismatched lengths = %v, want 0", got)
+	}
+}
+
+// fakeEmbeddings serves vectors of [pets, weather] word counts and counts
+// the texts it embeds.
+func fakeEmbeddings(t *testing.T, embedded *atomic.Int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string
+			Input []string
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "test-model" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		type datum struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		var data []datum
+		for i, text := range req.Input {
+			embedded.Add(1)
+			var v [2]float32
+			for _, w := range contextWords(text) {
+				switch w {
+				case "cat", "dog", "kitten":
+					v[0]++
+				case "rain", "storm", "sunny":
+					v[1]++
+				}
+			}
+			data = append(data, datum{i, v[:]})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSearchContextSimilarity(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	store := t.TempDir()
+	for _, e := range []ContextEntry{
+		{Type: ContextFinding, Slug: "pets", Content: "The cat and the dog share a bowl."},
+		{Type: ContextFinding, Slug: "weather", Content: "Storm warnings and rain all week."},
+	} {
+		if _, err := writeContextEntry(e, "notes", "", store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var embedded atomic.Int32
+	srv := fakeEmbeddings(t, &embedded)
+	t.Setenv("SFA_EMBEDDINGS_URL", srv.URL)
+	t.Setenv("SFA_EMBEDDINGS_MODEL", "test-model")
+	t.Setenv("SFA_EMBEDDINGS_API_KEY", "secret")
+
+	search := func() []ContextResult {
+		t.Helper()
+		results, err := searchContextEntries(ContextQuery{Query: "kitten", Sort: ContextSortSimilarity}, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+	results := search()
+	if len(results) != 2 || !strings.HasSuffix(results[0].FilePath, "-pets.md") {
+		t.Fatalf("results = %+v, want pets first of both entries", results)
+	}
+	if n := embedded.Load(); n != 3 {
+		t.Errorf("embedded %d texts, want both entries and the query", n)
+	}
+
+	// Stored embeddings are reused
+	search()
+	if n := embedded.Load(); n != 4 {
+		t.Errorf("embedded %d texts in total, want only the query again", n)
+	}
+
+	// Without a provider the search ranks by relevance, matching words
+	t.Setenv("SFA_EMBEDDINGS_URL", "")
+	results, err := searchContextEntries(ContextQuery{Query: "rain", Sort: ContextSortSimilarity}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !strings.HasSuffix(results[0].FilePath, "-weather.md") {
+		t.Fatalf("fallback results = %+v, want the weather entry", results)
+	}
+
+	// A failing provider falls back as well
+	t.Setenv("SFA_EMBEDDINGS_URL", srv.URL)
+	t.Setenv("SFA_EMBEDDINGS_API_KEY", "wrong")
+	results, err = searchContextEntries(ContextQuery{Query: "rain", Sort: ContextSortSimilarity}, store)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("results with a failing provider = %+v, %v; want the relevance match", results, err)
+	}
+}
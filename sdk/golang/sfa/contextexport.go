@@ -0,0 +1,231 @@
+package sfa
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// contextBundleVersion is the version of the JSON export format.
+const contextBundleVersion = 1
+
+// contextBundle is the JSON export format: each entry's file, at its path
+// relative to the store root.
+type contextBundle struct {
+	Version    int                  `json:"version"`
+	ExportedAt string               `json:"exportedAt"`
+	Agent      string               `json:"agent,omitempty"`
+	SessionID  string               `json:"sessionId,omitempty"`
+	Entries    []contextBundleEntry `json:"entries"`
+}
+
+type contextBundleEntry struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// collectContextExport returns the entries opts selects, by path.
+func collectContextExport(storePath string, opts ContextExportOpts) ([]contextBundleEntry, error) {
+	root := storePath
+	if opts.Agent != "" {
+		root = filepath.Join(storePath, opts.Agent)
+	}
+	var entries []contextBundleEntry
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".md") {
+			return nil
+		}
+		rel, err := filepath.Rel(storePath, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if !inContextSession(rel, opts.SessionID) {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, contextBundleEntry{Path: rel, Content: string(data)})
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, err
+}
+
+// inContextSession reports whether the entry at rel, relative to the store
+// root, belongs to sessionID; every entry does for an empty sessionID.
+func inContextSession(rel, sessionID string) bool {
+	if sessionID == "" {
+		return true
+	}
+	parts := strings.Split(rel, "/")
+	return len(parts) == 3 && parts[1] == sessionID
+}
+
+// exportContext bundles the entries opts selects in its format.
+func exportContext(storePath string, opts ContextExportOpts) ([]byte, error) {
+	entries, err := collectContextExport(storePath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read context store: %w", err)
+	}
+	return encodeContextBundle(entries, opts)
+}
+
+// encodeContextBundle encodes entries in the format opts names.
+func encodeContextBundle(entries []contextBundleEntry, opts ContextExportOpts) ([]byte, error) {
+	switch opts.Format {
+	case "", ContextExportJSON:
+		if entries == nil {
+			entries = []contextBundleEntry{}
+		}
+		return json.MarshalIndent(contextBundle{
+			Version:    contextBundleVersion,
+			ExportedAt: time.Now().UTC().Format(time.RFC3339),
+			Agent:      opts.Agent,
+			SessionID:  opts.SessionID,
+			Entries:    entries,
+		}, "", "  ")
+	case ContextExportTar:
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		now := time.Now()
+		for _, e := range entries {
+			hdr := &tar.Header{Name: e.Path, Mode: 0644, Size: int64(len(e.Content)), ModTime: now}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return nil, err
+			}
+			if _, err := io.WriteString(tw, e.Content); err != nil {
+				return nil, err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown context export format %q (use json or tar)", opts.Format)
+}
+
+// readContextBundle decodes an export in either format, told apart by the
+// gzip magic number, and checks that every entry in it is one.
+func readContextBundle(data []byte) ([]contextBundleEntry, error) {
+	entries, err := decodeContextBundle(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !validContextBundlePath(e.Path) || !strings.HasPrefix(e.Content, "---\n") {
+			return nil, fmt.Errorf("invalid context export: %q is not a context entry", e.Path)
+		}
+	}
+	return entries, nil
+}
+
+func decodeContextBundle(data []byte) ([]contextBundleEntry, error) {
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		var bundle contextBundle
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return nil, fmt.Errorf("invalid context export: %w", err)
+		}
+		if bundle.Version != contextBundleVersion {
+			return nil, fmt.Errorf("unsupported context export version %d", bundle.Version)
+		}
+		return bundle.Entries, nil
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid context export: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var entries []contextBundleEntry
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid context export: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid context export: %w", err)
+		}
+		entries = append(entries, contextBundleEntry{Path: hdr.Name, Content: string(content)})
+	}
+}
+
+// validContextBundlePath reports whether p is where an entry may live:
+// <agent>/<file>.md or <agent>/<session>/<file>.md, with nothing that
+// leaves the store.
+func validContextBundlePath(p string) bool {
+	if p != path.Clean(p) || path.IsAbs(p) || !strings.HasSuffix(p, ".md") {
+		return false
+	}
+	parts := strings.Split(p, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.HasPrefix(part, ".") || strings.Contains(part, `\`) {
+			return false
+		}
+	}
+	return true
+}
+
+// importContext writes the entries of an export into the store and indexes
+// them. Entries whose path the store already has are left as they are. It
+// returns the absolute paths of the entries written.
+func importContext(storePath string, data []byte) ([]string, error) {
+	entries, err := readContextBundle(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var imported []string
+	for _, e := range entries {
+		dest := filepath.Join(storePath, filepath.FromSlash(e.Path))
+		if _, err := os.Stat(dest); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return imported, fmt.Errorf("failed to create context directory: %w", err)
+		}
+		if err := os.WriteFile(dest, []byte(e.Content), 0644); err != nil {
+			return imported, fmt.Errorf("failed to write context entry: %w", err)
+		}
+		indexContextFile(storePath, dest)
+		if abs, err := filepath.Abs(dest); err == nil {
+			dest = abs
+		}
+		imported = append(imported, dest)
+	}
+	return imported, nil
+}
@@ -0,0 +1,82 @@
+package sfa
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportImportContext(t *testing.T) {
+	src := t.TempDir()
+	write := func(agent, session, slug string) {
+		t.Helper()
+		if _, err := writeContextEntry(ContextEntry{Type: ContextFinding, Slug: slug, Content: slug}, agent, session, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("reviewer", "s1", "in-session")
+	write("reviewer", "s2", "other-session")
+	write("reviewer", "", "no-session")
+	write("fixer", "s1", "fixer-in-session")
+
+	for _, format := range []ContextExportFormat{ContextExportJSON, ContextExportTar} {
+		t.Run(string(format), func(t *testing.T) {
+			data, err := exportContext(src, ContextExportOpts{Agent: "reviewer", SessionID: "s1", Format: format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst := t.TempDir()
+			imported, err := importContext(dst, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(imported) != 1 || !strings.HasSuffix(imported[0], "-in-session.md") || filepath.Base(filepath.Dir(imported[0])) != "s1" {
+				t.Fatalf("imported %v, want the one reviewer entry of s1", imported)
+			}
+			results, err := searchNative(ContextQuery{}, dst)
+			if err != nil || len(results) != 1 || results[0].Content != "in-session" {
+				t.Errorf("imported store holds %+v, %v", results, err)
+			}
+
+			// Importing again leaves what is already there
+			if again, err := importContext(dst, data); err != nil || len(again) != 0 {
+				t.Errorf("second import wrote %v, %v", again, err)
+			}
+		})
+	}
+
+	data, err := exportContext(src, ContextExportOpts{SessionID: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle contextBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Entries) != 2 || !strings.HasPrefix(bundle.Entries[0].Path, "fixer/s1/") {
+		t.Errorf("session export holds %+v, want both agents' s1 entries", bundle.Entries)
+	}
+
+	if _, err := exportContext(src, ContextExportOpts{Format: "zip"}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestImportContextRejectsEscapingPaths(t *testing.T) {
+	for _, p := range []string{"../evil.md", "/abs/x.md", "agent/../../x.md", ".index.db", "agent/.hidden.md", "a/b/c/d.md", "agent/x.txt"} {
+		data, _ := json.Marshal(contextBundle{Version: contextBundleVersion, Entries: []contextBundleEntry{{Path: p, Content: "---\n---\n"}}})
+		if _, err := importContext(t.TempDir(), data); err == nil {
+			t.Errorf("importContext accepted %q", p)
+		}
+	}
+
+	data, _ := json.Marshal(contextBundle{Version: contextBundleVersion, Entries: []contextBundleEntry{{Path: "agent/x.md", Content: "no frontmatter"}}})
+	if _, err := importContext(t.TempDir(), data); err == nil {
+		t.Error("importContext accepted an entry without frontmatter")
+	}
+
+	if _, err := importContext(t.TempDir(), []byte(`{"version": 99, "entries": []}`)); err == nil {
+		t.Error("importContext accepted an unknown version")
+	}
+}
@@ -0,0 +1,164 @@
+package sfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// contextFrontmatterKeys are the frontmatter keys the store sets. Any other
+// key is the entry's metadata.
+var contextFrontmatterKeys = []string{"agent", "sessionId", "timestamp", "type", "tags", "links", "attachments"}
+
+// splitContextFrontmatter splits an entry file into the YAML between its
+// "---" fences and the body after them. A file that does not open with a
+// fence is all body.
+func splitContextFrontmatter(data string) (string, string, bool) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	rest, ok := strings.CutPrefix(data, "---\n")
+	if !ok {
+		return "", data, false
+	}
+	if strings.HasPrefix(rest, "---\n") || rest == "---" {
+		return "", strings.TrimPrefix(rest, "---"), true
+	}
+	end := strings.Index(rest, "\n---\n")
+	if end < 0 {
+		if !strings.HasSuffix(rest, "\n---") {
+			return "", data, false
+		}
+		end = len(rest) - len("\n---")
+	}
+	return rest[:end+1], rest[min(end+len("\n---\n"), len(rest)):], true
+}
+
+// parseContextFrontmatter sets the fields of result from the YAML of an
+// entry's frontmatter, keeping keys the store does not set as Metadata.
+func parseContextFrontmatter(result *ContextResult, frontmatter string) error {
+	fields, err := parseYAML(frontmatter)
+	if err != nil {
+		return fmt.Errorf("invalid frontmatter: %w", err)
+	}
+	for key, v := range fields {
+		switch key {
+		case "agent":
+			result.Agent = frontmatterString(v)
+		case "sessionId":
+			result.SessionID = frontmatterString(v)
+		case "timestamp":
+			result.Timestamp = frontmatterString(v)
+		case "type":
+			result.Type = ContextType(frontmatterString(v))
+		case "tags":
+			result.Tags = frontmatterList(v)
+		case "links":
+			result.Links = frontmatterList(v)
+		case "attachments":
+			result.Attachments = contextAttachments(result.FilePath, frontmatterList(v))
+		default:
+			if result.Metadata == nil {
+				result.Metadata = make(map[string]any)
+			}
+			result.Metadata[key] = v
+		}
+	}
+	return nil
+}
+
+// frontmatterString reads a scalar field as a string, so that a session ID
+// of digits written by hand is not a number.
+func frontmatterString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64, bool:
+		return yamlScalar(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// frontmatterList reads a list field, a lone scalar being a list of one.
+func frontmatterList(v any) []string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, frontmatterString(item))
+		}
+		return list
+	}
+	if s := frontmatterString(v); s != "" {
+		return []string{s}
+	}
+	return nil
+}
+
+// writeContextFrontmatter writes the "---" fenced frontmatter of an entry:
+// the fields the store sets, then its metadata with sorted keys.
+func writeContextFrontmatter(b *strings.Builder, doc *contextDocument) {
+	b.WriteString("---\n")
+	b.WriteString("agent: " + frontmatterScalar(doc.Agent) + "\n")
+	if doc.SessionID != "" {
+		b.WriteString("sessionId: " + frontmatterScalar(doc.SessionID) + "\n")
+	}
+	b.WriteString("timestamp: " + frontmatterScalar(doc.Timestamp) + "\n")
+	b.WriteString("type: " + frontmatterScalar(string(doc.Type)) + "\n")
+	for _, field := range []struct {
+		key  string
+		list []string
+	}{{"tags", doc.Tags}, {"links", doc.Links}, {"attachments", doc.Attachments}} {
+		if len(field.list) == 0 {
+			continue
+		}
+		b.WriteString(field.key + ":\n")
+		for _, item := range field.list {
+			b.WriteString("  - " + frontmatterScalar(item) + "\n")
+		}
+	}
+	if metadata := contextMetadataValues(doc.Metadata); len(metadata) > 0 {
+		writeYAMLMap(b, metadata, 0)
+	}
+	b.WriteString("---\n")
+}
+
+// frontmatterScalar formats a string field, plain unless YAML would read it
+// back as something else. Unlike yamlScalar, a colon not followed by a
+// space stays plain, so timestamps and tags such as "cwe:79" are written
+// as they always were.
+func frontmatterScalar(s string) string {
+	if v, err := parseYAMLInline(s); err == nil && v == any(s) && s == strings.TrimSpace(s) &&
+		!strings.Contains(s, ": ") && !strings.HasSuffix(s, ":") && !strings.Contains(s, " #") &&
+		!strings.ContainsAny(s, "\"'\n\t") && !strings.ContainsAny(s[:1], "-?,[]{}&*!|>%@`#") {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// contextMetadataValues returns metadata as the JSON types the YAML writer
+// knows, without the keys the store sets: an int becomes a float64 and a
+// struct a map, as encoding/json would read them back.
+func contextMetadataValues(metadata map[string]any) map[string]any {
+	if len(metadata) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil
+	}
+	var values map[string]any
+	if json.Unmarshal(data, &values) != nil {
+		return nil
+	}
+	for key := range values {
+		if slices.Contains(contextFrontmatterKeys, key) {
+			delete(values, key)
+		}
+	}
+	return values
+}
@@ -0,0 +1,178 @@
+package sfa
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseContextFrontmatter(t *testing.T) {
+	data := "---\r\n" +
+		"agent: \"code-reviewer\"\r\n" +
+		"sessionId: 1234\r\n" +
+		"timestamp: 2026-02-21T14:30:22Z\r\n" +
+		"type: 'finding'\r\n" +
+		"tags: [auth, cwe:79]\r\n" +
+		"title: \"Token: leaks # into logs\"\r\n" +
+		"summary: |\r\n" +
+		"  Line one\r\n" +
+		"  Line two\r\n" +
+		"owner:\r\n" +
+		"  team: sécurité\r\n" +
+		"  on-call: [ana, bo]\r\n" +
+		"---\r\n\r\nThe body\r\n"
+	result, err := parseContextEntry("entry.md", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Agent != "code-reviewer" || result.SessionID != "1234" || result.Timestamp != "2026-02-21T14:30:22Z" || result.Type != ContextFinding {
+		t.Errorf("fields = %+v", result)
+	}
+	if !reflect.DeepEqual(result.Tags, []string{"auth", "cwe:79"}) {
+		t.Errorf("tags = %q", result.Tags)
+	}
+	want := map[string]any{
+		"title":   "Token: leaks # into logs",
+		"summary": "Line one\nLine two\n",
+		"owner":   map[string]any{"team": "sécurité", "on-call": []any{"ana", "bo"}},
+	}
+	if !reflect.DeepEqual(result.Metadata, want) {
+		t.Errorf("metadata = %#v, want %#v", result.Metadata, want)
+	}
+	if result.Content != "The body" {
+		t.Errorf("content = %q", result.Content)
+	}
+
+	if _, err := parseContextEntry("bad.md", strings.NewReader("---\ntags: [auth\n---\n\nBody\n")); err == nil {
+		t.Error("expected an error for malformed frontmatter")
+	}
+	if result, err := parseContextEntry("plain.md", strings.NewReader("Just notes\n")); err != nil || result.Content != "Just notes" {
+		t.Errorf("without frontmatter = %+v, %v", result, err)
+	}
+}
+
+func TestContextFrontmatterRoundTrip(t *testing.T) {
+	entry := ContextEntry{
+		Type:  ContextDecision,
+		Tags:  []string{"auth", "cwe:79"},
+		Links: []string{"reviewer/20260221T143022-leak-a1b2c3.md"},
+		Metadata: map[string]any{
+			"title":    "Use \"rotating\" tokens: #1 choice",
+			"note":     "naïve café ✓ 日本語",
+			"steps":    "first\nsecond\n",
+			"priority": 2,
+			"empty":    "",
+			"yes":      "true",
+			"nested":   map[string]any{"list": []string{"- a", "b: c"}, "ok": true},
+		},
+		Content: "Rotate the tokens",
+	}
+	now := time.Date(2026, 2, 21, 14, 30, 22, 0, time.UTC)
+	data := formatContextEntry(entry, "code-reviewer", "s1", now)
+	if !strings.Contains(data, "timestamp: 2026-02-21T14:30:22Z\n") || !strings.Contains(data, "\n  - cwe:79\n") {
+		t.Errorf("fields the store sets are not written plain:\n%s", data)
+	}
+
+	result, err := parseContextEntry("entry.md", strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, data)
+	}
+	want := contextMetadataValues(entry.Metadata)
+	if !reflect.DeepEqual(result.Metadata, want) {
+		t.Errorf("metadata = %#v, want %#v\n%s", result.Metadata, want, data)
+	}
+	if result.Agent != "code-reviewer" || result.SessionID != "s1" || !reflect.DeepEqual(result.Tags, entry.Tags) ||
+		!reflect.DeepEqual(result.Links, entry.Links) || result.Content != entry.Content {
+		t.Errorf("result = %+v", result)
+	}
+
+	doc, err := parseContextDocument(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatContextDocument(doc); got != data {
+		t.Errorf("formatContextDocument() =\n%s\nwant\n%s", got, data)
+	}
+	if got := doc.update(ContextEntry{Metadata: map[string]any{"priority": 3, "empty": nil}}); got != "Updated the metadata" {
+		t.Errorf("update() = %q", got)
+	}
+	if doc.Metadata["priority"] != 3.0 || doc.Metadata["note"] != "naïve café ✓ 日本語" {
+		t.Errorf("metadata after update = %#v", doc.Metadata)
+	}
+	if _, ok := doc.Metadata["empty"]; ok {
+		t.Error("a nil value did not remove the key")
+	}
+	if got := doc.update(ContextEntry{Metadata: map[string]any{"priority": 3}}); got != "" {
+		t.Errorf("update() with the same metadata = %q", got)
+	}
+}
+
+// TestContextFrontmatterFixture reads the entry the TypeScript SDK's tests
+// also read (tests/sdk/context.test.ts), so the two agree on its values.
+func TestContextFrontmatterFixture(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "context-frontmatter.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON, err := os.ReadFile(filepath.Join("testdata", "context-frontmatter.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want any
+	if err := json.Unmarshal(wantJSON, &want); err != nil {
+		t.Fatal(err)
+	}
+	check := func(name, data string) {
+		t.Helper()
+		result, err := parseContextEntry("entry.md", strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, _ := json.Marshal(map[string]any{
+			"agent":     result.Agent,
+			"sessionId": result.SessionID,
+			"timestamp": result.Timestamp,
+			"type":      result.Type,
+			"tags":      result.Tags,
+			"links":     result.Links,
+			"metadata":  result.Metadata,
+			"content":   result.Content,
+		})
+		var value any
+		if err := json.Unmarshal(got, &value); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(value, want) {
+			t.Errorf("%s read as\n%s\nwant\n%s", name, got, wantJSON)
+		}
+	}
+	check("fixture", string(data))
+
+	doc, err := parseContextDocument(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("rewritten fixture", formatContextDocument(doc))
+}
+
+func TestValidateContextMetadata(t *testing.T) {
+	for _, metadata := range []map[string]any{
+		{"tags": "x"},
+		{"": "x"},
+		{"ch": make(chan int)},
+	} {
+		err := validateContextMetadata(metadata)
+		var entryErr *ContextEntryError
+		if !errors.As(err, &entryErr) || entryErr.Field != "metadata" {
+			t.Errorf("validateContextMetadata(%v) = %v", metadata, err)
+		}
+	}
+	if err := validateContextMetadata(map[string]any{"title": "x", "n": 1}); err != nil {
+		t.Error(err)
+	}
+}
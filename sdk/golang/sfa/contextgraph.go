@@ -0,0 +1,94 @@
+package sfa
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// contextLinkPath normalizes a link as an entry records it, possibly
+// quoted or with backslashes, to a path relative to the store root.
+func contextLinkPath(link string) string {
+	link = strings.Trim(strings.TrimSpace(link), `"'`)
+	return path.Clean(strings.ReplaceAll(link, `\`, "/"))
+}
+
+// relatedContext returns the entry at p and the entries within depth links
+// of it, following the links of each entry and the links to it from
+// others. depth < 0 follows every link.
+func relatedContext(store contextStore, p string, depth int) (*ContextGraph, error) {
+	root, err := store.entryPath(p)
+	if err != nil {
+		return nil, err
+	}
+	all, err := store.search(ContextQuery{})
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]ContextResult, len(all))
+	for _, r := range all {
+		if rel, err := store.entryPath(r.FilePath); err == nil {
+			entries[rel] = r
+		}
+	}
+	if _, ok := entries[root]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrContextEntryNotFound, p)
+	}
+
+	links := make(map[string][]string)     // the paths each entry links to
+	backlinks := make(map[string][]string) // the paths of the entries linking to each
+	for _, from := range sortedKeys(entries) {
+		for _, l := range entries[from].Links {
+			to := contextLinkPath(l)
+			if to == from || slices.Contains(links[from], to) {
+				continue
+			}
+			links[from] = append(links[from], to)
+			backlinks[to] = append(backlinks[to], from)
+		}
+	}
+
+	// Breadth first, so each entry's depth is its shortest distance
+	dist := map[string]int{root: 0}
+	queue := []string{root}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if depth >= 0 && dist[cur] >= depth {
+			continue
+		}
+		for _, next := range append(slices.Clone(links[cur]), backlinks[cur]...) {
+			if _, seen := dist[next]; seen {
+				continue
+			}
+			if _, ok := entries[next]; !ok {
+				continue
+			}
+			dist[next] = dist[cur] + 1
+			queue = append(queue, next)
+		}
+	}
+
+	graph := &ContextGraph{Root: root}
+	for rel, d := range dist {
+		graph.Nodes = append(graph.Nodes, ContextNode{ContextResult: entries[rel], Path: rel, Depth: d})
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		if graph.Nodes[i].Depth != graph.Nodes[j].Depth {
+			return graph.Nodes[i].Depth < graph.Nodes[j].Depth
+		}
+		return graph.Nodes[i].Path < graph.Nodes[j].Path
+	})
+	for _, n := range graph.Nodes {
+		for _, to := range links[n.Path] {
+			_, inGraph := dist[to]
+			_, stored := entries[to]
+			if inGraph || !stored {
+				graph.Edges = append(graph.Edges, ContextEdge{From: n.Path, To: to})
+			}
+		}
+	}
+	return graph, nil
+}
@@ -0,0 +1,65 @@
+package sfa
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelatedContext(t *testing.T) {
+	store := &localContextStore{path: t.TempDir()}
+	write := func(agent, slug string, links ...string) string {
+		t.Helper()
+		p, err := store.write(ContextEntry{Type: ContextFinding, Slug: slug, Content: slug, Links: links}, agent, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(store.path, p)
+		return filepath.ToSlash(rel)
+	}
+	// fix -> finding -> spec; summary -> fix; finding -> a missing entry
+	spec := write("reviewer", "spec")
+	finding := write("reviewer", "finding", spec, `"reviewer/gone.md"`)
+	fix := write("fixer", "fix", finding)
+	summary := write("summarizer", "summary", fix)
+	other := write("other", "unrelated")
+
+	graph, err := relatedContext(store, filepath.Join(store.path, finding), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if graph.Root != finding {
+		t.Errorf("Root = %s, want %s", graph.Root, finding)
+	}
+	got := ""
+	for _, n := range graph.Nodes {
+		got += fmt.Sprintf("%s:%d ", n.Path, n.Depth)
+	}
+	if want := fmt.Sprintf("%s:0 %s:1 %s:1 ", finding, fix, spec); got != want {
+		t.Errorf("nodes = %s, want %s", got, want)
+	}
+	if graph.Nodes[0].Content != "finding" {
+		t.Errorf("root content = %q", graph.Nodes[0].Content)
+	}
+	wantEdges := []ContextEdge{{finding, spec}, {finding, "reviewer/gone.md"}, {fix, finding}}
+	if fmt.Sprint(graph.Edges) != fmt.Sprint(wantEdges) {
+		t.Errorf("edges = %v, want %v", graph.Edges, wantEdges)
+	}
+
+	graph, err = relatedContext(store, spec, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Nodes) != 4 || graph.Nodes[3].Path != summary || graph.Nodes[3].Depth != 3 {
+		t.Errorf("unlimited depth reached %+v", graph.Nodes)
+	}
+
+	graph, err = relatedContext(store, other, 0)
+	if err != nil || len(graph.Nodes) != 1 || len(graph.Edges) != 0 {
+		t.Errorf("depth 0 returned %+v, %v", graph, err)
+	}
+	if _, err := relatedContext(store, "reviewer/gone.md", 1); !errors.Is(err, ErrContextEntryNotFound) {
+		t.Errorf("expected ErrContextEntryNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+package sfa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contextIndexFile, at the root of the context store, is a SQLite index of
+// its entries, so searches need not read every file. The markdown files
+// stay the source of truth: the index is rebuilt from them when it is
+// missing or unreadable.
+//
+// The index is shared with the TypeScript SDK, which opens it with
+// bun:sqlite, so both must read and write SQLite. Linking SQLite would need
+// cgo or a third-party driver, which this SDK avoids, so it runs the
+// sqlite3 command instead: an optional dependency, without which searches
+// scan the files.
+const contextIndexFile = ".index.db"
+
+// contextIndexSchema creates the entries table, and entries_fts, the FTS5
+// table of their content that relevance searches rank with bm25. tags and
+// links are stored one per line, with tags wrapped in newlines so a tag can
+// be matched exactly with instr; attachments are names, one per line, and
+// metadata is JSON, "" if there is none.
+const contextIndexSchema = `CREATE TABLE IF NOT EXISTS entries (
+  path TEXT PRIMARY KEY,
+  agent TEXT NOT NULL,
+  session TEXT NOT NULL,
+  type TEXT NOT NULL,
+  tags TEXT NOT NULL,
+  links TEXT NOT NULL,
+  attachments TEXT NOT NULL,
+  timestamp TEXT NOT NULL,
+  metadata TEXT NOT NULL,
+  content TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS entries_agent ON entries (agent, timestamp);
+CREATE VIRTUAL TABLE IF NOT EXISTS entries_fts USING fts5 (path UNINDEXED, content);
+`
+
+// errNoSQLite is returned when the sqlite3 command is not installed.
+var errNoSQLite = errors.New("sqlite3 not found in PATH")
+
+// noSQLiteWarning warns, once per process, that the index is unavailable.
+var noSQLiteWarning sync.Once
+
+// runSQLite runs a SQL script against the index of the store at storePath
+// and returns its output, JSON rows for a query.
+func runSQLite(storePath, script string) ([]byte, error) {
+	sqlitePath, err := exec.LookPath("sqlite3")
+	if err != nil {
+		noSQLiteWarning.Do(func() {
+			stderrLog.Warn("sqlite3 not found in PATH: context searches scan the store's files instead of its index (install sqlite3 to use it)")
+		})
+		return nil, errNoSQLite
+	}
+	cmd := exec.Command(sqlitePath, "-json", "-bail", "-cmd", ".timeout 5000", filepath.Join(storePath, contextIndexFile))
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("sqlite3: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// sqlQuote quotes s as a SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// contextIndexInsert returns the statements that add or replace an entry,
+// its path relative to the store root.
+func contextIndexInsert(rel string, entry *ContextResult) string {
+	tags := ""
+	if len(entry.Tags) > 0 {
+		tags = "\n" + strings.Join(entry.Tags, "\n") + "\n"
+	}
+	metadata := ""
+	if len(entry.Metadata) > 0 {
+		data, _ := json.Marshal(entry.Metadata)
+		metadata = string(data)
+	}
+	path := sqlQuote(filepath.ToSlash(rel))
+	return fmt.Sprintf("INSERT OR REPLACE INTO entries VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s, %s);\n", path, sqlQuote(entry.Agent), sqlQuote(entry.SessionID),
+		sqlQuote(string(entry.Type)), sqlQuote(tags), sqlQuote(strings.Join(entry.Links, "\n")), sqlQuote(strings.Join(contextAttachmentNames(entry.Attachments), "\n")),
+		sqlQuote(entry.Timestamp), sqlQuote(metadata), sqlQuote(entry.Content)) +
+		fmt.Sprintf("DELETE FROM entries_fts WHERE path = %s;\nINSERT INTO entries_fts VALUES (%s, %s);\n", path, path, sqlQuote(entry.Content))
+}
+
+// withContextIndexLock runs fn holding the store's index lock, which
+// writers of the index take so that a rebuild cannot drop an entry another
+// agent is adding, nor remove the index while it is being written.
+func withContextIndexLock(storePath string, fn func() error) error {
+	return withFileLock(filepath.Join(storePath, contextIndexFile), fn)
+}
+
+// indexContextFile adds the entry at path to the store's index. Without an
+// index there is nothing to do: the first search builds it from the files.
+// If the entry cannot be added, e.g. to an index from an older SDK that
+// lacks a table, the index is removed, so that it is rebuilt instead of
+// missing the entry.
+func indexContextFile(storePath, path string) {
+	withContextIndexLock(storePath, func() error {
+		indexPath := filepath.Join(storePath, contextIndexFile)
+		if _, err := os.Stat(indexPath); err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(storePath, path)
+		if err == nil {
+			var entry *ContextResult
+			if entry, err = parseContextFile(path); err == nil {
+				_, err = runSQLite(storePath, "BEGIN;\n"+contextIndexInsert(rel, entry)+"COMMIT;\n")
+			}
+		}
+		if err != nil {
+			os.Remove(indexPath)
+		}
+		return nil
+	})
+}
+
+// rebuildContextIndex recreates the store's index from its markdown files.
+// The files are read under the index lock, so entries written meanwhile are
+// indexed either by the rebuild or after it.
+func rebuildContextIndex(storePath string) error {
+	return withContextIndexLock(storePath, func() error {
+		return rebuildContextIndexLocked(storePath)
+	})
+}
+
+func rebuildContextIndexLocked(storePath string) error {
+	var b strings.Builder
+	b.WriteString("BEGIN;\nDROP TABLE IF EXISTS entries;\nDROP TABLE IF EXISTS entries_fts;\n")
+	b.WriteString(contextIndexSchema)
+	err := filepath.Walk(storePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		entry, err := parseContextFile(path)
+		if err != nil {
+			return nil // skip unparseable files
+		}
+		rel, err := filepath.Rel(storePath, path)
+		if err != nil {
+			return nil
+		}
+		b.WriteString(contextIndexInsert(rel, entry))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Embeddings are kept, as they are costly to compute, but not for
+	// entries that are gone
+	b.WriteString(contextEmbeddingsSchema)
+	b.WriteString("DELETE FROM embeddings WHERE path NOT IN (SELECT path FROM entries);\n")
+	b.WriteString("COMMIT;\n")
+
+	indexPath := filepath.Join(storePath, contextIndexFile)
+	if _, err := runSQLite(storePath, b.String()); err != nil {
+		// A corrupt index cannot be rebuilt in place; start over once
+		if _, statErr := os.Stat(indexPath); statErr != nil || errors.Is(err, errNoSQLite) {
+			return err
+		}
+		os.Remove(indexPath)
+		if _, err := runSQLite(storePath, b.String()); err != nil {
+			os.Remove(indexPath)
+			return err
+		}
+	}
+	return nil
+}
+
+// contextIndexFilters returns the WHERE conditions on entries e for a
+// query's agent, session, type, tags, and time range.
+func contextIndexFilters(query ContextQuery) []string {
+	var where []string
+	if query.Agent != "" {
+		where = append(where, "e.agent = "+sqlQuote(query.Agent))
+	}
+	if query.SessionID != "" {
+		where = append(where, "e.session = "+sqlQuote(query.SessionID))
+	}
+	if query.Type != "" {
+		where = append(where, "e.type = "+sqlQuote(string(query.Type)))
+	}
+	if len(query.Tags) > 0 {
+		var matches []string
+		for _, tag := range query.Tags {
+			matches = append(matches, fmt.Sprintf("instr(e.tags, %s) > 0", sqlQuote("\n"+tag+"\n")))
+		}
+		op := " OR "
+		if query.AllTags {
+			op = " AND "
+		}
+		where = append(where, "("+strings.Join(matches, op)+")")
+	}
+	// julianday compares timestamps with and without fractional seconds
+	if !query.Since.IsZero() {
+		where = append(where, fmt.Sprintf("julianday(e.timestamp) >= julianday(%s)", sqlQuote(query.Since.UTC().Format(time.RFC3339Nano))))
+	}
+	if !query.Until.IsZero() {
+		where = append(where, fmt.Sprintf("julianday(e.timestamp) < julianday(%s)", sqlQuote(query.Until.UTC().Format(time.RFC3339Nano))))
+	}
+	return where
+}
+
+// contextIndexColumns are the columns of entries e a search reads.
+const contextIndexColumns = "e.path, e.agent, e.session, e.type, e.tags, e.links, e.attachments, e.timestamp, e.metadata, e.content"
+
+// contextIndexQuery returns the SELECT for a query, newest entries first,
+// or with ContextSortRelevance, those with any word of Query ranked by bm25.
+func contextIndexQuery(query ContextQuery) string {
+	where := contextIndexFilters(query)
+	sql := "SELECT " + contextIndexColumns + " FROM entries e"
+	order := " ORDER BY e.timestamp DESC"
+	if terms := contextTerms(query.Query); query.Sort == ContextSortRelevance && len(terms) > 0 {
+		// Quoted, each word is matched as is rather than as FTS5 syntax
+		quoted := make([]string, len(terms))
+		for i, t := range terms {
+			quoted[i] = `"` + t + `"`
+		}
+		sql += " JOIN entries_fts ON entries_fts.path = e.path"
+		where = append([]string{"entries_fts MATCH " + sqlQuote(strings.Join(quoted, " OR "))}, where...)
+		order = " ORDER BY bm25(entries_fts), e.timestamp DESC"
+	} else if query.Query != "" {
+		where = append(where, fmt.Sprintf("instr(lower(e.content), lower(%s)) > 0", sqlQuote(query.Query)))
+	}
+
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += order
+	// finishContextResults skips the offset, as it does for the other searches
+	if query.Limit > 0 {
+		sql += fmt.Sprintf(" LIMIT %d", max(query.Offset, 0)+query.Limit)
+	}
+	return sql + ";\n"
+}
+
+// queryContextIndex runs a query script against the store's index,
+// building it first if it is missing or cannot be read.
+func queryContextIndex(storePath, script string) ([]byte, error) {
+	if _, err := os.Stat(storePath); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(storePath, contextIndexFile)); err != nil {
+		if err := rebuildContextIndex(storePath); err != nil {
+			return nil, err
+		}
+	}
+	out, err := runSQLite(storePath, script)
+	if err != nil {
+		if errors.Is(err, errNoSQLite) {
+			return nil, err
+		}
+		if err := rebuildContextIndex(storePath); err != nil {
+			return nil, err
+		}
+		return runSQLite(storePath, script)
+	}
+	return out, nil
+}
+
+// searchContextIndex answers a query from the store's index.
+func searchContextIndex(query ContextQuery, storePath string) ([]ContextResult, error) {
+	out, err := queryContextIndex(storePath, contextIndexQuery(query))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := parseContextIndexRows(out)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ContextResult, len(rows))
+	for i, row := range rows {
+		results[i] = row.result(storePath)
+	}
+	return results, nil
+}
+
+// contextIndexRow is a row of entries as sqlite3 prints it, with the
+// entry's embedding when a similarity search joins it.
+type contextIndexRow struct {
+	Path        string `json:"path"`
+	Agent       string `json:"agent"`
+	Session     string `json:"session"`
+	Type        string `json:"type"`
+	Tags        string `json:"tags"`
+	Links       string `json:"links"`
+	Attachments string `json:"attachments"`
+	Timestamp   string `json:"timestamp"`
+	Metadata    string `json:"metadata"`
+	Content     string `json:"content"`
+	Model       string `json:"model"`  // embeddings model, "" if none
+	Hash        string `json:"hash"`   // content hash the embedding is of
+	Vector      string `json:"vector"` // hex of the embedding
+}
+
+// parseContextIndexRows reads the JSON rows sqlite3 prints for a query,
+// nothing at all when there are none.
+func parseContextIndexRows(out []byte) ([]contextIndexRow, error) {
+	if len(strings.TrimSpace(string(out))) == 0 {
+		return nil, nil
+	}
+	var rows []contextIndexRow
+	if err := json.Unmarshal(out, &rows); err != nil {
+		return nil, fmt.Errorf("failed to read context index: %w", err)
+	}
+	return rows, nil
+}
+
+// result returns the entry of a row in the store at storePath.
+func (row contextIndexRow) result(storePath string) ContextResult {
+	result := ContextResult{
+		FilePath:  filepath.Join(storePath, filepath.FromSlash(row.Path)),
+		Agent:     row.Agent,
+		SessionID: row.Session,
+		Timestamp: row.Timestamp,
+		Type:      ContextType(row.Type),
+		Content:   row.Content,
+	}
+	if tags := strings.Trim(row.Tags, "\n"); tags != "" {
+		result.Tags = strings.Split(tags, "\n")
+	}
+	if row.Links != "" {
+		result.Links = strings.Split(row.Links, "\n")
+	}
+	if row.Attachments != "" {
+		result.Attachments = contextAttachments(result.FilePath, strings.Split(row.Attachments, "\n"))
+	}
+	if row.Metadata != "" {
+		json.Unmarshal([]byte(row.Metadata), &result.Metadata)
+	}
+	return result
+}
@@ -0,0 +1,115 @@
+package sfa
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestContextIndexQuery(t *testing.T) {
+	got := contextIndexQuery(ContextQuery{Agent: "reviewer", Tags: []string{"sec", "o'brien"}, Query: "it's"})
+	for _, want := range []string{
+		"agent = 'reviewer'",
+		"(instr(e.tags, '\nsec\n') > 0 OR instr(e.tags, '\no''brien\n') > 0)",
+		"instr(lower(e.content), lower('it''s')) > 0",
+		"ORDER BY e.timestamp DESC",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("contextIndexQuery() = %q, missing %q", got, want)
+		}
+	}
+	got = contextIndexQuery(ContextQuery{Query: `SQL "injection" OR`, Sort: ContextSortRelevance, Limit: 3})
+	for _, want := range []string{
+		`entries_fts MATCH '"sql" OR "injection" OR "or"'`,
+		"ORDER BY bm25(entries_fts)",
+		"LIMIT 3",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("contextIndexQuery() = %q, missing %q", got, want)
+		}
+	}
+	got = contextIndexQuery(ContextQuery{Tags: []string{"a", "b"}, AllTags: true, Since: time.Date(2026, 2, 21, 0, 0, 0, 0, time.UTC), Offset: 5, Limit: 5})
+	for _, want := range []string{
+		"(instr(e.tags, '\na\n') > 0 AND instr(e.tags, '\nb\n') > 0)",
+		"julianday(e.timestamp) >= julianday('2026-02-21T00:00:00Z')",
+		"LIMIT 10",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("contextIndexQuery() = %q, missing %q", got, want)
+		}
+	}
+	if got := contextIndexQuery(ContextQuery{}); strings.Contains(got, "WHERE") {
+		t.Errorf("contextIndexQuery() of an empty query = %q, want no WHERE", got)
+	}
+}
+
+func TestSearchContextIndex(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	store := t.TempDir()
+	write := func(slug string, tags []string, content string) string {
+		t.Helper()
+		path, err := writeContextEntry(ContextEntry{Type: ContextFinding, Tags: tags, Slug: slug, Content: content}, "reviewer", "s1", store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	first := write("first", []string{"security"}, "Quote's in content")
+
+	// The first search builds the index from the files
+	results, err := searchContextIndex(ContextQuery{Query: "QUOTE'S"}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].FilePath != first || results[0].SessionID != "s1" || results[0].Tags[0] != "security" {
+		t.Fatalf("results = %+v, want the first entry", results)
+	}
+	if _, err := os.Stat(filepath.Join(store, contextIndexFile)); err != nil {
+		t.Fatalf("index not created: %v", err)
+	}
+
+	// Later writes are added to it
+	write("second", []string{"sec"}, "second entry")
+	results, err = searchContextIndex(ContextQuery{Tags: []string{"sec"}}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !strings.HasSuffix(results[0].FilePath, "-second.md") {
+		t.Fatalf("tag search = %+v, want only the second entry", results)
+	}
+
+	// A corrupt index is rebuilt
+	if err := os.WriteFile(filepath.Join(store, contextIndexFile), []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err = searchContextIndex(ContextQuery{Agent: "reviewer"}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("search after corruption = %d results, want 2", len(results))
+	}
+}
+
+func TestRunSQLiteMissing(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	noSQLiteWarning = sync.Once{}
+	var err error
+	stderr := captureStderr(t, func() {
+		_, err = runSQLite(t.TempDir(), "SELECT 1;")
+		_, _ = runSQLite(t.TempDir(), "SELECT 1;")
+	})
+	if !errors.Is(err, errNoSQLite) {
+		t.Errorf("runSQLite() error = %v, want errNoSQLite", err)
+	}
+	if strings.Count(stderr, "sqlite3 not found") != 1 {
+		t.Errorf("expected one warning about the missing sqlite3, got %q", stderr)
+	}
+}
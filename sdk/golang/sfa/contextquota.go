@@ -0,0 +1,197 @@
+package sfa
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrContextQuotaExceeded is returned by WriteContext when an entry does not
+// fit the store's quota and the quota refuses writes rather than evicting,
+// or when the entry alone is larger than the quota allows.
+var ErrContextQuotaExceeded = errors.New("context store quota exceeded")
+
+// contextEvictionPriority orders entry types for eviction: lower goes
+// first. Types not listed go before all of them.
+var contextEvictionPriority = map[ContextType]int{
+	ContextReference: 1,
+	ContextArtifact:  2,
+	ContextFinding:   3,
+	ContextDecision:  4,
+	ContextSummary:   5,
+}
+
+// contextLimit caps the entries of one scope; zero fields are unlimited.
+type contextLimit struct {
+	MaxEntries int
+	MaxBytes   int64
+}
+
+func (l contextLimit) set() bool {
+	return l.MaxEntries > 0 || l.MaxBytes > 0
+}
+
+// contextQuota is contextStore.quota: limits on each agent's entries and on
+// its entries in one session, and whether a write past them evicts older
+// entries or is refused.
+type contextQuota struct {
+	Agent   contextLimit
+	Session contextLimit
+	Reject  bool
+}
+
+// resolveContextQuota reads contextStore.quota from the config. An unknown
+// onExceeded is warned about and treated as "evict".
+func resolveContextQuota(config map[string]any) contextQuota {
+	cs, _ := config["contextStore"].(map[string]any)
+	raw, _ := cs["quota"].(map[string]any)
+	limit := func(key string) contextLimit {
+		m, _ := raw[key].(map[string]any)
+		entries, _ := m["maxEntries"].(float64)
+		bytes, _ := m["maxBytes"].(float64)
+		return contextLimit{MaxEntries: int(entries), MaxBytes: int64(bytes)}
+	}
+	quota := contextQuota{Agent: limit("agent"), Session: limit("session")}
+	switch mode, _ := raw["onExceeded"].(string); mode {
+	case "", "evict":
+	case "reject":
+		quota.Reject = true
+	default:
+		stderrLog.Warn(fmt.Sprintf("ignoring contextStore.quota.onExceeded %q (use evict or reject)", mode))
+	}
+	return quota
+}
+
+// contextFile is an entry on disk, as quotas count it.
+type contextFile struct {
+	Path    string
+	Type    ContextType
+	Written time.Time
+	Size    int64
+}
+
+// scanContextFiles returns the entries under dir, which need not exist.
+func scanContextFiles(dir string) ([]contextFile, error) {
+	var files []contextFile
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		f := contextFile{Path: path, Written: info.ModTime(), Size: info.Size() + contextAttachmentsSize(path)}
+		if entry, err := parseContextFile(path); err == nil {
+			f.Type = entry.Type
+			if t, err := time.Parse(time.RFC3339, entry.Timestamp); err == nil {
+				f.Written = t
+			}
+		}
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+// contextEvictions returns the files to remove so that one more entry of
+// size bytes fits limit: the lowest priority types first, oldest first
+// within a type. It fails if the entry alone does not fit.
+func contextEvictions(files []contextFile, limit contextLimit, size int64) ([]contextFile, error) {
+	if limit.MaxBytes > 0 && size > limit.MaxBytes {
+		return nil, fmt.Errorf("entry of %d bytes is larger than maxBytes %d", size, limit.MaxBytes)
+	}
+	entries, bytes := len(files)+1, size+contextBytes(files)
+	order := append([]contextFile(nil), files...)
+	sort.SliceStable(order, func(i, j int) bool {
+		pi, pj := contextEvictionPriority[order[i].Type], contextEvictionPriority[order[j].Type]
+		if pi != pj {
+			return pi < pj
+		}
+		return order[i].Written.Before(order[j].Written)
+	})
+	var evict []contextFile
+	for _, f := range order {
+		if (limit.MaxEntries <= 0 || entries <= limit.MaxEntries) && (limit.MaxBytes <= 0 || bytes <= limit.MaxBytes) {
+			break
+		}
+		evict = append(evict, f)
+		entries--
+		bytes -= f.Size
+	}
+	return evict, nil
+}
+
+// enforceContextQuota makes room for an entry of size bytes that agentName
+// is about to write in sessionID, evicting entries as the quota allows. It
+// returns an error wrapping ErrContextQuotaExceeded if the entry must not
+// be written.
+func enforceContextQuota(storePath, agentName, sessionID string, size int64, quota contextQuota) error {
+	type scope struct {
+		name  string
+		dir   string
+		limit contextLimit
+	}
+	agentDir := filepath.Join(storePath, agentName)
+	var scopes []scope
+	// The session first: what it evicts counts toward the agent's usage too
+	if sessionID != "" && quota.Session.set() {
+		scopes = append(scopes, scope{"session " + sessionID, filepath.Join(agentDir, sessionID), quota.Session})
+	}
+	if quota.Agent.set() {
+		scopes = append(scopes, scope{"agent " + agentName, agentDir, quota.Agent})
+	}
+
+	for _, s := range scopes {
+		files, err := scanContextFiles(s.dir)
+		if err != nil {
+			return fmt.Errorf("failed to measure context usage: %w", err)
+		}
+		evict, err := contextEvictions(files, s.limit, size)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrContextQuotaExceeded, s.name, err)
+		}
+		if len(evict) == 0 {
+			continue
+		}
+		if quota.Reject {
+			return fmt.Errorf("%w: %s holds %d entries (%d bytes); %s", ErrContextQuotaExceeded, s.name, len(files), contextBytes(files), describeContextLimit(s.limit))
+		}
+		var removed []string
+		for _, f := range evict {
+			if err := removeContextEntryFile(f.Path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to evict context entry: %w", err)
+			}
+			removed = append(removed, f.Path)
+		}
+		unindexContextFiles(storePath, removed)
+	}
+	return nil
+}
+
+// contextBytes is the total size of files.
+func contextBytes(files []contextFile) int64 {
+	var n int64
+	for _, f := range files {
+		n += f.Size
+	}
+	return n
+}
+
+// describeContextLimit renders a limit for an error message.
+func describeContextLimit(l contextLimit) string {
+	var parts []string
+	if l.MaxEntries > 0 {
+		parts = append(parts, fmt.Sprintf("maxEntries %d", l.MaxEntries))
+	}
+	if l.MaxBytes > 0 {
+		parts = append(parts, fmt.Sprintf("maxBytes %d", l.MaxBytes))
+	}
+	return "the limit is " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,125 @@
+package sfa
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResolveContextQuota(t *testing.T) {
+	config := map[string]any{"contextStore": map[string]any{"quota": map[string]any{
+		"agent":      map[string]any{"maxEntries": float64(100), "maxBytes": float64(4096)},
+		"session":    map[string]any{"maxEntries": float64(10)},
+		"onExceeded": "reject",
+	}}}
+	got := resolveContextQuota(config)
+	want := contextQuota{Agent: contextLimit{100, 4096}, Session: contextLimit{MaxEntries: 10}, Reject: true}
+	if got != want {
+		t.Errorf("resolveContextQuota() = %+v, want %+v", got, want)
+	}
+	if q := resolveContextQuota(map[string]any{}); q.Agent.set() || q.Session.set() || q.Reject {
+		t.Errorf("resolveContextQuota() without a quota = %+v", q)
+	}
+}
+
+func TestContextEvictions(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []contextFile{
+		{Path: "old-decision", Type: ContextDecision, Written: base, Size: 10},
+		{Path: "new-reference", Type: ContextReference, Written: base.Add(2 * time.Hour), Size: 10},
+		{Path: "old-reference", Type: ContextReference, Written: base.Add(time.Hour), Size: 10},
+		{Path: "summary", Type: ContextSummary, Written: base, Size: 10},
+	}
+
+	evict, err := contextEvictions(files, contextLimit{MaxEntries: 3}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evict) != 2 || evict[0].Path != "old-reference" || evict[1].Path != "new-reference" {
+		t.Errorf("evicted %+v, want both references, oldest first", evict)
+	}
+
+	evict, _ = contextEvictions(files, contextLimit{MaxBytes: 35}, 10)
+	if len(evict) != 2 {
+		t.Errorf("evicted %d entries for maxBytes, want 2", len(evict))
+	}
+
+	if evict, _ := contextEvictions(files, contextLimit{MaxEntries: 10}, 10); len(evict) != 0 {
+		t.Errorf("evicted %+v under the limit", evict)
+	}
+
+	if _, err := contextEvictions(nil, contextLimit{MaxBytes: 5}, 10); err == nil {
+		t.Error("expected an error for an entry larger than maxBytes")
+	}
+}
+
+func TestEnforceContextQuota(t *testing.T) {
+	store := t.TempDir()
+	write := func(session string, typ ContextType, slug string) string {
+		t.Helper()
+		path, err := writeContextEntry(ContextEntry{Type: typ, Slug: slug, Content: slug}, "notes", session, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	reference := write("s1", ContextReference, "reference")
+	finding := write("s1", ContextFinding, "finding")
+	other := write("s2", ContextFinding, "other-session")
+
+	quota := contextQuota{Session: contextLimit{MaxEntries: 2}, Reject: true}
+	err := enforceContextQuota(store, "notes", "s1", 100, quota)
+	if !errors.Is(err, ErrContextQuotaExceeded) {
+		t.Fatalf("expected ErrContextQuotaExceeded, got %v", err)
+	}
+	if _, err := os.Stat(reference); err != nil {
+		t.Error("a rejected write evicted an entry")
+	}
+
+	quota.Reject = false
+	if err := enforceContextQuota(store, "notes", "s1", 100, quota); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(reference); !os.IsNotExist(err) {
+		t.Error("the reference should have been evicted first")
+	}
+	for _, path := range []string{finding, other} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was evicted", filepath.Base(path))
+		}
+	}
+
+	// Another session's entries count toward the agent's quota
+	quota = contextQuota{Agent: contextLimit{MaxEntries: 2}}
+	if err := enforceContextQuota(store, "notes", "s1", 100, quota); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := scanContextFiles(filepath.Join(store, "notes"))
+	if len(files) != 1 {
+		t.Errorf("agent holds %d entries after eviction, want 1", len(files))
+	}
+}
+
+func TestWriteContextEnforcesQuota(t *testing.T) {
+	store := t.TempDir()
+	def := &AgentDef{Name: "notes"}
+	e := &executor{
+		def:          def,
+		resolved:     resolveEnv(nil, def.Name, map[string]any{}),
+		contextStore: &localContextStore{path: store, quota: contextQuota{Agent: contextLimit{MaxEntries: 1}, Reject: true}},
+	}
+	ctx := e.executeContext(&execution{ctx: context.Background(), safety: &SafetyState{}}, "", nil)
+
+	if _, err := ctx.WriteContext(ContextEntry{Type: ContextFinding, Slug: "first", Content: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.WriteContext(ContextEntry{Type: ContextFinding, Slug: "second", Content: "second"}); !errors.Is(err, ErrContextQuotaExceeded) {
+		t.Errorf("expected ErrContextQuotaExceeded, got %v", err)
+	}
+	if files, _ := scanContextFiles(store); len(files) != 1 {
+		t.Errorf("store holds %d entries, want 1", len(files))
+	}
+}
@@ -0,0 +1,164 @@
+package sfa
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters, the defaults SQLite's FTS5 uses as well.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// contextSnippetRadius is how many characters a snippet keeps on each side
+// of the match.
+const contextSnippetRadius = 80
+
+// contextWords splits text into lowercase words of letters and digits, as
+// FTS5's default tokenizer does.
+func contextWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// contextTerms returns the distinct words of a query, in order.
+func contextTerms(query string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, w := range contextWords(query) {
+		if !seen[w] {
+			seen[w] = true
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// rankContextResults keeps the results whose content has any of terms and
+// orders them by BM25 score, best first, newer first among equals. Document
+// frequencies are taken over results, the entries that passed the other
+// filters.
+func rankContextResults(results []ContextResult, terms []string) []ContextResult {
+	type doc struct {
+		result ContextResult
+		freq   map[string]int
+		length int
+		score  float64
+	}
+	docs := make([]doc, len(results))
+	df := make(map[string]int)
+	total := 0
+	for i, r := range results {
+		words := contextWords(r.Content)
+		freq := make(map[string]int)
+		for _, w := range words {
+			freq[w]++
+		}
+		for _, t := range terms {
+			if freq[t] > 0 {
+				df[t]++
+			}
+		}
+		docs[i] = doc{result: r, freq: freq, length: len(words)}
+		total += len(words)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	avg := math.Max(float64(total)/float64(len(docs)), 1)
+
+	var ranked []doc
+	for _, d := range docs {
+		for _, t := range terms {
+			tf := float64(d.freq[t])
+			if tf == 0 {
+				continue
+			}
+			idf := math.Log(1 + (float64(len(docs))-float64(df[t])+0.5)/(float64(df[t])+0.5))
+			d.score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(d.length)/avg))
+		}
+		if d.score > 0 {
+			ranked = append(ranked, d)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].result.Timestamp > ranked[j].result.Timestamp
+	})
+	out := make([]ContextResult, len(ranked))
+	for i, d := range ranked {
+		out[i] = d.result
+	}
+	return out
+}
+
+// contextSnippet returns the text around the first case-insensitive match
+// of any of needles in content, on one line, with the match in **bold** and
+// "…" where the content was cut; "" if none matches.
+func contextSnippet(content string, needles []string) string {
+	lower := strings.ToLower(content)
+	start, end := -1, -1
+	for _, n := range needles {
+		if n == "" {
+			continue
+		}
+		if i := strings.Index(lower, strings.ToLower(n)); i >= 0 && (start < 0 || i < start) {
+			start, end = i, i+len(n)
+		}
+	}
+	// Lowercasing can change byte lengths; give up on the rare text where it did
+	if start < 0 || len(lower) != len(content) {
+		return ""
+	}
+
+	from := max(start-contextSnippetRadius, 0)
+	to := min(end+contextSnippetRadius, len(content))
+	// Cut at whole words
+	if from > 0 {
+		if i := strings.IndexAny(content[from:start], " \n\t"); i >= 0 {
+			from += i + 1
+		}
+	}
+	if to < len(content) {
+		if i := strings.LastIndexAny(content[end:to], " \n\t"); i >= 0 {
+			to = end + i
+		}
+	}
+
+	snippet := content[from:start] + "**" + content[start:end] + "**" + content[end:to]
+	snippet = strings.Join(strings.Fields(snippet), " ")
+	if from > 0 {
+		snippet = "…" + snippet
+	}
+	if to < len(content) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// finishContextResults adds snippets for the query and applies its offset
+// and limit.
+func finishContextResults(results []ContextResult, query ContextQuery) []ContextResult {
+	if query.Query != "" {
+		needles := []string{query.Query}
+		if query.Sort != ContextSortNewest {
+			needles = contextTerms(query.Query)
+		}
+		for i := range results {
+			results[i].Snippet = contextSnippet(results[i].Content, needles)
+		}
+	}
+	if query.Offset > 0 {
+		results = results[min(query.Offset, len(results)):]
+	}
+	if query.Limit > 0 && len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results
+}
@@ -0,0 +1,87 @@
+package sfa
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestRankContextResults(t *testing.T) {
+	results := []ContextResult{
+		{FilePath: "a", Timestamp: "3", Content: "Unrelated notes about deployment."},
+		{FilePath: "b", Timestamp: "2", Content: "Token refresh fails; the token cache keeps an expired token."},
+		{FilePath: "c", Timestamp: "1", Content: "A single token mention in a much longer paragraph about caching layers and eviction."},
+	}
+	ranked := rankContextResults(results, contextTerms("token"))
+	if len(ranked) != 2 || ranked[0].FilePath != "b" || ranked[1].FilePath != "c" {
+		t.Fatalf("rankContextResults() = %+v, want b then c", ranked)
+	}
+	if ranked := rankContextResults(results, contextTerms("kubernetes")); len(ranked) != 0 {
+		t.Errorf("rankContextResults() with no matching term = %+v, want none", ranked)
+	}
+}
+
+func TestContextSnippet(t *testing.T) {
+	content := strings.Repeat("lorem ipsum ", 20) + "the SQL injection\nin login " + strings.Repeat("dolor sit ", 20)
+	got := contextSnippet(content, []string{"injection"})
+	if !strings.Contains(got, "the SQL **injection** in login") {
+		t.Errorf("contextSnippet() = %q, want the match in bold on one line", got)
+	}
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Errorf("contextSnippet() = %q, want … at both cut ends", got)
+	}
+	if got := contextSnippet("short TEXT", []string{"text"}); got != "short **TEXT**" {
+		t.Errorf("contextSnippet() = %q, want %q", got, "short **TEXT**")
+	}
+	if got := contextSnippet("short text", []string{"absent"}); got != "" {
+		t.Errorf("contextSnippet() = %q, want empty", got)
+	}
+}
+
+func TestSearchContextRelevance(t *testing.T) {
+	store := t.TempDir()
+	for _, e := range []ContextEntry{
+		{Type: ContextFinding, Slug: "best", Content: "Session token leak: the token is logged in plain text."},
+		{Type: ContextFinding, Slug: "other", Content: "Timeout in the session handler."},
+		{Type: ContextFinding, Slug: "none", Content: "Nothing relevant."},
+	} {
+		if _, err := writeContextEntry(e, "reviewer", "", store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(t *testing.T) {
+		results, err := searchContextEntries(ContextQuery{Query: "token session", Sort: ContextSortRelevance}, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 || !strings.HasSuffix(results[0].FilePath, "-best.md") {
+			t.Fatalf("results = %+v, want best first of two", results)
+		}
+		if results[0].Snippet != "**Session** token leak: the token is logged in plain text." {
+			t.Errorf("Snippet = %q", results[0].Snippet)
+		}
+
+		results, err = searchContextEntries(ContextQuery{Query: "session", Sort: ContextSortRelevance, Limit: 1}, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 {
+			t.Errorf("Limit 1 returned %d results", len(results))
+		}
+	}
+	t.Run("native", func(t *testing.T) {
+		t.Setenv("PATH", "")
+		check(t)
+	})
+	t.Run("index", func(t *testing.T) {
+		if _, err := exec.LookPath("sqlite3"); err != nil {
+			t.Skip("sqlite3 not installed")
+		}
+		check(t)
+	})
+
+	if _, err := searchContextEntries(ContextQuery{Sort: "oldest"}, store); err == nil {
+		t.Error("expected an error for an unknown sort")
+	}
+}
@@ -0,0 +1,106 @@
+package sfa
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrAmbiguousContextID is returned by ReadContext when more than one entry
+// has the ID given.
+var ErrAmbiguousContextID = errors.New("context entry ID is ambiguous")
+
+// isContextID reports whether s is an entry ID, its file name without
+// ".md" such as 20260221T143022-auth-vulnerability, rather than a path.
+func isContextID(s string) bool {
+	return s != "" && !strings.ContainsAny(s, `/\*?[`) && !strings.HasPrefix(s, ".") && !strings.HasSuffix(s, ".md")
+}
+
+// matchContextID returns the one of paths, relative to the store root,
+// whose entry has the ID id.
+func matchContextID(id string, paths []string) (string, error) {
+	var found []string
+	for _, p := range paths {
+		if path.Base(p) == id+".md" && validContextBundlePath(p) {
+			found = append(found, p)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", ErrContextEntryNotFound, id)
+	case 1:
+		return found[0], nil
+	}
+	sort.Strings(found)
+	return "", fmt.Errorf("%w: %s is each of %s", ErrAmbiguousContextID, id, strings.Join(found, ", "))
+}
+
+func (s *localContextStore) read(pathOrID string) (*ContextResult, error) {
+	var file string
+	if isContextID(pathOrID) {
+		var paths []string
+		for _, pattern := range []string{"*", filepath.Join("*", "*")} {
+			matches, _ := filepath.Glob(filepath.Join(s.path, pattern, pathOrID+".md"))
+			for _, m := range matches {
+				if rel, err := filepath.Rel(s.path, m); err == nil {
+					paths = append(paths, filepath.ToSlash(rel))
+				}
+			}
+		}
+		rel, err := matchContextID(pathOrID, paths)
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(s.path, filepath.FromSlash(rel))
+	} else {
+		var err error
+		if file, err = contextEntryFile(s.path, pathOrID); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := parseContextFile(file)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrContextEntryNotFound, pathOrID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read context entry: %w", err)
+	}
+	return result, nil
+}
+
+func (s *remoteContextStore) read(pathOrID string) (*ContextResult, error) {
+	var rel string
+	if isContextID(pathOrID) {
+		objects, err := s.backend.list("")
+		if err != nil {
+			return nil, err
+		}
+		paths := make([]string, len(objects))
+		for i, o := range objects {
+			paths[i] = o.Path
+		}
+		if rel, err = matchContextID(pathOrID, paths); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if rel, err = s.entryPath(pathOrID); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := s.backend.get(rel)
+	if errors.Is(err, ErrContextEntryNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrContextEntryNotFound, pathOrID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read context entry: %w", err)
+	}
+	return parseContextEntry(s.url+"/"+rel, bytes.NewReader(data))
+}
@@ -0,0 +1,71 @@
+package sfa
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadContext(t *testing.T) {
+	store := &localContextStore{path: t.TempDir()}
+	p, err := store.write(ContextEntry{Type: ContextFinding, Tags: []string{"auth"}, Slug: "auth-bug", Content: "Tokens never expire."}, "reviewer", "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strings.TrimSuffix(filepath.Base(p), ".md")
+	rel, _ := filepath.Rel(store.path, p)
+
+	for _, pathOrID := range []string{p, filepath.ToSlash(rel), id} {
+		got, err := store.read(pathOrID)
+		if err != nil {
+			t.Fatalf("read(%q): %v", pathOrID, err)
+		}
+		if got.FilePath != p || got.Agent != "reviewer" || got.SessionID != "s1" || got.Content != "Tokens never expire." {
+			t.Errorf("read(%q) = %+v", pathOrID, got)
+		}
+	}
+
+	if _, err := store.read("reviewer/missing.md"); !errors.Is(err, ErrContextEntryNotFound) {
+		t.Errorf("expected ErrContextEntryNotFound for a path, got %v", err)
+	}
+	if _, err := store.read("20260101T000000-missing"); !errors.Is(err, ErrContextEntryNotFound) {
+		t.Errorf("expected ErrContextEntryNotFound for an ID, got %v", err)
+	}
+	if _, err := store.read("../elsewhere/x.md"); err == nil {
+		t.Error("expected a path outside the store to be refused")
+	}
+
+	// The same file name under another agent
+	if err := writeFileAtomic(filepath.Join(store.path, "fixer", filepath.Base(p)), []byte("---\nagent: fixer\n---\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.read(id); !errors.Is(err, ErrAmbiguousContextID) {
+		t.Errorf("expected ErrAmbiguousContextID, got %v", err)
+	}
+}
+
+func TestReadRemoteContext(t *testing.T) {
+	srv, _ := contextObjectServer(t, "")
+	u, _ := url.Parse(srv.URL)
+	store := &remoteContextStore{url: srv.URL, backend: newHTTPObjectBackend(u, "")}
+	p, err := store.write(ContextEntry{Type: ContextDecision, Slug: "use-jwt", Content: "Use JWTs."}, "architect", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strings.TrimSuffix(p[strings.LastIndex(p, "/")+1:], ".md")
+
+	for _, pathOrID := range []string{p, strings.TrimPrefix(p, srv.URL+"/"), id} {
+		got, err := store.read(pathOrID)
+		if err != nil {
+			t.Fatalf("read(%q): %v", pathOrID, err)
+		}
+		if got.FilePath != p || got.Type != ContextDecision || got.Content != "Use JWTs." {
+			t.Errorf("read(%q) = %+v", pathOrID, got)
+		}
+	}
+	if _, err := store.read("architect/missing.md"); !errors.Is(err, ErrContextEntryNotFound) {
+		t.Errorf("expected ErrContextEntryNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package sfa
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// contextObject is an entry file held by a remote store, at its path
+// relative to the store root.
+type contextObject struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// errObjectExists is returned by objectBackend.create when the path is
+// already taken.
+var errObjectExists = errors.New("object already exists")
+
+// objectBackend holds the files of a remote context store by path.
+type objectBackend interface {
+	put(path string, data []byte) error
+	// create is put, but fails with errObjectExists rather than replace
+	// an object already at path.
+	create(path string, data []byte) error
+	get(path string) ([]byte, error)
+	// list returns the objects whose paths start with prefix.
+	list(prefix string) ([]contextObject, error)
+}
+
+// remoteContextStore keeps entries, in the same layout and format as a
+// local store, in an objectBackend. Searches read every entry under the
+// queried agent, as there is no index; quotas and retention are left to
+// the backend.
+type remoteContextStore struct {
+	url     string
+	backend objectBackend
+}
+
+func (s *remoteContextStore) write(entry ContextEntry, agentName, sessionID string) (string, error) {
+	now := time.Now().UTC()
+	p := agentName + "/"
+	if sessionID != "" {
+		p += sessionID + "/"
+	}
+	data := []byte(formatContextEntry(entry, agentName, sessionID, now))
+	for attempt := 0; ; attempt++ {
+		name := p + contextEntryName(now, entry.Slug, attempt)
+		err := s.backend.create(name, data)
+		if errors.Is(err, errObjectExists) && attempt < contextNameAttempts {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to write context entry: %w", err)
+		}
+		for _, a := range entry.Attachments {
+			if err := s.backend.put(contextAttachmentDir(name)+"/"+a.Name, a.Bytes); err != nil {
+				return "", fmt.Errorf("failed to write attachment %s: %w", a.Name, err)
+			}
+		}
+		return s.url + "/" + name, nil
+	}
+}
+
+// entries reads the entries whose paths start with prefix and pass keep.
+func (s *remoteContextStore) entries(prefix string, keep func(path string) bool) ([]contextBundleEntry, error) {
+	objects, err := s.backend.list(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read context store: %w", err)
+	}
+	var entries []contextBundleEntry
+	for _, o := range objects {
+		if !validContextBundlePath(o.Path) || !keep(o.Path) {
+			continue
+		}
+		data, err := s.backend.get(o.Path)
+		if errors.Is(err, ErrContextEntryNotFound) {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read context store: %w", err)
+		}
+		entries = append(entries, contextBundleEntry{Path: o.Path, Content: string(data)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+func (s *remoteContextStore) search(query ContextQuery) ([]ContextResult, error) {
+	query, err := normalizeContextSort(query)
+	if err != nil {
+		return nil, err
+	}
+	// Similarity filters like relevance, and ranks by it without a provider
+	similar := query.Sort == ContextSortSimilarity
+	if similar {
+		query.Sort = ContextSortRelevance
+	}
+
+	prefix := ""
+	if query.Agent != "" {
+		prefix = query.Agent + "/"
+	}
+	entries, err := s.entries(prefix, func(string) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	var results []ContextResult
+	for _, e := range entries {
+		result, err := parseContextEntry(s.url+"/"+e.Path, strings.NewReader(e.Content))
+		if err != nil || !matchesContextQuery(result, query) {
+			continue
+		}
+		results = append(results, *result)
+	}
+
+	if similar {
+		if provider := embeddingsFromEnv(); provider != nil {
+			ranked, err := rankContextSimilar(results, query.Query, provider)
+			if err == nil {
+				query.Sort = ContextSortSimilarity
+				return finishContextResults(ranked, query), nil
+			}
+			stderrLog.Warn(fmt.Sprintf("similarity search failed, ranking by relevance instead: %v", err))
+		}
+	}
+	return finishContextResults(orderContextResults(results, query), query), nil
+}
+
+func (s *remoteContextStore) export(opts ContextExportOpts) ([]byte, error) {
+	prefix := ""
+	if opts.Agent != "" {
+		prefix = opts.Agent + "/"
+	}
+	entries, err := s.entries(prefix, func(p string) bool { return inContextSession(p, opts.SessionID) })
+	if err != nil {
+		return nil, err
+	}
+	return encodeContextBundle(entries, opts)
+}
+
+func (s *remoteContextStore) importBundle(data []byte) ([]string, error) {
+	entries, err := readContextBundle(data)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := s.backend.list("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read context store: %w", err)
+	}
+	have := make(map[string]bool, len(objects))
+	for _, o := range objects {
+		have[o.Path] = true
+	}
+
+	var imported []string
+	for _, e := range entries {
+		if have[e.Path] {
+			continue
+		}
+		if err := s.backend.put(e.Path, []byte(e.Content)); err != nil {
+			return imported, fmt.Errorf("failed to write context entry: %w", err)
+		}
+		have[e.Path] = true
+		imported = append(imported, s.url+"/"+e.Path)
+	}
+	return imported, nil
+}
+
+// httpObjectBackend is a context store served over HTTP: PUT and GET on
+// <url>/<path> store and read an entry or attachment, and GET
+// <url>/?prefix=<prefix> lists entries as {"objects": [{"path", "size",
+// "modified"}]}. A PUT with If-None-Match: * must not replace an entry. A
+// token, when set, is sent as a bearer token.
+type httpObjectBackend struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func newHTTPObjectBackend(u *url.URL, token string) *httpObjectBackend {
+	return &httpObjectBackend{
+		base:   strings.TrimSuffix(u.String(), "/"),
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// escapeObjectPath escapes each segment of an entry path for a URL.
+func escapeObjectPath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (b *httpObjectBackend) do(method, target string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	if body != nil && strings.HasSuffix(target, ".md") {
+		req.Header.Set("Content-Type", "text/markdown; charset=utf-8")
+	} else if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("context store is unavailable: %w", err)
+	}
+	return resp, nil
+}
+
+func (b *httpObjectBackend) put(p string, data []byte) error {
+	resp, err := b.do(http.MethodPut, b.base+"/"+escapeObjectPath(p), data, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("context store returned %s", resp.Status)
+	}
+	return nil
+}
+
+// create puts with If-None-Match: *, which the server refuses with 412
+// Precondition Failed if the path is taken.
+func (b *httpObjectBackend) create(p string, data []byte) error {
+	resp, err := b.do(http.MethodPut, b.base+"/"+escapeObjectPath(p), data, http.Header{"If-None-Match": {"*"}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return errObjectExists
+	case resp.StatusCode/100 != 2:
+		return fmt.Errorf("context store returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (b *httpObjectBackend) get(p string) ([]byte, error) {
+	resp, err := b.do(http.MethodGet, b.base+"/"+escapeObjectPath(p), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrContextEntryNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("context store returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (b *httpObjectBackend) list(prefix string) ([]contextObject, error) {
+	resp, err := b.do(http.MethodGet, b.base+"/?prefix="+url.QueryEscape(prefix), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("context store returned %s", resp.Status)
+	}
+	var out struct {
+		Objects []contextObject `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("invalid context store listing: %w", err)
+	}
+	return out.Objects, nil
+}
@@ -0,0 +1,154 @@
+package sfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// contextObjectServer serves the HTTP context store protocol from memory.
+func contextObjectServer(t *testing.T, token string) (*httptest.Server, map[string]string) {
+	t.Helper()
+	var mu sync.Mutex
+	objects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		p := strings.TrimPrefix(r.URL.Path, "/")
+		switch {
+		case r.Method == http.MethodGet && p == "":
+			list := []contextObject{}
+			for path, content := range objects {
+				if strings.HasPrefix(path, r.URL.Query().Get("prefix")) {
+					list = append(list, contextObject{Path: path, Size: int64(len(content)), Modified: time.Now()})
+				}
+			}
+			sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+			json.NewEncoder(w).Encode(map[string]any{"objects": list})
+		case r.Method == http.MethodGet:
+			content, ok := objects[p]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			io.WriteString(w, content)
+		case r.Method == http.MethodPut:
+			if _, ok := objects[p]; ok && r.Header.Get("If-None-Match") == "*" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			objects[p] = string(data)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, objects
+}
+
+func TestRemoteContextStore(t *testing.T) {
+	srv, objects := contextObjectServer(t, "secret")
+	t.Setenv("SFA_CONTEXT_STORE_URL", srv.URL+"/")
+	t.Setenv("SFA_CONTEXT_STORE_TOKEN", "secret")
+	store, err := openContextStore(map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := store.write(ContextEntry{Type: ContextFinding, Tags: []string{"auth"}, Slug: "token leak", Content: "The token leaks into logs"}, "reviewer", "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(path, srv.URL+"/reviewer/s1/") || !strings.HasSuffix(path, "-token leak.md") {
+		t.Errorf("write returned %q", path)
+	}
+	if len(objects) != 1 {
+		t.Fatalf("server holds %d entries, want 1", len(objects))
+	}
+	if _, err := store.write(ContextEntry{Type: ContextDecision, Slug: "fix", Content: "Mask tokens"}, "fixer", "s2"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := store.search(ContextQuery{Query: "LEAKS", Tags: []string{"auth"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Agent != "reviewer" || results[0].FilePath != path || results[0].Snippet == "" {
+		t.Errorf("search returned %+v", results)
+	}
+	if results, _ := store.search(ContextQuery{Agent: "fixer"}); len(results) != 1 || results[0].Type != ContextDecision {
+		t.Errorf("agent search returned %+v", results)
+	}
+
+	data, err := store.export(ContextExportOpts{SessionID: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := t.TempDir()
+	if imported, err := importContext(local, data); err != nil || len(imported) != 1 {
+		t.Fatalf("imported %v, %v from the remote export", imported, err)
+	}
+
+	// Back the other way: only what the server lacks is written
+	local2 := t.TempDir()
+	if _, err := writeContextEntry(ContextEntry{Type: ContextSummary, Slug: "done", Content: "Done"}, "fixer", "s2", local2); err != nil {
+		t.Fatal(err)
+	}
+	bundle, _ := exportContext(local2, ContextExportOpts{})
+	imported, err := store.importBundle(bundle)
+	if err != nil || len(imported) != 1 || !strings.HasPrefix(imported[0], srv.URL+"/fixer/s2/") {
+		t.Errorf("importBundle returned %v, %v", imported, err)
+	}
+	if again, _ := store.importBundle(bundle); len(again) != 0 {
+		t.Errorf("second import wrote %v", again)
+	}
+}
+
+func TestRemoteContextStoreSameSlug(t *testing.T) {
+	srv, objects := contextObjectServer(t, "")
+	u, _ := url.Parse(srv.URL)
+	store := &remoteContextStore{url: srv.URL, backend: newHTTPObjectBackend(u, "")}
+	seen := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		path, err := store.write(ContextEntry{Type: ContextFinding, Slug: "same", Content: fmt.Sprintf("finding %d", i)}, "reviewer", "s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[path] {
+			t.Errorf("two writes returned %s", path)
+		}
+		seen[path] = true
+	}
+	if len(objects) != 5 {
+		t.Errorf("server holds %d entries, want 5", len(objects))
+	}
+}
+
+func TestRemoteContextStoreErrors(t *testing.T) {
+	srv, _ := contextObjectServer(t, "secret")
+	u, _ := url.Parse(srv.URL)
+	store := &remoteContextStore{url: srv.URL, backend: newHTTPObjectBackend(u, "wrong")}
+	if _, err := store.write(ContextEntry{Type: ContextFinding, Slug: "x", Content: "x"}, "a", ""); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected the server's 401, got %v", err)
+	}
+	if _, err := store.search(ContextQuery{}); err == nil {
+		t.Error("expected an error searching without access")
+	}
+	if _, err := store.search(ContextQuery{Sort: "oldest"}); err == nil {
+		t.Error("expected an error for an unknown sort")
+	}
+}
@@ -0,0 +1,154 @@
+package sfa
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// contextPruneInterval is how often writes prune a context store, and
+// contextPruneMarker the file at its root whose modification time records
+// the last pass.
+const (
+	contextPruneInterval = time.Hour
+	contextPruneMarker   = ".last-prune"
+)
+
+// parseRetention reads a retention period: "forever", a number of days
+// such as "30d", or a Go duration such as "12h". Forever is 0.
+func parseRetention(v string) (time.Duration, error) {
+	if v == "forever" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid retention %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid retention %q", v)
+	}
+	return d, nil
+}
+
+// resolveContextRetention returns contextStore.retention: how long entries
+// of each type are kept, with "default" for types not listed. Invalid
+// periods are warned about and ignored, keeping those entries.
+func resolveContextRetention(config map[string]any) map[string]time.Duration {
+	cs, _ := config["contextStore"].(map[string]any)
+	raw, _ := cs["retention"].(map[string]any)
+	retention := make(map[string]time.Duration, len(raw))
+	for _, key := range sortedKeys(raw) {
+		v, _ := raw[key].(string)
+		d, err := parseRetention(v)
+		if err != nil {
+			stderrLog.Warn(fmt.Sprintf("ignoring contextStore.retention.%s: %v (use forever, 30d, or 12h)", key, err))
+			continue
+		}
+		retention[key] = d
+	}
+	return retention
+}
+
+// contextEntryExpired reports whether an entry of type typ written at
+// timestamp is past its retention at now.
+func contextEntryExpired(retention map[string]time.Duration, typ ContextType, timestamp time.Time, now time.Time) bool {
+	keep, ok := retention[string(typ)]
+	if !ok {
+		keep = retention["default"]
+	}
+	return keep > 0 && now.Sub(timestamp) > keep
+}
+
+// pruneContextStore removes the entries of the store at storePath that are
+// past their retention, and their rows in the index. An entry's age is
+// from its frontmatter timestamp, or without one, its file's modification
+// time. It returns the paths removed.
+func pruneContextStore(storePath string, retention map[string]time.Duration, now time.Time) ([]string, error) {
+	if len(retention) == 0 {
+		return nil, nil
+	}
+	var removed []string
+	err := filepath.Walk(storePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		entry, err := parseContextFile(path)
+		if err != nil {
+			return nil
+		}
+		written, err := time.Parse(time.RFC3339, entry.Timestamp)
+		if err != nil {
+			written = info.ModTime()
+		}
+		if contextEntryExpired(retention, entry.Type, written, now) && removeContextEntryFile(path) == nil {
+			removed = append(removed, path)
+		}
+		return nil
+	})
+	if len(removed) > 0 {
+		unindexContextFiles(storePath, removed)
+	}
+	return removed, err
+}
+
+// unindexContextFiles removes entries from the store's index, or the index
+// itself if they cannot be removed, so it is rebuilt without them.
+func unindexContextFiles(storePath string, paths []string) {
+	withContextIndexLock(storePath, func() error {
+		unindexContextFilesLocked(storePath, paths)
+		return nil
+	})
+}
+
+func unindexContextFilesLocked(storePath string, paths []string) {
+	indexPath := filepath.Join(storePath, contextIndexFile)
+	if _, err := os.Stat(indexPath); err != nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("BEGIN;\n")
+	for _, path := range paths {
+		rel, err := filepath.Rel(storePath, path)
+		if err != nil {
+			continue
+		}
+		q := sqlQuote(filepath.ToSlash(rel))
+		b.WriteString(fmt.Sprintf("DELETE FROM entries WHERE path = %s;\nDELETE FROM entries_fts WHERE path = %s;\n", q, q))
+	}
+	b.WriteString(contextEmbeddingsSchema)
+	b.WriteString("DELETE FROM embeddings WHERE path NOT IN (SELECT path FROM entries);\nCOMMIT;\n")
+	if _, err := runSQLite(storePath, b.String()); err != nil {
+		os.Remove(indexPath)
+	}
+}
+
+// maybePruneContextStore prunes the store after a write, at most once per
+// contextPruneInterval. Failures are only warned about.
+func maybePruneContextStore(storePath string, retention map[string]time.Duration, now time.Time) {
+	if len(retention) == 0 {
+		return
+	}
+	marker := filepath.Join(storePath, contextPruneMarker)
+	if info, err := os.Stat(marker); err == nil && now.Sub(info.ModTime()) < contextPruneInterval {
+		return
+	}
+	if err := os.WriteFile(marker, nil, 0644); err == nil {
+		os.Chtimes(marker, now, now)
+	}
+	if _, err := pruneContextStore(storePath, retention, now); err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to prune context store: %v", err))
+	}
+}
@@ -0,0 +1,113 @@
+package sfa
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"forever", 0, false},
+		{"30d", 30 * 24 * time.Hour, false},
+		{"12h", 12 * time.Hour, false},
+		{"0d", 0, true},
+		{"-1h", 0, true},
+		{"soon", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRetention(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRetention(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveContextRetention(t *testing.T) {
+	config := map[string]any{"contextStore": map[string]any{"retention": map[string]any{
+		"finding": "30d", "summary": "forever", "default": "bogus",
+	}}}
+	got := resolveContextRetention(config)
+	if len(got) != 2 || got["finding"] != 30*24*time.Hour || got["summary"] != 0 {
+		t.Errorf("resolveContextRetention() = %v", got)
+	}
+}
+
+func TestPruneContextStore(t *testing.T) {
+	store := t.TempDir()
+	write := func(typ ContextType, slug string) string {
+		t.Helper()
+		path, err := writeContextEntry(ContextEntry{Type: typ, Slug: slug, Content: slug}, "notes", "", store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	finding := write(ContextFinding, "old-finding")
+	summary := write(ContextSummary, "old-summary")
+	decision := write(ContextDecision, "old-decision")
+
+	_, sqliteErr := exec.LookPath("sqlite3")
+	if sqliteErr == nil {
+		// Build the index, to check pruned entries leave it
+		if _, err := searchContextIndex(ContextQuery{}, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	retention := map[string]time.Duration{"finding": 24 * time.Hour, "summary": 0, "default": 90 * 24 * time.Hour}
+	now := time.Now().Add(48 * time.Hour)
+	removed, err := pruneContextStore(store, retention, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != finding {
+		t.Fatalf("removed = %v, want only the finding", removed)
+	}
+	for _, kept := range []string{summary, decision} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("%s was removed", filepath.Base(kept))
+		}
+	}
+
+	if sqliteErr == nil {
+		results, err := searchContextIndex(ContextQuery{}, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 {
+			t.Errorf("index has %d entries after pruning, want 2", len(results))
+		}
+	}
+}
+
+func TestMaybePruneContextStore(t *testing.T) {
+	store := t.TempDir()
+	retention := map[string]time.Duration{"default": time.Hour}
+	path, err := writeContextEntry(ContextEntry{Type: ContextFinding, Slug: "a", Content: "a"}, "notes", "", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A recent pass skips pruning
+	now := time.Now().Add(2 * time.Hour)
+	marker := filepath.Join(store, contextPruneMarker)
+	os.WriteFile(marker, nil, 0644)
+	os.Chtimes(marker, now, now)
+	maybePruneContextStore(store, retention, now)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal("pruned within the interval of the last pass")
+	}
+
+	maybePruneContextStore(store, retention, now.Add(contextPruneInterval))
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expired entry was not pruned once the interval passed")
+	}
+}
@@ -0,0 +1,308 @@
+package sfa
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Credentials sign requests to an S3 context store.
+type s3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// s3CredentialsFromEnv reads SFA_CONTEXT_STORE_ACCESS_KEY_ID,
+// SFA_CONTEXT_STORE_SECRET_ACCESS_KEY, and SFA_CONTEXT_STORE_SESSION_TOKEN,
+// falling back to their AWS_ equivalents. Only the SFA_ variables reach
+// subagents.
+func s3CredentialsFromEnv() s3Credentials {
+	env := func(name string) string {
+		if v := os.Getenv("SFA_CONTEXT_STORE_" + name); v != "" {
+			return v
+		}
+		return os.Getenv("AWS_" + name)
+	}
+	return s3Credentials{
+		AccessKeyID:     env("ACCESS_KEY_ID"),
+		SecretAccessKey: env("SECRET_ACCESS_KEY"),
+		SessionToken:    env("SESSION_TOKEN"),
+	}
+}
+
+// s3ObjectBackend is a context store in an S3 bucket, or a bucket of any
+// S3-compatible service, under an optional key prefix.
+type s3ObjectBackend struct {
+	endpoint  string // scheme and host
+	bucket    string
+	prefix    string // key prefix, "" or ending in "/"
+	pathStyle bool   // address the bucket in the path rather than the host
+	region    string
+	creds     s3Credentials
+	client    *http.Client
+}
+
+// newS3ObjectBackend returns the backend for s3://<bucket>/<prefix>. The
+// region is contextStore.region, AWS_REGION, or AWS_DEFAULT_REGION, by
+// default us-east-1. contextStore.endpoint, AWS_ENDPOINT_URL_S3, or
+// AWS_ENDPOINT_URL points it at an S3-compatible service, addressed
+// path-style.
+func newS3ObjectBackend(u *url.URL, config map[string]any) (*s3ObjectBackend, error) {
+	cs, _ := config["contextStore"].(map[string]any)
+	setting := func(key string, vars ...string) string {
+		if v, _ := cs[key].(string); v != "" {
+			return v
+		}
+		for _, name := range vars {
+			if v := os.Getenv(name); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	b := &s3ObjectBackend{
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+		region: setting("region", "AWS_REGION", "AWS_DEFAULT_REGION"),
+		creds:  s3CredentialsFromEnv(),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	if b.prefix != "" {
+		b.prefix += "/"
+	}
+	if b.region == "" {
+		b.region = "us-east-1"
+	}
+	if b.creds.AccessKeyID == "" || b.creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("context store %s needs credentials: set SFA_CONTEXT_STORE_ACCESS_KEY_ID and SFA_CONTEXT_STORE_SECRET_ACCESS_KEY", u)
+	}
+
+	if endpoint := setting("endpoint", "AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); endpoint != "" {
+		e, err := url.Parse(endpoint)
+		if err != nil || e.Host == "" || (e.Scheme != "http" && e.Scheme != "https") {
+			return nil, fmt.Errorf("invalid context store endpoint %q", endpoint)
+		}
+		b.endpoint = e.Scheme + "://" + e.Host
+		b.pathStyle = true
+	} else {
+		b.endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", b.bucket, b.region)
+	}
+	return b, nil
+}
+
+// s3Escape percent-encodes everything but unreserved characters, as
+// signature version 4 requires.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// request builds a signed request for key, with query parameters and body.
+func (b *s3ObjectBackend) request(method, key string, query url.Values, body []byte, header http.Header) (*http.Request, error) {
+	p := "/"
+	if b.pathStyle {
+		p += b.bucket + "/"
+	}
+	if key != "" {
+		segments := strings.Split(key, "/")
+		for i, s := range segments {
+			segments[i] = s3Escape(s)
+		}
+		p += strings.Join(segments, "/")
+	}
+	var q []string
+	for _, k := range sortedKeys(query) {
+		q = append(q, s3Escape(k)+"="+s3Escape(query.Get(k)))
+	}
+
+	req, err := http.NewRequest(method, b.endpoint+p, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawPath = p
+	req.URL.RawQuery = strings.Join(q, "&")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	signS3Request(req, body, b.creds, b.region, time.Now())
+	return req, nil
+}
+
+// signS3Request signs req with AWS signature version 4, covering the host
+// and every header already set.
+func signS3Request(req *http.Request, body []byte, creds s3Credentials, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := sortedKeys(headers)
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonical.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(request))
+	scope := day + "/" + region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac(mac(mac(mac([]byte("AWS4"+creds.SecretAccessKey), day), region), "s3"), "aws4_request")
+	signature := hex.EncodeToString(mac(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func (b *s3ObjectBackend) do(method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	req, err := b.request(method, key, query, body, header)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("context store is unavailable: %w", err)
+	}
+	return resp, nil
+}
+
+// s3Error describes an error response, with the message S3 gives.
+func s3Error(resp *http.Response) error {
+	var out struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&out) == nil && out.Code != "" {
+		return fmt.Errorf("context store returned %s: %s: %s", resp.Status, out.Code, out.Message)
+	}
+	return fmt.Errorf("context store returned %s", resp.Status)
+}
+
+func (b *s3ObjectBackend) put(p string, data []byte) error {
+	resp, err := b.do(http.MethodPut, b.prefix+p, nil, data, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// create is a conditional PutObject: S3 refuses it with 412 if the key
+// exists, or 409 if another conditional write to the key is in flight.
+func (b *s3ObjectBackend) create(p string, data []byte) error {
+	resp, err := b.do(http.MethodPut, b.prefix+p, nil, data, http.Header{"If-None-Match": {"*"}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict:
+		return errObjectExists
+	case resp.StatusCode/100 != 2:
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (b *s3ObjectBackend) get(p string) ([]byte, error) {
+	resp, err := b.do(http.MethodGet, b.prefix+p, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrContextEntryNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, s3Error(resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// list pages through ListObjectsV2.
+func (b *s3ObjectBackend) list(prefix string) ([]contextObject, error) {
+	var objects []contextObject
+	query := url.Values{"list-type": {"2"}, "prefix": {b.prefix + prefix}}
+	for {
+		resp, err := b.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid context store listing: %w", err)
+		}
+		for _, c := range page.Contents {
+			objects = append(objects, contextObject{
+				Path:     strings.TrimPrefix(c.Key, b.prefix),
+				Size:     c.Size,
+				Modified: c.LastModified,
+			})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
@@ -0,0 +1,46 @@
+package sfa
+
+import "sort"
+
+// listContextSessions returns the sessions with entries in the store, the
+// most recently active first.
+func listContextSessions(store contextStore) ([]ContextSession, error) {
+	results, err := store.search(ContextQuery{})
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*ContextSession)
+	agents := make(map[string]map[string]bool)
+	for _, r := range results {
+		if r.SessionID == "" {
+			continue
+		}
+		s := byID[r.SessionID]
+		if s == nil {
+			s = &ContextSession{ID: r.SessionID, First: r.Timestamp, Last: r.Timestamp}
+			byID[r.SessionID] = s
+			agents[r.SessionID] = make(map[string]bool)
+		}
+		s.Entries++
+		if r.Timestamp < s.First {
+			s.First = r.Timestamp
+		}
+		if r.Timestamp > s.Last {
+			s.Last = r.Timestamp
+		}
+		agents[r.SessionID][r.Agent] = true
+	}
+
+	sessions := make([]ContextSession, 0, len(byID))
+	for id, s := range byID {
+		s.Agents = sortedKeys(agents[id])
+		sessions = append(sessions, *s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Last != sessions[j].Last {
+			return sessions[i].Last > sessions[j].Last
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, nil
+}
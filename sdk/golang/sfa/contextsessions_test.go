@@ -0,0 +1,64 @@
+package sfa
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListContextSessions(t *testing.T) {
+	dir := t.TempDir()
+	write := func(agent, session, slug string, at time.Time) {
+		t.Helper()
+		path := filepath.Join(dir, agent, session, slug+".md")
+		os.MkdirAll(filepath.Dir(path), 0755)
+		data := formatContextEntry(ContextEntry{Type: ContextFinding, Content: slug}, agent, session, at)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := time.Date(2026, 2, 21, 14, 0, 0, 0, time.UTC)
+	write("reviewer", "s1", "a", base)
+	write("fixer", "s1", "b", base.Add(time.Hour))
+	write("reviewer", "s1", "c", base.Add(30*time.Minute))
+	write("reviewer", "s2", "d", base.Add(2*time.Hour))
+	write("reviewer", "", "outside", base.Add(3*time.Hour))
+
+	sessions, err := listContextSessions(&localContextStore{path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "s2" || sessions[1].ID != "s1" {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+	s1 := sessions[1]
+	if s1.Entries != 3 || s1.First != "2026-02-21T14:00:00Z" || s1.Last != "2026-02-21T15:00:00Z" ||
+		strings.Join(s1.Agents, ",") != "fixer,reviewer" {
+		t.Errorf("s1 = %+v", s1)
+	}
+
+	results, err := searchContextEntries(ContextQuery{SessionID: "s1", Agent: "reviewer"}, dir)
+	if err != nil || len(results) != 2 {
+		t.Errorf("search of a session returned %d results, %v", len(results), err)
+	}
+}
+
+func TestListContextSessionsRemote(t *testing.T) {
+	srv, _ := contextObjectServer(t, "")
+	u, _ := url.Parse(srv.URL)
+	store := &remoteContextStore{url: srv.URL, backend: newHTTPObjectBackend(u, "")}
+	store.write(ContextEntry{Type: ContextFinding, Slug: "a", Content: "a"}, "reviewer", "s1")
+	store.write(ContextEntry{Type: ContextFinding, Slug: "b", Content: "b"}, "fixer", "s1")
+	store.write(ContextEntry{Type: ContextFinding, Slug: "c", Content: "c"}, "fixer", "s2")
+
+	sessions, err := listContextSessions(store)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("sessions = %+v, %v", sessions, err)
+	}
+	if results, _ := store.search(ContextQuery{SessionID: "s1"}); len(results) != 2 {
+		t.Errorf("search of a session returned %+v", results)
+	}
+}
@@ -0,0 +1,124 @@
+package sfa
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// contextStore is where an agent's context entries are kept: a directory
+// on this machine by default, or a remote store that agents on several
+// machines or in several containers share.
+type contextStore interface {
+	// write stores an entry and returns where it was written.
+	write(entry ContextEntry, agentName, sessionID string) (string, error)
+	// read returns the entry at a path, or with an ID.
+	read(pathOrID string) (*ContextResult, error)
+	search(query ContextQuery) ([]ContextResult, error)
+	export(opts ContextExportOpts) ([]byte, error)
+	// importBundle stores the entries of an export the store does not
+	// already have and returns where they were written.
+	importBundle(data []byte) ([]string, error)
+	// rewrite applies edit to the entry at path, writing it back if edit
+	// reports a change.
+	rewrite(path string, edit func(doc *contextDocument) bool) error
+	// entryPath returns the path of the entry at path relative to the store
+	// root, as links name it.
+	entryPath(path string) (string, error)
+	// readAttachment returns the attachment name of the entry at path.
+	readAttachment(path, name string) ([]byte, error)
+}
+
+// localContextStore is the context store as a directory: indexed, held to
+// its quota, and pruned past its retention.
+type localContextStore struct {
+	path      string
+	retention map[string]time.Duration // contextStore.retention, by entry type
+	quota     contextQuota
+}
+
+func (s *localContextStore) write(entry ContextEntry, agentName, sessionID string) (string, error) {
+	size := int64(len(formatContextEntry(entry, agentName, sessionID, time.Now().UTC())))
+	for _, a := range entry.Attachments {
+		size += int64(len(a.Bytes))
+	}
+	if err := enforceContextQuota(s.path, agentName, sessionID, size, s.quota); err != nil {
+		return "", err
+	}
+	path, err := writeContextEntry(entry, agentName, sessionID, s.path)
+	if err != nil {
+		return "", err
+	}
+	maybePruneContextStore(s.path, s.retention, time.Now())
+	return path, nil
+}
+
+func (s *localContextStore) search(query ContextQuery) ([]ContextResult, error) {
+	return searchContextEntries(query, s.path)
+}
+
+func (s *localContextStore) export(opts ContextExportOpts) ([]byte, error) {
+	return exportContext(s.path, opts)
+}
+
+func (s *localContextStore) importBundle(data []byte) ([]string, error) {
+	return importContext(s.path, data)
+}
+
+// resolveContextStoreURL returns the URL of the remote context store, or ""
+// for a local one.
+// Priority: SFA_CONTEXT_STORE_URL env > SFA_CONTEXT_STORE env (local) >
+// config contextStore.url.
+func resolveContextStoreURL(config map[string]any) string {
+	if u := os.Getenv("SFA_CONTEXT_STORE_URL"); u != "" {
+		return u
+	}
+	if os.Getenv("SFA_CONTEXT_STORE") != "" {
+		return ""
+	}
+	cs, _ := config["contextStore"].(map[string]any)
+	u, _ := cs["url"].(string)
+	return u
+}
+
+// openContextStore returns the context store the config selects, encrypting
+// entry bodies if contextStore.encrypt is set.
+func openContextStore(config map[string]any) (contextStore, error) {
+	store, err := openContextBackend(config)
+	if err != nil || !resolveContextEncryption(config) {
+		return store, err
+	}
+	return &sealedContextStore{store}, nil
+}
+
+// openContextBackend returns the local or remote store the config selects.
+func openContextBackend(config map[string]any) (contextStore, error) {
+	raw := resolveContextStoreURL(config)
+	if raw == "" {
+		return &localContextStore{
+			path:      resolveContextStorePath(config),
+			retention: resolveContextRetention(config),
+			quota:     resolveContextQuota(config),
+		}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid context store URL %q", raw)
+	}
+	var backend objectBackend
+	switch u.Scheme {
+	case "http", "https":
+		backend = newHTTPObjectBackend(u, os.Getenv("SFA_CONTEXT_STORE_TOKEN"))
+	case "s3":
+		backend, err = newS3ObjectBackend(u, config)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported context store URL %q (use http, https, or s3)", raw)
+	}
+	return &remoteContextStore{url: strings.TrimSuffix(raw, "/"), backend: backend}, nil
+}
@@ -0,0 +1,41 @@
+package sfa
+
+import "testing"
+
+func TestResolveContextStoreURL(t *testing.T) {
+	config := map[string]any{"contextStore": map[string]any{"url": "s3://team-bucket/context"}}
+	t.Setenv("SFA_CONTEXT_STORE_URL", "")
+	t.Setenv("SFA_CONTEXT_STORE", "")
+	if got := resolveContextStoreURL(config); got != "s3://team-bucket/context" {
+		t.Errorf("resolveContextStoreURL() = %q, want the config's url", got)
+	}
+
+	// A local store set in the environment wins over the config's url
+	t.Setenv("SFA_CONTEXT_STORE", "/tmp/context")
+	if got := resolveContextStoreURL(config); got != "" {
+		t.Errorf("resolveContextStoreURL() = %q with SFA_CONTEXT_STORE set", got)
+	}
+
+	t.Setenv("SFA_CONTEXT_STORE_URL", "https://context.internal/team")
+	if got := resolveContextStoreURL(config); got != "https://context.internal/team" {
+		t.Errorf("resolveContextStoreURL() = %q, want SFA_CONTEXT_STORE_URL", got)
+	}
+}
+
+func TestOpenContextStore(t *testing.T) {
+	t.Setenv("SFA_CONTEXT_STORE_URL", "")
+	t.Setenv("SFA_CONTEXT_STORE", "")
+	store, err := openContextStore(map[string]any{"contextStore": map[string]any{"path": "/tmp/ctx"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local, ok := store.(*localContextStore); !ok || local.path != "/tmp/ctx" {
+		t.Errorf("openContextStore() = %#v, want the local store at /tmp/ctx", store)
+	}
+
+	for _, u := range []string{"ftp://host/context", "not a url", "https://"} {
+		if _, err := openContextStore(map[string]any{"contextStore": map[string]any{"url": u}}); err == nil {
+			t.Errorf("openContextStore accepted %q", u)
+		}
+	}
+}
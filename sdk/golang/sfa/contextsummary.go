@@ -0,0 +1,136 @@
+package sfa
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrNoSessionContext is returned by SummarizeSession when the session has
+// no context entries to summarize.
+var ErrNoSessionContext = errors.New("session has no context entries")
+
+// sessionSummaryTag marks the entries SummarizeSession writes, which later
+// summaries of the session leave out.
+const sessionSummaryTag = "session-summary"
+
+// resolveContextSummarizer returns the agent that summarizes sessions,
+// config contextStore.summarizer, or "" for none.
+func resolveContextSummarizer(config map[string]any) string {
+	cs, _ := config["contextStore"].(map[string]any)
+	s, _ := cs["summarizer"].(string)
+	return s
+}
+
+// sessionContext returns the entries of the session, oldest first, leaving
+// out earlier summaries of it.
+func sessionContext(ctx *ExecuteContext) ([]ContextResult, error) {
+	results, err := ctx.SearchContext(ContextQuery{SessionID: ctx.SessionID})
+	if err != nil {
+		return nil, err
+	}
+	var entries []ContextResult
+	for i := len(results) - 1; i >= 0; i-- {
+		if !slices.Contains(results[i].Tags, sessionSummaryTag) {
+			entries = append(entries, results[i])
+		}
+	}
+	return entries, nil
+}
+
+// formatSessionContext renders entries as one markdown document, the input
+// a summarizer agent reads on stdin.
+func formatSessionContext(sessionID string, entries []ContextResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Context of session %s\n", sessionID)
+	for _, e := range entries {
+		fmt.Fprintf(&b, "\n## %s: %s\n\n", e.Type, contextEntryTitle(e.Content))
+		fmt.Fprintf(&b, "Agent: %s  \nWritten: %s", e.Agent, e.Timestamp)
+		if len(e.Tags) > 0 {
+			fmt.Fprintf(&b, "  \nTags: %s", strings.Join(e.Tags, ", "))
+		}
+		b.WriteString("\n\n" + strings.TrimSpace(e.Content) + "\n")
+	}
+	return b.String()
+}
+
+// digestSessionContext lists entries one line each, the summary written
+// without a summarizer agent.
+func digestSessionContext(sessionID string, entries []ContextResult, paths []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d context entries of session %s:\n\n", len(entries), sessionID)
+	for i, e := range entries {
+		fmt.Fprintf(&b, "- **%s** by %s: %s (%s)\n", e.Type, e.Agent, contextEntryTitle(e.Content), paths[i])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// contextEntryTitle returns the first line of content, without heading
+// marks, cut to 100 characters.
+func contextEntryTitle(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(line, "#"))
+		if line == "" {
+			continue
+		}
+		if r := []rune(line); len(r) > 100 {
+			return string(r[:99]) + "…"
+		}
+		return line
+	}
+	return "(empty)"
+}
+
+// summarizeSession writes a summary entry of the session's context,
+// linking to every entry it summarizes. The summary is what the summarizer
+// agent prints given the entries on stdin, or without one a digest of
+// them.
+func summarizeSession(ctx *ExecuteContext, store contextStore, opts SummarizeSessionOpts) (string, error) {
+	if ctx.SessionID == "" {
+		return "", ErrNoSessionContext
+	}
+	entries, err := sessionContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrNoSessionContext, ctx.SessionID)
+	}
+	links := make([]string, len(entries))
+	for i, e := range entries {
+		if links[i], err = store.entryPath(e.FilePath); err != nil {
+			return "", err
+		}
+	}
+
+	summarizer := opts.Summarizer
+	if summarizer == "" {
+		summarizer = resolveContextSummarizer(ctx.Config)
+	}
+	content := digestSessionContext(ctx.SessionID, entries, links)
+	if summarizer != "" {
+		result, err := ctx.Invoke(summarizer, &InvokeOpts{Context: formatSessionContext(ctx.SessionID, entries)})
+		if err != nil {
+			return "", fmt.Errorf("summarizer %s: %w", summarizer, err)
+		}
+		if !result.OK {
+			return "", fmt.Errorf("summarizer %s exited with code %d: %s", summarizer, result.ExitCode, strings.TrimSpace(result.Stderr))
+		}
+		if content = strings.TrimSpace(result.Output); content == "" {
+			return "", fmt.Errorf("summarizer %s returned no summary", summarizer)
+		}
+	}
+
+	slug := opts.Slug
+	if slug == "" {
+		slug = sessionSummaryTag
+	}
+	tags := []string{sessionSummaryTag}
+	for _, tag := range opts.Tags {
+		if tag != sessionSummaryTag {
+			tags = append(tags, tag)
+		}
+	}
+	return ctx.WriteContext(ContextEntry{Type: ContextSummary, Tags: tags, Slug: slug, Content: content, Links: links})
+}
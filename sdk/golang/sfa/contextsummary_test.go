@@ -0,0 +1,70 @@
+package sfa
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSummarizeSession(t *testing.T) {
+	store := t.TempDir()
+	def := &AgentDef{Name: "lead"}
+	e := &executor{
+		def:          def,
+		resolved:     resolveEnv(nil, def.Name, map[string]any{}),
+		contextStore: &localContextStore{path: store},
+	}
+	ctx := e.executeContext(&execution{ctx: context.Background(), safety: &SafetyState{SessionID: "s1"}}, "", nil)
+	other := e.executeContext(&execution{ctx: context.Background(), safety: &SafetyState{SessionID: "s2"}}, "", nil)
+
+	if _, err := ctx.SummarizeSession(SummarizeSessionOpts{}); !errors.Is(err, ErrNoSessionContext) {
+		t.Errorf("expected ErrNoSessionContext, got %v", err)
+	}
+
+	ctx.WriteContext(ContextEntry{Type: ContextFinding, Slug: "leak", Content: "# Token leak\n\nThe token is logged."})
+	ctx.WriteContext(ContextEntry{Type: ContextDecision, Slug: "mask", Content: "Mask tokens in logs."})
+	other.WriteContext(ContextEntry{Type: ContextFinding, Slug: "unrelated", Content: "Another session."})
+
+	path, err := ctx.SummarizeSession(SummarizeSessionOpts{Tags: []string{"auth"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	doc, err := parseContextDocument(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Type != ContextSummary || strings.Join(doc.Tags, ",") != "session-summary,auth" || len(doc.Links) != 2 {
+		t.Errorf("summary entry = %+v", doc)
+	}
+	if !strings.Contains(doc.Content, "**finding** by lead: Token leak (lead/s1/") || strings.Contains(doc.Content, "Another session") {
+		t.Errorf("digest = %q", doc.Content)
+	}
+
+	// A summarizer gets the entries, without the earlier summary
+	var input string
+	ctx.Invoke = func(agentName string, opts *InvokeOpts) (*InvokeResult, error) {
+		input = opts.Context
+		return &InvokeResult{OK: true, Output: "Tokens leaked; they are now masked.\n"}, nil
+	}
+	path, err = ctx.SummarizeSession(SummarizeSessionOpts{Summarizer: "summarizer", Slug: "wrap-up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(input, "## finding: Token leak") || !strings.Contains(input, "Mask tokens in logs.") || strings.Contains(input, "session-summary") {
+		t.Errorf("summarizer input = %q", input)
+	}
+	result, err := ctx.ReadContext(path)
+	if err != nil || result.Content != "Tokens leaked; they are now masked." || len(result.Links) != 2 || !strings.HasSuffix(path, "-wrap-up.md") {
+		t.Errorf("summary = %+v, %v", result, err)
+	}
+
+	ctx.Invoke = func(string, *InvokeOpts) (*InvokeResult, error) {
+		return &InvokeResult{ExitCode: 1, Stderr: "no model\n"}, nil
+	}
+	if _, err := ctx.SummarizeSession(SummarizeSessionOpts{Summarizer: "summarizer"}); err == nil || !strings.Contains(err.Error(), "no model") {
+		t.Errorf("expected the summarizer's failure, got %v", err)
+	}
+}
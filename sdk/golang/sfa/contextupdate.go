@@ -0,0 +1,283 @@
+package sfa
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrContextEntryNotFound is returned by AppendContext, UpdateContext, and
+// ReplaceTags when the store has no entry at the path given.
+var ErrContextEntryNotFound = errors.New("context entry not found")
+
+// contextChangelogHeading opens the changelog section of an entry.
+const contextChangelogHeading = "## Changelog"
+
+// contextDocument is a context entry file: its frontmatter, its content,
+// and the changes recorded in its changelog.
+type contextDocument struct {
+	Agent       string
+	SessionID   string
+	Timestamp   string
+	Type        ContextType
+	Tags        []string
+	Links       []string
+	Metadata    map[string]any
+	Attachments []string // names of the entry's attachments
+	Content     string
+	Changelog   []string // lines of the changelog, without their "- "
+}
+
+// parseContextDocument splits an entry file into its parts. The changelog
+// is the list under the last changelog heading.
+func parseContextDocument(data string) (*contextDocument, error) {
+	end := -1
+	if strings.HasPrefix(data, "---\n") {
+		end = strings.Index(data[3:], "\n---\n")
+	}
+	if end < 0 {
+		return nil, errors.New("invalid context entry: no frontmatter")
+	}
+	end += 3 + len("\n---\n")
+	meta, err := parseContextEntry("", strings.NewReader(data[:end]))
+	if err != nil {
+		return nil, err
+	}
+	doc := &contextDocument{
+		Agent:       meta.Agent,
+		SessionID:   meta.SessionID,
+		Timestamp:   meta.Timestamp,
+		Type:        meta.Type,
+		Tags:        meta.Tags,
+		Links:       meta.Links,
+		Metadata:    meta.Metadata,
+		Attachments: contextAttachmentNames(meta.Attachments),
+	}
+
+	lines := strings.Split(data[end:], "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) != contextChangelogHeading {
+			continue
+		}
+		for _, l := range lines[i+1:] {
+			if item, ok := strings.CutPrefix(strings.TrimSpace(l), "- "); ok {
+				doc.Changelog = append(doc.Changelog, item)
+			}
+		}
+		lines = lines[:i]
+		break
+	}
+	doc.Content = strings.TrimSpace(strings.Join(lines, "\n"))
+	return doc, nil
+}
+
+// formatContextDocument renders an entry file: YAML frontmatter, the
+// content, then the changelog if there is one.
+func formatContextDocument(doc *contextDocument) string {
+	var b strings.Builder
+	writeContextFrontmatter(&b, doc)
+	b.WriteString("\n")
+	b.WriteString(doc.Content)
+	b.WriteString("\n")
+
+	if len(doc.Changelog) > 0 {
+		b.WriteString("\n" + contextChangelogHeading + "\n\n")
+		for _, change := range doc.Changelog {
+			b.WriteString("- " + change + "\n")
+		}
+	}
+	return b.String()
+}
+
+// logChange records a change by agentName at now in the changelog.
+func (d *contextDocument) logChange(agentName, description string, now time.Time) {
+	d.Changelog = append(d.Changelog, fmt.Sprintf("%s [%s]: %s", now.UTC().Format(time.RFC3339), agentName, description))
+}
+
+// appendContent adds content after what the entry holds, as a paragraph of
+// its own, and describes the change.
+func (d *contextDocument) appendContent(content string) string {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return ""
+	}
+	if d.Content == "" {
+		d.Content = content
+	} else {
+		d.Content += "\n\n" + content
+	}
+	return "Appended to the content"
+}
+
+// update applies the fields of entry that are set: Type, Tags, Links
+// (empty but non-nil clears them), Metadata (merged, a nil value removing
+// its key), and Content. The slug names the file and is not changed. It
+// describes what changed, or returns "" if nothing did.
+func (d *contextDocument) update(entry ContextEntry) string {
+	var changed []string
+	if entry.Type != "" && entry.Type != d.Type {
+		d.Type = entry.Type
+		changed = append(changed, "type")
+	}
+	if entry.Tags != nil && !slices.Equal(entry.Tags, d.Tags) {
+		d.Tags = entry.Tags
+		changed = append(changed, "tags")
+	}
+	if entry.Links != nil && !slices.Equal(entry.Links, d.Links) {
+		d.Links = entry.Links
+		changed = append(changed, "links")
+	}
+	if entry.Metadata != nil && d.mergeMetadata(entry.Metadata) {
+		changed = append(changed, "metadata")
+	}
+	if content := strings.TrimSpace(entry.Content); content != "" && content != d.Content {
+		d.Content = content
+		changed = append(changed, "content")
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+	return "Updated the " + strings.Join(changed, ", ")
+}
+
+// mergeMetadata sets the metadata keys given, removing those set to nil,
+// and reports whether any changed.
+func (d *contextDocument) mergeMetadata(metadata map[string]any) bool {
+	changed := false
+	for key, v := range contextMetadataValues(metadata) {
+		old, ok := d.Metadata[key]
+		switch {
+		case v == nil && ok:
+			delete(d.Metadata, key)
+		case v != nil && !(ok && reflect.DeepEqual(old, v)):
+			if d.Metadata == nil {
+				d.Metadata = make(map[string]any)
+			}
+			d.Metadata[key] = v
+		default:
+			continue
+		}
+		changed = true
+	}
+	return changed
+}
+
+// replaceTags sets the entry's tags and describes the change.
+func (d *contextDocument) replaceTags(tags []string) string {
+	if slices.Equal(tags, d.Tags) {
+		return ""
+	}
+	d.Tags = tags
+	return fmt.Sprintf("Replaced the tags with [%s]", strings.Join(tags, ", "))
+}
+
+// contextEntryPath returns the path relative to the store root of the
+// entry at p: a path WriteContext returned, or a relative path as links
+// are. Paths outside the store are refused.
+func contextEntryPath(storePath, p string) (string, error) {
+	rel := p
+	// A store at a relative path returns entries' paths under it
+	if r, ok := strings.CutPrefix(filepath.Clean(p), filepath.Clean(storePath)+string(filepath.Separator)); ok && !filepath.IsAbs(storePath) {
+		rel = r
+	} else if filepath.IsAbs(p) {
+		root, err := filepath.Abs(storePath)
+		if err != nil {
+			return "", err
+		}
+		if rel, err = filepath.Rel(root, p); err != nil {
+			return "", fmt.Errorf("%s is not an entry of the context store", p)
+		}
+	}
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	if !validContextBundlePath(rel) {
+		return "", fmt.Errorf("%s is not an entry of the context store", p)
+	}
+	return rel, nil
+}
+
+// contextEntryFile returns the file of the entry at p, as contextEntryPath
+// accepts it.
+func contextEntryFile(storePath, p string) (string, error) {
+	rel, err := contextEntryPath(storePath, p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storePath, filepath.FromSlash(rel)), nil
+}
+
+func (s *localContextStore) entryPath(p string) (string, error) {
+	return contextEntryPath(s.path, p)
+}
+
+// rewrite applies edit to the entry at p under the store's update lock, and
+// writes it back, reindexed, if edit reports a change.
+func (s *localContextStore) rewrite(p string, edit func(doc *contextDocument) bool) error {
+	file, err := contextEntryFile(s.path, p)
+	if err != nil {
+		return err
+	}
+	return withFileLock(filepath.Join(s.path, ".update"), func() error {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrContextEntryNotFound, p)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read context entry: %w", err)
+		}
+		doc, err := parseContextDocument(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if !edit(doc) {
+			return nil
+		}
+		if err := writeFileAtomic(file, []byte(formatContextDocument(doc)), 0644); err != nil {
+			return fmt.Errorf("failed to write context entry: %w", err)
+		}
+		indexContextFile(s.path, file)
+		return nil
+	})
+}
+
+// entryPath returns the path relative to the store root of the entry at p,
+// its URL or a relative path.
+func (s *remoteContextStore) entryPath(p string) (string, error) {
+	rel := strings.TrimPrefix(p, s.url+"/")
+	if !validContextBundlePath(rel) {
+		return "", fmt.Errorf("%s is not an entry of the context store", p)
+	}
+	return rel, nil
+}
+
+// rewrite applies edit to the entry at p, its URL or a path relative to the
+// store root, and puts it back if edit reports a change. Two agents
+// rewriting one entry at once may lose one of the changes.
+func (s *remoteContextStore) rewrite(p string, edit func(doc *contextDocument) bool) error {
+	rel, err := s.entryPath(p)
+	if err != nil {
+		return err
+	}
+	data, err := s.backend.get(rel)
+	if errors.Is(err, ErrContextEntryNotFound) {
+		return fmt.Errorf("%w: %s", ErrContextEntryNotFound, p)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read context entry: %w", err)
+	}
+	doc, err := parseContextDocument(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	if !edit(doc) {
+		return nil
+	}
+	if err := s.backend.put(rel, []byte(formatContextDocument(doc))); err != nil {
+		return fmt.Errorf("failed to write context entry: %w", err)
+	}
+	return nil
+}
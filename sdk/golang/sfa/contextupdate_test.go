@@ -0,0 +1,141 @@
+package sfa
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseContextDocument(t *testing.T) {
+	data := "---\nagent: notes\ntimestamp: 2026-02-21T14:30:22Z\ntype: summary\ntags:\n  - running\n---\n\nFirst pass.\n\n---\n\nSecond pass.\n\n## Changelog\n\n- 2026-02-21T15:00:00Z [notes]: Appended to the content\n"
+	doc, err := parseContextDocument(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Agent != "notes" || doc.Type != ContextSummary || len(doc.Tags) != 1 {
+		t.Errorf("frontmatter = %+v", doc)
+	}
+	// A horizontal rule in the content is not frontmatter
+	if doc.Content != "First pass.\n\n---\n\nSecond pass." {
+		t.Errorf("content = %q", doc.Content)
+	}
+	if len(doc.Changelog) != 1 || !strings.HasSuffix(doc.Changelog[0], "[notes]: Appended to the content") {
+		t.Errorf("changelog = %q", doc.Changelog)
+	}
+	if got := formatContextDocument(doc); got != data {
+		t.Errorf("formatContextDocument() =\n%s\nwant\n%s", got, data)
+	}
+
+	if _, err := parseContextDocument("no frontmatter"); err == nil {
+		t.Error("expected an error without frontmatter")
+	}
+}
+
+func TestEditContext(t *testing.T) {
+	store := t.TempDir()
+	def := &AgentDef{Name: "notes"}
+	e := &executor{
+		def:          def,
+		resolved:     resolveEnv(nil, def.Name, map[string]any{}),
+		contextStore: &localContextStore{path: store},
+	}
+	ctx := e.executeContext(&execution{ctx: context.Background(), safety: &SafetyState{SessionID: "s1"}}, "", nil)
+
+	path, err := ctx.WriteContext(ContextEntry{Type: ContextSummary, Tags: []string{"draft"}, Slug: "running", Content: "Started."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.AppendContext(path, "Found the leak."); err != nil {
+		t.Fatal(err)
+	}
+	// Paths relative to the store work too, as links are
+	rel, _ := filepath.Rel(store, path)
+	if err := ctx.ReplaceTags(filepath.ToSlash(rel), []string{"final", "auth"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UpdateContext(path, ContextEntry{Type: ContextDecision}); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing changes, so nothing is logged
+	if err := ctx.UpdateContext(path, ContextEntry{Type: ContextDecision}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	doc, err := parseContextDocument(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Content != "Started.\n\nFound the leak." || doc.Type != ContextDecision || strings.Join(doc.Tags, ",") != "final,auth" {
+		t.Errorf("entry is now %+v", doc)
+	}
+	if len(doc.Changelog) != 3 || !strings.Contains(doc.Changelog[1], "[notes]: Replaced the tags with [final, auth]") ||
+		!strings.HasSuffix(doc.Changelog[2], "Updated the type") {
+		t.Errorf("changelog = %q", doc.Changelog)
+	}
+	if doc.SessionID != "s1" || doc.Timestamp == "" {
+		t.Errorf("frontmatter was not kept: %+v", doc)
+	}
+
+	results, err := ctx.SearchContext(ContextQuery{Tags: []string{"auth"}})
+	if err != nil || len(results) != 1 {
+		t.Errorf("search by the new tag returned %+v, %v", results, err)
+	}
+
+	if err := ctx.AppendContext(filepath.Join(store, "notes", "missing.md"), "x"); !errors.Is(err, ErrContextEntryNotFound) {
+		t.Errorf("expected ErrContextEntryNotFound, got %v", err)
+	}
+	outside := filepath.Join(t.TempDir(), "notes", "x.md")
+	if err := ctx.AppendContext(outside, "x"); err == nil || errors.Is(err, ErrContextEntryNotFound) {
+		t.Errorf("expected a path outside the store to be refused, got %v", err)
+	}
+}
+
+func TestRemoteContextRewrite(t *testing.T) {
+	srv, objects := contextObjectServer(t, "")
+	u, _ := url.Parse(srv.URL)
+	store := &remoteContextStore{url: srv.URL, backend: newHTTPObjectBackend(u, "")}
+	path, err := store.write(ContextEntry{Type: ContextSummary, Slug: "running", Content: "Started."}, "notes", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.rewrite(path, func(doc *contextDocument) bool {
+		doc.appendContent("More.")
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := strings.TrimPrefix(path, srv.URL+"/")
+	if !strings.Contains(objects[rel], "Started.\n\nMore.\n") {
+		t.Errorf("stored entry = %q", objects[rel])
+	}
+	if err := store.rewrite(srv.URL+"/notes/missing.md", func(*contextDocument) bool { return true }); !errors.Is(err, ErrContextEntryNotFound) {
+		t.Errorf("expected ErrContextEntryNotFound, got %v", err)
+	}
+}
+
+func TestContextEntryPath(t *testing.T) {
+	abs := filepath.Join(string(filepath.Separator), "data", "context")
+	tests := []struct {
+		store, path, want string
+	}{
+		{abs, filepath.Join(abs, "notes", "s1", "a.md"), "notes/s1/a.md"},
+		{abs, "notes/a.md", "notes/a.md"},
+		// A relative store returns paths under it
+		{"ctx", filepath.Join("ctx", "notes", "a.md"), "notes/a.md"},
+		{abs, filepath.Join(string(filepath.Separator), "elsewhere", "notes", "a.md"), ""},
+		{abs, "../notes/a.md", ""},
+		{abs, "notes/.index.db", ""},
+	}
+	for _, tt := range tests {
+		got, err := contextEntryPath(tt.store, tt.path)
+		if got != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("contextEntryPath(%q, %q) = %q, %v, want %q", tt.store, tt.path, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package sfa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidContextEntry is wrapped by every ContextEntryError.
+var ErrInvalidContextEntry = errors.New("invalid context entry")
+
+// ContextEntryError is returned by WriteContext, UpdateContext, and
+// ReplaceTags for an entry they refuse, naming the field at fault. It
+// wraps ErrInvalidContextEntry.
+type ContextEntryError struct {
+	Field  string // "slug", "type", "content", "tags", "metadata", or "attachments"
+	Reason string
+}
+
+func (e *ContextEntryError) Error() string {
+	return fmt.Sprintf("invalid context entry: %s %s", e.Field, e.Reason)
+}
+
+func (e *ContextEntryError) Unwrap() error {
+	return ErrInvalidContextEntry
+}
+
+// ContextSchema constrains the content of one type of an agent's entries.
+type ContextSchema struct {
+	Sections []string                   // headings the content must have, at any level, in any order
+	Validate func(content string) error // further checks; its error is the reason the entry is refused
+}
+
+var (
+	// contextSlugPattern keeps slugs to a safe file name.
+	contextSlugPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+	// contextTagPattern keeps tags lowercase and free of the characters
+	// frontmatter lists and searches split on.
+	contextTagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._:/-]{0,63}$`)
+)
+
+// contextTypes are the entry types the store knows.
+var contextTypes = []ContextType{ContextFinding, ContextDecision, ContextArtifact, ContextReference, ContextSummary}
+
+func validateContextType(t ContextType) error {
+	if !slices.Contains(contextTypes, t) {
+		return &ContextEntryError{Field: "type", Reason: fmt.Sprintf("%q is not one of finding, decision, artifact, reference, or summary", t)}
+	}
+	return nil
+}
+
+func validateContextTags(tags []string) error {
+	for _, tag := range tags {
+		if !contextTagPattern.MatchString(tag) {
+			return &ContextEntryError{Field: "tags", Reason: fmt.Sprintf("%q must be lowercase letters, digits, and . _ : / -, at most 64 characters", tag)}
+		}
+	}
+	return nil
+}
+
+// validateContextContent checks content against the schema for its type,
+// if schemas has one.
+func validateContextContent(t ContextType, content string, schemas map[ContextType]ContextSchema) error {
+	if strings.TrimSpace(content) == "" {
+		return &ContextEntryError{Field: "content", Reason: "is empty"}
+	}
+	schema, ok := schemas[t]
+	if !ok {
+		return nil
+	}
+	for _, section := range schema.Sections {
+		if !hasMarkdownHeading(content, section) {
+			return &ContextEntryError{Field: "content", Reason: fmt.Sprintf("has no %q section, which %s entries need", section, t)}
+		}
+	}
+	if schema.Validate != nil {
+		if err := schema.Validate(content); err != nil {
+			return &ContextEntryError{Field: "content", Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+// hasMarkdownHeading reports whether content has a heading, at any level,
+// whose text is title, ignoring case.
+func hasMarkdownHeading(content, title string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		text, ok := strings.CutPrefix(strings.TrimLeft(line, "#"), " ")
+		if ok && strings.HasPrefix(line, "#") && strings.EqualFold(strings.TrimSpace(text), title) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateContextEntry checks an entry before it is written.
+func validateContextEntry(entry ContextEntry, schemas map[ContextType]ContextSchema) error {
+	if !contextSlugPattern.MatchString(entry.Slug) {
+		return &ContextEntryError{Field: "slug", Reason: fmt.Sprintf("%q must be letters, digits, and . _ -, starting with a letter or digit, at most 100 characters", entry.Slug)}
+	}
+	if err := validateContextType(entry.Type); err != nil {
+		return err
+	}
+	if err := validateContextTags(entry.Tags); err != nil {
+		return err
+	}
+	if err := validateContextMetadata(entry.Metadata); err != nil {
+		return err
+	}
+	if err := validateContextAttachments(entry.Attachments); err != nil {
+		return err
+	}
+	return validateContextContent(entry.Type, entry.Content, schemas)
+}
+
+// validateContextUpdate checks the type, tags, and metadata an update sets.
+// Schemas apply only as entries are written.
+func validateContextUpdate(entry ContextEntry) error {
+	if entry.Type != "" {
+		if err := validateContextType(entry.Type); err != nil {
+			return err
+		}
+	}
+	if err := validateContextTags(entry.Tags); err != nil {
+		return err
+	}
+	return validateContextMetadata(entry.Metadata)
+}
+
+// validateContextMetadata refuses metadata under the keys the store sets,
+// and values that cannot be written as YAML.
+func validateContextMetadata(metadata map[string]any) error {
+	for key, v := range metadata {
+		if key == "" || slices.Contains(contextFrontmatterKeys, key) {
+			return &ContextEntryError{Field: "metadata", Reason: fmt.Sprintf("key %q must not be empty nor one of %s", key, strings.Join(contextFrontmatterKeys, ", "))}
+		}
+		if _, err := json.Marshal(v); err != nil {
+			return &ContextEntryError{Field: "metadata", Reason: fmt.Sprintf("%q must be JSON-like: %v", key, err)}
+		}
+	}
+	return nil
+}
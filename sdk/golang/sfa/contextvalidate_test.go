@@ -0,0 +1,82 @@
+package sfa
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateContextEntry(t *testing.T) {
+	schemas := map[ContextType]ContextSchema{
+		ContextDecision: {
+			Sections: []string{"Context", "Decision"},
+			Validate: func(content string) error {
+				if !strings.Contains(content, "Status:") {
+					return errors.New("needs a Status: line")
+				}
+				return nil
+			},
+		},
+	}
+	decision := "## Context\n\nTokens leak.\n\n### decision\n\nRotate them.\n\nStatus: accepted"
+	tests := []struct {
+		name  string
+		entry ContextEntry
+		field string // "" when valid
+	}{
+		{"valid", ContextEntry{Type: ContextFinding, Slug: "auth-bug_2.v1", Tags: []string{"security", "cwe:79", "area/auth"}, Content: "x"}, ""},
+		{"schema met", ContextEntry{Type: ContextDecision, Slug: "rotate", Content: decision}, ""},
+		{"empty slug", ContextEntry{Type: ContextFinding, Content: "x"}, "slug"},
+		{"slug with a separator", ContextEntry{Type: ContextFinding, Slug: "../escape", Content: "x"}, "slug"},
+		{"slug with a space", ContextEntry{Type: ContextFinding, Slug: "auth bug", Content: "x"}, "slug"},
+		{"hidden slug", ContextEntry{Type: ContextFinding, Slug: ".index", Content: "x"}, "slug"},
+		{"slug too long", ContextEntry{Type: ContextFinding, Slug: strings.Repeat("a", 101), Content: "x"}, "slug"},
+		{"unknown type", ContextEntry{Type: "note", Slug: "a", Content: "x"}, "type"},
+		{"no type", ContextEntry{Slug: "a", Content: "x"}, "type"},
+		{"blank content", ContextEntry{Type: ContextFinding, Slug: "a", Content: " \n"}, "content"},
+		{"uppercase tag", ContextEntry{Type: ContextFinding, Slug: "a", Tags: []string{"Security"}, Content: "x"}, "tags"},
+		{"tag with a comma", ContextEntry{Type: ContextFinding, Slug: "a", Tags: []string{"a,b"}, Content: "x"}, "tags"},
+		{"missing section", ContextEntry{Type: ContextDecision, Slug: "a", Content: "## Context\n\nStatus: x"}, "content"},
+		{"schema's own check", ContextEntry{Type: ContextDecision, Slug: "a", Content: "## Context\n## Decision\n"}, "content"},
+	}
+	for _, tt := range tests {
+		err := validateContextEntry(tt.entry, schemas)
+		var ce *ContextEntryError
+		switch {
+		case tt.field == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.field != "" && (!errors.As(err, &ce) || ce.Field != tt.field || !errors.Is(err, ErrInvalidContextEntry)):
+			t.Errorf("%s: got %v, want an error for the %s", tt.name, err, tt.field)
+		}
+	}
+}
+
+func TestWriteContextValidates(t *testing.T) {
+	store := t.TempDir()
+	def := &AgentDef{Name: "notes", ContextSchema: map[ContextType]ContextSchema{ContextSummary: {Sections: []string{"Summary"}}}}
+	e := &executor{
+		def:          def,
+		resolved:     resolveEnv(nil, def.Name, map[string]any{}),
+		contextStore: &localContextStore{path: store},
+	}
+	ctx := e.executeContext(&execution{ctx: context.Background(), safety: &SafetyState{}}, "", nil)
+
+	if _, err := ctx.WriteContext(ContextEntry{Type: ContextSummary, Slug: "run", Content: "No heading."}); !errors.Is(err, ErrInvalidContextEntry) {
+		t.Errorf("expected the schema to refuse the entry, got %v", err)
+	}
+	path, err := ctx.WriteContext(ContextEntry{Type: ContextSummary, Slug: "run", Content: "# Summary\n\nStarted."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.ReplaceTags(path, []string{"Not OK"}); !errors.Is(err, ErrInvalidContextEntry) {
+		t.Errorf("expected ReplaceTags to refuse the tag, got %v", err)
+	}
+	if err := ctx.UpdateContext(path, ContextEntry{Type: "memo"}); !errors.Is(err, ErrInvalidContextEntry) {
+		t.Errorf("expected UpdateContext to refuse the type, got %v", err)
+	}
+	// Appends are not held to the schema
+	if err := ctx.AppendContext(path, "More."); err != nil {
+		t.Error(err)
+	}
+}
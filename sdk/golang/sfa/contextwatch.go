@@ -0,0 +1,61 @@
+package sfa
+
+import (
+	"context"
+	"time"
+)
+
+// contextWatchInterval is how often WatchContext searches the store for new
+// entries; a variable so tests can shorten it.
+var contextWatchInterval = time.Second
+
+// watchContext sends the entries matching query that are written to the
+// store from now until ctx is done, oldest first, then closes the channel.
+// The store is polled, so that local and remote stores are watched alike;
+// the query's sort, offset, and limit are ignored.
+func watchContext(ctx context.Context, store contextStore, query ContextQuery) (<-chan ContextResult, error) {
+	query.Sort, query.Offset, query.Limit = ContextSortNewest, 0, 0
+	// Timestamps have whole seconds, so entries of this second are searched
+	// again; those already there are not sent.
+	if since := time.Now().UTC().Truncate(time.Second); since.After(query.Since) {
+		query.Since = since
+	}
+	existing, err := store.search(query)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, r := range existing {
+		seen[r.FilePath] = true
+	}
+
+	out := make(chan ContextResult)
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(contextWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			results, err := store.search(query)
+			if err != nil {
+				continue
+			}
+			for i := len(results) - 1; i >= 0; i-- {
+				if seen[results[i].FilePath] {
+					continue
+				}
+				seen[results[i].FilePath] = true
+				select {
+				case out <- results[i]:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
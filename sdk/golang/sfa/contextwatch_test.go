@@ -0,0 +1,50 @@
+package sfa
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchContext(t *testing.T) {
+	old := contextWatchInterval
+	contextWatchInterval = 10 * time.Millisecond
+	t.Cleanup(func() { contextWatchInterval = old })
+
+	store := &localContextStore{path: t.TempDir()}
+	if _, err := store.write(ContextEntry{Type: ContextFinding, Slug: "before", Content: "written before"}, "reviewer", "s1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results, err := watchContext(ctx, store, ContextQuery{Type: ContextFinding, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.write(ContextEntry{Type: ContextDecision, Slug: "other", Content: "not a finding"}, "planner", "s1")
+	for _, slug := range []string{"first", "second"} {
+		if _, err := store.write(ContextEntry{Type: ContextFinding, Slug: slug, Content: slug}, "reviewer", "s1"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case r := <-results:
+			if got := strings.TrimSpace(r.Content); got != slug {
+				t.Errorf("watch sent %q, want %q", got, slug)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("watch did not send %s", slug)
+		}
+	}
+
+	cancel()
+	select {
+	case r, ok := <-results:
+		if ok {
+			t.Errorf("watch sent %+v after cancel", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("channel not closed after cancel")
+	}
+}
@@ -0,0 +1,216 @@
+package sfa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrBudgetExceeded is returned by RecordCost once a budget cap has been hit.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// costTracker accumulates costs recorded by the agent and its subagents and
+// enforces the caps received via SFA_BUDGET.
+type costTracker struct {
+	mu       sync.Mutex
+	totals   map[string]float64
+	budget   map[string]float64
+	exceeded error
+	cancel   context.CancelFunc
+}
+
+// newCostTracker creates a tracker enforcing the given SFA_BUDGET value.
+// An empty value means no caps.
+func newCostTracker(budgetStr string) (*costTracker, error) {
+	budget, err := parseCosts(budgetStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SFA_BUDGET: %w", err)
+	}
+	return &costTracker{
+		totals: make(map[string]float64),
+		budget: budget,
+	}, nil
+}
+
+// record adds amount to the given unit's total. Once any capped unit exceeds
+// its budget, the tracker cancels the execution context and returns
+// ErrBudgetExceeded for this and every later call.
+func (t *costTracker) record(units string, amount float64) error {
+	if t == nil {
+		return nil
+	}
+	if units == "" {
+		return fmt.Errorf("cost units must not be empty")
+	}
+	if amount < 0 {
+		return fmt.Errorf("cost amount must not be negative")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.totals[units] += amount
+	return t.checkLocked(units)
+}
+
+// add merges totals reported by a subagent.
+func (t *costTracker) add(totals map[string]float64) error {
+	if t == nil || len(totals) == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for units, amount := range totals {
+		t.totals[units] += amount
+	}
+	for units := range totals {
+		if err := t.checkLocked(units); err != nil {
+			return err
+		}
+	}
+	return t.exceeded
+}
+
+// checkLocked compares a unit's total against its cap. Caller holds t.mu.
+func (t *costTracker) checkLocked(units string) error {
+	if t.exceeded != nil {
+		return t.exceeded
+	}
+	limit, ok := t.budget[units]
+	if !ok || t.totals[units] <= limit {
+		return nil
+	}
+	t.exceeded = fmt.Errorf("%w: %s %s exceeds cap %s", ErrBudgetExceeded,
+		units, formatAmount(t.totals[units]), formatAmount(limit))
+	if t.cancel != nil {
+		t.cancel()
+	}
+	return t.exceeded
+}
+
+// exceededErr returns the budget-exceeded error, or nil if all caps are respected.
+func (t *costTracker) exceededErr() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.exceeded
+}
+
+// snapshot returns a copy of the accumulated costs.
+func (t *costTracker) snapshot() map[string]float64 {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.totals) == 0 {
+		return nil
+	}
+	out := make(map[string]float64, len(t.totals))
+	for k, v := range t.totals {
+		out[k] = v
+	}
+	return out
+}
+
+// remaining returns the budget left for each capped unit, for propagation
+// to subagents via SFA_BUDGET.
+func (t *costTracker) remaining() map[string]float64 {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.budget) == 0 {
+		return nil
+	}
+	out := make(map[string]float64, len(t.budget))
+	for units, limit := range t.budget {
+		left := limit - t.totals[units]
+		if left < 0 {
+			left = 0
+		}
+		out[units] = left
+	}
+	return out
+}
+
+// writeReport writes the accumulated totals to SFA_COST_FILE, if set, so the
+// parent agent can fold them into its own totals.
+func (t *costTracker) writeReport() {
+	path := os.Getenv("SFA_COST_FILE")
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(t.snapshot())
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to write cost report: %v", err))
+	}
+}
+
+// readCostReport reads totals written by a subagent's writeReport.
+func readCostReport(path string) map[string]float64 {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var totals map[string]float64
+	if err := json.Unmarshal(data, &totals); err != nil {
+		return nil
+	}
+	return totals
+}
+
+// parseCosts parses a "units=amount,units=amount" list (e.g. "usd=5,tokens=100000").
+func parseCosts(s string) (map[string]float64, error) {
+	costs := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("expected units=amount, got %q", pair)
+		}
+		amount, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || amount < 0 {
+			return nil, fmt.Errorf("invalid amount in %q", pair)
+		}
+		costs[strings.TrimSpace(parts[0])] = amount
+	}
+	return costs, nil
+}
+
+// formatCosts renders costs in the SFA_BUDGET format with units sorted.
+func formatCosts(costs map[string]float64) string {
+	units := make([]string, 0, len(costs))
+	for u := range costs {
+		units = append(units, u)
+	}
+	sort.Strings(units)
+
+	parts := make([]string, 0, len(units))
+	for _, u := range units {
+		parts = append(parts, fmt.Sprintf("%s=%s", u, formatAmount(costs[u])))
+	}
+	return strings.Join(parts, ",")
+}
+
+// formatAmount renders a cost amount without trailing zeros.
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
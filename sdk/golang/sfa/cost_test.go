@@ -0,0 +1,112 @@
+package sfa
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCosts(t *testing.T) {
+	costs, err := parseCosts("usd=5.50, tokens=100000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if costs["usd"] != 5.5 {
+		t.Errorf("expected usd 5.5, got %v", costs["usd"])
+	}
+	if costs["tokens"] != 100000 {
+		t.Errorf("expected tokens 100000, got %v", costs["tokens"])
+	}
+}
+
+func TestParseCostsInvalid(t *testing.T) {
+	for _, s := range []string{"usd", "usd=abc", "=5", "usd=-1"} {
+		if _, err := parseCosts(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestFormatCosts(t *testing.T) {
+	got := formatCosts(map[string]float64{"usd": 1.25, "tokens": 300})
+	if got != "tokens=300,usd=1.25" {
+		t.Errorf("expected tokens=300,usd=1.25, got %q", got)
+	}
+}
+
+func TestCostTrackerRecord(t *testing.T) {
+	costs, _ := newCostTracker("")
+	if err := costs.record("tokens", 100); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := costs.record("tokens", 50); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := costs.snapshot()["tokens"]; got != 150 {
+		t.Errorf("expected 150 tokens, got %v", got)
+	}
+}
+
+func TestCostTrackerBudgetExceeded(t *testing.T) {
+	costs, _ := newCostTracker("usd=1")
+	cancelled := false
+	costs.cancel = func() { cancelled = true }
+
+	if err := costs.record("usd", 0.75); err != nil {
+		t.Fatalf("unexpected error under budget: %v", err)
+	}
+	err := costs.record("usd", 0.5)
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded, got %v", err)
+	}
+	if !cancelled {
+		t.Error("expected context to be cancelled")
+	}
+	if costs.exceededErr() == nil {
+		t.Error("expected exceeded state to persist")
+	}
+	// Once exceeded, every later record fails
+	if err := costs.record("tokens", 1e9); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected sticky budget error, got %v", err)
+	}
+}
+
+func TestCostTrackerRemaining(t *testing.T) {
+	costs, _ := newCostTracker("usd=2,tokens=1000")
+	costs.record("usd", 0.5)
+	costs.add(map[string]float64{"tokens": 400})
+
+	remaining := costs.remaining()
+	if remaining["usd"] != 1.5 {
+		t.Errorf("expected 1.5 usd remaining, got %v", remaining["usd"])
+	}
+	if remaining["tokens"] != 600 {
+		t.Errorf("expected 600 tokens remaining, got %v", remaining["tokens"])
+	}
+}
+
+func TestCostReportRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cost.json")
+	os.Setenv("SFA_COST_FILE", path)
+	defer os.Unsetenv("SFA_COST_FILE")
+
+	costs, _ := newCostTracker("")
+	costs.record("usd", 0.42)
+	costs.writeReport()
+
+	totals := readCostReport(path)
+	if totals["usd"] != 0.42 {
+		t.Errorf("expected 0.42 usd, got %v", totals["usd"])
+	}
+}
+
+func TestNilCostTracker(t *testing.T) {
+	var costs *costTracker
+	if err := costs.record("usd", 1); err != nil {
+		t.Errorf("nil tracker should accept costs, got %v", err)
+	}
+	if costs.remaining() != nil || costs.snapshot() != nil {
+		t.Error("nil tracker should report nothing")
+	}
+}
@@ -0,0 +1,136 @@
+package sfa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// diagnosticsLogLines is how many log lines a diagnostics bundle keeps per
+// service, and diagnosticsKept how many bundles an agent keeps.
+const (
+	diagnosticsLogLines = 200
+	diagnosticsKept     = 5
+)
+
+// redactInspectEnv replaces the values in the Config.Env of docker inspect
+// output with "<redacted>", keeping the names, so a bundle can be shared
+// without the passwords services are configured with.
+func redactInspectEnv(out []byte) []byte {
+	var containers []map[string]any
+	if err := json.Unmarshal(out, &containers); err != nil {
+		return out
+	}
+	for _, c := range containers {
+		config, _ := c["Config"].(map[string]any)
+		env, _ := config["Env"].([]any)
+		for i, e := range env {
+			if s, ok := e.(string); ok {
+				name, _, _ := strings.Cut(s, "=")
+				env[i] = name + "=<redacted>"
+			}
+		}
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(containers); err != nil {
+		return out
+	}
+	return b.Bytes()
+}
+
+// inspectHealth returns the State.Health of docker inspect output, the
+// healthcheck's status and its recent probes, or nil without one.
+func inspectHealth(out []byte) []byte {
+	var containers []struct {
+		State struct {
+			Health json.RawMessage
+		}
+	}
+	if json.Unmarshal(out, &containers) != nil || len(containers) == 0 || len(containers[0].State.Health) == 0 || string(containers[0].State.Health) == "null" {
+		return nil
+	}
+	var health any
+	json.Unmarshal(containers[0].State.Health, &health)
+	data, _ := json.MarshalIndent(health, "", "  ")
+	return data
+}
+
+// writeServiceDiagnostics saves what is needed to debug services that did
+// not become healthy to a timestamped directory under the agent's services
+// directory, and returns its path: the compose file, compose ps, and for
+// each service its inspect output with environment values redacted, its
+// healthcheck history, and its last log lines. Older bundles beyond
+// diagnosticsKept are removed.
+func writeServiceDiagnostics(engine containerEngine, composePath string, services map[string]ServiceDef) (string, error) {
+	root := filepath.Join(filepath.Dir(composePath), "diagnostics")
+	dir := filepath.Join(root, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create diagnostics directory: %w", err)
+	}
+	write := func(name string, data []byte) {
+		if len(data) > 0 {
+			os.WriteFile(filepath.Join(dir, name), data, 0600)
+		}
+	}
+
+	if data, err := os.ReadFile(composePath); err == nil {
+		write("compose.yaml", data)
+	}
+	ps, _ := engine.composeCommand(composePath, "ps", "-a").CombinedOutput()
+	write("ps.txt", ps)
+
+	out, _ := engine.composeCommand(composePath, "ps", "-a", "--format", "{{.Service}}\t{{.ID}}\t{{.Name}}").Output()
+	containers := parseServiceContainers(string(out))
+	for _, name := range sortedKeys(services) {
+		if id := containers[name][0]; id != "" {
+			if inspect, err := engine.command("inspect", id).Output(); err == nil {
+				write(name+".inspect.json", redactInspectEnv(inspect))
+				write(name+".health.json", inspectHealth(inspect))
+			}
+		}
+		logs, _ := engine.composeCommand(composePath, "logs", "--no-color", "--tail", fmt.Sprint(diagnosticsLogLines), name).CombinedOutput()
+		write(name+".log", logs)
+	}
+
+	pruneDiagnostics(root, diagnosticsKept)
+	return dir, nil
+}
+
+// pruneDiagnostics removes all but the newest keep bundles under root.
+// Bundle names are timestamps, so they sort oldest first.
+func pruneDiagnostics(root string, keep int) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	var bundles []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			bundles = append(bundles, entry.Name())
+		}
+	}
+	sort.Strings(bundles)
+	for len(bundles) > keep {
+		os.RemoveAll(filepath.Join(root, bundles[0]))
+		bundles = bundles[1:]
+	}
+}
+
+// withServiceDiagnostics saves a diagnostics bundle for services that did
+// not become healthy and adds its path to err.
+func withServiceDiagnostics(err error, engine containerEngine, composePath string, services map[string]ServiceDef) error {
+	dir, derr := writeServiceDiagnostics(engine, composePath, services)
+	if derr != nil {
+		stderrLog.Warn(derr.Error())
+		return err
+	}
+	return fmt.Errorf("%w\ndiagnostics saved to %s", err, dir)
+}
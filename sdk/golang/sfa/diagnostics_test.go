@@ -0,0 +1,49 @@
+package sfa
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const inspectOutput = `[{"Id":"3f2a9c","Config":{"Image":"postgres:16","Env":["POSTGRES_PASSWORD=hunter2","PATH=/usr/bin"]},"State":{"Status":"running","Health":{"Status":"unhealthy","FailingStreak":3,"Log":[{"ExitCode":1,"Output":"no response"}]}}}]`
+
+func TestRedactInspectEnv(t *testing.T) {
+	out := string(redactInspectEnv([]byte(inspectOutput)))
+	if strings.Contains(out, "hunter2") || !strings.Contains(out, `"POSTGRES_PASSWORD=<redacted>"`) {
+		t.Errorf("expected the password to be redacted:\n%s", out)
+	}
+	if !strings.Contains(out, `"Image": "postgres:16"`) {
+		t.Errorf("expected the rest of the output to be kept:\n%s", out)
+	}
+	if got := string(redactInspectEnv([]byte("not json"))); got != "not json" {
+		t.Errorf("expected unparseable output unchanged, got %q", got)
+	}
+}
+
+func TestInspectHealth(t *testing.T) {
+	health := string(inspectHealth([]byte(inspectOutput)))
+	if !strings.Contains(health, `"Status": "unhealthy"`) || !strings.Contains(health, `"Output": "no response"`) {
+		t.Errorf("health = %s", health)
+	}
+	if got := inspectHealth([]byte(`[{"State":{"Status":"running"}}]`)); got != nil {
+		t.Errorf("expected no health without a healthcheck, got %s", got)
+	}
+}
+
+func TestPruneDiagnostics(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"20260501T120000Z", "20260501T130000Z", "20260502T090000Z"} {
+		os.MkdirAll(filepath.Join(root, name), 0700)
+	}
+	pruneDiagnostics(root, 2)
+	entries, _ := os.ReadDir(root)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, ",") != "20260501T130000Z,20260502T090000Z" {
+		t.Errorf("kept %v, want the two newest", names)
+	}
+}
@@ -0,0 +1,162 @@
+package sfa
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// dotenvFiles are loaded from the working directory in order, later files
+// overriding earlier ones, unless SFA_ENV_FILE names a single file instead.
+var dotenvFiles = []string{".env", ".env.local"}
+
+// secretNameMarkers identify .env variables masked even when no EnvDef
+// declares them secret.
+var secretNameMarkers = []string{"KEY", "TOKEN", "SECRET", "PASSWORD", "PASSWD", "CREDENTIAL", "PRIVATE"}
+
+// loadDotenv reads the project's .env files (or SFA_ENV_FILE). Missing
+// default files are skipped; a missing SFA_ENV_FILE or a malformed line is
+// reported as a warning and skipped.
+func loadDotenv() map[string]string {
+	files := dotenvFiles
+	explicit := os.Getenv("SFA_ENV_FILE")
+	if explicit != "" {
+		files = []string{explicit}
+	}
+
+	values := make(map[string]string)
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if explicit != "" || !os.IsNotExist(err) {
+				stderrLog.Warn(fmt.Sprintf("cannot read env file: %v", err))
+			}
+			continue
+		}
+		parsed, errs := parseDotenv(string(data))
+		for _, e := range errs {
+			stderrLog.Warn(fmt.Sprintf("%s: %v", path, e))
+		}
+		for k, v := range parsed {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+// parseDotenv parses KEY=VALUE lines with optional "export " prefixes,
+// # comments, and single- or double-quoted values. Double quotes support
+// \n, \t, \", and \\ escapes and may span lines; single quotes are literal.
+// Values are not expanded.
+func parseDotenv(data string) (map[string]string, []error) {
+	values := make(map[string]string)
+	var errs []error
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, rest, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validEnvName(key) {
+			errs = append(errs, fmt.Errorf("line %d: expected KEY=VALUE", lineNo))
+			continue
+		}
+		rest = strings.TrimLeft(rest, " \t")
+
+		switch {
+		case strings.HasPrefix(rest, `"`):
+			// Gather lines until the closing quote
+			raw := rest[1:]
+			end := closingQuote(raw)
+			for end < 0 && i+1 < len(lines) {
+				i++
+				raw += "\n" + lines[i]
+				end = closingQuote(raw)
+			}
+			if end < 0 {
+				errs = append(errs, fmt.Errorf("line %d: unterminated double quote", lineNo))
+				continue
+			}
+			values[key] = unescapeDotenv(raw[:end])
+		case strings.HasPrefix(rest, "'"):
+			end := strings.Index(rest[1:], "'")
+			if end < 0 {
+				errs = append(errs, fmt.Errorf("line %d: unterminated single quote", lineNo))
+				continue
+			}
+			values[key] = rest[1 : end+1]
+		default:
+			// An unquoted value ends at a " #" comment
+			if idx := strings.Index(rest, " #"); idx >= 0 {
+				rest = rest[:idx]
+			}
+			values[key] = strings.TrimSpace(rest)
+		}
+	}
+	return values, errs
+}
+
+// closingQuote returns the index of the first unescaped double quote in s,
+// or -1.
+func closingQuote(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDotenv(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		default: // \" \\ and anything else: the character itself
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// looksSecret reports whether a variable name suggests a credential.
+func looksSecret(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, marker := range secretNameMarkers {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package sfa
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	values, errs := parseDotenv(`# comment
+PLAIN=value
+export EXPORTED=yes
+SPACED = padded value  # trailing comment
+HASH=a#b
+SINGLE='literal \n $HOME'
+DOUBLE="line1\nline2 \"quoted\""
+MULTI="first
+second"
+EMPTY=
+not a pair
+1BAD=x
+UNTERMINATED='oops
+`)
+	want := map[string]string{
+		"PLAIN":    "value",
+		"EXPORTED": "yes",
+		"SPACED":   "padded value",
+		"HASH":     "a#b",
+		"SINGLE":   `literal \n $HOME`,
+		"DOUBLE":   "line1\nline2 \"quoted\"",
+		"MULTI":    "first\nsecond",
+		"EMPTY":    "",
+	}
+	for k, v := range want {
+		if values[k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, values[k])
+		}
+	}
+	if len(values) != len(want) {
+		t.Errorf("unexpected extra values: %v", values)
+	}
+	if len(errs) != 3 {
+		t.Errorf("expected 3 errors for malformed lines, got %v", errs)
+	}
+}
+
+func TestResolveEnvDotenv(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(filepath.Join(dir, ".env"), "DE_MODEL=from-dotenv\nDE_API_KEY=sk-dotenv\nDE_EXTRA_TOKEN=tok\nDE_REGION=us\n")
+	writeTestFile(filepath.Join(dir, ".env.local"), "DE_REGION=eu\n")
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	os.Setenv("DE_MODEL", "from-process")
+	defer os.Unsetenv("DE_MODEL")
+
+	decls := []EnvDef{{Name: "DE_MODEL"}, {Name: "DE_API_KEY"}, {Name: "DE_REGION"}}
+	config := map[string]any{
+		"agents": map[string]any{"dotenv-agent": map[string]any{"env": map[string]any{"DE_REGION": "from-config"}}},
+	}
+	resolved := resolveEnv(decls, "dotenv-agent", config)
+
+	if resolved.Values["DE_MODEL"] != "from-process" {
+		t.Errorf("expected process env to beat .env, got %q", resolved.Values["DE_MODEL"])
+	}
+	if resolved.Values["DE_REGION"] != "eu" || resolved.Sources["DE_REGION"] != envSourceDotenv {
+		t.Errorf("expected .env.local to beat .env and config, got %q from %q", resolved.Values["DE_REGION"], resolved.Sources["DE_REGION"])
+	}
+	if !resolved.Secrets["DE_API_KEY"] || resolved.Secrets["DE_REGION"] {
+		t.Errorf("expected only secret-looking names masked: %v", resolved.Secrets)
+	}
+	if resolved.Values["DE_EXTRA_TOKEN"] != "tok" || !resolved.Secrets["DE_EXTRA_TOKEN"] {
+		t.Errorf("expected undeclared .env vars resolved and masked, got %v", resolved.Values)
+	}
+	if masked := maskSecrets("key=sk-dotenv", resolved); masked != "key=***" {
+		t.Errorf("expected .env secret masked, got %q", masked)
+	}
+}
+
+func TestResolveEnvDotenvOverride(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "custom.env")
+	writeTestFile(path, "DO_VALUE=custom\n")
+	t.Setenv("SFA_ENV_FILE", path)
+
+	resolved := resolveEnv([]EnvDef{{Name: "DO_VALUE"}}, "dotenv-agent", map[string]any{})
+	if resolved.Values["DO_VALUE"] != "custom" {
+		t.Errorf("expected SFA_ENV_FILE to be loaded, got %q", resolved.Values["DO_VALUE"])
+	}
+
+	t.Setenv("SFA_ENV_FILE", filepath.Join(dir, "missing.env"))
+	stderr := captureStderr(t, func() {
+		resolveEnv(nil, "dotenv-agent", map[string]any{})
+	})
+	if !strings.Contains(stderr, "cannot read env file") {
+		t.Errorf("expected a warning for a missing SFA_ENV_FILE, got %q", stderr)
+	}
+}
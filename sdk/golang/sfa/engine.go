@@ -0,0 +1,239 @@
+package sfa
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Container engines services can run on.
+const (
+	EngineDocker = "docker"
+	EnginePodman = "podman"
+)
+
+// containerEngine runs service containers: the engine's own CLI for
+// networks and containers, and its compose command for projects.
+type containerEngine struct {
+	name    string   // EngineDocker or EnginePodman, also its binary
+	compose []string // compose command, e.g. docker compose or podman-compose
+	env     []string // KEY=value pointing the engine at a configured daemon
+}
+
+// command returns an engine CLI command, e.g. docker network ls.
+func (e containerEngine) command(args ...string) *exec.Cmd {
+	return e.withEnv(exec.Command(e.name, args...))
+}
+
+// composeCommand returns a compose command for the project in composePath.
+func (e containerEngine) composeCommand(composePath string, args ...string) *exec.Cmd {
+	argv := append(append(append([]string(nil), e.compose[1:]...), "-f", composePath), args...)
+	return e.withEnv(exec.Command(e.compose[0], argv...))
+}
+
+func (e containerEngine) withEnv(cmd *exec.Cmd) *exec.Cmd {
+	if len(e.env) > 0 {
+		cmd.Env = append(os.Environ(), e.env...)
+	}
+	return cmd
+}
+
+// getenv returns a variable as the engine's commands see it.
+func (e containerEngine) getenv(key string) string {
+	for i := len(e.env) - 1; i >= 0; i-- {
+		if v, ok := strings.CutPrefix(e.env[i], key+"="); ok {
+			return v
+		}
+	}
+	return os.Getenv(key)
+}
+
+// engineCandidates lists each engine's compose commands in preference order.
+var engineCandidates = map[string][][]string{
+	EngineDocker: {{"docker", "compose"}},
+	EnginePodman: {{"podman", "compose"}, {"podman-compose"}},
+}
+
+// engineRemoteVars names the variables that point each engine at a daemon
+// address and at a named context (Podman calls them connections).
+var engineRemoteVars = map[string]struct{ host, context string }{
+	EngineDocker: {"DOCKER_HOST", "DOCKER_CONTEXT"},
+	EnginePodman: {"CONTAINER_HOST", "CONTAINER_CONNECTION"},
+}
+
+// commandWorks reports whether argv runs successfully with env added to the
+// environment; a variable so tests can stand in for installed engines.
+var commandWorks = func(env []string, argv ...string) bool {
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return false
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd.Run() == nil
+}
+
+// containerEngineSetting returns the engine to use and where it was chosen:
+// SFA_CONTAINER_ENGINE > config services.engine > "" to detect one.
+func containerEngineSetting(config map[string]any) (string, string) {
+	if name := os.Getenv("SFA_CONTAINER_ENGINE"); name != "" {
+		return name, "SFA_CONTAINER_ENGINE"
+	}
+	if services, ok := config["services"].(map[string]any); ok {
+		if name, ok := services["engine"].(string); ok && name != "" {
+			return name, "services.engine"
+		}
+	}
+	return "", ""
+}
+
+// engineRemoteSetting returns the daemon address or context agentName's
+// services run on: services.host or services.context from the agent's
+// namespace (or defaults), else from the top-level services section. Both
+// are "" to leave the engine's own environment and current context alone.
+func engineRemoteSetting(config map[string]any, agentName string) (host, context string, err error) {
+	for _, section := range []any{mergeConfig(config, agentName)["services"], config["services"]} {
+		s, _ := section.(map[string]any)
+		host, _ = s["host"].(string)
+		context, _ = s["context"].(string)
+		switch {
+		case host != "" && context != "":
+			return "", "", fmt.Errorf("services.host and services.context are both set; use one")
+		case host != "" || context != "":
+			return host, context, nil
+		}
+	}
+	return "", "", nil
+}
+
+// remoteEngineEnv returns the variables pointing engine at host or context.
+// The other variable is cleared so an inherited one cannot override it.
+func remoteEngineEnv(engine, host, context string) []string {
+	if host == "" && context == "" {
+		return nil
+	}
+	vars := engineRemoteVars[engine]
+	return []string{vars.host + "=" + host, vars.context + "=" + context}
+}
+
+// resolveContainerEngine returns the configured engine, or with none
+// configured, Docker if it is available and Podman otherwise, pointed at
+// agentName's configured daemon or context.
+func resolveContainerEngine(config map[string]any, agentName string) (containerEngine, error) {
+	name, source := containerEngineSetting(config)
+	candidates := []string{EngineDocker, EnginePodman}
+	if name != "" {
+		if _, ok := engineCandidates[name]; !ok {
+			return containerEngine{}, fmt.Errorf("%s: unknown container engine %q (use %s or %s)", source, name, EngineDocker, EnginePodman)
+		}
+		candidates = []string{name}
+	}
+	host, context, err := engineRemoteSetting(config, agentName)
+	if err != nil {
+		return containerEngine{}, err
+	}
+
+	withoutCompose := ""
+	for _, engine := range candidates {
+		env := remoteEngineEnv(engine, host, context)
+		if !commandWorks(env, engine, "version") {
+			continue
+		}
+		for _, compose := range engineCandidates[engine] {
+			if commandWorks(env, append(append([]string(nil), compose...), "version")...) {
+				return containerEngine{name: engine, compose: compose, env: env}, nil
+			}
+		}
+		if withoutCompose == "" {
+			withoutCompose = engine
+		}
+	}
+
+	switch {
+	case withoutCompose != "":
+		return containerEngine{}, fmt.Errorf("%s Compose is not available. Install it to use service dependencies", engineTitle(withoutCompose))
+	case host != "":
+		return containerEngine{}, fmt.Errorf("cannot reach the container engine at %s. Check that it is running and reachable from here", host)
+	case context != "":
+		return containerEngine{}, fmt.Errorf("cannot reach the container engine for context %s. Check that the context exists and its daemon is reachable", context)
+	case name != "":
+		return containerEngine{}, fmt.Errorf("%s is not installed or not running. Install it, or unset %s, to use service dependencies", engineTitle(name), source)
+	default:
+		return containerEngine{}, fmt.Errorf("no container engine found. Install Docker or Podman to use service dependencies")
+	}
+}
+
+// engineTitle returns the engine's display name.
+func engineTitle(name string) string {
+	if name == EnginePodman {
+		return "Podman"
+	}
+	return "Docker"
+}
+
+// contextEndpoint returns the daemon address of an engine context, or of the
+// current one if context is ""; a variable so tests can stand in for the
+// engine.
+var contextEndpoint = func(e containerEngine, context string) (string, error) {
+	if e.name == EnginePodman {
+		out, err := e.command("system", "connection", "list", "--format", "{{.Name}}\t{{.URI}}\t{{.Default}}").Output()
+		if err != nil {
+			return "", err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			fields := strings.Split(line, "\t")
+			if len(fields) == 3 && (fields[0] == context || (context == "" && fields[2] == "true")) {
+				return fields[1], nil
+			}
+		}
+		return "", fmt.Errorf("no such connection")
+	}
+	args := []string{"context", "inspect", "--format", "{{.Endpoints.docker.Host}}"}
+	if context != "" {
+		args = append(args, context)
+	}
+	out, err := e.command(args...).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// serviceHost returns the host services' published ports are reached on:
+// the machine the engine's daemon runs on, from its host variable or its
+// context, or localhost for a local daemon.
+func serviceHost(e containerEngine) (string, error) {
+	vars := engineRemoteVars[e.name]
+	if host := e.getenv(vars.host); host != "" {
+		return endpointHost(host), nil
+	}
+	context := e.getenv(vars.context)
+	endpoint, err := contextEndpoint(e, context)
+	switch {
+	case err != nil && context != "":
+		return "", fmt.Errorf("failed to inspect %s context %s: %w", engineTitle(e.name), context, err)
+	case err != nil:
+		return "localhost", nil // no current context to inspect
+	}
+	return endpointHost(endpoint), nil
+}
+
+// endpointHost returns the machine a daemon address such as
+// tcp://10.0.0.5:2376 or ssh://me@devbox names, or localhost for a socket.
+func endpointHost(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" || u.Scheme == "unix" || u.Scheme == "npipe" {
+		return "localhost"
+	}
+	return u.Hostname()
+}
+
+// isLocalHost reports whether host is this machine.
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,125 @@
+package sfa
+
+import (
+	"strings"
+	"testing"
+)
+
+// useInstalledCommands makes commandWorks succeed only for the given
+// commands, joined by spaces.
+func useInstalledCommands(t *testing.T, installed ...string) {
+	t.Helper()
+	orig := commandWorks
+	t.Cleanup(func() { commandWorks = orig })
+	commandWorks = func(env []string, argv ...string) bool {
+		cmd := strings.Join(argv, " ")
+		for _, c := range installed {
+			if cmd == c {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestResolveContainerEngine(t *testing.T) {
+	t.Setenv("SFA_CONTAINER_ENGINE", "")
+
+	useInstalledCommands(t, "docker version", "docker compose version", "podman version", "podman-compose version")
+	engine, err := resolveContainerEngine(nil, "")
+	if err != nil || engine.name != EngineDocker {
+		t.Fatalf("expected docker to be preferred, got %+v, %v", engine, err)
+	}
+
+	config := map[string]any{"services": map[string]any{"engine": "podman"}}
+	engine, err = resolveContainerEngine(config, "")
+	if err != nil || strings.Join(engine.compose, " ") != "podman-compose" {
+		t.Errorf("expected podman-compose from config, got %+v, %v", engine, err)
+	}
+	cmd := engine.composeCommand("/x/compose.yaml", "up", "-d")
+	if got := strings.Join(cmd.Args, " "); got != "podman-compose -f /x/compose.yaml up -d" {
+		t.Errorf("compose command = %s", got)
+	}
+
+	t.Setenv("SFA_CONTAINER_ENGINE", "docker")
+	if engine, _ := resolveContainerEngine(config, ""); engine.name != EngineDocker {
+		t.Errorf("expected SFA_CONTAINER_ENGINE to override config, got %+v", engine)
+	}
+
+	t.Setenv("SFA_CONTAINER_ENGINE", "containerd")
+	if _, err := resolveContainerEngine(nil, ""); err == nil || !strings.Contains(err.Error(), "unknown container engine") {
+		t.Errorf("expected an unknown engine error, got %v", err)
+	}
+}
+
+func TestResolveContainerEngineFallback(t *testing.T) {
+	t.Setenv("SFA_CONTAINER_ENGINE", "")
+
+	useInstalledCommands(t, "podman version", "podman compose version")
+	engine, err := resolveContainerEngine(nil, "")
+	if err != nil || strings.Join(engine.compose, " ") != "podman compose" {
+		t.Errorf("expected a fallback to podman compose, got %+v, %v", engine, err)
+	}
+
+	useInstalledCommands(t, "docker version")
+	if _, err := resolveContainerEngine(nil, ""); err == nil || !strings.Contains(err.Error(), "Docker Compose is not available") {
+		t.Errorf("expected a missing compose error, got %v", err)
+	}
+
+	useInstalledCommands(t)
+	if _, err := resolveContainerEngine(nil, ""); err == nil || !strings.Contains(err.Error(), "Install Docker or Podman") {
+		t.Errorf("expected no engine found, got %v", err)
+	}
+	t.Setenv("SFA_CONTAINER_ENGINE", "podman")
+	if _, err := resolveContainerEngine(nil, ""); err == nil || !strings.Contains(err.Error(), "Podman is not installed") {
+		t.Errorf("expected podman not installed, got %v", err)
+	}
+}
+
+func TestResolveRemoteContainerEngine(t *testing.T) {
+	t.Setenv("SFA_CONTAINER_ENGINE", "")
+	useInstalledCommands(t, "docker version", "docker compose version")
+
+	config := map[string]any{
+		"services": map[string]any{"context": "devbox"},
+		"agents":   map[string]any{"reporter": map[string]any{"services": map[string]any{"host": "ssh://me@build.internal"}}},
+	}
+	engine, err := resolveContainerEngine(config, "reporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(engine.env, " "); got != "DOCKER_HOST=ssh://me@build.internal DOCKER_CONTEXT=" {
+		t.Errorf("reporter env = %s", got)
+	}
+	if host, err := serviceHost(engine); err != nil || host != "build.internal" {
+		t.Errorf("reporter host = %q, %v", host, err)
+	}
+
+	orig := contextEndpoint
+	t.Cleanup(func() { contextEndpoint = orig })
+	contextEndpoint = func(e containerEngine, context string) (string, error) {
+		if context == "devbox" {
+			return "tcp://10.0.0.5:2376", nil
+		}
+		return "unix:///var/run/docker.sock", nil
+	}
+	engine, err = resolveContainerEngine(config, "other")
+	if err != nil || strings.Join(engine.env, " ") != "DOCKER_HOST= DOCKER_CONTEXT=devbox" {
+		t.Fatalf("expected the top-level context, got %+v, %v", engine, err)
+	}
+	if host, err := serviceHost(engine); err != nil || host != "10.0.0.5" {
+		t.Errorf("context host = %q, %v", host, err)
+	}
+
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "")
+	engine, _ = resolveContainerEngine(nil, "other")
+	if host, err := serviceHost(engine); err != nil || host != "localhost" || engine.env != nil {
+		t.Errorf("expected a local engine, got %+v, %q, %v", engine, host, err)
+	}
+
+	config["services"] = map[string]any{"host": "tcp://a:2376", "context": "b"}
+	if _, err := resolveContainerEngine(config, "other"); err == nil {
+		t.Error("expected services.host and services.context together to be rejected")
+	}
+}
@@ -10,14 +10,29 @@ import (
 type ResolvedEnv struct {
 	Values  map[string]string
 	Secrets map[string]bool
+	Sources map[string]string // where each value came from, one of the envSource* constants
 }
 
+// Sources of resolved environment values, in precedence order.
+const (
+	envSourceProcess  = "environment"
+	envSourceDotenv   = ".env file"
+	envSourceKeyring  = "keyring" // only for EnvDefs with Source "keyring"
+	envSourceAgent    = "agent config"
+	envSourceDefaults = "shared defaults"
+	envSourceDef      = "definition default"
+)
+
 // resolveEnv resolves environment variables using the SFA precedence order:
-// process env > agent config namespace > shared config defaults > definition defaults.
+// process env > .env files > OS keychain (Source: "keyring" only) > agent
+// config namespace > shared config defaults > definition defaults. Variables
+// in .env files that no EnvDef declares are resolved too, so they reach the
+// process environment.
 func resolveEnv(declarations []EnvDef, agentName string, config map[string]any) *ResolvedEnv {
 	resolved := &ResolvedEnv{
 		Values:  make(map[string]string),
 		Secrets: make(map[string]bool),
+		Sources: make(map[string]string),
 	}
 
 	// Extract agent-specific env from config
@@ -52,28 +67,73 @@ func resolveEnv(declarations []EnvDef, agentName string, config map[string]any)
 		}
 	}
 
+	dotenv := loadDotenv()
+	declared := make(map[string]bool, len(declarations))
+
 	for _, decl := range declarations {
-		if decl.Secret {
+		declared[decl.Name] = true
+		if decl.Secret || decl.Source == envSourceKeyring {
 			resolved.Secrets[decl.Name] = true
 		}
 
-		// Precedence: process env > agent config > global defaults > definition default
+		// Precedence: process env > .env > keyring > agent config > global defaults > definition default
 		if val := os.Getenv(decl.Name); val != "" {
 			resolved.Values[decl.Name] = val
+			resolved.Sources[decl.Name] = envSourceProcess
 			continue
 		}
-		if val, ok := agentEnv[decl.Name]; ok {
+		if val := dotenv[decl.Name]; val != "" {
 			resolved.Values[decl.Name] = val
+			resolved.Sources[decl.Name] = envSourceDotenv
+			if looksSecret(decl.Name) {
+				resolved.Secrets[decl.Name] = true
+			}
 			continue
 		}
+		if decl.Source == envSourceKeyring {
+			val, ok, err := lookupKeyring(agentName, decl.Name)
+			if err != nil {
+				stderrLog.Warn(err.Error())
+			}
+			if ok {
+				resolved.Values[decl.Name] = val
+				resolved.Sources[decl.Name] = envSourceKeyring
+				continue
+			}
+		}
+		if val, ok := agentEnv[decl.Name]; ok {
+			plain, err := decryptConfigValue(agentName, decl.Name, val)
+			if err == nil {
+				resolved.Values[decl.Name] = plain
+				resolved.Sources[decl.Name] = envSourceAgent
+				if plain != val {
+					resolved.Secrets[decl.Name] = true
+				}
+				continue
+			}
+			stderrLog.Warn(err.Error())
+		}
 		if val, ok := globalEnv[decl.Name]; ok {
 			resolved.Values[decl.Name] = val
+			resolved.Sources[decl.Name] = envSourceDefaults
 			continue
 		}
 		if decl.Default != "" {
 			resolved.Values[decl.Name] = decl.Default
+			resolved.Sources[decl.Name] = envSourceDef
+			continue
+		}
+	}
+
+	for name, val := range dotenv {
+		if declared[name] || os.Getenv(name) != "" {
 			continue
 		}
+		resolved.Values[name] = val
+		resolved.Sources[name] = envSourceDotenv
+		if looksSecret(name) {
+			resolved.Secrets[name] = true
+		}
 	}
 
 	return resolved
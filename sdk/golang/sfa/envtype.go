@@ -0,0 +1,108 @@
+package sfa
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Env var types accepted in EnvDef.Type.
+const (
+	EnvTypeString  = "string"
+	EnvTypeURL     = "url"
+	EnvTypeInt     = "int"
+	EnvTypeBool    = "bool"
+	EnvTypeEnum    = "enum"
+	EnvTypePath    = "path"
+	EnvTypePattern = "pattern"
+)
+
+// checkEnvValue reports why val is not acceptable for decl, or nil. The
+// error names the variable but never includes the value, which may be a
+// secret.
+func checkEnvValue(decl EnvDef, val string) error {
+	switch decl.Type {
+	case "", EnvTypeString, EnvTypePattern:
+		// Pattern (below) is the only check
+	case EnvTypeURL:
+		u, err := url.Parse(val)
+		// A host or an absolute path (sqlite:///data.db); "host:port" alone parses as an opaque URL
+		if err != nil || u.Scheme == "" || (u.Host == "" && !strings.HasPrefix(u.Path, "/")) {
+			return fmt.Errorf("%s must be a valid URL (e.g. https://host/path)", decl.Name)
+		}
+	case EnvTypeInt:
+		if _, err := strconv.Atoi(strings.TrimSpace(val)); err != nil {
+			return fmt.Errorf("%s must be an integer", decl.Name)
+		}
+	case EnvTypeBool:
+		if _, ok := parseEnvBool(val); !ok {
+			return fmt.Errorf("%s must be a boolean (true/false, yes/no, on/off, 1/0)", decl.Name)
+		}
+	case EnvTypeEnum:
+		for _, allowed := range decl.Enum {
+			if val == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s must be one of: %s", decl.Name, strings.Join(decl.Enum, ", "))
+	case EnvTypePath:
+		if _, err := os.Stat(val); err != nil {
+			return fmt.Errorf("%s must be an existing path (%s not found)", decl.Name, val)
+		}
+	default:
+		return fmt.Errorf("%s has unknown type %q", decl.Name, decl.Type)
+	}
+
+	if decl.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + decl.Pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("%s has an invalid pattern: %v", decl.Name, err)
+		}
+		if !re.MatchString(val) {
+			return fmt.Errorf("%s must match the pattern %s", decl.Name, decl.Pattern)
+		}
+	}
+	return nil
+}
+
+// parseEnvBool accepts the common spellings of a boolean env value.
+func parseEnvBool(val string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "1", "t", "true", "yes", "y", "on":
+		return true, true
+	case "0", "f", "false", "no", "n", "off":
+		return false, true
+	}
+	return false, false
+}
+
+// invalidEnv checks every resolved value against its declared type and
+// returns one message per malformed variable. Missing values are left to
+// validateEnv.
+func invalidEnv(declarations []EnvDef, resolved *ResolvedEnv) []string {
+	var problems []string
+	for _, decl := range declarations {
+		val, ok := resolved.Values[decl.Name]
+		if !ok || val == "" {
+			continue
+		}
+		if err := checkEnvValue(decl, val); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// formatInvalidEnvError creates a user-friendly error message for malformed env vars.
+func formatInvalidEnvError(agentName string, problems []string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Invalid environment variables for %s:\n", agentName))
+	for _, p := range problems {
+		b.WriteString(fmt.Sprintf("  • %s\n", p))
+	}
+	b.WriteString(fmt.Sprintf("\nRun '%s --setup' to correct them, or fix the values in the environment.", agentName))
+	return b.String()
+}
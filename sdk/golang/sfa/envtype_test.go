@@ -0,0 +1,66 @@
+package sfa
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckEnvValue(t *testing.T) {
+	existing := t.TempDir()
+	cases := []struct {
+		decl  EnvDef
+		value string
+		want  string // substring of the error, or "" for valid
+	}{
+		{EnvDef{Name: "DATABASE_URL", Type: EnvTypeURL}, "postgres://db:5432/app", ""},
+		{EnvDef{Name: "DATABASE_URL", Type: EnvTypeURL}, "localhost:5432", "DATABASE_URL must be a valid URL"},
+		{EnvDef{Name: "DATABASE_URL", Type: EnvTypeURL}, "db/app", "must be a valid URL"},
+		{EnvDef{Name: "PORT", Type: EnvTypeInt}, "8080", ""},
+		{EnvDef{Name: "PORT", Type: EnvTypeInt}, "80a", "PORT must be an integer"},
+		{EnvDef{Name: "DEBUG", Type: EnvTypeBool}, "yes", ""},
+		{EnvDef{Name: "DEBUG", Type: EnvTypeBool}, "maybe", "DEBUG must be a boolean"},
+		{EnvDef{Name: "MODE", Type: EnvTypeEnum, Enum: []string{"fast", "slow"}}, "slow", ""},
+		{EnvDef{Name: "MODE", Type: EnvTypeEnum, Enum: []string{"fast", "slow"}}, "medium", "MODE must be one of: fast, slow"},
+		{EnvDef{Name: "DIR", Type: EnvTypePath}, existing, ""},
+		{EnvDef{Name: "DIR", Type: EnvTypePath}, filepath.Join(existing, "nope"), "DIR must be an existing path"},
+		{EnvDef{Name: "REGION", Type: EnvTypePattern, Pattern: `[a-z]{2}-[a-z]+-\d`}, "us-east-1", ""},
+		{EnvDef{Name: "REGION", Type: EnvTypePattern, Pattern: `[a-z]{2}-[a-z]+-\d`}, "us-east-1x", "REGION must match"},
+		{EnvDef{Name: "REGION", Pattern: `[a-z]+`}, "EAST", "REGION must match"},
+		{EnvDef{Name: "BAD", Pattern: `(`}, "x", "invalid pattern"},
+		{EnvDef{Name: "ODD", Type: "uuid"}, "x", `unknown type "uuid"`},
+	}
+	for _, c := range cases {
+		err := checkEnvValue(c.decl, c.value)
+		switch {
+		case c.want == "" && err != nil:
+			t.Errorf("%s=%q: unexpected error %v", c.decl.Name, c.value, err)
+		case c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)):
+			t.Errorf("%s=%q: expected error containing %q, got %v", c.decl.Name, c.value, c.want, err)
+		}
+	}
+}
+
+func TestInvalidEnvHidesSecretValues(t *testing.T) {
+	decls := []EnvDef{
+		{Name: "API_TOKEN", Secret: true, Pattern: `sk-[a-z0-9]+`},
+		{Name: "RETRIES", Type: EnvTypeInt},
+		{Name: "UNSET", Type: EnvTypeInt},
+	}
+	resolved := &ResolvedEnv{
+		Values:  map[string]string{"API_TOKEN": "hunter2", "RETRIES": "3"},
+		Secrets: map[string]bool{"API_TOKEN": true},
+	}
+
+	problems := invalidEnv(decls, resolved)
+	if len(problems) != 1 {
+		t.Fatalf("expected 1 problem, got %v", problems)
+	}
+	msg := formatInvalidEnvError("test-agent", problems)
+	if strings.Contains(msg, "hunter2") {
+		t.Errorf("secret value leaked into error: %s", msg)
+	}
+	if !strings.Contains(msg, "API_TOKEN") || !strings.Contains(msg, "--setup") {
+		t.Errorf("unexpected message: %s", msg)
+	}
+}
@@ -0,0 +1,94 @@
+package sfa
+
+import "errors"
+
+// Standard error codes reported in structured error diagnostics.
+const (
+	ErrCodeExecutionFailed  = "execution_failed"
+	ErrCodeInvalidUsage     = "invalid_usage"
+	ErrCodeTimeout          = "timeout"
+	ErrCodeInterrupted      = "interrupted"
+	ErrCodeBudgetExceeded   = "budget_exceeded"
+	ErrCodePermissionDenied = "permission_denied"
+	ErrCodeSandboxViolation = "sandbox_violation"
+)
+
+// AgentError is a structured failure. Execute may return one (or an error
+// wrapping one) to give orchestrators a stable code to branch on; other
+// errors are classified by the SDK. In JSON output mode it is emitted as the
+// result's "error" object.
+type AgentError struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	Retryable bool           `json:"retryable"`
+	ExitCode  int            `json:"-"` // agent-specific exit code (10+); 0 uses the SDK's choice
+	Err       error          `json:"-"` // underlying cause
+}
+
+func (e *AgentError) Error() string {
+	switch {
+	case e.Message != "":
+		return e.Message
+	case e.Err != nil:
+		return e.Err.Error()
+	}
+	return e.Code
+}
+
+func (e *AgentError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorDef declares an agent-specific error code, listed in --describe.
+type ErrorDef struct {
+	Code        string
+	Description string
+	Retryable   bool
+}
+
+// standardErrors are the codes the SDK itself may report.
+var standardErrors = []ErrorDef{
+	{Code: ErrCodeExecutionFailed, Description: "Execute returned an error"},
+	{Code: ErrCodeInvalidUsage, Description: "The request's input or options were invalid"},
+	{Code: ErrCodeTimeout, Description: "Execution exceeded its timeout", Retryable: true},
+	{Code: ErrCodeInterrupted, Description: "Terminated by SIGINT or SIGTERM", Retryable: true},
+	{Code: ErrCodeBudgetExceeded, Description: "A cost cap from SFA_BUDGET was exceeded"},
+	{Code: ErrCodePermissionDenied, Description: "A permission request was refused"},
+	{Code: ErrCodeSandboxViolation, Description: "The agent attempted an action its sandbox forbids"},
+}
+
+// toAgentError returns the structured form of err. An AgentError in err's
+// chain is used as-is, with missing fields filled from code and err;
+// anything else becomes an error with the given code.
+func toAgentError(err error, code string, retryable bool) *AgentError {
+	var ae *AgentError
+	if errors.As(err, &ae) {
+		out := *ae
+		if out.Code == "" {
+			out.Code = code
+		}
+		out.Message = ae.Error()
+		return &out
+	}
+
+	message := code
+	if err != nil {
+		message = err.Error()
+	}
+	return &AgentError{Code: code, Message: message, Retryable: retryable, Err: err}
+}
+
+// describeErrors lists the standard and agent-declared error codes for --describe.
+func describeErrors(defs []ErrorDef) []map[string]any {
+	all := append(append([]ErrorDef(nil), standardErrors...), defs...)
+	out := make([]map[string]any, 0, len(all))
+	for _, d := range all {
+		out = append(out, map[string]any{
+			"code":        d.Code,
+			"description": d.Description,
+			"retryable":   d.Retryable,
+		})
+	}
+	return out
+}
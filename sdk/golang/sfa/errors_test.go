@@ -0,0 +1,83 @@
+package sfa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestToAgentErrorClassifiesPlainErrors(t *testing.T) {
+	cause := errors.New("deadline hit")
+	ae := toAgentError(cause, ErrCodeTimeout, true)
+	if ae.Code != ErrCodeTimeout || ae.Message != "deadline hit" || !ae.Retryable {
+		t.Errorf("unexpected classification: %+v", ae)
+	}
+	if !errors.Is(ae, cause) {
+		t.Error("expected classified error to unwrap to its cause")
+	}
+}
+
+func TestToAgentErrorKeepsAgentCode(t *testing.T) {
+	agentErr := &AgentError{
+		Code:      "upstream_unavailable",
+		Details:   map[string]any{"service": "search"},
+		Retryable: true,
+		Err:       errors.New("503 from search"),
+	}
+	ae := toAgentError(fmt.Errorf("query failed: %w", agentErr), ErrCodeExecutionFailed, false)
+	if ae.Code != "upstream_unavailable" || !ae.Retryable || ae.Details["service"] != "search" {
+		t.Errorf("expected agent-supplied fields preserved, got %+v", ae)
+	}
+	if ae.Message != "503 from search" {
+		t.Errorf("expected message filled from cause, got %q", ae.Message)
+	}
+}
+
+func TestFormatResultJSONStructuredError(t *testing.T) {
+	failure := &AgentError{Code: ErrCodeTimeout, Message: "timed out", Retryable: true}
+	out := formatResult(AgentResult{}, failure, OutputJSON)
+
+	var got struct {
+		Result any            `json:"result"`
+		Error  map[string]any `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out, err)
+	}
+	if got.Error["code"] != "timeout" || got.Error["message"] != "timed out" || got.Error["retryable"] != true {
+		t.Errorf("unexpected error object: %v", got.Error)
+	}
+}
+
+func TestFormatResultJSONStringErrorIsStructured(t *testing.T) {
+	out := formatResult(AgentResult{Result: "partial", Error: "ran out of input"}, nil, OutputJSON)
+	if !strings.Contains(out, `"error":{"code":"execution_failed","message":"ran out of input","retryable":false}`) {
+		t.Errorf("expected structured error object, got %s", out)
+	}
+
+	out = formatResult(AgentResult{Result: "ok"}, nil, OutputJSON)
+	if strings.Contains(out, `"error"`) {
+		t.Errorf("expected no error field on success, got %s", out)
+	}
+}
+
+func TestDescribeListsErrorCodes(t *testing.T) {
+	def := &AgentDef{
+		Name:   "test-agent",
+		Errors: []ErrorDef{{Code: "quota_exhausted", Description: "API quota used up", Retryable: true}},
+	}
+	desc := generateDescribe(def, nil, nil)
+	codes := desc["errors"].([]map[string]any)
+
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		seen[c["code"].(string)] = true
+	}
+	for _, want := range []string{ErrCodeExecutionFailed, ErrCodeTimeout, "quota_exhausted"} {
+		if !seen[want] {
+			t.Errorf("expected %s in describe errors, got %v", want, codes)
+		}
+	}
+}
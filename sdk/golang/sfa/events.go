@@ -0,0 +1,138 @@
+package sfa
+
+import (
+	"bufio"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// servicePollInterval is how often waitForHealthy checks compose ps with
+// no engine events to go on, and serviceEventPollInterval the fallback
+// check while events are streaming, in case one is missed.
+const (
+	servicePollInterval      = 2 * time.Second
+	serviceEventPollInterval = 5 * time.Second
+)
+
+// serviceEvent is a container event for one of an agent's services.
+type serviceEvent struct {
+	Service  string
+	Action   string // e.g. "start", "die", or "health_status"
+	ExitCode int    // for die
+}
+
+// parseServiceEvent reads a line of engine events --format '{{json .}}':
+// Docker gives the action and labels under Action and Actor.Attributes,
+// Podman under Status and Attributes, and calls die "died".
+func parseServiceEvent(line string) (serviceEvent, bool) {
+	var raw struct {
+		Action string
+		Status string
+		Actor  struct {
+			Attributes map[string]string
+		}
+		Attributes        map[string]string
+		ContainerExitCode *int
+	}
+	if json.Unmarshal([]byte(line), &raw) != nil {
+		return serviceEvent{}, false
+	}
+	attrs := raw.Actor.Attributes
+	if attrs == nil {
+		attrs = raw.Attributes
+	}
+	action := raw.Action
+	if action == "" {
+		action = raw.Status
+	}
+	// Docker appends the result, e.g. "health_status: healthy"
+	action, _, _ = strings.Cut(action, ":")
+	if action == "died" {
+		action = "die"
+	}
+	ev := serviceEvent{Service: attrs["com.docker.compose.service"], Action: action}
+	if ev.Service == "" || ev.Action == "" {
+		return serviceEvent{}, false
+	}
+	if code, err := strconv.Atoi(attrs["exitCode"]); err == nil {
+		ev.ExitCode = code
+	} else if raw.ContainerExitCode != nil {
+		ev.ExitCode = *raw.ContainerExitCode
+	}
+	return ev, true
+}
+
+// watchServiceEvents streams the container events of an agent's services
+// until stop is called. A nil channel means the engine cannot stream
+// events and the caller should poll. A variable so tests can stand in for
+// the engine.
+var watchServiceEvents = func(engine containerEngine, agentName string) (events <-chan serviceEvent, stop func()) {
+	cmd := engine.command("events", "--filter", "type=container", "--filter", "label=sfa.agent="+agentName, "--format", "{{json .}}")
+	out, err := cmd.StdoutPipe()
+	if err != nil || cmd.Start() != nil {
+		return nil, func() {}
+	}
+	ch := make(chan serviceEvent, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(ch)
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			if ev, ok := parseServiceEvent(scanner.Text()); ok {
+				select {
+				case ch <- ev:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return ch, func() {
+		close(done)
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+}
+
+// awaitServiceEvent waits until an event arrives or the fallback poll is
+// due, then takes any other queued events, recording die events in died
+// so a container that exits and is restarted between two checks is not
+// missed. It returns the events channel, nil once the stream has ended.
+func awaitServiceEvent(events <-chan serviceEvent, died map[string]composeStatus) <-chan serviceEvent {
+	interval := servicePollInterval
+	if events != nil {
+		interval = serviceEventPollInterval
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	record := func(ev serviceEvent, ok bool) bool {
+		if !ok {
+			return false
+		}
+		if ev.Action == "die" {
+			died[ev.Service] = composeStatus{State: "exited", ExitCode: ev.ExitCode}
+		}
+		return true
+	}
+	select {
+	case ev, ok := <-events:
+		if !record(ev, ok) {
+			return nil
+		}
+	case <-timer.C:
+		return events
+	}
+	for {
+		select {
+		case ev, ok := <-events:
+			if !record(ev, ok) {
+				return nil
+			}
+		default:
+			return events
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package sfa
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseServiceEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want serviceEvent
+		ok   bool
+	}{
+		{
+			name: "docker die",
+			line: `{"Type":"container","Action":"die","Actor":{"ID":"abc","Attributes":{"com.docker.compose.service":"db","exitCode":"137"}}}`,
+			want: serviceEvent{Service: "db", Action: "die", ExitCode: 137},
+			ok:   true,
+		},
+		{
+			name: "docker health",
+			line: `{"Type":"container","Action":"health_status: healthy","Actor":{"Attributes":{"com.docker.compose.service":"db"}}}`,
+			want: serviceEvent{Service: "db", Action: "health_status"},
+			ok:   true,
+		},
+		{
+			name: "podman died",
+			line: `{"Status":"died","Attributes":{"com.docker.compose.service":"cache"},"ContainerExitCode":1}`,
+			want: serviceEvent{Service: "cache", Action: "die", ExitCode: 1},
+			ok:   true,
+		},
+		{name: "not compose", line: `{"Action":"die","Actor":{"Attributes":{"name":"other"}}}`},
+		{name: "not json", line: "db died"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseServiceEvent(tt.line)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("parseServiceEvent() = %+v, %v; want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAwaitServiceEvent(t *testing.T) {
+	ch := make(chan serviceEvent, 4)
+	ch <- serviceEvent{Service: "db", Action: "health_status"}
+	ch <- serviceEvent{Service: "app", Action: "die", ExitCode: 2}
+	died := make(map[string]composeStatus)
+
+	start := time.Now()
+	if got := awaitServiceEvent(ch, died); got == nil {
+		t.Fatal("awaitServiceEvent() = nil with the stream open")
+	}
+	if elapsed := time.Since(start); elapsed >= servicePollInterval {
+		t.Errorf("awaitServiceEvent() waited %s with events queued", elapsed)
+	}
+	if want := (composeStatus{State: "exited", ExitCode: 2}); died["app"] != want || len(died) != 1 {
+		t.Errorf("died = %+v, want only app %+v", died, want)
+	}
+
+	close(ch)
+	if got := awaitServiceEvent(ch, died); got != nil {
+		t.Error("awaitServiceEvent() did not return nil once the stream ended")
+	}
+}
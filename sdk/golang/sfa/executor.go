@@ -0,0 +1,290 @@
+package sfa
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// executor holds what every execution of the agent shares, whether it is the
+// single run of CLI mode or one of many requests handled in a server mode.
+type executor struct {
+	def           *AgentDef
+	flags         StandardFlags
+	resolved      *ResolvedEnv
+	config        map[string]any // merged for this agent
+	metrics       *metricsRegistry
+	signals       *signalHandler
+	permissions   *permissionPolicy
+	prompts       *prompter
+	contextStore  contextStore
+	checkpointDir string
+	rateLimitDir  string
+	services      *serviceMonitor // nil when the agent declares no services
+}
+
+// execution is the per-run state behind one ExecuteContext.
+type execution struct {
+	ctx      context.Context
+	safety   *SafetyState
+	costs    *costTracker
+	session  *sessionTracker
+	meta     *executionMeta       // nil discards SetMeta
+	progress func(message string) // receives each Progress message after it is emitted
+	partial  func(result any)     // receives each Partial result; nil discards them
+	touch    func()               // called after Progress and any user interaction
+}
+
+// executeContext builds the ExecuteContext for one execution.
+func (e *executor) executeContext(run *execution, input string, options map[string]any) *ExecuteContext {
+	name := e.def.Name
+	touch := run.touch
+	if touch == nil {
+		touch = func() {}
+	}
+
+	ctx := &ExecuteContext{
+		Input:        input,
+		Options:      options,
+		Env:          e.resolved.Values,
+		Config:       e.config,
+		Ctx:          run.ctx,
+		Depth:        run.safety.Depth,
+		SessionID:    run.safety.SessionID,
+		AgentName:    name,
+		AgentVersion: e.def.Version,
+		Progress: func(message string) {
+			emitProgress(name, message)
+			if run.progress != nil {
+				run.progress(message)
+			}
+			touch()
+		},
+		Partial: func(result any) {
+			if run.partial != nil {
+				run.partial(result)
+			}
+			touch()
+		},
+		Invoke: func(agentName string, opts *InvokeOpts) (*InvokeResult, error) {
+			result, err := invokeAgent(agentName, run.safety, run.costs, run.meta, run.ctx, opts)
+			e.metrics.recordSubagent(name, agentName, err == nil && result.OK)
+			return result, err
+		},
+		WriteContext: func(entry ContextEntry) (string, error) {
+			span := startSpan(run.ctx, "sfa.context.write")
+			span.setAttr("sfa.context.type", string(entry.Type))
+			var path string
+			err := validateContextEntry(entry, e.def.ContextSchema)
+			if err == nil {
+				entry.Attachments, err = loadContextAttachments(entry.Attachments)
+			}
+			if err == nil && !entry.AllowDuplicate {
+				path, err = findDuplicateContext(e.contextStore, entry, name, run.safety.SessionID)
+			}
+			duplicate := path != ""
+			if err == nil && !duplicate {
+				path, err = e.contextStore.write(entry, name, run.safety.SessionID)
+			}
+			span.setAttr("sfa.context.duplicate", duplicate)
+			span.finish(err)
+			if err == nil && !duplicate {
+				run.session.addContextEntry(path)
+			}
+			return path, err
+		},
+		AppendContext: func(path, content string) error {
+			return e.editContext(run, path, func(doc *contextDocument) string { return doc.appendContent(content) })
+		},
+		UpdateContext: func(path string, entry ContextEntry) error {
+			if err := validateContextUpdate(entry); err != nil {
+				return err
+			}
+			return e.editContext(run, path, func(doc *contextDocument) string { return doc.update(entry) })
+		},
+		ReplaceTags: func(path string, tags []string) error {
+			if err := validateContextTags(tags); err != nil {
+				return err
+			}
+			return e.editContext(run, path, func(doc *contextDocument) string { return doc.replaceTags(tags) })
+		},
+		ReadContext: func(pathOrID string) (*ContextResult, error) {
+			span := startSpan(run.ctx, "sfa.context.read")
+			result, err := e.contextStore.read(pathOrID)
+			span.finish(err)
+			return result, err
+		},
+		ReadAttachment: func(path, name string) ([]byte, error) {
+			span := startSpan(run.ctx, "sfa.context.attachment")
+			data, err := e.contextStore.readAttachment(path, name)
+			span.finish(err)
+			return data, err
+		},
+		SearchContext: func(query ContextQuery) ([]ContextResult, error) {
+			span := startSpan(run.ctx, "sfa.context.search")
+			results, err := e.contextStore.search(query)
+			span.setAttr("sfa.context.results", len(results))
+			span.finish(err)
+			return results, err
+		},
+		RelatedContext: func(path string, depth int) (*ContextGraph, error) {
+			span := startSpan(run.ctx, "sfa.context.related")
+			graph, err := relatedContext(e.contextStore, path, depth)
+			if graph != nil {
+				span.setAttr("sfa.context.results", len(graph.Nodes))
+			}
+			span.finish(err)
+			return graph, err
+		},
+		ListSessions: func() ([]ContextSession, error) {
+			span := startSpan(run.ctx, "sfa.context.sessions")
+			sessions, err := listContextSessions(e.contextStore)
+			span.setAttr("sfa.context.results", len(sessions))
+			span.finish(err)
+			return sessions, err
+		},
+		WatchContext: func(watchCtx context.Context, query ContextQuery) (<-chan ContextResult, error) {
+			watchCtx, cancel := context.WithCancel(watchCtx)
+			context.AfterFunc(run.ctx, cancel)
+			results, err := watchContext(watchCtx, e.contextStore, query)
+			if err != nil {
+				cancel()
+			}
+			return results, err
+		},
+		ExportContext: func(opts ContextExportOpts) ([]byte, error) {
+			return e.contextStore.export(opts)
+		},
+		ImportContext: func(data []byte) ([]string, error) {
+			paths, err := e.contextStore.importBundle(data)
+			for _, path := range paths {
+				run.session.addContextEntry(path)
+			}
+			return paths, err
+		},
+		QueryLogs: func(query LogQuery) ([]LogEntry, error) {
+			lc := &LoggingConfig{FilePath: resolveLogFile(e.config)}
+			lc.PerAgent, lc.PerAgentOnly = resolveLogPerAgent(e.config)
+			return queryLogs(lc, query)
+		},
+		SetMeta:    run.meta.set,
+		RecordCost: run.costs.record,
+		Checkpoint: func(state any) error {
+			return saveCheckpoint(e.checkpointDir, run.safety.SessionID, name, e.def.Version, state)
+		},
+		OnReload:    e.signals.addReloadHook,
+		OnStatus:    e.signals.addStatusHook,
+		ServiceLogs: e.services.logs,
+		OnServiceUnhealthy: func(fn func(service, state string)) {
+			e.services.onUnhealthy(run.ctx, fn)
+		},
+		RequestPermission: func(action string) error {
+			err := e.permissions.request(action)
+			touch()
+			return err
+		},
+		RateLimiter: func(limiter string, rps float64, burst int) *RateLimiter {
+			return newRateLimiter(e.rateLimitDir, run.safety.SessionID, limiter, rps, burst)
+		},
+		Confirm: func(question string) (bool, error) {
+			ok, err := e.prompts.confirm(question)
+			touch()
+			return ok, err
+		},
+		Prompt: func(question, def string) (string, error) {
+			answer, err := e.prompts.prompt(question, def)
+			touch()
+			return answer, err
+		},
+		envDefs:  e.def.Env,
+		resolved: e.resolved,
+		meta:     run.meta,
+	}
+	ctx.SummarizeSession = func(opts SummarizeSessionOpts) (string, error) {
+		span := startSpan(run.ctx, "sfa.context.summarize")
+		path, err := summarizeSession(ctx, e.contextStore, opts)
+		span.finish(err)
+		return path, err
+	}
+	return ctx
+}
+
+// classifyFailure maps a finished execution to its exit code and structured
+// error, emitting the matching progress line and diagnostic. It returns
+// ExitSuccess and nil when the execution did not fail.
+func classifyFailure(agentName string, execErr error, ctx context.Context, costs *costTracker, sandboxed bool) (int, *AgentError) {
+	if budgetErr := costs.exceededErr(); budgetErr != nil {
+		emitProgress(agentName, "budget exceeded")
+		stderrLog.Error(budgetErr.Error())
+		return ExitFailure, toAgentError(budgetErr, ErrCodeBudgetExceeded, false)
+	}
+	if execErr == nil {
+		return ExitSuccess, nil
+	}
+
+	exitCode := ExitFailure
+	var failure *AgentError
+	if sandboxed && isSandboxViolation(execErr) {
+		exitCode = ExitPermissionDeny
+		failure = toAgentError(execErr, ErrCodeSandboxViolation, false)
+		emitProgress(agentName, "sandbox violation")
+	} else if errors.Is(execErr, ErrPermissionDenied) {
+		exitCode = ExitPermissionDeny
+		failure = toAgentError(execErr, ErrCodePermissionDenied, false)
+		emitProgress(agentName, "permission denied")
+	} else if ctx.Err() != nil {
+		exitCode = ExitTimeout
+		failure = toAgentError(execErr, ErrCodeTimeout, true)
+		emitProgress(agentName, "timeout exceeded")
+	} else {
+		failure = toAgentError(execErr, ErrCodeExecutionFailed, false)
+		if failure.ExitCode >= 10 {
+			exitCode = failure.ExitCode
+		}
+	}
+	stderrLog.Error(execErr.Error())
+	return exitCode, failure
+}
+
+// agentResult wraps Execute's return value, folding an AgentResult error
+// string into the exit code and structured error when the run otherwise
+// succeeded.
+func agentResult(result any, exitCode int, failure *AgentError) (AgentResult, int, *AgentError) {
+	ar, ok := result.(AgentResult)
+	if !ok {
+		ar = AgentResult{Result: result}
+	}
+	if ar.Error != "" && exitCode == ExitSuccess {
+		exitCode = ExitFailure
+		failure = &AgentError{Code: ErrCodeExecutionFailed, Message: ar.Error}
+	}
+	return ar, exitCode, failure
+}
+
+// attachCost records cost totals in the result metadata.
+func attachCost(ar *AgentResult, totals map[string]float64) {
+	if totals == nil {
+		return
+	}
+	if ar.Metadata == nil {
+		ar.Metadata = make(map[string]any)
+	}
+	ar.Metadata["cost"] = totals
+}
+
+// editContext applies change to the entry at path and records it in the
+// entry's changelog. change describes what it changed, or returns "" to
+// leave the entry as it was.
+func (e *executor) editContext(run *execution, path string, change func(doc *contextDocument) string) error {
+	span := startSpan(run.ctx, "sfa.context.update")
+	err := e.contextStore.rewrite(path, func(doc *contextDocument) bool {
+		description := change(doc)
+		if description == "" {
+			return false
+		}
+		doc.logChange(e.def.Name, description, time.Now())
+		return true
+	})
+	span.finish(err)
+	return err
+}
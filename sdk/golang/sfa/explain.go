@@ -0,0 +1,283 @@
+package sfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// explainPlan summarizes what a run would do, printed by --explain.
+type explainPlan struct {
+	Agent        string           `json:"agent"`
+	Version      string           `json:"version"`
+	TrustLevel   TrustLevel       `json:"trustLevel"`
+	Sandbox      string           `json:"sandbox"`
+	Timeout      string           `json:"timeout"`
+	OutputFormat OutputFormat     `json:"outputFormat"`
+	Depth        int              `json:"depth"`
+	MaxDepth     int              `json:"maxDepth"`
+	Cache        string           `json:"cache"`
+	Logging      string           `json:"logging"`
+	Env          []explainEnv     `json:"env"`
+	Services     []explainService `json:"services,omitempty"`
+	Lifecycle    ServiceLifecycle `json:"serviceLifecycle,omitempty"`
+	Subagents    explainSubagents `json:"subagents"`
+	Problems     []string         `json:"problems,omitempty"` // reasons the run would fail before Execute
+}
+
+type explainEnv struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	Secret   bool   `json:"secret"`
+	Source   string `json:"source"` // "missing" when unresolved
+	Value    string `json:"value,omitempty"`
+}
+
+type explainService struct {
+	Name  string   `json:"name"`
+	Image string   `json:"image"`
+	Ports []string `json:"ports,omitempty"`
+}
+
+type explainSubagents struct {
+	Allowed   bool     `json:"allowed"`
+	Reason    string   `json:"reason,omitempty"`
+	Reachable []string `json:"reachable"`
+}
+
+// buildExplainPlan inspects the lifecycle without executing anything.
+// input is used only to look up the result cache.
+func buildExplainPlan(def *AgentDef, args *ParsedArgs, resolved *ResolvedEnv, logConfig *LoggingConfig, input string) *explainPlan {
+	flags := args.Flags
+	plan := &explainPlan{
+		Agent:        def.Name,
+		Version:      def.Version,
+		TrustLevel:   def.describedTrustLevel(),
+		OutputFormat: flags.OutputFormat,
+		Depth:        parseInt(os.Getenv("SFA_DEPTH"), 0),
+		MaxDepth:     parseInt(os.Getenv("SFA_MAX_DEPTH"), flags.MaxDepth),
+	}
+
+	// Sandbox
+	sandboxed := def.TrustLevel == TrustSandboxed && os.Getenv(sandboxEnv) != "off" && sandboxSupported
+	switch {
+	case sandboxed:
+		plan.Sandbox = "enforced"
+	case def.TrustLevel == TrustSandboxed && os.Getenv(sandboxEnv) == "off":
+		plan.Sandbox = "disabled (SFA_SANDBOX=off)"
+	case def.TrustLevel == TrustSandboxed:
+		plan.Sandbox = fmt.Sprintf("advisory (not supported on %s)", runtime.GOOS)
+	case def.TrustLevel == "":
+		plan.Sandbox = "advisory (trust level not declared)"
+	default:
+		plan.Sandbox = "none"
+	}
+
+	// Timeout
+	if flags.Timeout > 0 {
+		plan.Timeout = fmt.Sprintf("%ds", flags.Timeout)
+	} else {
+		plan.Timeout = "none"
+	}
+
+	// Cache
+	switch {
+	case !def.Cacheable:
+		plan.Cache = "not cacheable"
+	case flags.NoCache:
+		plan.Cache = "bypassed (--no-cache)"
+	case lookupCache(resolveCacheDir(), def.Name, computeCacheKey(def.Name, def.Version, input, args.Custom)) != nil:
+		plan.Cache = "hit"
+	default:
+		plan.Cache = "miss"
+	}
+
+	// Logging
+	if logConfig.Suppressed {
+		plan.Logging = "suppressed"
+	} else {
+		plan.Logging = logConfig.FilePath
+	}
+
+	// Environment
+	plan.Env = make([]explainEnv, 0, len(def.Env))
+	for _, decl := range def.Env {
+		e := explainEnv{Name: decl.Name, Required: decl.Required, Secret: decl.Secret, Source: "missing"}
+		if val, ok := resolved.Values[decl.Name]; ok {
+			e.Source = resolved.Sources[decl.Name]
+			e.Value = val
+			if decl.Secret || resolved.Secrets[decl.Name] {
+				e.Value = "***"
+			}
+			// References resolve at startup, so only literal values are checked here
+			if val != "" && secretProviderFor(val) == nil {
+				if err := checkEnvValue(decl, val); err != nil {
+					plan.Problems = append(plan.Problems, err.Error())
+				}
+			}
+		} else if decl.Required {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("required env var %s is not set", decl.Name))
+		}
+		plan.Env = append(plan.Env, e)
+	}
+
+	// Services
+	if len(def.Services) > 0 {
+		plan.Lifecycle = def.ServiceLifecycle
+		for _, name := range sortedServiceNames(def.Services) {
+			svc := def.Services[name]
+			plan.Services = append(plan.Services, explainService{Name: name, Image: serviceImage(def.Name, name, svc), Ports: svc.Ports})
+		}
+		if sandboxed {
+			plan.Problems = append(plan.Problems, "sandboxed agents cannot start services")
+		}
+	}
+
+	// Subagents
+	plan.Subagents.Reachable = discoverSubagents(def.Name)
+	switch {
+	case sandboxed:
+		plan.Subagents.Reason = "sandboxed agents cannot invoke subagents"
+	case plan.Depth+1 > plan.MaxDepth:
+		plan.Subagents.Reason = fmt.Sprintf("max depth %d reached", plan.MaxDepth)
+	default:
+		plan.Subagents.Allowed = true
+		plan.Subagents.Reason = fmt.Sprintf("%d level(s) remaining", plan.MaxDepth-plan.Depth)
+	}
+
+	return plan
+}
+
+// discoverSubagents lists agents an Invoke call would likely resolve:
+// executables beside this agent and sfa-* executables on PATH.
+func discoverSubagents(self string) []string {
+	seen := make(map[string]bool)
+	var found []string
+	add := func(name string) {
+		if name == self || seen[name] {
+			return
+		}
+		seen[name] = true
+		found = append(found, name)
+	}
+
+	if strings.ContainsRune(os.Args[0], filepath.Separator) {
+		selfPath, _ := filepath.Abs(os.Args[0])
+		dir := filepath.Dir(selfPath)
+		for _, p := range executablesIn(dir) {
+			if p != selfPath {
+				add(filepath.Join(filepath.Base(dir), filepath.Base(p)))
+			}
+		}
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		for _, p := range executablesIn(dir) {
+			if name := filepath.Base(p); strings.HasPrefix(name, "sfa-") {
+				add(name)
+			}
+		}
+	}
+
+	sort.Strings(found)
+	return found
+}
+
+// executablesIn returns the executable regular files in dir.
+func executablesIn(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		out = append(out, filepath.Join(dir, e.Name()))
+	}
+	return out
+}
+
+// sortedServiceNames returns service names in a stable order.
+func sortedServiceNames(services map[string]ServiceDef) []string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// render formats the plan as indented JSON or aligned text.
+func (p *explainPlan) render(format OutputFormat) string {
+	if format == OutputJSON {
+		data, _ := json.MarshalIndent(p, "", "  ")
+		return string(data) + "\n"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Execution plan for %s %s\n\n", p.Agent, p.Version)
+	fmt.Fprintf(&b, "  Trust level:    %s (sandbox: %s)\n", p.TrustLevel, p.Sandbox)
+	fmt.Fprintf(&b, "  Timeout:        %s\n", p.Timeout)
+	fmt.Fprintf(&b, "  Output format:  %s\n", p.OutputFormat)
+	fmt.Fprintf(&b, "  Depth:          %d of max %d\n", p.Depth, p.MaxDepth)
+	fmt.Fprintf(&b, "  Cache:          %s\n", p.Cache)
+	fmt.Fprintf(&b, "  Logging:        %s\n", p.Logging)
+
+	b.WriteString("\nEnvironment:\n")
+	if len(p.Env) == 0 {
+		b.WriteString("  (none declared)\n")
+	}
+	for _, e := range p.Env {
+		var tags []string
+		if e.Required {
+			tags = append(tags, "required")
+		}
+		if e.Secret {
+			tags = append(tags, "secret")
+		}
+		line := "  " + e.Name
+		if len(tags) > 0 {
+			line += " (" + strings.Join(tags, ", ") + ")"
+		}
+		line += ": " + e.Source
+		if e.Value != "" {
+			line += " = " + e.Value
+		}
+		b.WriteString(line + "\n")
+	}
+
+	if len(p.Services) > 0 {
+		fmt.Fprintf(&b, "\nServices (%s):\n", p.Lifecycle)
+		for _, s := range p.Services {
+			line := fmt.Sprintf("  %s: %s", s.Name, s.Image)
+			if len(s.Ports) > 0 {
+				line += " ports " + strings.Join(s.Ports, ", ")
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+
+	b.WriteString("\nSubagents:\n")
+	if p.Subagents.Allowed {
+		fmt.Fprintf(&b, "  invocation allowed (%s)\n", p.Subagents.Reason)
+	} else {
+		fmt.Fprintf(&b, "  invocation not allowed (%s)\n", p.Subagents.Reason)
+	}
+	if len(p.Subagents.Reachable) > 0 {
+		fmt.Fprintf(&b, "  reachable: %s\n", strings.Join(p.Subagents.Reachable, ", "))
+	}
+
+	if len(p.Problems) > 0 {
+		b.WriteString("\nWould fail before execution:\n")
+		for _, problem := range p.Problems {
+			fmt.Fprintf(&b, "  - %s\n", problem)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package sfa
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplainPlan(t *testing.T) {
+	os.Setenv("SFA_DEPTH", "1")
+	os.Setenv("SFA_EXPLAIN_TOKEN", "tok-123")
+	defer os.Unsetenv("SFA_DEPTH")
+	defer os.Unsetenv("SFA_EXPLAIN_TOKEN")
+
+	def := &AgentDef{
+		Name:       "explainer",
+		Version:    "1.2.0",
+		TrustLevel: TrustNetwork,
+		Env: []EnvDef{
+			{Name: "SFA_EXPLAIN_TOKEN", Required: true, Secret: true},
+			{Name: "MODEL", Default: "small"},
+			{Name: "REGION", Required: true},
+		},
+		Services: map[string]ServiceDef{
+			"db": {Image: "postgres:16", Ports: []string{"5432"}},
+		},
+		ServiceLifecycle: ServiceEphemeral,
+	}
+	args := &ParsedArgs{Flags: StandardFlags{Timeout: 60, MaxDepth: 3, OutputFormat: OutputText}}
+	resolved := resolveEnv(def.Env, def.Name, map[string]any{})
+
+	plan := buildExplainPlan(def, args, resolved, &LoggingConfig{Suppressed: true}, "")
+
+	if plan.Timeout != "60s" || plan.Depth != 1 || plan.MaxDepth != 3 || plan.Cache != "not cacheable" {
+		t.Errorf("unexpected plan basics: %+v", plan)
+	}
+	sources := map[string]string{}
+	for _, e := range plan.Env {
+		sources[e.Name] = e.Source
+		if e.Name == "SFA_EXPLAIN_TOKEN" && e.Value != "***" {
+			t.Errorf("expected secret masked, got %q", e.Value)
+		}
+	}
+	if sources["SFA_EXPLAIN_TOKEN"] != envSourceProcess || sources["MODEL"] != envSourceDef || sources["REGION"] != "missing" {
+		t.Errorf("unexpected env sources: %v", sources)
+	}
+	if len(plan.Problems) != 1 || !strings.Contains(plan.Problems[0], "REGION") {
+		t.Errorf("expected missing REGION reported, got %v", plan.Problems)
+	}
+	if !plan.Subagents.Allowed {
+		t.Errorf("expected subagents allowed at depth 1 of 3, got %+v", plan.Subagents)
+	}
+
+	text := plan.render(OutputText)
+	for _, want := range []string{"Execution plan for explainer 1.2.0", "db: postgres:16", "Services (ephemeral)", "REGION (required): missing"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in text plan:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "tok-123") {
+		t.Error("expected secret value absent from plan")
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(plan.render(OutputJSON)), &decoded); err != nil {
+		t.Fatalf("invalid JSON plan: %v", err)
+	}
+}
+
+func TestExplainPlanSandboxed(t *testing.T) {
+	os.Unsetenv("SFA_SANDBOX")
+	def := &AgentDef{Name: "boxed", TrustLevel: TrustSandboxed}
+	args := &ParsedArgs{Flags: StandardFlags{MaxDepth: 5}}
+	plan := buildExplainPlan(def, args, resolveEnv(nil, "boxed", nil), &LoggingConfig{Suppressed: true}, "")
+
+	if !sandboxSupported {
+		if !strings.HasPrefix(plan.Sandbox, "advisory") || !plan.Subagents.Allowed {
+			t.Errorf("expected advisory sandbox with subagents, got %+v", plan)
+		}
+		return
+	}
+	if plan.Sandbox != "enforced" || plan.Subagents.Allowed {
+		t.Errorf("expected enforced sandbox without subagents, got %+v", plan)
+	}
+}
+
+func TestExplainPlanUndeclaredTrustLevel(t *testing.T) {
+	os.Unsetenv("SFA_SANDBOX")
+	def := &AgentDef{Name: "plain"}
+	args := &ParsedArgs{Flags: StandardFlags{MaxDepth: 5}}
+	plan := buildExplainPlan(def, args, resolveEnv(nil, "plain", nil), &LoggingConfig{Suppressed: true}, "")
+
+	if plan.TrustLevel != TrustSandboxed || plan.Sandbox != "advisory (trust level not declared)" {
+		t.Errorf("expected advisory sandboxed trust level, got %+v", plan)
+	}
+}
+
+func TestDiscoverSubagents(t *testing.T) {
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"sfa-reviewer": 0755, "sfa-notes.txt": 0644, "ls": 0755} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", dir)
+	defer os.Setenv("PATH", oldPath)
+
+	found := discoverSubagents("sfa-self")
+	if len(found) != 1 || found[0] != "sfa-reviewer" {
+		t.Errorf("expected only sfa-reviewer, got %v", found)
+	}
+}
@@ -0,0 +1,77 @@
+package sfa
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gpuRequest is a service's GPU reservation: Count ("all" or a number) or
+// specific DeviceIDs.
+type gpuRequest struct {
+	Count     string
+	DeviceIDs []string
+}
+
+// parseGPURequest reads ServiceDef.GPUs: "all", a positive count such as
+// "1", or "device=0,2" for specific GPUs, as docker run --gpus takes them.
+func parseGPURequest(service, gpus string) (gpuRequest, error) {
+	if ids, ok := strings.CutPrefix(gpus, "device="); ok {
+		var request gpuRequest
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				request.DeviceIDs = append(request.DeviceIDs, id)
+			}
+		}
+		if len(request.DeviceIDs) > 0 {
+			return request, nil
+		}
+	} else if gpus == "all" {
+		return gpuRequest{Count: "all"}, nil
+	} else if n, err := strconv.Atoi(gpus); err == nil && n > 0 {
+		return gpuRequest{Count: gpus}, nil
+	}
+	return gpuRequest{}, fmt.Errorf("service %s: invalid GPUs %q (use \"all\", a count such as \"1\", or \"device=0,2\")", service, gpus)
+}
+
+// cdiSpecDirs are where the NVIDIA Container Toolkit writes the CDI specs
+// Podman hands GPUs out with.
+var cdiSpecDirs = []string{"/etc/cdi", "/var/run/cdi"}
+
+// gpuRuntimeAvailable reports whether the engine can give containers
+// NVIDIA GPUs: Docker has the nvidia runtime registered, or Podman has an
+// NVIDIA CDI spec. A variable so tests can stand in for the engine.
+var gpuRuntimeAvailable = func(engine containerEngine) bool {
+	if engine.name == EnginePodman {
+		for _, dir := range cdiSpecDirs {
+			matches, _ := filepath.Glob(filepath.Join(dir, "nvidia*"))
+			if len(matches) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	out, err := engine.command("info", "--format", "{{json .Runtimes}}").Output()
+	return err == nil && strings.Contains(string(out), `"nvidia"`)
+}
+
+// checkGPURuntime fails, naming the services that request GPUs, if the
+// engine has no GPU runtime to give them.
+func checkGPURuntime(engine containerEngine, services map[string]ServiceDef) error {
+	var requesting []string
+	for _, name := range sortedKeys(services) {
+		if services[name].GPUs != "" {
+			requesting = append(requesting, name)
+		}
+	}
+	if len(requesting) == 0 || gpuRuntimeAvailable(engine) {
+		return nil
+	}
+	who := "service " + requesting[0] + " requests"
+	if len(requesting) > 1 {
+		who = "services " + strings.Join(requesting, ", ") + " request"
+	}
+	return fmt.Errorf("%s GPUs, but %s has no NVIDIA GPU runtime. Install the NVIDIA Container Toolkit (https://docs.nvidia.com/datacenter/cloud-native/container-toolkit/) and restart %s, or run on a host with GPUs",
+		who, engineTitle(engine.name), engineTitle(engine.name))
+}
@@ -0,0 +1,58 @@
+package sfa
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMaterializeComposeGPUs(t *testing.T) {
+	t.Setenv("SFA_DATA_HOME", t.TempDir())
+	services := map[string]ServiceDef{
+		"ollama": {Image: "ollama/ollama", GPUs: "all", Resources: &ResourceLimits{Memory: "8g"}},
+		"vllm":   {Image: "vllm/vllm-openai", GPUs: "device=0, 2"},
+	}
+	path, err := materializeCompose("inference", "1.0.0", services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"    deploy:\n      resources:\n        limits:\n          memory: 8g\n        reservations:\n          devices:\n            - driver: nvidia\n              count: all\n              capabilities: [gpu]\n",
+		"              device_ids:\n                - \"0\"\n                - \"2\"\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in compose file:\n%s", want, data)
+		}
+	}
+
+	for _, gpus := range []string{"0", "-1", "some", "device="} {
+		bad := map[string]ServiceDef{"ollama": {Image: "ollama/ollama", GPUs: gpus}}
+		if _, err := materializeCompose("inference", "1.0.0", bad); err == nil {
+			t.Errorf("expected GPUs %q to be rejected", gpus)
+		}
+	}
+}
+
+func TestCheckGPURuntime(t *testing.T) {
+	orig := gpuRuntimeAvailable
+	t.Cleanup(func() { gpuRuntimeAvailable = orig })
+	available := false
+	gpuRuntimeAvailable = func(containerEngine) bool { return available }
+
+	engine := containerEngine{name: EngineDocker}
+	if err := checkGPURuntime(engine, map[string]ServiceDef{"db": {Image: "postgres:16"}}); err != nil {
+		t.Errorf("expected services without GPUs to pass, got %v", err)
+	}
+	services := map[string]ServiceDef{"ollama": {Image: "ollama/ollama", GPUs: "1"}}
+	if err := checkGPURuntime(engine, services); err == nil || !strings.Contains(err.Error(), "service ollama requests GPUs, but Docker has no NVIDIA GPU runtime") {
+		t.Errorf("expected a missing runtime error, got %v", err)
+	}
+	available = true
+	if err := checkGPURuntime(engine, services); err != nil {
+		t.Errorf("expected the runtime to satisfy ollama, got %v", err)
+	}
+}
@@ -0,0 +1,361 @@
+package sfa
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The gRPC AgentService (specification/agent-service.proto) is served
+// without a gRPC dependency: the wire format is length-prefixed protobuf
+// over HTTP/2, which the standard library provides over TLS.
+
+const (
+	grpcServicePath = "/sfa.v1.AgentService/"
+
+	// gRPC status codes
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcUnimplemented   = 12
+	grpcUnauthenticated = 16
+)
+
+// Env vars naming a PEM certificate and key for the gRPC listener.
+const (
+	grpcCertEnv = "SFA_GRPC_TLS_CERT"
+	grpcKeyEnv  = "SFA_GRPC_TLS_KEY"
+)
+
+// serveGRPC serves the AgentService on addr until ctx is cancelled.
+func (s *agentServer) serveGRPC(ctx context.Context, addr string) error {
+	cert, fingerprint, err := grpcCertificate()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	srv := &http.Server{
+		Handler:           s.grpcHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}},
+	}
+	if fingerprint != "" {
+		stderrLog.Warn(fmt.Sprintf("%s and %s are not set; using a self-signed certificate (SHA-256 %s)", grpcCertEnv, grpcKeyEnv, fingerprint))
+	}
+	emitProgress(s.runner.def.Name, "serving gRPC on "+ln.Addr().String())
+	return s.serveUntilDone(ctx, srv, tls.NewListener(ln, srv.TLSConfig))
+}
+
+// grpcCertificate loads the certificate named by SFA_GRPC_TLS_CERT and
+// SFA_GRPC_TLS_KEY, or generates a self-signed one and returns its
+// fingerprint so clients can pin it.
+func grpcCertificate() (tls.Certificate, string, error) {
+	certFile, keyFile := os.Getenv(grpcCertEnv), os.Getenv(grpcKeyEnv)
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return tls.Certificate{}, "", fmt.Errorf("failed to load gRPC TLS certificate: %w", err)
+		}
+		return cert, "", nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	host, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "sfa-agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost", host},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	sum := sha256.Sum256(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, hex.EncodeToString(sum[:]), nil
+}
+
+// grpcHandler routes AgentService methods.
+func (s *agentServer) grpcHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC requires HTTP/2 POST with application/grpc", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		stream := &grpcStream{w: w}
+
+		switch r.URL.Path {
+		case grpcServicePath + "Execute":
+			s.grpcExecute(r, stream)
+		case grpcServicePath + "Describe":
+			if _, err := readGRPCMessage(r.Body); err != nil {
+				stream.finish(grpcInvalidArgument, err.Error())
+				return
+			}
+			s.mu.RLock()
+			resolved := s.resolved
+			s.mu.RUnlock()
+			describe, _ := json.Marshal(generateDescribe(s.runner.def, resolved.Values, resolved.Secrets))
+			stream.send(appendProtoString(nil, 1, string(describe)))
+			stream.finish(grpcOK, "")
+		default:
+			stream.finish(grpcUnimplemented, "unknown method "+r.URL.Path)
+		}
+	})
+}
+
+func (s *agentServer) grpcExecute(r *http.Request, stream *grpcStream) {
+	if !s.authorized(r, false) {
+		stream.finish(grpcUnauthenticated, "missing or invalid bearer token")
+		return
+	}
+	msg, err := readGRPCMessage(r.Body)
+	if err != nil {
+		stream.finish(grpcInvalidArgument, err.Error())
+		return
+	}
+	req, err := decodeExecuteRequest(msg)
+	if err != nil {
+		stream.finish(grpcInvalidArgument, err.Error())
+		return
+	}
+
+	resp := s.execute(r.Context(), req, func(event executionEvent) {
+		if event.Type == "progress" {
+			// ExecuteEvent{progress: Progress{message}}
+			stream.send(appendProtoMessage(nil, 1, appendProtoString(nil, 1, event.Message)))
+		}
+	})
+	stream.send(appendProtoMessage(nil, 2, encodeExecuteResult(resp)))
+	stream.finish(grpcOK, "")
+}
+
+// grpcStream writes length-prefixed messages and the closing status.
+type grpcStream struct {
+	mu       sync.Mutex
+	w        http.ResponseWriter
+	finished bool
+}
+
+func (g *grpcStream) send(msg []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.finished {
+		return
+	}
+	var prefix [5]byte // uncompressed flag + big-endian length
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	g.w.Write(prefix[:])
+	g.w.Write(msg)
+	if f, ok := g.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *grpcStream) finish(code int, message string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.finished = true
+	g.w.Header().Set("Grpc-Status", fmt.Sprintf("%d", code))
+	if message != "" {
+		g.w.Header().Set("Grpc-Message", message)
+	}
+}
+
+// readGRPCMessage reads the single request message of a unary or
+// server-streaming call.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.EOF {
+			return nil, nil // an empty message may be sent as no frame
+		}
+		return nil, fmt.Errorf("reading request: %w", err)
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed requests are not supported")
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > maxRequestBody {
+		return nil, fmt.Errorf("request of %d bytes exceeds the limit", n)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("reading request: %w", err)
+	}
+	return msg, nil
+}
+
+// decodeExecuteRequest decodes an ExecuteRequest message.
+func decodeExecuteRequest(msg []byte) (executeRequest, error) {
+	var req executeRequest
+	var optionsJSON string
+	err := walkProto(msg, func(field int, v uint64, data []byte) {
+		switch field {
+		case 1:
+			req.Input = string(data)
+		case 2:
+			optionsJSON = string(data)
+		case 3:
+			req.Timeout = int(int32(v))
+		case 4:
+			req.SessionID = string(data)
+		}
+	})
+	if err != nil {
+		return req, fmt.Errorf("invalid ExecuteRequest: %w", err)
+	}
+	if optionsJSON != "" {
+		if err := json.Unmarshal([]byte(optionsJSON), &req.Options); err != nil {
+			return req, fmt.Errorf("invalid options_json: %w", err)
+		}
+	}
+	if req.SessionID != "" {
+		if err := validateSessionID(req.SessionID); err != nil {
+			return req, fmt.Errorf("invalid session_id: %w", err)
+		}
+	}
+	return req, nil
+}
+
+// encodeExecuteResult encodes an ExecuteResult message.
+func encodeExecuteResult(resp *executeResponse) []byte {
+	var b []byte
+	b = appendProtoVarint(b, 1, uint64(int64(resp.ExitCode)))
+	b = appendProtoString(b, 2, resp.SessionID)
+	if resp.Result != nil {
+		result, _ := json.Marshal(resp.Result)
+		b = appendProtoString(b, 3, string(result))
+	}
+	if len(resp.Metadata) > 0 {
+		metadata, _ := json.Marshal(resp.Metadata)
+		b = appendProtoString(b, 4, string(metadata))
+	}
+	for _, w := range resp.Warnings {
+		b = appendProtoString(b, 5, w)
+	}
+	if e := resp.Error; e != nil {
+		var eb []byte
+		eb = appendProtoString(eb, 1, e.Code)
+		eb = appendProtoString(eb, 2, e.Message)
+		if len(e.Details) > 0 {
+			details, _ := json.Marshal(e.Details)
+			eb = appendProtoString(eb, 3, string(details))
+		}
+		if e.Retryable {
+			eb = appendProtoVarint(eb, 4, 1)
+		}
+		b = appendProtoMessage(b, 6, eb)
+	}
+	return b
+}
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendProtoTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// appendProtoVarint appends a varint field, omitting the zero default.
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendProtoTag(b, field, wireVarint), v)
+}
+
+// appendProtoString appends a string field, omitting the empty default.
+func appendProtoString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = binary.AppendUvarint(appendProtoTag(b, field, wireBytes), uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendProtoMessage appends an embedded message, even when empty, so a
+// oneof member stays present.
+func appendProtoMessage(b []byte, field int, msg []byte) []byte {
+	b = binary.AppendUvarint(appendProtoTag(b, field, wireBytes), uint64(len(msg)))
+	return append(b, msg...)
+}
+
+// walkProto calls fn for each field of msg with its varint value or its
+// length-delimited bytes; fixed-width fields are skipped.
+func walkProto(msg []byte, fn func(field int, v uint64, data []byte)) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("truncated tag")
+		}
+		msg = msg[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+		switch wireType {
+		case wireVarint:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return errors.New("truncated varint")
+			}
+			msg = msg[n:]
+			fn(field, v, nil)
+		case wireBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || l > uint64(len(msg)-n) {
+				return errors.New("truncated field")
+			}
+			fn(field, 0, msg[n:n+int(l)])
+			msg = msg[n+int(l):]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wireType == wireFixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return errors.New("truncated field")
+			}
+			msg = msg[size:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", wireType)
+		}
+	}
+	return nil
+}
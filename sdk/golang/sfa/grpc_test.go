@@ -0,0 +1,184 @@
+package sfa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func grpcFrame(msg []byte) []byte {
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	return append(prefix[:], msg...)
+}
+
+// grpcCall posts one framed request and returns the response messages and
+// trailers.
+func grpcCall(t *testing.T, client *http.Client, url string, msg []byte) ([][]byte, http.Header) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(grpcFrame(msg)))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var msgs [][]byte
+	for {
+		m, err := readGRPCMessage(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m == nil {
+			break
+		}
+		msgs = append(msgs, m)
+	}
+	io.Copy(io.Discard, resp.Body)
+	trailer := resp.Trailer
+	if trailer.Get("Grpc-Status") == "" {
+		trailer = resp.Header // trailers-only response
+	}
+	return msgs, trailer
+}
+
+func newGRPCTestServer(t *testing.T, s *agentServer) *httptest.Server {
+	srv := httptest.NewUnstartedServer(s.grpcHandler())
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	var msg []byte
+	msg = appendProtoString(msg, 1, "input text")
+	msg = appendProtoString(msg, 2, `{"model":"small"}`)
+	msg = appendProtoVarint(msg, 3, 30)
+	msg = append(appendProtoTag(msg, 9, wireFixed32), 1, 2, 3, 4) // unknown field is skipped
+	msg = appendProtoString(msg, 4, "sess-1")
+
+	req, err := decodeExecuteRequest(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Input != "input text" || req.Options["model"] != "small" || req.Timeout != 30 || req.SessionID != "sess-1" {
+		t.Errorf("unexpected request: %+v", req)
+	}
+
+	if _, err := decodeExecuteRequest([]byte{0x0a, 0x05, 'a'}); err == nil {
+		t.Error("expected an error for a truncated field")
+	}
+	if _, err := decodeExecuteRequest(appendProtoString(nil, 4, "../../etc")); err == nil {
+		t.Error("expected an error for a path-like session_id")
+	}
+}
+
+func TestGRPCExecute(t *testing.T) {
+	s := newTestServer(t, &AgentDef{
+		Name:    "grpc-agent",
+		Version: "1.0.0",
+		Execute: func(ctx *ExecuteContext) (any, error) {
+			ctx.Progress("halfway")
+			if ctx.Input == "fail" {
+				return nil, &AgentError{Code: "bad_input", Message: "cannot handle that", Retryable: true}
+			}
+			return map[string]any{"echo": ctx.Input}, nil
+		},
+	})
+	srv := newGRPCTestServer(t, s)
+
+	captureStderr(t, func() {
+		msgs, trailer := grpcCall(t, srv.Client(), srv.URL+grpcServicePath+"Execute", appendProtoString(nil, 1, "hi"))
+		if trailer.Get("Grpc-Status") != "0" || len(msgs) != 2 {
+			t.Fatalf("expected progress and result with status 0, got %d messages, trailer %v", len(msgs), trailer)
+		}
+
+		var progress string
+		walkProto(msgs[0], func(field int, _ uint64, data []byte) {
+			if field == 1 {
+				walkProto(data, func(_ int, _ uint64, m []byte) { progress = string(m) })
+			}
+		})
+		if progress != "halfway" {
+			t.Errorf("expected progress event, got %q", progress)
+		}
+
+		fields := map[int]string{}
+		walkProto(msgs[1], func(field int, _ uint64, data []byte) {
+			if field == 2 {
+				walkProto(data, func(f int, v uint64, d []byte) { fields[f] = string(d) })
+			}
+		})
+		if fields[3] != `{"echo":"hi"}` || fields[2] == "" {
+			t.Errorf("unexpected result event: %v", fields)
+		}
+
+		msgs, _ = grpcCall(t, srv.Client(), srv.URL+grpcServicePath+"Execute", appendProtoString(nil, 1, "fail"))
+		var exitCode uint64
+		var errCode string
+		walkProto(msgs[len(msgs)-1], func(field int, _ uint64, data []byte) {
+			walkProto(data, func(f int, v uint64, d []byte) {
+				switch f {
+				case 1:
+					exitCode = v
+				case 6:
+					walkProto(d, func(ef int, _ uint64, ed []byte) {
+						if ef == 1 {
+							errCode = string(ed)
+						}
+					})
+				}
+			})
+		})
+		if exitCode != ExitFailure || errCode != "bad_input" {
+			t.Errorf("expected failure result, got exit %d code %q", exitCode, errCode)
+		}
+	})
+}
+
+func TestGRPCDescribeAndErrors(t *testing.T) {
+	s := newTestServer(t, &AgentDef{Name: "grpc-describe", Version: "3.0.0", Execute: func(*ExecuteContext) (any, error) { return nil, nil }})
+	srv := newGRPCTestServer(t, s)
+
+	msgs, trailer := grpcCall(t, srv.Client(), srv.URL+grpcServicePath+"Describe", nil)
+	if trailer.Get("Grpc-Status") != "0" || len(msgs) != 1 || !strings.Contains(string(msgs[0]), `"name":"grpc-describe"`) {
+		t.Errorf("unexpected describe response: %q %v", msgs, trailer)
+	}
+
+	_, trailer = grpcCall(t, srv.Client(), srv.URL+grpcServicePath+"Nope", nil)
+	if trailer.Get("Grpc-Status") != "12" {
+		t.Errorf("expected UNIMPLEMENTED, got %v", trailer)
+	}
+
+	_, trailer = grpcCall(t, srv.Client(), srv.URL+grpcServicePath+"Execute", appendProtoString(nil, 2, "not json"))
+	if trailer.Get("Grpc-Status") != "3" {
+		t.Errorf("expected INVALID_ARGUMENT, got %v", trailer)
+	}
+
+	resp, err := http.Post(strings.Replace(srv.URL, "https", "http", 1)+grpcServicePath+"Describe", "application/grpc", nil)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Error("expected a plaintext HTTP/1 request to be refused")
+		}
+	}
+}
+
+func TestGRPCCertificate(t *testing.T) {
+	t.Setenv(grpcCertEnv, "")
+	t.Setenv(grpcKeyEnv, "")
+	cert, fingerprint, err := grpcCertificate()
+	if err != nil || len(cert.Certificate) != 1 || len(fingerprint) != 64 {
+		t.Errorf("expected a self-signed certificate, got %v %q", err, fingerprint)
+	}
+
+	t.Setenv(grpcCertEnv, "/nonexistent/cert.pem")
+	if _, _, err := grpcCertificate(); err == nil {
+		t.Error("expected an error for a missing certificate file")
+	}
+}
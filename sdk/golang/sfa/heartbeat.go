@@ -0,0 +1,102 @@
+package sfa
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// defaultHeartbeatInterval is used when AgentDef.HeartbeatInterval is zero.
+const defaultHeartbeatInterval = 30 * time.Second
+
+// heartbeat emits a progress line whenever Execute has been silent for a full
+// interval, so orchestrators and humans can tell "working" from "hung".
+type heartbeat struct {
+	agentName string
+	interval  time.Duration
+	start     time.Time
+	last      atomic.Int64 // unix nanos of the last progress line
+	done      chan struct{}
+}
+
+// startHeartbeat begins monitoring. A negative interval disables heartbeats;
+// zero selects the default. The returned heartbeat is nil when disabled, and
+// its methods are nil-safe.
+func startHeartbeat(ctx context.Context, agentName string, interval time.Duration, start time.Time) *heartbeat {
+	if interval < 0 {
+		return nil
+	}
+	if interval == 0 {
+		interval = defaultHeartbeatInterval
+	}
+
+	h := &heartbeat{
+		agentName: agentName,
+		interval:  interval,
+		start:     start,
+		done:      make(chan struct{}),
+	}
+	h.touch()
+
+	go h.run(ctx)
+	return h
+}
+
+// run checks for silence at a fraction of the interval until stopped.
+func (h *heartbeat) run(ctx context.Context) {
+	tick := h.interval / 4
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, h.last.Load())) < h.interval {
+				continue
+			}
+			var remaining time.Duration
+			deadline, hasDeadline := ctx.Deadline()
+			if hasDeadline {
+				remaining = deadline.Sub(now)
+			}
+			emitProgress(h.agentName, formatHeartbeat(now.Sub(h.start), remaining, hasDeadline))
+			h.touch()
+		}
+	}
+}
+
+// touch records that progress was just emitted.
+func (h *heartbeat) touch() {
+	if h == nil {
+		return
+	}
+	h.last.Store(time.Now().UnixNano())
+}
+
+// stop ends monitoring.
+func (h *heartbeat) stop() {
+	if h == nil {
+		return
+	}
+	close(h.done)
+}
+
+// formatHeartbeat renders the heartbeat progress message.
+func formatHeartbeat(elapsed, remaining time.Duration, hasDeadline bool) string {
+	elapsed = elapsed.Round(time.Second)
+	if !hasDeadline {
+		return fmt.Sprintf("heartbeat: still working (%s elapsed)", elapsed)
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return fmt.Sprintf("heartbeat: still working (%s elapsed, %s until timeout)", elapsed, remaining.Round(time.Second))
+}
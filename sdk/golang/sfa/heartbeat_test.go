@@ -0,0 +1,78 @@
+package sfa
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureStderr runs fn with os.Stderr redirected and returns what was written.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	orig := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = orig }()
+
+	fn()
+
+	w.Close()
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+func TestHeartbeatEmitsWhenSilent(t *testing.T) {
+	out := captureStderr(t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		h := startHeartbeat(ctx, "test-agent", 50*time.Millisecond, time.Now())
+		time.Sleep(130 * time.Millisecond)
+		h.stop()
+	})
+
+	if !strings.Contains(out, "[agent:test-agent] heartbeat: still working") {
+		t.Errorf("expected heartbeat line, got %q", out)
+	}
+	if !strings.Contains(out, "until timeout") {
+		t.Errorf("expected remaining timeout in heartbeat, got %q", out)
+	}
+}
+
+func TestHeartbeatSuppressedByProgress(t *testing.T) {
+	out := captureStderr(t, func() {
+		h := startHeartbeat(context.Background(), "test-agent", 80*time.Millisecond, time.Now())
+		for i := 0; i < 6; i++ {
+			time.Sleep(25 * time.Millisecond)
+			h.touch()
+		}
+		h.stop()
+	})
+
+	if strings.Contains(out, "heartbeat") {
+		t.Errorf("expected no heartbeat while progress is flowing, got %q", out)
+	}
+}
+
+func TestHeartbeatDisabled(t *testing.T) {
+	h := startHeartbeat(context.Background(), "test-agent", -1, time.Now())
+	if h != nil {
+		t.Fatal("expected nil heartbeat for negative interval")
+	}
+	h.touch()
+	h.stop()
+}
+
+func TestFormatHeartbeat(t *testing.T) {
+	if got := formatHeartbeat(90*time.Second, 0, false); got != "heartbeat: still working (1m30s elapsed)" {
+		t.Errorf("unexpected message without deadline: %q", got)
+	}
+	if got := formatHeartbeat(45*time.Second, 75*time.Second, true); got != "heartbeat: still working (45s elapsed, 1m15s until timeout)" {
+		t.Errorf("unexpected message with deadline: %q", got)
+	}
+}
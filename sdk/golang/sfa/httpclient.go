@@ -0,0 +1,215 @@
+package sfa
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP client retry defaults.
+const (
+	httpMaxRetries   = 3
+	httpRetryBackoff = 500 * time.Millisecond
+	httpMaxBackoff   = 10 * time.Second
+)
+
+// HTTPClient returns an http.Client for use inside Execute. Requests are
+// bound to the execution context and deadline, retried with exponential
+// backoff on 429 and 5xx responses (honoring Retry-After), and carry an
+// Authorization header from any secret EnvDef whose AuthHost matches the
+// request host. With --verbose, each request and response is logged to
+// stderr at Debug level, with secret values masked.
+func HTTPClient(ctx *ExecuteContext) *http.Client {
+	t := &httpTransport{
+		base:       http.DefaultTransport,
+		ctx:        ctx.Ctx,
+		agentName:  ctx.AgentName,
+		verbose:    debugLogging(),
+		resolved:   ctx.resolved,
+		meta:       ctx.meta,
+		auth:       httpAuthHeaders(ctx.envDefs, ctx.resolved),
+		maxRetries: httpMaxRetries,
+		backoff:    httpRetryBackoff,
+	}
+	client := &http.Client{Transport: t}
+	if ctx.Ctx != nil {
+		if deadline, ok := ctx.Ctx.Deadline(); ok {
+			client.Timeout = time.Until(deadline)
+		}
+	}
+	return client
+}
+
+// httpAuthHeaders maps request hosts to Authorization header values built
+// from declared secrets.
+func httpAuthHeaders(defs []EnvDef, resolved *ResolvedEnv) map[string]string {
+	auth := make(map[string]string)
+	if resolved == nil {
+		return auth
+	}
+	for _, def := range defs {
+		if !def.Secret || def.AuthHost == "" {
+			continue
+		}
+		val := resolved.Values[def.Name]
+		if val == "" {
+			continue
+		}
+		scheme := def.AuthScheme
+		if scheme == "" {
+			scheme = "Bearer"
+		}
+		auth[strings.ToLower(def.AuthHost)] = scheme + " " + val
+	}
+	return auth
+}
+
+// httpTransport adds the execution context, secret injection, retries, and
+// masked logging around a base RoundTripper.
+type httpTransport struct {
+	base       http.RoundTripper
+	ctx        context.Context
+	agentName  string
+	verbose    bool
+	resolved   *ResolvedEnv
+	auth       map[string]string
+	maxRetries int
+	backoff    time.Duration
+	meta       *executionMeta // counts retries for the execution log
+}
+
+func (t *httpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Requests built without a context inherit the execution's cancellation
+	if t.ctx != nil && req.Context() == context.Background() {
+		req = req.WithContext(t.ctx)
+	}
+	if header, ok := t.auth[strings.ToLower(req.URL.Hostname())]; ok && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", header)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("cannot retry %s %s: request body is not replayable", req.Method, t.mask(req.URL.String()))
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		t.logRequest(req, attempt)
+		start := time.Now()
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			t.log(fmt.Sprintf("http: %s %s failed: %v", req.Method, t.mask(req.URL.String()), err))
+			return nil, err
+		}
+		t.log(fmt.Sprintf("http: %s %s -> %d (%s)", req.Method, t.mask(req.URL.String()), resp.StatusCode, time.Since(start).Round(time.Millisecond)))
+
+		if !retryableStatus(resp.StatusCode) || attempt >= t.maxRetries {
+			return resp, nil
+		}
+
+		delay := retryDelay(resp, attempt, t.backoff)
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < delay {
+			return resp, nil // not enough time left to wait and retry
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		t.log(fmt.Sprintf("http: retrying in %s (attempt %d of %d)", delay.Round(time.Millisecond), attempt+1, t.maxRetries))
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		t.meta.addRetry()
+	}
+}
+
+// retryableStatus reports whether a response status warrants a retry.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// retryDelay honors Retry-After (seconds or HTTP date), falling back to
+// exponential backoff.
+func retryDelay(resp *http.Response, attempt int, base time.Duration) time.Duration {
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.Atoi(ra); err == nil && secs >= 0 {
+			return min(time.Duration(secs)*time.Second, httpMaxBackoff)
+		}
+		if when, err := http.ParseTime(ra); err == nil {
+			return min(max(time.Until(when), 0), httpMaxBackoff)
+		}
+	}
+	return min(base<<attempt, httpMaxBackoff)
+}
+
+// logRequest writes the request line and headers at verbose level.
+func (t *httpTransport) logRequest(req *http.Request, attempt int) {
+	if !t.verbose {
+		return
+	}
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "http: %s %s", req.Method, t.mask(req.URL.String()))
+	if attempt > 0 {
+		fmt.Fprintf(&b, " (retry %d)", attempt)
+	}
+	for _, name := range names {
+		value := strings.Join(req.Header[name], ", ")
+		if sensitiveHeader(name) {
+			value = "***"
+		}
+		fmt.Fprintf(&b, "\n  %s: %s", name, t.mask(value))
+	}
+	t.log(b.String())
+}
+
+func (t *httpTransport) log(message string) {
+	if t.verbose {
+		stderrLog.Debug(message, "agent", t.agentName)
+	}
+}
+
+// mask removes secret values from text, including their URL-encoded forms.
+func (t *httpTransport) mask(text string) string {
+	if t.resolved == nil {
+		return text
+	}
+	text = maskSecrets(text, t.resolved)
+	for name := range t.resolved.Secrets {
+		if val := t.resolved.Values[name]; val != "" {
+			text = strings.ReplaceAll(text, url.QueryEscape(val), "***")
+		}
+	}
+	return text
+}
+
+// sensitiveHeader reports whether a header carries credentials regardless
+// of whether its value matches a declared secret.
+func sensitiveHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key":
+		return true
+	}
+	return false
+}
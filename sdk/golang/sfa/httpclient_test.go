@@ -0,0 +1,146 @@
+package sfa
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testHTTPContext(t *testing.T, serverURL string) *ExecuteContext {
+	t.Helper()
+	u, _ := url.Parse(serverURL)
+	return &ExecuteContext{
+		Ctx:       context.Background(),
+		AgentName: "test-agent",
+		envDefs: []EnvDef{
+			{Name: "API_TOKEN", Secret: true, AuthHost: u.Hostname()},
+			{Name: "OTHER_TOKEN", Secret: true, AuthHost: "elsewhere.example.com"},
+		},
+		resolved: &ResolvedEnv{
+			Values:  map[string]string{"API_TOKEN": "s3cret", "OTHER_TOKEN": "other"},
+			Secrets: map[string]bool{"API_TOKEN": true, "OTHER_TOKEN": true},
+		},
+	}
+}
+
+func TestHTTPClientInjectsAuthForMatchingHost(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	resp, err := HTTPClient(testHTTPContext(t, srv.URL)).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "Bearer s3cret" {
+		t.Errorf("expected injected bearer token, got %q", got)
+	}
+}
+
+func TestHTTPClientRetriesThenSucceeds(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "payload" {
+			t.Errorf("expected body replayed on retry, got %q", body)
+		}
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			io.WriteString(w, "ok")
+		}
+	}))
+	defer srv.Close()
+
+	ctx := testHTTPContext(t, srv.URL)
+	ctx.meta = &executionMeta{}
+	client := HTTPClient(ctx)
+	client.Transport.(*httpTransport).backoff = time.Millisecond
+
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls != 3 {
+		t.Errorf("expected success on third attempt, got %d after %d calls", resp.StatusCode, calls)
+	}
+	if retries := ctx.meta.snapshot()["retries"]; retries != 2 {
+		t.Errorf("expected 2 retries in the log metadata, got %v", retries)
+	}
+}
+
+func TestHTTPClientGivesUpAfterMaxRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := HTTPClient(testHTTPContext(t, srv.URL))
+	client.Transport.(*httpTransport).backoff = time.Millisecond
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls != httpMaxRetries+1 {
+		t.Errorf("expected final 503 after %d calls, got %d after %d", httpMaxRetries+1, resp.StatusCode, calls)
+	}
+}
+
+func TestHTTPClientVerboseLogMasksSecrets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	stderrLevel.Set(slog.LevelDebug)
+	defer stderrLevel.Set(slog.LevelInfo)
+
+	out := captureStderr(t, func() {
+		req, _ := http.NewRequest("GET", srv.URL+"/v1?key=s3cret", nil)
+		req.Header.Set("X-Trace", "s3cret-suffix")
+		resp, err := HTTPClient(testHTTPContext(t, srv.URL)).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+	if strings.Contains(out, "s3cret") {
+		t.Errorf("expected secrets masked in debug log, got %q", out)
+	}
+	for _, want := range []string{"http: GET", "Authorization: ***", "-> 200"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in debug log, got %q", want, out)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	if d := retryDelay(resp, 2, 100*time.Millisecond); d != 400*time.Millisecond {
+		t.Errorf("expected exponential backoff of 400ms, got %v", d)
+	}
+	resp.Header.Set("Retry-After", "3")
+	if d := retryDelay(resp, 0, time.Millisecond); d != 3*time.Second {
+		t.Errorf("expected Retry-After of 3s, got %v", d)
+	}
+	resp.Header.Set("Retry-After", "3600")
+	if d := retryDelay(resp, 0, time.Millisecond); d != httpMaxBackoff {
+		t.Errorf("expected Retry-After capped at %v, got %v", httpMaxBackoff, d)
+	}
+}
@@ -0,0 +1,88 @@
+package sfa
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// lastUsedFile, in an agent's services directory, holds when its persistent
+// services were last used, as RFC 3339.
+const lastUsedFile = "last-used"
+
+// recordServiceUse notes that an agent's persistent services are in use now.
+func recordServiceUse(agentName string) {
+	dir := dataDir("services", agentName)
+	if dir == "" {
+		return
+	}
+	_ = writeFileAtomic(filepath.Join(dir, lastUsedFile), []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0600)
+}
+
+// serviceLastUsed returns when an agent's persistent services were last
+// used, or false if they are not recorded.
+func serviceLastUsed(agentName string) (time.Time, bool) {
+	data, err := os.ReadFile(filepath.Join(dataDir("services", agentName), lastUsedFile))
+	if err != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	return t, err == nil
+}
+
+// serviceIdleTTL returns how long an agent's persistent services may go
+// unused: services.idleTTL in its namespace, else in defaults. Zero, for
+// no limit, if unset or invalid.
+func serviceIdleTTL(config map[string]any, agentName string) time.Duration {
+	var sections []any
+	if agents, ok := config["agents"].(map[string]any); ok {
+		if ns, ok := agents[agentName].(map[string]any); ok {
+			sections = append(sections, ns["services"])
+		}
+	}
+	if defaults, ok := config["defaults"].(map[string]any); ok {
+		sections = append(sections, defaults["services"])
+	}
+	for _, section := range sections {
+		s, _ := section.(map[string]any)
+		v, ok := s["idleTTL"].(string)
+		if !ok {
+			continue
+		}
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			stderrLog.Warn(fmt.Sprintf("ignoring invalid services.idleTTL %q for %s (want a duration such as 2h)", v, agentName))
+			return 0
+		}
+		return ttl
+	}
+	return 0
+}
+
+// reapIdleServices tears down the persistent services of other agents left
+// unused for longer than their idle TTL. Named volumes are kept, as with
+// --services-down.
+func reapIdleServices(agentName string, config map[string]any, now time.Time) {
+	entries, err := os.ReadDir(dataDir("services"))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || name == agentName {
+			continue
+		}
+		lastUsed, ok := serviceLastUsed(name)
+		if !ok {
+			continue
+		}
+		ttl := serviceIdleTTL(config, name)
+		if ttl == 0 || now.Sub(lastUsed) < ttl {
+			continue
+		}
+		emitProgress(agentName, fmt.Sprintf("stopping idle services of %s (unused for %s)", name, formatElapsed(now.Sub(lastUsed))))
+		composeDown(name, config)
+	}
+}
@@ -0,0 +1,62 @@
+package sfa
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServiceIdleTTL(t *testing.T) {
+	config := map[string]any{
+		"defaults": map[string]any{"services": map[string]any{"idleTTL": "2h"}},
+		"agents": map[string]any{
+			"db-agent":  map[string]any{"services": map[string]any{"idleTTL": "30m"}},
+			"bad-agent": map[string]any{"services": map[string]any{"idleTTL": "soon"}},
+		},
+	}
+	for agent, want := range map[string]time.Duration{
+		"db-agent":  30 * time.Minute,
+		"other":     2 * time.Hour,
+		"bad-agent": 0,
+	} {
+		if got := serviceIdleTTL(config, agent); got != want {
+			t.Errorf("serviceIdleTTL(%s) = %s, want %s", agent, got, want)
+		}
+	}
+	if got := serviceIdleTTL(nil, "db-agent"); got != 0 {
+		t.Errorf("expected no TTL without config, got %s", got)
+	}
+}
+
+func TestReapIdleServices(t *testing.T) {
+	t.Setenv("SFA_DATA_HOME", t.TempDir())
+	t.Setenv("SFA_CONTAINER_ENGINE", "")
+	t.Setenv("PATH", t.TempDir()) // compose down fails without touching a real engine
+	useInstalledCommands(t, "docker version", "docker compose version")
+
+	now := time.Now()
+	for agent, lastUsed := range map[string]time.Time{
+		"stale":   now.Add(-3 * time.Hour),
+		"fresh":   now.Add(-10 * time.Minute),
+		"current": now.Add(-3 * time.Hour),
+	} {
+		dir := dataDir("services", agent)
+		os.MkdirAll(dir, 0700)
+		os.WriteFile(filepath.Join(dir, "compose.yaml"), []byte("services: {}\n"), 0600)
+		os.WriteFile(filepath.Join(dir, lastUsedFile), []byte(lastUsed.UTC().Format(time.RFC3339)+"\n"), 0600)
+	}
+	config := map[string]any{"defaults": map[string]any{"services": map[string]any{"idleTTL": "1h"}}}
+
+	reapIdleServices("current", config, now)
+	for agent, want := range map[string]bool{"stale": false, "fresh": true, "current": true} {
+		if _, ok := serviceLastUsed(agent); ok != want {
+			t.Errorf("%s still recorded = %v, want %v", agent, ok, want)
+		}
+	}
+
+	recordServiceUse("stale")
+	if lastUsed, ok := serviceLastUsed("stale"); !ok || now.Sub(lastUsed) > time.Minute {
+		t.Errorf("expected recordServiceUse to record now, got %v, %v", lastUsed, ok)
+	}
+}
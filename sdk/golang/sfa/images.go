@@ -0,0 +1,74 @@
+package sfa
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imagePresent reports whether the engine already has an image; a variable
+// so tests can stand in for the engine.
+var imagePresent = func(engine containerEngine, image string) bool {
+	return engine.command("image", "inspect", image).Run() == nil
+}
+
+// pullImage pulls an image, returning the engine's output on failure; a
+// variable so tests can stand in for the engine.
+var pullImage = func(engine containerEngine, image string) error {
+	out, err := engine.command("pull", image).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// pullServiceImages pulls the images the services need and the engine does
+// not have, all at once rather than one after another as Podman's compose
+// does, reporting each service's pull as a progress line. Services sharing
+// an image pull it once; services built from source are left to
+// buildServices.
+func pullServiceImages(engine containerEngine, agentName string, services map[string]ServiceDef) error {
+	byImage := make(map[string][]string)
+	for _, name := range sortedKeys(services) {
+		if image := services[name].Image; image != "" && services[name].Build == nil {
+			byImage[image] = append(byImage[image], name)
+		}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+	)
+	for _, image := range sortedKeys(byImage) {
+		if imagePresent(engine, image) {
+			continue
+		}
+		names := strings.Join(byImage[image], ", ")
+		emitProgress(agentName, fmt.Sprintf("service %s: pulling %s", names, image))
+		wg.Add(1)
+		go func(image, names string) {
+			defer wg.Done()
+			start := time.Now()
+			if err := pullImage(engine, image); err != nil {
+				mu.Lock()
+				failed = append(failed, fmt.Sprintf("service %s: failed to pull %s: %v", names, image, err))
+				mu.Unlock()
+				return
+			}
+			emitProgress(agentName, fmt.Sprintf("service %s: pulled %s in %s", names, image, formatElapsed(time.Since(start))))
+		}(image, names)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("cannot start services:\n  • %s", strings.Join(failed, "\n  • "))
+	}
+	return nil
+}
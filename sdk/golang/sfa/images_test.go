@@ -0,0 +1,58 @@
+package sfa
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPullServiceImages(t *testing.T) {
+	origPresent, origPull := imagePresent, pullImage
+	t.Cleanup(func() { imagePresent, pullImage = origPresent, origPull })
+
+	var mu sync.Mutex
+	pulled := make(map[string]int)
+	active, maxActive := 0, 0
+	imagePresent = func(_ containerEngine, image string) bool { return image == "redis:7" }
+	pullImage = func(_ containerEngine, image string) error {
+		mu.Lock()
+		pulled[image]++
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		if image == "missing:1" {
+			return errors.New("manifest unknown")
+		}
+		return nil
+	}
+
+	services := map[string]ServiceDef{
+		"db":      {Image: "postgres:16"},
+		"replica": {Image: "postgres:16"},
+		"search":  {Image: "opensearch:2"},
+		"cache":   {Image: "redis:7"},
+	}
+	if err := pullServiceImages(containerEngine{name: EngineDocker}, "puller", services); err != nil {
+		t.Fatal(err)
+	}
+	if pulled["postgres:16"] != 1 || pulled["opensearch:2"] != 1 || pulled["redis:7"] != 0 {
+		t.Errorf("pulled = %v, want postgres and opensearch once each", pulled)
+	}
+	if maxActive != 2 {
+		t.Errorf("expected both pulls at once, got %d concurrent", maxActive)
+	}
+
+	services["broken"] = ServiceDef{Image: "missing:1"}
+	err := pullServiceImages(containerEngine{name: EngineDocker}, "puller", services)
+	if err == nil || !strings.Contains(err.Error(), "service broken: failed to pull missing:1: manifest unknown") {
+		t.Errorf("expected the failed pull reported, got %v", err)
+	}
+}
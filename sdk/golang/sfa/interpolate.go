@@ -0,0 +1,107 @@
+package sfa
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandConfig returns a copy of config with ${VAR} references in string
+// values expanded by lookup. ${VAR:-default} uses default when VAR is unset
+// or empty, and $${ is a literal ${. An unset reference without a default
+// expands to "" with a warning. Keys are never expanded.
+func expandConfig(config map[string]any, lookup func(string) (string, bool)) map[string]any {
+	return expandConfigValue("", config, lookup).(map[string]any)
+}
+
+func expandConfigValue(path string, v any, lookup func(string) (string, bool)) any {
+	switch v := v.(type) {
+	case string:
+		expanded, unset := expandVars(v, lookup)
+		for _, name := range unset {
+			stderrLog.Warn(fmt.Sprintf("config %s references unset variable %s", path, name))
+		}
+		return expanded
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, child := range v {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			out[k] = expandConfigValue(childPath, child, lookup)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, child := range v {
+			out[i] = expandConfigValue(fmt.Sprintf("%s[%d]", path, i), child, lookup)
+		}
+		return out
+	}
+	return v
+}
+
+// expandVars expands ${VAR} and ${VAR:-default} in s, returning the names
+// of unset variables that had no default. Malformed references are left as
+// written.
+func expandVars(s string, lookup func(string) (string, bool)) (string, []string) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	var unset []string
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), unset
+		}
+		if i > 0 && s[i-1] == '$' {
+			// $${ escapes a literal ${
+			b.WriteString(s[:i])
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			b.WriteString(s)
+			return b.String(), unset
+		}
+		ref := s[i+2 : i+end]
+		name, def, hasDefault := strings.Cut(ref, ":-")
+		if !validEnvName(name) {
+			b.WriteString(s[:i+end+1])
+			s = s[i+end+1:]
+			continue
+		}
+		b.WriteString(s[:i])
+		val, ok := lookup(name)
+		switch {
+		case ok && val != "":
+			b.WriteString(val)
+		case hasDefault:
+			b.WriteString(def)
+		case !ok:
+			unset = append(unset, name)
+		}
+		s = s[i+end+1:]
+	}
+}
+
+// configVarLookup resolves config references from the process environment,
+// then from the project's .env files, which are read only if needed.
+func configVarLookup() func(string) (string, bool) {
+	var dotenv map[string]string
+	return func(name string) (string, bool) {
+		if val, ok := os.LookupEnv(name); ok {
+			return val, true
+		}
+		if dotenv == nil {
+			dotenv = loadDotenv()
+		}
+		val, ok := dotenv[name]
+		return val, ok
+	}
+}
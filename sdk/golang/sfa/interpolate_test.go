@@ -0,0 +1,63 @@
+package sfa
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{"API_HOST": "https://api.example.com", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	cases := []struct {
+		in, want string
+		unset    []string
+	}{
+		{"${API_HOST}/v1", "https://api.example.com/v1", nil},
+		{"${MISSING:-fallback}", "fallback", nil},
+		{"${EMPTY:-fallback}", "fallback", nil},
+		{"${EMPTY}", "", nil},
+		{"a${MISSING}b", "ab", []string{"MISSING"}},
+		{"$${API_HOST}", "${API_HOST}", nil},
+		{"price $5 and ${not valid}", "price $5 and ${not valid}", nil},
+		{"unterminated ${API_HOST", "unterminated ${API_HOST", nil},
+		{"${API_HOST}${API_HOST}", "https://api.example.comhttps://api.example.com", nil},
+	}
+	for _, c := range cases {
+		got, unset := expandVars(c.in, lookup)
+		if got != c.want || strings.Join(unset, ",") != strings.Join(c.unset, ",") {
+			t.Errorf("expandVars(%q) = %q %v, want %q %v", c.in, got, unset, c.want, c.unset)
+		}
+	}
+}
+
+func TestLoadLayeredConfigExpands(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := writeTestFile(path, `{"agents": {"a": {"baseUrl": "${TEST_INTERP_HOST}/v1", "hosts": ["${TEST_INTERP_HOST}"], "raw": "$${TEST_INTERP_HOST}", "env": {"FROM_DOTENV": "${TEST_INTERP_DOTENV}"}}}}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(filepath.Join(dir, ".env"), "TEST_INTERP_DOTENV=dotenv-value\n"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SFA_CONFIG", path)
+	t.Setenv("TEST_INTERP_HOST", "http://localhost:8080")
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	ns := mergeConfig(loadLayeredConfig(), "a")
+	if ns["baseUrl"] != "http://localhost:8080/v1" || ns["hosts"].([]any)[0] != "http://localhost:8080" || ns["raw"] != "${TEST_INTERP_HOST}" {
+		t.Errorf("unexpected expansion: %v", ns)
+	}
+	if env := agentEnvNamespace(loadLayeredConfig(), "a"); env["FROM_DOTENV"] != "dotenv-value" {
+		t.Errorf("expected .env fallback, got %v", env["FROM_DOTENV"])
+	}
+	if raw := mergeConfig(loadConfig(), "a"); raw["baseUrl"] != "${TEST_INTERP_HOST}/v1" {
+		t.Errorf("expected loadConfig to stay unexpanded, got %v", raw["baseUrl"])
+	}
+}
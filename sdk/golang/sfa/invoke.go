@@ -4,14 +4,20 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 	"syscall"
 	"time"
 )
 
-// invokeAgent spawns a subagent as a subprocess with proper env propagation and timeout.
-func invokeAgent(agentName string, safety *SafetyState, parentCtx context.Context, opts *InvokeOpts) (*InvokeResult, error) {
+// invokeAgent spawns a subagent as a subprocess with proper env propagation and timeout,
+// recording the invocation in meta for the parent's log entry.
+func invokeAgent(agentName string, safety *SafetyState, costs *costTracker, meta *executionMeta, parentCtx context.Context, opts *InvokeOpts) (*InvokeResult, error) {
+	if sandboxActive() {
+		return nil, sandboxForbids("invoke subagents")
+	}
+
 	// Check depth limit
 	if err := checkDepthLimit(safety); err != nil {
 		return nil, err
@@ -22,15 +28,49 @@ func invokeAgent(agentName string, safety *SafetyState, parentCtx context.Contex
 		return nil, err
 	}
 
+	span := startSpan(parentCtx, "sfa.invoke "+agentName)
+	span.setAttr("sfa.agent.target", agentName)
+	span.setAttr("sfa.depth", safety.Depth+1)
+
 	// Build environment
 	env := buildSubagentEnv()
 
+	// Propagate trace context so the child's spans join this trace
+	if tp := traceparentFor(contextWithSpan(parentCtx, span)); tp != "" {
+		env["TRACEPARENT"] = tp
+	}
+
 	// Override with incremented safety env vars
 	safetyEnv := buildSubagentSafetyEnv(safety)
 	for k, v := range safetyEnv {
 		env[k] = v
 	}
 
+	// Pass the remaining budget down and collect the child's costs via a report file
+	if remaining := costs.remaining(); len(remaining) > 0 {
+		env["SFA_BUDGET"] = formatCosts(remaining)
+	}
+	costFile, err := os.CreateTemp("", "sfa-cost-*.json")
+	if err != nil {
+		err = fmt.Errorf("failed to create cost report file: %w", err)
+		span.finish(err)
+		return nil, err
+	}
+	costFile.Close()
+	defer os.Remove(costFile.Name())
+	env["SFA_COST_FILE"] = costFile.Name()
+
+	// The child reports its version and its own invocations via a second file
+	reportFile, err := os.CreateTemp("", "sfa-invocation-*.json")
+	if err != nil {
+		err = fmt.Errorf("failed to create invocation report file: %w", err)
+		span.finish(err)
+		return nil, err
+	}
+	reportFile.Close()
+	defer os.Remove(reportFile.Name())
+	env["SFA_INVOCATION_FILE"] = reportFile.Name()
+
 	// Build env slice
 	envSlice := make([]string, 0, len(env))
 	for k, v := range env {
@@ -71,7 +111,8 @@ func invokeAgent(agentName string, safety *SafetyState, parentCtx context.Contex
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	// Run
-	err := cmd.Run()
+	record := meta.beginInvocation(agentName)
+	err = cmd.Run()
 
 	result := &InvokeResult{
 		Output: stdout.String(),
@@ -84,10 +125,24 @@ func invokeAgent(agentName string, safety *SafetyState, parentCtx context.Contex
 		} else if ctx.Err() == context.DeadlineExceeded {
 			result.ExitCode = ExitTimeout
 		} else {
-			return nil, fmt.Errorf("failed to invoke %s: %w", agentName, err)
+			err = fmt.Errorf("failed to invoke %s: %w", agentName, err)
+			span.finish(err)
+			return nil, err
 		}
 	}
 
+	// Fold the child's costs into ours; going over budget cancels the parent context
+	result.Cost = readCostReport(costFile.Name())
+	_ = costs.add(result.Cost)
+
 	result.OK = result.ExitCode == 0
+	record(result.ExitCode, readInvocationReport(reportFile.Name()))
+
+	span.setAttr("sfa.exit_code", result.ExitCode)
+	if result.OK {
+		span.finish(nil)
+	} else {
+		span.finish(fmt.Errorf("%s exited with code %d", agentName, result.ExitCode))
+	}
 	return result, nil
 }
@@ -1,22 +1,26 @@
 package sfa
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestInvokeDepthLimitReached(t *testing.T) {
 	safety := &SafetyState{Depth: 4, MaxDepth: 5, CallChain: []string{"a", "b", "c", "d", "e"}}
 
-	_, err := invokeAgent("target", safety, nil, nil)
+	_, err := invokeAgent("target", safety, nil, nil, nil, nil)
 	if err == nil {
 		t.Fatal("expected depth limit error")
 	}
 }
 
 func TestInvokeLoopDetected(t *testing.T) {
-	safety := &SafetyState{Depth: 1, MaxDepth: 5, CallChain: []string{"parent", "child"}}
+	safety := &SafetyState{Depth: 1, MaxDepth: 5, CallChain: []string{"parent", "child"}, LoopBound: &LoopPolicy{Mode: LoopDeny}}
 
-	_, err := invokeAgent("parent", safety, nil, nil)
+	_, err := invokeAgent("parent", safety, nil, nil, nil, nil)
 	if err == nil {
 		t.Fatal("expected loop detection error")
 	}
@@ -35,3 +39,59 @@ func TestBuildSubagentSafetyEnvIncrementsDepth(t *testing.T) {
 		t.Errorf("expected depth 3, got %s", env["SFA_DEPTH"])
 	}
 }
+
+func TestInvokeRecordsInvocationTree(t *testing.T) {
+	dir := t.TempDir()
+	report := `{"agent":"summarizer","version":"1.2.0","invocations":[{"seq":1,"agent":"fetcher","version":"0.3.0","exitCode":0,"durationMs":5,"offsetMs":1}]}`
+	child := filepath.Join(dir, "summarizer")
+	script := "#!/bin/sh\nprintf '%s' '" + report + "' > \"$SFA_INVOCATION_FILE\"\nexit 2\n"
+	if err := os.WriteFile(child, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "plain")
+	if err := os.WriteFile(plain, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := &executionMeta{started: time.Now()}
+	safety := &SafetyState{MaxDepth: 5, CallChain: []string{"orchestrator"}, SessionID: "s1"}
+	for _, agent := range []string{child, plain} {
+		if _, err := invokeAgent(agent, safety, nil, meta, context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recs, _ := meta.snapshot()["invocations"].([]invocationRecord)
+	if len(recs) != 2 {
+		t.Fatalf("invocations = %+v", recs)
+	}
+	first, second := recs[0], recs[1]
+	if first.Seq != 1 || first.Agent != "summarizer" || first.Version != "1.2.0" || first.ExitCode != 2 {
+		t.Errorf("first invocation = %+v", first)
+	}
+	if len(first.Invocations) != 1 || first.Invocations[0].Agent != "fetcher" || first.Invocations[0].Version != "0.3.0" {
+		t.Errorf("nested invocations = %+v", first.Invocations)
+	}
+	// An executable that writes no report is recorded without a version
+	if second.Seq != 2 || second.Agent != plain || second.Version != "" || second.ExitCode != 0 || second.OffsetMs < first.OffsetMs {
+		t.Errorf("second invocation = %+v", second)
+	}
+}
+
+func TestWriteInvocationReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	t.Setenv("SFA_INVOCATION_FILE", path)
+
+	meta := &executionMeta{}
+	meta.beginInvocation("fetcher")(0, &invocationReport{Version: "0.3.0"})
+	meta.writeInvocationReport("summarizer", "1.2.0")
+
+	report := readInvocationReport(path)
+	if report == nil || report.Agent != "summarizer" || report.Version != "1.2.0" ||
+		len(report.Invocations) != 1 || report.Invocations[0].Agent != "fetcher" {
+		t.Errorf("report = %+v", report)
+	}
+	if readInvocationReport(filepath.Join(t.TempDir(), "missing.json")) != nil {
+		t.Error("expected no report from a missing file")
+	}
+}
@@ -0,0 +1,62 @@
+package sfa
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringService is the service (or target prefix) under which secrets are
+// stored in the OS keychain; each item's account is "<agent>/<VAR>".
+const keyringService = "single-file-agents"
+
+// errKeyringNotFound means the keychain has no item for the account.
+var errKeyringNotFound = errors.New("not found in keyring")
+
+// secretStore reads and writes keychain items.
+type secretStore interface {
+	get(service, account string) (string, error)
+	set(service, account, value string) error
+}
+
+// keyring is the platform keychain: macOS Keychain, libsecret, or Windows
+// Credential Manager. Tests replace it.
+var keyring secretStore = systemKeyring{}
+
+func keyringAccount(agentName, varName string) string {
+	return agentName + "/" + varName
+}
+
+// lookupKeyring returns the keychain value for an agent's env var. A
+// missing item is not an error; an unavailable keychain is.
+func lookupKeyring(agentName, varName string) (string, bool, error) {
+	val, err := keyring.get(keyringService, keyringAccount(agentName, varName))
+	if errors.Is(err, errKeyringNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("keyring lookup for %s failed: %w", varName, err)
+	}
+	return val, val != "", nil
+}
+
+// storeKeyring saves an agent's env var in the keychain.
+func storeKeyring(agentName, varName, value string) error {
+	if err := keyring.set(keyringService, keyringAccount(agentName, varName), value); err != nil {
+		return fmt.Errorf("failed to store %s in keyring: %w", varName, err)
+	}
+	return nil
+}
+
+// keyringToolError describes a failed keychain command, distinguishing a
+// missing tool from a failure it reported.
+func keyringToolError(err error, stderr string) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("keyring tool is not installed: %w", err)
+	}
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+package sfa
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+)
+
+// systemKeyring uses the Secret Service (GNOME Keyring, KWallet) through
+// libsecret's secret-tool.
+type systemKeyring struct{}
+
+func (systemKeyring) get(service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// secret-tool exits 1 with no output when nothing matches
+		if _, ok := err.(*exec.ExitError); ok && stderr.Len() == 0 {
+			return "", errKeyringNotFound
+		}
+		return "", keyringToolError(err, stderr.String())
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (systemKeyring) set(service, account, value string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "store", "--label="+service+": "+account, "service", service, "account", account)
+	cmd.Stdin = strings.NewReader(value)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return keyringToolError(err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !linux && !windows
+
+package sfa
+
+import "errors"
+
+// systemKeyring reports that no keychain is available on this platform.
+type systemKeyring struct{}
+
+var errKeyringUnsupported = errors.New("no OS keyring is supported on this platform")
+
+func (systemKeyring) get(service, account string) (string, error) {
+	return "", errKeyringUnsupported
+}
+
+func (systemKeyring) set(service, account, value string) error {
+	return errKeyringUnsupported
+}
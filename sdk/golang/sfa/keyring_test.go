@@ -0,0 +1,113 @@
+package sfa
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeKeyring is an in-memory secretStore.
+type fakeKeyring struct {
+	items map[string]string
+	err   error
+}
+
+func (f *fakeKeyring) get(service, account string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	val, ok := f.items[service+"|"+account]
+	if !ok {
+		return "", errKeyringNotFound
+	}
+	return val, nil
+}
+
+func (f *fakeKeyring) set(service, account, value string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.items[service+"|"+account] = value
+	return nil
+}
+
+func useFakeKeyring(t *testing.T) *fakeKeyring {
+	t.Helper()
+	fake := &fakeKeyring{items: map[string]string{}}
+	prev := keyring
+	keyring = fake
+	t.Cleanup(func() { keyring = prev })
+	return fake
+}
+
+func TestResolveEnvKeyring(t *testing.T) {
+	fake := useFakeKeyring(t)
+	if err := storeKeyring("kr-agent", "KR_TOKEN", "from-keyring"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.items["single-file-agents|kr-agent/KR_TOKEN"] != "from-keyring" {
+		t.Fatalf("unexpected keyring items: %v", fake.items)
+	}
+
+	decls := []EnvDef{
+		{Name: "KR_TOKEN", Source: "keyring"},
+		{Name: "KR_PLAIN"}, // not opted in: the keyring is never consulted
+	}
+	fake.items["single-file-agents|kr-agent/KR_PLAIN"] = "ignored"
+	config := map[string]any{
+		"agents": map[string]any{
+			"kr-agent": map[string]any{
+				"env": map[string]any{"KR_TOKEN": "from-config", "KR_PLAIN": "from-config"},
+			},
+		},
+	}
+
+	resolved := resolveEnv(decls, "kr-agent", config)
+	if resolved.Values["KR_TOKEN"] != "from-keyring" || resolved.Sources["KR_TOKEN"] != envSourceKeyring {
+		t.Errorf("expected keyring to beat config, got %q from %q", resolved.Values["KR_TOKEN"], resolved.Sources["KR_TOKEN"])
+	}
+	if !resolved.Secrets["KR_TOKEN"] {
+		t.Error("expected keyring values to be masked as secrets")
+	}
+	if resolved.Values["KR_PLAIN"] != "from-config" {
+		t.Errorf("expected config value for a var without Source, got %q", resolved.Values["KR_PLAIN"])
+	}
+
+	os.Setenv("KR_TOKEN", "from-env")
+	defer os.Unsetenv("KR_TOKEN")
+	resolved = resolveEnv(decls, "kr-agent", config)
+	if resolved.Values["KR_TOKEN"] != "from-env" {
+		t.Errorf("expected process env to beat keyring, got %q", resolved.Values["KR_TOKEN"])
+	}
+}
+
+func TestResolveEnvKeyringUnavailable(t *testing.T) {
+	fake := useFakeKeyring(t)
+	fake.err = errors.New("secret-tool: command not found")
+
+	decls := []EnvDef{{Name: "KR_MISSING", Source: "keyring", Default: "fallback"}}
+	var resolved *ResolvedEnv
+	stderr := captureStderr(t, func() {
+		resolved = resolveEnv(decls, "kr-agent", map[string]any{})
+	})
+	if resolved.Values["KR_MISSING"] != "fallback" {
+		t.Errorf("expected fallback to later tiers, got %q", resolved.Values["KR_MISSING"])
+	}
+	if !strings.Contains(stderr, "keyring lookup for KR_MISSING failed") {
+		t.Errorf("expected a warning, got %q", stderr)
+	}
+
+	if err := storeKeyring("kr-agent", "KR_MISSING", "x"); err == nil {
+		t.Error("expected store to fail when the keyring is unavailable")
+	}
+}
+
+func TestDescribeEnvSource(t *testing.T) {
+	def := &AgentDef{Name: "kr-agent", Env: []EnvDef{{Name: "KR_TOKEN", Source: "keyring"}}}
+	desc := generateDescribe(def, map[string]string{"KR_TOKEN": "s3cret"}, map[string]bool{"KR_TOKEN": true})
+	entry := desc["env"].([]map[string]any)[0]
+	if entry["source"] != "keyring" || entry["value"] != "***" {
+		t.Errorf("unexpected describe env entry: %v", entry)
+	}
+}
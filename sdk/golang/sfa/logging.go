@@ -1,28 +1,47 @@
 package sfa
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 // LogEntry is a single JSONL log entry for an agent execution.
 type LogEntry struct {
-	Timestamp     string         `json:"timestamp"`
-	Agent         string         `json:"agent"`
-	Version       string         `json:"version"`
-	ExitCode      int            `json:"exitCode"`
-	DurationMs    int64          `json:"durationMs"`
-	Depth         int            `json:"depth"`
-	CallChain     []string       `json:"callChain"`
-	InputSummary  string         `json:"inputSummary"`
-	OutputSummary string         `json:"outputSummary"`
-	SessionID     string         `json:"sessionId"`
-	Meta          map[string]any `json:"meta,omitempty"`
+	SchemaVersion   int            `json:"schemaVersion"` // see LogSchemaVersion
+	Timestamp       string         `json:"timestamp"`
+	Agent           string         `json:"agent"`
+	Version         string         `json:"version"`
+	ExitCode        int            `json:"exitCode"`
+	DurationMs      int64          `json:"durationMs"`
+	Depth           int            `json:"depth"`
+	CallChain       []string       `json:"callChain"`
+	CallChainDetail []string       `json:"callChainDetail,omitempty"`
+	InputSummary    string         `json:"inputSummary"`
+	OutputSummary   string         `json:"outputSummary"`
+	SessionID       string         `json:"sessionId"`
+	Meta            map[string]any `json:"meta,omitempty"`
+}
+
+// LogQuery selects the execution log entries QueryLogs returns. Zero fields
+// match every entry.
+type LogQuery struct {
+	Agent     string
+	SessionID string
+	Since     time.Time // entries logged at or after it; zero means any
+	ExitCode  *int      // entries of executions that exited with it; nil means any
+	Limit     int       // at most the newest this many; 0 means all
 }
 
 // LoggingConfig controls execution log behavior.
@@ -31,6 +50,12 @@ type LoggingConfig struct {
 	Suppressed   bool
 	MaxSizeBytes int64
 	RetainCount  int
+	PerAgent     bool      // also log each agent's entries to its own file
+	PerAgentOnly bool      // log them to the agent's file instead of FilePath
+	Sinks        []LogSink // besides the log file: logging.sinks, then AgentDef.LogSinks
+	// FlushInterval buffers entries, appending them at most this long
+	// after they are logged; 0 appends each entry as it is logged.
+	FlushInterval time.Duration
 }
 
 const (
@@ -51,39 +76,75 @@ func resolveLoggingConfig(config map[string]any, noLogFlag bool) *LoggingConfig
 		return lc
 	}
 
-	// Resolve file path
-	if p := os.Getenv("SFA_LOG_FILE"); p != "" {
-		lc.FilePath = p
-	} else if logging, ok := config["logging"]; ok {
-		if lm, ok := logging.(map[string]any); ok {
-			if f, ok := lm["file"].(string); ok {
-				lc.FilePath = f
-			}
-			if ms, ok := lm["maxSize"].(float64); ok {
-				lc.MaxSizeBytes = int64(ms) * 1024 * 1024
-			}
-			if rc, ok := lm["retainFiles"].(float64); ok {
-				lc.RetainCount = int(rc)
-			}
+	if lm, ok := config["logging"].(map[string]any); ok {
+		if ms, ok := lm["maxSize"].(float64); ok {
+			lc.MaxSizeBytes = int64(ms) * 1024 * 1024
+		}
+		if rc, ok := lm["retainFiles"].(float64); ok {
+			lc.RetainCount = int(rc)
 		}
 	}
+	lc.PerAgent, lc.PerAgentOnly = resolveLogPerAgent(config)
+	lc.FlushInterval = resolveLogBuffer(config)
 
+	lc.FilePath = resolveLogFile(config)
 	if lc.FilePath == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			lc.Suppressed = true
-			return lc
+		lc.Suppressed = true
+	}
+	lc.Sinks = resolveLogSinks(config)
+	return lc
+}
+
+// resolveLogFile returns the execution log path, whether or not logging is
+// suppressed. Priority: SFA_LOG_FILE env > config logging.file > default.
+func resolveLogFile(config map[string]any) string {
+	if p := os.Getenv("SFA_LOG_FILE"); p != "" {
+		return p
+	}
+	if lm, ok := config["logging"].(map[string]any); ok {
+		if f, ok := lm["file"].(string); ok && f != "" {
+			return f
+		}
+	}
+	return dataDir("logs", "executions.jsonl")
+}
+
+// resolveLogPerAgent reads logging.perAgent: true logs each agent's entries
+// to its own file as well as the shared log, and "only" to its own file
+// alone.
+func resolveLogPerAgent(config map[string]any) (perAgent, only bool) {
+	lm, _ := config["logging"].(map[string]any)
+	switch v := lm["perAgent"].(type) {
+	case bool:
+		return v, false
+	case string:
+		if v == "only" {
+			return true, true
 		}
-		lc.FilePath = filepath.Join(home, ".local", "share", "single-file-agents", "logs", "executions.jsonl")
+		stderrLog.Warn(fmt.Sprintf("ignoring invalid logging.perAgent %q (use true, false, or \"only\")", v))
 	}
+	return false, false
+}
 
-	return lc
+// agentLogFile returns the file an agent's entries are logged to with
+// logging.perAgent: a directory named for the agent beside the shared log,
+// holding a file of the same name, as in logs/<agent>/executions.jsonl.
+func agentLogFile(path, agent string) string {
+	return filepath.Join(filepath.Dir(path), agent, filepath.Base(path))
 }
 
-// createLogEntry builds a log entry from execution data.
+// createLogEntry builds a log entry from execution data, masking the
+// agent's secret values in the input and output summaries.
 func createLogEntry(agent, version string, exitCode int, startTime time.Time,
-	depth int, chain []string, sessionID, input, output string) *LogEntry {
+	depth int, chain []string, sessionID, input, output string, resolved *ResolvedEnv) *LogEntry {
+	if resolved != nil {
+		input, output = maskSecrets(input, resolved), maskSecrets(output, resolved)
+	}
+	if chain == nil {
+		chain = []string{}
+	}
 	return &LogEntry{
+		SchemaVersion: LogSchemaVersion,
 		Timestamp:     time.Now().UTC().Format(time.RFC3339),
 		Agent:         agent,
 		Version:       version,
@@ -97,64 +158,330 @@ func createLogEntry(agent, version string, exitCode int, startTime time.Time,
 	}
 }
 
-// writeLogEntry appends a log entry to the log file.
-// Best-effort: failures are warned to stderr but don't affect the exit code.
-func writeLogEntry(entry *LogEntry, config *LoggingConfig) {
-	if config.Suppressed {
+// executionMeta collects the metadata of an execution's log entry: the
+// keys the agent sets with SetMeta, the HTTP retries of its HTTPClient, and
+// the subagents it invokes. Methods are nil-safe.
+type executionMeta struct {
+	mu          sync.Mutex
+	started     time.Time
+	values      map[string]any
+	retries     int
+	invoked     int
+	invocations []invocationRecord
+}
+
+// invocationRecord is one subagent invocation in its parent's log entry,
+// under meta.invocations, with the subagent's own invocations nested.
+type invocationRecord struct {
+	Seq         int                `json:"seq"` // order the invocation started in among its parent's
+	Agent       string             `json:"agent"`
+	Version     string             `json:"version,omitempty"`
+	ExitCode    int                `json:"exitCode"`
+	DurationMs  int64              `json:"durationMs"`
+	OffsetMs    int64              `json:"offsetMs"` // from the start of the parent's execution
+	Invocations []invocationRecord `json:"invocations,omitempty"`
+}
+
+// invocationReport is what a subagent writes to SFA_INVOCATION_FILE as it
+// exits, for its parent to complete the subagent's invocationRecord.
+type invocationReport struct {
+	Agent       string             `json:"agent"`
+	Version     string             `json:"version"`
+	Invocations []invocationRecord `json:"invocations,omitempty"`
+}
+
+// set records a key of the agent's metadata. A value that cannot be
+// encoded as JSON is refused with a warning, as it would keep the entry
+// from being written.
+func (m *executionMeta) set(key string, value any) {
+	if m == nil {
+		return
+	}
+	if _, err := json.Marshal(value); err != nil {
+		stderrLog.Warn(fmt.Sprintf("ignoring log metadata %q: %v", key, err))
 		return
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values == nil {
+		m.values = make(map[string]any)
+	}
+	m.values[key] = value
+}
 
-	// Create log directory
-	dir := filepath.Dir(config.FilePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "warning: failed to create log directory: %v\n", err)
+// addRetry counts a request HTTPClient retried.
+func (m *executionMeta) addRetry() {
+	if m == nil {
 		return
 	}
+	m.mu.Lock()
+	m.retries++
+	m.mu.Unlock()
+}
 
-	// Check if rotation is needed
-	if info, err := os.Stat(config.FilePath); err == nil {
-		if info.Size() >= config.MaxSizeBytes {
-			rotateLog(config)
+// beginInvocation numbers an invocation of agent as it starts, returning
+// the function that records it once the subagent exits, completed by the
+// subagent's report if it wrote one.
+func (m *executionMeta) beginInvocation(agent string) func(exitCode int, report *invocationReport) {
+	if m == nil {
+		return func(int, *invocationReport) {}
+	}
+	start := time.Now()
+	m.mu.Lock()
+	m.invoked++
+	rec := invocationRecord{Seq: m.invoked, Agent: agent}
+	if !m.started.IsZero() {
+		rec.OffsetMs = start.Sub(m.started).Milliseconds()
+	}
+	m.mu.Unlock()
+	return func(exitCode int, report *invocationReport) {
+		rec.ExitCode = exitCode
+		rec.DurationMs = time.Since(start).Milliseconds()
+		if report != nil {
+			if report.Agent != "" {
+				rec.Agent = report.Agent
+			}
+			rec.Version = report.Version
+			rec.Invocations = report.Invocations
 		}
+		m.mu.Lock()
+		m.invocations = append(m.invocations, rec)
+		m.mu.Unlock()
 	}
+}
+
+// sortedInvocations returns the invocations recorded so far, in the order
+// they started. The caller holds m.mu.
+func (m *executionMeta) sortedInvocations() []invocationRecord {
+	if len(m.invocations) == 0 {
+		return nil
+	}
+	recs := append([]invocationRecord(nil), m.invocations...)
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Seq < recs[j].Seq })
+	return recs
+}
 
-	// Marshal entry
-	data, err := json.Marshal(entry)
+// writeInvocationReport writes the agent's version and invocations to
+// SFA_INVOCATION_FILE, if set, so the parent agent can record them.
+func (m *executionMeta) writeInvocationReport(agent, version string) {
+	path := os.Getenv("SFA_INVOCATION_FILE")
+	if path == "" {
+		return
+	}
+	report := invocationReport{Agent: agent, Version: version}
+	if m != nil {
+		m.mu.Lock()
+		report.Invocations = m.sortedInvocations()
+		m.mu.Unlock()
+	}
+	data, err := json.Marshal(report)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "warning: failed to marshal log entry: %v\n", err)
 		return
 	}
-	data = append(data, '\n')
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to write invocation report: %v", err))
+	}
+}
+
+// readInvocationReport reads the report a subagent's writeInvocationReport
+// wrote, or nil if it wrote none.
+func readInvocationReport(path string) *invocationReport {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var report invocationReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil
+	}
+	return &report
+}
+
+// snapshot returns the metadata collected so far, with "retries" when any
+// request was retried and "invocations" when subagents were invoked, for
+// the SDK to add its own keys to.
+func (m *executionMeta) snapshot() map[string]any {
+	meta := make(map[string]any)
+	if m == nil {
+		return meta
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, v := range m.values {
+		meta[k] = v
+	}
+	if m.retries > 0 {
+		meta["retries"] = m.retries
+	}
+	if len(m.invocations) > 0 {
+		meta["invocations"] = m.sortedInvocations()
+	}
+	return meta
+}
+
+// maskLogMeta masks the agent's secret values in the strings of an entry's
+// metadata, however deeply they are nested in maps and lists.
+func maskLogMeta(meta map[string]any, resolved *ResolvedEnv) map[string]any {
+	if resolved == nil || len(meta) == 0 {
+		return meta
+	}
+	masked := make(map[string]any, len(meta))
+	for k, v := range meta {
+		masked[k] = maskLogValue(v, resolved)
+	}
+	return masked
+}
+
+func maskLogValue(v any, resolved *ResolvedEnv) any {
+	switch v := v.(type) {
+	case string:
+		return maskSecrets(v, resolved)
+	case []string:
+		masked := make([]string, len(v))
+		for i, s := range v {
+			masked[i] = maskSecrets(s, resolved)
+		}
+		return masked
+	case []any:
+		masked := make([]any, len(v))
+		for i, item := range v {
+			masked[i] = maskLogValue(item, resolved)
+		}
+		return masked
+	case map[string]any:
+		return maskLogMeta(v, resolved)
+	}
+	return v
+}
+
+// writeLogEntry writes a log entry to each sink of config: the log file,
+// then the sinks of logging.sinks and AgentDef.LogSinks.
+// Best-effort: failures are warned to stderr but don't affect the exit code.
+func writeLogEntry(entry *LogEntry, config *LoggingConfig) {
+	if config.Suppressed {
+		return
+	}
+	for _, sink := range logSinks(config) {
+		if err := sink.Write(entry); err != nil {
+			stderrLog.Warn(fmt.Sprintf("failed to write execution log to %s: %v", sinkName(sink), err))
+		}
+	}
+}
+
+// appendLogEntry appends marshaled entries to the log file at path,
+// rotating it first once it has reached the maximum size.
+func appendLogEntry(path string, data []byte, config *LoggingConfig) {
+	// Create log directory
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to create log directory: %v", err))
+		return
+	}
+
+	// Rotate if needed; if that fails, the entry is appended all the same
+	if err := rotateLogIfFull(path, config); err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to rotate execution log, appending to it: %v", err))
+	}
 
 	// Append to file. O_APPEND writes are atomic at the kernel level for
-	// sizes under PIPE_BUF (typically 4KB), which JSONL entries always are.
-	f, err := os.OpenFile(config.FilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	// sizes under PIPE_BUF (typically 4KB), which JSONL entries always are;
+	// a buffered batch is one write at the end of the file, after any lines
+	// other processes appended, on local filesystems.
+	f, err := openLogFile(path)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "warning: failed to open log file: %v\n", err)
+		stderrLog.Warn(fmt.Sprintf("failed to open log file: %v", err))
 		return
 	}
 	defer f.Close()
 
 	if _, err := f.Write(data); err != nil {
-		fmt.Fprintf(os.Stderr, "warning: failed to write log entry: %v\n", err)
+		stderrLog.Warn(fmt.Sprintf("failed to write log entry: %v", err))
 	}
 }
 
-// rotateLog rotates the log file, keeping up to retainCount old files.
-func rotateLog(config *LoggingConfig) {
-	dir := filepath.Dir(config.FilePath)
-	base := filepath.Base(config.FilePath)
+// openLogFile opens the log file at path for appending, holding a shared
+// lock on it until it is closed. Rotation takes an exclusive lock on the
+// file once it has renamed it, so a writer that opened it before the rename
+// finishes before it is compressed, and one that gets the lock after finds
+// the file no longer at path and opens path again. The lock is advisory and
+// best-effort: where it cannot be taken the file is written without it.
+func openLogFile(path string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if syscall.Flock(int(f.Fd()), syscall.LOCK_SH) != nil {
+			return f, nil
+		}
+		opened, ferr := f.Stat()
+		current, perr := os.Stat(path)
+		if ferr != nil || (perr == nil && os.SameFile(opened, current)) {
+			return f, nil
+		}
+		if perr != nil && !errors.Is(perr, fs.ErrNotExist) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// logRotateLockStale is how old a rotation lock must be to be taken as left
+// behind by a writer that crashed while rotating.
+const logRotateLockStale = 30 * time.Second
+
+// rotateLogIfFull rotates the log file at path once it has reached the
+// maximum size. Writers in any process and either SDK may reach the size at
+// once, so it rotates only while holding path+".rotate.lock", which it
+// creates exclusively, and only if the file is still full once the lock is
+// held. A writer that finds the lock held leaves the rotation to its holder.
+func rotateLogIfFull(path string, config *LoggingConfig) error {
+	if info, err := os.Stat(path); err != nil || info.Size() < config.MaxSizeBytes {
+		return nil
+	}
+
+	lock := path + ".rotate.lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		info, serr := os.Stat(lock)
+		if serr != nil || time.Since(info.ModTime()) < logRotateLockStale {
+			return nil
+		}
+		os.Remove(lock)
+		f, err = os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(lock)
+
+	// Another writer may have rotated it before the lock was taken
+	if info, err := os.Stat(path); err != nil || info.Size() < config.MaxSizeBytes {
+		return nil
+	}
+	return rotateLog(path, config.RetainCount)
+}
+
+// rotateLog rotates the log file at path, keeping up to retainCount old
+// files. Rotated files are gzipped, as name.<time>.jsonl.gz.
+func rotateLog(path string, retainCount int) error {
+	dir := filepath.Dir(path)
+	base := filepath.Base(path)
 	ext := filepath.Ext(base)
 	name := strings.TrimSuffix(base, ext)
 
-	// Find existing rotated files
-	pattern := filepath.Join(dir, name+".*.jsonl")
-	matches, _ := filepath.Glob(pattern)
+	// Find existing rotated files, compressed or from before rotations were
+	matches, _ := filepath.Glob(filepath.Join(dir, name+".*.jsonl"))
+	compressed, _ := filepath.Glob(filepath.Join(dir, name+".*.jsonl.gz"))
+	matches = append(matches, compressed...)
 
 	// Sort and remove excess
-	if len(matches) >= config.RetainCount {
+	if len(matches) >= retainCount {
 		sort.Strings(matches)
-		for i := 0; i <= len(matches)-config.RetainCount; i++ {
+		for i := 0; i <= len(matches)-retainCount; i++ {
 			os.Remove(matches[i])
 		}
 	}
@@ -162,7 +489,196 @@ func rotateLog(config *LoggingConfig) {
 	// Rename current log
 	ts := time.Now().UTC().Format("20060102T150405")
 	rotated := filepath.Join(dir, fmt.Sprintf("%s.%s%s", name, ts, ext))
-	os.Rename(config.FilePath, rotated)
+	// A later rotation in the same second takes a numbered name, which
+	// sorts after the first and before the next second's
+	for i := 1; ; i++ {
+		_, err := os.Lstat(rotated)
+		_, gzErr := os.Lstat(rotated + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			break
+		}
+		rotated = filepath.Join(dir, fmt.Sprintf("%s.%s_%03d%s", name, ts, i, ext))
+	}
+	if err := os.Rename(path, rotated); err != nil {
+		return err
+	}
+	waitLogWriters(rotated)
+	if err := gzipLogFile(rotated); err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to compress rotated log: %v", err))
+	}
+	return nil
+}
+
+// waitLogWriters waits for the writers holding a lock on the log file at
+// path, one rotation has just renamed, to finish (see openLogFile).
+func waitLogWriters(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if syscall.Flock(int(f.Fd()), syscall.LOCK_EX) == nil {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}
+}
+
+// gzipLogFile replaces the file at path with a gzipped copy at path.gz.
+func gzipLogFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	// A writer without the lock, such as the TypeScript SDK's, may still
+	// append to the file after it was rotated, so copy until nothing more
+	// has arrived
+	var copied int64
+	for {
+		var n int64
+		n, err = io.Copy(gz, src)
+		copied += n
+		if err != nil {
+			break
+		}
+		if info, serr := src.Stat(); serr != nil || info.Size() <= copied {
+			break
+		}
+	}
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// queryLogs returns the entries of the execution log of config and of its
+// rotated files that match query, oldest first, after flushing buffered
+// entries. Malformed lines are skipped, and a missing log has no entries.
+//
+// With logging.perAgent, a query for one agent reads the agent's own file,
+// which may keep entries the shared log has rotated away. With "only", the
+// shared log is read as well for entries from before it was set, and a
+// query for every agent reads every agent's file.
+func queryLogs(config *LoggingConfig, query LogQuery) ([]LogEntry, error) {
+	logBuffer.flush()
+	path := config.FilePath
+	if path == "" {
+		return nil, nil
+	}
+	var logs []string
+	switch {
+	case config.PerAgent && query.Agent != "":
+		if config.PerAgentOnly {
+			logs = append(logs, path)
+		}
+		logs = append(logs, agentLogFile(path, query.Agent))
+	case config.PerAgentOnly:
+		agents, _ := filepath.Glob(agentLogFile(path, "*"))
+		logs = append([]string{path}, agents...)
+	default:
+		logs = []string{path}
+	}
+
+	var files []string
+	for _, log := range logs {
+		// Rotated files are name.<time>.jsonl, or name-<time>.jsonl as the
+		// TypeScript SDK names them, each gzipped with .gz after it
+		ext := filepath.Ext(log)
+		name := strings.TrimSuffix(log, ext)
+		for _, pattern := range []string{".*" + ext, ".*" + ext + ".gz", "-*" + ext, "-*" + ext + ".gz"} {
+			rotated, _ := filepath.Glob(name + pattern)
+			files = append(files, rotated...)
+		}
+		files = append(files, log)
+	}
+
+	var entries []LogEntry
+	for _, file := range files {
+		found, err := readLogFile(file, query)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, _ := time.Parse(time.RFC3339Nano, entries[i].Timestamp)
+		b, _ := time.Parse(time.RFC3339Nano, entries[j].Timestamp)
+		return a.Before(b)
+	})
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[len(entries)-query.Limit:]
+	}
+	return entries, nil
+}
+
+// readLogFile returns the entries of one log file that match query,
+// reading a gzipped rotation through gzip.
+func readLogFile(file string, query LogQuery) ([]LogEntry, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read execution log: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read execution log %s: %w", file, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		e, err := decodeLogEntry(scanner.Bytes())
+		if err != nil || !query.matches(&e) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read execution log: %w", err)
+	}
+	return entries, nil
+}
+
+// matches reports whether e passes the query's filters.
+func (q *LogQuery) matches(e *LogEntry) bool {
+	if q.Agent != "" && e.Agent != q.Agent {
+		return false
+	}
+	if q.SessionID != "" && e.SessionID != q.SessionID {
+		return false
+	}
+	if q.ExitCode != nil && e.ExitCode != *q.ExitCode {
+		return false
+	}
+	if !q.Since.IsZero() {
+		t, err := time.Parse(time.RFC3339Nano, e.Timestamp)
+		if err != nil || t.Before(q.Since) {
+			return false
+		}
+	}
+	return true
 }
 
 // truncate shortens a string to maxLen characters.
@@ -1,10 +1,15 @@
 package sfa
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -56,7 +61,7 @@ func TestResolveLoggingConfigDefaults(t *testing.T) {
 func TestCreateLogEntry(t *testing.T) {
 	start := time.Now().Add(-100 * time.Millisecond)
 	entry := createLogEntry("test-agent", "1.0.0", 0, start, 0,
-		[]string{"test-agent"}, "session-1", "input data", "output data")
+		[]string{"test-agent"}, "session-1", "input data", "output data", nil)
 
 	if entry.Agent != "test-agent" {
 		t.Errorf("expected agent test-agent, got %s", entry.Agent)
@@ -74,13 +79,59 @@ func TestCreateLogEntry(t *testing.T) {
 
 func TestCreateLogEntryTruncation(t *testing.T) {
 	longInput := strings.Repeat("a", 1000)
-	entry := createLogEntry("test", "1.0", 0, time.Now(), 0, nil, "", longInput, "")
+	entry := createLogEntry("test", "1.0", 0, time.Now(), 0, nil, "", longInput, "", nil)
 
 	if len(entry.InputSummary) != 500 {
 		t.Errorf("expected truncated to 500, got %d", len(entry.InputSummary))
 	}
 }
 
+func TestCreateLogEntryMasksSecrets(t *testing.T) {
+	resolved := &ResolvedEnv{
+		Values:  map[string]string{"API_KEY": "sk-live-123", "REGION": "eu"},
+		Secrets: map[string]bool{"API_KEY": true},
+	}
+	entry := createLogEntry("test", "1.0", 0, time.Now(), 0, nil, "",
+		"key=sk-live-123 region=eu", `{"echo":"sk-live-123"}`, resolved)
+	if entry.InputSummary != "key=*** region=eu" || entry.OutputSummary != `{"echo":"***"}` {
+		t.Errorf("summaries = %q, %q", entry.InputSummary, entry.OutputSummary)
+	}
+
+	meta := maskLogMeta(map[string]any{
+		"cost":  1.5,
+		"note":  "used sk-live-123",
+		"calls": []any{map[string]any{"url": "https://x?key=sk-live-123"}},
+		"files": []string{"sk-live-123.md"},
+	}, resolved)
+	data, _ := json.Marshal(meta)
+	if strings.Contains(string(data), "sk-live-123") || !strings.Contains(string(data), `"cost":1.5`) {
+		t.Errorf("meta = %s", data)
+	}
+}
+
+func TestExecutionMeta(t *testing.T) {
+	meta := &executionMeta{}
+	e := &executor{def: &AgentDef{Name: "reviewer"}, resolved: &ResolvedEnv{}}
+	ctx := e.executeContext(&execution{ctx: context.Background(), safety: &SafetyState{}, meta: meta}, "", nil)
+	ctx.SetMeta("files", 42)
+	ctx.SetMeta("model", "large")
+	out := captureStderr(t, func() { ctx.SetMeta("bad", make(chan int)) })
+	if !strings.Contains(out, `warning: ignoring log metadata "bad"`) {
+		t.Errorf("stderr = %q", out)
+	}
+	meta.addRetry()
+
+	got := meta.snapshot()
+	if len(got) != 3 || got["files"] != 42 || got["model"] != "large" || got["retries"] != 1 {
+		t.Errorf("snapshot() = %v", got)
+	}
+	var none *executionMeta
+	none.set("x", 1)
+	if got := none.snapshot(); len(got) != 0 {
+		t.Errorf("nil snapshot() = %v", got)
+	}
+}
+
 func TestWriteLogEntry(t *testing.T) {
 	tmpDir := t.TempDir()
 	logPath := filepath.Join(tmpDir, "test.jsonl")
@@ -132,3 +183,217 @@ func TestWriteLogEntrySuppressed(t *testing.T) {
 		t.Error("expected no log file when suppressed")
 	}
 }
+
+func TestWriteLogEntryPerAgent(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "executions.jsonl")
+	config := resolveLoggingConfig(map[string]any{"logging": map[string]any{"file": logPath, "perAgent": true}}, false)
+	if !config.PerAgent || config.PerAgentOnly {
+		t.Fatalf("perAgent: true resolved to %+v", config)
+	}
+	writeLogEntry(&LogEntry{Timestamp: "2026-01-01T10:00:00Z", Agent: "fetcher"}, config)
+	writeLogEntry(&LogEntry{Timestamp: "2026-01-01T11:00:00Z", Agent: "parser"}, config)
+
+	count := func(path string) int {
+		data, _ := os.ReadFile(path)
+		return strings.Count(string(data), "\n")
+	}
+	if n := count(logPath); n != 2 {
+		t.Errorf("shared log has %d entries, want 2", n)
+	}
+	if n := count(filepath.Join(dir, "fetcher", "executions.jsonl")); n != 1 {
+		t.Errorf("fetcher's log has %d entries, want 1", n)
+	}
+	if got, _ := queryLogs(config, LogQuery{Agent: "parser"}); len(got) != 1 {
+		t.Errorf("query for parser = %+v", got)
+	}
+
+	// Each file rotates on its own
+	config.MaxSizeBytes = 1
+	writeLogEntry(&LogEntry{Timestamp: "2026-01-01T12:00:00Z", Agent: "fetcher"}, config)
+	if rotated, _ := filepath.Glob(filepath.Join(dir, "fetcher", "executions.*.jsonl.gz")); len(rotated) != 1 {
+		t.Errorf("fetcher's rotated logs = %v", rotated)
+	}
+
+	// With "only", the shared log keeps the entries from before
+	dir = t.TempDir()
+	logPath = filepath.Join(dir, "executions.jsonl")
+	os.WriteFile(logPath, []byte(`{"timestamp":"2026-01-01T10:00:00Z","agent":"parser"}`+"\n"), 0644)
+	config = resolveLoggingConfig(map[string]any{"logging": map[string]any{"file": logPath, "perAgent": "only"}}, false)
+	if !config.PerAgentOnly {
+		t.Fatalf(`perAgent: "only" resolved to %+v`, config)
+	}
+	writeLogEntry(&LogEntry{Timestamp: "2026-01-01T11:00:00Z", Agent: "parser"}, config)
+	writeLogEntry(&LogEntry{Timestamp: "2026-01-01T12:00:00Z", Agent: "fetcher"}, config)
+	if n := count(logPath); n != 1 {
+		t.Errorf("shared log has %d entries with perAgent: only, want 1", n)
+	}
+	if got, _ := queryLogs(config, LogQuery{Agent: "parser"}); len(got) != 2 {
+		t.Errorf("query for parser = %+v, want its entries from both logs", got)
+	}
+	if got, _ := queryLogs(config, LogQuery{}); len(got) != 3 {
+		t.Errorf("query for every agent = %d entries, want 3", len(got))
+	}
+}
+
+func TestWriteLogEntryBuffered(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "executions.jsonl")
+	config := resolveLoggingConfig(map[string]any{"logging": map[string]any{"file": logPath, "buffer": "1h"}}, false)
+	if config.FlushInterval != time.Hour {
+		t.Fatalf("buffer: 1h resolved to %v", config.FlushInterval)
+	}
+	writeLogEntry(&LogEntry{Timestamp: "2026-01-01T10:00:00Z", Agent: "fetcher"}, config)
+	writeLogEntry(&LogEntry{Timestamp: "2026-01-01T11:00:00Z", Agent: "parser"}, config)
+	if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+		t.Fatalf("entries were written before a flush: %v", err)
+	}
+
+	// Queries see buffered entries
+	if got, _ := queryLogs(config, LogQuery{}); len(got) != 2 || got[1].Agent != "parser" {
+		t.Errorf("query = %+v", got)
+	}
+
+	// The interval flushes in the background
+	config.FlushInterval = time.Millisecond
+	writeLogEntry(&LogEntry{Timestamp: "2026-01-01T12:00:00Z", Agent: "fetcher"}, config)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(logPath)
+		if strings.Count(string(data), "\n") == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("log after the flush interval:\n%s", data)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if d := resolveLogBuffer(map[string]any{"logging": map[string]any{"buffer": true}}); d != defaultLogFlushInterval {
+		t.Errorf("buffer: true resolved to %v", d)
+	}
+	out := captureStderr(t, func() {
+		if d := resolveLogBuffer(map[string]any{"logging": map[string]any{"buffer": "soon"}}); d != 0 {
+			t.Errorf("buffer: soon resolved to %v", d)
+		}
+	})
+	if !strings.Contains(out, `ignoring invalid logging.buffer "soon"`) {
+		t.Errorf("stderr = %q", out)
+	}
+}
+
+func TestRotateLogCompresses(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "executions.jsonl")
+	config := &LoggingConfig{FilePath: logPath, MaxSizeBytes: 1, RetainCount: 5}
+	writeLogEntry(&LogEntry{Timestamp: "2026-01-01T10:00:00Z", Agent: "fetcher"}, config)
+	writeLogEntry(&LogEntry{Timestamp: "2026-01-01T11:00:00Z", Agent: "parser"}, config)
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "executions.*.*"))
+	if len(rotated) != 1 || !strings.HasSuffix(rotated[0], ".jsonl.gz") {
+		t.Fatalf("rotated files = %v, want one gzipped", rotated)
+	}
+	f, _ := os.Open(rotated[0])
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(gz); !strings.Contains(string(data), `"agent":"fetcher"`) {
+		t.Errorf("rotated log holds %q", data)
+	}
+	if got, err := queryLogs(config, LogQuery{}); err != nil || len(got) != 2 || got[0].Agent != "fetcher" {
+		t.Errorf("queryLogs() = %+v, %v", got, err)
+	}
+}
+
+func TestWriteLogEntryConcurrentRotation(t *testing.T) {
+	dir := t.TempDir()
+	config := &LoggingConfig{FilePath: filepath.Join(dir, "executions.jsonl"), MaxSizeBytes: 512, RetainCount: 1000}
+
+	const writers, each = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				writeLogEntry(&LogEntry{Timestamp: "2026-01-01T10:00:00Z", Agent: fmt.Sprintf("agent-%d", w), SessionID: fmt.Sprint(i)}, config)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	entries, err := queryLogs(config, LogQuery{})
+	if err != nil || len(entries) != writers*each {
+		t.Errorf("read %d entries (%v), want %d", len(entries), err, writers*each)
+	}
+	if _, err := os.Stat(config.FilePath + ".rotate.lock"); !os.IsNotExist(err) {
+		t.Errorf("rotation lock left behind: %v", err)
+	}
+}
+
+func TestRotateLogIfFullSkipsWhileLocked(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "executions.jsonl")
+	os.WriteFile(path, []byte(strings.Repeat("x", 100)+"\n"), 0644)
+	config := &LoggingConfig{MaxSizeBytes: 10, RetainCount: 5}
+
+	// Another writer is rotating
+	os.WriteFile(path+".rotate.lock", nil, 0644)
+	if err := rotateLogIfFull(path, config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("rotated while another writer held the lock: %v", err)
+	}
+
+	// A lock left behind by a crash is taken over
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(path+".rotate.lock", old, old)
+	if err := rotateLogIfFull(path, config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("stale lock kept the log from rotating: %v", err)
+	}
+}
+
+func TestQueryLogs(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "executions.jsonl")
+	line := func(ts, agent, session string, code int) string {
+		data, _ := json.Marshal(LogEntry{Timestamp: ts, Agent: agent, SessionID: session, ExitCode: code})
+		return string(data) + "\n"
+	}
+	// A file each SDK rotated, then the current log
+	os.WriteFile(filepath.Join(dir, "executions.20260101T000000.jsonl"), []byte(line("2026-01-01T10:00:00Z", "fetcher", "s1", 0)), 0644)
+	os.WriteFile(filepath.Join(dir, "executions-20260102T000000.jsonl"), []byte(line("2026-01-02T10:00:00.500Z", "fetcher", "s2", 1)), 0644)
+	os.WriteFile(logPath, []byte(line("2026-01-03T10:00:00Z", "fetcher", "s2", 0)+"not json\n"+line("2026-01-03T11:00:00Z", "parser", "s2", 2)), 0644)
+
+	all, err := queryLogs(&LoggingConfig{FilePath: logPath}, LogQuery{})
+	if err != nil || len(all) != 4 || all[0].SessionID != "s1" || all[3].Agent != "parser" {
+		t.Fatalf("queryLogs() = %+v, %v", all, err)
+	}
+	zero := 0
+	for _, tc := range []struct {
+		query LogQuery
+		want  int
+	}{
+		{LogQuery{Agent: "fetcher"}, 3},
+		{LogQuery{SessionID: "s2"}, 3},
+		{LogQuery{Agent: "fetcher", SessionID: "s2", ExitCode: &zero}, 1},
+		{LogQuery{Since: time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)}, 3},
+		{LogQuery{Limit: 2}, 2},
+	} {
+		if got, _ := queryLogs(&LoggingConfig{FilePath: logPath}, tc.query); len(got) != tc.want {
+			t.Errorf("queryLogs(%+v) = %d entries, want %d", tc.query, len(got), tc.want)
+		}
+	}
+	if got, _ := queryLogs(&LoggingConfig{FilePath: logPath}, LogQuery{Limit: 1}); len(got) != 1 || got[0].Agent != "parser" {
+		t.Errorf("Limit kept %+v, want the newest entry", got)
+	}
+	if got, err := queryLogs(&LoggingConfig{FilePath: filepath.Join(dir, "missing.jsonl")}, LogQuery{}); err != nil || len(got) != 0 {
+		t.Errorf("missing log = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,56 @@
+package sfa
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// LogSchemaVersion is the version of the execution log entry schema the
+// SDK writes, as each entry's schemaVersion. Entries from before the field
+// was added are version 1.
+//
+// Adding an optional field keeps the version: readers ignore fields they
+// do not know. Renaming, removing, or changing the type or meaning of a
+// field bumps it, with a migration in logMigrations that upgrades entries
+// of the previous version, so that readers keep reading older files.
+const LogSchemaVersion = 2
+
+// logMigrations[i] upgrades the fields of an entry of schema version i+1
+// to version i+2.
+var logMigrations = []func(fields map[string]json.RawMessage){
+	// 1 → 2: callChain is always a list, empty rather than null or missing
+	func(fields map[string]json.RawMessage) {
+		if c, ok := fields["callChain"]; !ok || string(c) == "null" {
+			fields["callChain"] = json.RawMessage("[]")
+		}
+	},
+}
+
+// decodeLogEntry parses one line of the execution log, upgrading an entry
+// of an older schema version to LogSchemaVersion. An entry of a newer
+// version is decoded as is, keeping the fields this SDK knows.
+func decodeLogEntry(line []byte) (LogEntry, error) {
+	var e LogEntry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return e, err
+	}
+	if e.SchemaVersion >= LogSchemaVersion {
+		return e, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return e, err
+	}
+	for v := max(e.SchemaVersion, 1); v < LogSchemaVersion; v++ {
+		logMigrations[v-1](fields)
+	}
+	fields["schemaVersion"] = json.RawMessage(strconv.Itoa(LogSchemaVersion))
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return e, err
+	}
+	var upgraded LogEntry
+	err = json.Unmarshal(data, &upgraded)
+	return upgraded, err
+}
@@ -0,0 +1,44 @@
+package sfa
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDecodeLogEntryUpgrades(t *testing.T) {
+	for _, line := range []string{
+		`{"timestamp":"2026-01-01T10:00:00Z","agent":"parser","exitCode":1}`,
+		`{"timestamp":"2026-01-01T10:00:00Z","agent":"parser","exitCode":1,"callChain":null}`,
+	} {
+		e, err := decodeLogEntry([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.SchemaVersion != LogSchemaVersion || e.CallChain == nil || e.Agent != "parser" || e.ExitCode != 1 {
+			t.Errorf("%s decoded to %+v", line, e)
+		}
+	}
+
+	// Newer entries are read as is
+	e, err := decodeLogEntry([]byte(`{"schemaVersion":99,"agent":"parser","traceId":"abc"}`))
+	if err != nil || e.SchemaVersion != 99 || e.Agent != "parser" {
+		t.Errorf("newer entry decoded to %+v, %v", e, err)
+	}
+	if _, err := decodeLogEntry([]byte(`not json`)); err == nil {
+		t.Error("expected an error for a malformed line")
+	}
+}
+
+func TestCreateLogEntrySchemaVersion(t *testing.T) {
+	entry := createLogEntry("parser", "1.0.0", 0, time.Now(), 0, nil, "s1", "", "", nil)
+	data, _ := json.Marshal(entry)
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+	if fields["schemaVersion"] != float64(LogSchemaVersion) {
+		t.Errorf("schemaVersion = %v", fields["schemaVersion"])
+	}
+	if chain, ok := fields["callChain"].([]any); !ok || len(chain) != 0 {
+		t.Errorf("callChain = %#v, want []", fields["callChain"])
+	}
+}
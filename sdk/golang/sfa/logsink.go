@@ -0,0 +1,349 @@
+package sfa
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LogSink receives an agent's execution log entries. The JSONL log file is
+// the default sink; logging.sinks in the shared config and AgentDef.LogSinks
+// add others, such as a collector over HTTP or an in-memory sink for
+// tests. Sinks are best-effort: their errors are warned on stderr and never
+// change the exit code. A sink that implements fmt.Stringer is named by it
+// in those warnings.
+type LogSink interface {
+	// Write receives an entry as an execution ends. In --serve it may be
+	// called from several goroutines at once.
+	Write(entry *LogEntry) error
+	// Flush delivers the entries the sink holds; FlushLogs calls it.
+	Flush() error
+	// Close flushes the sink and releases it. The SDK calls it once, as
+	// the agent exits.
+	Close() error
+}
+
+// fileLogSink is the default sink: it appends each entry to the log file,
+// and to the agent's own file with logging.perAgent, buffered with
+// logging.buffer. Each file rotates on its own.
+type fileLogSink struct {
+	config *LoggingConfig
+}
+
+func (s *fileLogSink) String() string { return s.config.FilePath }
+
+func (s *fileLogSink) Write(entry *LogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	write := appendLogEntry
+	if s.config.FlushInterval > 0 {
+		write = logBuffer.add
+	}
+	if !s.config.PerAgentOnly {
+		write(s.config.FilePath, data, s.config)
+	}
+	if s.config.PerAgent && entry.Agent != "" {
+		write(agentLogFile(s.config.FilePath, entry.Agent), data, s.config)
+	}
+	return nil
+}
+
+func (s *fileLogSink) Flush() error {
+	logBuffer.flush()
+	return nil
+}
+
+func (s *fileLogSink) Close() error { return s.Flush() }
+
+// activeLogConfig is the logging config of the running agent, whose sinks
+// FlushLogs flushes.
+var activeLogConfig atomic.Pointer[LoggingConfig]
+
+// logSinks returns the sinks entries of config are written to: the log
+// file first, then config.Sinks.
+func logSinks(config *LoggingConfig) []LogSink {
+	return append([]LogSink{&fileLogSink{config: config}}, config.Sinks...)
+}
+
+// closeLogSinks closes the sinks of config, as the agent exits.
+func closeLogSinks(config *LoggingConfig) {
+	if config.Suppressed {
+		return
+	}
+	for _, sink := range logSinks(config) {
+		if err := sink.Close(); err != nil {
+			stderrLog.Warn(fmt.Sprintf("failed to close execution log sink %s: %v", sinkName(sink), err))
+		}
+	}
+}
+
+// sinkName names a sink in warnings.
+func sinkName(sink LogSink) string {
+	if s, ok := sink.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", sink)
+}
+
+// resolveLogSinks reads logging.sinks, warning about and skipping any sink
+// it cannot use:
+//
+//	"sinks": [
+//	  {"type": "otlp", "endpoint": "http://collector:4318", "headers": {"Authorization": "Bearer ..."}},
+//	  {"type": "syslog", "address": "udp://logs.internal:514", "facility": "local0", "tag": "sfa"}
+//	]
+func resolveLogSinks(config map[string]any) []LogSink {
+	lm, _ := config["logging"].(map[string]any)
+	list, ok := lm["sinks"].([]any)
+	if !ok {
+		if lm["sinks"] != nil {
+			stderrLog.Warn("ignoring logging.sinks: it must be a list")
+		}
+		return nil
+	}
+	var sinks []LogSink
+	for i, v := range list {
+		m, _ := v.(map[string]any)
+		sink, err := newLogSink(m)
+		if err != nil {
+			stderrLog.Warn(fmt.Sprintf("ignoring logging.sinks[%d]: %v", i, err))
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks
+}
+
+// newLogSink creates the sink one entry of logging.sinks describes.
+func newLogSink(m map[string]any) (LogSink, error) {
+	switch typ, _ := m["type"].(string); typ {
+	case "otlp":
+		endpoint, _ := m["endpoint"].(string)
+		if endpoint == "" {
+			endpoint = os.Getenv("SFA_OTEL_ENDPOINT")
+		}
+		if endpoint == "" {
+			return nil, errors.New("an otlp sink needs an endpoint, or SFA_OTEL_ENDPOINT")
+		}
+		endpoint = strings.TrimSuffix(endpoint, "/")
+		if !strings.HasSuffix(endpoint, "/v1/logs") {
+			endpoint += "/v1/logs"
+		}
+		sink := &otlpLogSink{endpoint: endpoint, headers: make(map[string]string)}
+		headers, _ := m["headers"].(map[string]any)
+		for k, v := range headers {
+			sink.headers[k] = fmt.Sprint(v)
+		}
+		return sink, nil
+	case "syslog":
+		sink := &syslogSink{facility: 1} // user
+		if addr, _ := m["address"].(string); addr != "" {
+			u, err := url.Parse(addr)
+			if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "unix" && u.Scheme != "unixgram") {
+				return nil, fmt.Errorf("invalid address %q (use udp://host:port, tcp://host:port, or unix:///path)", addr)
+			}
+			sink.network, sink.address = u.Scheme, u.Host
+			if strings.HasPrefix(u.Scheme, "unix") {
+				sink.address = u.Path
+			}
+		}
+		if name, _ := m["facility"].(string); name != "" {
+			facility, ok := syslogFacilities[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown facility %q", name)
+			}
+			sink.facility = facility
+		}
+		sink.tag, _ = m["tag"].(string)
+		return sink, nil
+	case "":
+		return nil, errors.New("missing type (otlp or syslog)")
+	default:
+		return nil, fmt.Errorf("unknown type %q (use otlp or syslog)", typ)
+	}
+}
+
+// otlpLogSink exports each entry as an OTLP log record over HTTP, in the
+// JSON encoding, as the tracer exports spans.
+type otlpLogSink struct {
+	endpoint string
+	headers  map[string]string
+}
+
+func (s *otlpLogSink) String() string { return s.endpoint }
+
+func (s *otlpLogSink) Flush() error { return nil }
+func (s *otlpLogSink) Close() error { return nil }
+
+func (s *otlpLogSink) Write(entry *LogEntry) error {
+	body, err := json.Marshal(otlpLogPayload(entry))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// otlpLogPayload builds an OTLP ExportLogsServiceRequest holding entry as
+// one record: INFO for a successful execution, ERROR otherwise, with the
+// entry's fields as attributes.
+func otlpLogPayload(entry *LogEntry) map[string]any {
+	ts, _ := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	severity, severityText := 9, "INFO"
+	if entry.ExitCode != 0 {
+		severity, severityText = 17, "ERROR"
+	}
+	attrs := map[string]any{
+		"schemaVersion": entry.SchemaVersion,
+		"agent":         entry.Agent,
+		"version":       entry.Version,
+		"exitCode":      entry.ExitCode,
+		"durationMs":    entry.DurationMs,
+		"depth":         entry.Depth,
+		"callChain":     strings.Join(entry.CallChain, ","),
+		"inputSummary":  entry.InputSummary,
+		"outputSummary": entry.OutputSummary,
+		"sessionId":     entry.SessionID,
+	}
+	if len(entry.Meta) > 0 {
+		if meta, err := json.Marshal(entry.Meta); err == nil {
+			attrs["meta"] = string(meta)
+		}
+	}
+	record := map[string]any{
+		"timeUnixNano":         strconv.FormatInt(ts.UnixNano(), 10),
+		"observedTimeUnixNano": strconv.FormatInt(time.Now().UnixNano(), 10),
+		"severityNumber":       severity,
+		"severityText":         severityText,
+		"body":                 map[string]any{"stringValue": fmt.Sprintf("%s exited %d in %dms", entry.Agent, entry.ExitCode, entry.DurationMs)},
+		"attributes":           otlpAttributes(attrs),
+	}
+	return map[string]any{
+		"resourceLogs": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": otlpAttributes(map[string]any{
+						"service.name":    entry.Agent,
+						"service.version": entry.Version,
+					}),
+				},
+				"scopeLogs": []any{
+					map[string]any{
+						"scope":      map[string]any{"name": "sfa-sdk-go"},
+						"logRecords": []any{record},
+					},
+				},
+			},
+		},
+	}
+}
+
+// syslogFacilities are the facility names a syslog sink accepts.
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSink writes each entry's JSON line as a syslog message, to the
+// local syslog daemon or journald when it has no address. It writes the
+// format the log/syslog package does, which is not built on every platform
+// the SDK is.
+type syslogSink struct {
+	network  string // empty for the local daemon
+	address  string
+	facility int
+	tag      string // the agent's name when empty
+}
+
+func (s *syslogSink) String() string {
+	if s.network == "" {
+		return "syslog"
+	}
+	return "syslog at " + s.address
+}
+
+func (s *syslogSink) Flush() error { return nil }
+func (s *syslogSink) Close() error { return nil }
+
+func (s *syslogSink) Write(entry *LogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	severity := 6 // info
+	if entry.ExitCode != 0 {
+		severity = 3 // err
+	}
+	tag := s.tag
+	if tag == "" {
+		tag = entry.Agent
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+
+	pri := s.facility*8 + severity
+	var msg string
+	if s.network == "" || strings.HasPrefix(s.network, "unix") {
+		msg = fmt.Sprintf("<%d>%s %s[%d]: %s", pri, time.Now().Format(time.Stamp), tag, os.Getpid(), data)
+	} else {
+		host, _ := os.Hostname()
+		msg = fmt.Sprintf("<%d>%s %s %s[%d]: %s", pri, time.Now().Format(time.RFC3339), host, tag, os.Getpid(), data)
+	}
+	if s.network == "tcp" {
+		// Stream transports need a frame delimiter
+		msg += "\n"
+	}
+	_, err = conn.Write([]byte(msg))
+	return err
+}
+
+// dial connects to the sink's address, or to the first local syslog
+// socket that accepts a connection.
+func (s *syslogSink) dial() (net.Conn, error) {
+	if s.network != "" {
+		return net.DialTimeout(s.network, s.address, 3*time.Second)
+	}
+	for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, errors.New("no local syslog daemon found")
+}
@@ -0,0 +1,150 @@
+package sfa
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOTLPLogSink(t *testing.T) {
+	var path, auth string
+	var payload map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &payload)
+	}))
+	defer srv.Close()
+
+	config := resolveLoggingConfig(map[string]any{"logging": map[string]any{
+		"file":  filepath.Join(t.TempDir(), "executions.jsonl"),
+		"sinks": []any{map[string]any{"type": "otlp", "endpoint": srv.URL + "/", "headers": map[string]any{"Authorization": "Bearer t0k"}}},
+	}}, false)
+	if len(config.Sinks) != 1 {
+		t.Fatalf("sinks = %v", config.Sinks)
+	}
+	writeLogEntry(&LogEntry{Timestamp: "2026-02-21T14:30:22Z", Agent: "reviewer", Version: "1.0.0", ExitCode: 2, SessionID: "s1"}, config)
+
+	if path != "/v1/logs" || auth != "Bearer t0k" {
+		t.Errorf("request to %q with Authorization %q", path, auth)
+	}
+	data, _ := json.Marshal(payload)
+	for _, want := range []string{
+		`"severityText":"ERROR"`,
+		`"timeUnixNano":"1771684222000000000"`,
+		`{"key":"sessionId","value":{"stringValue":"s1"}}`,
+		`{"key":"service.name","value":{"stringValue":"reviewer"}}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %s in %s", want, data)
+		}
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+
+	sink, err := newLogSink(map[string]any{"type": "syslog", "address": "udp://" + conn.LocalAddr().String(), "facility": "local0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(&LogEntry{Agent: "reviewer", ExitCode: 0, SessionID: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// local0 (16) * 8 + info (6)
+	if !strings.HasPrefix(msg, "<134>") || !strings.Contains(msg, " reviewer[") || !strings.HasSuffix(msg, `"sessionId":"s1"}`) {
+		t.Errorf("message = %q", msg)
+	}
+}
+
+func TestResolveLogSinksInvalid(t *testing.T) {
+	t.Setenv("SFA_OTEL_ENDPOINT", "")
+	out := captureStderr(t, func() {
+		sinks := resolveLogSinks(map[string]any{"logging": map[string]any{"sinks": []any{
+			map[string]any{"type": "kafka"},
+			map[string]any{"type": "otlp"},
+			map[string]any{"type": "syslog", "facility": "mail2"},
+			map[string]any{"type": "syslog", "address": "http://logs:514"},
+			map[string]any{"type": "syslog"},
+		}}})
+		if len(sinks) != 1 {
+			t.Errorf("sinks = %v, want only the local syslog", sinks)
+		}
+	})
+	if n := strings.Count(out, "warning: ignoring logging.sinks["); n != 4 {
+		t.Errorf("expected 4 warnings, got %q", out)
+	}
+}
+
+// memoryLogSink keeps entries in memory, as an agent's tests might.
+type memoryLogSink struct {
+	mu             sync.Mutex
+	entries        []LogEntry
+	flushes, close int
+	err            error
+}
+
+func (s *memoryLogSink) Write(entry *LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, *entry)
+	return s.err
+}
+
+func (s *memoryLogSink) Flush() error { s.flushes++; return s.err }
+func (s *memoryLogSink) Close() error { s.close++; return s.err }
+
+func TestCustomLogSinks(t *testing.T) {
+	t.Cleanup(func() { activeLogConfig.Store(nil) })
+	logPath := filepath.Join(t.TempDir(), "executions.jsonl")
+	config := resolveLoggingConfig(map[string]any{"logging": map[string]any{"file": logPath}}, false)
+	mem, failing := &memoryLogSink{}, &memoryLogSink{err: errors.New("collector down")}
+	config.Sinks = append(config.Sinks, mem, failing)
+	activeLogConfig.Store(config)
+
+	out := captureStderr(t, func() {
+		writeLogEntry(&LogEntry{Agent: "reviewer", SessionID: "s1"}, config)
+	})
+	if len(mem.entries) != 1 || mem.entries[0].SessionID != "s1" {
+		t.Errorf("memory sink holds %+v", mem.entries)
+	}
+	if data, _ := os.ReadFile(logPath); !strings.Contains(string(data), `"sessionId":"s1"`) {
+		t.Errorf("log file = %q", data)
+	}
+	if !strings.Contains(out, "warning: failed to write execution log to *sfa.memoryLogSink: collector down") {
+		t.Errorf("stderr = %q", out)
+	}
+
+	captureStderr(t, FlushLogs)
+	captureStderr(t, func() { closeLogSinks(config) })
+	if mem.flushes != 1 || mem.close != 1 {
+		t.Errorf("flushed %d times, closed %d times", mem.flushes, mem.close)
+	}
+
+	// --no-log suppresses every sink
+	mem.entries = nil
+	writeLogEntry(&LogEntry{Agent: "reviewer"}, &LoggingConfig{Suppressed: true, Sinks: []LogSink{mem}})
+	if len(mem.entries) != 0 {
+		t.Errorf("a suppressed log wrote %+v", mem.entries)
+	}
+}
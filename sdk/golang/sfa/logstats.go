@@ -0,0 +1,100 @@
+package sfa
+
+import (
+	"sort"
+	"time"
+)
+
+// LogStats summarizes the execution log entries of one agent, or of one
+// agent in one session.
+type LogStats struct {
+	Agent         string          `json:"agent"`
+	SessionID     string          `json:"sessionId,omitempty"` // set when grouped by session
+	Executions    int             `json:"executions"`
+	Failures      int             `json:"failures"`
+	SuccessRate   float64         `json:"successRate"`
+	P50DurationMs int64           `json:"p50DurationMs"`
+	P95DurationMs int64           `json:"p95DurationMs"`
+	PerDay        map[string]int  `json:"perDay"`                 // executions by UTC day, as 2026-02-21
+	FailureCodes  []ExitCodeCount `json:"failureCodes,omitempty"` // most common first
+}
+
+// ExitCodeCount is how many executions exited with a non-zero code.
+type ExitCodeCount struct {
+	ExitCode int `json:"exitCode"`
+	Count    int `json:"count"`
+}
+
+// LogStatsOpts configures AggregateLogs.
+type LogStatsOpts struct {
+	BySession bool // one LogStats per agent and session rather than per agent
+}
+
+// AggregateLogs summarizes execution log entries, such as those QueryLogs
+// returns, per agent, sorted by agent and then session. Durations are
+// nearest-rank percentiles, and entries whose timestamp cannot be parsed
+// are left out of PerDay.
+func AggregateLogs(entries []LogEntry, opts LogStatsOpts) []LogStats {
+	type group struct {
+		stats     LogStats
+		durations []int64
+		codes     map[int]int
+	}
+	groups := make(map[[2]string]*group)
+	for _, e := range entries {
+		key := [2]string{e.Agent, ""}
+		if opts.BySession {
+			key[1] = e.SessionID
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{stats: LogStats{Agent: key[0], SessionID: key[1], PerDay: make(map[string]int)}, codes: make(map[int]int)}
+			groups[key] = g
+		}
+		g.stats.Executions++
+		g.durations = append(g.durations, e.DurationMs)
+		if e.ExitCode != 0 {
+			g.stats.Failures++
+			g.codes[e.ExitCode]++
+		}
+		if ts, err := time.Parse(time.RFC3339Nano, e.Timestamp); err == nil {
+			g.stats.PerDay[ts.UTC().Format(time.DateOnly)]++
+		}
+	}
+
+	stats := make([]LogStats, 0, len(groups))
+	for _, g := range groups {
+		st := g.stats
+		st.SuccessRate = float64(st.Executions-st.Failures) / float64(st.Executions)
+		sort.Slice(g.durations, func(i, j int) bool { return g.durations[i] < g.durations[j] })
+		st.P50DurationMs = percentile(g.durations, 50)
+		st.P95DurationMs = percentile(g.durations, 95)
+		for code, n := range g.codes {
+			st.FailureCodes = append(st.FailureCodes, ExitCodeCount{ExitCode: code, Count: n})
+		}
+		sort.Slice(st.FailureCodes, func(i, j int) bool {
+			a, b := st.FailureCodes[i], st.FailureCodes[j]
+			return a.Count > b.Count || (a.Count == b.Count && a.ExitCode < b.ExitCode)
+		})
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Agent != stats[j].Agent {
+			return stats[i].Agent < stats[j].Agent
+		}
+		return stats[i].SessionID < stats[j].SessionID
+	})
+	return stats
+}
+
+// percentile returns the nearest-rank pth percentile of sorted values.
+func percentile(sorted []int64, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100 // ceil(p/100 * n)
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
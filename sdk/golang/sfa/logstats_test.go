@@ -0,0 +1,42 @@
+package sfa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAggregateLogs(t *testing.T) {
+	entries := []LogEntry{
+		{Timestamp: "2026-02-20T23:00:00-02:00", Agent: "reviewer", SessionID: "s1", DurationMs: 100},
+		{Timestamp: "2026-02-21T10:00:00Z", Agent: "reviewer", SessionID: "s1", ExitCode: 1, DurationMs: 400},
+		{Timestamp: "2026-02-21T11:00:00Z", Agent: "reviewer", SessionID: "s2", ExitCode: 3, DurationMs: 300},
+		{Timestamp: "2026-02-21T12:00:00Z", Agent: "reviewer", SessionID: "s2", ExitCode: 1, DurationMs: 200},
+		{Timestamp: "bad", Agent: "planner", SessionID: "s1", DurationMs: 50},
+	}
+
+	stats := AggregateLogs(entries, LogStatsOpts{})
+	if len(stats) != 2 || stats[0].Agent != "planner" {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if len(stats[0].PerDay) != 0 || stats[0].P95DurationMs != 50 {
+		t.Errorf("planner = %+v", stats[0])
+	}
+	r := stats[1]
+	if r.Executions != 4 || r.Failures != 3 || r.SuccessRate != 0.25 {
+		t.Errorf("counts = %+v", r)
+	}
+	if r.P50DurationMs != 200 || r.P95DurationMs != 400 {
+		t.Errorf("p50 %d, p95 %d", r.P50DurationMs, r.P95DurationMs)
+	}
+	if want := map[string]int{"2026-02-21": 4}; !reflect.DeepEqual(r.PerDay, want) {
+		t.Errorf("perDay = %v, want %v", r.PerDay, want)
+	}
+	if want := []ExitCodeCount{{1, 2}, {3, 1}}; !reflect.DeepEqual(r.FailureCodes, want) {
+		t.Errorf("failureCodes = %v, want %v", r.FailureCodes, want)
+	}
+
+	bySession := AggregateLogs(entries, LogStatsOpts{BySession: true})
+	if len(bySession) != 3 || bySession[1].SessionID != "s1" || bySession[2].SessionID != "s2" || bySession[2].Executions != 2 {
+		t.Errorf("by session = %+v", bySession)
+	}
+}
@@ -0,0 +1,123 @@
+package sfa
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogFlushInterval = time.Second
+	logBufferBytes          = 64 * 1024 // flush at once when this much is pending
+)
+
+// logBuffer holds the execution log entries written with logging.buffer
+// until they are flushed.
+var logBuffer logWriter
+
+// logWriter buffers execution log lines and appends each file's pending
+// lines with one write, after the flush interval or once logBufferBytes
+// are pending, so that an agent logging many executions opens each file
+// and checks it for rotation once a batch rather than once an entry.
+// Batches are appended whole with O_APPEND, so writers in other processes
+// still never interleave within an entry.
+type logWriter struct {
+	flushMu sync.Mutex // keeps batches in order
+
+	mu      sync.Mutex
+	pending map[string]*pendingLog
+	size    int
+	timer   *time.Timer
+}
+
+// pendingLog is the lines waiting to be appended to one file.
+type pendingLog struct {
+	data   []byte
+	config *LoggingConfig
+}
+
+// add queues data for path, flushing in the background after the config's
+// flush interval.
+func (w *logWriter) add(path string, data []byte, config *LoggingConfig) {
+	w.mu.Lock()
+	if w.pending == nil {
+		w.pending = make(map[string]*pendingLog)
+	}
+	p := w.pending[path]
+	if p == nil {
+		p = &pendingLog{}
+		w.pending[path] = p
+	}
+	p.data = append(p.data, data...)
+	p.config = config
+	w.size += len(data)
+	full := w.size >= logBufferBytes
+	if !full && w.timer == nil {
+		w.timer = time.AfterFunc(config.FlushInterval, w.flush)
+	}
+	w.mu.Unlock()
+
+	if full {
+		w.flush()
+	}
+}
+
+// flush appends everything pending, file by file.
+func (w *logWriter) flush() {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	pending := w.pending
+	w.pending, w.size = nil, 0
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.mu.Unlock()
+
+	paths := make([]string, 0, len(pending))
+	for path := range pending {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		appendLogEntry(path, pending[path].data, pending[path].config)
+	}
+}
+
+// FlushLogs writes the execution log entries buffered with logging.buffer,
+// and flushes the running agent's other sinks. The SDK flushes before the
+// agent exits and the log file before QueryLogs reads it; call it to make
+// entries visible to other processes sooner.
+func FlushLogs() {
+	logBuffer.flush()
+	if config := activeLogConfig.Load(); config != nil {
+		for _, sink := range config.Sinks {
+			if err := sink.Flush(); err != nil {
+				stderrLog.Warn(fmt.Sprintf("failed to flush execution log sink %s: %v", sinkName(sink), err))
+			}
+		}
+	}
+}
+
+// resolveLogBuffer reads logging.buffer: true buffers entries for up to
+// defaultLogFlushInterval, and a duration such as "250ms" for that long.
+// Zero writes each entry as it is logged.
+func resolveLogBuffer(config map[string]any) time.Duration {
+	lm, _ := config["logging"].(map[string]any)
+	switch v := lm["buffer"].(type) {
+	case bool:
+		if v {
+			return defaultLogFlushInterval
+		}
+	case string:
+		d, err := time.ParseDuration(v)
+		if err == nil && d > 0 {
+			return d
+		}
+		stderrLog.Warn(fmt.Sprintf("ignoring invalid logging.buffer %q (use true, false, or a duration such as 500ms)", v))
+	}
+	return 0
+}
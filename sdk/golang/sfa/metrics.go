@@ -0,0 +1,306 @@
+package sfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric names exposed by the SDK.
+const (
+	metricExecutions     = "sfa_executions_total"
+	metricFailures       = "sfa_execution_failures_total"
+	metricDuration       = "sfa_execution_duration_seconds"
+	metricSubagents      = "sfa_subagent_invocations_total"
+	metricServiceStartup = "sfa_service_startup_seconds"
+)
+
+// durationBuckets are the histogram upper bounds, in seconds.
+var durationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// metricDef describes a metric for the Prometheus exposition.
+type metricDef struct {
+	name string
+	help string
+	kind string // "counter" or "histogram"
+}
+
+var metricDefs = []metricDef{
+	{metricExecutions, "Agent executions started.", "counter"},
+	{metricFailures, "Agent executions that exited non-zero, by exit code.", "counter"},
+	{metricDuration, "Agent execution duration.", "histogram"},
+	{metricSubagents, "Subagent invocations, by target and outcome.", "counter"},
+	{metricServiceStartup, "Time to start declared service dependencies.", "histogram"},
+}
+
+// histogram is a cumulative Prometheus-style histogram.
+type histogram struct {
+	counts []uint64 // per bucket, non-cumulative; last entry is +Inf
+	sum    float64
+	count  uint64
+}
+
+// metricsRegistry holds the counters and histograms for one agent process.
+// Series are keyed by metric name and a rendered label set.
+type metricsRegistry struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+
+	// per-execution figures for the metrics file
+	subagents        int
+	subagentFailures int
+	serviceStartup   time.Duration
+}
+
+// newMetricsRegistry creates an empty registry.
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		counters:   make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*histogram),
+	}
+}
+
+// inc adds delta to a counter series.
+func (m *metricsRegistry) inc(name string, labels map[string]string, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.counters[name]
+	if !ok {
+		series = make(map[string]float64)
+		m.counters[name] = series
+	}
+	series[formatLabels(labels)] += delta
+}
+
+// observe records a value in a histogram series.
+func (m *metricsRegistry) observe(name string, labels map[string]string, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.histograms[name]
+	if !ok {
+		series = make(map[string]*histogram)
+		m.histograms[name] = series
+	}
+	key := formatLabels(labels)
+	h, ok := series[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets)+1)}
+		series[key] = h
+	}
+	i := sort.SearchFloat64s(durationBuckets, value)
+	h.counts[i]++
+	h.sum += value
+	h.count++
+}
+
+// recordExecution records the outcome of an agent execution.
+func (m *metricsRegistry) recordExecution(agent string, exitCode int, d time.Duration) {
+	labels := map[string]string{"agent": agent}
+	m.inc(metricExecutions, labels, 1)
+	if exitCode != ExitSuccess {
+		m.inc(metricFailures, map[string]string{"agent": agent, "exit_code": fmt.Sprintf("%d", exitCode)}, 1)
+	}
+	m.observe(metricDuration, labels, d.Seconds())
+}
+
+// recordSubagent records a subagent invocation and its outcome.
+func (m *metricsRegistry) recordSubagent(agent, target string, ok bool) {
+	status := "success"
+	if !ok {
+		status = "failure"
+	}
+	m.inc(metricSubagents, map[string]string{"agent": agent, "target": target, "status": status}, 1)
+
+	m.mu.Lock()
+	m.subagents++
+	if !ok {
+		m.subagentFailures++
+	}
+	m.mu.Unlock()
+}
+
+// recordServiceStartup records how long service dependencies took to start.
+func (m *metricsRegistry) recordServiceStartup(agent string, d time.Duration) {
+	m.observe(metricServiceStartup, map[string]string{"agent": agent}, d.Seconds())
+
+	m.mu.Lock()
+	m.serviceStartup = d
+	m.mu.Unlock()
+}
+
+// render returns the registry in the Prometheus text exposition format.
+func (m *metricsRegistry) render() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	for _, def := range metricDefs {
+		switch def.kind {
+		case "counter":
+			series := m.counters[def.name]
+			if len(series) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", def.name, def.help, def.name)
+			for _, key := range sortedKeys(series) {
+				fmt.Fprintf(&b, "%s%s %s\n", def.name, key, formatAmount(series[key]))
+			}
+		case "histogram":
+			series := m.histograms[def.name]
+			if len(series) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s histogram\n", def.name, def.help, def.name)
+			for _, key := range sortedKeys(series) {
+				h := series[key]
+				var cumulative uint64
+				for i, bound := range durationBuckets {
+					cumulative += h.counts[i]
+					fmt.Fprintf(&b, "%s_bucket%s %d\n", def.name, withLabel(key, "le", formatAmount(bound)), cumulative)
+				}
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", def.name, withLabel(key, "le", "+Inf"), h.count)
+				fmt.Fprintf(&b, "%s_sum%s %s\n", def.name, key, formatAmount(h.sum))
+				fmt.Fprintf(&b, "%s_count%s %d\n", def.name, key, h.count)
+			}
+		}
+	}
+	return b.String()
+}
+
+// serveMetrics exposes the registry at http://<port>/metrics until the
+// returned stop function is called.
+func serveMetrics(m *metricsRegistry, port int) (func(), error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on metrics port %d: %w", port, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = fmt.Fprint(w, m.render())
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+
+	return func() { _ = srv.Close() }, nil
+}
+
+// metricsSample is one execution's measurements, appended as a JSON line to
+// the metrics file for aggregation by `sfa logs stats`.
+type metricsSample struct {
+	Timestamp        string `json:"timestamp"`
+	Agent            string `json:"agent"`
+	Version          string `json:"version"`
+	SessionID        string `json:"sessionId"`
+	ExitCode         int    `json:"exitCode"`
+	DurationMs       int64  `json:"durationMs"`
+	Subagents        int    `json:"subagents"`
+	SubagentFailures int    `json:"subagentFailures"`
+	ServiceStartupMs int64  `json:"serviceStartupMs,omitempty"`
+}
+
+// sample builds the metrics file record for a finished execution.
+func (m *metricsRegistry) sample(agent, version, sessionID string, exitCode int, d time.Duration) metricsSample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return metricsSample{
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+		Agent:            agent,
+		Version:          version,
+		SessionID:        sessionID,
+		ExitCode:         exitCode,
+		DurationMs:       d.Milliseconds(),
+		Subagents:        m.subagents,
+		SubagentFailures: m.subagentFailures,
+		ServiceStartupMs: m.serviceStartup.Milliseconds(),
+	}
+}
+
+// resolveMetricsFile returns the metrics file path, or "" when suppressed.
+// Priority: SFA_METRICS_FILE env > config metrics.file > default next to the
+// execution log. Suppressed along with execution logging.
+func resolveMetricsFile(config map[string]any, logConfig *LoggingConfig) string {
+	if logConfig.Suppressed {
+		return ""
+	}
+	if p := os.Getenv("SFA_METRICS_FILE"); p != "" {
+		return p
+	}
+	if mc, ok := config["metrics"].(map[string]any); ok {
+		if f, ok := mc["file"].(string); ok && f != "" {
+			return f
+		}
+	}
+	return filepath.Join(filepath.Dir(logConfig.FilePath), "metrics.jsonl")
+}
+
+// appendMetricsSample appends a sample to the metrics file.
+// Best-effort: failures are warned to stderr but don't affect the exit code.
+func appendMetricsSample(path string, sample metricsSample) {
+	if path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to create metrics directory: %v", err))
+		return
+	}
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to open metrics file: %v", err))
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to write metrics sample: %v", err))
+	}
+}
+
+// formatLabels renders a label set as {k="v",...} with keys sorted.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[k])
+		parts[i] = fmt.Sprintf(`%s="%s"`, k, v)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// withLabel appends one label to an already rendered label set.
+func withLabel(rendered, key, value string) string {
+	label := fmt.Sprintf(`%s="%s"`, key, value)
+	if rendered == "" {
+		return "{" + label + "}"
+	}
+	return rendered[:len(rendered)-1] + "," + label + "}"
+}
+
+// sortedKeys returns a map's keys in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,122 @@
+package sfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRender(t *testing.T) {
+	m := newMetricsRegistry()
+	m.recordExecution("test-agent", ExitSuccess, 700*time.Millisecond)
+	m.recordExecution("test-agent", ExitFailure, 3*time.Second)
+	m.recordSubagent("test-agent", "child", true)
+	m.recordSubagent("test-agent", "child", false)
+
+	out := m.render()
+	for _, want := range []string{
+		"# TYPE sfa_executions_total counter",
+		`sfa_executions_total{agent="test-agent"} 2`,
+		`sfa_execution_failures_total{agent="test-agent",exit_code="1"} 1`,
+		"# TYPE sfa_execution_duration_seconds histogram",
+		`sfa_execution_duration_seconds_bucket{agent="test-agent",le="0.5"} 0`,
+		`sfa_execution_duration_seconds_bucket{agent="test-agent",le="1"} 1`,
+		`sfa_execution_duration_seconds_bucket{agent="test-agent",le="+Inf"} 2`,
+		`sfa_execution_duration_seconds_sum{agent="test-agent"} 3.7`,
+		`sfa_execution_duration_seconds_count{agent="test-agent"} 2`,
+		`sfa_subagent_invocations_total{agent="test-agent",status="failure",target="child"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "sfa_service_startup_seconds") {
+		t.Error("expected unobserved metrics to be omitted")
+	}
+}
+
+func TestMetricsSample(t *testing.T) {
+	m := newMetricsRegistry()
+	m.recordSubagent("a", "b", true)
+	m.recordSubagent("a", "c", false)
+	m.recordServiceStartup("a", 1500*time.Millisecond)
+
+	s := m.sample("a", "1.0.0", "sess", ExitSuccess, 2*time.Second)
+	if s.Subagents != 2 || s.SubagentFailures != 1 {
+		t.Errorf("expected 2 subagents with 1 failure, got %d/%d", s.Subagents, s.SubagentFailures)
+	}
+	if s.ServiceStartupMs != 1500 || s.DurationMs != 2000 {
+		t.Errorf("unexpected timings: %+v", s)
+	}
+}
+
+func TestResolveMetricsFile(t *testing.T) {
+	os.Unsetenv("SFA_METRICS_FILE")
+	logConfig := &LoggingConfig{FilePath: "/tmp/logs/executions.jsonl"}
+
+	if got := resolveMetricsFile(nil, logConfig); got != "/tmp/logs/metrics.jsonl" {
+		t.Errorf("expected default next to log file, got %q", got)
+	}
+
+	config := map[string]any{"metrics": map[string]any{"file": "/tmp/custom.jsonl"}}
+	if got := resolveMetricsFile(config, logConfig); got != "/tmp/custom.jsonl" {
+		t.Errorf("expected config path, got %q", got)
+	}
+
+	if got := resolveMetricsFile(nil, &LoggingConfig{Suppressed: true}); got != "" {
+		t.Errorf("expected suppression with logging, got %q", got)
+	}
+}
+
+func TestAppendMetricsSample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "metrics.jsonl")
+	appendMetricsSample(path, metricsSample{Agent: "a", ExitCode: 0})
+	appendMetricsSample(path, metricsSample{Agent: "a", ExitCode: 1})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(lines))
+	}
+	var s metricsSample
+	if err := json.Unmarshal([]byte(lines[1]), &s); err != nil || s.ExitCode != 1 {
+		t.Errorf("unexpected second sample %q: %v", lines[1], err)
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot allocate port: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	m := newMetricsRegistry()
+	m.recordExecution("test-agent", ExitSuccess, time.Second)
+	stop, err := serveMetrics(m, port)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stop()
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", port))
+	if err != nil {
+		t.Fatalf("failed to scrape: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `sfa_executions_total{agent="test-agent"} 1`) {
+		t.Errorf("unexpected scrape body:\n%s", body)
+	}
+}
@@ -12,7 +12,7 @@ func writeResult(result any, format OutputFormat) {
 	case OutputJSON:
 		data, err := json.Marshal(result)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: failed to marshal result: %v\n", err)
+			stderrLog.Error(fmt.Sprintf("failed to marshal result: %v", err))
 			os.Exit(ExitFailure)
 		}
 		fmt.Fprintln(os.Stdout, string(data))
@@ -35,18 +35,13 @@ func writeResult(result any, format OutputFormat) {
 	}
 }
 
-// writeDiagnostic writes a diagnostic message to stderr.
-func writeDiagnostic(message string) {
-	fmt.Fprintln(os.Stderr, message)
-}
-
-// exitWithError writes an error message to stderr and exits with the given code.
+// exitWithError logs an error message to stderr and exits with the given code.
 func exitWithError(message string, code int) {
-	fmt.Fprintf(os.Stderr, "error: %s\n", message)
+	stderrLog.Error(message)
 	os.Exit(code)
 }
 
-// emitProgress writes a progress message to stderr in the SFA format.
+// emitProgress logs a progress message of the agent, at Info level.
 func emitProgress(agentName, message string) {
-	fmt.Fprintf(os.Stderr, "[agent:%s] %s\n", agentName, message)
+	stderrLog.Info(message, "agent", agentName)
 }
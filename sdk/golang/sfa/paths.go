@@ -0,0 +1,43 @@
+package sfa
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// appDirName is SFA's directory under the XDG base directories.
+const appDirName = "single-file-agents"
+
+// dataDir returns elem joined under the SFA data directory, or "" if there
+// is no home directory to derive it from. Priority: SFA_DATA_HOME >
+// $XDG_DATA_HOME/single-file-agents > ~/.local/share/single-file-agents.
+func dataDir(elem ...string) string {
+	base := os.Getenv("SFA_DATA_HOME")
+	if base == "" {
+		base = xdgDir("XDG_DATA_HOME", ".local", "share")
+	}
+	if base == "" {
+		return ""
+	}
+	return filepath.Join(append([]string{base}, elem...)...)
+}
+
+// configDir returns the directory searched for the shared config file:
+// $XDG_CONFIG_HOME/single-file-agents > ~/.config/single-file-agents.
+func configDir() string {
+	return xdgDir("XDG_CONFIG_HOME", ".config")
+}
+
+// xdgDir returns single-file-agents under the base directory named by env,
+// or under home/fallback when env is unset. As the XDG spec requires, a
+// relative path in env is ignored.
+func xdgDir(env string, fallback ...string) string {
+	if p := os.Getenv(env); filepath.IsAbs(p) {
+		return filepath.Join(p, appDirName)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(append(append([]string{home}, fallback...), appDirName)...)
+}
@@ -0,0 +1,45 @@
+package sfa
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDataDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SFA_DATA_HOME", "")
+	t.Setenv("XDG_DATA_HOME", "")
+
+	if got, want := dataDir("logs", "executions.jsonl"), filepath.Join(home, ".local", "share", "single-file-agents", "logs", "executions.jsonl"); got != want {
+		t.Errorf("default: got %s, want %s", got, want)
+	}
+
+	t.Setenv("XDG_DATA_HOME", "relative/ignored")
+	if got, want := dataDir("cache"), filepath.Join(home, ".local", "share", "single-file-agents", "cache"); got != want {
+		t.Errorf("relative XDG_DATA_HOME: got %s, want %s", got, want)
+	}
+
+	t.Setenv("XDG_DATA_HOME", "/xdg/data")
+	if got := dataDir("sessions"); got != filepath.FromSlash("/xdg/data/single-file-agents/sessions") {
+		t.Errorf("XDG_DATA_HOME: got %s", got)
+	}
+
+	t.Setenv("SFA_DATA_HOME", "/srv/sfa")
+	if got := dataDir("context"); got != filepath.FromSlash("/srv/sfa/context") {
+		t.Errorf("SFA_DATA_HOME: got %s", got)
+	}
+	t.Setenv("SFA_NO_LOG", "")
+	t.Setenv("SFA_LOG_FILE", "")
+	if got := resolveLoggingConfig(nil, false).FilePath; got != filepath.FromSlash("/srv/sfa/logs/executions.jsonl") {
+		t.Errorf("expected the log under SFA_DATA_HOME, got %s", got)
+	}
+}
+
+func TestConfigDirXDG(t *testing.T) {
+	t.Setenv("SFA_CONFIG", "")
+	t.Setenv("XDG_CONFIG_HOME", "/xdg/config")
+	if got := getConfigPath(); got != filepath.FromSlash("/xdg/config/single-file-agents/config.json") {
+		t.Errorf("XDG_CONFIG_HOME: got %s", got)
+	}
+}
@@ -0,0 +1,73 @@
+package sfa
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrPermissionDenied is returned by RequestPermission when an action is
+// refused. Execute errors wrapping it exit with ExitPermissionDeny.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// permissionPolicy decides permission requests for one run. Answers are
+// remembered per action, so the user is asked at most once for each.
+type permissionPolicy struct {
+	agentName      string
+	trustLevel     TrustLevel
+	yes            bool
+	nonInteractive bool
+	prompter       *prompter
+
+	mu      sync.Mutex
+	answers map[string]bool
+}
+
+func newPermissionPolicy(agentName string, trustLevel TrustLevel, flags StandardFlags) *permissionPolicy {
+	return &permissionPolicy{
+		agentName:      agentName,
+		trustLevel:     trustLevel,
+		yes:            flags.Yes,
+		nonInteractive: flags.NonInteractive,
+		prompter:       newPrompter(agentName, flags),
+		answers:        make(map[string]bool),
+	}
+}
+
+// request asks for permission to perform action. Sandboxed agents are always
+// refused; --yes and --non-interactive grant without prompting (logging the
+// action to stderr); otherwise the user is prompted on the terminal, and a
+// missing terminal counts as a refusal.
+func (p *permissionPolicy) request(action string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if allowed, ok := p.answers[action]; ok {
+		return p.result(action, allowed)
+	}
+
+	var allowed bool
+	switch {
+	case p.trustLevel == TrustSandboxed:
+		stderrLog.Info(fmt.Sprintf("permission denied: %s (trustLevel is sandboxed)", action), "agent", p.agentName)
+	case p.yes || p.nonInteractive:
+		allowed = true
+		stderrLog.Info(fmt.Sprintf("permission granted: %s", action), "agent", p.agentName)
+	default:
+		var err error
+		allowed, err = p.prompter.ask(fmt.Sprintf("permission requested: %s. Allow?", action))
+		if err != nil {
+			stderrLog.Info(fmt.Sprintf("permission denied: %s (cannot prompt: %v; pass --yes to allow)", action, err), "agent", p.agentName)
+		}
+	}
+
+	p.answers[action] = allowed
+	return p.result(action, allowed)
+}
+
+func (p *permissionPolicy) result(action string, allowed bool) error {
+	if allowed {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrPermissionDenied, action)
+}
@@ -0,0 +1,95 @@
+package sfa
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeTTY answers prompts from a fixed script and records what was written.
+type fakeTTY struct {
+	io.Reader
+	written strings.Builder
+	opened  *int
+}
+
+func (f *fakeTTY) Write(p []byte) (int, error) { return f.written.Write(p) }
+func (f *fakeTTY) Close() error                { return nil }
+
+func ttyAnswering(answer string, opened *int) func() (io.ReadWriteCloser, error) {
+	return func() (io.ReadWriteCloser, error) {
+		*opened++
+		return &fakeTTY{Reader: strings.NewReader(answer)}, nil
+	}
+}
+
+func TestPermissionPromptAllowsAndRemembers(t *testing.T) {
+	opened := 0
+	p := newPermissionPolicy("test-agent", TrustLocal, StandardFlags{})
+	p.prompter.openTTY = ttyAnswering("y\n", &opened)
+
+	if err := p.request("write to repo"); err != nil {
+		t.Fatalf("expected permission granted, got %v", err)
+	}
+	if err := p.request("write to repo"); err != nil {
+		t.Fatalf("expected remembered grant, got %v", err)
+	}
+	if opened != 1 {
+		t.Errorf("expected one prompt per action, got %d", opened)
+	}
+}
+
+func TestPermissionPromptRefusal(t *testing.T) {
+	opened := 0
+	p := newPermissionPolicy("test-agent", TrustNetwork, StandardFlags{})
+	p.prompter.openTTY = ttyAnswering("\n", &opened)
+
+	err := p.request("call external API")
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "call external API") {
+		t.Errorf("expected action in error, got %q", err)
+	}
+}
+
+func TestPermissionNoTerminalDenies(t *testing.T) {
+	p := newPermissionPolicy("test-agent", TrustLocal, StandardFlags{})
+	p.prompter.openTTY = func() (io.ReadWriteCloser, error) { return nil, errors.New("no tty") }
+
+	captureStderr(t, func() {
+		if err := p.request("delete files"); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("expected denial without a terminal, got %v", err)
+		}
+	})
+}
+
+func TestPermissionYesSkipsPrompt(t *testing.T) {
+	for _, flags := range []StandardFlags{{Yes: true}, {NonInteractive: true}} {
+		opened := 0
+		p := newPermissionPolicy("test-agent", TrustPrivileged, flags)
+		p.prompter.openTTY = ttyAnswering("n\n", &opened)
+
+		out := captureStderr(t, func() {
+			if err := p.request("write to repo"); err != nil {
+				t.Errorf("expected grant with %+v, got %v", flags, err)
+			}
+		})
+		if opened != 0 {
+			t.Errorf("expected no prompt with %+v", flags)
+		}
+		if !strings.Contains(out, "permission granted: write to repo") {
+			t.Errorf("expected granted action logged to stderr, got %q", out)
+		}
+	}
+}
+
+func TestPermissionSandboxedAlwaysDenied(t *testing.T) {
+	p := newPermissionPolicy("test-agent", TrustSandboxed, StandardFlags{Yes: true})
+	captureStderr(t, func() {
+		if err := p.request("write to repo"); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("expected sandboxed agent to be denied, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,272 @@
+package sfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// autoPortPrefix marks a port mapping whose host port the SDK picks, e.g.
+// "auto:5432".
+const autoPortPrefix = "auto:"
+
+// allocateAutoPorts returns services with each "auto:<container port>"
+// mapping replaced by "<host port>:<container port>". Host ports are saved
+// in the agent's ports.json and reused on later runs, so persistent
+// services keep their port; new ones are picked from ports free now.
+func allocateAutoPorts(agentName string, services map[string]ServiceDef) (map[string]ServiceDef, error) {
+	dir := dataDir("services", agentName)
+	if dir == "" {
+		return nil, fmt.Errorf("failed to determine the data directory")
+	}
+	path := filepath.Join(dir, "ports.json")
+
+	saved := make(map[string]int)
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			stderrLog.Warn(fmt.Sprintf("ignoring unreadable %s: %v", path, err))
+			saved = make(map[string]int)
+		}
+	}
+
+	allocated := make(map[string]int)
+	resolved := make(map[string]ServiceDef, len(services))
+	for _, name := range sortedKeys(services) {
+		svc := services[name]
+		if !hasAutoPorts(svc.Ports) {
+			resolved[name] = svc
+			continue
+		}
+		ports := make([]string, len(svc.Ports))
+		for i, p := range svc.Ports {
+			ports[i] = p
+			if !strings.HasPrefix(p, autoPortPrefix) {
+				continue
+			}
+			target := strings.TrimPrefix(p, autoPortPrefix)
+			if n, err := strconv.Atoi(strings.TrimSuffix(target, "/tcp")); err != nil || n < 1 || n > 65535 {
+				return nil, fmt.Errorf("service %s: invalid port %q (use auto:<container port>, e.g. auto:5432)", name, p)
+			}
+			key := name + "/" + target
+			host, ok := saved[key]
+			if !ok {
+				var err error
+				if host, err = freeHostPort(); err != nil {
+					return nil, fmt.Errorf("service %s: failed to find a free port for %s: %w", name, target, err)
+				}
+			}
+			allocated[key] = host
+			ports[i] = fmt.Sprintf("%d:%s", host, target)
+		}
+		svc.Ports = ports
+		resolved[name] = svc
+	}
+
+	if len(allocated) > 0 {
+		data, _ := json.MarshalIndent(allocated, "", "  ")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create services directory: %w", err)
+		}
+		if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+			return nil, fmt.Errorf("failed to save allocated ports: %w", err)
+		}
+	}
+	return resolved, nil
+}
+
+// hasAutoPorts reports whether any mapping asks for an allocated port.
+func hasAutoPorts(ports []string) bool {
+	for _, p := range ports {
+		if strings.HasPrefix(p, autoPortPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// freeHostPort returns a TCP port nothing is listening on. The port is
+// free when checked; compose binds it moments later.
+func freeHostPort() (int, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// publishedPort is a host port a service's compose mapping binds.
+type publishedPort struct {
+	IP     string // "" for all interfaces
+	Port   int
+	Target string // container port
+}
+
+// publishedPorts returns the TCP host ports in mappings such as
+// "5432:5432" and "127.0.0.1:8080:80". A bare container port gets an
+// ephemeral host port and UDP mappings are not probed, so both are skipped.
+func publishedPorts(ports []string) []publishedPort {
+	var published []publishedPort
+	for _, p := range ports {
+		if strings.HasSuffix(p, "/udp") {
+			continue
+		}
+		parts := strings.Split(strings.TrimSuffix(p, "/tcp"), ":")
+		var pp publishedPort
+		switch len(parts) {
+		case 2:
+			pp.Port, pp.Target = parseInt(parts[0], 0), parts[1]
+		case 3:
+			pp.IP, pp.Port, pp.Target = parts[0], parseInt(parts[1], 0), parts[2]
+		}
+		if pp.Port > 0 {
+			published = append(published, pp)
+		}
+	}
+	return published
+}
+
+// portInUse reports whether something already listens on the host port.
+func portInUse(pp publishedPort) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort(pp.IP, strconv.Itoa(pp.Port)))
+	if err != nil {
+		return true
+	}
+	l.Close()
+	return false
+}
+
+// portOwner describes what holds a host port: a container, a process, or
+// "" if that cannot be told. ours is true for the agent's own container,
+// e.g. a persistent service still running from an earlier run. A variable
+// so tests can stand in for the engine and lsof.
+var portOwner = func(engine containerEngine, agentName string, port int) (owner string, ours bool) {
+	publish := fmt.Sprintf("publish=%d", port)
+	out, err := engine.command("ps", "-q", "--filter", "label=sfa.agent="+agentName, "--filter", publish).Output()
+	if err == nil && strings.TrimSpace(string(out)) != "" {
+		return "", true
+	}
+	out, err = engine.command("ps", "--filter", publish, "--format", "{{.Names}}").Output()
+	if name := strings.TrimSpace(string(out)); err == nil && name != "" {
+		return "container " + strings.Fields(name)[0], false
+	}
+	out, err = exec.Command("lsof", "-nP", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN", "-Fpc").Output()
+	if err == nil {
+		return parseLsofOwner(string(out)), false
+	}
+	return "", false
+}
+
+// parseLsofOwner reads the first process from lsof -Fpc output, e.g.
+// "process postgres (pid 812)".
+func parseLsofOwner(out string) string {
+	pid, command := "", ""
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "p") && pid == "":
+			pid = line[1:]
+		case strings.HasPrefix(line, "c") && command == "":
+			command = line[1:]
+		}
+	}
+	if pid == "" {
+		return ""
+	}
+	if command == "" {
+		return fmt.Sprintf("process %s", pid)
+	}
+	return fmt.Sprintf("process %s (pid %s)", command, pid)
+}
+
+// checkPortConflicts fails if a host port the services publish is already
+// taken by anything other than the agent's own containers, naming the
+// holder and the ways around it, instead of leaving compose up to fail.
+func checkPortConflicts(engine containerEngine, agentName string, services map[string]ServiceDef) error {
+	var conflicts []string
+	for _, name := range sortedKeys(services) {
+		for _, pp := range publishedPorts(services[name].Ports) {
+			if !portInUse(pp) {
+				continue
+			}
+			owner, ours := portOwner(engine, agentName, pp.Port)
+			if ours {
+				continue
+			}
+			if owner == "" {
+				owner = "another process"
+			}
+			conflicts = append(conflicts, fmt.Sprintf("port %d for service %s is in use by %s; stop it, set %s to use an existing %s instead, or map the port as %s%s to pick a free one",
+				pp.Port, name, owner, serviceEnvName(name, "URL"), name, autoPortPrefix, pp.Target))
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	return fmt.Errorf("cannot start services, host ports are taken:\n  • %s", strings.Join(conflicts, "\n  • "))
+}
+
+// composePort returns the host address compose published a service's
+// container port on, e.g. "0.0.0.0:54321"; a variable so tests can stand
+// in for the engine.
+var composePort = func(engine containerEngine, composePath, service, target string) (string, error) {
+	out, err := engine.composeCommand(composePath, "port", service, target).Output()
+	if err != nil {
+		return "", err
+	}
+	// One line per address family, e.g. 0.0.0.0:54321 and [::]:54321
+	return strings.TrimSpace(strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]), nil
+}
+
+// firstTCPPort returns the index of a service's first TCP port mapping and
+// its container port, or -1 if it has none.
+func firstTCPPort(ports []string) (int, string) {
+	for i, p := range ports {
+		if strings.HasSuffix(p, "/udp") {
+			continue
+		}
+		parts := strings.Split(strings.TrimSuffix(p, "/tcp"), ":")
+		if target := parts[len(parts)-1]; parseInt(target, 0) > 0 {
+			return i, target
+		}
+	}
+	return -1, ""
+}
+
+// discoverPublishedPorts asks compose which host port each running service
+// was actually published on, and returns the services with their first TCP
+// mapping rewritten to it. This covers a bare container port, which gets an
+// ephemeral host port, and anything remapped since the compose file was
+// written. A service compose reports nothing for keeps its declared ports.
+func discoverPublishedPorts(engine containerEngine, composePath string, services map[string]ServiceDef) map[string]ServiceDef {
+	discovered := make(map[string]ServiceDef, len(services))
+	for _, name := range sortedKeys(services) {
+		svc := services[name]
+		discovered[name] = svc
+		i, target := firstTCPPort(svc.Ports)
+		if i < 0 {
+			continue
+		}
+		addr, err := composePort(engine, composePath, name, target)
+		hostPort := addr[strings.LastIndex(addr, ":")+1:]
+		if err != nil || parseInt(hostPort, 0) <= 0 {
+			if servicePort(svc) == "" {
+				stderrLog.Warn(fmt.Sprintf("could not find the host port of service %s", name))
+			}
+			continue
+		}
+
+		mapping := hostPort + ":" + target
+		if published := publishedPorts(svc.Ports[i : i+1]); len(published) == 1 && published[0].IP != "" {
+			mapping = published[0].IP + ":" + mapping
+		}
+		svc.Ports = append([]string(nil), svc.Ports...)
+		svc.Ports[i] = mapping
+		discovered[name] = svc
+	}
+	return discovered
+}
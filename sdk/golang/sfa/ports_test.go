@@ -0,0 +1,156 @@
+package sfa
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAllocateAutoPorts(t *testing.T) {
+	t.Setenv("SFA_DATA_HOME", t.TempDir())
+	services := map[string]ServiceDef{
+		"db":    {Image: "postgres:16", Ports: []string{"auto:5432"}},
+		"cache": {Image: "redis:7", Ports: []string{"6379:6379"}},
+	}
+
+	first, err := allocateAutoPorts("porter", services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := first["db"].Ports[0]
+	host, target, _ := strings.Cut(mapping, ":")
+	if target != "5432" || parseInt(host, 0) <= 0 {
+		t.Fatalf("db port = %q, want <host>:5432", mapping)
+	}
+	if first["cache"].Ports[0] != "6379:6379" {
+		t.Errorf("fixed port changed: %v", first["cache"].Ports)
+	}
+	if services["db"].Ports[0] != "auto:5432" {
+		t.Error("allocateAutoPorts modified its input")
+	}
+
+	// The saved port is reused, so persistent services keep it
+	second, err := allocateAutoPorts("porter", services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second["db"].Ports[0] != mapping {
+		t.Errorf("second run got %q, want the saved %q", second["db"].Ports[0], mapping)
+	}
+
+	path, err := materializeCompose("porter", "1.0.0", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "      - \""+mapping+"\"\n") {
+		t.Errorf("expected %s in compose file:\n%s", mapping, data)
+	}
+
+	bad := map[string]ServiceDef{"db": {Ports: []string{"auto:postgres"}}}
+	if _, err := allocateAutoPorts("porter", bad); err == nil {
+		t.Error("expected an invalid auto port to fail")
+	}
+}
+
+func TestPublishedPorts(t *testing.T) {
+	got := publishedPorts([]string{"5432:5432", "127.0.0.1:8080:80/tcp", "6379", "53:53/udp", "auto:9000"})
+	want := []publishedPort{{Port: 5432, Target: "5432"}, {IP: "127.0.0.1", Port: 8080, Target: "80"}}
+	if len(got) != len(want) {
+		t.Fatalf("published = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("published[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCheckPortConflicts(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	orig := portOwner
+	t.Cleanup(func() { portOwner = orig })
+	ours := false
+	portOwner = func(engine containerEngine, agentName string, p int) (string, bool) {
+		return "container other-postgres-1", ours
+	}
+
+	services := map[string]ServiceDef{
+		"db": {Ports: []string{fmt.Sprintf("127.0.0.1:%d:5432", port)}},
+	}
+	err = checkPortConflicts(containerEngine{}, "porter", services)
+	if err == nil {
+		t.Fatal("expected a conflict")
+	}
+	for _, want := range []string{fmt.Sprintf("port %d for service db", port), "container other-postgres-1", "SFA_SVC_DB_URL", "auto:5432"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+
+	// The agent's own running service is not a conflict
+	ours = true
+	if err := checkPortConflicts(containerEngine{}, "porter", services); err != nil {
+		t.Errorf("expected no conflict with the agent's own container, got %v", err)
+	}
+}
+
+func TestParseLsofOwner(t *testing.T) {
+	if got := parseLsofOwner("p812\ncpostgres\nf7\n"); got != "process postgres (pid 812)" {
+		t.Errorf("owner = %q", got)
+	}
+	if got := parseLsofOwner(""); got != "" {
+		t.Errorf("owner = %q, want empty", got)
+	}
+}
+
+func TestDiscoverPublishedPorts(t *testing.T) {
+	orig := composePort
+	t.Cleanup(func() { composePort = orig })
+	composePort = func(_ containerEngine, _, service, target string) (string, error) {
+		switch service + "/" + target {
+		case "db/5432":
+			return "0.0.0.0:32768", nil
+		case "api/80":
+			return "127.0.0.1:8080", nil
+		case "cache/6379":
+			return "[::]:6380", nil
+		}
+		return "", fmt.Errorf("no port")
+	}
+
+	declared := []string{"5432"}
+	services := map[string]ServiceDef{
+		"db":     {Image: "postgres:16", Ports: declared},
+		"api":    {Image: "api:1", Ports: []string{"9000/udp", "127.0.0.1:8080:80"}},
+		"cache":  {Image: "redis:7", Ports: []string{"6379:6379"}},
+		"search": {Image: "opensearch:2", Ports: []string{"9200:9200"}},
+		"worker": {Image: "worker:1"},
+	}
+	got := discoverPublishedPorts(containerEngine{name: EngineDocker}, "compose.yaml", services)
+	for name, want := range map[string]string{
+		"db":     "32768:5432",
+		"api":    "9000/udp,127.0.0.1:8080:80",
+		"cache":  "6380:6379",
+		"search": "9200:9200",
+		"worker": "",
+	} {
+		if ports := strings.Join(got[name].Ports, ","); ports != want {
+			t.Errorf("%s ports = %q, want %q", name, ports, want)
+		}
+	}
+	if servicePort(got["db"]) != "32768" {
+		t.Errorf("expected db to be reached on 32768, got %q", servicePort(got["db"]))
+	}
+	if declared[0] != "5432" {
+		t.Error("expected the declared ports to be left alone")
+	}
+}
@@ -0,0 +1,48 @@
+package sfa
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Named profiles live under the config's "profiles" key. The one selected
+// by --profile or SFA_PROFILE is layered over the base config, so its
+// defaults and agents.<name> settings (including env) win.
+
+// activeProfile returns the selected profile name, or "".
+func activeProfile() string {
+	return os.Getenv("SFA_PROFILE")
+}
+
+// applyProfile layers the named profile over config. An unknown profile
+// leaves config as it is; checkProfile reports it.
+func applyProfile(config map[string]any, name string) map[string]any {
+	if name == "" {
+		return config
+	}
+	profiles, _ := config["profiles"].(map[string]any)
+	profile, ok := profiles[name].(map[string]any)
+	if !ok {
+		return config
+	}
+	return overlayConfig(config, profile)
+}
+
+// checkProfile returns an error if a profile is selected but config does
+// not define it.
+func checkProfile(config map[string]any) error {
+	name := activeProfile()
+	if name == "" {
+		return nil
+	}
+	profiles, _ := config["profiles"].(map[string]any)
+	if _, ok := profiles[name].(map[string]any); ok {
+		return nil
+	}
+	names := sortedKeys(profiles)
+	if len(names) == 0 {
+		return fmt.Errorf("unknown profile %q: the config defines no profiles", name)
+	}
+	return fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(names, ", "))
+}
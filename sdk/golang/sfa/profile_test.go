@@ -0,0 +1,77 @@
+package sfa
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const profileConfig = `{
+  "defaults": {"timeout": 60, "env": {"REGION": "us-east-1"}},
+  "agents": {"a": {"model": "small", "env": {"API_URL": "https://dev.example", "TOKEN": "base"}}},
+  "profiles": {
+    "prod": {
+      "defaults": {"timeout": 300},
+      "agents": {"a": {"env": {"API_URL": "https://api.example"}}}
+    },
+    "staging": {"defaults": {"env": {"REGION": "eu-west-1"}}}
+  }
+}`
+
+func TestLayeredConfigWithProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := writeTestFile(path, profileConfig); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SFA_CONFIG", path)
+	inDir(t, t.TempDir())
+	decls := []EnvDef{{Name: "API_URL"}, {Name: "TOKEN"}, {Name: "REGION"}}
+
+	t.Setenv("SFA_PROFILE", "")
+	config := loadLayeredConfig()
+	if got := resolveEnv(decls, "a", config).Values["API_URL"]; got != "https://dev.example" {
+		t.Errorf("expected base value without a profile, got %q", got)
+	}
+
+	t.Setenv("SFA_PROFILE", "prod")
+	config = loadLayeredConfig()
+	if err := checkProfile(config); err != nil {
+		t.Fatal(err)
+	}
+	merged := mergeConfig(config, "a")
+	if merged["timeout"] != 300.0 || merged["model"] != "small" {
+		t.Errorf("expected profile defaults over base, got %v", merged)
+	}
+	resolved := resolveEnv(decls, "a", config)
+	if resolved.Values["API_URL"] != "https://api.example" || resolved.Values["TOKEN"] != "base" || resolved.Values["REGION"] != "us-east-1" {
+		t.Errorf("expected profile env layered over base, got %v", resolved.Values)
+	}
+
+	t.Setenv("SFA_PROFILE", "staging")
+	if got := resolveEnv(decls, "a", loadLayeredConfig()).Values["REGION"]; got != "eu-west-1" {
+		t.Errorf("expected staging default env, got %q", got)
+	}
+}
+
+func TestCheckProfileUnknown(t *testing.T) {
+	config, _ := decodeConfig("config.json", []byte(profileConfig))
+	t.Setenv("SFA_PROFILE", "qa")
+	err := checkProfile(config)
+	if err == nil || !strings.Contains(err.Error(), "available: prod, staging") {
+		t.Errorf("expected unknown profile error listing profiles, got %v", err)
+	}
+	if got := applyProfile(config, "qa"); mergeConfig(got, "a")["timeout"] != 60.0 {
+		t.Error("expected an unknown profile to leave config unchanged")
+	}
+	if err := checkProfile(map[string]any{}); err == nil || !strings.Contains(err.Error(), "no profiles") {
+		t.Errorf("expected no-profiles error, got %v", err)
+	}
+}
+
+func TestValidateConfigProfiles(t *testing.T) {
+	config, _ := decodeConfig("config.json", []byte(`{"profiles": {"prod": {"defaults": {"timeout": "long"}, "logging": {}}}}`))
+	got := strings.Join(validateConfig(config), "\n")
+	if !strings.Contains(got, "profiles.prod.defaults.timeout must be a number") || !strings.Contains(got, "unknown key profiles.prod.logging") {
+		t.Errorf("unexpected problems:\n%s", got)
+	}
+}
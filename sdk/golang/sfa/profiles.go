@@ -0,0 +1,128 @@
+package sfa
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// profileNamePattern is what Docker Compose accepts as a profile name.
+var profileNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// checkProfiles rejects a profile name compose would refuse.
+func checkProfiles(name string, svc ServiceDef) error {
+	for _, profile := range svc.Profiles {
+		if !profileNamePattern.MatchString(profile) {
+			return fmt.Errorf("service %s: invalid profile name %q (use letters, digits, ., _, and -)", name, profile)
+		}
+	}
+	return nil
+}
+
+// resolveServiceProfiles returns the compose profiles to enable: those
+// passed with --services-profile and those in the agent's services.profiles
+// config, a list or a comma-separated string. Profiles no service declares
+// are warned about.
+func resolveServiceProfiles(flagged []string, config map[string]any, agentName string, services map[string]ServiceDef) []string {
+	names := append([]string(nil), flagged...)
+	if section, ok := mergeConfig(config, agentName)["services"].(map[string]any); ok {
+		switch v := section["profiles"].(type) {
+		case string:
+			names = append(names, strings.Split(v, ",")...)
+		case []any:
+			for _, p := range v {
+				if s, ok := p.(string); ok {
+					names = append(names, s)
+				}
+			}
+		}
+	}
+
+	declared := make(map[string]bool)
+	for _, svc := range services {
+		for _, profile := range svc.Profiles {
+			declared[profile] = true
+		}
+	}
+	seen := make(map[string]bool)
+	var profiles []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if !declared[name] {
+			stderrLog.Warn(fmt.Sprintf("no service has profile %s", name))
+			continue
+		}
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+// activeServices returns the services compose starts with profiles enabled:
+// those with no profiles, and those with an enabled one. It fails if an
+// active service depends on one that is not started.
+func activeServices(services map[string]ServiceDef, profiles []string) (map[string]ServiceDef, error) {
+	enabled := make(map[string]bool, len(profiles))
+	for _, profile := range profiles {
+		enabled[profile] = true
+	}
+	active := make(map[string]ServiceDef, len(services))
+	for name, svc := range services {
+		if len(svc.Profiles) == 0 {
+			active[name] = svc
+			continue
+		}
+		for _, profile := range svc.Profiles {
+			if enabled[profile] {
+				active[name] = svc
+				break
+			}
+		}
+	}
+	for _, name := range sortedKeys(active) {
+		for _, dep := range sortedKeys(active[name].DependsOn) {
+			if depSvc, ok := services[dep]; ok {
+				if _, started := active[dep]; !started {
+					return nil, fmt.Errorf("service %s depends on %s, which starts only with profile %s (pass --services-profile %s)",
+						name, dep, strings.Join(depSvc.Profiles, " or "), depSvc.Profiles[0])
+				}
+			}
+		}
+	}
+	return active, nil
+}
+
+// profileArgs returns the compose flags enabling profiles.
+func profileArgs(profiles []string) []string {
+	var args []string
+	for _, profile := range profiles {
+		args = append(args, "--profile", profile)
+	}
+	return args
+}
+
+// writeComposeProfiles records the enabled profiles as COMPOSE_PROFILES in
+// the project's .env file, so later compose commands (ps, logs, down) see
+// the same services as up.
+func writeComposeProfiles(composePath string, profiles []string) error {
+	if len(profiles) == 0 {
+		return nil
+	}
+	path := filepath.Join(filepath.Dir(composePath), ".env")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read compose env file: %w", err)
+	}
+	data = append(data, "COMPOSE_PROFILES="+dotenvQuote(strings.Join(profiles, ","))+"\n"...)
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write compose env file: %w", err)
+	}
+	return nil
+}
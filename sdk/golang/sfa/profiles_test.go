@@ -0,0 +1,78 @@
+package sfa
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// adminServices is a database with an admin UI started only on request.
+func adminServices() map[string]ServiceDef {
+	return map[string]ServiceDef{
+		"db":    {Image: "postgres:16"},
+		"admin": {Image: "adminer:4", Profiles: []string{"admin", "debug"}, DependsOn: map[string]string{"db": ""}},
+	}
+}
+
+func TestMaterializeComposeProfiles(t *testing.T) {
+	t.Setenv("SFA_DATA_HOME", t.TempDir())
+	path, err := materializeCompose("profiled", "1.0.0", adminServices())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "    image: adminer:4\n    profiles:\n      - \"admin\"\n      - \"debug\"\n"; !strings.Contains(string(data), want) {
+		t.Errorf("expected admin's profiles in compose file:\n%s", data)
+	}
+
+	if err := writeComposeProfiles(path, []string{"admin", "debug"}); err != nil {
+		t.Fatal(err)
+	}
+	env, err := os.ReadFile(strings.TrimSuffix(path, "compose.yaml") + ".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(env) != "COMPOSE_PROFILES='admin,debug'\n" {
+		t.Errorf(".env = %q", env)
+	}
+
+	bad := map[string]ServiceDef{"admin": {Image: "adminer:4", Profiles: []string{"-x"}}}
+	if _, err := materializeCompose("profiled", "1.0.0", bad); err == nil {
+		t.Error("expected an invalid profile name to be rejected")
+	}
+}
+
+func TestActiveServices(t *testing.T) {
+	active, err := activeServices(adminServices(), nil)
+	if err != nil || strings.Join(sortedKeys(active), ",") != "db" {
+		t.Errorf("without profiles = %v, %v, want db", sortedKeys(active), err)
+	}
+	active, err = activeServices(adminServices(), []string{"debug"})
+	if err != nil || strings.Join(sortedKeys(active), ",") != "admin,db" {
+		t.Errorf("with debug = %v, %v, want admin,db", sortedKeys(active), err)
+	}
+
+	services := adminServices()
+	services["app"] = ServiceDef{Image: "app:1", DependsOn: map[string]string{"admin": ""}}
+	if _, err := activeServices(services, nil); err == nil || !strings.Contains(err.Error(), "--services-profile admin") {
+		t.Errorf("expected a dependency on an inactive service to fail, got %v", err)
+	}
+}
+
+func TestResolveServiceProfiles(t *testing.T) {
+	config := map[string]any{
+		"agents": map[string]any{"profiled": map[string]any{"services": map[string]any{"profiles": []any{"debug", "admin"}}}},
+	}
+	if got := resolveServiceProfiles([]string{"admin", "missing"}, config, "profiled", adminServices()); strings.Join(got, ",") != "admin,debug" {
+		t.Errorf("profiles = %v, want admin,debug", got)
+	}
+
+	config["defaults"] = map[string]any{"services": map[string]any{"profiles": "debug, admin"}}
+	delete(config, "agents")
+	if got := resolveServiceProfiles(nil, config, "profiled", adminServices()); strings.Join(got, ",") != "admin,debug" {
+		t.Errorf("comma-separated profiles = %v, want admin,debug", got)
+	}
+}
@@ -0,0 +1,118 @@
+package sfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// projectMarker is the project marker written by `sfa init`. As a file it
+// is JSON whose optional "config" object is the project's config layer; as
+// a directory it holds a config.{json,yaml,yml,toml} file.
+const projectMarker = ".sfa"
+
+// loadLayeredConfig returns the user config with the project config and
+// then the active profile layered on top, and ${VAR} references expanded.
+// Setup reads and writes the user config alone, unexpanded (loadConfig).
+func loadLayeredConfig() map[string]any {
+	config := loadConfig()
+	if project, _ := loadProjectConfig(); project != nil {
+		config = overlayConfig(config, project)
+	}
+	config = applyProfile(config, activeProfile())
+	return expandConfig(config, configVarLookup())
+}
+
+// loadProjectConfig finds the nearest .sfa marker at or above the working
+// directory and returns its config layer and path, or nil if there is none.
+// An unreadable or malformed layer is skipped with a warning.
+func loadProjectConfig() (map[string]any, string) {
+	marker := findProjectMarker()
+	if marker == "" {
+		return nil, ""
+	}
+	info, err := os.Stat(marker)
+	if err != nil {
+		return nil, ""
+	}
+
+	if info.IsDir() {
+		for _, name := range configFileNames {
+			path := filepath.Join(marker, name)
+			data, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				stderrLog.Warn(fmt.Sprintf("cannot read project config: %v", err))
+				return nil, ""
+			}
+			config, err := decodeConfig(path, data)
+			if err != nil {
+				stderrLog.Warn(fmt.Sprintf("ignoring malformed project config %s: %v", path, err))
+				return nil, ""
+			}
+			warnConfigProblems(path, config)
+			return config, path
+		}
+		return nil, ""
+	}
+
+	data, err := os.ReadFile(marker)
+	if err != nil {
+		stderrLog.Warn(fmt.Sprintf("cannot read project config: %v", err))
+		return nil, ""
+	}
+	var m struct {
+		Config map[string]any `json:"config"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		stderrLog.Warn(fmt.Sprintf("ignoring malformed project marker %s: %v", marker, err))
+		return nil, ""
+	}
+	if m.Config == nil {
+		return nil, ""
+	}
+	warnConfigProblems(marker, m.Config)
+	return m.Config, marker
+}
+
+// findProjectMarker returns the path of the nearest .sfa at or above the
+// working directory, or "".
+func findProjectMarker() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, projectMarker)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// overlayConfig returns base with over applied on top: nested objects merge
+// key by key, and any other value in over replaces base's.
+func overlayConfig(base, over map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		bm, bok := merged[k].(map[string]any)
+		om, ook := v.(map[string]any)
+		if bok && ook {
+			merged[k] = overlayConfig(bm, om)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,82 @@
+package sfa
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// inDir runs the rest of the test from dir.
+func inDir(t *testing.T, dir string) {
+	t.Helper()
+	prev, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(prev) })
+}
+
+func TestLayeredConfigFromMarkerDirectory(t *testing.T) {
+	root := t.TempDir()
+	userPath := filepath.Join(root, "user.json")
+	if err := writeTestFile(userPath, `{"defaults": {"timeout": 60, "verbose": true}, "agents": {"a": {"model": "small", "env": {"X": "user"}}}}`); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SFA_CONFIG", userPath)
+
+	project := filepath.Join(root, "repo")
+	os.MkdirAll(filepath.Join(project, ".sfa"), 0755)
+	os.MkdirAll(filepath.Join(project, "sub", "dir"), 0755)
+	if err := writeTestFile(filepath.Join(project, ".sfa", "config.yaml"), "defaults:\n  timeout: 300\nagents:\n  a:\n    model: large\n"); err != nil {
+		t.Fatal(err)
+	}
+	inDir(t, filepath.Join(project, "sub", "dir"))
+
+	config := loadLayeredConfig()
+	merged := mergeConfig(config, "a")
+	if merged["timeout"] != 300.0 || merged["verbose"] != true || merged["model"] != "large" {
+		t.Errorf("unexpected layered config: %v", merged)
+	}
+	if env := agentEnvNamespace(config, "a"); env["X"] != "user" {
+		t.Errorf("expected user env to survive layering, got %v", env)
+	}
+	if user := loadConfig(); mergeConfig(user, "a")["model"] != "small" {
+		t.Error("expected loadConfig to return the user layer alone")
+	}
+}
+
+func TestLayeredConfigFromMarkerFile(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("SFA_CONFIG", filepath.Join(root, "missing.json"))
+	if err := writeTestFile(filepath.Join(root, ".sfa"), `{"language": "golang", "sdkPath": "sfa", "config": {"defaults": {"timeout": 45}}}`); err != nil {
+		t.Fatal(err)
+	}
+	inDir(t, root)
+
+	if got := mergeConfig(loadLayeredConfig(), "a")["timeout"]; got != 45.0 {
+		t.Errorf("expected timeout from marker config, got %v", got)
+	}
+
+	// A marker without a config block adds nothing
+	if err := writeTestFile(filepath.Join(root, ".sfa"), `{"language": "golang", "sdkPath": "sfa"}`); err != nil {
+		t.Fatal(err)
+	}
+	if config, path := loadProjectConfig(); config != nil || path != "" {
+		t.Errorf("expected no project layer, got %v from %s", config, path)
+	}
+}
+
+func TestOverlayConfig(t *testing.T) {
+	base := map[string]any{"a": map[string]any{"x": 1.0, "y": 2.0}, "list": []any{1.0}}
+	got := overlayConfig(base, map[string]any{"a": map[string]any{"y": 3.0}, "list": []any{2.0}})
+	a := got["a"].(map[string]any)
+	if a["x"] != 1.0 || a["y"] != 3.0 || got["list"].([]any)[0] != 2.0 {
+		t.Errorf("unexpected overlay: %v", got)
+	}
+	if base["a"].(map[string]any)["y"] != 2.0 {
+		t.Error("overlay modified its base")
+	}
+}
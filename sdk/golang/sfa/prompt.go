@@ -0,0 +1,104 @@
+package sfa
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrNonInteractive is returned by Confirm and Prompt under --non-interactive.
+var ErrNonInteractive = errors.New("cannot prompt in non-interactive mode")
+
+// prompter asks the user questions on the terminal. It reads the TTY rather
+// than stdin, which carries context input.
+type prompter struct {
+	agentName      string
+	yes            bool
+	nonInteractive bool
+	openTTY        func() (io.ReadWriteCloser, error)
+}
+
+func newPrompter(agentName string, flags StandardFlags) *prompter {
+	return &prompter{
+		agentName:      agentName,
+		yes:            flags.Yes,
+		nonInteractive: flags.NonInteractive,
+		openTTY:        openTTY,
+	}
+}
+
+// confirm asks a yes/no question. --yes answers yes without prompting;
+// otherwise --non-interactive fails with ErrNonInteractive.
+func (p *prompter) confirm(question string) (bool, error) {
+	if p.yes {
+		stderrLog.Info(fmt.Sprintf("%s yes (--yes)", question), "agent", p.agentName)
+		return true, nil
+	}
+	if p.nonInteractive {
+		return false, fmt.Errorf("%w: %s", ErrNonInteractive, question)
+	}
+	return p.ask(question)
+}
+
+// prompt asks for a value, returning def for an empty answer. --yes accepts
+// the default without prompting; otherwise --non-interactive fails with
+// ErrNonInteractive.
+func (p *prompter) prompt(question, def string) (string, error) {
+	if p.yes {
+		stderrLog.Info(fmt.Sprintf("%s %s (--yes)", question, def), "agent", p.agentName)
+		return def, nil
+	}
+	if p.nonInteractive {
+		return "", fmt.Errorf("%w: %s", ErrNonInteractive, question)
+	}
+
+	label := question
+	if def != "" {
+		label += fmt.Sprintf(" [%s]", def)
+	}
+	answer, err := p.readLine(label + " ")
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// ask prompts for a yes/no answer on the terminal, defaulting to no.
+func (p *prompter) ask(question string) (bool, error) {
+	answer, err := p.readLine(question + " [y/N] ")
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// readLine writes label to the terminal and reads one trimmed line.
+func (p *prompter) readLine(label string) (string, error) {
+	tty, err := p.openTTY()
+	if err != nil {
+		return "", fmt.Errorf("no terminal available for prompt: %w", err)
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "[agent:%s] %s", p.agentName, label)
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && answer == "" {
+		return "", fmt.Errorf("failed to read answer: %w", err)
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// openTTY opens the controlling terminal for interactive prompts.
+func openTTY() (io.ReadWriteCloser, error) {
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
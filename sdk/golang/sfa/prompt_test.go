@@ -0,0 +1,71 @@
+package sfa
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPromptReadsTerminal(t *testing.T) {
+	opened := 0
+	p := newPrompter("test-agent", StandardFlags{})
+	p.openTTY = ttyAnswering("eu-west-1\n", &opened)
+
+	got, err := p.prompt("Region?", "us-east-1")
+	if err != nil || got != "eu-west-1" {
+		t.Errorf("expected typed answer, got %q (%v)", got, err)
+	}
+
+	p.openTTY = ttyAnswering("\n", &opened)
+	if got, _ := p.prompt("Region?", "us-east-1"); got != "us-east-1" {
+		t.Errorf("expected default for empty answer, got %q", got)
+	}
+}
+
+func TestConfirmReadsTerminal(t *testing.T) {
+	opened := 0
+	p := newPrompter("test-agent", StandardFlags{})
+	for answer, want := range map[string]bool{"y\n": true, "YES\n": true, "n\n": false, "\n": false} {
+		p.openTTY = ttyAnswering(answer, &opened)
+		if got, err := p.confirm("Overwrite?"); err != nil || got != want {
+			t.Errorf("answer %q: expected %v, got %v (%v)", answer, want, got, err)
+		}
+	}
+}
+
+func TestPromptYesAutoAnswers(t *testing.T) {
+	opened := 0
+	p := newPrompter("test-agent", StandardFlags{Yes: true, NonInteractive: true})
+	p.openTTY = ttyAnswering("n\n", &opened)
+
+	captureStderr(t, func() {
+		if ok, err := p.confirm("Overwrite?"); !ok || err != nil {
+			t.Errorf("expected yes under --yes, got %v (%v)", ok, err)
+		}
+		if got, err := p.prompt("Region?", "us-east-1"); got != "us-east-1" || err != nil {
+			t.Errorf("expected default under --yes, got %q (%v)", got, err)
+		}
+	})
+	if opened != 0 {
+		t.Error("expected no terminal prompt under --yes")
+	}
+}
+
+func TestPromptNonInteractiveErrors(t *testing.T) {
+	p := newPrompter("test-agent", StandardFlags{NonInteractive: true})
+	if _, err := p.confirm("Overwrite?"); !errors.Is(err, ErrNonInteractive) {
+		t.Errorf("expected ErrNonInteractive, got %v", err)
+	}
+	if _, err := p.prompt("Region?", "x"); !errors.Is(err, ErrNonInteractive) {
+		t.Errorf("expected ErrNonInteractive, got %v", err)
+	}
+}
+
+func TestPromptWithoutTerminal(t *testing.T) {
+	p := newPrompter("test-agent", StandardFlags{})
+	p.openTTY = func() (io.ReadWriteCloser, error) { return nil, errors.New("no tty") }
+	if _, err := p.confirm("Overwrite?"); err == nil || !strings.Contains(err.Error(), "no terminal") {
+		t.Errorf("expected terminal error, got %v", err)
+	}
+}
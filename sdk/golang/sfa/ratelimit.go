@@ -0,0 +1,146 @@
+package sfa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket shared by every agent in a session. Its
+// state lives in a file under the session's rate-limit directory, so
+// concurrent agents calling the same external API draw from one quota.
+// Agents sharing a limiter name should use the same rate and burst.
+type RateLimiter struct {
+	name  string
+	rps   float64
+	burst int
+	path  string // empty when no state directory is available
+
+	mu    sync.Mutex
+	local rateLimitState // used when path is empty
+}
+
+// rateLimitState is the on-disk form of a token bucket.
+type rateLimitState struct {
+	Tokens  float64 `json:"tokens"`
+	Updated int64   `json:"updated"` // unix nanoseconds of the last refill
+}
+
+// resolveRateLimitDir returns the directory holding shared rate limiter state.
+func resolveRateLimitDir() string {
+	return dataDir("ratelimits")
+}
+
+// newRateLimiter returns the limiter called name within the session.
+func newRateLimiter(dir, sessionID, name string, rps float64, burst int) *RateLimiter {
+	l := &RateLimiter{name: name, rps: rps, burst: burst}
+	if dir != "" && sessionID != "" {
+		l.path = filepath.Join(dir, sessionID, name+".json")
+	}
+	return l
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if err := l.validate(); err != nil {
+		return err
+	}
+	for {
+		delay, err := l.reserve(time.Now())
+		if err != nil || delay == 0 {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Allow takes a token if one is available without waiting.
+func (l *RateLimiter) Allow() bool {
+	if l.validate() != nil {
+		return false
+	}
+	delay, err := l.reserve(time.Now())
+	return err == nil && delay == 0
+}
+
+func (l *RateLimiter) validate() error {
+	if l.name == "" || strings.ContainsAny(l.name, `/\`) || l.name == "." || l.name == ".." {
+		return fmt.Errorf("invalid rate limiter name %q", l.name)
+	}
+	if l.rps <= 0 {
+		return fmt.Errorf("rate limiter %s: rps must be positive", l.name)
+	}
+	if l.burst < 1 {
+		return fmt.Errorf("rate limiter %s: burst must be at least 1", l.name)
+	}
+	return nil
+}
+
+// reserve refills the bucket and takes a token if one is available. It
+// returns zero on success, or how long to wait before the next token.
+func (l *RateLimiter) reserve(now time.Time) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.path == "" {
+		return l.take(&l.local, now), nil
+	}
+
+	var delay time.Duration
+	err := withFileLock(l.path, func() error {
+		var state rateLimitState
+		data, err := os.ReadFile(l.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &state); err != nil {
+				state = rateLimitState{} // corrupt state starts a fresh bucket
+			}
+		}
+
+		delay = l.take(&state, now)
+
+		data, err = json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(l.path, data, 0644)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("rate limiter %s: %w", l.name, err)
+	}
+	return delay, nil
+}
+
+// take refills state for the time elapsed since its last update, then
+// consumes a token or computes the wait until one accrues.
+func (l *RateLimiter) take(state *rateLimitState, now time.Time) time.Duration {
+	if state.Updated == 0 {
+		state.Tokens = float64(l.burst)
+	} else if elapsed := now.Sub(time.Unix(0, state.Updated)); elapsed > 0 {
+		state.Tokens += elapsed.Seconds() * l.rps
+	}
+	if state.Tokens > float64(l.burst) {
+		state.Tokens = float64(l.burst)
+	}
+	state.Updated = now.UnixNano()
+
+	if state.Tokens >= 1 {
+		state.Tokens--
+		return 0
+	}
+	return time.Duration((1 - state.Tokens) / l.rps * float64(time.Second))
+}
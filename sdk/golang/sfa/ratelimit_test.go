@@ -0,0 +1,93 @@
+package sfa
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurstThenRefill(t *testing.T) {
+	l := newRateLimiter(t.TempDir(), "sess-1", "api", 10, 2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if d, err := l.reserve(now); err != nil || d != 0 {
+			t.Fatalf("expected burst token %d, got delay %v err %v", i, d, err)
+		}
+	}
+	d, err := l.reserve(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d <= 0 || d > 100*time.Millisecond {
+		t.Errorf("expected a wait of at most 100ms at 10 rps, got %v", d)
+	}
+	if d, _ := l.reserve(now.Add(200 * time.Millisecond)); d != 0 {
+		t.Errorf("expected a token after refill, got delay %v", d)
+	}
+}
+
+func TestRateLimiterSharedAcrossInstances(t *testing.T) {
+	dir := t.TempDir()
+	a := newRateLimiter(dir, "sess-1", "api", 1, 1)
+	b := newRateLimiter(dir, "sess-1", "api", 1, 1)
+	other := newRateLimiter(dir, "sess-2", "api", 1, 1)
+
+	if !a.Allow() {
+		t.Fatal("expected first token")
+	}
+	if b.Allow() {
+		t.Error("expected a second limiter in the same session to share the bucket")
+	}
+	if !other.Allow() {
+		t.Error("expected a different session to have its own bucket")
+	}
+}
+
+func TestRateLimiterWaitConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l := newRateLimiter(dir, "sess-1", "api", 20, 1)
+			if err := l.Wait(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// One token up front, then three more at 20/s
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("expected waits to be serialized through the shared bucket, took %v", elapsed)
+	}
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	l := newRateLimiter("", "", "api", 0.1, 1)
+	if !l.Allow() {
+		t.Fatal("expected first token from in-memory bucket")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestRateLimiterValidation(t *testing.T) {
+	for _, l := range []*RateLimiter{
+		newRateLimiter("", "", "../x", 1, 1),
+		newRateLimiter("", "", "api", 0, 1),
+		newRateLimiter("", "", "api", 1, 0),
+	} {
+		if err := l.Wait(context.Background()); err == nil {
+			t.Errorf("expected validation error for %+v", l)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package sfa
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// readyProbeURL returns the probe URL with ${host} and ${port} filled in.
+func readyProbeURL(host string, svc ServiceDef) string {
+	return strings.NewReplacer("${host}", host, "${port}", servicePort(svc)).Replace(svc.Ready.URL)
+}
+
+// checkReadyProbe rejects a probe the SDK cannot run, before Docker is
+// invoked.
+func checkReadyProbe(name string, svc ServiceDef) error {
+	if svc.Ready == nil {
+		return nil
+	}
+	if strings.Contains(svc.Ready.URL, "${port}") {
+		if servicePort(svc) == "" {
+			return fmt.Errorf("service %s: ready probe uses ${port}, but the service publishes no host port", name)
+		}
+	}
+	u, err := url.Parse(readyProbeURL("localhost", svc))
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("service %s: invalid ready probe %q (use tcp://host:port or http://host:port/path)", name, svc.Ready.URL)
+	}
+	switch u.Scheme {
+	case "tcp", "http", "https":
+	default:
+		return fmt.Errorf("service %s: unsupported ready probe scheme %q (use tcp, http, or https)", name, u.Scheme)
+	}
+	if svc.Ready.Status != 0 && u.Scheme == "tcp" {
+		return fmt.Errorf("service %s: a tcp ready probe cannot expect an HTTP status", name)
+	}
+	return nil
+}
+
+// probeReady runs a service's probe once, returning why it is not ready.
+func probeReady(host string, svc ServiceDef) error {
+	target := readyProbeURL(host, svc)
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "tcp" {
+		conn, err := net.DialTimeout("tcp", u.Host, 2*time.Second)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(target)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if want := svc.Ready.Status; want != 0 && resp.StatusCode != want {
+		return fmt.Errorf("%s answered %d, want %d", target, resp.StatusCode, want)
+	} else if want == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("%s answered %d", target, resp.StatusCode)
+	}
+	return nil
+}
+
+// waitForReady polls the services' ready probes, with ${host} as host,
+// until all pass, failing with the last reason for each service still not
+// ready.
+func waitForReady(agentName, host string, services map[string]ServiceDef, timeout time.Duration) error {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	pending := make(map[string]error)
+	for name, svc := range services {
+		if svc.Ready != nil {
+			pending[name] = nil
+		}
+	}
+
+	for len(pending) > 0 {
+		for _, name := range sortedKeys(pending) {
+			if err := probeReady(host, services[name]); err != nil {
+				pending[name] = err
+			} else {
+				delete(pending, name)
+				emitProgress(agentName, fmt.Sprintf("service %s ready after %s", name, formatElapsed(time.Since(start))))
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			var reasons []string
+			for _, name := range sortedKeys(pending) {
+				reasons = append(reasons, fmt.Sprintf("%s: %v", name, pending[name]))
+			}
+			return fmt.Errorf("services failed their ready probes within %s:\n  • %s", formatElapsed(timeout), strings.Join(reasons, "\n  • "))
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package sfa
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckReadyProbe(t *testing.T) {
+	for name, svc := range map[string]ServiceDef{
+		"tcp":  {Ports: []string{"5432:5432"}, Ready: &ReadyProbe{URL: "tcp://${host}:${port}"}},
+		"http": {Ports: []string{"127.0.0.1:8080:80"}, Ready: &ReadyProbe{URL: "http://${host}:${port}/health", Status: 204}},
+		"none": {},
+	} {
+		if err := checkReadyProbe(name, svc); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for name, svc := range map[string]ServiceDef{
+		"no port":    {Ready: &ReadyProbe{URL: "tcp://localhost:${port}"}},
+		"scheme":     {Ready: &ReadyProbe{URL: "redis://localhost:6379"}},
+		"no host":    {Ready: &ReadyProbe{URL: "localhost"}},
+		"tcp status": {Ready: &ReadyProbe{URL: "tcp://localhost:1", Status: 200}},
+	} {
+		if err := checkReadyProbe(name, svc); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	httpPort := srv.Listener.Addr().(*net.TCPAddr).Port
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tcpPort := l.Addr().(*net.TCPAddr).Port
+
+	services := map[string]ServiceDef{
+		"api": {Ports: []string{fmt.Sprintf("%d:80", httpPort)}, Ready: &ReadyProbe{URL: "http://127.0.0.1:${port}/health"}},
+		"db":  {Ports: []string{fmt.Sprintf("%d:5432", tcpPort)}, Ready: &ReadyProbe{URL: "tcp://127.0.0.1:${port}"}},
+		"raw": {Image: "no-probe"},
+	}
+
+	err = waitForReady("prober", "localhost", services, 0)
+	if err == nil || !strings.Contains(err.Error(), "api:") || strings.Contains(err.Error(), "db:") {
+		t.Errorf("expected only api to fail while it answers 503, got %v", err)
+	}
+
+	status = http.StatusOK
+	if err := waitForReady("prober", "localhost", services, 0); err != nil {
+		t.Errorf("expected all ready, got %v", err)
+	}
+}
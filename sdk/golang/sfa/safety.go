@@ -5,60 +5,79 @@ import (
 	"crypto/rand"
 	"fmt"
 	"os"
-	"os/signal"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
 )
 
 // SafetyState tracks invocation depth, call chain, and session identity.
 type SafetyState struct {
-	Depth     int
-	MaxDepth  int
-	CallChain []string
-	SessionID string
+	Depth      int
+	MaxDepth   int
+	CallChain  []string
+	Hops       []CallHop // CallChain with versions and start times, where known
+	SessionID  string
+	LoopPolicy LoopPolicy  // the agent's declared policy
+	LoopBound  *LoopPolicy // SFA_LOOP_POLICY, bounding every agent in the session; nil when unset
 }
 
 // initSafety reads SFA_* safety env vars, performs loop detection, and propagates state.
-func initSafety(agentName string, maxDepthFlag int) (*SafetyState, error) {
+// SFA_LOOP_POLICY, when set, bounds the agent's declared loop policy: a
+// repeat is permitted only when both allow it.
+func initSafety(agentName, version string, maxDepthFlag int, policy LoopPolicy) (*SafetyState, error) {
 	depth := parseInt(os.Getenv("SFA_DEPTH"), 0)
 	maxDepth := parseInt(os.Getenv("SFA_MAX_DEPTH"), maxDepthFlag)
 
-	// Parse call chain
-	chainStr := os.Getenv("SFA_CALL_CHAIN")
-	var chain []string
-	if chainStr != "" {
-		chain = strings.Split(chainStr, ",")
+	var bound *LoopPolicy
+	if envPolicy := os.Getenv("SFA_LOOP_POLICY"); envPolicy != "" {
+		p, err := parseLoopPolicy(envPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SFA_LOOP_POLICY: %w", err)
+		}
+		bound = &p
 	}
 
-	// Loop detection — check if this agent is already in the call chain
-	for _, name := range chain {
-		if name == agentName {
-			chain = append(chain, agentName)
-			return nil, fmt.Errorf("loop detected: %s", strings.Join(chain, " → "))
+	// Parse call chain (names plus encoded detail, when an SFA-aware parent provided it)
+	hops := parseCallChain(os.Getenv("SFA_CALL_CHAIN"), os.Getenv("SFA_CALL_CHAIN_DETAIL"))
+	self := CallHop{Name: agentName, Version: version, Depth: depth, Start: time.Now().UTC()}
+
+	// Loop detection — check if this agent may re-enter the call chain
+	if err := evaluateLoop(hops, self, policy); err != nil {
+		return nil, err
+	}
+	if bound != nil {
+		if err := evaluateLoop(hops, self, *bound); err != nil {
+			return nil, err
 		}
 	}
 
 	// Append current agent to call chain
-	chain = append(chain, agentName)
+	hops = append(hops, self)
+	chain := hopNames(hops)
 
 	// Session ID — generate if top-level
 	sessionID := os.Getenv("SFA_SESSION_ID")
 	if sessionID == "" {
 		sessionID = generateUUID()
+	} else if err := validateSessionID(sessionID); err != nil {
+		return nil, fmt.Errorf("invalid SFA_SESSION_ID: %w", err)
 	}
 
 	safety := &SafetyState{
-		Depth:     depth,
-		MaxDepth:  maxDepth,
-		CallChain: chain,
-		SessionID: sessionID,
+		Depth:      depth,
+		MaxDepth:   maxDepth,
+		CallChain:  chain,
+		Hops:       hops,
+		SessionID:  sessionID,
+		LoopPolicy: policy,
+		LoopBound:  bound,
 	}
 
 	// Propagate to process environment
 	os.Setenv("SFA_DEPTH", fmt.Sprintf("%d", depth))
 	os.Setenv("SFA_MAX_DEPTH", fmt.Sprintf("%d", maxDepth))
 	os.Setenv("SFA_CALL_CHAIN", strings.Join(chain, ","))
+	os.Setenv("SFA_CALL_CHAIN_DETAIL", encodeCallChain(hops))
 	os.Setenv("SFA_SESSION_ID", sessionID)
 
 	return safety, nil
@@ -72,24 +91,111 @@ func checkDepthLimit(safety *SafetyState) error {
 	return nil
 }
 
-// checkLoop returns an error if the target agent is already in the call chain.
+// checkLoop returns an error if the target agent may not re-enter the call
+// chain under the session's SFA_LOOP_POLICY bound. A re-entry is otherwise
+// for the target to decide under its own policy, which the invoking agent
+// does not know, as it starts.
 func checkLoop(safety *SafetyState, targetAgent string) error {
-	for _, name := range safety.CallChain {
-		if name == targetAgent {
-			chain := append(safety.CallChain, targetAgent)
-			return fmt.Errorf("loop detected: %s", strings.Join(chain, " → "))
+	if safety.LoopBound == nil {
+		return nil
+	}
+	return evaluateLoop(safety.callHops(), CallHop{Name: targetAgent}, *safety.LoopBound)
+}
+
+// callHops returns Hops, or bare hops derived from CallChain when Hops is unset.
+func (s *SafetyState) callHops() []CallHop {
+	if len(s.Hops) == len(s.CallChain) {
+		return s.Hops
+	}
+	hops := make([]CallHop, len(s.CallChain))
+	for i, name := range s.CallChain {
+		hops[i] = CallHop{Name: name}
+	}
+	return hops
+}
+
+// evaluateLoop decides whether agent may be appended to chain.
+// deny rejects any repeat; allow-self permits up to Max consecutive
+// self-invocations; allow-cycles permits up to Max repeats anywhere in the chain.
+func evaluateLoop(chain []CallHop, agent CallHop, policy LoopPolicy) error {
+	count := 0
+	for _, hop := range chain {
+		if hop.Name == agent.Name {
+			count++
 		}
 	}
-	return nil
+	if count == 0 {
+		return nil
+	}
+
+	switch policy.Mode {
+	case LoopAllowSelf:
+		// Every prior occurrence must be part of the trailing self-recursion run
+		run := 0
+		for i := len(chain) - 1; i >= 0 && chain[i].Name == agent.Name; i-- {
+			run++
+		}
+		if run == count && count <= policy.Max {
+			return nil
+		}
+	case LoopAllowCycles:
+		if count <= policy.Max {
+			return nil
+		}
+	}
+
+	looped := append(append([]CallHop{}, chain...), agent)
+	if policy.Mode == "" || policy.Mode == LoopDeny {
+		return fmt.Errorf("loop detected: %s", formatHops(looped))
+	}
+	return fmt.Errorf("loop detected: %s (loop policy %s exceeded)", formatHops(looped), policy)
+}
+
+// parseLoopPolicy parses "deny", "allow-self N", or "allow-cycles N".
+// A colon may be used instead of the space (e.g. "allow-self:3").
+func parseLoopPolicy(s string) (LoopPolicy, error) {
+	fields := strings.Fields(strings.Replace(strings.TrimSpace(s), ":", " ", 1))
+	if len(fields) == 0 {
+		return LoopPolicy{}, fmt.Errorf("empty loop policy")
+	}
+
+	mode := LoopMode(fields[0])
+	switch mode {
+	case LoopDeny:
+		if len(fields) != 1 {
+			return LoopPolicy{}, fmt.Errorf("deny takes no count, got %q", s)
+		}
+		return LoopPolicy{Mode: LoopDeny}, nil
+	case LoopAllowSelf, LoopAllowCycles:
+		if len(fields) != 2 {
+			return LoopPolicy{}, fmt.Errorf("%s requires a count, got %q", mode, s)
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 {
+			return LoopPolicy{}, fmt.Errorf("invalid count in %q", s)
+		}
+		return LoopPolicy{Mode: mode, Max: n}, nil
+	default:
+		return LoopPolicy{}, fmt.Errorf("unknown loop policy %q (expected deny, allow-self N, or allow-cycles N)", fields[0])
+	}
+}
+
+// String renders the policy in the SFA_LOOP_POLICY format.
+func (p LoopPolicy) String() string {
+	if p.Mode == "" || p.Mode == LoopDeny {
+		return string(LoopDeny)
+	}
+	return fmt.Sprintf("%s %d", p.Mode, p.Max)
 }
 
 // buildSubagentSafetyEnv returns env vars with incremented depth for subagent invocation.
 func buildSubagentSafetyEnv(safety *SafetyState) map[string]string {
 	return map[string]string{
-		"SFA_DEPTH":      fmt.Sprintf("%d", safety.Depth+1),
-		"SFA_MAX_DEPTH":  fmt.Sprintf("%d", safety.MaxDepth),
-		"SFA_CALL_CHAIN": strings.Join(safety.CallChain, ","),
-		"SFA_SESSION_ID": safety.SessionID,
+		"SFA_DEPTH":             fmt.Sprintf("%d", safety.Depth+1),
+		"SFA_MAX_DEPTH":         fmt.Sprintf("%d", safety.MaxDepth),
+		"SFA_CALL_CHAIN":        strings.Join(safety.CallChain, ","),
+		"SFA_CALL_CHAIN_DETAIL": encodeCallChain(safety.callHops()),
+		"SFA_SESSION_ID":        safety.SessionID,
 	}
 }
 
@@ -101,35 +207,6 @@ func setupTimeout(agentName string, timeoutSeconds int) (context.Context, contex
 	return context.WithTimeout(context.Background(), time.Duration(timeoutSeconds)*time.Second)
 }
 
-// setupSignalHandlers installs SIGINT and SIGTERM handlers that cancel the context.
-// Returns a cleanup function that removes the signal handlers.
-func setupSignalHandlers(agentName string, cancel context.CancelFunc) func() {
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		sig := <-sigCh
-		cancel()
-
-		switch sig {
-		case syscall.SIGINT:
-			emitProgress(agentName, "interrupted (SIGINT)")
-			// Give a moment for cleanup, then exit
-			time.Sleep(100 * time.Millisecond)
-			os.Exit(ExitSIGINT)
-		case syscall.SIGTERM:
-			emitProgress(agentName, "terminated (SIGTERM)")
-			time.Sleep(5 * time.Second) // grace period
-			os.Exit(ExitSIGTERM)
-		}
-	}()
-
-	return func() {
-		signal.Stop(sigCh)
-		close(sigCh)
-	}
-}
-
 // generateUUID produces a UUID v4 string.
 func generateUUID() string {
 	b := make([]byte, 16)
@@ -12,9 +12,10 @@ func TestInitSafetyTopLevel(t *testing.T) {
 	os.Unsetenv("SFA_DEPTH")
 	os.Unsetenv("SFA_MAX_DEPTH")
 	os.Unsetenv("SFA_CALL_CHAIN")
+	os.Unsetenv("SFA_CALL_CHAIN_DETAIL")
 	os.Unsetenv("SFA_SESSION_ID")
 
-	safety, err := initSafety("test-agent", 5)
+	safety, err := initSafety("test-agent", "1.0.0", 5, LoopPolicy{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -46,10 +47,11 @@ func TestInitSafetyNestedCall(t *testing.T) {
 		os.Unsetenv("SFA_DEPTH")
 		os.Unsetenv("SFA_MAX_DEPTH")
 		os.Unsetenv("SFA_CALL_CHAIN")
+		os.Unsetenv("SFA_CALL_CHAIN_DETAIL")
 		os.Unsetenv("SFA_SESSION_ID")
 	}()
 
-	safety, err := initSafety("child-agent", 5)
+	safety, err := initSafety("child-agent", "1.0.0", 5, LoopPolicy{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -66,13 +68,34 @@ func TestInitSafetyNestedCall(t *testing.T) {
 	if safety.CallChain[0] != "parent-agent" || safety.CallChain[1] != "child-agent" {
 		t.Errorf("expected [parent-agent, child-agent], got %v", safety.CallChain)
 	}
+	if got := safety.Hops[1].Label(); got != "child-agent@1.0.0" {
+		t.Errorf("expected child-agent@1.0.0 hop, got %q", got)
+	}
+	if !strings.HasPrefix(os.Getenv("SFA_CALL_CHAIN_DETAIL"), "parent-agent@:0:0,child-agent@1.0.0:1:") {
+		t.Errorf("unexpected SFA_CALL_CHAIN_DETAIL %q", os.Getenv("SFA_CALL_CHAIN_DETAIL"))
+	}
+}
+
+func TestInitSafetyLoopErrorShowsVersions(t *testing.T) {
+	os.Setenv("SFA_CALL_CHAIN", "agent-a,agent-b")
+	os.Setenv("SFA_CALL_CHAIN_DETAIL", "agent-a@2.1.0:0:1700000000000,agent-b@0.3.0:1:1700000001000")
+	defer os.Unsetenv("SFA_CALL_CHAIN")
+	defer os.Unsetenv("SFA_CALL_CHAIN_DETAIL")
+
+	_, err := initSafety("agent-a", "2.1.0", 5, LoopPolicy{})
+	if err == nil {
+		t.Fatal("expected loop detection error")
+	}
+	if !strings.Contains(err.Error(), "agent-a@2.1.0 → agent-b@0.3.0 → agent-a@2.1.0") {
+		t.Errorf("expected versioned loop path, got: %v", err)
+	}
 }
 
 func TestInitSafetyLoopDetection(t *testing.T) {
 	os.Setenv("SFA_CALL_CHAIN", "agent-a,agent-b")
 	defer os.Unsetenv("SFA_CALL_CHAIN")
 
-	_, err := initSafety("agent-a", 5)
+	_, err := initSafety("agent-a", "", 5, LoopPolicy{})
 	if err == nil {
 		t.Fatal("expected loop detection error")
 	}
@@ -84,6 +107,93 @@ func TestInitSafetyLoopDetection(t *testing.T) {
 	}
 }
 
+func TestInitSafetyLoopPolicyFromEnv(t *testing.T) {
+	os.Setenv("SFA_CALL_CHAIN", "agent-a,agent-b")
+	os.Setenv("SFA_LOOP_POLICY", "allow-cycles 1")
+	defer os.Unsetenv("SFA_CALL_CHAIN")
+	defer os.Unsetenv("SFA_LOOP_POLICY")
+
+	// The declared policy and the bound both permit one cycle
+	safety, err := initSafety("agent-a", "", 5, LoopPolicy{Mode: LoopAllowCycles, Max: 2})
+	if err != nil {
+		t.Fatalf("expected one cycle to be permitted, got: %v", err)
+	}
+	if safety.LoopBound == nil || *safety.LoopBound != (LoopPolicy{Mode: LoopAllowCycles, Max: 1}) {
+		t.Errorf("expected allow-cycles 1 bound, got %v", safety.LoopBound)
+	}
+
+	// The bound never loosens an agent's declared policy
+	os.Setenv("SFA_CALL_CHAIN", "agent-a,agent-b")
+	if _, err := initSafety("agent-a", "", 5, LoopPolicy{}); err == nil {
+		t.Error("expected the declared deny policy to win over a looser bound")
+	}
+
+	// and a stricter bound wins over it
+	os.Setenv("SFA_LOOP_POLICY", "deny")
+	if _, err := initSafety("agent-a", "", 5, LoopPolicy{Mode: LoopAllowCycles, Max: 2}); err == nil {
+		t.Error("expected the deny bound to win over the declared policy")
+	}
+}
+
+func TestInitSafetyInvalidLoopPolicy(t *testing.T) {
+	os.Setenv("SFA_LOOP_POLICY", "sometimes")
+	defer os.Unsetenv("SFA_LOOP_POLICY")
+
+	if _, err := initSafety("agent-a", "", 5, LoopPolicy{}); err == nil {
+		t.Fatal("expected error for invalid SFA_LOOP_POLICY")
+	}
+}
+
+func TestEvaluateLoopAllowSelf(t *testing.T) {
+	policy := LoopPolicy{Mode: LoopAllowSelf, Max: 2}
+
+	if err := evaluateLoop(hopsOf("planner", "a", "a"), CallHop{Name: "a"}, policy); err != nil {
+		t.Errorf("expected two self re-entries to pass, got: %v", err)
+	}
+	if err := evaluateLoop(hopsOf("a", "a", "a"), CallHop{Name: "a"}, policy); err == nil {
+		t.Error("expected third self re-entry to fail")
+	}
+	if err := evaluateLoop(hopsOf("a", "b"), CallHop{Name: "a"}, policy); err == nil {
+		t.Error("expected indirect cycle to fail under allow-self")
+	}
+}
+
+func TestEvaluateLoopAllowCycles(t *testing.T) {
+	policy := LoopPolicy{Mode: LoopAllowCycles, Max: 1}
+
+	if err := evaluateLoop(hopsOf("a", "b"), CallHop{Name: "a"}, policy); err != nil {
+		t.Errorf("expected one cycle to pass, got: %v", err)
+	}
+	err := evaluateLoop(hopsOf("a", "b", "a", "b"), CallHop{Name: "a"}, policy)
+	if err == nil || !strings.Contains(err.Error(), "allow-cycles 1") {
+		t.Errorf("expected policy exceeded error, got: %v", err)
+	}
+}
+
+func TestParseLoopPolicy(t *testing.T) {
+	cases := map[string]LoopPolicy{
+		"deny":           {Mode: LoopDeny},
+		"allow-self 3":   {Mode: LoopAllowSelf, Max: 3},
+		"allow-cycles:2": {Mode: LoopAllowCycles, Max: 2},
+	}
+	for in, want := range cases {
+		got, err := parseLoopPolicy(in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q: expected %v, got %v", in, want, got)
+		}
+	}
+
+	for _, in := range []string{"", "allow-self", "allow-self 0", "deny 2", "forever"} {
+		if _, err := parseLoopPolicy(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
 func TestCheckDepthLimit(t *testing.T) {
 	safety := &SafetyState{Depth: 4, MaxDepth: 5}
 	err := checkDepthLimit(safety)
@@ -101,13 +211,25 @@ func TestCheckDepthLimitOK(t *testing.T) {
 }
 
 func TestCheckLoopDetected(t *testing.T) {
-	safety := &SafetyState{CallChain: []string{"agent-a", "agent-b"}}
+	safety := &SafetyState{CallChain: []string{"agent-a", "agent-b"}, LoopBound: &LoopPolicy{Mode: LoopDeny}}
 	err := checkLoop(safety, "agent-a")
 	if err == nil {
 		t.Error("expected loop error")
 	}
 }
 
+func TestCheckLoopLeavesReentryToTarget(t *testing.T) {
+	// A (allow-cycles) -> B (deny) -> A: B cannot know A's policy, so A decides
+	safety := &SafetyState{CallChain: []string{"agent-a", "agent-b"}, LoopPolicy: LoopPolicy{Mode: LoopDeny}}
+	if err := checkLoop(safety, "agent-a"); err != nil {
+		t.Errorf("expected the invoker to leave the re-entry to agent-a, got: %v", err)
+	}
+	safety.LoopBound = &LoopPolicy{Mode: LoopAllowCycles, Max: 1}
+	if err := checkLoop(safety, "agent-a"); err != nil {
+		t.Errorf("expected the bound to permit one cycle, got: %v", err)
+	}
+}
+
 func TestCheckLoopOK(t *testing.T) {
 	safety := &SafetyState{CallChain: []string{"agent-a", "agent-b"}}
 	err := checkLoop(safety, "agent-c")
@@ -175,3 +297,10 @@ func TestGenerateUUID(t *testing.T) {
 		t.Error("two UUIDs should not be identical")
 	}
 }
+
+func TestInitSafetyInvalidSessionID(t *testing.T) {
+	t.Setenv("SFA_SESSION_ID", "../../../../escaped")
+	if _, err := initSafety("agent-a", "", 5, LoopPolicy{}); err == nil || !strings.Contains(err.Error(), "invalid SFA_SESSION_ID") {
+		t.Errorf("expected invalid SFA_SESSION_ID error, got: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package sfa
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// ErrSandboxViolation is returned when a sandboxed agent attempts an action
+// its trust level forbids. Execute errors wrapping it (or the OS errors the
+// sandbox produces) exit with ExitPermissionDeny.
+var ErrSandboxViolation = errors.New("sandbox violation")
+
+// sandboxEnv controls enforcement: "off" disables it, and the SDK sets it to
+// "active" in the re-executed process running inside the sandbox.
+const sandboxEnv = "SFA_SANDBOX"
+
+// describedTrustLevel returns the trust level the agent is described with:
+// the declared one, or sandboxed when none is declared.
+func (def *AgentDef) describedTrustLevel() TrustLevel {
+	if def.TrustLevel == "" {
+		return TrustSandboxed
+	}
+	return def.TrustLevel
+}
+
+// shouldSandbox reports whether this invocation runs under sandbox
+// restrictions: a declared sandboxed trust level, the execution path (not
+// --help, --describe, --setup, --explain, ...), and not disabled via
+// SFA_SANDBOX=off.
+func shouldSandbox(def *AgentDef, flags StandardFlags) bool {
+	if def.TrustLevel != TrustSandboxed || os.Getenv(sandboxEnv) == "off" {
+		return false
+	}
+	return !flags.Help && !flags.Version && !flags.Describe && !flags.Setup && !flags.ServicesDown && !flags.Explain
+}
+
+// sandboxWritablePaths returns the directories a sandboxed agent may write:
+// the workspace (SFA_WORKSPACE or the working directory), the temp dir, and
+// the SDK's own state (logs, metrics, context store, sessions, cost report),
+// and the directory of --output-file.
+func sandboxWritablePaths(logConfig *LoggingConfig, metricsFile, contextStorePath, outputFile string) []string {
+	var paths []string
+	add := func(p string) {
+		if p == "" {
+			return
+		}
+		if abs, err := filepath.Abs(p); err == nil {
+			paths = append(paths, filepath.Clean(abs))
+		}
+	}
+
+	if ws := os.Getenv("SFA_WORKSPACE"); ws != "" {
+		add(ws)
+	} else if wd, err := os.Getwd(); err == nil {
+		add(wd)
+	}
+	add(os.TempDir())
+	add(filepath.Dir(resolveSessionsDir()))
+	if !logConfig.Suppressed {
+		add(filepath.Dir(logConfig.FilePath))
+	}
+	if metricsFile != "" {
+		add(filepath.Dir(metricsFile))
+	}
+	add(contextStorePath)
+	if p := os.Getenv("SFA_COST_FILE"); p != "" {
+		add(filepath.Dir(p))
+	}
+	if p := os.Getenv("SFA_INVOCATION_FILE"); p != "" {
+		add(filepath.Dir(p))
+	}
+	if outputFile != "" {
+		add(filepath.Dir(outputFile))
+	}
+
+	sort.Strings(paths)
+	out := paths[:0]
+	for _, p := range paths {
+		if len(out) > 0 && pathWithin(p, out[len(out)-1]) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// pathWithin reports whether p equals dir or lies beneath it.
+func pathWithin(p, dir string) bool {
+	if dir == "/" {
+		return true
+	}
+	return p == dir || strings.HasPrefix(p, dir+string(filepath.Separator))
+}
+
+// isSandboxViolation reports whether err stems from a sandbox restriction:
+// a blocked exec or mount (EPERM), a write outside the workspace (EROFS),
+// or any network access (the sandbox has no network, so dial and DNS errors
+// qualify), as well as ErrSandboxViolation itself.
+func isSandboxViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.Is(err, ErrSandboxViolation) ||
+		errors.Is(err, syscall.EPERM) ||
+		errors.Is(err, syscall.EROFS) ||
+		errors.Is(err, syscall.ENETUNREACH) ||
+		errors.As(err, &opErr) ||
+		errors.As(err, &dnsErr)
+}
+
+// sandboxForbids returns an ErrSandboxViolation for an action the SDK
+// mediates itself, such as invoking a subagent.
+func sandboxForbids(action string) error {
+	return fmt.Errorf("%w: sandboxed agents cannot %s (declare a higher trustLevel)", ErrSandboxViolation, action)
+}
+
+// sandboxActive reports whether this process runs inside the sandbox.
+func sandboxActive() bool {
+	return os.Getenv(sandboxEnv) == "active"
+}
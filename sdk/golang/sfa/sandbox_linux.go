@@ -0,0 +1,231 @@
+//go:build linux
+
+package sfa
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// sandboxSupported reports whether enterSandbox and applySandbox enforce
+// the sandbox on this platform.
+const sandboxSupported = true
+
+// enterSandbox re-executes the agent inside new user, mount, and (unless
+// isolateNetwork is false, as for server modes that must accept connections)
+// network namespaces and exits with the sandboxed process's exit code. It
+// returns only in the sandboxed process itself. When namespaces are
+// unavailable the agent exits with ExitPermissionDeny rather than run
+// unisolated.
+func enterSandbox(agentName string, isolateNetwork bool) {
+	if sandboxActive() {
+		return
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		exitWithError(fmt.Sprintf("sandbox unavailable, cannot locate executable: %v; declare a higher trust level, or set SFA_SANDBOX=off to run unisolated", err), ExitPermissionDeny)
+	}
+
+	cmd := exec.Command(self, os.Args[1:]...)
+	cmd.Args[0] = os.Args[0]
+	cmd.Env = append(os.Environ(), sandboxEnv+"=active")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Map our uid/gid to root inside the namespace so the re-executed process
+	// keeps the capabilities needed to remount the filesystem read-only.
+	cloneflags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS)
+	if isolateNetwork {
+		cloneflags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: cloneflags,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+		GidMappingsEnableSetgroups: false,
+	}
+
+	// The terminal delivers SIGINT to the whole process group; forward the rest.
+	sigCh := make(chan os.Signal, 4)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
+
+	if err := cmd.Start(); err != nil {
+		signal.Stop(sigCh)
+		exitWithError(fmt.Sprintf("sandbox namespaces unavailable: %v; declare a higher trust level, or set SFA_SANDBOX=off to run unisolated", err), ExitPermissionDeny)
+	}
+	stderrLog.Debug("running in sandbox", "agent", agentName)
+
+	go func() {
+		for sig := range sigCh {
+			if sig != syscall.SIGINT {
+				_ = cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	signal.Stop(sigCh)
+	if err == nil {
+		os.Exit(ExitSuccess)
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			os.Exit(128 + int(status.Signal()))
+		}
+		os.Exit(exitErr.ExitCode())
+	}
+	exitWithError(fmt.Sprintf("sandboxed execution failed: %v", err), ExitFailure)
+}
+
+// applySandbox restricts the current process: everything outside the
+// writable paths becomes read-only (when running in our mount namespace),
+// then a seccomp filter blocks exec, mount, and namespace syscalls.
+func applySandbox(writable []string) error {
+	var errs []string
+	if err := remountReadOnly(writable); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := installExecFilter(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// remountReadOnly bind-mounts each writable path onto itself, then remounts
+// every other mount read-only. /proc, /sys, and /dev are left untouched.
+func remountReadOnly(writable []string) error {
+	// Keep our changes out of the parent namespace
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("filesystem not isolated: %w", err)
+	}
+
+	for _, p := range writable {
+		if err := os.MkdirAll(p, 0755); err != nil {
+			continue
+		}
+		_ = syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REC, "")
+	}
+
+	mounts, err := readMountinfo("/proc/self/mountinfo")
+	if err != nil {
+		return fmt.Errorf("filesystem not isolated: %w", err)
+	}
+	for _, m := range mounts {
+		if skipReadOnlyRemount(m.point, writable) {
+			continue
+		}
+		flags := uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY) | m.flags
+		if err := syscall.Mount("", m.point, "", flags, ""); err != nil && m.point == "/" {
+			return fmt.Errorf("filesystem not isolated: remount / read-only: %w", err)
+		}
+	}
+
+	// The working directory still references the mount it was entered on;
+	// re-enter it so relative paths resolve through the writable bind mounts.
+	if wd, err := os.Getwd(); err == nil {
+		_ = os.Chdir(wd)
+	}
+	return nil
+}
+
+// skipReadOnlyRemount reports whether a mount point stays writable.
+func skipReadOnlyRemount(point string, writable []string) bool {
+	for _, special := range []string{"/proc", "/sys", "/dev"} {
+		if pathWithin(point, special) {
+			return true
+		}
+	}
+	for _, w := range writable {
+		if pathWithin(point, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// mountEntry is a mount point and the per-mount flags that must be
+// preserved when remounting inside a user namespace.
+type mountEntry struct {
+	point string
+	flags uintptr
+}
+
+// readMountinfo parses /proc/self/mountinfo.
+func readMountinfo(path string) ([]mountEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m, ok := parseMountinfoLine(scanner.Text()); ok {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// parseMountinfoLine extracts the mount point (field 5) and per-mount
+// options (field 6) from a mountinfo line.
+func parseMountinfoLine(line string) (mountEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 6 {
+		return mountEntry{}, false
+	}
+
+	m := mountEntry{point: unescapeMountPath(fields[4])}
+	for _, opt := range strings.Split(fields[5], ",") {
+		switch opt {
+		case "nosuid":
+			m.flags |= syscall.MS_NOSUID
+		case "nodev":
+			m.flags |= syscall.MS_NODEV
+		case "noexec":
+			m.flags |= syscall.MS_NOEXEC
+		case "noatime":
+			m.flags |= syscall.MS_NOATIME
+		case "nodiratime":
+			m.flags |= syscall.MS_NODIRATIME
+		case "relatime":
+			m.flags |= syscall.MS_RELATIME
+		}
+	}
+	return m, true
+}
+
+// unescapeMountPath decodes the octal escapes (\040 for space, etc.) used
+// in mountinfo paths.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
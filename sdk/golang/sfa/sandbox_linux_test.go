@@ -0,0 +1,44 @@
+//go:build linux
+
+package sfa
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseMountinfoLine(t *testing.T) {
+	m, ok := parseMountinfoLine(`36 35 98:0 /mnt1 /mnt/my\040disk rw,nosuid,noexec,relatime shared:1 - ext3 /dev/root rw`)
+	if !ok {
+		t.Fatal("expected line to parse")
+	}
+	if m.point != "/mnt/my disk" {
+		t.Errorf("expected unescaped mount point, got %q", m.point)
+	}
+	want := uintptr(syscall.MS_NOSUID | syscall.MS_NOEXEC | syscall.MS_RELATIME)
+	if m.flags != want {
+		t.Errorf("expected flags %#x, got %#x", want, m.flags)
+	}
+
+	if _, ok := parseMountinfoLine("garbage"); ok {
+		t.Error("expected short line to be rejected")
+	}
+}
+
+func TestSkipReadOnlyRemount(t *testing.T) {
+	writable := []string{"/home/u/project", "/tmp"}
+	for point, want := range map[string]bool{
+		"/":                    false,
+		"/home":                false,
+		"/home/u/project":      true,
+		"/home/u/project/data": true,
+		"/tmp":                 true,
+		"/proc/sys":            true,
+		"/dev/shm":             true,
+		"/var":                 false,
+	} {
+		if got := skipReadOnlyRemount(point, writable); got != want {
+			t.Errorf("skipReadOnlyRemount(%q) = %v, want %v", point, got, want)
+		}
+	}
+}
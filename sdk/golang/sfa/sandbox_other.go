@@ -0,0 +1,23 @@
+//go:build !linux
+
+package sfa
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// sandboxSupported reports whether enterSandbox and applySandbox enforce
+// the sandbox on this platform.
+const sandboxSupported = false
+
+// enterSandbox is a no-op: namespace isolation requires Linux.
+// trustLevel remains advisory on this platform.
+func enterSandbox(agentName string, isolateNetwork bool) {
+	stderrLog.Debug(fmt.Sprintf("sandbox enforcement is not supported on %s; trustLevel is advisory", runtime.GOOS), "agent", agentName)
+}
+
+// applySandbox is a no-op outside Linux.
+func applySandbox(writable []string) error {
+	return nil
+}
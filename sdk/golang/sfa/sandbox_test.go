@@ -0,0 +1,115 @@
+package sfa
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"syscall"
+	"testing"
+)
+
+func TestShouldSandbox(t *testing.T) {
+	os.Unsetenv("SFA_SANDBOX")
+	def := &AgentDef{TrustLevel: TrustSandboxed}
+
+	if !shouldSandbox(def, StandardFlags{}) {
+		t.Error("expected sandboxed agent to be sandboxed on the execution path")
+	}
+	if shouldSandbox(def, StandardFlags{Setup: true}) || shouldSandbox(def, StandardFlags{Describe: true}) {
+		t.Error("expected --setup and --describe to run unsandboxed")
+	}
+	if shouldSandbox(&AgentDef{TrustLevel: TrustNetwork}, StandardFlags{}) {
+		t.Error("expected network trust level to run unsandboxed")
+	}
+	if shouldSandbox(&AgentDef{}, StandardFlags{}) {
+		t.Error("expected an undeclared trust level to run unsandboxed")
+	}
+
+	os.Setenv("SFA_SANDBOX", "off")
+	defer os.Unsetenv("SFA_SANDBOX")
+	if shouldSandbox(def, StandardFlags{}) {
+		t.Error("expected SFA_SANDBOX=off to disable the sandbox")
+	}
+}
+
+func TestSandboxWritablePaths(t *testing.T) {
+	ws := t.TempDir()
+	os.Setenv("SFA_WORKSPACE", ws)
+	os.Setenv("SFA_COST_FILE", ws+"/sub/cost.json")
+	defer os.Unsetenv("SFA_WORKSPACE")
+	defer os.Unsetenv("SFA_COST_FILE")
+
+	paths := sandboxWritablePaths(&LoggingConfig{Suppressed: true}, "", ws+"/context", ws+"/out/result.json")
+	for _, p := range paths {
+		if p != ws && pathWithin(p, ws) {
+			t.Errorf("expected %s to be folded into workspace %s, got %v", p, ws, paths)
+		}
+	}
+
+	found := false
+	for _, p := range paths {
+		if pathWithin(ws, p) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected workspace %s to be writable, got %v", ws, paths)
+	}
+}
+
+func TestPathWithin(t *testing.T) {
+	cases := []struct {
+		p, dir string
+		want   bool
+	}{
+		{"/tmp/a", "/tmp", true},
+		{"/tmp", "/tmp", true},
+		{"/tmpfoo", "/tmp", false},
+		{"/anything", "/", true},
+	}
+	for _, c := range cases {
+		if got := pathWithin(c.p, c.dir); got != c.want {
+			t.Errorf("pathWithin(%q, %q) = %v, want %v", c.p, c.dir, got, c.want)
+		}
+	}
+}
+
+func TestIsSandboxViolation(t *testing.T) {
+	violations := []error{
+		sandboxForbids("invoke subagents"),
+		&os.PathError{Op: "open", Path: "/etc/x", Err: syscall.EROFS},
+		fmt.Errorf("run: %w", &os.SyscallError{Syscall: "fork/exec", Err: syscall.EPERM}),
+		&net.OpError{Op: "dial", Err: syscall.ENETUNREACH},
+		&net.DNSError{Err: "no such host", Name: "example.com"},
+	}
+	for _, err := range violations {
+		if !isSandboxViolation(err) {
+			t.Errorf("expected %v to be a sandbox violation", err)
+		}
+	}
+
+	for _, err := range []error{nil, errors.New("model refused"), os.ErrNotExist} {
+		if isSandboxViolation(err) {
+			t.Errorf("expected %v not to be a sandbox violation", err)
+		}
+	}
+}
+
+func TestInvokeAgentForbiddenInSandbox(t *testing.T) {
+	os.Setenv("SFA_SANDBOX", "active")
+	defer os.Unsetenv("SFA_SANDBOX")
+
+	_, err := invokeAgent("child", &SafetyState{MaxDepth: 5}, nil, nil, nil, nil)
+	if !errors.Is(err, ErrSandboxViolation) {
+		t.Errorf("expected ErrSandboxViolation, got %v", err)
+	}
+}
+
+func TestSandboxWritablePathsSorted(t *testing.T) {
+	paths := sandboxWritablePaths(&LoggingConfig{Suppressed: true}, "", "", "")
+	if len(paths) == 0 || !sort.StringsAreSorted(paths) {
+		t.Errorf("expected sorted, non-empty writable paths, got %v", paths)
+	}
+}
@@ -0,0 +1,83 @@
+//go:build linux && (amd64 || arm64)
+
+package sfa
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Classic BPF opcodes and seccomp constants (linux/filter.h, linux/seccomp.h).
+const (
+	bpfLdWAbs = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJeqK   = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJgeK   = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+	bpfRetK   = 0x06 // BPF_RET | BPF_K
+
+	seccompRetAllow = 0x7fff0000
+	seccompRetErrno = 0x00050000
+
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+	prSetNoNewPrivs        = 38
+
+	seccompDataNr   = 0 // offsetof(struct seccomp_data, nr)
+	seccompDataArch = 4 // offsetof(struct seccomp_data, arch)
+
+	x32SyscallBit = 0x40000000
+)
+
+type sockFilter struct {
+	code uint16
+	jt   uint8
+	jf   uint8
+	k    uint32
+}
+
+type sockFprog struct {
+	len    uint16
+	filter *sockFilter
+}
+
+// execFilterProgram builds a filter that fails the denied syscalls with
+// EPERM, rejects foreign-ABI syscalls, and allows everything else.
+func execFilterProgram(arch uint32, denied []uint32) []sockFilter {
+	n := len(denied)
+	prog := []sockFilter{
+		{code: bpfLdWAbs, k: seccompDataArch},
+		{code: bpfJeqK, jt: 1, jf: 0, k: arch},
+		{code: bpfRetK, k: seccompRetErrno | uint32(syscall.EPERM)},
+		{code: bpfLdWAbs, k: seccompDataNr},
+		{code: bpfJgeK, jt: uint8(n + 1), jf: 0, k: x32SyscallBit},
+	}
+	for i, nr := range denied {
+		// On match, jump past the remaining checks and the allow to the deny
+		prog = append(prog, sockFilter{code: bpfJeqK, jt: uint8(n - i), jf: 0, k: nr})
+	}
+	prog = append(prog,
+		sockFilter{code: bpfRetK, k: seccompRetAllow},
+		sockFilter{code: bpfRetK, k: seccompRetErrno | uint32(syscall.EPERM)},
+	)
+	return prog
+}
+
+// installExecFilter applies the filter to every thread of the process.
+// It cannot be removed, so it is installed only once setup is complete.
+func installExecFilter() error {
+	prog := execFilterProgram(seccompAuditArch, seccompDeniedSyscalls)
+	fprog := sockFprog{len: uint16(len(prog)), filter: &prog[0]}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("subprocess exec not blocked: set no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(sysSeccomp, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		return fmt.Errorf("subprocess exec not blocked: install seccomp filter: %w", errno)
+	}
+	runtime.KeepAlive(prog)
+	return nil
+}
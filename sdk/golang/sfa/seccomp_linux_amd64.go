@@ -0,0 +1,18 @@
+package sfa
+
+// AUDIT_ARCH_X86_64 and the syscalls a sandboxed agent may not make.
+const (
+	seccompAuditArch = 0xc000003e
+	sysSeccomp       = 317
+)
+
+var seccompDeniedSyscalls = []uint32{
+	59,  // execve
+	322, // execveat
+	165, // mount
+	166, // umount2
+	272, // unshare
+	308, // setns
+	155, // pivot_root
+	161, // chroot
+}
@@ -0,0 +1,18 @@
+package sfa
+
+// AUDIT_ARCH_AARCH64 and the syscalls a sandboxed agent may not make.
+const (
+	seccompAuditArch = 0xc00000b7
+	sysSeccomp       = 277
+)
+
+var seccompDeniedSyscalls = []uint32{
+	221, // execve
+	281, // execveat
+	40,  // mount
+	39,  // umount2
+	97,  // unshare
+	268, // setns
+	41,  // pivot_root
+	51,  // chroot
+}
@@ -0,0 +1,10 @@
+//go:build linux && !amd64 && !arm64
+
+package sfa
+
+import "fmt"
+
+// installExecFilter is unavailable on this architecture.
+func installExecFilter() error {
+	return fmt.Errorf("subprocess exec not blocked: seccomp filter not supported on this architecture")
+}
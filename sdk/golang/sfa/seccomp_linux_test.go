@@ -0,0 +1,59 @@
+//go:build linux && (amd64 || arm64)
+
+package sfa
+
+import "testing"
+
+// runFilter evaluates the classic BPF program against a syscall.
+func runFilter(prog []sockFilter, arch, nr uint32) uint32 {
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		switch ins.code {
+		case bpfLdWAbs:
+			if ins.k == seccompDataArch {
+				acc = arch
+			} else {
+				acc = nr
+			}
+		case bpfJeqK:
+			if acc == ins.k {
+				pc += int(ins.jt)
+			} else {
+				pc += int(ins.jf)
+			}
+		case bpfJgeK:
+			if acc >= ins.k {
+				pc += int(ins.jt)
+			} else {
+				pc += int(ins.jf)
+			}
+		case bpfRetK:
+			return ins.k
+		}
+	}
+	return 0
+}
+
+func TestExecFilterProgram(t *testing.T) {
+	denied := []uint32{59, 322, 165}
+	prog := execFilterProgram(seccompAuditArch, denied)
+	deny := uint32(seccompRetErrno | 1) // EPERM
+
+	for _, nr := range denied {
+		if got := runFilter(prog, seccompAuditArch, nr); got != deny {
+			t.Errorf("syscall %d: expected deny, got %#x", nr, got)
+		}
+	}
+	for _, nr := range []uint32{0, 1, 60, 321} {
+		if got := runFilter(prog, seccompAuditArch, nr); got != seccompRetAllow {
+			t.Errorf("syscall %d: expected allow, got %#x", nr, got)
+		}
+	}
+	if got := runFilter(prog, 0x40000003, 0); got != deny {
+		t.Errorf("expected foreign arch to be denied, got %#x", got)
+	}
+	if got := runFilter(prog, seccompAuditArch, x32SyscallBit|1); got != deny {
+		t.Errorf("expected x32 syscalls to be denied, got %#x", got)
+	}
+}
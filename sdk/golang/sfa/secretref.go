@@ -0,0 +1,192 @@
+package sfa
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretResolver returns the secret a reference points to, such as
+// "vault:secret/data/app#token".
+type SecretResolver func(ref string) (string, error)
+
+// secretProviders maps a reference prefix to its resolver.
+var secretProviders = struct {
+	sync.RWMutex
+	byPrefix map[string]SecretResolver
+}{byPrefix: map[string]SecretResolver{
+	"vault:": resolveVaultRef,
+	"op://":  resolveOnePasswordRef,
+}}
+
+// secretCache holds resolved references for the life of the process.
+var secretCache = struct {
+	sync.Mutex
+	values map[string]string
+}{values: map[string]string{}}
+
+// RegisterSecretProvider makes env values (from the process environment or
+// config) starting with prefix resolve through resolve at startup. It replaces any provider registered
+// for the same prefix, including the built-in "vault:" and "op://".
+func RegisterSecretProvider(prefix string, resolve SecretResolver) {
+	secretProviders.Lock()
+	defer secretProviders.Unlock()
+	secretProviders.byPrefix[prefix] = resolve
+}
+
+// secretProviderFor returns the resolver for a reference, or nil if value
+// is not a reference.
+func secretProviderFor(value string) SecretResolver {
+	secretProviders.RLock()
+	defer secretProviders.RUnlock()
+	// Longest prefix wins, so a registered "vault:prod/" can override "vault:"
+	var best string
+	for prefix := range secretProviders.byPrefix {
+		if strings.HasPrefix(value, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return nil
+	}
+	return secretProviders.byPrefix[best]
+}
+
+// resolveSecretRefs replaces every resolved value that is a secret
+// reference with the secret it names, marking it secret. A reference already
+// in the process environment is replaced there too, so subprocesses and
+// os.Getenv see the secret. All failures are reported together.
+func resolveSecretRefs(resolved *ResolvedEnv) error {
+	names := make([]string, 0, len(resolved.Values))
+	for name := range resolved.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failures []string
+	for _, name := range names {
+		ref := resolved.Values[name]
+		resolve := secretProviderFor(ref)
+		if resolve == nil {
+			continue
+		}
+		val, err := resolveSecretRef(ref, resolve)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("  • %s: %v", name, err))
+			continue
+		}
+		resolved.Values[name] = val
+		resolved.Secrets[name] = true
+		if os.Getenv(name) == ref {
+			os.Setenv(name, val)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to resolve secret references:\n%s", strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// resolveSecretRef resolves one reference, once per process.
+func resolveSecretRef(ref string, resolve SecretResolver) (string, error) {
+	secretCache.Lock()
+	defer secretCache.Unlock()
+	if val, ok := secretCache.values[ref]; ok {
+		return val, nil
+	}
+	val, err := resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	secretCache.values[ref] = val
+	return val, nil
+}
+
+// resolveVaultRef reads "vault:<path>#<key>" from the Vault HTTP API at
+// VAULT_ADDR, authenticating with VAULT_TOKEN or ~/.vault-token. Both KV v1
+// and v2 response shapes are accepted.
+func resolveVaultRef(ref string) (string, error) {
+	path, key, ok := strings.Cut(strings.TrimPrefix(ref, "vault:"), "#")
+	if !ok || path == "" || key == "" {
+		return "", errors.New("vault reference must look like vault:<path>#<key>")
+	}
+	addr := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return "", errors.New("vault is unavailable: VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			data, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(data))
+		}
+	}
+	if token == "" {
+		return "", errors.New("vault is unavailable: VAULT_TOKEN is not set and ~/.vault-token is missing")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault is unavailable at %s: %w", addr, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s for %s", resp.Status, path)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid vault response for %s: %w", path, err)
+	}
+	data := body.Data
+	if inner, ok := data["data"].(map[string]any); ok { // KV v2 nests the secret
+		data = inner
+	}
+	val, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %q", path, key)
+	}
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprintf("%v", val), nil
+}
+
+// resolveOnePasswordRef reads an "op://vault/item/field" reference with the
+// 1Password CLI.
+func resolveOnePasswordRef(ref string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("op", "read", "--no-newline", ref)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", errors.New("1Password is unavailable: the op CLI is not installed")
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("op read failed: %s", msg)
+		}
+		return "", fmt.Errorf("op read failed: %w", err)
+	}
+	return stdout.String(), nil
+}
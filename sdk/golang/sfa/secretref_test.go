@@ -0,0 +1,111 @@
+package sfa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func resetSecretCache(t *testing.T) {
+	t.Helper()
+	secretCache.Lock()
+	secretCache.values = map[string]string{}
+	secretCache.Unlock()
+}
+
+func TestResolveVaultRef(t *testing.T) {
+	resetSecretCache(t)
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vt-1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/app":
+			w.Write([]byte(`{"data":{"data":{"token":"kv2-secret"},"metadata":{}}}`))
+		case "/v1/kv/app":
+			w.Write([]byte(`{"data":{"token":"kv1-secret"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer vault.Close()
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "vt-1")
+
+	for ref, want := range map[string]string{
+		"vault:secret/data/app#token": "kv2-secret",
+		"vault:kv/app#token":          "kv1-secret",
+	} {
+		if got, err := resolveVaultRef(ref); err != nil || got != want {
+			t.Errorf("%s: expected %q, got %q (%v)", ref, want, got, err)
+		}
+	}
+	for _, ref := range []string{"vault:secret/data/app#missing", "vault:secret/data/nope#token", "vault:no-key"} {
+		if _, err := resolveVaultRef(ref); err == nil {
+			t.Errorf("%s: expected an error", ref)
+		}
+	}
+
+	t.Setenv("VAULT_ADDR", "")
+	if _, err := resolveVaultRef("vault:kv/app#token"); err == nil || !strings.Contains(err.Error(), "VAULT_ADDR") {
+		t.Errorf("expected a clear error without VAULT_ADDR, got %v", err)
+	}
+}
+
+func TestResolveOnePasswordRefWithoutCLI(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	_, err := resolveOnePasswordRef("op://vault/item/field")
+	if err == nil || !strings.Contains(err.Error(), "op CLI is not installed") {
+		t.Errorf("expected a clear error without the op CLI, got %v", err)
+	}
+}
+
+func TestResolveSecretRefs(t *testing.T) {
+	resetSecretCache(t)
+	calls := 0
+	RegisterSecretProvider("test-ref:", func(ref string) (string, error) {
+		calls++
+		return "resolved-" + strings.TrimPrefix(ref, "test-ref:"), nil
+	})
+	defer func() {
+		secretProviders.Lock()
+		delete(secretProviders.byPrefix, "test-ref:")
+		secretProviders.Unlock()
+	}()
+
+	os.Setenv("SR_FROM_ENV", "test-ref:a")
+	defer os.Unsetenv("SR_FROM_ENV")
+	resolved := &ResolvedEnv{
+		Values:  map[string]string{"SR_FROM_ENV": "test-ref:a", "SR_PLAIN": "plain", "SR_AGAIN": "test-ref:a"},
+		Secrets: map[string]bool{},
+		Sources: map[string]string{"SR_FROM_ENV": envSourceProcess},
+	}
+	if err := resolveSecretRefs(resolved); err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Values["SR_FROM_ENV"] != "resolved-a" || resolved.Values["SR_PLAIN"] != "plain" {
+		t.Errorf("unexpected values: %v", resolved.Values)
+	}
+	if !resolved.Secrets["SR_FROM_ENV"] || resolved.Secrets["SR_PLAIN"] {
+		t.Errorf("expected only resolved references marked secret: %v", resolved.Secrets)
+	}
+	if os.Getenv("SR_FROM_ENV") != "resolved-a" {
+		t.Errorf("expected process env updated, got %q", os.Getenv("SR_FROM_ENV"))
+	}
+	if calls != 1 {
+		t.Errorf("expected one provider call for a repeated reference, got %d", calls)
+	}
+
+	t.Setenv("VAULT_ADDR", "")
+	failing := &ResolvedEnv{
+		Values:  map[string]string{"SR_B": "vault:kv/b#k", "SR_A": "vault:kv/a#k"},
+		Secrets: map[string]bool{},
+	}
+	err := resolveSecretRefs(failing)
+	if err == nil || !strings.Contains(err.Error(), "SR_A") || !strings.Contains(err.Error(), "SR_B") {
+		t.Errorf("expected every failure reported, got %v", err)
+	}
+}
@@ -0,0 +1,510 @@
+package sfa
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRequestBody bounds the size of an /execute request.
+const maxRequestBody = 32 << 20
+
+// Env vars holding the bearer token that /execute, /ws, and gRPC Execute
+// require, and the extra browser origins allowed to open /ws.
+const (
+	serveTokenEnv   = "SFA_SERVE_TOKEN"
+	serveOriginsEnv = "SFA_SERVE_ORIGINS"
+)
+
+// serveShutdownGrace is how long in-flight requests may finish after a
+// termination signal; it stays below the SIGINT forced-exit grace period.
+const serveShutdownGrace = sigintGrace - 500*time.Millisecond
+
+// agentServer runs the agent's Execute function once per request, with the
+// same env resolution, safety limits, logging, and metrics as CLI mode.
+type agentServer struct {
+	runner      *executor
+	safety      *SafetyState // the server process's own; requests inherit its depth and chain
+	defaults    map[string]any
+	sandboxed   bool
+	logConfig   *LoggingConfig
+	metricsFile string
+	cacheDir    string
+	hub         *executionHub
+	token       string   // required bearer token; empty allows any caller
+	origins     []string // cross-origin /ws origins allowed besides the server's own
+
+	mu       sync.RWMutex
+	config   map[string]any
+	resolved *ResolvedEnv
+	draining bool
+}
+
+// executeRequest is the body of POST /execute.
+type executeRequest struct {
+	Input     string         `json:"input"`
+	Options   map[string]any `json:"options,omitempty"`
+	Timeout   int            `json:"timeout,omitempty"` // seconds; 0 uses the server's --timeout
+	SessionID string         `json:"sessionId,omitempty"`
+	// ExecutionID names the execution for /ws subscribers; generated if empty
+	ExecutionID string `json:"executionId,omitempty"`
+}
+
+// executeResponse is an AgentResult in its JSON output shape, plus the exit
+// code the CLI would have returned and the session the request ran in.
+type executeResponse struct {
+	jsonResult
+	ExitCode    int    `json:"exitCode"`
+	SessionID   string `json:"sessionId"`
+	ExecutionID string `json:"executionId,omitempty"`
+}
+
+// executionEvent is something an execution reports while it runs: a
+// progress message, a partial result, or the final response.
+type executionEvent struct {
+	Type    string `json:"type"` // "progress", "partial", or "result"
+	Message string `json:"message,omitempty"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func newAgentServer(runner *executor, safety *SafetyState, defaults map[string]any, sandboxed bool, logConfig *LoggingConfig, metricsFile string) *agentServer {
+	s := &agentServer{
+		runner:      runner,
+		safety:      safety,
+		defaults:    defaults,
+		sandboxed:   sandboxed,
+		logConfig:   logConfig,
+		metricsFile: metricsFile,
+		cacheDir:    resolveCacheDir(),
+		hub:         newExecutionHub(),
+		token:       os.Getenv(serveTokenEnv),
+		origins:     serveOrigins(),
+		config:      runner.config,
+		resolved:    runner.resolved,
+	}
+
+	// A server has no terminal to prompt on
+	noTTY := func() (io.ReadWriteCloser, error) { return nil, errors.New("prompts are unavailable in server mode") }
+	runner.prompts.openTTY = noTTY
+	runner.permissions.prompter.openTTY = noTTY
+
+	// SIGHUP reloads apply to requests that start afterwards
+	runner.signals.addReloadHook(func(config map[string]any, env map[string]string) {
+		resolved := resolveEnv(agentEnvDecls(runner.def), runner.def.Name, loadLayeredConfig())
+		if err := resolveSecretRefs(resolved); err != nil {
+			stderrLog.Warn(err.Error())
+		}
+		s.mu.Lock()
+		s.config = config
+		s.resolved = resolved
+		s.mu.Unlock()
+	})
+	return s
+}
+
+// execute runs one request to completion. events, if not nil, receives each
+// progress message and partial result the agent emits.
+func (s *agentServer) execute(parent context.Context, req executeRequest, events func(executionEvent)) *executeResponse {
+	start := time.Now()
+	def := s.runner.def
+
+	s.mu.RLock()
+	runner := *s.runner
+	runner.config, runner.resolved = s.config, s.resolved
+	s.mu.RUnlock()
+
+	if req.SessionID != "" {
+		if err := validateSessionID(req.SessionID); err != nil {
+			return &executeResponse{
+				jsonResult: jsonResult{Error: &AgentError{Code: ErrCodeInvalidUsage, Message: err.Error()}},
+				ExitCode:   ExitInvalidUsage,
+			}
+		}
+	}
+	sessionID := req.SessionID
+	if sessionID == "" {
+		sessionID = generateUUID()
+	}
+	resp := &executeResponse{SessionID: sessionID}
+
+	options, err := s.requestOptions(req.Options)
+	if err == nil && def.ContextRequired && req.Input == "" {
+		err = errors.New("this agent requires context input")
+	}
+	if err != nil {
+		resp.ExitCode = ExitInvalidUsage
+		resp.Error = &AgentError{Code: ErrCodeInvalidUsage, Message: err.Error()}
+		return resp
+	}
+
+	// Per-request safety: the server's depth and chain, a fresh session
+	hops := append([]CallHop(nil), s.safety.Hops...)
+	if len(hops) > 0 {
+		hops[len(hops)-1].Start = start.UTC()
+	}
+	safety := &SafetyState{
+		Depth:      s.safety.Depth,
+		MaxDepth:   s.safety.MaxDepth,
+		CallChain:  s.safety.CallChain,
+		Hops:       hops,
+		SessionID:  sessionID,
+		LoopPolicy: s.safety.LoopPolicy,
+		LoopBound:  s.safety.LoopBound,
+	}
+	costs, _ := newCostTracker(os.Getenv("SFA_BUDGET")) // validated at startup
+	session := newSessionTracker(resolveSessionsDir(), safety)
+	session.start(sessionID)
+
+	timeout := runner.flags.Timeout
+	if req.Timeout > 0 {
+		timeout = req.Timeout
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, time.Duration(timeout)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	defer cancel()
+	costs.cancel = cancel
+
+	tracer := newTracer(def.Name, def.Version)
+	span := tracer.start(ctx, "sfa.run "+def.Name)
+	span.setAttr("sfa.session_id", sessionID)
+	ctx = contextWithSpan(ctx, span)
+
+	// Look up a cached result for deterministic agents
+	var cacheKey string
+	var cached *AgentResult
+	if def.Cacheable && !runner.flags.NoCache {
+		cacheKey = computeCacheKey(def.Name, def.Version, req.Input, options)
+		cached = lookupCache(s.cacheDir, def.Name, cacheKey)
+	}
+
+	var result any
+	var execErr error
+	execMeta := &executionMeta{started: start}
+	if cached != nil {
+		result = *cached
+	} else {
+		run := &execution{ctx: ctx, safety: safety, costs: costs, session: session, meta: execMeta}
+		if events != nil {
+			run.progress = func(message string) { events(executionEvent{Type: "progress", Message: message}) }
+			run.partial = func(result any) { events(executionEvent{Type: "partial", Data: result}) }
+		}
+		execCtx := runner.executeContext(run, req.Input, options)
+		// Signal hooks are process-wide; registering them per request would leak
+		execCtx.OnReload = func(func(map[string]any, map[string]string)) {}
+		execCtx.OnStatus = func(func() string) {}
+		result, execErr = executeSafely(def, execCtx)
+	}
+
+	exitCode, failure := classifyFailure(def.Name, execErr, ctx, costs, s.sandboxed)
+	if parent.Err() != nil && exitCode != ExitSuccess {
+		exitCode = ExitSIGTERM
+		failure = toAgentError(execErr, ErrCodeInterrupted, true)
+	}
+
+	totals := costs.snapshot()
+	if result != nil {
+		var ar AgentResult
+		ar, exitCode, failure = agentResult(result, exitCode, failure)
+		if cacheKey != "" && cached == nil && exitCode == ExitSuccess {
+			storeCache(s.cacheDir, def.Name, def.Version, cacheKey, ar, def.CacheTTL)
+		}
+		attachCost(&ar, totals)
+		resp.Result, resp.Metadata, resp.Warnings = ar.Result, ar.Metadata, ar.Warnings
+	}
+	resp.Error = failure
+	resp.ExitCode = exitCode
+
+	if exitCode == ExitSuccess {
+		clearCheckpoint(runner.checkpointDir, sessionID, def.Name)
+	}
+
+	// Log the execution as CLI mode would
+	output, _ := json.Marshal(resp.jsonResult)
+	logEntry := createLogEntry(
+		def.Name, def.Version, exitCode, start,
+		safety.Depth, safety.CallChain, sessionID,
+		req.Input, string(output), runner.resolved,
+	)
+	logEntry.CallChainDetail = strings.Split(encodeCallChain(safety.Hops), ",")
+	meta := execMeta.snapshot()
+	meta["mode"] = "serve"
+	if totals != nil {
+		meta["cost"] = totals
+	}
+	if cached != nil {
+		meta["cache"] = "hit"
+	} else if cacheKey != "" {
+		meta["cache"] = "miss"
+	}
+	logEntry.Meta = maskLogMeta(meta, runner.resolved)
+	writeLogEntry(logEntry, s.logConfig)
+
+	duration := time.Since(start)
+	runner.metrics.recordExecution(def.Name, exitCode, duration)
+	appendMetricsSample(s.metricsFile, runner.metrics.sample(def.Name, def.Version, sessionID, exitCode, duration))
+
+	span.setAttr("sfa.exit_code", exitCode)
+	if exitCode == ExitSuccess {
+		span.finish(nil)
+	} else {
+		span.finish(failure)
+	}
+	tracer.flush()
+	session.finish(exitCode, totals)
+
+	return resp
+}
+
+// requestOptions overlays request options on the server's defaults and
+// checks that required options are present.
+func (s *agentServer) requestOptions(overrides map[string]any) (map[string]any, error) {
+	options := make(map[string]any, len(s.defaults)+len(overrides))
+	for k, v := range s.defaults {
+		options[k] = v
+	}
+	for k, v := range overrides {
+		options[k] = v
+	}
+	for _, opt := range s.runner.def.Options {
+		if !opt.Required {
+			continue
+		}
+		if v, ok := options[opt.Name]; !ok || v == "" {
+			return nil, fmt.Errorf("required option %s is missing", opt.Name)
+		}
+	}
+	return options, nil
+}
+
+// executeSafely runs Execute, turning a panic into an error so one bad
+// request cannot take down the server.
+func executeSafely(def *AgentDef, execCtx *ExecuteContext) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return def.Execute(execCtx)
+}
+
+// handler routes the HTTP API.
+func (s *agentServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /execute", s.handleExecute)
+	mux.HandleFunc("GET /describe", s.handleDescribe)
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /ws", s.handleWebSocket)
+	return mux
+}
+
+// serveOrigins parses the comma-separated SFA_SERVE_ORIGINS.
+func serveOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv(serveOriginsEnv), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, strings.TrimSuffix(o, "/"))
+		}
+	}
+	return origins
+}
+
+// authorized reports whether r carries the server's bearer token, if one
+// is configured. query also accepts it as ?token=, for WebSocket clients
+// that cannot set headers.
+func (s *agentServer) authorized(r *http.Request, query bool) bool {
+	if s.token == "" {
+		return true
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && query {
+		got, ok = r.URL.Query().Get("token"), true
+	}
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) == 1
+}
+
+// originAllowed reports whether a browser at r's Origin may call /execute
+// or open /ws: the server's own origin and SFA_SERVE_ORIGINS are, and so
+// are clients that send no Origin at all.
+func (s *agentServer) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range s.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func (s *agentServer) handleExecute(w http.ResponseWriter, r *http.Request) {
+	if !s.originAllowed(r) {
+		writeJSON(w, http.StatusForbidden, executeResponse{
+			jsonResult: jsonResult{Error: &AgentError{Code: ErrCodePermissionDenied, Message: "cross-origin request not allowed"}},
+			ExitCode:   ExitPermissionDeny,
+		})
+		return
+	}
+	if !s.authorized(r, false) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, executeResponse{
+			jsonResult: jsonResult{Error: &AgentError{Code: ErrCodePermissionDenied, Message: "missing or invalid bearer token"}},
+			ExitCode:   ExitPermissionDeny,
+		})
+		return
+	}
+
+	// A JSON content type cannot be sent cross-site without a preflight
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, executeResponse{
+			jsonResult: jsonResult{Error: &AgentError{Code: ErrCodeInvalidUsage, Message: "request body must be application/json"}},
+			ExitCode:   ExitInvalidUsage,
+		})
+		return
+	}
+
+	var req executeRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, executeResponse{
+			jsonResult: jsonResult{Error: &AgentError{Code: ErrCodeInvalidUsage, Message: fmt.Sprintf("invalid request body: %v", err)}},
+			ExitCode:   ExitInvalidUsage,
+		})
+		return
+	}
+
+	if req.ExecutionID == "" {
+		req.ExecutionID = generateUUID()
+	}
+	stream, err := s.hub.begin(req.ExecutionID)
+	if err != nil {
+		writeJSON(w, http.StatusConflict, executeResponse{
+			jsonResult:  jsonResult{Error: &AgentError{Code: ErrCodeInvalidUsage, Message: err.Error()}},
+			ExitCode:    ExitInvalidUsage,
+			ExecutionID: req.ExecutionID,
+		})
+		return
+	}
+
+	resp := s.execute(r.Context(), req, func(event executionEvent) { stream.publish(event, false) })
+	resp.ExecutionID = req.ExecutionID
+	s.hub.finish(req.ExecutionID, stream, resp)
+	w.Header().Set("X-SFA-Execution-Id", resp.ExecutionID)
+	w.Header().Set("X-SFA-Exit-Code", fmt.Sprintf("%d", resp.ExitCode))
+	writeJSON(w, httpStatusForExit(resp.ExitCode), resp)
+}
+
+func (s *agentServer) handleDescribe(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	resolved := s.resolved
+	s.mu.RUnlock()
+	writeJSON(w, http.StatusOK, generateDescribe(s.runner.def, resolved.Values, resolved.Secrets))
+}
+
+func (s *agentServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	draining := s.draining
+	s.mu.RUnlock()
+	if draining {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// httpStatusForExit maps an SFA exit code to an HTTP status.
+func httpStatusForExit(code int) int {
+	switch code {
+	case ExitSuccess:
+		return http.StatusOK
+	case ExitInvalidUsage:
+		return http.StatusBadRequest
+	case ExitTimeout:
+		return http.StatusGatewayTimeout
+	case ExitPermissionDeny:
+		return http.StatusForbidden
+	case ExitSIGINT, ExitSIGTERM:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// serveHTTP listens on addr until ctx is cancelled, then stops accepting
+// requests and lets in-flight ones finish.
+func (s *agentServer) serveHTTP(ctx context.Context, addr string) error {
+	addr, err := serveListenAddr(addr, s.token)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	srv := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	emitProgress(s.runner.def.Name, "serving on http://"+ln.Addr().String())
+	return s.serveUntilDone(ctx, srv, ln)
+}
+
+// serveListenAddr returns the address --serve listens on. A bare port such
+// as :8080 binds loopback only; any other host must be loopback unless
+// SFA_SERVE_TOKEN is set, since /execute runs the agent for its caller.
+func serveListenAddr(addr, token string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid --serve address %q: %w", addr, err)
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	if token == "" && !isLocalHost(host) {
+		return "", fmt.Errorf("refusing to serve on non-loopback address %s without %s", addr, serveTokenEnv)
+	}
+	return addr, nil
+}
+
+// serveUntilDone runs srv on ln until ctx is cancelled, then marks the
+// server draining and shuts it down gracefully.
+func (s *agentServer) serveUntilDone(ctx context.Context, srv *http.Server, ln net.Listener) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		s.mu.Lock()
+		s.draining = true
+		s.mu.Unlock()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownGrace)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	err := srv.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-done
+		return nil
+	}
+	return err
+}
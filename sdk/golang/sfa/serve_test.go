@@ -0,0 +1,294 @@
+package sfa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, def *AgentDef) *agentServer {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SFA_DATA_HOME", "")
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("SFA_BUDGET", "")
+	t.Setenv("SFA_SERVE_TOKEN", "")
+	t.Setenv("SFA_SERVE_ORIGINS", "")
+
+	signals := setupSignalHandlers(def.Name, func() {})
+	t.Cleanup(signals.stop)
+
+	resolved := resolveEnv(def.Env, def.Name, map[string]any{})
+	flags := StandardFlags{Timeout: 5, MaxDepth: 5}
+	runner := &executor{
+		def:           def,
+		flags:         flags,
+		resolved:      resolved,
+		config:        map[string]any{},
+		metrics:       newMetricsRegistry(),
+		signals:       signals,
+		permissions:   newPermissionPolicy(def.Name, def.TrustLevel, flags),
+		prompts:       newPrompter(def.Name, flags),
+		contextStore:  &localContextStore{path: t.TempDir()},
+		checkpointDir: t.TempDir(),
+	}
+	safety := &SafetyState{
+		MaxDepth:  5,
+		CallChain: []string{def.Name},
+		Hops:      []CallHop{{Name: def.Name, Version: def.Version}},
+		SessionID: "server-session",
+	}
+	return newAgentServer(runner, safety, map[string]any{"greeting": "hello"}, false, &LoggingConfig{Suppressed: true}, "")
+}
+
+func postExecute(t *testing.T, url string, body string) (*http.Response, executeResponse) {
+	t.Helper()
+	resp, err := http.Post(url+"/execute", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out executeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	return resp, out
+}
+
+func TestServeExecute(t *testing.T) {
+	s := newTestServer(t, &AgentDef{
+		Name:    "greeter",
+		Version: "1.0.0",
+		Options: []OptionDef{{Name: "greeting", Type: "string"}},
+		Execute: func(ctx *ExecuteContext) (any, error) {
+			return ctx.Options["greeting"].(string) + ", " + ctx.Input, nil
+		},
+	})
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	resp, out := postExecute(t, srv.URL, `{"input":"world"}`)
+	if resp.StatusCode != http.StatusOK || out.Result != "hello, world" || out.ExitCode != ExitSuccess {
+		t.Errorf("unexpected response %d: %+v", resp.StatusCode, out)
+	}
+	if out.SessionID == "" || out.SessionID == "server-session" {
+		t.Errorf("expected a fresh session per request, got %q", out.SessionID)
+	}
+
+	_, out = postExecute(t, srv.URL, `{"input":"there","options":{"greeting":"hi"},"sessionId":"s-1"}`)
+	if out.Result != "hi, there" || out.SessionID != "s-1" {
+		t.Errorf("expected request options and session honored, got %+v", out)
+	}
+}
+
+func TestServeExecuteFailures(t *testing.T) {
+	s := newTestServer(t, &AgentDef{
+		Name: "failer",
+		Execute: func(ctx *ExecuteContext) (any, error) {
+			switch ctx.Input {
+			case "deny":
+				return nil, ctx.RequestPermission("write to repo")
+			case "panic":
+				panic("boom")
+			case "slow":
+				<-ctx.Ctx.Done()
+				return nil, ctx.Ctx.Err()
+			}
+			return nil, &AgentError{Code: "quota_exhausted", Message: "out of quota", Retryable: true}
+		},
+	})
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	cases := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"input":"quota"}`, http.StatusInternalServerError, "quota_exhausted"},
+		{`{"input":"deny"}`, http.StatusForbidden, ErrCodePermissionDenied},
+		{`{"input":"panic"}`, http.StatusInternalServerError, ErrCodeExecutionFailed},
+		{`{"input":"slow","timeout":1}`, http.StatusGatewayTimeout, ErrCodeTimeout},
+		{`not json`, http.StatusBadRequest, ErrCodeInvalidUsage},
+		{`{"input":"quota","sessionId":"../../etc"}`, http.StatusBadRequest, ErrCodeInvalidUsage},
+	}
+	captureStderr(t, func() {
+		for _, c := range cases {
+			resp, out := postExecute(t, srv.URL, c.body)
+			if resp.StatusCode != c.status || out.Error == nil || out.Error.Code != c.code {
+				t.Errorf("%s: expected %d/%s, got %d/%+v", c.body, c.status, c.code, resp.StatusCode, out.Error)
+			}
+		}
+	})
+}
+
+func TestServeExecuteRequiresToken(t *testing.T) {
+	s := newTestServer(t, &AgentDef{Name: "guarded", Execute: func(*ExecuteContext) (any, error) { return "ok", nil }})
+	s.token = "s3cret"
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	resp, out := postExecute(t, srv.URL, `{"input":"x"}`)
+	if resp.StatusCode != http.StatusUnauthorized || out.Error == nil || out.Error.Code != ErrCodePermissionDenied {
+		t.Errorf("expected 401 without a token, got %d/%+v", resp.StatusCode, out.Error)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/execute", strings.NewReader(`{"input":"x"}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	req.Header.Set("Content-Type", "application/json")
+	authed, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authed.Body.Close()
+	if authed.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 with the token, got %d", authed.StatusCode)
+	}
+}
+
+func TestServeExecuteRejectsCrossSite(t *testing.T) {
+	s := newTestServer(t, &AgentDef{Name: "guarded", Execute: func(*ExecuteContext) (any, error) { return "ok", nil }})
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	cases := []struct {
+		origin, contentType string
+		status              int
+	}{
+		{"", "application/json", http.StatusOK},
+		{srv.URL, "application/json; charset=utf-8", http.StatusOK},
+		{"https://evil.example", "application/json", http.StatusForbidden},
+		{"", "text/plain", http.StatusUnsupportedMediaType},
+		{"", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"", "", http.StatusUnsupportedMediaType},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/execute", strings.NewReader(`{"input":"x"}`))
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("origin %q, content type %q: expected %d, got %d", c.origin, c.contentType, c.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestServeListenAddr(t *testing.T) {
+	for _, c := range []struct {
+		addr, token, want string
+	}{
+		{":8080", "", "127.0.0.1:8080"},
+		{"localhost:8080", "", "localhost:8080"},
+		{"[::1]:8080", "", "[::1]:8080"},
+		{"0.0.0.0:8080", "s3cret", "0.0.0.0:8080"},
+	} {
+		if got, err := serveListenAddr(c.addr, c.token); err != nil || got != c.want {
+			t.Errorf("serveListenAddr(%q) = %q, %v; want %q", c.addr, got, err, c.want)
+		}
+	}
+	for _, addr := range []string{"0.0.0.0:8080", "10.0.0.5:8080", "8080"} {
+		if _, err := serveListenAddr(addr, ""); err == nil {
+			t.Errorf("serveListenAddr(%q) without a token should fail", addr)
+		}
+	}
+}
+
+func TestServeExecuteDuringReload(t *testing.T) {
+	s := newTestServer(t, &AgentDef{Name: "reloader", Execute: func(*ExecuteContext) (any, error) { return "ok", nil }})
+	s.runner.signals.setReloader(func() (map[string]any, map[string]string) { return map[string]any{}, nil })
+
+	captureStderr(t, func() {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 20; i++ {
+				s.runner.signals.handle(syscall.SIGHUP)
+			}
+		}()
+		for i := 0; i < 20; i++ {
+			if resp := s.execute(context.Background(), executeRequest{Input: "x"}, nil); resp.ExitCode != ExitSuccess {
+				t.Errorf("expected success during reload, got %+v", resp.Error)
+			}
+		}
+		<-done
+	})
+}
+
+func TestServeExecuteWithoutHops(t *testing.T) {
+	s := newTestServer(t, &AgentDef{Name: "orphan", Execute: func(*ExecuteContext) (any, error) { return "ok", nil }})
+	s.safety.Hops = nil
+
+	if resp := s.execute(context.Background(), executeRequest{Input: "x"}, nil); resp.ExitCode != ExitSuccess || resp.Result != "ok" {
+		t.Errorf("expected success without a hop chain, got %+v", resp)
+	}
+}
+
+func TestServeDescribeAndHealth(t *testing.T) {
+	s := newTestServer(t, &AgentDef{Name: "describer", Version: "2.0.0", Execute: func(*ExecuteContext) (any, error) { return nil, nil }})
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/describe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var desc map[string]any
+	json.NewDecoder(resp.Body).Decode(&desc)
+	resp.Body.Close()
+	if desc["name"] != "describer" || desc["version"] != "2.0.0" {
+		t.Errorf("unexpected describe output: %v", desc)
+	}
+
+	resp, err = http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected healthy, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(srv.URL+"/healthz", "", bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for POST /healthz, got %d", resp.StatusCode)
+	}
+}
+
+func TestServeHTTPShutsDownOnCancel(t *testing.T) {
+	s := newTestServer(t, &AgentDef{Name: "stopper", Execute: func(*ExecuteContext) (any, error) { return nil, nil }})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+	captureStderr(t, func() {
+		go func() { errCh <- s.serveHTTP(ctx, "127.0.0.1:0") }()
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		select {
+		case err := <-errCh:
+			if err != nil && !errors.Is(err, context.Canceled) {
+				t.Errorf("expected clean shutdown, got %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("server did not shut down")
+		}
+	})
+}
@@ -1,51 +1,66 @@
 package sfa
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// checkDockerAvailability verifies that Docker and Docker Compose are available.
-func checkDockerAvailability() error {
-	if _, err := exec.LookPath("docker"); err != nil {
-		return fmt.Errorf("Docker is not installed or not in PATH. Install Docker to use service dependencies")
-	}
-
-	cmd := exec.Command("docker", "compose", "version")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Docker Compose is not available. Install Docker Compose to use service dependencies")
-	}
-
-	return nil
-}
-
 // materializeCompose writes a Docker Compose YAML file from agent service definitions.
 // Returns the file path.
 func materializeCompose(agentName, version string, services map[string]ServiceDef) (string, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("failed to determine home directory: %w", err)
+	dir := dataDir("services", agentName)
+	if dir == "" {
+		return "", fmt.Errorf("failed to determine the data directory")
 	}
-
-	dir := filepath.Join(home, ".local", "share", "single-file-agents", "services", agentName)
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return "", fmt.Errorf("failed to create services directory: %w", err)
 	}
 
 	composePath := filepath.Join(dir, "compose.yaml")
 
+	// Services are written in start order, so dependencies come first
+	order, err := serviceStartOrder(services)
+	if err != nil {
+		return "", err
+	}
+
 	// Build YAML content
 	var b strings.Builder
 	b.WriteString("services:\n")
 
-	for name, svc := range services {
+	for _, name := range order {
+		svc := services[name]
 		b.WriteString(fmt.Sprintf("  %s:\n", name))
-		b.WriteString(fmt.Sprintf("    image: %s\n", svc.Image))
+		b.WriteString(fmt.Sprintf("    image: %s\n", serviceImage(agentName, name, svc)))
+		if svc.Build != nil {
+			if err := writeComposeBuild(&b, name, svc.Build); err != nil {
+				return "", err
+			}
+		}
+
+		if err := checkProfiles(name, svc); err != nil {
+			return "", err
+		}
+		if len(svc.Profiles) > 0 {
+			b.WriteString("    profiles:\n")
+			for _, profile := range svc.Profiles {
+				b.WriteString(fmt.Sprintf("      - %q\n", profile))
+			}
+		}
+
+		if len(svc.DependsOn) > 0 {
+			b.WriteString("    depends_on:\n")
+			for _, dep := range sortedKeys(svc.DependsOn) {
+				b.WriteString(fmt.Sprintf("      %s:\n", dep))
+				b.WriteString(fmt.Sprintf("        condition: %s\n", dependsOnCondition(svc.DependsOn[dep])))
+			}
+		}
 
 		if len(svc.Ports) > 0 {
 			b.WriteString("    ports:\n")
@@ -56,10 +71,9 @@ func materializeCompose(agentName, version string, services map[string]ServiceDe
 
 		if len(svc.Environment) > 0 {
 			b.WriteString("    environment:\n")
-			for k, v := range svc.Environment {
-				// Interpolate ${VAR} from process env
-				expanded := os.ExpandEnv(v)
-				b.WriteString(fmt.Sprintf("      %s: %q\n", k, expanded))
+			for _, k := range sortedKeys(svc.Environment) {
+				// ${VAR} is left for compose to fill from the .env file
+				b.WriteString(fmt.Sprintf("      %s: %q\n", k, svc.Environment[k]))
 			}
 		}
 
@@ -82,6 +96,10 @@ func materializeCompose(agentName, version string, services map[string]ServiceDe
 			}
 		}
 
+		if err := writeComposeContainerOptions(&b, name, svc); err != nil {
+			return "", err
+		}
+
 		if svc.Healthcheck != nil {
 			b.WriteString("    healthcheck:\n")
 			b.WriteString(fmt.Sprintf("      test: %s\n", svc.Healthcheck.Test))
@@ -99,177 +117,770 @@ func materializeCompose(agentName, version string, services map[string]ServiceDe
 			}
 		}
 
+		if err := checkReadyProbe(name, svc); err != nil {
+			return "", err
+		}
+		if svc.ConnString != "" {
+			if _, err := renderConnString("localhost", svc); err != nil {
+				return "", fmt.Errorf("service %s: connection string: %w (set it in the environment or the service's Environment; ${port} needs a published port)", name, err)
+			}
+		}
+
+		if svc.Resources != nil || svc.GPUs != "" {
+			b.WriteString("    deploy:\n")
+			b.WriteString("      resources:\n")
+		}
+		if limits := svc.Resources; limits != nil {
+			if err := checkResourceLimits(name, limits); err != nil {
+				return "", err
+			}
+			b.WriteString("        limits:\n")
+			if limits.CPUs != "" {
+				b.WriteString(fmt.Sprintf("          cpus: %q\n", limits.CPUs))
+			}
+			if limits.Memory != "" {
+				b.WriteString(fmt.Sprintf("          memory: %s\n", limits.Memory))
+			}
+			if limits.Pids > 0 {
+				b.WriteString(fmt.Sprintf("          pids: %d\n", limits.Pids))
+			}
+		}
+		if svc.GPUs != "" {
+			request, err := parseGPURequest(name, svc.GPUs)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString("        reservations:\n")
+			b.WriteString("          devices:\n")
+			b.WriteString("            - driver: nvidia\n")
+			if len(request.DeviceIDs) > 0 {
+				b.WriteString("              device_ids:\n")
+				for _, id := range request.DeviceIDs {
+					b.WriteString(fmt.Sprintf("                - %q\n", id))
+				}
+			} else {
+				b.WriteString(fmt.Sprintf("              count: %s\n", request.Count))
+			}
+			b.WriteString("              capabilities: [gpu]\n")
+		}
+
+		if len(svc.Networks) > 0 {
+			// Listing networks drops the implicit default one, so keep it
+			// for the agent's own services
+			b.WriteString("    networks:\n")
+			b.WriteString("      default: {}\n")
+			for _, network := range svc.Networks {
+				b.WriteString(fmt.Sprintf("      %s:\n", network))
+				b.WriteString("        aliases:\n")
+				b.WriteString(fmt.Sprintf("          - %q\n", agentName+"-"+name))
+			}
+		}
+
 		// Add SFA labels
 		b.WriteString("    labels:\n")
 		b.WriteString(fmt.Sprintf("      sfa.agent: %q\n", agentName))
 		b.WriteString(fmt.Sprintf("      sfa.version: %q\n", version))
 	}
 
+	// Shared networks are created before compose up, outside the project,
+	// so taking the services down leaves them for other agents
+	networks, err := sharedNetworks(services)
+	if err != nil {
+		return "", err
+	}
+	// Named volumes are created the same way, so down -v leaves their data
+	volumes, err := namedVolumes(services)
+	if err != nil {
+		return "", err
+	}
+	if len(volumes) > 0 {
+		b.WriteString("volumes:\n")
+		for _, volume := range sortedKeys(volumes) {
+			b.WriteString(fmt.Sprintf("  %s:\n", volume))
+			b.WriteString(fmt.Sprintf("    name: %s\n", serviceVolumeName(agentName, volume)))
+			b.WriteString("    external: true\n")
+		}
+	}
+	if len(networks) > 0 {
+		b.WriteString("networks:\n")
+		for _, network := range networks {
+			b.WriteString(fmt.Sprintf("  %s:\n", network))
+			b.WriteString(fmt.Sprintf("    name: %s\n", sharedNetworkName(network)))
+			b.WriteString("    external: true\n")
+		}
+	}
+
+	// The compose file holds ${VAR} references, not values; the values,
+	// secrets included, go to a .env file only the user can read
 	content := b.String()
-	if err := os.WriteFile(composePath, []byte(content), 0644); err != nil {
+	if err := writeFileAtomic(composePath, []byte(content), 0600); err != nil {
 		return "", fmt.Errorf("failed to write compose file: %w", err)
 	}
+	if err := writeFileAtomic(filepath.Join(dir, ".env"), []byte(composeEnvFile(content)), 0600); err != nil {
+		return "", fmt.Errorf("failed to write compose env file: %w", err)
+	}
 
 	return composePath, nil
 }
 
-// composeHash returns a SHA256 hash of the compose content for change detection.
-func composeHash(content string) string {
-	h := sha256.Sum256([]byte(content))
-	return fmt.Sprintf("%x", h)
+// composeVarPattern matches $VAR and ${VAR...} references compose
+// interpolates, and $$, its escaped dollar sign.
+var composeVarPattern = regexp.MustCompile(`\$(?:\$|\{([A-Za-z_][A-Za-z0-9_]*)|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// composeEnvFile returns the .env file compose reads from the project
+// directory: each variable the compose content references that is set in
+// the environment, which by now includes the agent's resolved env.
+func composeEnvFile(content string) string {
+	seen := make(map[string]bool)
+	var b strings.Builder
+	for _, m := range composeVarPattern.FindAllStringSubmatch(content, -1) {
+		name := m[1] + m[2]
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if v, ok := os.LookupEnv(name); ok {
+			b.WriteString(fmt.Sprintf("%s=%s\n", name, dotenvQuote(v)))
+		}
+	}
+	return b.String()
 }
 
-// startServices starts Docker Compose services for an agent.
-func startServices(agentName, version string, services map[string]ServiceDef, env *ResolvedEnv) error {
-	if len(services) == 0 {
+// dotenvQuote quotes a .env value: single quotes keep it literal, and
+// values that contain one are double-quoted with escapes.
+func dotenvQuote(v string) string {
+	if !strings.ContainsAny(v, "'\n") {
+		return "'" + v + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`).Replace(v) + `"`
+}
+
+// serviceStartOrder returns the service names ordered so that each comes
+// after the services it depends on, otherwise by name. It fails on a
+// dependency that is not declared, an unknown condition, a service_healthy
+// dependency without a healthcheck, or a cycle.
+func serviceStartOrder(services map[string]ServiceDef) ([]string, error) {
+	for _, name := range sortedKeys(services) {
+		for _, dep := range sortedKeys(services[name].DependsOn) {
+			depSvc, ok := services[dep]
+			if !ok {
+				return nil, fmt.Errorf("service %s depends on undeclared service %s", name, dep)
+			}
+			switch cond := dependsOnCondition(services[name].DependsOn[dep]); cond {
+			case DependsOnStarted, DependsOnCompleted:
+			case DependsOnHealthy:
+				if depSvc.Healthcheck == nil {
+					return nil, fmt.Errorf("service %s waits for %s to be healthy, but %s has no healthcheck", name, dep, dep)
+				}
+			default:
+				return nil, fmt.Errorf("service %s: unknown depends-on condition %q for %s", name, cond, dep)
+			}
+		}
+	}
+
+	var order []string
+	state := make(map[string]int) // 1 while visiting, 2 once placed
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("services have a dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, dep := range sortedKeys(services[name].DependsOn) {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, name)
 		return nil
 	}
+	for _, name := range sortedKeys(services) {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// dependsOnCondition returns cond, defaulting to DependsOnStarted.
+func dependsOnCondition(cond string) string {
+	if cond == "" {
+		return DependsOnStarted
+	}
+	return cond
+}
+
+// memoryLimitPattern matches a Compose byte value such as 512m or 1.5g.
+var memoryLimitPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[bkmg]?$`)
+
+// checkResourceLimits rejects limits Docker would refuse, so the error names
+// the service instead of surfacing from compose up.
+func checkResourceLimits(service string, limits *ResourceLimits) error {
+	if limits.CPUs != "" {
+		if cpus, err := strconv.ParseFloat(limits.CPUs, 64); err != nil || cpus <= 0 {
+			return fmt.Errorf("service %s: invalid CPU limit %q (use a positive number of cores, e.g. \"1.5\")", service, limits.CPUs)
+		}
+	}
+	if limits.Memory != "" && !memoryLimitPattern.MatchString(strings.ToLower(limits.Memory)) {
+		return fmt.Errorf("service %s: invalid memory limit %q (use a size such as 512m or 2g)", service, limits.Memory)
+	}
+	if limits.Pids < 0 {
+		return fmt.Errorf("service %s: invalid pids limit %d", service, limits.Pids)
+	}
+	return nil
+}
+
+// networkNamePattern is what a shared network may be called: a Docker
+// network name without the sfa- prefix.
+var networkNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// sharedNetworkName returns the Docker network for a declared shared
+// network.
+func sharedNetworkName(network string) string {
+	return "sfa-" + network
+}
+
+// withAgentNetworks returns services with the agent-wide networks added to
+// each one.
+func withAgentNetworks(services map[string]ServiceDef, networks []string) map[string]ServiceDef {
+	if len(networks) == 0 {
+		return services
+	}
+	joined := make(map[string]ServiceDef, len(services))
+	for name, svc := range services {
+		seen := make(map[string]bool)
+		var all []string
+		for _, network := range append(append([]string(nil), networks...), svc.Networks...) {
+			if !seen[network] {
+				seen[network] = true
+				all = append(all, network)
+			}
+		}
+		svc.Networks = all
+		joined[name] = svc
+	}
+	return joined
+}
+
+// sharedNetworks returns the shared networks the services join, sorted, or
+// an error for an invalid name.
+func sharedNetworks(services map[string]ServiceDef) ([]string, error) {
+	seen := make(map[string]bool)
+	var networks []string
+	for _, name := range sortedKeys(services) {
+		for _, network := range services[name].Networks {
+			if network == "default" || !networkNamePattern.MatchString(network) {
+				return nil, fmt.Errorf("service %s: invalid network name %q (use lowercase letters, digits, '.', '_', and '-')", name, network)
+			}
+			if !seen[network] {
+				seen[network] = true
+				networks = append(networks, network)
+			}
+		}
+	}
+	sort.Strings(networks)
+	return networks, nil
+}
+
+// ensureNetworks creates the shared networks that do not exist yet,
+// labelled so sfa services prune can find them.
+func ensureNetworks(engine containerEngine, networks []string) error {
+	for _, network := range networks {
+		name := sharedNetworkName(network)
+		if engine.command("network", "inspect", name).Run() == nil {
+			continue
+		}
+		args := []string{"network", "create", "--label", "sfa.network=" + network}
+		if session := os.Getenv("SFA_SESSION_ID"); session != "" {
+			args = append(args, "--label", "sfa.session="+session)
+		}
+		out, err := engine.command(append(args, name)...).CombinedOutput()
+		if err != nil {
+			// Another agent may have created it in the meantime
+			if engine.command("network", "inspect", name).Run() == nil {
+				continue
+			}
+			return fmt.Errorf("failed to create network %s: %s", name, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// serviceEnvName returns the SFA_SVC_<NAME>_<suffix> variable for a service.
+func serviceEnvName(service, suffix string) string {
+	return fmt.Sprintf("SFA_SVC_%s_%s", strings.ToUpper(strings.ReplaceAll(service, "-", "_")), suffix)
+}
+
+// serviceOverrideDecls declares the SFA_SVC_<NAME>_URL, _HOST, and _PORT
+// variables that point a service at an existing instance instead of
+// starting it, so setup can prompt for them and the shared config can
+// supply them like any other env var.
+func serviceOverrideDecls(services map[string]ServiceDef) []EnvDef {
+	var decls []EnvDef
+	for _, name := range sortedKeys(services) {
+		decls = append(decls,
+			EnvDef{Name: serviceEnvName(name, "URL"), Description: fmt.Sprintf("connection URL of an existing %s; leave empty to start one", name)},
+			EnvDef{Name: serviceEnvName(name, "HOST"), Description: fmt.Sprintf("host of an existing %s", name)},
+			EnvDef{Name: serviceEnvName(name, "PORT"), Description: fmt.Sprintf("port of an existing %s", name), Type: EnvTypeInt},
+		)
+	}
+	return decls
+}
 
-	// Check which services are externally configured
-	allExternal := true
+// agentEnvDecls returns the agent's env declarations followed by the
+// override variables of its services that it does not declare itself.
+func agentEnvDecls(def *AgentDef) []EnvDef {
+	decls := append([]EnvDef(nil), def.Env...)
+	declared := make(map[string]bool, len(def.Env))
+	for _, decl := range def.Env {
+		declared[decl.Name] = true
+	}
+	for _, decl := range serviceOverrideDecls(def.Services) {
+		if !declared[decl.Name] {
+			decls = append(decls, decl)
+		}
+	}
+	return decls
+}
+
+// defaultServiceStartTimeout is used when AgentDef.ServiceStartTimeout is
+// zero.
+const defaultServiceStartTimeout = 60 * time.Second
+
+// serviceProgressInterval is how often a progress line lists the services
+// still being waited on.
+const serviceProgressInterval = 10 * time.Second
+
+// resolveServiceStartTimeout returns how long to wait for services:
+// SFA_SERVICE_TIMEOUT (seconds) > AgentDef.ServiceStartTimeout > 60s.
+func resolveServiceStartTimeout(declared time.Duration) time.Duration {
+	if v := os.Getenv("SFA_SERVICE_TIMEOUT"); v != "" {
+		if n := parseInt(v, 0); n > 0 {
+			return time.Duration(n) * time.Second
+		}
+		stderrLog.Warn(fmt.Sprintf("ignoring invalid SFA_SERVICE_TIMEOUT %q (want a number of seconds)", v))
+	}
+	if declared > 0 {
+		return declared
+	}
+	return defaultServiceStartTimeout
+}
+
+// allServicesExternal reports whether every service points at an existing
+// instance through SFA_SVC_<NAME>_URL or _HOST, so none are started. Only
+// meaningful before startServices, which sets those variables itself.
+func allServicesExternal(services map[string]ServiceDef) bool {
 	for name := range services {
-		upperName := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
-		urlKey := fmt.Sprintf("SFA_SVC_%s_URL", upperName)
-		hostKey := fmt.Sprintf("SFA_SVC_%s_HOST", upperName)
-		if os.Getenv(urlKey) == "" && os.Getenv(hostKey) == "" {
-			allExternal = false
-			break
+		if os.Getenv(serviceEnvName(name, "URL")) == "" && os.Getenv(serviceEnvName(name, "HOST")) == "" {
+			return false
 		}
 	}
+	return true
+}
+
+// startServices starts an agent's services with the container engine config
+// selects, those in profiles included, waiting up to timeout for them to
+// become healthy and ready.
+func startServices(agentName, version string, services map[string]ServiceDef, profiles []string, env *ResolvedEnv, config map[string]any, timeout time.Duration) error {
+	if len(services) == 0 {
+		return nil
+	}
 
-	if allExternal {
+	if allServicesExternal(services) {
 		return nil // all services externally configured
 	}
 
-	// Check Docker availability
-	if err := checkDockerAvailability(); err != nil {
+	// Find Docker or Podman, and the host its published ports are on
+	engine, err := resolveContainerEngine(config, agentName)
+	if err != nil {
+		return err
+	}
+	host, err := serviceHost(engine)
+	if err != nil {
 		return err
 	}
 
-	// Materialize compose file
+	// Pick host ports for auto:<port> mappings; they are exported below
+	services, err = allocateAutoPorts(agentName, services)
+	if err != nil {
+		return err
+	}
+
+	// Materialize compose file, with every service; the rest of the steps
+	// concern only those the enabled profiles start
 	composePath, err := materializeCompose(agentName, version, services)
 	if err != nil {
 		return err
 	}
+	if err := writeComposeProfiles(composePath, profiles); err != nil {
+		return err
+	}
+	all := services
+	services, err = activeServices(all, profiles)
+	if err != nil {
+		return err
+	}
+
+	networks, err := sharedNetworks(all)
+	if err != nil {
+		return err
+	}
+	if err := ensureNetworks(engine, networks); err != nil {
+		return err
+	}
+	volumes, err := namedVolumes(all)
+	if err != nil {
+		return err
+	}
+	if err := ensureVolumes(engine, agentName, volumes); err != nil {
+		return err
+	}
+
+	// Fail clearly on a missing GPU runtime rather than with compose's
+	// device error
+	if err := checkGPURuntime(engine, services); err != nil {
+		return err
+	}
 
-	// Start services
-	cmd := exec.Command("docker", "compose", "-f", composePath, "up", "-d")
+	// Fail clearly on taken ports rather than with compose's bind error;
+	// a remote engine's ports cannot be checked from here
+	if isLocalHost(host) {
+		if err := checkPortConflicts(engine, agentName, services); err != nil {
+			return err
+		}
+	}
+
+	// Pull missing images concurrently, before anything is stopped
+	if err := pullServiceImages(engine, agentName, services); err != nil {
+		return err
+	}
+
+	// Build images from source whose inputs changed; the new build hash in
+	// the compose file makes the service's container be recreated below
+	if err := buildServices(engine, agentName, composePath, services); err != nil {
+		return err
+	}
+
+	// Take down services whose definition changed since the last run
+	orphans, err := recreateChangedServices(engine, agentName, composePath)
+	if err != nil {
+		return err
+	}
+
+	// Start services; compose starts those that do not depend on each
+	// other in parallel
+	order, _ := serviceStartOrder(services)
+	emitProgress(agentName, fmt.Sprintf("starting %s", strings.Join(order, ", ")))
+	up := append(profileArgs(profiles), "up", "-d")
+	if orphans {
+		up = append(up, "--remove-orphans")
+	}
+	cmd := engine.composeCommand(composePath, up...)
 	cmd.Stdout = os.Stderr
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to start services: %w", err)
 	}
+	saveComposeHashes(composePath)
 
-	// Wait for healthy
-	if err := waitForHealthy(agentName, composePath, 60); err != nil {
+	// From here on, services are reached on the host ports compose actually
+	// published, not the ones declared
+	services = discoverPublishedPorts(engine, composePath, services)
+	saveServiceState(engine, agentName, host, composePath, services)
+
+	// Wait for healthy, then for the SDK's own readiness probes
+	if err := waitForHealthy(agentName, engine, composePath, services, timeout); err != nil {
 		return err
 	}
+	if err := waitForReady(agentName, host, services, timeout); err != nil {
+		dumpComposeLogs(engine, composePath)
+		return withServiceDiagnostics(err, engine, composePath, services)
+	}
 
 	// Inject SFA_SVC_* variables
-	injectServiceVars(agentName, services, composePath)
+	injectServiceVars(agentName, host, services, composePath)
 
 	return nil
 }
 
-// waitForHealthy polls Docker Compose until all services are healthy or running.
-func waitForHealthy(agentName, composePath string, timeoutSeconds int) error {
-	deadline := time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
-
+// waitForHealthy checks compose, on each engine event for the agent's
+// containers and as a fallback poll, until every service is ready: healthy
+// if it has a healthcheck, otherwise running, or exited with code 0 if
+// another service waits for it to complete. A service that exits otherwise
+// fails at once. Progress lines report each service as it becomes ready and,
+// every serviceProgressInterval, the ones still pending.
+func waitForHealthy(agentName string, engine containerEngine, composePath string, services map[string]ServiceDef, timeout time.Duration) error {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	lastReport := start
+	ready := make(map[string]bool)
+
+	// Engine events wake the loop as soon as a container dies or changes
+	// health; compose ps stays the source of truth, polled as a fallback.
+	events, stop := watchServiceEvents(engine, agentName)
+	defer stop()
+	died := make(map[string]composeStatus)
+
+	var pending []string
+	var statuses map[string]composeStatus
 	for time.Now().Before(deadline) {
-		cmd := exec.Command("docker", "compose", "-f", composePath, "ps", "--format", "{{.Status}}")
+		cmd := engine.composeCommand(composePath, "ps", "-a", "--format", "{{.Service}}\t{{.State}}\t{{.Health}}\t{{.ExitCode}}")
 		out, err := cmd.Output()
 		if err != nil {
-			time.Sleep(2 * time.Second)
+			events = awaitServiceEvent(events, died)
 			continue
 		}
 
-		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-		allReady := true
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
-			lower := strings.ToLower(line)
-			if !strings.Contains(lower, "healthy") && !strings.HasPrefix(lower, "up") {
-				allReady = false
-				break
+		statuses = parseComposeStatus(string(out))
+		// A container that died counts as exited even if it was restarted
+		// before this check.
+		for name, st := range died {
+			statuses[name] = st
+		}
+		pending, err = pendingServices(services, statuses)
+		if err != nil {
+			dumpComposeLogs(engine, composePath)
+			return withServiceDiagnostics(err, engine, composePath, services)
+		}
+		waiting := make(map[string]bool, len(pending))
+		for _, name := range pending {
+			waiting[name] = true
+		}
+		for _, name := range sortedKeys(services) {
+			if !waiting[name] && !ready[name] {
+				ready[name] = true
+				emitProgress(agentName, fmt.Sprintf("service %s %s after %s", name, serviceState(statuses, name), formatElapsed(time.Since(start))))
 			}
 		}
-
-		if allReady && len(lines) > 0 && lines[0] != "" {
+		if len(pending) == 0 {
 			return nil
 		}
+		if time.Since(lastReport) >= serviceProgressInterval {
+			lastReport = time.Now()
+			emitProgress(agentName, fmt.Sprintf("waiting for services (%s of %s): %s", formatElapsed(time.Since(start)), formatElapsed(timeout), describePending(pending, statuses)))
+		}
 
-		time.Sleep(2 * time.Second)
+		events = awaitServiceEvent(events, died)
 	}
 
 	// Timeout — dump logs for debugging
-	dumpCmd := exec.Command("docker", "compose", "-f", composePath, "logs", "--tail", "50")
+	dumpComposeLogs(engine, composePath)
+
+	err := fmt.Errorf("services failed to become healthy within %s", formatElapsed(timeout))
+	if len(pending) > 0 {
+		err = fmt.Errorf("services failed to become healthy within %s (waiting on %s); raise AgentDef.ServiceStartTimeout or set SFA_SERVICE_TIMEOUT to wait longer", formatElapsed(timeout), describePending(pending, statuses))
+	}
+	return withServiceDiagnostics(err, engine, composePath, services)
+}
+
+// serviceState describes a service's container for progress lines, e.g.
+// "healthy", "starting", "completed", or "not created".
+func serviceState(statuses map[string]composeStatus, name string) string {
+	st, ok := statuses[name]
+	switch {
+	case !ok:
+		return "not created"
+	case st.State == "exited":
+		return "completed"
+	case st.State == "running" && st.Health != "":
+		return st.Health
+	default:
+		return st.State
+	}
+}
+
+// describePending lists pending services with their state, e.g.
+// "db (starting), app (not created)".
+func describePending(pending []string, statuses map[string]composeStatus) string {
+	parts := make([]string, len(pending))
+	for i, name := range pending {
+		parts[i] = fmt.Sprintf("%s (%s)", name, serviceState(statuses, name))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatElapsed renders a duration to the second, e.g. "14s" or "1m30s".
+func formatElapsed(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
+
+// composeStatus is one container's state from docker compose ps.
+type composeStatus struct {
+	State    string // running, exited, created, restarting, ...
+	Health   string // healthy, unhealthy, starting, or "" without a healthcheck
+	ExitCode int
+}
+
+// parseComposeStatus reads "service\tstate\thealth\texit code" lines.
+func parseComposeStatus(out string) map[string]composeStatus {
+	statuses := make(map[string]composeStatus)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		st := composeStatus{State: strings.ToLower(fields[1])}
+		if len(fields) > 2 {
+			st.Health = strings.ToLower(fields[2])
+		}
+		if len(fields) > 3 {
+			st.ExitCode = parseInt(fields[3], 0)
+		}
+		statuses[fields[0]] = st
+	}
+	return statuses
+}
+
+// pendingServices returns the services that are not ready yet, in start
+// order, or an error if one has exited when it should still be running.
+func pendingServices(services map[string]ServiceDef, statuses map[string]composeStatus) ([]string, error) {
+	order, err := serviceStartOrder(services)
+	if err != nil {
+		return nil, err
+	}
+	oneShot := make(map[string]bool)
+	for _, svc := range services {
+		for dep, cond := range svc.DependsOn {
+			if cond == DependsOnCompleted {
+				oneShot[dep] = true
+			}
+		}
+	}
+
+	var pending []string
+	for _, name := range order {
+		st, ok := statuses[name]
+		switch {
+		case !ok:
+			pending = append(pending, name)
+		case st.State == "exited" || st.State == "dead":
+			if !oneShot[name] || st.ExitCode != 0 {
+				return nil, fmt.Errorf("service %s exited with code %d", name, st.ExitCode)
+			}
+		case st.State != "running":
+			pending = append(pending, name)
+		case services[name].Healthcheck != nil && st.Health != "healthy":
+			pending = append(pending, name)
+		}
+	}
+	return pending, nil
+}
+
+// dumpComposeLogs writes the services' recent logs to stderr.
+func dumpComposeLogs(engine containerEngine, composePath string) {
+	dumpCmd := engine.composeCommand(composePath, "logs", "--tail", "50")
 	dumpCmd.Stdout = os.Stderr
 	dumpCmd.Stderr = os.Stderr
 	dumpCmd.Run()
+}
 
-	return fmt.Errorf("services failed to become healthy within %d seconds", timeoutSeconds)
+// servicePort returns the port a service is reached on from the agent: its
+// first published host port, or "" if it publishes none.
+func servicePort(svc ServiceDef) string {
+	if published := publishedPorts(svc.Ports); len(published) > 0 {
+		return strconv.Itoa(published[0].Port)
+	}
+	return ""
 }
 
-// injectServiceVars sets SFA_SVC_* environment variables for running services.
-func injectServiceVars(agentName string, services map[string]ServiceDef, composePath string) {
+// injectServiceVars sets SFA_SVC_* environment variables for running
+// services, which are reached on host, the engine's machine.
+func injectServiceVars(agentName, host string, services map[string]ServiceDef, composePath string) {
 	for name, svc := range services {
 		upperName := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
 
-		// Default host and port from compose port mappings
-		host := "localhost"
-		port := ""
-		if len(svc.Ports) > 0 {
-			parts := strings.Split(svc.Ports[0], ":")
-			if len(parts) >= 2 {
-				port = parts[0]
-			}
-		}
+		port := servicePort(svc)
 
 		os.Setenv(fmt.Sprintf("SFA_SVC_%s_HOST", upperName), host)
 		if port != "" {
 			os.Setenv(fmt.Sprintf("SFA_SVC_%s_PORT", upperName), port)
 			os.Setenv(fmt.Sprintf("SFA_SVC_%s_URL", upperName), fmt.Sprintf("%s:%s", host, port))
 		}
+		if svc.ConnString != "" {
+			// Checked by materializeCompose, so it renders
+			url, _ := renderConnString(host, svc)
+			os.Setenv(fmt.Sprintf("SFA_SVC_%s_URL", upperName), url)
+		}
+	}
+}
+
+// renderConnString fills in a service's ConnString: ${host} and ${port}
+// from host and its published port, and any other ${VAR} from the service's
+// Environment, then the process environment. It fails naming the
+// placeholders it cannot fill.
+func renderConnString(host string, svc ServiceDef) (string, error) {
+	port := servicePort(svc)
+	var unresolved []string
+	url := os.Expand(svc.ConnString, func(name string) string {
+		switch name {
+		case "host":
+			return host
+		case "port":
+			if port == "" {
+				unresolved = append(unresolved, name)
+			}
+			return port
+		}
+		if v, ok := svc.Environment[name]; ok {
+			return os.ExpandEnv(v)
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		unresolved = append(unresolved, name)
+		return ""
+	})
+	if len(unresolved) > 0 {
+		return "", fmt.Errorf("unresolved ${%s}", strings.Join(unresolved, "}, ${"))
 	}
+	return url, nil
 }
 
-// stopServices stops Docker Compose services.
-func stopServices(agentName string, lifecycle ServiceLifecycle, services map[string]ServiceDef) {
-	if lifecycle == ServicePersistent || len(services) == 0 {
+// stopServices stops an agent's ephemeral services. Session services are
+// left to endSessionServices.
+func stopServices(agentName string, lifecycle ServiceLifecycle, services map[string]ServiceDef, config map[string]any) {
+	if lifecycle == ServicePersistent || lifecycle == ServiceSession || len(services) == 0 {
 		return
 	}
 
-	composeDown(agentName)
+	composeDown(agentName, config)
+	removeServiceVolumes(agentName, config)
 }
 
-// composeDown tears down Docker Compose services for an agent.
-func composeDown(agentName string) {
-	home, err := os.UserHomeDir()
+// composeDown tears down an agent's compose services. Without a container
+// engine there is nothing to tear down.
+func composeDown(agentName string, config map[string]any) {
+	dir := dataDir("services", agentName)
+	if dir == "" {
+		return
+	}
+	engine, err := resolveContainerEngine(config, agentName)
 	if err != nil {
 		return
 	}
 
-	dir := filepath.Join(home, ".local", "share", "single-file-agents", "services", agentName)
-
 	// Try modern name first, then legacy
 	for _, name := range []string{"compose.yaml", "docker-compose.yml"} {
 		composePath := filepath.Join(dir, name)
 		if _, err := os.Stat(composePath); err == nil {
-			cmd := exec.Command("docker", "compose", "-f", composePath, "down", "-v")
+			cmd := engine.composeCommand(composePath, "down", "-v")
 			cmd.Stdout = os.Stderr
 			cmd.Stderr = os.Stderr
 			cmd.Run()
+			os.Remove(filepath.Join(dir, lastUsedFile))
+			os.Remove(filepath.Join(dir, serviceStateFile))
 			return
 		}
 	}
 }
 
 // handleServicesDown handles the --services-down flag.
-func handleServicesDown(agentName string) {
-	composeDown(agentName)
+func handleServicesDown(agentName string, config map[string]any) {
+	composeDown(agentName, config)
 	emitProgress(agentName, "services stopped")
 	os.Exit(ExitSuccess)
 }
@@ -0,0 +1,119 @@
+package sfa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// sessionServices is the refcount file at sessions/<id>.services.json for
+// agents with ServiceSession lifecycle: how many running invocations in the
+// session use each agent's services, and whether the root agent has exited.
+type sessionServices struct {
+	Agents     map[string]int `json:"agents"`
+	RootExited bool           `json:"rootExited,omitempty"`
+}
+
+// sessionServicesPath returns the refcount file for a session, or "" if the
+// sessions directory cannot be determined.
+func sessionServicesPath(sessionID string) string {
+	dir := resolveSessionsDir()
+	if dir == "" || sessionID == "" {
+		return ""
+	}
+	return filepath.Join(dir, sessionID+".services.json")
+}
+
+// holdSessionServices counts one more running invocation using agentName's
+// session services.
+func holdSessionServices(sessionID, agentName string) {
+	updateSessionServices(sessionID, func(s *sessionServices) bool {
+		s.Agents[agentName]++
+		return false
+	})
+}
+
+// releaseSessionServices drops this invocation's hold on agentName's
+// services, if it has one, and records the root agent's exit. Once the root
+// agent has exited and nothing holds any services, it returns the agents
+// whose services to tear down and removes the refcount file.
+func releaseSessionServices(sessionID, agentName string, root bool) []string {
+	// Most sessions start no session services; don't leave files for them
+	if path := sessionServicesPath(sessionID); path == "" {
+		return nil
+	} else if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	var teardown []string
+	updateSessionServices(sessionID, func(s *sessionServices) bool {
+		if agentName != "" && s.Agents[agentName] > 0 {
+			s.Agents[agentName]--
+		}
+		if root {
+			s.RootExited = true
+		}
+		if !s.RootExited {
+			return false
+		}
+		for _, n := range s.Agents {
+			if n > 0 {
+				return false
+			}
+		}
+		teardown = sortedKeys(s.Agents)
+		return true
+	})
+	return teardown
+}
+
+// endSessionServices releases this invocation's hold and tears down the
+// session's services when releaseSessionServices says they are done.
+func endSessionServices(sessionID, agentName string, root bool, config map[string]any) {
+	for _, agent := range releaseSessionServices(sessionID, agentName, root) {
+		composeDown(agent, config)
+		removeServiceVolumes(agent, config)
+	}
+}
+
+// updateSessionServices applies fn to the refcount file under an exclusive
+// lock, removing the file if fn returns true. Failures are warned to stderr and ignored.
+func updateSessionServices(sessionID string, fn func(s *sessionServices) (remove bool)) {
+	path := sessionServicesPath(sessionID)
+	if path == "" {
+		return
+	}
+	err := withFileLock(path, func() error {
+		s := &sessionServices{}
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal(data, s); err != nil {
+				return fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+		}
+		if s.Agents == nil {
+			s.Agents = make(map[string]int)
+		}
+
+		if fn(s) || len(s.Agents) == 0 {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			return nil
+		}
+		data, err = json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, append(data, '\n'), 0644)
+	})
+	if err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to update session services: %v", err))
+	}
+}
@@ -0,0 +1,44 @@
+package sfa
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSessionServicesRefcount(t *testing.T) {
+	t.Setenv("SFA_DATA_HOME", t.TempDir())
+	const session = "sess-1"
+
+	// A root agent that started no session services leaves nothing behind
+	if got := releaseSessionServices(session, "", true); got != nil {
+		t.Errorf("expected nothing to tear down, got %v", got)
+	}
+	if _, err := os.Stat(sessionServicesPath(session)); !os.IsNotExist(err) {
+		t.Errorf("expected no refcount file, got %v", err)
+	}
+
+	// Two subagents share the database agent's services; the root agent
+	// starts its own
+	holdSessionServices(session, "root")
+	holdSessionServices(session, "db-agent")
+	holdSessionServices(session, "db-agent")
+	if got := releaseSessionServices(session, "db-agent", false); got != nil {
+		t.Errorf("expected services kept while the root runs, got %v", got)
+	}
+	if got := releaseSessionServices(session, "db-agent", false); got != nil {
+		t.Errorf("expected services kept while the root runs, got %v", got)
+	}
+
+	holdSessionServices(session, "late-agent")
+	if got := releaseSessionServices(session, "root", true); got != nil {
+		t.Errorf("expected services kept while late-agent runs, got %v", got)
+	}
+	got := releaseSessionServices(session, "late-agent", false)
+	if strings.Join(got, ",") != "db-agent,late-agent,root" {
+		t.Errorf("teardown = %v, want every agent once the last holder exits", got)
+	}
+	if _, err := os.Stat(sessionServicesPath(session)); !os.IsNotExist(err) {
+		t.Errorf("expected the refcount file removed, got %v", err)
+	}
+}
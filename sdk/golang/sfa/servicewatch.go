@@ -0,0 +1,202 @@
+package sfa
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultServiceLogTail is how many lines ServiceLogs returns when tail is
+// not positive.
+const defaultServiceLogTail = 100
+
+// serviceWatchInterval is how often services are checked while an
+// OnServiceUnhealthy hook is registered; a variable so tests can shorten it.
+var serviceWatchInterval = 5 * time.Second
+
+// serviceMonitor backs ExecuteContext.ServiceLogs and OnServiceUnhealthy for
+// the services an agent started. Polling starts with the first hook and
+// stops at cleanup. Its methods are nil-safe; nil means no services run.
+type serviceMonitor struct {
+	agentName string
+	services  map[string]ServiceDef
+	config    map[string]any
+	external  bool // every service was externally configured, so none run
+
+	mu       sync.Mutex
+	hooks    map[int]func(service, state string)
+	nextHook int
+	done     chan struct{} // nil until polling starts
+}
+
+// newServiceMonitor returns a monitor for an agent's services, or nil if it
+// declares none. external is allServicesExternal from before they started.
+func newServiceMonitor(agentName string, services map[string]ServiceDef, config map[string]any, external bool) *serviceMonitor {
+	if len(services) == 0 {
+		return nil
+	}
+	return &serviceMonitor{
+		agentName: agentName,
+		services:  services,
+		config:    config,
+		external:  external,
+		hooks:     make(map[int]func(service, state string)),
+	}
+}
+
+// composePath returns the agent's materialized compose file.
+func (m *serviceMonitor) composePath() string {
+	return filepath.Join(dataDir("services", m.agentName), "compose.yaml")
+}
+
+// logs returns a service's last tail log lines.
+func (m *serviceMonitor) logs(name string, tail int) (string, error) {
+	if m == nil {
+		return "", fmt.Errorf("service %s: the agent declares no services", name)
+	}
+	if _, ok := m.services[name]; !ok {
+		return "", fmt.Errorf("service %s: not declared (declared: %s)", name, strings.Join(sortedKeys(m.services), ", "))
+	}
+	if m.external {
+		return "", fmt.Errorf("service %s: externally configured, so its logs are not available to the agent", name)
+	}
+	if tail <= 0 {
+		tail = defaultServiceLogTail
+	}
+	engine, err := resolveContainerEngine(m.config, m.agentName)
+	if err != nil {
+		return "", err
+	}
+	out, err := engine.composeCommand(m.composePath(), "logs", "--no-color", "--tail", strconv.Itoa(tail), name).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("service %s: failed to read logs: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// onUnhealthy registers fn until ctx is done, starting to poll if needed.
+func (m *serviceMonitor) onUnhealthy(ctx context.Context, fn func(service, state string)) {
+	if m == nil || m.external {
+		return
+	}
+	m.mu.Lock()
+	id := m.nextHook
+	m.nextHook++
+	m.hooks[id] = fn
+	if m.done == nil {
+		m.done = make(chan struct{})
+		go m.watch(m.done)
+	}
+	m.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-m.done:
+		}
+		m.mu.Lock()
+		delete(m.hooks, id)
+		m.mu.Unlock()
+	}()
+}
+
+// stop ends polling.
+func (m *serviceMonitor) stop() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done != nil {
+		select {
+		case <-m.done:
+		default:
+			close(m.done)
+		}
+	}
+}
+
+// watch polls compose until done, calling the hooks once each time a
+// service stops being healthy, and again only after it has recovered.
+func (m *serviceMonitor) watch(done chan struct{}) {
+	engine, err := resolveContainerEngine(m.config, m.agentName)
+	if err != nil {
+		stderrLog.Warn(fmt.Sprintf("cannot watch services: %v", err))
+		return
+	}
+	reported := make(map[string]bool)
+	ticker := time.NewTicker(serviceWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		out, err := engine.composeCommand(m.composePath(), "ps", "-a", "--format", "{{.Service}}\t{{.State}}\t{{.Health}}\t{{.ExitCode}}").Output()
+		if err != nil {
+			continue
+		}
+		m.check(parseComposeStatus(string(out)), reported)
+	}
+}
+
+// check calls the hooks for each service that has become unhealthy since
+// the last check, recording it in reported.
+func (m *serviceMonitor) check(statuses map[string]composeStatus, reported map[string]bool) {
+	for _, name := range sortedKeys(m.services) {
+		state := unhealthyState(m.services, statuses, name)
+		if state == "" {
+			delete(reported, name)
+			continue
+		}
+		if reported[name] {
+			continue
+		}
+		reported[name] = true
+		emitProgress(m.agentName, fmt.Sprintf("service %s %s", name, state))
+
+		m.mu.Lock()
+		ids := make([]int, 0, len(m.hooks))
+		for id := range m.hooks {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		hooks := make([]func(service, state string), len(ids))
+		for i, id := range ids {
+			hooks[i] = m.hooks[id]
+		}
+		m.mu.Unlock()
+		for _, fn := range hooks {
+			fn(name, state)
+		}
+	}
+}
+
+// unhealthyState describes why a service that was ready no longer is, e.g.
+// "unhealthy" or "exited with code 137", or returns "" if it is fine. A
+// one-shot service that completed is fine.
+func unhealthyState(services map[string]ServiceDef, statuses map[string]composeStatus, name string) string {
+	st, ok := statuses[name]
+	switch {
+	case !ok:
+		return "removed"
+	case st.State == "exited" || st.State == "dead":
+		for _, svc := range services {
+			if svc.DependsOn[name] == DependsOnCompleted && st.ExitCode == 0 {
+				return ""
+			}
+		}
+		return fmt.Sprintf("exited with code %d", st.ExitCode)
+	case st.State == "restarting":
+		return "restarting"
+	case st.Health == "unhealthy":
+		return "unhealthy"
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+package sfa
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestServiceMonitorCheck(t *testing.T) {
+	m := newServiceMonitor("watcher", migrationServices(), nil, false)
+	var calls []string
+	m.hooks[0] = func(service, state string) { calls = append(calls, service+" "+state) }
+
+	reported := make(map[string]bool)
+	m.check(parseComposeStatus("db\trunning\thealthy\t0\nmigrations\texited\t\t0\napp\trunning\t\t0\n"), reported)
+	if len(calls) != 0 {
+		t.Errorf("expected no calls while healthy, got %v", calls)
+	}
+
+	m.check(parseComposeStatus("db\trunning\tunhealthy\t0\nmigrations\texited\t\t0\napp\texited\t\t137\n"), reported)
+	m.check(parseComposeStatus("db\trunning\tunhealthy\t0\nmigrations\texited\t\t0\napp\texited\t\t137\n"), reported)
+	if got := strings.Join(calls, "; "); got != "app exited with code 137; db unhealthy" {
+		t.Errorf("calls = %q, want each service reported once", got)
+	}
+
+	// A recovered service is reported again if it turns unhealthy again
+	m.check(parseComposeStatus("db\trunning\thealthy\t0\nmigrations\texited\t\t0\napp\texited\t\t137\n"), reported)
+	m.check(parseComposeStatus("db\trunning\tunhealthy\t0\nmigrations\texited\t\t0\napp\texited\t\t137\n"), reported)
+	if len(calls) != 3 || calls[2] != "db unhealthy" {
+		t.Errorf("calls = %v, want db reported again", calls)
+	}
+}
+
+func TestServiceMonitorLogs(t *testing.T) {
+	var m *serviceMonitor
+	if _, err := m.logs("db", 10); err == nil {
+		t.Error("expected an error without services")
+	}
+	m.onUnhealthy(context.Background(), func(string, string) {}) // nil-safe
+	m.stop()
+
+	m = newServiceMonitor("watcher", migrationServices(), nil, true)
+	if _, err := m.logs("cache", 10); err == nil || !strings.Contains(err.Error(), "not declared") {
+		t.Errorf("expected an undeclared service rejected, got %v", err)
+	}
+	if _, err := m.logs("db", 10); err == nil || !strings.Contains(err.Error(), "externally configured") {
+		t.Errorf("expected external services to have no logs, got %v", err)
+	}
+}
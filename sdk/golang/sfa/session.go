@@ -0,0 +1,177 @@
+package sfa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// SessionManifest is the per-session record at sessions/<id>.json.
+type SessionManifest struct {
+	SessionID      string               `json:"sessionId"`
+	RootAgent      string               `json:"rootAgent"`
+	StartedAt      string               `json:"startedAt"`
+	EndedAt        string               `json:"endedAt,omitempty"`
+	Status         string               `json:"status"` // running, completed, failed
+	Participants   []SessionParticipant `json:"participants"`
+	Cost           map[string]float64   `json:"cost,omitempty"`
+	ContextEntries []string             `json:"contextEntries,omitempty"`
+}
+
+// SessionParticipant records one agent invocation within a session.
+type SessionParticipant struct {
+	Hop       string             `json:"hop"` // encoded call hop, unique per invocation
+	Agent     string             `json:"agent"`
+	Version   string             `json:"version"`
+	Depth     int                `json:"depth"`
+	StartedAt string             `json:"startedAt"`
+	EndedAt   string             `json:"endedAt,omitempty"`
+	ExitCode  *int               `json:"exitCode,omitempty"`
+	Cost      map[string]float64 `json:"cost,omitempty"`
+}
+
+const (
+	sessionRunning   = "running"
+	sessionCompleted = "completed"
+	sessionFailed    = "failed"
+)
+
+// sessionIDPattern matches the session IDs the SDK accepts: UUIDs, or other
+// names made of letters, digits, "-", and "_".
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// validateSessionID rejects a session ID that is unsafe to use in a file
+// name. Session IDs name manifests, checkpoints, context store and rate
+// limit directories, so every ID from outside the agent, whether
+// SFA_SESSION_ID, --resume, or a request, is checked with it before any
+// path is built from it.
+func validateSessionID(id string) error {
+	if !sessionIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid session ID %q: use a UUID, or letters, digits, - and _", id)
+	}
+	return nil
+}
+
+// resolveSessionsDir returns the directory holding session manifests.
+func resolveSessionsDir() string {
+	return dataDir("sessions")
+}
+
+// sessionTracker records this agent's participation in the session manifest.
+// All updates are best-effort: failures are warned to stderr and ignored.
+type sessionTracker struct {
+	path string
+	hop  CallHop
+}
+
+// newSessionTracker returns a tracker for the current session, or nil if the
+// sessions directory cannot be determined or the session ID is invalid.
+func newSessionTracker(dir string, safety *SafetyState) *sessionTracker {
+	if dir == "" || len(safety.Hops) == 0 || validateSessionID(safety.SessionID) != nil {
+		return nil
+	}
+	return &sessionTracker{
+		path: filepath.Join(dir, safety.SessionID+".json"),
+		hop:  safety.Hops[len(safety.Hops)-1],
+	}
+}
+
+// start registers this invocation, creating the manifest if this is the root agent.
+func (t *sessionTracker) start(sessionID string) {
+	t.update(func(m *SessionManifest) {
+		if m.SessionID == "" {
+			m.SessionID = sessionID
+			m.StartedAt = t.hop.Start.Format(time.RFC3339)
+			m.Status = sessionRunning
+		}
+		if t.hop.Depth == 0 {
+			m.RootAgent = t.hop.Name
+		}
+		m.Participants = append(m.Participants, SessionParticipant{
+			Hop:       t.hop.String(),
+			Agent:     t.hop.Name,
+			Version:   t.hop.Version,
+			Depth:     t.hop.Depth,
+			StartedAt: t.hop.Start.Format(time.RFC3339),
+		})
+	})
+}
+
+// addContextEntry links a context entry written during the session.
+func (t *sessionTracker) addContextEntry(path string) {
+	t.update(func(m *SessionManifest) {
+		m.ContextEntries = append(m.ContextEntries, path)
+	})
+}
+
+// finish records this invocation's exit. The root agent also closes the session,
+// records the session-wide cost, which already includes all descendants, and
+// removes the lock file, since no participant updates a closed session.
+func (t *sessionTracker) finish(exitCode int, cost map[string]float64) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	t.update(func(m *SessionManifest) {
+		hop := t.hop.String()
+		for i := range m.Participants {
+			if m.Participants[i].Hop == hop {
+				m.Participants[i].EndedAt = now
+				m.Participants[i].ExitCode = &exitCode
+				m.Participants[i].Cost = cost
+			}
+		}
+		if t.hop.Depth == 0 {
+			m.EndedAt = now
+			m.Cost = cost
+			if exitCode == ExitSuccess {
+				m.Status = sessionCompleted
+			} else {
+				m.Status = sessionFailed
+			}
+		}
+	})
+	if t != nil && t.hop.Depth == 0 {
+		os.Remove(t.path + ".lock")
+	}
+}
+
+// update applies fn to the manifest under an exclusive lock.
+func (t *sessionTracker) update(fn func(m *SessionManifest)) {
+	if t == nil {
+		return
+	}
+	err := withFileLock(t.path, func() error {
+		m, err := readSessionManifest(t.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if m == nil {
+			m = &SessionManifest{}
+		}
+		fn(m)
+
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(t.path, append(data, '\n'), 0644)
+	})
+	if err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to update session manifest: %v", err))
+	}
+}
+
+// readSessionManifest reads a session manifest file.
+func readSessionManifest(path string) (*SessionManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m SessionManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse session manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
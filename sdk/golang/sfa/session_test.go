@@ -0,0 +1,114 @@
+package sfa
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionTrackerLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().UTC()
+	root := &SafetyState{
+		SessionID: "sess-1",
+		Hops:      []CallHop{{Name: "planner", Version: "1.0.0", Start: start}},
+	}
+	child := &SafetyState{
+		SessionID: "sess-1",
+		Hops: []CallHop{
+			root.Hops[0],
+			{Name: "reviewer", Version: "0.2.0", Depth: 1, Start: start.Add(time.Second)},
+		},
+	}
+
+	rootTracker := newSessionTracker(dir, root)
+	rootTracker.start("sess-1")
+
+	childTracker := newSessionTracker(dir, child)
+	childTracker.start("sess-1")
+	childTracker.addContextEntry("/store/reviewer/sess-1/finding.md")
+	childTracker.finish(ExitFailure, map[string]float64{"usd": 0.1})
+
+	rootTracker.finish(ExitSuccess, map[string]float64{"usd": 0.3})
+
+	m, err := readSessionManifest(filepath.Join(dir, "sess-1.json"))
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	if m.RootAgent != "planner" {
+		t.Errorf("expected root planner, got %q", m.RootAgent)
+	}
+	if m.Status != sessionCompleted || m.EndedAt == "" {
+		t.Errorf("expected completed session with end time, got %q/%q", m.Status, m.EndedAt)
+	}
+	if m.Cost["usd"] != 0.3 {
+		t.Errorf("expected session cost 0.3, got %v", m.Cost["usd"])
+	}
+	if len(m.Participants) != 2 {
+		t.Fatalf("expected 2 participants, got %d", len(m.Participants))
+	}
+	p := m.Participants[1]
+	if p.Agent != "reviewer" || p.ExitCode == nil || *p.ExitCode != ExitFailure {
+		t.Errorf("unexpected child participant %+v", p)
+	}
+	if len(m.ContextEntries) != 1 {
+		t.Errorf("expected 1 context entry link, got %v", m.ContextEntries)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sess-1.json.lock")); !os.IsNotExist(err) {
+		t.Error("expected the root agent to remove the lock file when closing the session")
+	}
+}
+
+func TestSessionTrackerConcurrentUpdates(t *testing.T) {
+	dir := t.TempDir()
+	safety := &SafetyState{SessionID: "sess-2", Hops: []CallHop{{Name: "a", Start: time.Now()}}}
+	tracker := newSessionTracker(dir, safety)
+	tracker.start("sess-2")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracker.addContextEntry("/entry.md")
+		}()
+	}
+	wg.Wait()
+
+	m, err := readSessionManifest(filepath.Join(dir, "sess-2.json"))
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	if len(m.ContextEntries) != 20 {
+		t.Errorf("expected 20 entries after concurrent updates, got %d", len(m.ContextEntries))
+	}
+}
+
+func TestNilSessionTracker(t *testing.T) {
+	tracker := newSessionTracker("", &SafetyState{})
+	// Must not panic
+	tracker.start("x")
+	tracker.finish(0, nil)
+}
+
+func TestValidateSessionID(t *testing.T) {
+	for _, id := range []string{generateUUID(), "sess-1", "nightly_run"} {
+		if err := validateSessionID(id); err != nil {
+			t.Errorf("validateSessionID(%q) = %v", id, err)
+		}
+	}
+	for _, id := range []string{"", "../../escaped", "a/b", ".", "..", "a b", strings.Repeat("a", 129)} {
+		if err := validateSessionID(id); err == nil {
+			t.Errorf("expected %q to be rejected", id)
+		}
+	}
+
+	// A tracker never builds a path from an invalid ID
+	safety := &SafetyState{SessionID: "../escaped", Hops: []CallHop{{Name: "planner"}}}
+	if tracker := newSessionTracker(t.TempDir(), safety); tracker != nil {
+		t.Errorf("expected no tracker, got %+v", tracker)
+	}
+}
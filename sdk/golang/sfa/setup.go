@@ -2,15 +2,59 @@ package sfa
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
 )
 
-// runSetup handles the --setup interactive flow for environment variables.
-func runSetup(agentName string, declarations []EnvDef, nonInteractive bool) {
-	if nonInteractive {
-		exitWithError("setup requires interactive mode (remove --non-interactive)", ExitInvalidUsage)
+// runSetup handles --setup: the interactive prompt flow; with --set or
+// --from-json, writing the given values without prompting; with --export
+// and --import, moving the configuration through a .env file; or with
+// --global, editing shared defaults (see runGlobalSetup).
+func runSetup(agentName string, declarations []EnvDef, flags StandardFlags) {
+	if flags.SetupExport != "" {
+		n, err := exportSetup(agentName, declarations, flags.SetupExport)
+		if err != nil {
+			exitWithError(err.Error(), ExitFailure)
+		}
+		if flags.SetupExport != "-" {
+			fmt.Printf("Exported %d values to %s.\n", n, flags.SetupExport)
+		}
+		os.Exit(ExitSuccess)
+	}
+
+	if flags.SetupImport != "" {
+		n, err := importSetup(agentName, declarations, flags.SetupImport, newPrompter(agentName, flags))
+		if err != nil {
+			exitWithError(err.Error(), ExitInvalidUsage)
+		}
+		fmt.Printf("Imported %d values.\n", n)
+		os.Exit(ExitSuccess)
+	}
+
+	if flags.SetupGlobal {
+		runGlobalSetup(agentName, declarations, flags)
+		return
+	}
+
+	if len(flags.SetupSet) > 0 || flags.SetupFromJSON != "" {
+		values, err := collectSetupValues(flags.SetupSet, flags.SetupFromJSON)
+		if err != nil {
+			exitWithError(err.Error(), ExitInvalidUsage)
+		}
+		if err := applySetupValues(agentName, declarations, values); err != nil {
+			exitWithError(err.Error(), ExitInvalidUsage)
+		}
+		fmt.Println("Configuration saved.")
+		os.Exit(ExitSuccess)
+	}
+
+	if flags.NonInteractive {
+		exitWithError("setup requires interactive mode (remove --non-interactive, or pass values with --set or --from-json)", ExitInvalidUsage)
 	}
 
 	if len(declarations) == 0 {
@@ -18,10 +62,126 @@ func runSetup(agentName string, declarations []EnvDef, nonInteractive bool) {
 		os.Exit(ExitSuccess)
 	}
 
-	// Load current config
-	config := loadConfig()
+	// Load current config; answers are collected and merged into a fresh
+	// read when saving
+	envMap := agentEnvNamespace(loadConfig(), agentName)
+	updates := make(map[string]any)
+
+	reader := bufio.NewReader(os.Stdin)
 
-	// Ensure agents namespace exists
+	fmt.Printf("Setup for %s\n\n", agentName)
+
+	servicesShown := false
+	for _, decl := range declarations {
+		if !servicesShown && strings.HasPrefix(decl.Name, "SFA_SVC_") {
+			fmt.Println("\nService overrides (leave empty to let the agent start its own):")
+			servicesShown = true
+		}
+
+		// Show current value
+		current := ""
+		keyringBacked := decl.Source == envSourceKeyring
+		if keyringBacked {
+			val, _, err := lookupKeyring(agentName, decl.Name)
+			if err != nil {
+				exitWithError(err.Error(), ExitFailure)
+			}
+			current = val
+		} else if v, ok := envMap[decl.Name]; ok {
+			current = fmt.Sprintf("%v", v)
+		}
+		if current == "" {
+			current = os.Getenv(decl.Name)
+		}
+
+		hidden := decl.Secret || keyringBacked
+		input := promptSetupValue(reader, decl, current, hidden)
+		if input == "" {
+			continue
+		}
+		if keyringBacked {
+			// Keyring-backed values never touch the config file
+			if err := storeKeyring(agentName, decl.Name, input); err != nil {
+				exitWithError(err.Error(), ExitFailure)
+			}
+			updates[decl.Name] = nil
+			continue
+		}
+		updates[decl.Name] = input
+	}
+
+	if err := saveAgentEnv(agentName, declarations, updates); err != nil {
+		exitWithError(err.Error(), ExitFailure)
+	}
+
+	fmt.Println("\nConfiguration saved.")
+	os.Exit(ExitSuccess)
+}
+
+// promptSetupValue prompts for decl until the answer is empty, a secret
+// reference, or valid for the declared type, and returns it; "" keeps the
+// current value. Hidden values are typed with echo off.
+func promptSetupValue(reader *bufio.Reader, decl EnvDef, current string, hidden bool) string {
+	prompt := decl.Name
+	if decl.Description != "" {
+		prompt += fmt.Sprintf(" (%s)", decl.Description)
+	}
+
+	if current != "" {
+		display := current
+		if hidden {
+			display = "***"
+		}
+		prompt += fmt.Sprintf(" [current: %s]", display)
+	} else if decl.Default != "" {
+		prompt += fmt.Sprintf(" [default: %s]", decl.Default)
+	}
+
+	req := ""
+	if decl.Required {
+		req = " (required)"
+	}
+
+	// Secrets are typed with echo off; @path reads a value, such as a
+	// PEM key, from a file
+	var input string
+	for {
+		fmt.Printf("%s%s: ", prompt, req)
+		line, err := readSetupLine(reader, hidden)
+		input = strings.TrimSpace(line)
+		val, ferr := readSetupValue(input)
+		if ferr != nil {
+			fmt.Printf("  %v\n", ferr)
+			if err != nil {
+				input = ""
+				break
+			}
+			continue
+		}
+		input = val
+		if input == "" || secretProviderFor(input) != nil {
+			break
+		}
+		// Re-prompt until the value matches its declared type
+		verr := checkEnvValue(decl, input)
+		if verr == nil {
+			break
+		}
+		fmt.Printf("  %v\n", verr)
+		if err != nil {
+			input = "" // no more input; keep the current value
+			break
+		}
+	}
+
+	if input != "" && hidden {
+		fmt.Printf("  %s set to %s\n", decl.Name, maskedSummary(input))
+	}
+	return input
+}
+
+// agentEnvNamespace returns config's agents.<name>.env map, creating it.
+func agentEnvNamespace(config map[string]any, agentName string) map[string]any {
 	if config["agents"] == nil {
 		config["agents"] = map[string]any{}
 	}
@@ -33,56 +193,295 @@ func runSetup(agentName string, declarations []EnvDef, nonInteractive bool) {
 	if agentNS["env"] == nil {
 		agentNS["env"] = map[string]any{}
 	}
-	envMap := agentNS["env"].(map[string]any)
+	return agentNS["env"].(map[string]any)
+}
 
-	reader := bufio.NewReader(os.Stdin)
+// collectSetupValues merges the values of a --from-json file ("-" for
+// stdin) with --set KEY=VALUE pairs, which take precedence.
+func collectSetupValues(sets []string, fromJSON string) (map[string]string, error) {
+	values := make(map[string]string)
+	if fromJSON != "" {
+		var data []byte
+		var err error
+		if fromJSON == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(fromJSON)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", fromJSON, err)
+		}
+		var raw map[string]any
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%s must contain a JSON object of variable names to values: %w", fromJSON, err)
+		}
+		for name, v := range raw {
+			switch v := v.(type) {
+			case string:
+				values[name] = v
+			case float64, bool:
+				values[name] = fmt.Sprintf("%v", v)
+			default:
+				return nil, fmt.Errorf("%s: value for %s must be a string, number, or boolean", fromJSON, name)
+			}
+		}
+	}
+	for _, pair := range sets {
+		name, val, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid --set %q (expected KEY=VALUE)", pair)
+		}
+		val, err := readSetupValue(val)
+		if err != nil {
+			return nil, fmt.Errorf("--set %s: %w", name, err)
+		}
+		values[name] = val
+	}
+	return values, nil
+}
 
-	fmt.Printf("Setup for %s\n\n", agentName)
+// readSetupLine reads one answer from stdin, with echo off if hidden and
+// stdin is a terminal.
+func readSetupLine(reader *bufio.Reader, hidden bool) (string, error) {
+	if hidden {
+		if restore, err := disableEcho(os.Stdin); err == nil {
+			defer restore()
+		}
+	}
+	return reader.ReadString('\n')
+}
 
+// readSetupValue resolves an answer or --set value: "@path" is the contents
+// of the file at path, minus one trailing newline, and "@@" escapes a
+// literal leading "@".
+func readSetupValue(val string) (string, error) {
+	if strings.HasPrefix(val, "@@") {
+		return val[1:], nil
+	}
+	path, ok := strings.CutPrefix(val, "@")
+	if !ok {
+		return val, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	s := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
+
+// maskedSummary describes a secret without revealing it.
+func maskedSummary(val string) string {
+	summary := fmt.Sprintf("******** (%d characters", len([]rune(val)))
+	if lines := strings.Count(val, "\n") + 1; lines > 1 {
+		summary += fmt.Sprintf(", %d lines", lines)
+	}
+	return summary + ")"
+}
+
+// applySetupValues validates values against the agent's declarations and
+// saves them, keyring-backed ones to the OS keychain. Nothing is written if
+// any value is undeclared or malformed.
+func applySetupValues(agentName string, declarations []EnvDef, values map[string]string) error {
+	decls := make(map[string]EnvDef, len(declarations))
 	for _, decl := range declarations {
-		// Show current value
-		current := ""
-		if v, ok := envMap[decl.Name]; ok {
-			current = fmt.Sprintf("%v", v)
+		decls[decl.Name] = decl
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		decl, ok := decls[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is not declared by %s", name, agentName))
+			continue
 		}
-		if current == "" {
-			current = os.Getenv(decl.Name)
+		val := values[name]
+		if val == "" || secretProviderFor(val) != nil {
+			continue
 		}
+		if err := checkEnvValue(decl, val); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("setup failed:\n  • %s", strings.Join(problems, "\n  • "))
+	}
 
-		prompt := decl.Name
-		if decl.Description != "" {
-			prompt += fmt.Sprintf(" (%s)", decl.Description)
+	updates := make(map[string]any, len(names))
+	for _, name := range names {
+		if decls[name].Source == envSourceKeyring {
+			if err := storeKeyring(agentName, name, values[name]); err != nil {
+				return err
+			}
+			updates[name] = nil
+			continue
 		}
+		updates[name] = values[name]
+	}
+	return saveAgentEnv(agentName, declarations, updates)
+}
 
-		if current != "" {
-			display := current
-			if decl.Secret {
-				display = "***"
+// saveAgentEnv merges updates into the agent's env namespace in the shared
+// config (a nil value removes the entry), encrypting secrets if
+// secrets.recipient is set. Other keys are re-read and kept as they are on
+// disk, so concurrent setups don't overwrite each other.
+func saveAgentEnv(agentName string, declarations []EnvDef, updates map[string]any) error {
+	err := updateConfig(func(config map[string]any) error {
+		envMap := agentEnvNamespace(config, agentName)
+		for name, val := range updates {
+			if val == nil {
+				delete(envMap, name)
+				continue
 			}
-			prompt += fmt.Sprintf(" [current: %s]", display)
-		} else if decl.Default != "" {
-			prompt += fmt.Sprintf(" [default: %s]", decl.Default)
+			envMap[name] = val
+		}
+		return sealSecrets(config, agentName, declarations)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	return nil
+}
+
+// configuredValues returns the values setup has stored for the agent: its
+// config namespace, decrypted, plus any keyring-backed variables.
+func configuredValues(agentName string, declarations []EnvDef) (map[string]string, error) {
+	values := make(map[string]string)
+	for name, v := range agentEnvNamespace(loadConfig(), agentName) {
+		val, err := decryptConfigValue(agentName, name, fmt.Sprintf("%v", v))
+		if err != nil {
+			return nil, err
 		}
+		values[name] = val
+	}
+	for _, decl := range declarations {
+		if decl.Source != envSourceKeyring {
+			continue
+		}
+		val, found, err := lookupKeyring(agentName, decl.Name)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			values[decl.Name] = val
+		}
+	}
+	return values, nil
+}
 
-		req := ""
-		if decl.Required {
-			req = " (required)"
+// exportSetup writes the agent's configured values to path ("-" for stdout)
+// as a .env file, unmasked, and returns how many were written. Secrets are
+// named in a warning.
+func exportSetup(agentName string, declarations []EnvDef, path string) (int, error) {
+	values, err := configuredValues(agentName, declarations)
+	if err != nil {
+		return 0, err
+	}
+	secret := make(map[string]bool)
+	for _, decl := range declarations {
+		secret[decl.Name] = decl.Secret || decl.Source == envSourceKeyring
+	}
+	var secrets []string
+	for name := range values {
+		if secret[name] || looksSecret(name) {
+			secrets = append(secrets, name)
 		}
+	}
+	sort.Strings(secrets)
 
-		fmt.Printf("%s%s: ", prompt, req)
-		input, _ := reader.ReadString('\n')
-		input = strings.TrimSpace(input)
+	data := formatDotenv(values)
+	if path == "-" {
+		_, err = os.Stdout.WriteString(data)
+	} else {
+		err = os.WriteFile(path, []byte(data), 0600)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to export configuration: %w", err)
+	}
+	if len(secrets) > 0 {
+		stderrLog.Warn(fmt.Sprintf("exported file contains unmasked secrets: %s", strings.Join(secrets, ", ")))
+	}
+	return len(values), nil
+}
+
+// importSetup stores the declared values from a .env file, asking before
+// overwriting a configured value that differs, and returns how many were
+// stored. Undeclared variables are skipped with a warning.
+func importSetup(agentName string, declarations []EnvDef, path string, p *prompter) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	imported, errs := parseDotenv(string(data))
+	if len(errs) > 0 {
+		return 0, fmt.Errorf("%s: %v", path, errors.Join(errs...))
+	}
+	current, err := configuredValues(agentName, declarations)
+	if err != nil {
+		return 0, err
+	}
+	decls := make(map[string]EnvDef, len(declarations))
+	for _, decl := range declarations {
+		decls[decl.Name] = decl
+	}
 
-		if input != "" {
-			envMap[decl.Name] = input
+	names := make([]string, 0, len(imported))
+	for name := range imported {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make(map[string]string)
+	for _, name := range names {
+		decl, ok := decls[name]
+		if !ok {
+			stderrLog.Warn(fmt.Sprintf("skipping %s: not declared by %s", name, agentName))
+			continue
 		}
+		val := imported[name]
+		if cur, ok := current[name]; ok && cur != "" && cur != val {
+			shown, incoming := cur, val
+			if decl.Secret || decl.Source == envSourceKeyring {
+				shown, incoming = "***", "***"
+			}
+			overwrite, err := p.confirm(fmt.Sprintf("%s is %s, import has %s. Overwrite?", name, shown, incoming))
+			if err != nil {
+				return 0, err
+			}
+			if !overwrite {
+				continue
+			}
+		}
+		values[name] = val
 	}
+	if err := applySetupValues(agentName, declarations, values); err != nil {
+		return 0, err
+	}
+	return len(values), nil
+}
 
-	// Save config
-	if err := saveConfig(config); err != nil {
-		exitWithError(fmt.Sprintf("failed to save config: %v", err), ExitFailure)
+// formatDotenv renders values as sorted KEY=VALUE lines that parseDotenv
+// reads back unchanged, double-quoting values that need it.
+func formatDotenv(values map[string]string) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	fmt.Println("\nConfiguration saved.")
-	os.Exit(ExitSuccess)
+	var b strings.Builder
+	for _, name := range names {
+		val := values[name]
+		if strings.ContainsAny(val, " \t\r\n\"'#\\") {
+			r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\r", "\\r", "\t", "\\t")
+			val = `"` + r.Replace(val) + `"`
+		}
+		fmt.Fprintf(&b, "%s=%s\n", name, val)
+	}
+	return b.String()
 }
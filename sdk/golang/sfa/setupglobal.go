@@ -0,0 +1,250 @@
+package sfa
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// globalSettings are the shared settings --setup --global edits, named by
+// their config path. Env var names cannot contain dots, so --set can take
+// either.
+var globalSettings = []EnvDef{
+	{Name: "defaults.timeout", Type: EnvTypeInt, Pattern: "[1-9][0-9]*", Description: "default timeout in seconds"},
+	{Name: "logging.file", Description: "execution log file"},
+	{Name: "metrics.file", Description: "metrics file"},
+	{Name: "contextStore.path", Description: "context store directory"},
+}
+
+// runGlobalSetup handles --setup --global: like runSetup, but writing the
+// shared settings and defaults.env, which apply to every agent, instead of
+// the agent's own namespace.
+func runGlobalSetup(agentName string, declarations []EnvDef, flags StandardFlags) {
+	decls := globalEnvDecls(declarations)
+
+	if len(flags.SetupSet) > 0 || flags.SetupFromJSON != "" {
+		values, err := collectSetupValues(flags.SetupSet, flags.SetupFromJSON)
+		if err != nil {
+			exitWithError(err.Error(), ExitInvalidUsage)
+		}
+		if err := applyGlobalValues(agentName, declarations, values); err != nil {
+			exitWithError(err.Error(), ExitInvalidUsage)
+		}
+		fmt.Println("Configuration saved.")
+		os.Exit(ExitSuccess)
+	}
+
+	if flags.NonInteractive {
+		exitWithError("setup requires interactive mode (remove --non-interactive, or pass values with --set or --from-json)", ExitInvalidUsage)
+	}
+
+	config := loadConfig()
+	envMap := defaultsEnvNamespace(config)
+	settings := make(map[string]string)
+	env := make(map[string]string)
+
+	reader := bufio.NewReader(os.Stdin)
+
+	fmt.Println("Global setup (shared by all agents)")
+	fmt.Println()
+	for _, def := range globalSettings {
+		current := ""
+		if v, ok := lookupConfigPath(config, def.Name); ok {
+			current = fmt.Sprintf("%v", v)
+		}
+		if input := promptSetupValue(reader, def, current, false); input != "" {
+			settings[def.Name] = input
+		}
+	}
+
+	if len(decls) > 0 {
+		fmt.Printf("\nEnvironment defaults (used by any agent that declares them; %s overrides them):\n", agentName)
+	}
+	for _, decl := range decls {
+		current := ""
+		if v, ok := envMap[decl.Name]; ok {
+			current = fmt.Sprintf("%v", v)
+		}
+		if current == "" {
+			current = os.Getenv(decl.Name)
+		}
+		if input := promptSetupValue(reader, decl, current, decl.Secret); input != "" {
+			env[decl.Name] = input
+		}
+	}
+
+	if err := saveGlobalSetup(settings, env); err != nil {
+		exitWithError(err.Error(), ExitFailure)
+	}
+
+	fmt.Println("\nConfiguration saved.")
+	os.Exit(ExitSuccess)
+}
+
+// globalEnvDecls returns the declarations defaults.env may hold: all but
+// keyring-backed ones, which the keychain stores per agent.
+func globalEnvDecls(declarations []EnvDef) []EnvDef {
+	var decls []EnvDef
+	for _, decl := range declarations {
+		if decl.Source != envSourceKeyring {
+			decls = append(decls, decl)
+		}
+	}
+	return decls
+}
+
+// applyGlobalValues validates values, keyed by setting path or env var
+// name, and saves them to the shared settings and defaults.env. Nothing is
+// written if any value is unknown or malformed.
+func applyGlobalValues(agentName string, declarations []EnvDef, values map[string]string) error {
+	known := make(map[string]EnvDef)
+	for _, def := range globalSettings {
+		known[def.Name] = def
+	}
+	keyringBacked := make(map[string]bool)
+	for _, decl := range declarations {
+		if decl.Source == envSourceKeyring {
+			keyringBacked[decl.Name] = true
+			continue
+		}
+		known[decl.Name] = decl
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	settings := make(map[string]string)
+	env := make(map[string]string)
+	for _, name := range names {
+		def, ok := known[name]
+		switch {
+		case keyringBacked[name]:
+			problems = append(problems, fmt.Sprintf("%s is stored in the keychain per agent; set it without --global", name))
+			continue
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is not a shared setting or a variable declared by %s", name, agentName))
+			continue
+		}
+		val := values[name]
+		if val != "" && secretProviderFor(val) == nil {
+			if err := checkEnvValue(def, val); err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+		}
+		if strings.Contains(name, ".") {
+			settings[name] = val
+		} else {
+			env[name] = val
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("setup failed:\n  • %s", strings.Join(problems, "\n  • "))
+	}
+	return saveGlobalSetup(settings, env)
+}
+
+// saveGlobalSetup writes settings and env into the shared config. An empty
+// value removes the setting or variable. defaults.env is never encrypted,
+// even with secrets.recipient set, since agents of every SDK read it.
+func saveGlobalSetup(settings, env map[string]string) error {
+	types := make(map[string]string, len(globalSettings))
+	for _, def := range globalSettings {
+		types[def.Name] = def.Type
+	}
+	err := updateConfig(func(config map[string]any) error {
+		for path, val := range settings {
+			if val == "" {
+				deleteConfigPath(config, path)
+				continue
+			}
+			var v any = val
+			if types[path] == EnvTypeInt {
+				n, err := strconv.Atoi(strings.TrimSpace(val))
+				if err != nil {
+					return fmt.Errorf("%s must be an integer", path)
+				}
+				v = float64(n)
+			}
+			setConfigPath(config, path, v)
+		}
+		envMap := defaultsEnvNamespace(config)
+		for name, val := range env {
+			if val == "" {
+				delete(envMap, name)
+				continue
+			}
+			envMap[name] = val
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	return nil
+}
+
+// defaultsEnvNamespace returns config's defaults.env map, creating it.
+func defaultsEnvNamespace(config map[string]any) map[string]any {
+	if config["defaults"] == nil {
+		config["defaults"] = map[string]any{}
+	}
+	defaults := config["defaults"].(map[string]any)
+	if defaults["env"] == nil {
+		defaults["env"] = map[string]any{}
+	}
+	return defaults["env"].(map[string]any)
+}
+
+// lookupConfigPath returns the value at a dotted path such as
+// "logging.file".
+func lookupConfigPath(config map[string]any, path string) (any, bool) {
+	var v any = config
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// setConfigPath sets the value at a dotted path, creating or replacing
+// intermediate objects.
+func setConfigPath(config map[string]any, path string, val any) {
+	keys := strings.Split(path, ".")
+	m := config
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := m[key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			m[key] = next
+		}
+		m = next
+	}
+	m[keys[len(keys)-1]] = val
+}
+
+// deleteConfigPath removes the value at a dotted path, if present.
+func deleteConfigPath(config map[string]any, path string) {
+	keys := strings.Split(path, ".")
+	m := config
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := m[key].(map[string]any)
+		if !ok {
+			return
+		}
+		m = next
+	}
+	delete(m, keys[len(keys)-1])
+}
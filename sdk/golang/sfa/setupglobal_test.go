@@ -0,0 +1,113 @@
+package sfa
+
+import (
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyGlobalValues(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := writeTestFile(configPath, `{"defaults": {"verbose": true}, "logging": {"file": "/old.jsonl", "maxSize": 10}}`); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SFA_CONFIG", configPath)
+	useFakeKeyring(t)
+
+	decls := []EnvDef{
+		{Name: "API_URL", Type: EnvTypeURL},
+		{Name: "API_TOKEN", Secret: true, Source: envSourceKeyring},
+	}
+	values := map[string]string{
+		"defaults.timeout":  "300",
+		"contextStore.path": "/data/context",
+		"logging.file":      "",
+		"API_URL":           "https://api.example",
+	}
+	if err := applyGlobalValues("setup-agent", decls, values); err != nil {
+		t.Fatal(err)
+	}
+
+	config := loadConfig()
+	if v, _ := lookupConfigPath(config, "defaults.timeout"); v != float64(300) {
+		t.Errorf("defaults.timeout = %#v, want the number 300", v)
+	}
+	if v, _ := lookupConfigPath(config, "defaults.verbose"); v != true {
+		t.Errorf("defaults.verbose was not kept: %#v", v)
+	}
+	if v, _ := lookupConfigPath(config, "contextStore.path"); v != "/data/context" {
+		t.Errorf("contextStore.path = %#v", v)
+	}
+	if _, ok := lookupConfigPath(config, "logging.file"); ok {
+		t.Error("expected an empty value to remove logging.file")
+	}
+	if v, _ := lookupConfigPath(config, "logging.maxSize"); v != float64(10) {
+		t.Errorf("logging.maxSize was not kept: %#v", v)
+	}
+	if v, _ := lookupConfigPath(config, "defaults.env.API_URL"); v != "https://api.example" {
+		t.Errorf("defaults.env.API_URL = %#v", v)
+	}
+	if _, ok := lookupConfigPath(config, "agents"); ok {
+		t.Error("global setup wrote an agent namespace")
+	}
+
+	// Unknown, keyring-backed, and malformed values are all reported and
+	// nothing is saved
+	err := applyGlobalValues("setup-agent", decls, map[string]string{
+		"defaults.timeout": "0",
+		"API_TOKEN":        "tok",
+		"TYPO":             "x",
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"defaults.timeout must match", "API_TOKEN is stored in the keychain", "TYPO is not a shared setting"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+	if v, _ := lookupConfigPath(loadConfig(), "defaults.timeout"); v != float64(300) {
+		t.Errorf("config changed after failed setup: %#v", v)
+	}
+}
+
+func TestApplyGlobalValuesLeavesDefaultsPlain(t *testing.T) {
+	key := useConfigKey(t)
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	recipient := base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+	if err := writeTestFile(configPath, `{"secrets": {"recipient": "`+recipient+`"}}`); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SFA_CONFIG", configPath)
+	t.Setenv("API_KEY", "")
+
+	decls := []EnvDef{{Name: "API_KEY", Secret: true}}
+	if err := applyGlobalValues("agent", decls, map[string]string{"API_KEY": "sk-123"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := lookupConfigPath(loadConfig(), "defaults.env.API_KEY"); v != "sk-123" {
+		t.Fatalf("expected defaults.env to stay plaintext for every SDK, got %v", v)
+	}
+
+	resolved := resolveEnv(decls, "any-agent", loadConfig())
+	if resolved.Values["API_KEY"] != "sk-123" || resolved.Sources["API_KEY"] != envSourceDefaults {
+		t.Errorf("expected the defaults value, got %q from %q", resolved.Values["API_KEY"], resolved.Sources["API_KEY"])
+	}
+}
+
+func TestParseArgsSetupGlobal(t *testing.T) {
+	args, err := parseArgs([]string{"--setup", "--global", "--set", "defaults.timeout=60"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !args.Flags.SetupGlobal {
+		t.Error("expected SetupGlobal")
+	}
+	if _, err := parseArgs([]string{"--global"}, nil); err == nil {
+		t.Error("expected --global without --setup to fail")
+	}
+	if _, err := parseArgs([]string{"--setup", "--global", "--export", "-"}, nil); err == nil {
+		t.Error("expected --global with --export to fail")
+	}
+}
@@ -0,0 +1,227 @@
+package sfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Sources of run settings and config values shown by --show-config, beside
+// the envSource* constants.
+const (
+	sourceFlag     = "flag"
+	sourceConfig   = "config"
+	sourceBuiltin  = "built-in default"
+	sourceDeclared = "declaration default"
+)
+
+// shownConfig is the effective configuration printed by --show-config.
+type shownConfig struct {
+	Agent    string       `json:"agent"`
+	Version  string       `json:"version"`
+	Settings []shownValue `json:"settings"`
+	Config   []shownValue `json:"config"`
+	Env      []shownValue `json:"env"`
+}
+
+// shownValue is one value and where it came from; Source is "unset" for a
+// declared env var with no value.
+type shownValue struct {
+	Key    string `json:"key"`
+	Value  any    `json:"value,omitempty"`
+	Source string `json:"source"`
+}
+
+// buildShownConfig collects the run settings, the agent's merged config,
+// and its resolved env, each with its source. config is the layered config
+// and merged the agent's view of it. Secrets are masked.
+func buildShownConfig(def *AgentDef, args *ParsedArgs, config, merged map[string]any, resolved *ResolvedEnv) *shownConfig {
+	sc := &shownConfig{Agent: def.Name, Version: def.Version, Config: []shownValue{}, Env: []shownValue{}}
+	sc.Settings = shownSettings(args, config)
+
+	// Config: the agent namespace wins over defaults; anything else was
+	// filled in from the agent's config schema
+	defaults, _ := config["defaults"].(map[string]any)
+	agents, _ := config["agents"].(map[string]any)
+	ns, _ := agents[def.Name].(map[string]any)
+	for _, key := range sortedKeys(merged) {
+		v := shownValue{Key: key, Value: merged[key], Source: sourceDeclared}
+		if _, ok := ns[key]; ok {
+			v.Source = envSourceAgent
+		} else if _, ok := defaults[key]; ok {
+			v.Source = envSourceDefaults
+		}
+		if looksSecret(key) {
+			v.Value = "***"
+		}
+		sc.Config = append(sc.Config, v)
+	}
+
+	// Env: declared variables, then undeclared ones from .env files
+	secret := make(map[string]bool)
+	declared := make(map[string]bool)
+	for _, decl := range agentEnvDecls(def) {
+		declared[decl.Name] = true
+		secret[decl.Name] = decl.Secret
+		val, ok := resolved.Values[decl.Name]
+		if !ok {
+			// Service overrides are only interesting when set
+			if !strings.HasPrefix(decl.Name, "SFA_SVC_") {
+				sc.Env = append(sc.Env, shownValue{Key: decl.Name, Source: "unset"})
+			}
+			continue
+		}
+		sc.Env = append(sc.Env, shownValue{Key: decl.Name, Value: val, Source: resolved.Sources[decl.Name]})
+	}
+	var extra []string
+	for name := range resolved.Values {
+		if !declared[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		sc.Env = append(sc.Env, shownValue{Key: name, Value: resolved.Values[name], Source: resolved.Sources[name]})
+	}
+	for i, v := range sc.Env {
+		if v.Value != nil && (secret[v.Key] || resolved.Secrets[v.Key]) {
+			sc.Env[i].Value = "***"
+		}
+	}
+	return sc
+}
+
+// shownSettings reports the standard settings that flags, SFA_* variables,
+// and the shared config decide.
+func shownSettings(args *ParsedArgs, config map[string]any) []shownValue {
+	flags := args.Flags
+	flagOr := func(name string) string {
+		if args.Given[name] {
+			return sourceFlag
+		}
+		return sourceBuiltin
+	}
+	fromEnv := func(name string) string {
+		return fmt.Sprintf("%s (%s)", envSourceProcess, name)
+	}
+
+	settings := []shownValue{
+		{Key: "timeout", Value: flags.Timeout, Source: flagOr("timeout")},
+		{Key: "outputFormat", Value: string(flags.OutputFormat), Source: flagOr("output-format")},
+	}
+
+	maxDepth := shownValue{Key: "maxDepth", Value: flags.MaxDepth, Source: flagOr("max-depth")}
+	if env := os.Getenv("SFA_MAX_DEPTH"); env != "" {
+		maxDepth.Value = parseInt(env, flags.MaxDepth)
+		maxDepth.Source = fromEnv("SFA_MAX_DEPTH")
+	}
+	settings = append(settings, maxDepth)
+
+	// --profile is exported as SFA_PROFILE before config loads
+	if name := activeProfile(); name != "" {
+		source := fromEnv("SFA_PROFILE")
+		if args.Given["profile"] {
+			source = sourceFlag
+		}
+		settings = append(settings, shownValue{Key: "profile", Value: name, Source: source})
+	}
+
+	logFile := shownValue{Key: "logging.file", Source: sourceBuiltin}
+	lc := resolveLoggingConfig(config, flags.NoLog)
+	switch {
+	case flags.NoLog:
+		logFile.Value, logFile.Source = "suppressed", sourceFlag
+	case os.Getenv("SFA_NO_LOG") == "1":
+		logFile.Value, logFile.Source = "suppressed", fromEnv("SFA_NO_LOG")
+	case os.Getenv("SFA_LOG_FILE") != "":
+		logFile.Value, logFile.Source = lc.FilePath, fromEnv("SFA_LOG_FILE")
+	default:
+		logFile.Value = lc.FilePath
+		if _, ok := lookupConfigPath(config, "logging.file"); ok {
+			logFile.Source = sourceConfig
+		}
+		if lc.Suppressed {
+			logFile.Value = "suppressed"
+		}
+	}
+	settings = append(settings, logFile)
+
+	store := shownValue{Key: "contextStore.path", Value: resolveContextStorePath(config), Source: sourceBuiltin}
+	if os.Getenv("SFA_CONTEXT_STORE") != "" {
+		store.Source = fromEnv("SFA_CONTEXT_STORE")
+	} else if _, ok := lookupConfigPath(config, "contextStore.path"); ok {
+		store.Source = sourceConfig
+	}
+	settings = append(settings, store)
+
+	if raw := resolveContextStoreURL(config); raw != "" {
+		remote := shownValue{Key: "contextStore.url", Value: raw, Source: sourceConfig}
+		if u, err := url.Parse(raw); err == nil {
+			remote.Value = u.Redacted()
+		}
+		if os.Getenv("SFA_CONTEXT_STORE_URL") != "" {
+			remote.Source = fromEnv("SFA_CONTEXT_STORE_URL")
+		}
+		settings = append(settings, remote)
+	}
+
+	engine := shownValue{Key: "services.engine", Value: "auto", Source: sourceBuiltin}
+	if name, source := containerEngineSetting(config); name != "" {
+		engine.Value, engine.Source = name, sourceConfig
+		if source == "SFA_CONTAINER_ENGINE" {
+			engine.Source = fromEnv(source)
+		}
+	}
+	settings = append(settings, engine)
+	return settings
+}
+
+// render formats the configuration as text, or indented JSON for
+// --output-format json.
+func (sc *shownConfig) render(format OutputFormat) string {
+	if format == OutputJSON {
+		data, _ := json.MarshalIndent(sc, "", "  ")
+		return string(data) + "\n"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Configuration for %s %s\n", sc.Agent, sc.Version)
+	sections := []struct {
+		title  string
+		values []shownValue
+		empty  string
+	}{
+		{"Settings", sc.Settings, ""},
+		{"Config", sc.Config, "(none)"},
+		{"Environment", sc.Env, "(none declared)"},
+	}
+	for _, section := range sections {
+		fmt.Fprintf(&b, "\n%s:\n", section.title)
+		if len(section.values) == 0 {
+			fmt.Fprintf(&b, "  %s\n", section.empty)
+		}
+		for _, v := range section.values {
+			if v.Value == nil {
+				fmt.Fprintf(&b, "  %s: %s\n", v.Key, v.Source)
+				continue
+			}
+			fmt.Fprintf(&b, "  %s = %s (%s)\n", v.Key, formatShownValue(v.Value), v.Source)
+		}
+	}
+	return b.String()
+}
+
+// formatShownValue prints strings as they are and other values as JSON.
+func formatShownValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
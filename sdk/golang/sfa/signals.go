@@ -0,0 +1,210 @@
+package sfa
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Grace periods between receiving a termination signal and forcing exit.
+// The main path normally finishes first once the context is cancelled.
+const (
+	sigintGrace  = 2 * time.Second
+	sigtermGrace = 5 * time.Second
+)
+
+// signalHandler routes process signals for one agent run:
+//   - SIGINT/SIGTERM cancel the context, then run cleanups and exit 130/143
+//     if the main path hasn't exited within the grace period
+//   - SIGHUP re-reads config and env and passes them to agent reload hooks
+//     (config reload / credential rotation)
+//   - SIGUSR1 dumps a status report to stderr
+type signalHandler struct {
+	agentName string
+	cancel    context.CancelFunc
+	sigCh     chan os.Signal
+	done      chan struct{}
+	exit      func(code int) // os.Exit; replaced in tests
+
+	mu          sync.Mutex
+	received    os.Signal
+	cleanups    []func(exitCode int)
+	reloader    func() (config map[string]any, env map[string]string)
+	reloadHooks []func(config map[string]any, env map[string]string)
+	status      func() []string
+	statusHooks []func() string
+
+	cleanupOnce sync.Once
+}
+
+// setupSignalHandlers installs the agent's signal handlers.
+// Call stop to remove them.
+func setupSignalHandlers(agentName string, cancel context.CancelFunc) *signalHandler {
+	h := &signalHandler{
+		agentName: agentName,
+		cancel:    cancel,
+		sigCh:     make(chan os.Signal, 4),
+		done:      make(chan struct{}),
+		exit:      os.Exit,
+	}
+	signal.Notify(h.sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
+	go h.loop()
+	return h
+}
+
+// loop dispatches signals until stop is called.
+func (h *signalHandler) loop() {
+	for {
+		select {
+		case <-h.done:
+			return
+		case sig := <-h.sigCh:
+			h.handle(sig)
+		}
+	}
+}
+
+// handle reacts to a single signal.
+func (h *signalHandler) handle(sig os.Signal) {
+	switch sig {
+	case syscall.SIGHUP:
+		h.mu.Lock()
+		reloader := h.reloader
+		hooks := append([]func(map[string]any, map[string]string){}, h.reloadHooks...)
+		h.mu.Unlock()
+		if reloader == nil {
+			return
+		}
+		config, env := reloader()
+		for _, fn := range hooks {
+			fn(config, env)
+		}
+		emitProgress(h.agentName, "configuration reloaded (SIGHUP)")
+
+	case syscall.SIGUSR1:
+		h.mu.Lock()
+		status := h.status
+		hooks := append([]func() string{}, h.statusHooks...)
+		h.mu.Unlock()
+		var lines []string
+		if status != nil {
+			lines = status()
+		}
+		for _, fn := range hooks {
+			if line := fn(); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		for _, line := range lines {
+			emitProgress(h.agentName, "status: "+line)
+		}
+
+	case syscall.SIGINT, syscall.SIGTERM:
+		h.mu.Lock()
+		first := h.received == nil
+		if first {
+			h.received = sig
+		}
+		h.mu.Unlock()
+		if !first {
+			return
+		}
+
+		h.cancel()
+		code, grace := ExitSIGINT, sigintGrace
+		if sig == syscall.SIGINT {
+			emitProgress(h.agentName, "interrupted (SIGINT)")
+		} else {
+			code, grace = ExitSIGTERM, sigtermGrace
+			emitProgress(h.agentName, "terminated (SIGTERM)")
+		}
+
+		// Forced exit runs in the background so SIGHUP/SIGUSR1 keep working
+		go func() {
+			select {
+			case <-h.done:
+				return
+			case <-time.After(grace):
+			}
+			h.runCleanups(code)
+			h.exit(code)
+		}()
+	}
+}
+
+// onCleanup registers fn to run once before the process exits, whether via
+// the normal path or a termination signal. Cleanups run in reverse order.
+func (h *signalHandler) onCleanup(fn func(exitCode int)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cleanups = append(h.cleanups, fn)
+}
+
+// setReloader sets how SIGHUP re-reads config and environment.
+func (h *signalHandler) setReloader(fn func() (map[string]any, map[string]string)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reloader = fn
+}
+
+// addReloadHook registers an agent handler called with the reloaded config
+// and environment on SIGHUP.
+func (h *signalHandler) addReloadHook(fn func(config map[string]any, env map[string]string)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reloadHooks = append(h.reloadHooks, fn)
+}
+
+// setStatus sets the SDK's built-in SIGUSR1 status lines.
+func (h *signalHandler) setStatus(fn func() []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status = fn
+}
+
+// addStatusHook registers an agent function whose line is appended to the
+// SIGUSR1 status report.
+func (h *signalHandler) addStatusHook(fn func() string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.statusHooks = append(h.statusHooks, fn)
+}
+
+// runCleanups runs registered cleanups exactly once. Concurrent callers
+// block until the first run completes.
+func (h *signalHandler) runCleanups(exitCode int) {
+	h.cleanupOnce.Do(func() {
+		h.mu.Lock()
+		cleanups := h.cleanups
+		h.mu.Unlock()
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i](exitCode)
+		}
+	})
+}
+
+// exitCode returns the exit code implied by a received termination signal.
+func (h *signalHandler) exitCode() (int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch h.received {
+	case syscall.SIGINT:
+		return ExitSIGINT, true
+	case syscall.SIGTERM:
+		return ExitSIGTERM, true
+	}
+	return 0, false
+}
+
+// stop removes the signal handlers.
+func (h *signalHandler) stop() {
+	signal.Stop(h.sigCh)
+	select {
+	case <-h.done:
+	default:
+		close(h.done)
+	}
+}
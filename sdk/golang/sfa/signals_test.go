@@ -0,0 +1,96 @@
+package sfa
+
+import (
+	"context"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalCleanupsRunOnceInReverse(t *testing.T) {
+	h := setupSignalHandlers("test-agent", func() {})
+	defer h.stop()
+
+	var order []string
+	h.onCleanup(func(code int) { order = append(order, "first") })
+	h.onCleanup(func(code int) { order = append(order, "second") })
+
+	h.runCleanups(ExitSuccess)
+	h.runCleanups(ExitFailure)
+
+	if strings.Join(order, ",") != "second,first" {
+		t.Errorf("expected cleanups once in reverse order, got %v", order)
+	}
+}
+
+func TestSignalTerminationRunsCleanupsBeforeExit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := setupSignalHandlers("test-agent", cancel)
+	defer h.stop()
+
+	exited := make(chan int, 1)
+	var cleanedWith int
+	h.exit = func(code int) { exited <- code }
+	h.onCleanup(func(code int) { cleanedWith = code })
+
+	captureStderr(t, func() { h.handle(syscall.SIGINT) })
+
+	if ctx.Err() == nil {
+		t.Error("expected SIGINT to cancel the context")
+	}
+	if code, ok := h.exitCode(); !ok || code != ExitSIGINT {
+		t.Errorf("expected exit code %d, got %d (%v)", ExitSIGINT, code, ok)
+	}
+
+	select {
+	case code := <-exited:
+		if code != ExitSIGINT {
+			t.Errorf("expected forced exit %d, got %d", ExitSIGINT, code)
+		}
+		if cleanedWith != ExitSIGINT {
+			t.Errorf("expected cleanups to run with %d before exit, got %d", ExitSIGINT, cleanedWith)
+		}
+	case <-time.After(sigintGrace + time.Second):
+		t.Fatal("expected forced exit after the grace period")
+	}
+}
+
+func TestSignalReloadCallsHooks(t *testing.T) {
+	h := setupSignalHandlers("test-agent", func() {})
+	defer h.stop()
+
+	h.setReloader(func() (map[string]any, map[string]string) {
+		return map[string]any{"model": "new"}, map[string]string{"API_KEY": "rotated"}
+	})
+	var gotKey string
+	h.addReloadHook(func(config map[string]any, env map[string]string) {
+		gotKey = env["API_KEY"]
+	})
+
+	out := captureStderr(t, func() { h.handle(syscall.SIGHUP) })
+	if gotKey != "rotated" {
+		t.Errorf("expected reload hook to receive rotated env, got %q", gotKey)
+	}
+	if !strings.Contains(out, "configuration reloaded") {
+		t.Errorf("expected reload progress line, got %q", out)
+	}
+}
+
+func TestSignalStatusDump(t *testing.T) {
+	h := setupSignalHandlers("test-agent", func() {})
+	defer h.stop()
+
+	h.setStatus(func() []string { return []string{"elapsed 3s"} })
+	h.addStatusHook(func() string { return "processed 12/40 files" })
+
+	out := captureStderr(t, func() { h.handle(syscall.SIGUSR1) })
+	for _, want := range []string{
+		"[agent:test-agent] status: elapsed 3s",
+		"[agent:test-agent] status: processed 12/40 files",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in status dump, got %q", want, out)
+		}
+	}
+}
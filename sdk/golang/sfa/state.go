@@ -0,0 +1,74 @@
+package sfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceStateFile, beside compose.yaml, records what the SDK last brought
+// up, so sfa services list can compare it with what the engine runs.
+const serviceStateFile = "state.json"
+
+// servicesState is the content of state.json.
+type servicesState struct {
+	Agent       string                        `json:"agent"`
+	Host        string                        `json:"host"`
+	ComposeHash string                        `json:"composeHash"`
+	StartedAt   string                        `json:"startedAt"` // RFC 3339
+	Services    map[string]serviceStateRecord `json:"services"`
+}
+
+// serviceStateRecord is one service in state.json.
+type serviceStateRecord struct {
+	Container string   `json:"container"`       // container ID, "" if compose did not report one
+	Name      string   `json:"name,omitempty"`  // container name
+	Ports     []string `json:"ports,omitempty"` // published mappings, as in the compose file
+}
+
+// parseServiceContainers reads "service\tid\tname" lines from compose ps.
+func parseServiceContainers(out string) map[string][2]string {
+	containers := make(map[string][2]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) == 3 && fields[0] != "" {
+			containers[fields[0]] = [2]string{fields[1], fields[2]}
+		}
+	}
+	return containers
+}
+
+// newServiceState builds the state of services just brought up, from the
+// containers compose reports for them.
+func newServiceState(agentName, host, composeHash string, services map[string]ServiceDef, containers map[string][2]string, now time.Time) servicesState {
+	state := servicesState{
+		Agent:       agentName,
+		Host:        host,
+		ComposeHash: composeHash,
+		StartedAt:   now.UTC().Format(time.RFC3339),
+		Services:    make(map[string]serviceStateRecord, len(services)),
+	}
+	for name, svc := range services {
+		c := containers[name]
+		state.Services[name] = serviceStateRecord{Container: c[0], Name: c[1], Ports: svc.Ports}
+	}
+	return state
+}
+
+// saveServiceState writes state.json for the services compose just
+// brought up. Failing to is only warned about.
+func saveServiceState(engine containerEngine, agentName, host, composePath string, services map[string]ServiceDef) {
+	out, err := engine.composeCommand(composePath, "ps", "-a", "--format", "{{.Service}}\t{{.ID}}\t{{.Name}}").Output()
+	if err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to record service state: %v", err))
+		return
+	}
+	hashes, _ := readComposeHashes(composeHashPath(composePath))
+	state := newServiceState(agentName, host, hashes.File, services, parseServiceContainers(string(out)), time.Now())
+	data, _ := json.MarshalIndent(state, "", "  ")
+	if err := writeFileAtomic(filepath.Join(filepath.Dir(composePath), serviceStateFile), append(data, '\n'), 0600); err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to record service state: %v", err))
+	}
+}
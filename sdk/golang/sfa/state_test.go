@@ -0,0 +1,33 @@
+package sfa
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNewServiceState(t *testing.T) {
+	containers := parseServiceContainers("db\t3f2a9c\tsfa-reporter-db-1\nbroken line\ncache\t77b1e0\tsfa-reporter-cache-1\n")
+	if len(containers) != 2 || containers["db"] != [2]string{"3f2a9c", "sfa-reporter-db-1"} {
+		t.Fatalf("containers = %v", containers)
+	}
+
+	services := map[string]ServiceDef{
+		"db":     {Image: "postgres:16", Ports: []string{"54321:5432"}},
+		"cache":  {Image: "redis:7"},
+		"search": {Image: "opensearch:2"},
+	}
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	state := newServiceState("reporter", "localhost", "abc123", services, containers, now)
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"agent":"reporter","host":"localhost","composeHash":"abc123","startedAt":"2026-05-01T12:00:00Z","services":{` +
+		`"cache":{"container":"77b1e0","name":"sfa-reporter-cache-1"},` +
+		`"db":{"container":"3f2a9c","name":"sfa-reporter-db-1","ports":["54321:5432"]},` +
+		`"search":{"container":""}}}`
+	if string(data) != want {
+		t.Errorf("state.json = %s\nwant %s", data, want)
+	}
+}
@@ -0,0 +1,126 @@
+package sfa
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// stderrLog carries everything the SDK writes to stderr: progress, notes,
+// warnings, and errors. Progress and other records about an agent carry
+// its name as the "agent" attribute. Its level is Info, Debug with
+// --verbose, Warn with --quiet, or SFA_LOG_LEVEL's without either flag;
+// see configureStderrLog.
+var (
+	stderrLevel = new(slog.LevelVar)
+	stderrLog   = slog.New(&stderrTextHandler{level: stderrLevel})
+)
+
+// stderrWriter writes to os.Stderr as it is at the time of the write, so
+// that records follow a redirected os.Stderr.
+type stderrWriter struct{}
+
+func (stderrWriter) Write(p []byte) (int, error) {
+	return os.Stderr.Write(p)
+}
+
+// configureStderrLog sets the level of stderrLog from the flags and
+// SFA_LOG_LEVEL (debug, info, warn, or error), and its format from
+// SFA_LOG_FORMAT: text, the default, or json for one JSON object a line.
+func configureStderrLog(flags StandardFlags) {
+	var warnings []string
+	level := slog.LevelInfo
+	if v := os.Getenv("SFA_LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			warnings = append(warnings, fmt.Sprintf("ignoring invalid SFA_LOG_LEVEL %q (use debug, info, warn, or error)", v))
+		}
+	}
+	switch {
+	case flags.Verbose:
+		level = slog.LevelDebug
+	case flags.Quiet:
+		level = slog.LevelWarn
+	}
+	stderrLevel.Set(level)
+
+	switch format := os.Getenv("SFA_LOG_FORMAT"); format {
+	case "", "text":
+		stderrLog = slog.New(&stderrTextHandler{level: stderrLevel})
+	case "json":
+		stderrLog = slog.New(slog.NewJSONHandler(stderrWriter{}, &slog.HandlerOptions{Level: stderrLevel}))
+	default:
+		warnings = append(warnings, fmt.Sprintf("ignoring invalid SFA_LOG_FORMAT %q (use text or json)", format))
+	}
+	for _, w := range warnings {
+		stderrLog.Warn(w)
+	}
+}
+
+// debugLogging reports whether stderrLog writes Debug records, so that
+// callers can skip building them.
+func debugLogging() bool {
+	return stderrLog.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// stderrTextHandler writes records as lines of text in the SDK's stderr
+// format: "error: " and "warning: " before errors and warnings,
+// "[agent:<name>] " before other records of an agent, and any further
+// attributes as key=value after the message.
+type stderrTextHandler struct {
+	level slog.Leveler
+	attrs []slog.Attr
+}
+
+func (h *stderrTextHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *stderrTextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &stderrTextHandler{level: h.level, attrs: append(slices.Clip(h.attrs), attrs...)}
+}
+
+// WithGroup returns h: attributes are written by their own keys.
+func (h *stderrTextHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func (h *stderrTextHandler) Handle(_ context.Context, r slog.Record) error {
+	var agent string
+	var extra strings.Builder
+	add := func(a slog.Attr) bool {
+		switch v := a.Value.Resolve().String(); {
+		case a.Key == "agent":
+			agent = v
+		case a.Key != "":
+			if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+				v = strconv.Quote(v)
+			}
+			extra.WriteString(" " + a.Key + "=" + v)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("warning: ")
+	case agent != "":
+		b.WriteString("[agent:" + agent + "] ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(extra.String())
+	b.WriteByte('\n')
+	_, err := io.WriteString(stderrWriter{}, b.String())
+	return err
+}
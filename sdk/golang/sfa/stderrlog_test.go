@@ -0,0 +1,77 @@
+package sfa
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestStderrLogText(t *testing.T) {
+	t.Cleanup(func() { configureStderrLog(StandardFlags{}) })
+	t.Setenv("SFA_LOG_LEVEL", "")
+	t.Setenv("SFA_LOG_FORMAT", "")
+	configureStderrLog(StandardFlags{})
+
+	out := captureStderr(t, func() {
+		emitProgress("reviewer", "Reading files")
+		stderrLog.Debug("not shown")
+		stderrLog.Warn("disk almost full", "free", "2 GB")
+		stderrLog.Error("boom")
+	})
+	want := "[agent:reviewer] Reading files\nwarning: disk almost full free=\"2 GB\"\nerror: boom\n"
+	if out != want {
+		t.Errorf("stderr =\n%q\nwant\n%q", out, want)
+	}
+}
+
+func TestStderrLogLevels(t *testing.T) {
+	t.Cleanup(func() { configureStderrLog(StandardFlags{}) })
+	for _, tt := range []struct {
+		env   string
+		flags StandardFlags
+		want  slog.Level
+	}{
+		{"", StandardFlags{}, slog.LevelInfo},
+		{"error", StandardFlags{}, slog.LevelError},
+		{"DEBUG", StandardFlags{}, slog.LevelDebug},
+		{"error", StandardFlags{Verbose: true}, slog.LevelDebug},
+		{"debug", StandardFlags{Quiet: true}, slog.LevelWarn},
+		{"debug", StandardFlags{Verbose: true, Quiet: true}, slog.LevelDebug},
+	} {
+		t.Setenv("SFA_LOG_LEVEL", tt.env)
+		configureStderrLog(tt.flags)
+		if got := stderrLevel.Level(); got != tt.want {
+			t.Errorf("SFA_LOG_LEVEL=%q %+v: level %v, want %v", tt.env, tt.flags, got, tt.want)
+		}
+	}
+
+	t.Setenv("SFA_LOG_LEVEL", "loud")
+	out := captureStderr(t, func() { configureStderrLog(StandardFlags{}) })
+	if !strings.Contains(out, `warning: ignoring invalid SFA_LOG_LEVEL "loud"`) || stderrLevel.Level() != slog.LevelInfo {
+		t.Errorf("invalid level: %q, level %v", out, stderrLevel.Level())
+	}
+}
+
+func TestStderrLogJSON(t *testing.T) {
+	t.Cleanup(func() { configureStderrLog(StandardFlags{}) })
+	t.Setenv("SFA_LOG_LEVEL", "")
+	t.Setenv("SFA_LOG_FORMAT", "json")
+	configureStderrLog(StandardFlags{Quiet: true})
+
+	out := captureStderr(t, func() {
+		emitProgress("reviewer", "Reading files")
+		stderrLog.Warn("disk almost full", "agent", "reviewer")
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the warning with --quiet, got %q", out)
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["level"] != "WARN" || record["msg"] != "disk almost full" || record["agent"] != "reviewer" {
+		t.Errorf("record = %v", record)
+	}
+}
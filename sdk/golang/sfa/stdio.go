@@ -0,0 +1,280 @@
+package sfa
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// progressParams is the payload of a "progress" notification.
+type progressParams struct {
+	ID      json.RawMessage `json:"id"`
+	Message string          `json:"message"`
+}
+
+// cancelParams names the execute request to cancel.
+type cancelParams struct {
+	ID json.RawMessage `json:"id"`
+}
+
+// rpcConn reads and writes Content-Length framed messages, as in LSP.
+type rpcConn struct {
+	r  *bufio.Reader
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newRPCConn(r io.Reader, w io.Writer) *rpcConn {
+	return &rpcConn{r: bufio.NewReader(r), w: w}
+}
+
+// read returns the next message body. io.EOF means the peer closed the
+// stream between messages.
+func (c *rpcConn) read() ([]byte, error) {
+	length := -1
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length < 0 {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("reading frame header: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed frame header %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || n < 0 || n > maxRequestBody {
+				return nil, fmt.Errorf("invalid Content-Length %q", strings.TrimSpace(value))
+			}
+			length = n
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("frame is missing Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, fmt.Errorf("reading frame body: %w", err)
+	}
+	return body, nil
+}
+
+// write frames and sends one message; safe for concurrent use.
+func (c *rpcConn) write(msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(body)
+	return err
+}
+
+func (c *rpcConn) reply(id json.RawMessage, result any) {
+	if id != nil {
+		c.write(rpcResponse{JSONRPC: "2.0", ID: id, Result: result})
+	}
+}
+
+func (c *rpcConn) fail(id json.RawMessage, code int, message string) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	c.write(rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}})
+}
+
+// serveStdio handles JSON-RPC requests from in until it reaches EOF or ctx
+// is cancelled. Executions run concurrently; on EOF they are allowed to
+// finish, on cancellation they get serveShutdownGrace before being cancelled.
+func (s *agentServer) serveStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	conn := newRPCConn(in, out)
+	base, cancelAll := context.WithCancel(context.Background())
+	defer cancelAll()
+
+	var mu sync.Mutex
+	inflight := make(map[string]context.CancelFunc)
+	var wg sync.WaitGroup
+
+	type frame struct {
+		body []byte
+		err  error
+	}
+	frames := make(chan frame)
+	go func() {
+		for {
+			body, err := conn.read()
+			select {
+			case frames <- frame{body, err}:
+			case <-base.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	emitProgress(s.runner.def.Name, "serving JSON-RPC on stdio")
+	for {
+		var f frame
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.draining = true
+			s.mu.Unlock()
+			waitTimeout(&wg, serveShutdownGrace)
+			cancelAll()
+			wg.Wait()
+			return nil
+		case f = <-frames:
+		}
+		if f.err == io.EOF {
+			wg.Wait()
+			return nil
+		}
+		if f.err != nil {
+			cancelAll()
+			wg.Wait()
+			return f.err
+		}
+
+		var req rpcRequest
+		if err := json.Unmarshal(f.body, &req); err != nil {
+			conn.fail(nil, rpcParseError, fmt.Sprintf("invalid JSON: %v", err))
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			conn.fail(req.ID, rpcInvalidRequest, "expected a JSON-RPC 2.0 request")
+			continue
+		}
+
+		switch req.Method {
+		case "describe":
+			s.mu.RLock()
+			resolved := s.resolved
+			s.mu.RUnlock()
+			conn.reply(req.ID, generateDescribe(s.runner.def, resolved.Values, resolved.Secrets))
+
+		case "execute":
+			var params executeRequest
+			if len(req.Params) > 0 {
+				if err := json.Unmarshal(req.Params, &params); err != nil {
+					conn.fail(req.ID, rpcInvalidParams, fmt.Sprintf("invalid params: %v", err))
+					continue
+				}
+			}
+			key := string(req.ID)
+			execCtx, cancel := context.WithCancel(base)
+			if req.ID != nil {
+				mu.Lock()
+				_, dup := inflight[key]
+				if !dup {
+					inflight[key] = cancel
+				}
+				mu.Unlock()
+				if dup {
+					cancel()
+					conn.fail(req.ID, rpcInvalidRequest, fmt.Sprintf("request %s is already running", key))
+					continue
+				}
+			}
+			wg.Add(1)
+			go func(id json.RawMessage) {
+				defer wg.Done()
+				defer cancel()
+				resp := s.execute(execCtx, params, func(event executionEvent) {
+					if id != nil && event.Type == "progress" {
+						conn.write(rpcNotification{JSONRPC: "2.0", Method: "progress", Params: progressParams{ID: id, Message: event.Message}})
+					}
+				})
+				if id != nil {
+					mu.Lock()
+					delete(inflight, key)
+					mu.Unlock()
+				}
+				conn.reply(id, resp)
+			}(req.ID)
+
+		case "cancel":
+			var params cancelParams
+			if err := json.Unmarshal(req.Params, &params); err != nil || params.ID == nil {
+				conn.fail(req.ID, rpcInvalidParams, "cancel requires the id of an execute request")
+				continue
+			}
+			mu.Lock()
+			cancel, ok := inflight[string(params.ID)]
+			mu.Unlock()
+			if ok {
+				cancel()
+			}
+			conn.reply(req.ID, map[string]bool{"cancelled": ok})
+
+		default:
+			conn.fail(req.ID, rpcMethodNotFound, fmt.Sprintf("unknown method %q", req.Method))
+		}
+	}
+}
+
+// waitTimeout waits for wg, giving up after d.
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+	}
+}
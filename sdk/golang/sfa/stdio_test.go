@@ -0,0 +1,141 @@
+package sfa
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stdioSession runs serveStdio over pipes and returns a client connection.
+func stdioSession(t *testing.T, s *agentServer) (client *rpcConn, closeInput func(), done <-chan error) {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.serveStdio(context.Background(), inR, outW)
+		outW.Close()
+	}()
+	return newRPCConn(outR, inW), func() { inW.Close() }, errCh
+}
+
+func readMessage(t *testing.T, c *rpcConn) map[string]any {
+	t.Helper()
+	body, err := c.read()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var msg map[string]any
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatalf("invalid message %s: %v", body, err)
+	}
+	return msg
+}
+
+func TestRPCConnFraming(t *testing.T) {
+	c := newRPCConn(strings.NewReader("Content-Length: 2\r\nContent-Type: application/json\r\n\r\n{}content-length: 4\r\n\r\nnull"), nil)
+	for _, want := range []string{"{}", "null"} {
+		body, err := c.read()
+		if err != nil || string(body) != want {
+			t.Fatalf("expected %q, got %q (%v)", want, body, err)
+		}
+	}
+	if _, err := c.read(); err != io.EOF {
+		t.Errorf("expected EOF between frames, got %v", err)
+	}
+
+	c = newRPCConn(strings.NewReader("X-Other: 1\r\n\r\n{}"), nil)
+	if _, err := c.read(); err == nil || err == io.EOF {
+		t.Errorf("expected an error for a frame without Content-Length, got %v", err)
+	}
+}
+
+func TestServeStdio(t *testing.T) {
+	s := newTestServer(t, &AgentDef{
+		Name:    "rpc-agent",
+		Version: "1.2.3",
+		Execute: func(ctx *ExecuteContext) (any, error) {
+			ctx.Progress("working")
+			return strings.ToUpper(ctx.Input), nil
+		},
+	})
+	captureStderr(t, func() {
+		client, closeInput, done := stdioSession(t, s)
+		client.write(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "describe"})
+		msg := readMessage(t, client)
+		if result, _ := msg["result"].(map[string]any); result["name"] != "rpc-agent" {
+			t.Errorf("unexpected describe response: %v", msg)
+		}
+
+		client.write(map[string]any{"jsonrpc": "2.0", "id": "a", "method": "execute", "params": map[string]any{"input": "hi"}})
+		msg = readMessage(t, client)
+		if msg["method"] != "progress" || msg["params"].(map[string]any)["message"] != "working" {
+			t.Errorf("expected a progress notification, got %v", msg)
+		}
+		msg = readMessage(t, client)
+		result, _ := msg["result"].(map[string]any)
+		if msg["id"] != "a" || result["result"] != "HI" || result["exitCode"] != float64(0) {
+			t.Errorf("unexpected execute response: %v", msg)
+		}
+
+		client.write(map[string]any{"jsonrpc": "2.0", "id": 2, "method": "bogus"})
+		msg = readMessage(t, client)
+		if e, _ := msg["error"].(map[string]any); e["code"] != float64(rpcMethodNotFound) {
+			t.Errorf("expected method not found, got %v", msg)
+		}
+
+		client.write(json.RawMessage(`{"jsonrpc":"2.0","id":3,"method":"execute","params":"nope"}`))
+		msg = readMessage(t, client)
+		if e, _ := msg["error"].(map[string]any); e["code"] != float64(rpcInvalidParams) {
+			t.Errorf("expected invalid params, got %v", msg)
+		}
+
+		closeInput()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("expected clean exit on EOF, got %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("serveStdio did not return on EOF")
+		}
+	})
+}
+
+func TestServeStdioCancel(t *testing.T) {
+	started := make(chan struct{})
+	s := newTestServer(t, &AgentDef{
+		Name: "slow-agent",
+		Execute: func(ctx *ExecuteContext) (any, error) {
+			close(started)
+			<-ctx.Ctx.Done()
+			return nil, ctx.Ctx.Err()
+		},
+	})
+	captureStderr(t, func() {
+		client, closeInput, done := stdioSession(t, s)
+		client.write(map[string]any{"jsonrpc": "2.0", "id": 7, "method": "execute", "params": map[string]any{"timeout": 30}})
+		<-started
+		client.write(map[string]any{"jsonrpc": "2.0", "id": 8, "method": "cancel", "params": map[string]any{"id": 7}})
+
+		seen := map[float64]map[string]any{}
+		for len(seen) < 2 {
+			msg := readMessage(t, client)
+			if id, ok := msg["id"].(float64); ok {
+				seen[id] = msg
+			}
+		}
+		if r, _ := seen[8]["result"].(map[string]any); r["cancelled"] != true {
+			t.Errorf("expected cancel to find the request, got %v", seen[8])
+		}
+		r, _ := seen[7]["result"].(map[string]any)
+		if e, _ := r["error"].(map[string]any); e["code"] != ErrCodeInterrupted {
+			t.Errorf("expected an interrupted result, got %v", seen[7])
+		}
+		closeInput()
+		<-done
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package sfa
+
+import (
+	"errors"
+	"os"
+)
+
+// disableEcho is unsupported here; secrets are read with echo on.
+func disableEcho(f *os.File) (func(), error) {
+	return nil, errors.New("hiding terminal input is not supported on this platform")
+}
@@ -0,0 +1,33 @@
+//go:build linux || darwin
+
+package sfa
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// disableEcho turns off echo on the terminal f, keeping the newline echo so
+// the cursor still moves on, and returns a function restoring the previous
+// mode. It fails if f is not a terminal.
+func disableEcho(f *os.File) (func(), error) {
+	var old syscall.Termios
+	if err := termios(f.Fd(), ioctlGetTermios, &old); err != nil {
+		return nil, err
+	}
+	t := old
+	t.Lflag &^= syscall.ECHO
+	t.Lflag |= syscall.ECHONL
+	if err := termios(f.Fd(), ioctlSetTermios, &t); err != nil {
+		return nil, err
+	}
+	return func() { termios(f.Fd(), ioctlSetTermios, &old) }, nil
+}
+
+func termios(fd uintptr, req uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
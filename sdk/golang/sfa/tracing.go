@@ -0,0 +1,292 @@
+package sfa
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracer records spans for one agent execution and exports them via OTLP/HTTP
+// (JSON encoding) when SFA_OTEL_ENDPOINT is set. Trace context arrives and is
+// propagated to subagents through the W3C TRACEPARENT environment variable, so
+// a multi-agent session forms a single distributed trace.
+type tracer struct {
+	endpoint string
+	service  string
+	version  string
+	traceID  string
+	parentID string // span ID from the incoming TRACEPARENT, if any
+	sampled  bool
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+// span is a single timed operation within a trace.
+type span struct {
+	tracer   *tracer
+	name     string
+	spanID   string
+	parentID string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]any
+	err      error
+}
+
+type spanContextKey struct{}
+
+// newTracer creates a tracer from SFA_OTEL_ENDPOINT and TRACEPARENT.
+// Returns nil when tracing is disabled; all methods are nil-safe.
+func newTracer(agentName, version string) *tracer {
+	endpoint := os.Getenv("SFA_OTEL_ENDPOINT")
+	if endpoint == "" {
+		return nil
+	}
+
+	t := &tracer{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		service:  agentName,
+		version:  version,
+		sampled:  true,
+	}
+
+	if traceID, parentID, flags, ok := parseTraceparent(os.Getenv("TRACEPARENT")); ok {
+		t.traceID = traceID
+		t.parentID = parentID
+		t.sampled = flags&0x01 == 0x01
+	} else {
+		t.traceID = randomHex(16)
+	}
+	return t
+}
+
+// start begins a span. The parent is taken from ctx, falling back to the
+// incoming TRACEPARENT for the root span.
+func (t *tracer) start(ctx context.Context, name string) *span {
+	if t == nil {
+		return nil
+	}
+	parentID := t.parentID
+	if parent := spanFromContext(ctx); parent != nil {
+		parentID = parent.spanID
+	}
+	return &span{
+		tracer:   t,
+		name:     name,
+		spanID:   randomHex(8),
+		parentID: parentID,
+		start:    time.Now(),
+		attrs:    make(map[string]any),
+	}
+}
+
+// startSpan begins a child of the span carried by ctx. Returns nil when ctx
+// carries no span (tracing disabled).
+func startSpan(ctx context.Context, name string) *span {
+	parent := spanFromContext(ctx)
+	if parent == nil {
+		return nil
+	}
+	return parent.tracer.start(ctx, name)
+}
+
+// setAttr records an attribute on the span.
+func (s *span) setAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = value
+}
+
+// finish ends the span, marking it failed if err is non-nil. Only the first
+// call has an effect.
+func (s *span) finish(err error) {
+	if s == nil || !s.end.IsZero() {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.mu.Unlock()
+}
+
+// traceparent returns the W3C trace context header value identifying this span.
+func (s *span) traceparent() string {
+	if s == nil {
+		return ""
+	}
+	flags := "00"
+	if s.tracer.sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", s.tracer.traceID, s.spanID, flags)
+}
+
+// contextWithSpan returns a child context carrying the span as the current parent.
+func contextWithSpan(ctx context.Context, s *span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, s)
+}
+
+// spanFromContext returns the current span, or nil.
+func spanFromContext(ctx context.Context) *span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanContextKey{}).(*span)
+	return s
+}
+
+// traceparentFor returns the TRACEPARENT value to hand to a subagent: the
+// current span when tracing, otherwise the incoming value passed through.
+func traceparentFor(ctx context.Context) string {
+	if s := spanFromContext(ctx); s != nil {
+		return s.traceparent()
+	}
+	return os.Getenv("TRACEPARENT")
+}
+
+// flush exports all finished spans. Best-effort: failures are warned to stderr.
+func (t *tracer) flush() {
+	if t == nil || !t.sampled {
+		return
+	}
+
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(t.otlpPayload(spans))
+	if err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to encode trace: %v", err))
+		return
+	}
+
+	url := t.endpoint
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to export trace: %v", err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		stderrLog.Warn(fmt.Sprintf("trace export returned HTTP %d", resp.StatusCode))
+	}
+}
+
+// otlpPayload builds an OTLP ExportTraceServiceRequest in its JSON mapping.
+func (t *tracer) otlpPayload(spans []*span) map[string]any {
+	otlpSpans := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		entry := map[string]any{
+			"traceId":           t.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parentID != "" {
+			entry["parentSpanId"] = s.parentID
+		}
+		if s.err != nil {
+			entry["status"] = map[string]any{"code": 2, "message": s.err.Error()} // STATUS_CODE_ERROR
+		} else {
+			entry["status"] = map[string]any{"code": 1} // STATUS_CODE_OK
+		}
+		otlpSpans = append(otlpSpans, entry)
+	}
+
+	return map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": otlpAttributes(map[string]any{
+						"service.name":    t.service,
+						"service.version": t.version,
+					}),
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": "sfa-sdk-go"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+// otlpAttributes converts a map to OTLP KeyValue attributes.
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]any
+		switch val := v.(type) {
+		case string:
+			value = map[string]any{"stringValue": val}
+		case bool:
+			value = map[string]any{"boolValue": val}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(val)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(val, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": val}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprintf("%v", val)}
+		}
+		out = append(out, map[string]any{"key": k, "value": value})
+	}
+	return out
+}
+
+// parseTraceparent parses a W3C traceparent header ("00-<trace>-<span>-<flags>").
+func parseTraceparent(s string) (traceID, spanID string, flags byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", 0, false
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil || parts[1] == strings.Repeat("0", 32) {
+		return "", "", 0, false
+	}
+	if _, err := hex.DecodeString(parts[2]); err != nil || parts[2] == strings.Repeat("0", 16) {
+		return "", "", 0, false
+	}
+	f, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return "", "", 0, false
+	}
+	return parts[1], parts[2], f[0], true
+}
+
+// randomHex returns n random bytes hex-encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
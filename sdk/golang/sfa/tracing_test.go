@@ -0,0 +1,120 @@
+package sfa
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, spanID, flags, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("expected valid traceparent")
+	}
+	if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || spanID != "00f067aa0ba902b7" || flags != 1 {
+		t.Errorf("unexpected parse: %s %s %d", traceID, spanID, flags)
+	}
+
+	for _, bad := range []string{
+		"",
+		"garbage",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, _, _, ok := parseTraceparent(bad); ok {
+			t.Errorf("%q: expected invalid", bad)
+		}
+	}
+}
+
+func TestNewTracerDisabled(t *testing.T) {
+	os.Unsetenv("SFA_OTEL_ENDPOINT")
+
+	tr := newTracer("test-agent", "1.0.0")
+	if tr != nil {
+		t.Fatal("expected nil tracer without SFA_OTEL_ENDPOINT")
+	}
+
+	// Nil tracer and spans must be safe to use
+	s := tr.start(context.Background(), "noop")
+	s.setAttr("k", "v")
+	s.finish(nil)
+	tr.flush()
+	if startSpan(context.Background(), "child") != nil {
+		t.Error("expected nil child span without a parent")
+	}
+}
+
+func TestTracerJoinsIncomingTrace(t *testing.T) {
+	os.Setenv("SFA_OTEL_ENDPOINT", "http://127.0.0.1:0")
+	os.Setenv("TRACEPARENT", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	defer os.Unsetenv("SFA_OTEL_ENDPOINT")
+	defer os.Unsetenv("TRACEPARENT")
+
+	tr := newTracer("child", "1.0.0")
+	root := tr.start(context.Background(), "sfa.run child")
+	if root.parentID != "00f067aa0ba902b7" {
+		t.Errorf("expected root span parented to incoming span, got %q", root.parentID)
+	}
+
+	ctx := contextWithSpan(context.Background(), root)
+	child := startSpan(ctx, "sfa.invoke grandchild")
+	if child.parentID != root.spanID {
+		t.Errorf("expected child parented to root span")
+	}
+
+	tp := traceparentFor(contextWithSpan(ctx, child))
+	if !strings.HasPrefix(tp, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+child.spanID) {
+		t.Errorf("expected propagated traceparent for child span, got %q", tp)
+	}
+}
+
+func TestTraceparentForPassThrough(t *testing.T) {
+	os.Setenv("TRACEPARENT", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	defer os.Unsetenv("TRACEPARENT")
+
+	if got := traceparentFor(context.Background()); got != os.Getenv("TRACEPARENT") {
+		t.Errorf("expected incoming traceparent passed through, got %q", got)
+	}
+}
+
+func TestTracerFlushExportsOTLP(t *testing.T) {
+	var path string
+	var payload map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &payload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	os.Setenv("SFA_OTEL_ENDPOINT", srv.URL)
+	os.Unsetenv("TRACEPARENT")
+	defer os.Unsetenv("SFA_OTEL_ENDPOINT")
+
+	tr := newTracer("test-agent", "1.0.0")
+	root := tr.start(context.Background(), "sfa.run test-agent")
+	root.setAttr("sfa.depth", 0)
+	child := startSpan(contextWithSpan(context.Background(), root), "sfa.context.write")
+	child.finish(nil)
+	root.finish(nil)
+	tr.flush()
+
+	if path != "/v1/traces" {
+		t.Errorf("expected export to /v1/traces, got %q", path)
+	}
+	data, _ := json.Marshal(payload)
+	for _, want := range []string{`"service.name"`, `"sfa.run test-agent"`, `"sfa.context.write"`, `"parentSpanId"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected payload to contain %s, got %s", want, data)
+		}
+	}
+}
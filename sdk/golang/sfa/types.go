@@ -1,6 +1,10 @@
 package sfa
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
 
 // TrustLevel describes the agent's permission requirements.
 type TrustLevel string
@@ -23,6 +27,15 @@ const (
 	ContextSummary   ContextType = "summary"
 )
 
+// ContextSort orders context search results.
+type ContextSort string
+
+const (
+	ContextSortNewest     ContextSort = "newest"     // most recent first; the default
+	ContextSortRelevance  ContextSort = "relevance"  // best match for Query first, by BM25
+	ContextSortSimilarity ContextSort = "similarity" // closest in meaning to Query first, by embeddings; relevance without a provider
+)
+
 // OutputFormat controls result output formatting.
 type OutputFormat string
 
@@ -37,8 +50,24 @@ type ServiceLifecycle string
 const (
 	ServicePersistent ServiceLifecycle = "persistent"
 	ServiceEphemeral  ServiceLifecycle = "ephemeral"
+	ServiceSession    ServiceLifecycle = "session" // up until the session's root agent exits
 )
 
+// LoopMode selects how an agent may re-enter the call chain.
+type LoopMode string
+
+const (
+	LoopDeny        LoopMode = "deny"
+	LoopAllowSelf   LoopMode = "allow-self"
+	LoopAllowCycles LoopMode = "allow-cycles"
+)
+
+// LoopPolicy bounds re-entry into the call chain. The zero value denies all loops.
+type LoopPolicy struct {
+	Mode LoopMode
+	Max  int // re-entries permitted for allow-self and allow-cycles
+}
+
 // Exit codes per the SFA specification.
 const (
 	ExitSuccess        = 0
@@ -57,6 +86,12 @@ type EnvDef struct {
 	Secret      bool
 	Default     string
 	Description string
+	AuthHost    string   // secret sent as the Authorization header on HTTPClient requests to this host
+	AuthScheme  string   // Authorization scheme for AuthHost; default "Bearer"
+	Source      string   // "keyring" reads the value from the OS keychain before config
+	Type        string   // "url", "int", "bool", "enum", "path", "pattern"; default any string
+	Enum        []string // allowed values when Type is "enum"
+	Pattern     string   // regular expression the whole value must match
 }
 
 // OptionDef declares a custom CLI option for the agent.
@@ -69,17 +104,45 @@ type OptionDef struct {
 	Required    bool
 }
 
+// ConfigDef declares a key the agent reads from its merged config
+// (ExecuteContext.Config).
+type ConfigDef struct {
+	Key         string
+	Type        string // "string", "number", "boolean", "object", "array"; default any
+	Default     any    // used when the key is unset or its value is invalid
+	Description string
+}
+
 // ServiceDef declares a Docker Compose service dependency.
 type ServiceDef struct {
-	Image       string
+	Image       string    // with Build, the name to tag the built image; default sfa-<agent>-<service>
+	Build       *BuildDef // build the image from source instead of pulling it
 	Ports       []string
 	Environment map[string]string
 	Healthcheck *HealthcheckDef
 	Volumes     []string
-	Command     any // string or []string
-	ConnString  string
+	Command     any               // string or []string
+	ConnString  string            // SFA_SVC_<NAME>_URL template, e.g. "postgres://${POSTGRES_USER}@${host}:${port}/app"
+	DependsOn   map[string]string // services to start first, by name, to the condition to wait for
+	Networks    []string          // shared networks to join besides the agent's own
+	Resources   *ResourceLimits
+	Ready       *ReadyProbe       // polled by the SDK after compose up, for images without a healthcheck
+	Profiles    []string          // compose profiles; started only when one is enabled, e.g. "admin"
+	GPUs        string            // GPUs to reserve: "all", a count such as "1", or "device=0,2"
+	User        string            // user to run as, a name or "uid[:gid]"
+	Restart     string            // restart policy; default RestartNo
+	ExtraHosts  []string          // /etc/hosts entries, "host:ip" or "host:host-gateway"
+	Ulimits     map[string]Ulimit // by limit name, e.g. "nofile": {Soft: 65536}
 }
 
+// Conditions for ServiceDef.DependsOn, as in Docker Compose. An empty
+// condition means DependsOnStarted.
+const (
+	DependsOnStarted   = "service_started"
+	DependsOnHealthy   = "service_healthy"                // the dependency's Healthcheck passes
+	DependsOnCompleted = "service_completed_successfully" // the dependency runs to exit code 0, e.g. migrations
+)
+
 // HealthcheckDef is a Docker healthcheck configuration.
 type HealthcheckDef struct {
 	Test        string
@@ -89,36 +152,107 @@ type HealthcheckDef struct {
 	StartPeriod string
 }
 
+// ReadyProbe is a readiness check the SDK runs itself. URL is
+// tcp://host:port, which must accept a connection, or http(s)://host:port/path,
+// which must answer with Status (any 2xx if zero). ${host} and ${port} are
+// the service's published host and port, e.g. "http://${host}:${port}/health".
+type ReadyProbe struct {
+	URL    string
+	Status int
+}
+
+// Ulimit is a per-container resource limit such as nofile, as in docker
+// run --ulimit. -1 is unlimited; a zero Hard means the same as Soft.
+type Ulimit struct {
+	Soft int64
+	Hard int64
+}
+
+// BuildDef builds a service's image from a Dockerfile, as compose build
+// does. A relative Context is taken from the agent's working directory.
+type BuildDef struct {
+	Context    string            // default "."
+	Dockerfile string            // relative to Context; default "Dockerfile"
+	Args       map[string]string // build arguments
+}
+
+// ResourceLimits caps what a service container may use. Zero values leave
+// a limit unset.
+type ResourceLimits struct {
+	CPUs   string // CPU cores, e.g. "1.5"
+	Memory string // e.g. "512m" or "2g"
+	Pids   int    // maximum number of processes
+}
+
 // AgentDef is the complete definition passed to DefineAgent.
 type AgentDef struct {
-	Name             string
-	Version          string
-	Description      string
-	TrustLevel       TrustLevel
-	ContextRequired  bool
-	Env              []EnvDef
-	Services         map[string]ServiceDef
-	ServiceLifecycle ServiceLifecycle
-	Options          []OptionDef
-	Examples         []string
-	Execute          func(ctx *ExecuteContext) (any, error)
+	Name                string
+	Version             string
+	Description         string
+	TrustLevel          TrustLevel // described as sandboxed when empty; only a declared sandboxed level is enforced
+	ContextRequired     bool
+	ContextSchema       map[ContextType]ContextSchema // checked as entries of each type are written
+	LoopPolicy          LoopPolicy
+	Env                 []EnvDef
+	Services            map[string]ServiceDef
+	ServiceLifecycle    ServiceLifecycle
+	ServiceStartTimeout time.Duration // wait for services to become healthy and ready; default 60s, SFA_SERVICE_TIMEOUT overrides
+	Networks            []string      // shared networks every service joins, so other agents' services can reach them
+	Options             []OptionDef
+	ConfigSchema        []ConfigDef // keys read from the merged config, listed in --describe
+	Examples            []string
+	Errors              []ErrorDef    // agent-specific error codes, listed in --describe
+	Cacheable           bool          // deterministic agents may reuse results for identical input and options
+	CacheTTL            time.Duration // default 1h
+	HeartbeatInterval   time.Duration // silence before a heartbeat progress line; default 30s, negative disables
+	LogSinks            []LogSink     // receive execution log entries besides the log file and logging.sinks
+	Execute             func(ctx *ExecuteContext) (any, error)
 }
 
 // ExecuteContext is passed to the agent's Execute function.
 type ExecuteContext struct {
-	Input        string
-	Options      map[string]any
-	Env          map[string]string
-	Config       map[string]any
-	Ctx          context.Context
-	Depth        int
-	SessionID    string
-	AgentName    string
-	AgentVersion string
-	Progress     func(message string)
-	Invoke       func(agentName string, opts *InvokeOpts) (*InvokeResult, error)
-	WriteContext func(entry ContextEntry) (string, error)
-	SearchContext func(query ContextQuery) ([]ContextResult, error)
+	Input              string
+	Options            map[string]any
+	Env                map[string]string
+	Config             map[string]any
+	Ctx                context.Context
+	Depth              int
+	SessionID          string
+	AgentName          string
+	AgentVersion       string
+	Progress           func(message string)
+	Partial            func(result any) // streamed to --serve /ws subscribers; discarded in CLI mode
+	Invoke             func(agentName string, opts *InvokeOpts) (*InvokeResult, error)
+	WriteContext       func(entry ContextEntry) (string, error)
+	AppendContext      func(path, content string) error            // adds content to an entry; path as WriteContext returned it, or relative to the store
+	UpdateContext      func(path string, entry ContextEntry) error // replaces the type, tags, links, and content that entry sets, and merges its metadata
+	ReplaceTags        func(path string, tags []string) error
+	ReadContext        func(pathOrID string) (*ContextResult, error) // a path as WriteContext returned it or relative to the store, or an entry's file name without .md
+	ReadAttachment     func(path, name string) ([]byte, error)       // the attachment name of the entry at path
+	SearchContext      func(query ContextQuery) ([]ContextResult, error)
+	RelatedContext     func(path string, depth int) (*ContextGraph, error)
+	ListSessions       func() ([]ContextSession, error)                                            // sessions with entries in the store, most recently active first; ContextQuery.SessionID lists one's entries
+	WatchContext       func(ctx context.Context, query ContextQuery) (<-chan ContextResult, error) // entries matching query as they are written, until ctx or the execution is done
+	ExportContext      func(opts ContextExportOpts) ([]byte, error)                                // entries of the store as a JSON document or .tar.gz
+	ImportContext      func(data []byte) ([]string, error)                                         // writes an export's entries, skipping paths already present; returns those written
+	QueryLogs          func(query LogQuery) ([]LogEntry, error)                                    // execution log entries matching query, oldest first; this execution is logged as it ends
+	SetMeta            func(key string, value any)                                                 // records key in this execution's log entry meta; the SDK's own keys win
+	SummarizeSession   func(opts SummarizeSessionOpts) (string, error)
+	RecordCost         func(units string, amount float64) error
+	Checkpoint         func(state any) error
+	ResumeState        json.RawMessage                                             // last checkpoint when resuming; nil otherwise
+	OnReload           func(fn func(config map[string]any, env map[string]string)) // called on SIGHUP with reloaded values
+	OnStatus           func(fn func() string)                                      // line added to the SIGUSR1 status dump
+	ServiceLogs        func(name string, tail int) (string, error)                 // a declared service's last tail log lines; tail <= 0 means 100
+	OnServiceUnhealthy func(fn func(service, state string))                        // called when a service turns unhealthy or exits during Execute
+	RequestPermission  func(action string) error                                   // nil when allowed; wraps ErrPermissionDenied when refused
+	RateLimiter        func(name string, rps float64, burst int) *RateLimiter      // token bucket shared by all agents in the session
+	Confirm            func(question string) (bool, error)                         // yes/no on the terminal; yes under --yes
+	Prompt             func(question, def string) (string, error)                  // value from the terminal; def under --yes or empty answer
+
+	envDefs  []EnvDef
+	resolved *ResolvedEnv
+	meta     *executionMeta
 }
 
 // InvokeOpts configures a subagent invocation.
@@ -134,41 +268,118 @@ type InvokeResult struct {
 	ExitCode int
 	Output   string
 	Stderr   string
+	Cost     map[string]float64 // totals reported by the subagent and its descendants
 }
 
 // ContextEntry is used to write a context store entry.
 type ContextEntry struct {
-	Type    ContextType
-	Tags    []string
-	Slug    string
-	Content string
-	Links   []string
+	Type           ContextType
+	Tags           []string
+	Slug           string
+	Content        string
+	Links          []string
+	Metadata       map[string]any // further frontmatter keys: JSON-like values, under keys other than those the store sets
+	Attachments    []ContextAttachment
+	AllowDuplicate bool // write even if the agent's session has an entry with this type, tags, and content
+}
+
+// ContextAttachment is a file kept with a context entry, such as a diff, a
+// screenshot, or a CSV.
+type ContextAttachment struct {
+	Name  string // file name: letters, digits, and . _ -, not ending in .md
+	Path  string // to write, a file to copy; in results, where the attachment is kept
+	Bytes []byte // to write, the content, if Path is not set
 }
 
 // ContextQuery defines search criteria for the context store.
 type ContextQuery struct {
-	Agent string
-	Tags  []string
-	Type  ContextType
-	Query string
+	Agent     string
+	SessionID string // "" matches entries of every session, and those outside one
+	Tags      []string
+	AllTags   bool // entries must have every tag of Tags, not just one
+	Type      ContextType
+	Query     string
+	Since     time.Time   // entries written at or after it; zero means any
+	Until     time.Time   // entries written before it; zero means any
+	Sort      ContextSort // "" means ContextSortNewest
+	Offset    int         // results to skip, for paging
+	Limit     int         // at most this many results; 0 means all
 }
 
 // ContextResult is a context store entry returned from search.
 type ContextResult struct {
-	FilePath  string
-	Agent     string
-	SessionID string
-	Timestamp string
-	Type      ContextType
-	Tags      []string
-	Links     []string
-	Content   string
+	FilePath    string
+	Agent       string
+	SessionID   string
+	Timestamp   string
+	Type        ContextType
+	Tags        []string
+	Links       []string
+	Metadata    map[string]any      // the entry's further frontmatter keys, nil if none
+	Attachments []ContextAttachment // Name and Path of each; read them with ReadAttachment
+	Content     string
+	Snippet     string // text around the first match of Query, matches in **bold**; "" without a Query
+}
+
+// ContextSession is a session with entries in the context store, as
+// ListSessions returns it.
+type ContextSession struct {
+	ID      string
+	Entries int
+	First   string   // timestamp of its oldest entry
+	Last    string   // timestamp of its newest entry
+	Agents  []string // agents that wrote its entries, sorted
+}
+
+// ContextExportFormat is the form of a context export.
+type ContextExportFormat string
+
+const (
+	ContextExportJSON ContextExportFormat = "json" // one JSON document; the default
+	ContextExportTar  ContextExportFormat = "tar"  // a gzipped tarball of the entry files
+)
+
+// ContextExportOpts selects the entries ExportContext bundles.
+type ContextExportOpts struct {
+	Agent     string // "" exports every agent's entries
+	SessionID string // "" exports entries of every session, and those outside one
+	Format    ContextExportFormat
+}
+
+// SummarizeSessionOpts configures SummarizeSession, which writes a summary
+// entry of the session's context entries, linking to each of them.
+type SummarizeSessionOpts struct {
+	Summarizer string   // agent given the entries on stdin, whose output is the summary; default config contextStore.summarizer, else a digest of the entries
+	Slug       string   // default "session-summary"
+	Tags       []string // besides "session-summary", which every summary has
+}
+
+// ContextGraph is what RelatedContext returns: an entry and the entries
+// within depth links of it, following links both ways. A negative depth
+// follows every link.
+type ContextGraph struct {
+	Root  string        // the entry's path relative to the store root
+	Nodes []ContextNode // the root first, then by distance and path
+	Edges []ContextEdge // links between nodes, and to entries the store lacks
+}
+
+// ContextNode is an entry in a ContextGraph.
+type ContextNode struct {
+	ContextResult
+	Path  string // relative to the store root, as links name it
+	Depth int    // links from the root, in either direction
+}
+
+// ContextEdge is a link in a ContextGraph: the entry at From links to To.
+type ContextEdge struct {
+	From string
+	To   string
 }
 
 // AgentResult wraps the return value from an agent's Execute function.
 type AgentResult struct {
-	Result   any                    `json:"result"`
-	Metadata map[string]any         `json:"metadata,omitempty"`
-	Warnings []string               `json:"warnings,omitempty"`
-	Error    string                 `json:"error,omitempty"`
+	Result   any            `json:"result"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Warnings []string       `json:"warnings,omitempty"`
+	Error    string         `json:"error,omitempty"`
 }
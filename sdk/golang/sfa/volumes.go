@@ -0,0 +1,97 @@
+package sfa
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// volumeNamePattern is what Docker accepts as a volume name.
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// serviceVolumeName returns the engine volume for an agent's named volume.
+// Agent names keep two agents' "data" volumes apart.
+func serviceVolumeName(agentName, volume string) string {
+	return "sfa-" + agentName + "-" + volume
+}
+
+// namedVolumeSource returns the volume name a mount such as
+// "pgdata:/var/lib/postgresql/data" uses, or "" for a bind mount
+// ("./init:/docker-entrypoint-initdb.d", "/srv/data:/data") or an
+// anonymous volume ("/data").
+func namedVolumeSource(mount string) string {
+	source, _, found := strings.Cut(mount, ":")
+	if !found || source == "" || strings.ContainsAny(source[:1], "/.~$") || strings.Contains(source, "/") {
+		return ""
+	}
+	return source
+}
+
+// namedVolumes returns each named volume the services mount with the
+// services that mount it, failing on a name Docker would refuse.
+func namedVolumes(services map[string]ServiceDef) (map[string][]string, error) {
+	volumes := make(map[string][]string)
+	for _, name := range sortedKeys(services) {
+		for _, mount := range services[name].Volumes {
+			volume := namedVolumeSource(mount)
+			if volume == "" {
+				continue
+			}
+			if !volumeNamePattern.MatchString(volume) {
+				return nil, fmt.Errorf("service %s: invalid volume name %q (use letters, digits, ., _, and -)", name, volume)
+			}
+			if users := volumes[volume]; len(users) == 0 || users[len(users)-1] != name {
+				volumes[volume] = append(users, name)
+			}
+		}
+	}
+	return volumes, nil
+}
+
+// ensureVolumes creates the agent's named volumes that do not exist yet,
+// labelled with the agent and the services that mount them. They are
+// external to the compose project, so compose down -v leaves the data for
+// the next run; removeServiceVolumes and sfa services prune --volumes
+// remove them.
+func ensureVolumes(engine containerEngine, agentName string, volumes map[string][]string) error {
+	for _, volume := range sortedKeys(volumes) {
+		name := serviceVolumeName(agentName, volume)
+		if engine.command("volume", "inspect", name).Run() == nil {
+			continue
+		}
+		out, err := engine.command("volume", "create",
+			"--label", "sfa.agent="+agentName,
+			"--label", "sfa.service="+strings.Join(volumes[volume], ","),
+			"--label", "sfa.volume="+volume,
+			name,
+		).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to create volume %s: %s", name, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// removeServiceVolumes removes an agent's named volumes when its services
+// are torn down for good, as ephemeral and session services are. Failures
+// are warned to stderr and ignored.
+func removeServiceVolumes(agentName string, config map[string]any) {
+	engine, err := resolveContainerEngine(config, agentName)
+	if err != nil {
+		return
+	}
+	out, err := engine.command("volume", "ls", "-q", "--filter", "label=sfa.agent="+agentName).Output()
+	if err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to list volumes for %s: %v", agentName, err))
+		return
+	}
+	names := strings.Fields(string(out))
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	if out, err := engine.command(append([]string{"volume", "rm"}, names...)...).CombinedOutput(); err != nil {
+		stderrLog.Warn(fmt.Sprintf("failed to remove volumes for %s: %s", agentName, strings.TrimSpace(string(out))))
+	}
+}
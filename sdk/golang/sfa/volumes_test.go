@@ -0,0 +1,55 @@
+package sfa
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNamedVolumeSource(t *testing.T) {
+	for mount, want := range map[string]string{
+		"pgdata:/var/lib/postgresql/data":    "pgdata",
+		"cache:/data:ro":                     "cache",
+		"./init:/docker-entrypoint-initdb.d": "",
+		"/srv/data:/data":                    "",
+		"~/models:/models":                   "",
+		"${DATA_DIR}:/data":                  "",
+		"/data":                              "",
+	} {
+		if got := namedVolumeSource(mount); got != want {
+			t.Errorf("namedVolumeSource(%q) = %q, want %q", mount, got, want)
+		}
+	}
+}
+
+func TestMaterializeComposeVolumes(t *testing.T) {
+	t.Setenv("SFA_DATA_HOME", t.TempDir())
+	services := map[string]ServiceDef{
+		"db":     {Image: "postgres:16", Volumes: []string{"pgdata:/var/lib/postgresql/data", "./init:/docker-entrypoint-initdb.d"}},
+		"backup": {Image: "backup:1", Volumes: []string{"pgdata:/backup/source:ro"}},
+	}
+	volumes, err := namedVolumes(services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(volumes["pgdata"], ","); len(volumes) != 1 || got != "backup,db" {
+		t.Errorf("volumes = %v, want pgdata used by backup and db", volumes)
+	}
+
+	path, err := materializeCompose("archivist", "1.0.0", services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "volumes:\n  pgdata:\n    name: sfa-archivist-pgdata\n    external: true\n"; !strings.Contains(string(data), want) {
+		t.Errorf("expected %q in compose file:\n%s", want, data)
+	}
+
+	bad := map[string]ServiceDef{"db": {Image: "postgres:16", Volumes: []string{"pg@data:/data"}}}
+	if _, err := materializeCompose("archivist", "1.0.0", bad); err == nil {
+		t.Error("expected an invalid volume name to be rejected")
+	}
+}
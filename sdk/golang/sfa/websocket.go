@@ -0,0 +1,353 @@
+package sfa
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// executionRetention is how long a finished execution's events stay
+// available to late /ws subscribers.
+const executionRetention = time.Minute
+
+// executionHub tracks the event streams of executions by ID so /ws
+// subscribers can follow them, before they start or after they finish.
+type executionHub struct {
+	mu      sync.Mutex
+	streams map[string]*executionStream
+}
+
+// executionStream records one execution's events and wakes subscribers on
+// each new one.
+type executionStream struct {
+	mu          sync.Mutex
+	events      []executionEvent
+	started     bool
+	done        bool
+	subscribers int
+	changed     chan struct{} // closed and replaced on every change
+}
+
+func newExecutionHub() *executionHub {
+	return &executionHub{streams: make(map[string]*executionStream)}
+}
+
+// begin registers an execution, keeping any subscribers already waiting for
+// it. It fails if an execution with the same ID is running.
+func (h *executionHub) begin(id string) (*executionStream, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.streams[id]
+	if st != nil {
+		st.mu.Lock()
+		running := st.started && !st.done
+		finished := st.done
+		st.mu.Unlock()
+		if running {
+			return nil, fmt.Errorf("execution %s is already running", id)
+		}
+		if finished {
+			st = nil // a new run under a reused ID starts a fresh stream
+		}
+	}
+	if st == nil {
+		st = &executionStream{changed: make(chan struct{})}
+		h.streams[id] = st
+	}
+	st.mu.Lock()
+	st.started = true
+	st.mu.Unlock()
+	return st, nil
+}
+
+// finish records the final response and forgets the stream after
+// executionRetention.
+func (h *executionHub) finish(id string, st *executionStream, resp *executeResponse) {
+	st.publish(executionEvent{Type: "result", Data: resp}, true)
+	time.AfterFunc(executionRetention, func() {
+		h.mu.Lock()
+		if h.streams[id] == st {
+			delete(h.streams, id)
+		}
+		h.mu.Unlock()
+	})
+}
+
+// subscribe returns the stream for id, creating a placeholder if the
+// execution has not started yet.
+func (h *executionHub) subscribe(id string) *executionStream {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.streams[id]
+	if st == nil {
+		st = &executionStream{changed: make(chan struct{})}
+		h.streams[id] = st
+	}
+	st.mu.Lock()
+	st.subscribers++
+	st.mu.Unlock()
+	return st
+}
+
+// unsubscribe drops a placeholder nobody is waiting on any more.
+func (h *executionHub) unsubscribe(id string, st *executionStream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st.mu.Lock()
+	st.subscribers--
+	abandoned := st.subscribers == 0 && !st.started
+	st.mu.Unlock()
+	if abandoned && h.streams[id] == st {
+		delete(h.streams, id)
+	}
+}
+
+func (st *executionStream) publish(event executionEvent, last bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.done {
+		return
+	}
+	st.events = append(st.events, event)
+	st.done = last
+	close(st.changed)
+	st.changed = make(chan struct{})
+}
+
+// next returns the events after the first n, whether the stream is done,
+// and a channel closed on the next change.
+func (st *executionStream) next(n int) ([]executionEvent, bool, <-chan struct{}) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.events[n:], st.done, st.changed
+}
+
+// handleWebSocket streams an execution's events as JSON text messages,
+// replaying those already emitted, and closes after the result.
+func (s *agentServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !s.originAllowed(r) {
+		http.Error(w, "cross-origin WebSocket not allowed", http.StatusForbidden)
+		return
+	}
+	if !s.authorized(r, true) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing execution id", http.StatusBadRequest)
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer conn.Close()
+
+	st := s.hub.subscribe(id)
+	defer s.hub.unsubscribe(id, st)
+
+	// Read client frames to answer pings and notice when it goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.readUntilClose()
+	}()
+
+	sent := 0
+	for {
+		events, done, changed := st.next(sent)
+		for _, event := range events {
+			data, _ := json.Marshal(event)
+			if err := conn.writeFrame(wsOpText, data); err != nil {
+				return
+			}
+		}
+		sent += len(events)
+		if done {
+			conn.writeClose(wsCloseNormal, "")
+			select {
+			case <-closed:
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		select {
+		case <-changed:
+		case <-closed:
+			return
+		}
+	}
+}
+
+// WebSocket opcodes and close codes (RFC 6455).
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+
+	wsCloseNormal = 1000
+
+	wsMaxClientFrame = 1 << 16
+	wsGUID           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// wsConn is the server side of a WebSocket connection.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu        sync.Mutex // serializes writes
+	closeSent bool
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("expected a WebSocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection does not support WebSocket")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeFrame sends one unfragmented, unmasked frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	c.closeSent = opcode == wsOpClose
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+func (c *wsConn) writeClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.writeFrame(wsOpClose, append(payload, reason...))
+}
+
+// readFrame reads one client frame, which must be masked.
+func (c *wsConn) readFrame() (opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return 0, nil, errors.New("client frame is not masked")
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxClientFrame {
+		return 0, nil, fmt.Errorf("client frame of %d bytes exceeds the limit", n)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// readUntilClose answers pings and returns when the client closes the
+// connection or sends a close frame, which is echoed unless the server
+// already sent one. Other client messages are ignored.
+func (c *wsConn) readUntilClose() {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpPing:
+			c.writeFrame(wsOpPong, payload)
+		case wsOpClose:
+			c.writeFrame(wsOpClose, payload)
+			return
+		}
+	}
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
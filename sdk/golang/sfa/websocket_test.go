@@ -0,0 +1,204 @@
+package sfa
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsDial opens a WebSocket to path on srv and returns the connection and
+// its reader once the handshake succeeds.
+func wsDial(t *testing.T, srv *httptest.Server, path string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake response: %d %v", resp.StatusCode, resp.Header)
+	}
+	return conn, r
+}
+
+// wsRead reads one unmasked server frame.
+func wsRead(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := int(head[1] & 0x7F)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+// wsWriteMasked sends one masked client frame.
+func wsWriteMasked(conn net.Conn, opcode byte, payload []byte) {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+func TestServeWebSocketStreamsExecution(t *testing.T) {
+	s := newTestServer(t, &AgentDef{
+		Name: "streamer",
+		Execute: func(ctx *ExecuteContext) (any, error) {
+			ctx.Progress("step 1")
+			ctx.Partial(map[string]any{"lines": 1})
+			return "final", nil
+		},
+	})
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	captureStderr(t, func() {
+		conn, r := wsDial(t, srv, "/ws?id=exec-1")
+
+		resp, out := postExecute(t, srv.URL, `{"input":"go","executionId":"exec-1"}`)
+		if out.ExecutionID != "exec-1" || resp.Header.Get("X-SFA-Execution-Id") != "exec-1" {
+			t.Errorf("expected execution ID echoed, got %q", out.ExecutionID)
+		}
+
+		var types []string
+		for {
+			op, payload := wsRead(t, r)
+			if op == wsOpClose {
+				if binary.BigEndian.Uint16(payload) != wsCloseNormal {
+					t.Errorf("unexpected close payload %v", payload)
+				}
+				wsWriteMasked(conn, wsOpClose, payload)
+				break
+			}
+			var event executionEvent
+			if err := json.Unmarshal(payload, &event); err != nil {
+				t.Fatal(err)
+			}
+			types = append(types, event.Type)
+			if event.Type == "result" && event.Data.(map[string]any)["result"] != "final" {
+				t.Errorf("unexpected result event %v", event.Data)
+			}
+		}
+		if strings.Join(types, ",") != "progress,partial,result" {
+			t.Errorf("unexpected event sequence %v", types)
+		}
+
+		// A late subscriber gets the finished execution replayed
+		conn2, r2 := wsDial(t, srv, "/ws?id=exec-1")
+		wsWriteMasked(conn2, wsOpPing, []byte("hi"))
+		seen := 0
+		for {
+			op, _ := wsRead(t, r2)
+			if op == wsOpClose {
+				break
+			}
+			seen++
+		}
+		if seen != 3 {
+			t.Errorf("expected 3 replayed events, got %d", seen)
+		}
+	})
+}
+
+func TestServeWebSocketRejects(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s := newTestServer(t, &AgentDef{
+		Name: "blocker",
+		Execute: func(ctx *ExecuteContext) (any, error) {
+			close(started)
+			<-release
+			return "done", nil
+		},
+	})
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/ws?id=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a non-upgrade request, got %d", resp.StatusCode)
+	}
+
+	captureStderr(t, func() {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			http.Post(srv.URL+"/execute", "application/json", strings.NewReader(`{"executionId":"dup"}`))
+		}()
+		<-started
+		resp, out := postExecute(t, srv.URL, `{"executionId":"dup"}`)
+		if resp.StatusCode != http.StatusConflict || out.Error == nil {
+			t.Errorf("expected 409 for a running execution ID, got %d", resp.StatusCode)
+		}
+		close(release)
+		<-done
+	})
+}
+
+func TestServeWebSocketOriginAndAuth(t *testing.T) {
+	s := newTestServer(t, &AgentDef{Name: "guarded", Execute: func(*ExecuteContext) (any, error) { return nil, nil }})
+	s.token = "s3cret"
+	s.origins = []string{"https://ui.example"}
+
+	cases := []struct {
+		origin, auth, query string
+		status              int
+	}{
+		{"https://evil.example", "Bearer s3cret", "", http.StatusForbidden},
+		{"", "", "", http.StatusUnauthorized},
+		{"http://test", "Bearer wrong", "", http.StatusUnauthorized},
+		// Allowed origins and credentials pass through to the upgrade, which
+		// the recorder cannot hijack
+		{"http://test", "Bearer s3cret", "", http.StatusBadRequest},
+		{"https://ui.example", "", "&token=s3cret", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "http://test/ws?id=x"+c.query, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		rec := httptest.NewRecorder()
+		s.handler().ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Errorf("origin %q auth %q: expected %d, got %d", c.origin, c.auth, c.status, rec.Code)
+		}
+	}
+}
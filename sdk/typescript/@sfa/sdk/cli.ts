@@ -15,6 +15,8 @@ export interface StandardFlags {
   "no-log": boolean;
   "max-depth": number;
   "services-down": boolean;
+  /** Compose profiles to start, comma-separated; repeating the flag adds more */
+  "services-profile": string | undefined;
   yes: boolean;
   "non-interactive": boolean;
   context: string | undefined;
@@ -41,6 +43,7 @@ const STANDARD_FLAG_DEFS: Record<string, { type: "string" | "number" | "boolean"
   "no-log": { type: "boolean" },
   "max-depth": { type: "number", default: 5 },
   "services-down": { type: "boolean" },
+  "services-profile": { type: "string" },
   yes: { type: "boolean" },
   "non-interactive": { type: "boolean" },
   context: { type: "string" },
@@ -107,7 +110,12 @@ export function parseArgs(argv: string[], customOptions?: AgentOption[]): Parsed
           if (value === undefined) {
             unknown.push(arg);
           } else {
-            flags[name] = def.type === "number" ? Number(value) : value;
+            flags[name] =
+              def.type === "number"
+                ? Number(value)
+                : name === "services-profile" && flags[name]
+                  ? `${flags[name]},${value}`
+                  : value;
           }
         }
       }
@@ -1,6 +1,6 @@
 import { join } from "node:path";
 import { mkdirSync } from "node:fs";
-import { homedir } from "node:os";
+import { configDir } from "./paths";
 
 /**
  * Shared configuration schema.
@@ -11,8 +11,52 @@ export interface SfaConfig {
   mcpServers?: Record<string, string>;
   defaults?: Record<string, unknown>;
   agents?: Record<string, AgentNamespaceConfig>;
-  logging?: { file?: string; maxSize?: number; retainFiles?: number };
-  contextStore?: { path?: string };
+  logging?: {
+    file?: string;
+    maxSize?: number;
+    retainFiles?: number;
+    perAgent?: boolean | "only";
+    buffer?: boolean | string;
+    sinks?: Record<string, unknown>[];
+  };
+  contextStore?: {
+    path?: string;
+    url?: string;
+    region?: string;
+    endpoint?: string;
+    /** Agent that summarizeSession pipes a session's entries to */
+    summarizer?: string;
+    /** Encrypt entry bodies at rest; the key is SFA_CONTEXT_KEY */
+    encrypt?: boolean;
+    retention?: Record<string, string>;
+    quota?: ContextQuota;
+  };
+  services?: { engine?: string } & RemoteEngineConfig;
+}
+
+/** Limits on one scope of the context store; unset fields are unlimited. */
+export interface ContextLimit {
+  maxEntries?: number;
+  maxBytes?: number;
+}
+
+/**
+ * contextStore.quota: limits on each agent's context entries and on its
+ * entries in one session, and whether a write past them evicts older
+ * entries (the default) or is refused.
+ */
+export interface ContextQuota {
+  agent?: ContextLimit;
+  session?: ContextLimit;
+  onExceeded?: "evict" | "reject";
+}
+
+/** Where services' container engine runs; set one or neither. */
+export interface RemoteEngineConfig {
+  /** Daemon address, e.g. ssh://me@devbox or tcp://10.0.0.5:2376 */
+  host?: string;
+  /** Named docker context or podman connection */
+  context?: string;
 }
 
 export interface AgentNamespaceConfig {
@@ -20,14 +64,12 @@ export interface AgentNamespaceConfig {
   [key: string]: unknown;
 }
 
-const DEFAULT_CONFIG_PATH = join(homedir(), ".config", "single-file-agents", "config.json");
-
 /**
  * Discover the config file path.
- * Priority: SFA_CONFIG env var → ~/.config/single-file-agents/config.json
+ * Priority: SFA_CONFIG env var → config.json in configDir()
  */
 export function getConfigPath(): string {
-  return process.env.SFA_CONFIG ?? DEFAULT_CONFIG_PATH;
+  return process.env.SFA_CONFIG ?? join(configDir(), "config.json");
 }
 
 /**
@@ -108,6 +150,8 @@ export function applyEnvOverrides(config: SfaConfig): SfaConfig {
     const protocolVars = [
       "SFA_CONFIG", "SFA_DEPTH", "SFA_MAX_DEPTH", "SFA_CALL_CHAIN",
       "SFA_SESSION_ID", "SFA_LOG_FILE", "SFA_NO_LOG", "SFA_CONTEXT_STORE",
+      "SFA_CONTEXT_STORE_URL", "SFA_CONTEXT_STORE_TOKEN", "SFA_CONTEXT_STORE_ACCESS_KEY_ID",
+      "SFA_CONTEXT_STORE_SECRET_ACCESS_KEY", "SFA_CONTEXT_STORE_SESSION_TOKEN",
     ];
     if (protocolVars.includes(key)) continue;
 
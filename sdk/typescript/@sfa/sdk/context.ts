@@ -1,10 +1,37 @@
-import { join, relative } from "node:path";
-import { mkdirSync, readdirSync, readFileSync, writeFileSync } from "node:fs";
-import { homedir } from "node:os";
-import type { SfaConfig } from "./config";
-import type { ContextEntry, ContextType, WriteContextInput, SearchContextInput } from "./types";
-
-const DEFAULT_CONTEXT_DIR = join(homedir(), ".local", "share", "single-file-agents", "context");
+import { Database } from "bun:sqlite";
+import { dirname, isAbsolute, join, posix, relative, resolve, sep } from "node:path";
+import { createCipheriv, createDecipheriv, createHash, createHmac, randomBytes } from "node:crypto";
+import { gunzipSync, gzipSync } from "node:zlib";
+import {
+  existsSync,
+  mkdirSync,
+  readdirSync,
+  readFileSync,
+  renameSync,
+  rmSync,
+  statSync,
+  utimesSync,
+  writeFileSync,
+} from "node:fs";
+import type { ContextLimit, ContextQuota, SfaConfig } from "./config";
+import { stderrLog } from "./output";
+import { dataDir } from "./paths";
+import type {
+  ContextAttachment,
+  ContextEdge,
+  ContextEntry,
+  ContextExportOptions,
+  ContextGraph,
+  ContextNode,
+  ContextSchema,
+  ContextSession,
+  ContextType,
+  ExecuteContext,
+  SummarizeSessionOptions,
+  WriteContextInput,
+  UpdateContextInput,
+  SearchContextInput,
+} from "./types";
 
 /**
  * Resolve the context store root path.
@@ -14,7 +41,7 @@ export function resolveContextStorePath(config: SfaConfig): string {
   return (
     process.env.SFA_CONTEXT_STORE ??
     config.contextStore?.path ??
-    DEFAULT_CONTEXT_DIR
+    dataDir("context")
   );
 }
 
@@ -26,6 +53,69 @@ function compactTimestamp(date: Date = new Date()): string {
   return date.toISOString().replace(/[-:]/g, "").replace(/\.\d{3}Z$/, "").slice(0, 15);
 }
 
+/** The nonces tried for an entry's file name before a write gives up. */
+const CONTEXT_NAME_ATTEMPTS = 10;
+
+/**
+ * The file name of an entry written at now: <timestamp>-<slug>.md, then, as
+ * attempt counts the names already taken, <timestamp>-<slug>-<nonce>.md, so
+ * entries written in the same second with the same slug never overwrite
+ * each other.
+ */
+function contextEntryName(now: Date, slug: string, attempt: number): string {
+  if (attempt === 0) return `${compactTimestamp(now)}-${slug}.md`;
+  return `${compactTimestamp(now)}-${slug}-${randomBytes(3).toString("hex")}.md`;
+}
+
+/** Frontmatter keys the store sets; any other key is the entry's metadata. */
+const CONTEXT_FRONTMATTER_KEYS = ["agent", "sessionId", "timestamp", "type", "tags", "links", "attachments"];
+
+/**
+ * Format a YAML scalar: a string plain unless it would read back as
+ * something else, quoted as JSON otherwise.
+ */
+function yamlScalar(v: unknown): string {
+  if (typeof v !== "string") return JSON.stringify(v ?? null);
+  try {
+    if (v === v.trim() && !/["'\n\t]/.test(v) && Bun.YAML.parse(v) === v) return v;
+  } catch {
+    // Not a plain scalar
+  }
+  return JSON.stringify(v);
+}
+
+/** The lines of a YAML block mapping with sorted keys. */
+function yamlMapLines(m: Record<string, unknown>, indent: number): string[] {
+  const pad = " ".repeat(indent);
+  return Object.keys(m)
+    .sort()
+    .flatMap((key) => yamlValueLines(`${pad}${yamlScalar(key)}:`, m[key], indent));
+}
+
+/** The lines of a "key:" or "-" line and the value after it. */
+function yamlValueLines(head: string, v: unknown, indent: number): string[] {
+  if (Array.isArray(v)) {
+    if (v.length === 0) return [`${head} []`];
+    return [head, ...v.flatMap((item) => yamlValueLines(`${" ".repeat(indent + 2)}-`, item, indent + 2))];
+  }
+  if (v !== null && typeof v === "object") {
+    const m = v as Record<string, unknown>;
+    if (Object.keys(m).length === 0) return [`${head} {}`];
+    return [head, ...yamlMapLines(m, indent + 2)];
+  }
+  return [`${head} ${yamlScalar(v)}`];
+}
+
+/**
+ * Metadata as the JSON values YAML is written from, without the keys the
+ * store sets.
+ */
+function contextMetadataValues(metadata: Record<string, unknown> | undefined): Record<string, unknown> {
+  const values: Record<string, unknown> = metadata ? JSON.parse(JSON.stringify(metadata)) : {};
+  for (const key of CONTEXT_FRONTMATTER_KEYS) delete values[key];
+  return values;
+}
+
 /**
  * Generate YAML frontmatter from metadata.
  */
@@ -36,17 +126,19 @@ function generateFrontmatter(meta: {
   type: ContextType;
   tags?: string[];
   links?: string[];
+  attachments?: string[];
+  metadata?: Record<string, unknown>;
 }): string {
   const lines: string[] = ["---"];
-  lines.push(`agent: ${meta.agent}`);
+  lines.push(`agent: ${yamlScalar(meta.agent)}`);
   if (meta.sessionId) {
-    lines.push(`sessionId: ${meta.sessionId}`);
+    lines.push(`sessionId: ${yamlScalar(meta.sessionId)}`);
   }
-  lines.push(`timestamp: ${meta.timestamp}`);
-  lines.push(`type: ${meta.type}`);
+  lines.push(`timestamp: ${yamlScalar(meta.timestamp)}`);
+  lines.push(`type: ${yamlScalar(meta.type)}`);
 
   if (meta.tags && meta.tags.length > 0) {
-    lines.push(`tags: [${meta.tags.join(", ")}]`);
+    lines.push(`tags: [${meta.tags.map(yamlScalar).join(", ")}]`);
   } else {
     lines.push("tags: []");
   }
@@ -54,81 +146,182 @@ function generateFrontmatter(meta: {
   if (meta.links && meta.links.length > 0) {
     lines.push("links:");
     for (const link of meta.links) {
-      lines.push(`  - "${link}"`);
+      lines.push(`  - ${JSON.stringify(link)}`);
     }
   }
 
+  if (meta.attachments && meta.attachments.length > 0) {
+    lines.push("attachments:");
+    for (const name of meta.attachments) {
+      lines.push(`  - ${yamlScalar(name)}`);
+    }
+  }
+
+  lines.push(...yamlMapLines(contextMetadataValues(meta.metadata), 0));
   lines.push("---");
   return lines.join("\n");
 }
 
 /**
  * Parse YAML frontmatter from a context file.
- * Returns the parsed metadata and the body content.
+ * Returns the parsed metadata and the body content, or null if the file
+ * has no frontmatter or it is not a YAML mapping.
  */
 function parseFrontmatter(text: string): { meta: Record<string, unknown>; body: string } | null {
+  text = text.replace(/\r\n/g, "\n");
   if (!text.startsWith("---\n")) return null;
-  const endIdx = text.indexOf("\n---\n", 4);
+  const endIdx = text.indexOf("\n---\n", 3);
   if (endIdx < 0) return null;
 
-  const yamlBlock = text.slice(4, endIdx);
+  const yamlBlock = text.slice(4, Math.max(endIdx, 4));
   const body = text.slice(endIdx + 5);
-  const meta: Record<string, unknown> = {};
-
-  for (const line of yamlBlock.split("\n")) {
-    const colonIdx = line.indexOf(":");
-    if (colonIdx < 0) continue;
-    const key = line.slice(0, colonIdx).trim();
-    let value: unknown = line.slice(colonIdx + 1).trim();
-
-    // Parse arrays: [item1, item2] or multi-line
-    if (typeof value === "string" && (value as string).startsWith("[")) {
-      const inner = (value as string).slice(1, -1);
-      value = inner
-        ? inner.split(",").map((s) => s.trim().replace(/^"|"$/g, ""))
-        : [];
+  let meta: unknown;
+  try {
+    meta = Bun.YAML.parse(yamlBlock) ?? {};
+  } catch {
+    return null;
+  }
+  if (typeof meta !== "object" || Array.isArray(meta)) return null;
+  return { meta: meta as Record<string, unknown>, body };
+}
+
+/** A frontmatter field as a string, so a session ID of digits is not a number. */
+function frontmatterString(v: unknown): string {
+  return v === null || v === undefined ? "" : String(v);
+}
+
+/** A frontmatter list field, a lone scalar being a list of one. */
+function frontmatterList(v: unknown): string[] {
+  if (Array.isArray(v)) return v.map(frontmatterString);
+  const s = frontmatterString(v);
+  return s ? [s] : [];
+}
+
+/** Why an entry was refused by writeContext, updateContext, or replaceTags. */
+export class ContextEntryError extends Error {
+  constructor(
+    readonly field: "slug" | "type" | "content" | "tags" | "metadata" | "attachments",
+    readonly reason: string,
+  ) {
+    super(`invalid context entry: ${field} ${reason}`);
+    this.name = "ContextEntryError";
+  }
+}
+
+/** Keeps slugs to a safe file name. */
+const CONTEXT_SLUG_PATTERN = /^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$/;
+/** Keeps tags lowercase and free of the characters frontmatter lists and searches split on. */
+const CONTEXT_TAG_PATTERN = /^[a-z0-9][a-z0-9._:/-]{0,63}$/;
+const CONTEXT_TYPES: ContextType[] = ["finding", "decision", "artifact", "reference", "summary"];
+
+function validateContextType(type: string): void {
+  if (!CONTEXT_TYPES.includes(type as ContextType)) {
+    throw new ContextEntryError("type", `"${type}" is not one of finding, decision, artifact, reference, or summary`);
+  }
+}
+
+/** Check tags against the tag naming pattern. */
+export function validateContextTags(tags: string[] = []): void {
+  for (const tag of tags) {
+    if (!CONTEXT_TAG_PATTERN.test(tag)) {
+      throw new ContextEntryError("tags", `"${tag}" must be lowercase letters, digits, and . _ : / -, at most 64 characters`);
+    }
+  }
+}
+
+/** Refuse metadata under the keys the store sets, and values JSON cannot hold. */
+export function validateContextMetadata(metadata: Record<string, unknown> = {}): void {
+  for (const [key, value] of Object.entries(metadata)) {
+    if (!key || CONTEXT_FRONTMATTER_KEYS.includes(key)) {
+      throw new ContextEntryError("metadata", `key "${key}" must not be empty nor one of ${CONTEXT_FRONTMATTER_KEYS.join(", ")}`);
+    }
+    try {
+      JSON.stringify(value);
+    } catch (err) {
+      throw new ContextEntryError("metadata", `"${key}" must be JSON-like: ${(err as Error).message}`);
+    }
+  }
+}
+
+/**
+ * Check the names of an entry's attachments, and that each gives a path
+ * or bytes. Names ending in .md are refused, as the store would read them
+ * as entries.
+ */
+function validateContextAttachments(attachments: ContextAttachment[] = []): void {
+  const seen = new Set<string>();
+  for (const a of attachments) {
+    if (!CONTEXT_SLUG_PATTERN.test(a.name ?? "") || a.name.toLowerCase().endsWith(".md")) {
+      throw new ContextEntryError(
+        "attachments",
+        `"${a.name ?? ""}" must be letters, digits, and . _ -, starting with a letter or digit, at most 100 characters, and not end in .md`,
+      );
     }
+    if (seen.has(a.name)) throw new ContextEntryError("attachments", `"${a.name}" is given twice`);
+    seen.add(a.name);
+    if (!a.path === !a.bytes) throw new ContextEntryError("attachments", `"${a.name}" must give one of path or bytes`);
+  }
+}
+
+/** Whether content has a heading, at any level, whose text is title, ignoring case. */
+function hasMarkdownHeading(content: string, title: string): boolean {
+  return content
+    .split("\n")
+    .some((line) => /^#+ /.test(line) && line.replace(/^#+ /, "").trim().toLowerCase() === title.toLowerCase());
+}
 
-    meta[key] = value;
+/** Check an entry before it is written, against the schema for its type if there is one. */
+export function validateContextEntry(input: WriteContextInput, schemas: Partial<Record<ContextType, ContextSchema>> = {}): void {
+  if (!CONTEXT_SLUG_PATTERN.test(input.slug ?? "")) {
+    throw new ContextEntryError(
+      "slug",
+      `"${input.slug ?? ""}" must be letters, digits, and . _ -, starting with a letter or digit, at most 100 characters`,
+    );
   }
+  validateContextType(input.type);
+  validateContextTags(input.tags);
+  validateContextMetadata(input.metadata);
+  validateContextAttachments(input.attachments);
+  if (!input.content?.trim()) throw new ContextEntryError("content", "is empty");
 
-  // Handle multi-line links
-  if (meta.links === undefined) {
-    const linksMatch = yamlBlock.match(/^links:\s*$/m);
-    if (linksMatch) {
-      const links: string[] = [];
-      const afterLinks = yamlBlock.slice(yamlBlock.indexOf("links:") + 6);
-      for (const l of afterLinks.split("\n")) {
-        const trimmed = l.trim();
-        if (trimmed.startsWith("- ")) {
-          links.push(trimmed.slice(2).replace(/^"|"$/g, ""));
-        } else if (trimmed && !trimmed.includes(":")) {
-          continue;
-        } else {
-          break;
-        }
-      }
-      meta.links = links;
+  const schema = schemas[input.type];
+  if (!schema) return;
+  for (const section of schema.sections ?? []) {
+    if (!hasMarkdownHeading(input.content, section)) {
+      throw new ContextEntryError("content", `has no "${section}" section, which ${input.type} entries need`);
     }
   }
+  try {
+    schema.validate?.(input.content);
+  } catch (err) {
+    throw new ContextEntryError("content", err instanceof Error ? err.message : String(err));
+  }
+}
 
-  return { meta, body };
+/** Check the type, tags, and metadata an update sets. Schemas apply only as entries are written. */
+export function validateContextUpdate(input: UpdateContextInput): void {
+  if (input.type) validateContextType(input.type);
+  validateContextTags(input.tags);
+  validateContextMetadata(input.metadata);
 }
 
 /**
  * Write a context entry to the store.
- * Returns the absolute file path of the written entry.
+ * Returns the absolute file path of the written entry. With a quota, older
+ * entries are first evicted to make room, or the write is refused (see
+ * enforceContextQuota). With a retention (see resolveContextRetention), the
+ * store is then pruned, at most hourly.
  */
 export function writeContext(
   input: WriteContextInput,
   agentName: string,
   sessionId: string | undefined,
   storePath: string,
+  retention?: Record<string, number>,
+  quota?: ContextQuota,
 ): string {
   const now = new Date();
   const timestamp = now.toISOString();
-  const compact = compactTimestamp(now);
-  const filename = `${compact}-${input.slug}.md`;
 
   // Determine directory: agent/session/ or agent/
   let dir: string;
@@ -140,7 +333,6 @@ export function writeContext(
 
   mkdirSync(dir, { recursive: true });
 
-  const filePath = join(dir, filename);
   const frontmatter = generateFrontmatter({
     agent: agentName,
     sessionId,
@@ -148,201 +340,979 @@ export function writeContext(
     type: input.type,
     tags: input.tags,
     links: input.links,
+    attachments: input.attachments?.map((a) => a.name),
+    metadata: input.metadata,
   });
 
   const content = frontmatter + "\n" + input.content + "\n";
-  writeFileSync(filePath, content);
+  const attachments = (input.attachments ?? []).map((a) => ({ name: a.name, bytes: contextAttachmentBytes(a) }));
+  const size = attachments.reduce((n, a) => n + a.bytes.length, Buffer.byteLength(content));
+  if (quota) enforceContextQuota(storePath, agentName, sessionId, size, quota);
+  // Create the file exclusively, with a nonce if another entry took the
+  // name first
+  let filePath: string;
+  for (let attempt = 0; ; attempt++) {
+    filePath = join(dir, contextEntryName(now, input.slug, attempt));
+    try {
+      writeFileSync(filePath, content, { flag: "wx" });
+      break;
+    } catch (err) {
+      if ((err as NodeJS.ErrnoException).code !== "EEXIST" || attempt >= CONTEXT_NAME_ATTEMPTS) throw err;
+    }
+  }
+  if (attachments.length > 0) {
+    try {
+      const attachmentDir = contextAttachmentDir(filePath);
+      mkdirSync(attachmentDir, { recursive: true });
+      for (const a of attachments) writeFileSync(join(attachmentDir, a.name), a.bytes);
+    } catch (err) {
+      removeContextEntryFile(filePath, true);
+      throw err;
+    }
+  }
+  indexContextFile(filePath);
+  if (retention) maybePruneContextStore(storePath, retention, now);
 
   return filePath;
 }
 
 /**
- * Search the context store for entries matching the given criteria.
- * Uses file system scanning and text matching (no external dependencies).
+ * Entry types in the order quotas evict them: lower goes first. Types not
+ * listed go before all of them.
  */
-export function searchContext(
-  query: SearchContextInput,
+const CONTEXT_EVICTION_PRIORITY: Record<string, number> = {
+  reference: 1,
+  artifact: 2,
+  finding: 3,
+  decision: 4,
+  summary: 5,
+};
+
+/** An entry on disk, as quotas count it. */
+interface ContextFile {
+  path: string;
+  type: string;
+  written: number;
+  size: number;
+}
+
+/** The entries under dir, which need not exist. */
+function scanContextFiles(dir: string): ContextFile[] {
+  const files: ContextFile[] = [];
+  let dirents;
+  try {
+    dirents = readdirSync(dir, { withFileTypes: true });
+  } catch {
+    return files;
+  }
+  for (const d of dirents) {
+    const path = join(dir, d.name);
+    if (d.isDirectory()) {
+      files.push(...scanContextFiles(path));
+    } else if (d.name.endsWith(".md")) {
+      try {
+        const stat = statSync(path);
+        const entry = parseContextFile(path);
+        const written = Date.parse(entry?.timestamp ?? "");
+        files.push({
+          path,
+          type: entry?.type ?? "",
+          written: Number.isNaN(written) ? stat.mtimeMs : written,
+          size: stat.size + contextAttachmentsSize(path),
+        });
+      } catch {
+        // Removed while scanning
+      }
+    }
+  }
+  return files;
+}
+
+/**
+ * The files to remove so that one more entry of size bytes fits limit: the
+ * lowest priority types first, oldest first within a type. Throws if the
+ * entry alone does not fit.
+ */
+function contextEvictions(files: ContextFile[], limit: ContextLimit, size: number): ContextFile[] {
+  const maxEntries = limit.maxEntries ?? 0;
+  const maxBytes = limit.maxBytes ?? 0;
+  if (maxBytes > 0 && size > maxBytes) {
+    throw new Error(`entry of ${size} bytes is larger than maxBytes ${maxBytes}`);
+  }
+  let entries = files.length + 1;
+  let bytes = files.reduce((n, f) => n + f.size, size);
+  const order = [...files].sort(
+    (a, b) =>
+      (CONTEXT_EVICTION_PRIORITY[a.type] ?? 0) - (CONTEXT_EVICTION_PRIORITY[b.type] ?? 0) || a.written - b.written,
+  );
+  const evict: ContextFile[] = [];
+  for (const f of order) {
+    if ((maxEntries <= 0 || entries <= maxEntries) && (maxBytes <= 0 || bytes <= maxBytes)) break;
+    evict.push(f);
+    entries--;
+    bytes -= f.size;
+  }
+  return evict;
+}
+
+/**
+ * Make room for an entry of size bytes that agentName is about to write in
+ * sessionId, evicting entries as the quota allows. Throws a "context store
+ * quota exceeded" error if the entry must not be written.
+ */
+export function enforceContextQuota(
   storePath: string,
-): ContextEntry[] {
-  const results: ContextEntry[] = [];
+  agentName: string,
+  sessionId: string | undefined,
+  size: number,
+  quota: ContextQuota,
+): void {
+  const isSet = (l?: ContextLimit) => (l?.maxEntries ?? 0) > 0 || (l?.maxBytes ?? 0) > 0;
+  const agentDir = join(storePath, agentName);
+  const scopes: { name: string; dir: string; limit: ContextLimit }[] = [];
+  // The session first: what it evicts counts toward the agent's usage too
+  if (sessionId && isSet(quota.session)) {
+    scopes.push({ name: `session ${sessionId}`, dir: join(agentDir, sessionId), limit: quota.session! });
+  }
+  if (isSet(quota.agent)) {
+    scopes.push({ name: `agent ${agentName}`, dir: agentDir, limit: quota.agent! });
+  }
 
-  // Determine which directories to scan
-  let agentDirs: string[];
-  if (query.agent) {
-    const agentDir = join(storePath, query.agent);
+  for (const scope of scopes) {
+    const files = scanContextFiles(scope.dir);
+    let evict: ContextFile[];
     try {
-      readdirSync(agentDir);
-      agentDirs = [agentDir];
-    } catch {
-      return [];
+      evict = contextEvictions(files, scope.limit, size);
+    } catch (err) {
+      throw new Error(`context store quota exceeded: ${scope.name}: ${(err as Error).message}`);
     }
-  } else {
-    try {
-      agentDirs = readdirSync(storePath, { withFileTypes: true })
-        .filter((d) => d.isDirectory())
-        .map((d) => join(storePath, d.name));
-    } catch {
-      return [];
+    if (evict.length === 0) continue;
+    if (quota.onExceeded === "reject") {
+      const limits = [
+        scope.limit.maxEntries ? `maxEntries ${scope.limit.maxEntries}` : "",
+        scope.limit.maxBytes ? `maxBytes ${scope.limit.maxBytes}` : "",
+      ].filter(Boolean);
+      const bytes = files.reduce((n, f) => n + f.size, 0);
+      throw new Error(
+        `context store quota exceeded: ${scope.name} holds ${files.length} entries (${bytes} bytes); the limit is ${limits.join(", ")}`,
+      );
     }
+    for (const f of evict) removeContextEntryFile(f.path, true);
+    unindexContextFiles(storePath, evict.map((f) => f.path));
   }
+}
 
-  for (const agentDir of agentDirs) {
-    scanDirectory(agentDir, storePath, query, results);
-  }
-
-  // Sort by timestamp descending (most recent first)
-  results.sort((a, b) => b.timestamp.localeCompare(a.timestamp));
+/** How often writes prune a context store, and the file whose mtime records the last pass. */
+const CONTEXT_PRUNE_INTERVAL_MS = 60 * 60 * 1000;
+const CONTEXT_PRUNE_MARKER = ".last-prune";
 
-  return results;
+/**
+ * Parse a retention period: "forever", a number of days such as "30d", or a
+ * Go-style duration such as "12h" or "1h30m". Returns milliseconds; forever
+ * is 0.
+ */
+function parseRetention(v: string): number {
+  if (v === "forever") return 0;
+  const days = /^(\d+)d$/.exec(v);
+  if (days && Number(days[1]) > 0) return Number(days[1]) * 24 * 60 * 60 * 1000;
+  const units: Record<string, number> = { ms: 1, s: 1000, m: 60_000, h: 3_600_000 };
+  const parts = [...v.matchAll(/(\d+(?:\.\d+)?)(ms|s|m|h)/g)];
+  if (!days && parts.length > 0 && parts.map((p) => p[0]).join("") === v) {
+    const ms = parts.reduce((n, p) => n + Number(p[1]) * units[p[2]], 0);
+    if (ms > 0) return ms;
+  }
+  throw new Error(`invalid retention "${v}"`);
 }
 
 /**
- * Recursively scan a directory for context files matching the query.
+ * contextStore.retention: how long entries of each type are kept, in
+ * milliseconds, with "default" for types not listed. Invalid periods are
+ * warned about and ignored, keeping those entries.
  */
-function scanDirectory(
-  dir: string,
-  storePath: string,
-  query: SearchContextInput,
-  results: ContextEntry[],
-): void {
-  let entries;
-  try {
-    entries = readdirSync(dir, { withFileTypes: true });
-  } catch {
-    return;
+export function resolveContextRetention(config: SfaConfig): Record<string, number> {
+  const retention: Record<string, number> = {};
+  for (const [key, v] of Object.entries(config.contextStore?.retention ?? {})) {
+    try {
+      retention[key] = parseRetention(String(v));
+    } catch (err) {
+      stderrLog.warn(`ignoring contextStore.retention.${key}: ${(err as Error).message} (use forever, 30d, or 12h)`);
+    }
   }
+  return retention;
+}
 
+/**
+ * Remove the entries of the store that are past their retention, and their
+ * rows in the index. An entry's age is from its frontmatter timestamp, or
+ * without one, its file's modification time. Returns the paths removed.
+ */
+export function pruneContextStore(storePath: string, retention: Record<string, number>, now: Date = new Date()): string[] {
+  if (Object.keys(retention).length === 0) return [];
+  const entries: ContextEntry[] = [];
+  scanDirectory(storePath, storePath, {}, entries);
+  const removed: string[] = [];
   for (const entry of entries) {
-    const fullPath = join(dir, entry.name);
-    if (entry.isDirectory()) {
-      scanDirectory(fullPath, storePath, query, results);
-    } else if (entry.name.endsWith(".md")) {
-      const parsed = parseContextFile(fullPath);
-      if (parsed && matchesQuery(parsed, query)) {
-        results.push(parsed);
+    const keep = retention[entry.type] ?? retention.default ?? 0;
+    let written = Date.parse(entry.timestamp);
+    if (Number.isNaN(written)) {
+      try {
+        written = statSync(entry.filePath).mtimeMs;
+      } catch {
+        continue;
+      }
+    }
+    if (keep > 0 && now.getTime() - written > keep) {
+      try {
+        removeContextEntryFile(entry.filePath);
+        removed.push(entry.filePath);
+      } catch {
+        // Leave it for the next pass
       }
     }
   }
+  if (removed.length > 0) unindexContextFiles(storePath, removed);
+  return removed;
 }
 
 /**
- * Parse a context file into a ContextEntry.
+ * Remove entries from the store's index, or the index itself if they cannot
+ * be removed, so it is rebuilt without them.
  */
-function parseContextFile(filePath: string): ContextEntry | null {
-  let text: string;
+function unindexContextFiles(storePath: string, paths: string[]): void {
+  if (!existsSync(join(storePath, CONTEXT_INDEX_FILE))) return;
   try {
-    text = readFileSync(filePath, "utf-8");
+    const db = openContextIndex(storePath);
+    try {
+      db.transaction(() => {
+        db.exec(CONTEXT_EMBEDDINGS_SCHEMA);
+        for (const path of paths) {
+          const rel = relative(storePath, path).split(sep).join("/");
+          db.query("DELETE FROM entries WHERE path = ?").run(rel);
+          db.query("DELETE FROM entries_fts WHERE path = ?").run(rel);
+          db.query("DELETE FROM embeddings WHERE path = ?").run(rel);
+        }
+      })();
+    } finally {
+      db.close();
+    }
   } catch {
-    return null;
+    // Rebuilt without them on the next search
+    rmSync(join(storePath, CONTEXT_INDEX_FILE), { force: true });
   }
+}
 
-  const parsed = parseFrontmatter(text);
-  if (!parsed) return null;
+/** Prune the store after a write, at most once per CONTEXT_PRUNE_INTERVAL_MS. */
+function maybePruneContextStore(storePath: string, retention: Record<string, number>, now: Date): void {
+  if (Object.keys(retention).length === 0) return;
+  const marker = join(storePath, CONTEXT_PRUNE_MARKER);
+  try {
+    if (now.getTime() - statSync(marker).mtimeMs < CONTEXT_PRUNE_INTERVAL_MS) return;
+  } catch {
+    // No pass yet
+  }
+  try {
+    writeFileSync(marker, "");
+    utimesSync(marker, now, now);
+    pruneContextStore(storePath, retention, now);
+  } catch (err) {
+    stderrLog.warn(`failed to prune context store: ${(err as Error).message}`);
+  }
+}
 
-  const { meta, body } = parsed;
-  return {
-    filePath,
-    agent: String(meta.agent ?? ""),
-    sessionId: meta.sessionId ? String(meta.sessionId) : undefined,
-    timestamp: String(meta.timestamp ?? ""),
-    type: String(meta.type ?? "finding") as ContextType,
-    tags: Array.isArray(meta.tags) ? meta.tags.map(String) : [],
-    links: Array.isArray(meta.links) ? meta.links.map(String) : [],
-    content: body.trim(),
-  };
+/**
+ * SQLite index of the store's entries, at its root, so searches need not
+ * read every file. The markdown files stay the source of truth: the index
+ * is rebuilt from them when it is missing or unreadable. entries_fts holds
+ * their content for relevance searches to rank with bm25. tags, links, and
+ * attachment names are stored one per line, with tags wrapped in newlines
+ * so a tag can be matched exactly with instr; metadata is JSON, "" if there
+ * is none.
+ */
+const CONTEXT_INDEX_FILE = ".index.db";
+const CONTEXT_INDEX_SCHEMA = `CREATE TABLE IF NOT EXISTS entries (
+  path TEXT PRIMARY KEY,
+  agent TEXT NOT NULL,
+  session TEXT NOT NULL,
+  type TEXT NOT NULL,
+  tags TEXT NOT NULL,
+  links TEXT NOT NULL,
+  attachments TEXT NOT NULL,
+  timestamp TEXT NOT NULL,
+  metadata TEXT NOT NULL,
+  content TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS entries_agent ON entries (agent, timestamp);
+CREATE VIRTUAL TABLE IF NOT EXISTS entries_fts USING fts5 (path UNINDEXED, content);`;
+
+function openContextIndex(storePath: string): Database {
+  const db = new Database(join(storePath, CONTEXT_INDEX_FILE));
+  db.exec("PRAGMA busy_timeout = 5000");
+  return db;
+}
+
+/** Add or replace an entry, its path relative to the store root. */
+function insertContextEntry(db: Database, storePath: string, entry: ContextEntry): void {
+  const path = relative(storePath, entry.filePath).split(sep).join("/");
+  db.query("INSERT OR REPLACE INTO entries VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)").run(
+    path,
+    entry.agent,
+    entry.sessionId ?? "",
+    entry.type,
+    entry.tags.length > 0 ? `\n${entry.tags.join("\n")}\n` : "",
+    entry.links.join("\n"),
+    (entry.attachments ?? []).map((a) => a.name).join("\n"),
+    entry.timestamp,
+    entry.metadata ? JSON.stringify(entry.metadata) : "",
+    entry.content,
+  );
+  db.query("DELETE FROM entries_fts WHERE path = ?").run(path);
+  db.query("INSERT INTO entries_fts VALUES (?, ?)").run(path, entry.content);
 }
 
 /**
- * Check if a context entry matches the search query.
+ * Add a written or changed entry to the index of the store it is in, found
+ * as the nearest parent directory holding one. Without an index there is
+ * nothing to do: the first search builds it from the files. If the entry
+ * cannot be added, e.g. to an index from an older SDK that lacks a table,
+ * the index is removed, so that it is rebuilt instead of missing the entry.
  */
-function matchesQuery(entry: ContextEntry, query: SearchContextInput): boolean {
-  if (query.agent && entry.agent !== query.agent) return false;
-  if (query.type && entry.type !== query.type) return false;
-  if (query.tags && query.tags.length > 0) {
-    const hasMatchingTag = query.tags.some((t) => entry.tags.includes(t));
-    if (!hasMatchingTag) return false;
+function indexContextFile(filePath: string): void {
+  let storePath = dirname(filePath);
+  while (!existsSync(join(storePath, CONTEXT_INDEX_FILE))) {
+    const parent = dirname(storePath);
+    if (parent === storePath) return;
+    storePath = parent;
   }
-  if (query.query) {
-    const lowerQuery = query.query.toLowerCase();
-    const inContent = entry.content.toLowerCase().includes(lowerQuery);
-    const inTags = entry.tags.some((t) => t.toLowerCase().includes(lowerQuery));
-    if (!inContent && !inTags) return false;
+  try {
+    const entry = parseContextFile(filePath);
+    if (!entry) throw new Error(`Invalid context file format: ${filePath}`);
+    const db = openContextIndex(storePath);
+    try {
+      db.transaction(() => insertContextEntry(db, storePath, entry))();
+    } finally {
+      db.close();
+    }
+  } catch {
+    rmSync(join(storePath, CONTEXT_INDEX_FILE), { force: true });
   }
-  return true;
 }
 
 /**
- * Update an existing context entry: replace content and append a changelog entry.
+ * Recreate the store's index from its markdown files. The files are read
+ * holding the index's write lock, so entries written meanwhile are indexed
+ * either by the rebuild or after it.
  */
-export function updateContext(
-  filePath: string,
-  newContent: string,
-  agentName: string,
-  description: string,
-): void {
-  let text: string;
+function rebuildContextIndex(storePath: string): void {
+  const build = () => {
+    const db = openContextIndex(storePath);
+    try {
+      db.transaction(() => {
+        const entries: ContextEntry[] = [];
+        scanDirectory(storePath, storePath, {}, entries);
+        db.exec("DROP TABLE IF EXISTS entries");
+        db.exec("DROP TABLE IF EXISTS entries_fts");
+        db.exec(CONTEXT_INDEX_SCHEMA);
+        for (const entry of entries) insertContextEntry(db, storePath, entry);
+        // Embeddings are kept, as they are costly to compute, but not for
+        // entries that are gone
+        db.exec(CONTEXT_EMBEDDINGS_SCHEMA);
+        db.exec("DELETE FROM embeddings WHERE path NOT IN (SELECT path FROM entries)");
+      }).immediate();
+    } finally {
+      db.close();
+    }
+  };
   try {
-    text = readFileSync(filePath, "utf-8");
+    build();
   } catch {
-    throw new Error(`Context file not found: ${filePath}`);
+    // A corrupt index cannot be rebuilt in place; start over once
+    rmSync(join(storePath, CONTEXT_INDEX_FILE), { force: true });
+    build();
   }
+}
 
-  const parsed = parseFrontmatter(text);
-  if (!parsed) {
-    throw new Error(`Invalid context file format: ${filePath}`);
+/** Add the WHERE conditions on entries e for a query's agent, session, type, tags, and time range. */
+function contextIndexFilters(query: SearchContextInput, where: string[], params: (string | number)[]): void {
+  if (query.agent) {
+    where.push("e.agent = ?");
+    params.push(query.agent);
   }
-
-  // Reconstruct the file: frontmatter + new content + changelog
-  const frontmatterEnd = text.indexOf("\n---\n", 4);
-  const frontmatter = text.slice(0, frontmatterEnd + 5);
-
-  // Check if there's already a changelog section
-  const changelogIdx = text.indexOf("\n## Changelog\n");
-  let existingChangelog = "";
-  if (changelogIdx >= 0) {
-    existingChangelog = text.slice(changelogIdx + "\n## Changelog\n".length);
+  if (query.sessionId) {
+    where.push("e.session = ?");
+    params.push(query.sessionId);
+  }
+  if (query.type) {
+    where.push("e.type = ?");
+    params.push(query.type);
   }
+  if (query.tags && query.tags.length > 0) {
+    where.push(`(${query.tags.map(() => "instr(e.tags, ?) > 0").join(query.allTags ? " AND " : " OR ")})`);
+    params.push(...query.tags.map((t) => `\n${t}\n`));
+  }
+  // julianday compares timestamps with and without fractional seconds
+  if (query.since) {
+    where.push("julianday(e.timestamp) >= julianday(?)");
+    params.push(new Date(query.since).toISOString());
+  }
+  if (query.until) {
+    where.push("julianday(e.timestamp) < julianday(?)");
+    params.push(new Date(query.until).toISOString());
+  }
+}
+
+type ContextIndexRow = {
+  path: string;
+  agent: string;
+  session: string;
+  type: string;
+  tags: string;
+  links: string;
+  attachments: string;
+  timestamp: string;
+  metadata: string;
+  content: string;
+};
 
-  const timestamp = new Date().toISOString();
-  const changelogEntry = `- ${timestamp} [${agentName}]: ${description}`;
+/** The entry of an index row in the store at storePath. */
+function contextIndexEntry(storePath: string, row: ContextIndexRow): ContextEntry {
+  const filePath = join(storePath, ...row.path.split("/"));
+  return {
+    filePath,
+    agent: row.agent,
+    sessionId: row.session || undefined,
+    timestamp: row.timestamp,
+    type: row.type as ContextType,
+    tags: row.tags.split("\n").filter(Boolean),
+    links: row.links ? row.links.split("\n") : [],
+    ...(row.attachments ? { attachments: contextAttachments(filePath, row.attachments.split("\n")) } : {}),
+    ...(row.metadata ? { metadata: JSON.parse(row.metadata) } : {}),
+    content: row.content,
+  };
+}
 
-  let updatedFile = frontmatter + "\n" + newContent + "\n";
-  updatedFile += "\n## Changelog\n";
-  if (existingChangelog) {
-    updatedFile += existingChangelog.trimEnd() + "\n";
+/**
+ * Answer a query from the store's index, newest entries first, or with
+ * sort "relevance", those with any word of the query ranked by bm25.
+ */
+function searchContextIndex(query: SearchContextInput, storePath: string): ContextEntry[] {
+  const where: string[] = [];
+  const params: (string | number)[] = [];
+  let from = "entries e";
+  let order = "e.timestamp DESC";
+  const terms = contextTerms(query.query ?? "");
+  if (query.sort === "relevance" && terms.length > 0) {
+    // Quoted, each word is matched as is rather than as FTS5 syntax
+    from += " JOIN entries_fts ON entries_fts.path = e.path";
+    where.push("entries_fts MATCH ?");
+    params.push(terms.map((t) => `"${t}"`).join(" OR "));
+    order = "bm25(entries_fts), e.timestamp DESC";
+  }
+  contextIndexFilters(query, where, params);
+  if (query.query && order === "e.timestamp DESC") {
+    where.push("(instr(lower(e.content), lower(?)) > 0 OR instr(lower(e.tags), lower(?)) > 0)");
+    params.push(query.query, query.query);
+  }
+  let sql =
+    `SELECT e.path, e.agent, e.session, e.type, e.tags, e.links, e.attachments, e.timestamp, e.metadata, e.content FROM ${from}` +
+    (where.length > 0 ? ` WHERE ${where.join(" AND ")}` : "") +
+    ` ORDER BY ${order}`;
+  // finishContextResults skips the offset, as it does for the other searches
+  if (query.limit && query.limit > 0) {
+    sql += " LIMIT ?";
+    params.push(Math.max(query.offset ?? 0, 0) + query.limit);
   }
-  updatedFile += changelogEntry + "\n";
 
-  writeFileSync(filePath, updatedFile);
+  const db = new Database(join(storePath, CONTEXT_INDEX_FILE), { readonly: true });
+  try {
+    return (db.query(sql).all(...params) as ContextIndexRow[]).map((row) => contextIndexEntry(storePath, row));
+  } finally {
+    db.close();
+  }
 }
 
 /**
- * Add a link to an existing context entry's frontmatter.
+ * Search the context store for entries matching the given criteria.
+ * Uses the store's SQLite index, building it first if it is missing or
+ * unreadable, and falls back to file system scanning and text matching.
+ * Sort "similarity" ranks by relevance here; see searchContextSimilar.
  */
-export function addContextLink(
-  filePath: string,
-  linkPath: string,
+export function searchContext(
+  query: SearchContextInput,
   storePath: string,
-): void {
-  let text: string;
-  try {
-    text = readFileSync(filePath, "utf-8");
-  } catch {
-    throw new Error(`Context file not found: ${filePath}`);
+): ContextEntry[] {
+  const sort = query.sort ?? "newest";
+  if (sort !== "newest" && sort !== "relevance" && sort !== "similarity") {
+    throw new Error(`Unknown context sort "${sort}" (use newest, relevance, or similarity)`);
   }
-
-  // Compute relative path from the store root
-  const relPath = relative(storePath, linkPath);
-
-  const parsed = parseFrontmatter(text);
-  if (!parsed) {
-    throw new Error(`Invalid context file format: ${filePath}`);
+  if (sort !== "newest" && contextTerms(query.query ?? "").length === 0) {
+    query = { ...query, sort: "newest" };
+  } else if (sort === "similarity") {
+    // Embeddings need the network; searchContextSimilar does that search
+    query = { ...query, sort: "relevance" };
+  }
+
+  if (existsSync(storePath)) {
+    try {
+      if (!existsSync(join(storePath, CONTEXT_INDEX_FILE))) rebuildContextIndex(storePath);
+      try {
+        return finishContextResults(searchContextIndex(query, storePath), query);
+      } catch {
+        rebuildContextIndex(storePath);
+        return finishContextResults(searchContextIndex(query, storePath), query);
+      }
+    } catch {
+      // Fall back to scanning the files
+    }
+  }
+
+  const results: ContextEntry[] = [];
+
+  // Determine which directories to scan
+  let agentDirs: string[];
+  if (query.agent) {
+    const agentDir = join(storePath, query.agent);
+    try {
+      readdirSync(agentDir);
+      agentDirs = [agentDir];
+    } catch {
+      return [];
+    }
+  } else {
+    try {
+      agentDirs = readdirSync(storePath, { withFileTypes: true })
+        .filter((d) => d.isDirectory())
+        .map((d) => join(storePath, d.name));
+    } catch {
+      return [];
+    }
+  }
+
+  // Relevance ranking matches words below instead
+  const filter = query.sort === "relevance" ? { ...query, query: undefined } : query;
+  for (const agentDir of agentDirs) {
+    scanDirectory(agentDir, storePath, filter, results);
+  }
+
+  if (query.sort === "relevance") {
+    return finishContextResults(rankContextResults(results, contextTerms(query.query ?? "")), query);
+  }
+  // Sort by timestamp descending (most recent first)
+  results.sort((a, b) => b.timestamp.localeCompare(a.timestamp));
+
+  return finishContextResults(results, query);
+}
+
+/** BM25 parameters, the defaults SQLite's FTS5 uses as well. */
+const BM25_K1 = 1.2;
+const BM25_B = 0.75;
+
+/** Characters a snippet keeps on each side of the match. */
+const SNIPPET_RADIUS = 80;
+
+/** Split text into lowercase words of letters and digits, as FTS5's default tokenizer does. */
+function contextWords(text: string): string[] {
+  return text.toLowerCase().match(/[\p{L}\p{N}]+/gu) ?? [];
+}
+
+/** The distinct words of a query, in order. */
+function contextTerms(query: string): string[] {
+  return [...new Set(contextWords(query))];
+}
+
+/**
+ * Keep the entries whose content has any of terms and order them by BM25
+ * score, best first, newer first among equals. Document frequencies are
+ * taken over entries, those that passed the other filters.
+ */
+function rankContextResults(entries: ContextEntry[], terms: string[]): ContextEntry[] {
+  const docs = entries.map((entry) => {
+    const words = contextWords(entry.content);
+    const freq = new Map<string, number>();
+    for (const w of words) freq.set(w, (freq.get(w) ?? 0) + 1);
+    return { entry, freq, length: words.length, score: 0 };
+  });
+  if (docs.length === 0) return [];
+  const avg = Math.max(docs.reduce((n, d) => n + d.length, 0) / docs.length, 1);
+  const df = new Map(terms.map((t) => [t, docs.filter((d) => d.freq.has(t)).length]));
+
+  for (const d of docs) {
+    for (const t of terms) {
+      const tf = d.freq.get(t) ?? 0;
+      if (tf === 0) continue;
+      const n = df.get(t) ?? 0;
+      const idf = Math.log(1 + (docs.length - n + 0.5) / (n + 0.5));
+      d.score += (idf * tf * (BM25_K1 + 1)) / (tf + BM25_K1 * (1 - BM25_B + (BM25_B * d.length) / avg));
+    }
+  }
+  return docs
+    .filter((d) => d.score > 0)
+    .sort((a, b) => b.score - a.score || b.entry.timestamp.localeCompare(a.entry.timestamp))
+    .map((d) => d.entry);
+}
+
+/**
+ * The text around the first case-insensitive match of any of needles in
+ * content, on one line, with the match in **bold** and "…" where the content
+ * was cut; undefined if none matches.
+ */
+function contextSnippet(content: string, needles: string[]): string | undefined {
+  const lower = content.toLowerCase();
+  let start = -1;
+  let end = -1;
+  for (const n of needles) {
+    const i = n ? lower.indexOf(n.toLowerCase()) : -1;
+    if (i >= 0 && (start < 0 || i < start)) {
+      start = i;
+      end = i + n.length;
+    }
+  }
+  // Lowercasing can change lengths; give up on the rare text where it did
+  if (start < 0 || lower.length !== content.length) return undefined;
+
+  let from = Math.max(start - SNIPPET_RADIUS, 0);
+  let to = Math.min(end + SNIPPET_RADIUS, content.length);
+  // Cut at whole words
+  if (from > 0) {
+    const i = content.slice(from, start).search(/\s/);
+    if (i >= 0) from += i + 1;
+  }
+  if (to < content.length) {
+    const tail = content.slice(end, to);
+    const i = Math.max(tail.lastIndexOf(" "), tail.lastIndexOf("\n"), tail.lastIndexOf("\t"));
+    if (i >= 0) to = end + i;
+  }
+
+  const snippet = `${content.slice(from, start)}**${content.slice(start, end)}**${content.slice(end, to)}`
+    .split(/\s+/)
+    .filter(Boolean)
+    .join(" ");
+  return `${from > 0 ? "…" : ""}${snippet}${to < content.length ? "…" : ""}`;
+}
+
+/** Add snippets for the query and apply its offset and limit. */
+function finishContextResults(entries: ContextEntry[], query: SearchContextInput): ContextEntry[] {
+  if (query.query) {
+    const needles = query.sort && query.sort !== "newest" ? contextTerms(query.query) : [query.query];
+    for (const entry of entries) {
+      const snippet = contextSnippet(entry.content, needles);
+      if (snippet) entry.snippet = snippet;
+    }
+  }
+  if (query.offset && query.offset > 0) entries = entries.slice(query.offset);
+  return query.limit && query.limit > 0 ? entries.slice(0, query.limit) : entries;
+}
+
+/** Texts one request to the embeddings endpoint carries. */
+const EMBEDDINGS_BATCH = 64;
+
+/**
+ * Embeddings of the context index: one vector per entry, of the model
+ * named, for the content with the hash given, so a changed entry or model
+ * is embedded again. Vectors are little-endian float32s.
+ */
+const CONTEXT_EMBEDDINGS_SCHEMA = `CREATE TABLE IF NOT EXISTS embeddings (
+  path TEXT PRIMARY KEY,
+  model TEXT NOT NULL,
+  hash TEXT NOT NULL,
+  vector BLOB NOT NULL
+);`;
+
+/**
+ * The OpenAI-compatible embeddings endpoint SFA_EMBEDDINGS_URL,
+ * SFA_EMBEDDINGS_MODEL, and SFA_EMBEDDINGS_API_KEY configure, or undefined
+ * without a URL.
+ */
+function embeddingsFromEnv(): { url: string; model: string; apiKey?: string } | undefined {
+  const url = process.env.SFA_EMBEDDINGS_URL;
+  if (!url) return undefined;
+  return { url, model: process.env.SFA_EMBEDDINGS_MODEL ?? "", apiKey: process.env.SFA_EMBEDDINGS_API_KEY || undefined };
+}
+
+/** The embeddings of texts, in order. */
+async function embed(
+  provider: { url: string; model: string; apiKey?: string },
+  texts: string[],
+): Promise<Float32Array[]> {
+  const vectors: Float32Array[] = [];
+  for (let start = 0; start < texts.length; start += EMBEDDINGS_BATCH) {
+    const batch = texts.slice(start, start + EMBEDDINGS_BATCH);
+    let res: Response;
+    try {
+      res = await fetch(provider.url, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          ...(provider.apiKey ? { Authorization: `Bearer ${provider.apiKey}` } : {}),
+        },
+        body: JSON.stringify({ model: provider.model, input: batch }),
+        signal: AbortSignal.timeout(30_000),
+      });
+    } catch (err) {
+      throw new Error(`embeddings endpoint is unavailable: ${err instanceof Error ? err.message : err}`);
+    }
+    if (!res.ok) throw new Error(`embeddings endpoint returned ${res.status} ${res.statusText}`);
+    const body = (await res.json()) as { data?: { index: number; embedding: number[] }[] };
+    const data = body.data ?? [];
+    if (data.length !== batch.length) {
+      throw new Error(`embeddings endpoint returned ${data.length} vectors for ${batch.length} texts`);
+    }
+    data.sort((a, b) => a.index - b.index);
+    for (const d of data) vectors.push(Float32Array.from(d.embedding));
+  }
+  return vectors;
+}
+
+/** Identify the content an embedding is of. */
+function contentHash(content: string): string {
+  return new Bun.CryptoHasher("sha256").update(content).digest("hex").slice(0, 16);
+}
+
+/** The cosine of the angle between a and b, or 0 if their lengths differ or either is zero. */
+function cosineSimilarity(a: Float32Array, b: Float32Array): number {
+  if (a.length !== b.length) return 0;
+  let dot = 0;
+  let na = 0;
+  let nb = 0;
+  for (let i = 0; i < a.length; i++) {
+    dot += a[i] * b[i];
+    na += a[i] * a[i];
+    nb += b[i] * b[i];
+  }
+  return na === 0 || nb === 0 ? 0 : dot / Math.sqrt(na * nb);
+}
+
+/**
+ * Search the context store like searchContext, but with sort "similarity"
+ * and a query, rank the entries passing the other filters by how close in
+ * meaning they are to the query, using the configured embeddings provider.
+ * Entries without a current embedding are embedded and stored first.
+ * Without a provider, or if it fails, the search ranks by relevance.
+ */
+export async function searchContextSimilar(
+  query: SearchContextInput,
+  storePath: string,
+): Promise<ContextEntry[]> {
+  const provider = embeddingsFromEnv();
+  if (query.sort !== "similarity" || !provider || contextTerms(query.query ?? "").length === 0) {
+    return searchContext(query, storePath);
+  }
+  try {
+    if (!existsSync(join(storePath, CONTEXT_INDEX_FILE))) rebuildContextIndex(storePath);
+    const db = openContextIndex(storePath);
+    try {
+      db.exec(CONTEXT_EMBEDDINGS_SCHEMA);
+      const where: string[] = [];
+      const params: (string | number)[] = [];
+      contextIndexFilters(query, where, params);
+      const sql =
+        "SELECT e.path, e.agent, e.session, e.type, e.tags, e.links, e.attachments, e.timestamp, e.metadata, e.content, " +
+        "m.model AS model, m.hash AS hash, m.vector AS vector FROM entries e LEFT JOIN embeddings m ON m.path = e.path" +
+        (where.length > 0 ? ` WHERE ${where.join(" AND ")}` : "");
+      type Row = ContextIndexRow & { model: string | null; hash: string | null; vector: Uint8Array | null };
+      const rows = db.query(sql).all(...params) as Row[];
+
+      const vectors = rows.map((row) =>
+        row.vector && row.model === provider.model && row.hash === contentHash(row.content)
+          ? new Float32Array(new Uint8Array(row.vector).buffer)
+          : undefined,
+      );
+      const stale = rows.map((_, i) => i).filter((i) => !vectors[i]);
+      if (stale.length > 0) {
+        const embedded = await embed(provider, stale.map((i) => rows[i].content));
+        // The vectors are used either way; failing to keep them only costs
+        // embedding them again
+        try {
+          const insert = db.query("INSERT OR REPLACE INTO embeddings VALUES (?, ?, ?, ?)");
+          db.transaction(() => {
+            stale.forEach((i, j) => {
+              vectors[i] = embedded[j];
+              insert.run(rows[i].path, provider.model, contentHash(rows[i].content), new Uint8Array(embedded[j].buffer));
+            });
+          })();
+        } catch (err) {
+          stale.forEach((i, j) => (vectors[i] = embedded[j]));
+          stderrLog.warn(`failed to store context embeddings: ${err instanceof Error ? err.message : err}`);
+        }
+      }
+
+      const ranked = await sortBySimilarity(
+        provider,
+        rows.map((row) => contextIndexEntry(storePath, row)),
+        vectors as Float32Array[],
+        query.query ?? "",
+      );
+      return finishContextResults(ranked, query);
+    } finally {
+      db.close();
+    }
+  } catch (err) {
+    stderrLog.warn(`similarity search failed, ranking by relevance instead: ${err instanceof Error ? err.message : err}`);
+    return searchContext({ ...query, sort: "relevance" }, storePath);
+  }
+}
+
+/**
+ * Order entries, whose embeddings are vectors, by their similarity to text,
+ * newest first among equals.
+ */
+async function sortBySimilarity(
+  provider: { url: string; model: string; apiKey?: string },
+  entries: ContextEntry[],
+  vectors: Float32Array[],
+  text: string,
+): Promise<ContextEntry[]> {
+  const [q] = await embed(provider, [text]);
+  if (!q || q.length === 0) throw new Error("embeddings endpoint returned an empty vector");
+  return entries
+    .map((entry, i) => ({ entry, score: cosineSimilarity(q, vectors[i]) }))
+    .sort((a, b) => b.score - a.score || b.entry.timestamp.localeCompare(a.entry.timestamp))
+    .map((r) => r.entry);
+}
+
+/**
+ * Recursively scan a directory for context files matching the query.
+ */
+function scanDirectory(
+  dir: string,
+  storePath: string,
+  query: SearchContextInput,
+  results: ContextEntry[],
+): void {
+  let entries;
+  try {
+    entries = readdirSync(dir, { withFileTypes: true });
+  } catch {
+    return;
+  }
+
+  for (const entry of entries) {
+    const fullPath = join(dir, entry.name);
+    if (entry.isDirectory()) {
+      scanDirectory(fullPath, storePath, query, results);
+    } else if (entry.name.endsWith(".md")) {
+      const parsed = parseContextFile(fullPath);
+      if (parsed && matchesQuery(parsed, query)) {
+        results.push(parsed);
+      }
+    }
+  }
+}
+
+/**
+ * Parse a context file into a ContextEntry.
+ */
+function parseContextFile(filePath: string): ContextEntry | null {
+  let text: string;
+  try {
+    text = readFileSync(filePath, "utf-8");
+  } catch {
+    return null;
+  }
+  return parseContextText(filePath, text);
+}
+
+/**
+ * Parse the text of a context entry, reporting location as its filePath.
+ */
+function parseContextText(location: string, text: string): ContextEntry | null {
+  const parsed = parseFrontmatter(text);
+  if (!parsed) return null;
+
+  const { meta, body } = parsed;
+  const metadata = Object.fromEntries(Object.entries(meta).filter(([key]) => !CONTEXT_FRONTMATTER_KEYS.includes(key)));
+  const attachments = frontmatterList(meta.attachments);
+  return {
+    filePath: location,
+    agent: frontmatterString(meta.agent),
+    sessionId: frontmatterString(meta.sessionId) || undefined,
+    timestamp: frontmatterString(meta.timestamp),
+    type: (frontmatterString(meta.type) || "finding") as ContextType,
+    tags: frontmatterList(meta.tags),
+    links: frontmatterList(meta.links),
+    ...(attachments.length > 0 ? { attachments: contextAttachments(location, attachments) } : {}),
+    ...(Object.keys(metadata).length > 0 ? { metadata } : {}),
+    content: body.trim(),
+  };
+}
+
+/**
+ * Check if a context entry matches the search query.
+ */
+function matchesQuery(entry: ContextEntry, query: SearchContextInput): boolean {
+  if (query.agent && entry.agent !== query.agent) return false;
+  if (query.sessionId && entry.sessionId !== query.sessionId) return false;
+  if (query.type && entry.type !== query.type) return false;
+  if (query.tags && query.tags.length > 0) {
+    const hasTag = (t: string) => entry.tags.includes(t);
+    if (!(query.allTags ? query.tags.every(hasTag) : query.tags.some(hasTag))) return false;
+  }
+  if (query.since || query.until) {
+    const at = Date.parse(entry.timestamp);
+    if (Number.isNaN(at)) return false;
+    if (query.since && at < new Date(query.since).getTime()) return false;
+    if (query.until && at >= new Date(query.until).getTime()) return false;
+  }
+  if (query.query) {
+    const lowerQuery = query.query.toLowerCase();
+    const inContent = entry.content.toLowerCase().includes(lowerQuery);
+    const inTags = entry.tags.some((t) => t.toLowerCase().includes(lowerQuery));
+    if (!inContent && !inTags) return false;
+  }
+  return true;
+}
+
+/**
+ * Update an existing context entry: replace content and append a changelog entry.
+ */
+export function updateContext(
+  filePath: string,
+  newContent: string,
+  agentName: string,
+  description: string,
+): void {
+  let text: string;
+  try {
+    text = readFileSync(filePath, "utf-8");
+  } catch {
+    throw new Error(`Context file not found: ${filePath}`);
+  }
+
+  const doc = parseContextDocument(text);
+  if (!doc) {
+    throw new Error(`Invalid context file format: ${filePath}`);
+  }
+  doc.content = newContent.trim();
+  logContextChange(doc, agentName, description);
+
+  writeFileSync(filePath, formatContextDocument(doc));
+  indexContextFile(filePath);
+}
+
+/**
+ * Add a link to an existing context entry's frontmatter.
+ */
+export function addContextLink(
+  filePath: string,
+  linkPath: string,
+  storePath: string,
+): void {
+  let text: string;
+  try {
+    text = readFileSync(filePath, "utf-8");
+  } catch {
+    throw new Error(`Context file not found: ${filePath}`);
+  }
+
+  // Compute relative path from the store root
+  const relPath = relative(storePath, linkPath);
+
+  const parsed = parseFrontmatter(text);
+  if (!parsed) {
+    throw new Error(`Invalid context file format: ${filePath}`);
   }
 
   // Parse existing links
-  const existingLinks: string[] = Array.isArray(parsed.meta.links)
-    ? parsed.meta.links.map(String)
-    : [];
+  const existingLinks = frontmatterList(parsed.meta.links);
 
   if (existingLinks.includes(relPath)) return; // Already linked
 
@@ -353,14 +1323,1349 @@ export function addContextLink(
   meta.links = existingLinks;
 
   const newFrontmatter = generateFrontmatter({
-    agent: String(meta.agent),
-    sessionId: meta.sessionId ? String(meta.sessionId) : undefined,
-    timestamp: String(meta.timestamp),
-    type: String(meta.type) as ContextType,
-    tags: Array.isArray(meta.tags) ? meta.tags.map(String) : undefined,
+    agent: frontmatterString(meta.agent),
+    sessionId: frontmatterString(meta.sessionId) || undefined,
+    timestamp: frontmatterString(meta.timestamp),
+    type: frontmatterString(meta.type) as ContextType,
+    tags: frontmatterList(meta.tags),
     links: existingLinks,
+    metadata: meta,
   });
 
   const updatedFile = newFrontmatter + "\n" + parsed.body;
   writeFileSync(filePath, updatedFile);
+  indexContextFile(filePath);
+}
+
+/** Opens the changelog section of an entry. */
+const CONTEXT_CHANGELOG_HEADING = "## Changelog";
+
+/** A context entry file: its frontmatter, content, and changelog. */
+export interface ContextDocument {
+  agent: string;
+  sessionId?: string;
+  timestamp: string;
+  type: ContextType;
+  tags: string[];
+  links: string[];
+  /** Names of the entry's attachments */
+  attachments?: string[];
+  /** Further frontmatter keys */
+  metadata?: Record<string, unknown>;
+  content: string;
+  /** Lines of the changelog, without their "- " */
+  changelog: string[];
+}
+
+/**
+ * Split an entry file into its parts. The changelog is the list under the
+ * last changelog heading.
+ */
+function parseContextDocument(text: string): ContextDocument | null {
+  const entry = parseContextText("", text);
+  const parsed = parseFrontmatter(text);
+  if (!entry || !parsed) return null;
+
+  let lines = parsed.body.split("\n");
+  const changelog: string[] = [];
+  for (let i = lines.length - 1; i >= 0; i--) {
+    if (lines[i].trim() !== CONTEXT_CHANGELOG_HEADING) continue;
+    for (const l of lines.slice(i + 1)) {
+      if (l.trim().startsWith("- ")) changelog.push(l.trim().slice(2));
+    }
+    lines = lines.slice(0, i);
+    break;
+  }
+  return {
+    agent: entry.agent,
+    sessionId: entry.sessionId,
+    timestamp: entry.timestamp,
+    type: entry.type,
+    tags: entry.tags,
+    links: entry.links,
+    attachments: entry.attachments?.map((a) => a.name),
+    metadata: entry.metadata,
+    content: lines.join("\n").trim(),
+    changelog,
+  };
+}
+
+/** Render an entry file: frontmatter, content, then the changelog if any. */
+function formatContextDocument(doc: ContextDocument): string {
+  let text = generateFrontmatter(doc) + "\n" + doc.content + "\n";
+  if (doc.changelog.length > 0) {
+    text += `\n${CONTEXT_CHANGELOG_HEADING}\n\n` + doc.changelog.map((c) => `- ${c}\n`).join("");
+  }
+  return text;
+}
+
+/** Record a change by agentName in the entry's changelog. */
+function logContextChange(doc: ContextDocument, agentName: string, description: string, now: Date = new Date()): void {
+  doc.changelog.push(`${now.toISOString().replace(/\.\d{3}Z$/, "Z")} [${agentName}]: ${description}`);
+}
+
+/**
+ * Add content after what the entry holds, as a paragraph of its own.
+ * Returns a description of the change, or "" if there was none.
+ */
+export function appendContextContent(doc: ContextDocument, content: string): string {
+  content = content.trim();
+  if (!content) return "";
+  doc.content = doc.content ? `${doc.content}\n\n${content}` : content;
+  return "Appended to the content";
+}
+
+/**
+ * Apply the fields of input that are set: type, tags, links (an empty
+ * array clears them), metadata (merged, a null value removing its key),
+ * and content. The slug names the file and is not changed. Returns a
+ * description of what changed, or "" if nothing did.
+ */
+export function updateContextDocument(doc: ContextDocument, input: UpdateContextInput): string {
+  const same = (a: string[], b: string[]) => a.length === b.length && a.every((v, i) => v === b[i]);
+  const changed: string[] = [];
+  if (input.type && input.type !== doc.type) {
+    doc.type = input.type;
+    changed.push("type");
+  }
+  if (input.tags && !same(input.tags, doc.tags)) {
+    doc.tags = input.tags;
+    changed.push("tags");
+  }
+  if (input.links && !same(input.links, doc.links)) {
+    doc.links = input.links;
+    changed.push("links");
+  }
+  if (input.metadata) {
+    const metadata = { ...doc.metadata };
+    let merged = false;
+    for (const [key, value] of Object.entries(contextMetadataValues(input.metadata))) {
+      if (value === null ? !(key in metadata) : JSON.stringify(metadata[key]) === JSON.stringify(value)) continue;
+      if (value === null) delete metadata[key];
+      else metadata[key] = value;
+      merged = true;
+    }
+    if (merged) {
+      doc.metadata = metadata;
+      changed.push("metadata");
+    }
+  }
+  const content = input.content?.trim();
+  if (content && content !== doc.content) {
+    doc.content = content;
+    changed.push("content");
+  }
+  return changed.length > 0 ? `Updated the ${changed.join(", ")}` : "";
+}
+
+/** Set the entry's tags. Returns a description of the change, or "". */
+export function replaceContextTags(doc: ContextDocument, tags: string[]): string {
+  if (tags.length === doc.tags.length && tags.every((t, i) => t === doc.tags[i])) return "";
+  doc.tags = tags;
+  return `Replaced the tags with [${tags.join(", ")}]`;
+}
+
+/**
+ * Rewrite the entry at path in store with change, recording the change it
+ * describes in the entry's changelog. change returns "" to leave the entry
+ * as it was.
+ */
+export async function editContextEntry(
+  store: ContextStore,
+  path: string,
+  agentName: string,
+  change: (doc: ContextDocument) => string,
+): Promise<void> {
+  await store.rewrite(path, (doc) => {
+    const description = change(doc);
+    if (!description) return false;
+    logContextChange(doc, agentName, description);
+    return true;
+  });
+}
+
+/**
+ * The path relative to the store root of the entry at path: a path
+ * writeContext returned, or a relative path as links are. Paths outside the
+ * store are refused.
+ */
+function contextEntryPath(storePath: string, path: string): string {
+  let rel = path;
+  // A store at a relative path returns entries' paths under it
+  if (!isAbsolute(storePath) && path.startsWith(join(storePath) + sep)) {
+    rel = path.slice(join(storePath).length + 1);
+  } else if (isAbsolute(path)) {
+    rel = relative(resolve(storePath), path);
+  }
+  rel = posix.normalize(rel.split(sep).join("/"));
+  if (!validContextBundlePath(rel)) {
+    throw new Error(`${path} is not an entry of the context store`);
+  }
+  return rel;
+}
+
+/** The file of the entry at path, as contextEntryPath accepts it. */
+function contextEntryFile(storePath: string, path: string): string {
+  return join(storePath, ...contextEntryPath(storePath, path).split("/"));
+}
+
+/**
+ * Whether s is an entry ID, its file name without ".md" such as
+ * 20260221T143022-auth-vulnerability, rather than a path.
+ */
+function isContextId(s: string): boolean {
+  return s !== "" && !/[/\\*?[]/.test(s) && !s.startsWith(".") && !s.endsWith(".md");
+}
+
+/** The one of paths, relative to the store root, whose entry has the ID id. */
+function matchContextId(id: string, paths: string[]): string {
+  const found = paths.filter((p) => posix.basename(p) === `${id}.md` && validContextBundlePath(p)).sort();
+  if (found.length === 0) throw new Error(`Context entry not found: ${id}`);
+  if (found.length > 1) throw new Error(`Context entry ID ${id} is ambiguous: it is each of ${found.join(", ")}`);
+  return found[0];
+}
+
+/**
+ * Read the entry at path (as contextEntryPath accepts it) or with an ID
+ * from the store at storePath.
+ */
+export function readContext(pathOrId: string, storePath: string): ContextEntry {
+  let file: string;
+  if (isContextId(pathOrId)) {
+    const paths: string[] = [];
+    const dirs = (dir: string) =>
+      existsSync(dir)
+        ? readdirSync(dir, { withFileTypes: true }).filter((d) => d.isDirectory() && !d.name.startsWith("."))
+        : [];
+    for (const agent of dirs(storePath)) {
+      if (existsSync(join(storePath, agent.name, `${pathOrId}.md`))) paths.push(`${agent.name}/${pathOrId}.md`);
+      for (const session of dirs(join(storePath, agent.name))) {
+        if (existsSync(join(storePath, agent.name, session.name, `${pathOrId}.md`))) {
+          paths.push(`${agent.name}/${session.name}/${pathOrId}.md`);
+        }
+      }
+    }
+    file = join(storePath, ...matchContextId(pathOrId, paths).split("/"));
+  } else {
+    file = contextEntryFile(storePath, pathOrId);
+  }
+
+  let text: string;
+  try {
+    text = readFileSync(file, "utf-8");
+  } catch {
+    throw new Error(`Context entry not found: ${pathOrId}`);
+  }
+  const entry = parseContextText(file, text);
+  if (!entry) throw new Error(`Invalid context file format: ${pathOrId}`);
+  return entry;
+}
+
+/*
+ * An entry's attachments are kept beside its file, in a directory named for
+ * it with ".attachments" in place of ".md":
+ *
+ *   reviewer/s1/20260221T143022-leak.md
+ *   reviewer/s1/20260221T143022-leak.attachments/fix.diff
+ *
+ * The entry's frontmatter lists their names under attachments.
+ */
+
+/**
+ * The directory of the attachments of the entry at entryPath: a file path,
+ * a path relative to the store root, or a URL.
+ */
+function contextAttachmentDir(entryPath: string): string {
+  return entryPath.replace(/\.md$/, "") + ".attachments";
+}
+
+/** Where the attachment name of the entry at entryPath is kept. */
+function contextAttachmentPath(entryPath: string, name: string): string {
+  if (entryPath.includes("://")) return `${contextAttachmentDir(entryPath)}/${name}`;
+  return join(contextAttachmentDir(entryPath), name);
+}
+
+/** The attachments named in the frontmatter of the entry at location, or with no location, their names only. */
+function contextAttachments(location: string, names: string[]): ContextAttachment[] {
+  return names.map((name) => (location ? { name, path: contextAttachmentPath(location, name) } : { name }));
+}
+
+/** The content of an attachment to write, read from its path if it gives no bytes. */
+function contextAttachmentBytes(a: ContextAttachment): Uint8Array {
+  if (a.bytes) return a.bytes;
+  try {
+    return readFileSync(a.path!);
+  } catch (err) {
+    throw new Error(`failed to read attachment ${a.name}: ${(err as Error).message}`);
+  }
+}
+
+/** The total size of the attachments of the entry at filePath. */
+function contextAttachmentsSize(filePath: string): number {
+  let size = 0;
+  try {
+    for (const d of readdirSync(contextAttachmentDir(filePath), { withFileTypes: true })) {
+      if (d.isFile()) size += statSync(join(contextAttachmentDir(filePath), d.name)).size;
+    }
+  } catch {
+    // No attachments
+  }
+  return size;
+}
+
+/** Remove an entry's file and its attachments; with force, a missing file is not an error. */
+function removeContextEntryFile(filePath: string, force = false): void {
+  rmSync(filePath, { force });
+  rmSync(contextAttachmentDir(filePath), { recursive: true, force: true });
+}
+
+/**
+ * Read the attachment name of the entry at path (as contextEntryPath
+ * accepts it) from the store at storePath.
+ */
+export function readContextAttachment(path: string, name: string, storePath: string): Uint8Array {
+  if (!CONTEXT_SLUG_PATTERN.test(name)) throw new Error(`Context attachment not found: ${name}`);
+  try {
+    return readFileSync(contextAttachmentPath(contextEntryFile(storePath, path), name));
+  } catch {
+    throw new Error(`Context attachment not found: ${name} of ${path}`);
+  }
+}
+
+/** Normalize a link as an entry records it, possibly quoted or with backslashes. */
+function contextLinkPath(link: string): string {
+  return posix.normalize(link.trim().replace(/^["']|["']$/g, "").replace(/\\/g, "/"));
+}
+
+/**
+ * The entry at path and the entries within depth links of it, following the
+ * links of each entry and the links to it from others. A negative depth
+ * follows every link.
+ */
+export async function relatedContext(store: ContextStore, path: string, depth: number): Promise<ContextGraph> {
+  const root = store.entryPath(path);
+  const entries = new Map<string, ContextEntry>();
+  for (const e of await store.search({})) {
+    try {
+      entries.set(store.entryPath(e.filePath), e);
+    } catch {
+      // Not at an entry's path
+    }
+  }
+  if (!entries.has(root)) throw new Error(`Context entry not found: ${path}`);
+
+  const links = new Map<string, string[]>();
+  const backlinks = new Map<string, string[]>();
+  for (const from of [...entries.keys()].sort()) {
+    for (const l of entries.get(from)!.links) {
+      const to = contextLinkPath(l);
+      const out = links.get(from) ?? [];
+      if (to === from || out.includes(to)) continue;
+      links.set(from, [...out, to]);
+      backlinks.set(to, [...(backlinks.get(to) ?? []), from]);
+    }
+  }
+
+  // Breadth first, so each entry's depth is its shortest distance
+  const dist = new Map<string, number>([[root, 0]]);
+  const queue = [root];
+  while (queue.length > 0) {
+    const cur = queue.shift()!;
+    const d = dist.get(cur)!;
+    if (depth >= 0 && d >= depth) continue;
+    for (const next of [...(links.get(cur) ?? []), ...(backlinks.get(cur) ?? [])]) {
+      if (dist.has(next) || !entries.has(next)) continue;
+      dist.set(next, d + 1);
+      queue.push(next);
+    }
+  }
+
+  const nodes: ContextNode[] = [...dist].map(([p, d]) => ({ ...entries.get(p)!, path: p, depth: d }));
+  nodes.sort((a, b) => a.depth - b.depth || (a.path < b.path ? -1 : a.path > b.path ? 1 : 0));
+  const edges: ContextEdge[] = [];
+  for (const n of nodes) {
+    for (const to of links.get(n.path) ?? []) {
+      if (dist.has(to) || !entries.has(to)) edges.push({ from: n.path, to });
+    }
+  }
+  return { root, nodes, edges };
+}
+
+/** How often watchContext searches the store for new entries, in ms. */
+const CONTEXT_WATCH_INTERVAL_MS = 1000;
+
+/**
+ * Yield the entries matching query that are written to the store from now
+ * until signal aborts, oldest first. The store is polled, so that local and
+ * remote stores are watched alike; the query's sort, offset, and limit are
+ * ignored.
+ */
+export async function* watchContext(
+  store: ContextStore,
+  query: SearchContextInput,
+  signal: AbortSignal,
+): AsyncGenerator<ContextEntry> {
+  // Timestamps have whole seconds, so entries of this second are searched
+  // again; those already there are not yielded.
+  const now = Math.floor(Date.now() / 1000) * 1000;
+  const since = query.since && new Date(query.since).getTime() > now ? query.since : new Date(now);
+  const filter: SearchContextInput = { ...query, since, sort: "newest", offset: 0, limit: 0 };
+  const seen = new Set((await store.search(filter)).map((e) => e.filePath));
+  while (!signal.aborted) {
+    await new Promise<void>((done) => {
+      const onAbort = () => (clearTimeout(timer), done());
+      const timer = setTimeout(() => (signal.removeEventListener("abort", onAbort), done()), CONTEXT_WATCH_INTERVAL_MS);
+      signal.addEventListener("abort", onAbort, { once: true });
+    });
+    if (signal.aborted) return;
+    let entries: ContextEntry[];
+    try {
+      entries = await store.search(filter);
+    } catch {
+      continue;
+    }
+    for (const entry of entries.reverse()) {
+      if (seen.has(entry.filePath)) continue;
+      seen.add(entry.filePath);
+      yield entry;
+    }
+  }
+}
+
+/**
+ * Identify an entry by its type, tags, and content, so that a write
+ * repeating an entry is recognized. Tag order and surrounding whitespace
+ * do not matter.
+ */
+function contextEntryHash(type: string, tags: string[], content: string): string {
+  return contentHash(`${type}\n${[...tags].sort().join("\n")}\n\n${content.trim()}`);
+}
+
+/**
+ * The path of the agent's entry in the session with the type, tags, and
+ * content of input, or undefined if there is none. Entries outside a
+ * session are compared with the agent's others outside one.
+ */
+export async function findDuplicateContext(
+  store: ContextStore,
+  input: WriteContextInput,
+  agentName: string,
+  sessionId: string | undefined,
+): Promise<string | undefined> {
+  const tags = input.tags ?? [];
+  const hash = contextEntryHash(input.type, tags, input.content);
+  const entries = await store.search({ agent: agentName, sessionId, type: input.type, tags, allTags: true });
+  const match = entries.find(
+    (e) => (e.sessionId ?? "") === (sessionId ?? "") && contextEntryHash(e.type, e.tags, e.content) === hash,
+  );
+  return match?.filePath;
+}
+
+/** The sessions with entries in the store, the most recently active first. */
+export async function listSessions(store: ContextStore): Promise<ContextSession[]> {
+  const byId = new Map<string, ContextSession>();
+  for (const e of await store.search({})) {
+    if (!e.sessionId) continue;
+    const s = byId.get(e.sessionId);
+    if (!s) {
+      byId.set(e.sessionId, { id: e.sessionId, entries: 1, first: e.timestamp, last: e.timestamp, agents: [e.agent] });
+      continue;
+    }
+    s.entries++;
+    if (e.timestamp < s.first) s.first = e.timestamp;
+    if (e.timestamp > s.last) s.last = e.timestamp;
+    if (!s.agents.includes(e.agent)) s.agents.push(e.agent);
+  }
+  const sessions = [...byId.values()];
+  for (const s of sessions) s.agents.sort();
+  return sessions.sort((a, b) => (a.last !== b.last ? (a.last < b.last ? 1 : -1) : a.id < b.id ? -1 : 1));
+}
+
+/** Marks the entries summarizeSession writes, which later summaries of the session leave out. */
+const SESSION_SUMMARY_TAG = "session-summary";
+
+/** The first line of content, without heading marks, cut to 100 characters. */
+function contextEntryTitle(content: string): string {
+  for (const raw of content.split("\n")) {
+    const line = raw.replace(/^\s*#+/, "").trim();
+    if (!line) continue;
+    const chars = [...line];
+    return chars.length > 100 ? chars.slice(0, 99).join("") + "…" : line;
+  }
+  return "(empty)";
+}
+
+/** Entries rendered as one markdown document, the input a summarizer agent reads on stdin. */
+function formatSessionContext(sessionId: string, entries: ContextEntry[]): string {
+  let out = `# Context of session ${sessionId}\n`;
+  for (const e of entries) {
+    out += `\n## ${e.type}: ${contextEntryTitle(e.content)}\n\n`;
+    out += `Agent: ${e.agent}  \nWritten: ${e.timestamp}`;
+    if (e.tags.length > 0) out += `  \nTags: ${e.tags.join(", ")}`;
+    out += `\n\n${e.content.trim()}\n`;
+  }
+  return out;
+}
+
+/**
+ * Write a summary entry of the session's context, linking to every entry
+ * it summarizes. The summary is what the summarizer agent prints given the
+ * entries on stdin, or without one a digest of them, one line each.
+ * Earlier summaries of the session are left out.
+ */
+export async function summarizeSession(
+  ctx: ExecuteContext,
+  store: ContextStore,
+  options: SummarizeSessionOptions = {},
+): Promise<string> {
+  // Oldest first
+  const entries = ctx.sessionId
+    ? (await ctx.searchContext({ sessionId: ctx.sessionId })).filter((e) => !e.tags.includes(SESSION_SUMMARY_TAG)).reverse()
+    : [];
+  if (!ctx.sessionId || entries.length === 0) {
+    throw new Error(`Session has no context entries: ${ctx.sessionId}`);
+  }
+  const links = entries.map((e) => store.entryPath(e.filePath));
+
+  const summarizer = options.summarizer || (ctx.config.contextStore as SfaConfig["contextStore"])?.summarizer;
+  let content =
+    `${entries.length} context entries of session ${ctx.sessionId}:\n\n` +
+    entries.map((e, i) => `- **${e.type}** by ${e.agent}: ${contextEntryTitle(e.content)} (${links[i]})`).join("\n");
+  if (summarizer) {
+    const result = await ctx.invoke(summarizer, { context: formatSessionContext(ctx.sessionId, entries) });
+    if (!result.ok) {
+      throw new Error(`summarizer ${summarizer} exited with code ${result.exitCode}: ${result.stderr.trim()}`);
+    }
+    content = result.output.trim();
+    if (!content) throw new Error(`summarizer ${summarizer} returned no summary`);
+  }
+
+  return ctx.writeContext({
+    type: "summary",
+    slug: options.slug || SESSION_SUMMARY_TAG,
+    tags: [SESSION_SUMMARY_TAG, ...(options.tags ?? []).filter((t) => t !== SESSION_SUMMARY_TAG)],
+    content,
+    links,
+  });
+}
+
+/**
+ * Apply edit to the entry at path and write it back, reindexed, if edit
+ * reports a change. The file is replaced whole, so readers never see it
+ * half written.
+ */
+function rewriteContextEntry(storePath: string, path: string, edit: (doc: ContextDocument) => boolean): void {
+  const file = contextEntryFile(storePath, path);
+  let text: string;
+  try {
+    text = readFileSync(file, "utf-8");
+  } catch {
+    throw new Error(`Context entry not found: ${path}`);
+  }
+  const doc = parseContextDocument(text);
+  if (!doc) throw new Error(`Invalid context file format: ${path}`);
+  if (!edit(doc)) return;
+
+  const tmp = `${file}.${process.pid}.tmp`;
+  writeFileSync(tmp, formatContextDocument(doc));
+  renameSync(tmp, file);
+  indexContextFile(file);
+}
+
+/** The version of the JSON export format. */
+const CONTEXT_BUNDLE_VERSION = 1;
+
+/** An entry's file in an export, at its path relative to the store root. */
+interface ContextBundleEntry {
+  path: string;
+  content: string;
+}
+
+/** The entries an export selects, by path. */
+function collectContextExport(storePath: string, options: ContextExportOptions): ContextBundleEntry[] {
+  const entries: ContextBundleEntry[] = [];
+  const walk = (dir: string) => {
+    let dirents;
+    try {
+      dirents = readdirSync(dir, { withFileTypes: true });
+    } catch {
+      return;
+    }
+    for (const d of dirents) {
+      const path = join(dir, d.name);
+      if (d.isDirectory()) {
+        walk(path);
+      } else if (d.name.endsWith(".md")) {
+        const rel = relative(storePath, path).split(sep).join("/");
+        if (!inContextSession(rel, options.sessionId)) continue;
+        entries.push({ path: rel, content: readFileSync(path, "utf-8") });
+      }
+    }
+  };
+  walk(options.agent ? join(storePath, options.agent) : storePath);
+  return entries.sort((a, b) => (a.path < b.path ? -1 : a.path > b.path ? 1 : 0));
+}
+
+/**
+ * Whether the entry at rel, relative to the store root, belongs to
+ * sessionId; every entry does without one.
+ */
+function inContextSession(rel: string, sessionId?: string): boolean {
+  if (!sessionId) return true;
+  const parts = rel.split("/");
+  return parts.length === 3 && parts[1] === sessionId;
+}
+
+/** A ustar header block for a regular file. */
+function tarHeader(name: string, size: number, mtime: number): Buffer {
+  const header = Buffer.alloc(512);
+  const field = (value: string, offset: number, length: number) => header.write(value, offset, length, "utf-8");
+  const octal = (n: number, length: number) => n.toString(8).padStart(length - 1, "0");
+  // Names over 100 bytes split at a slash into the ustar prefix field
+  let prefix = "";
+  if (Buffer.byteLength(name) > 100) {
+    const cut = name.lastIndexOf("/", 155);
+    if (cut > 0) [prefix, name] = [name.slice(0, cut), name.slice(cut + 1)];
+  }
+  field(name, 0, 100);
+  field(octal(0o644, 8), 100, 8);
+  field(octal(0, 8), 108, 8);
+  field(octal(0, 8), 116, 8);
+  field(octal(size, 12), 124, 12);
+  field(octal(mtime, 12), 136, 12);
+  field("        ", 148, 8);
+  field("0", 156, 1);
+  field("ustar", 257, 6);
+  field("00", 263, 2);
+  field(prefix, 345, 155);
+  let sum = 0;
+  for (const byte of header) sum += byte;
+  field(octal(sum, 7) + "\0", 148, 8);
+  return header;
+}
+
+/**
+ * Bundle the context entries of an agent, a session, or the whole store:
+ * as a JSON document (the default), or a gzipped tarball of the entry files.
+ */
+export function exportContext(storePath: string, options: ContextExportOptions = {}): Buffer {
+  return encodeContextBundle(collectContextExport(storePath, options), options);
+}
+
+/** Encode entries in the format options names. */
+function encodeContextBundle(entries: ContextBundleEntry[], options: ContextExportOptions): Buffer {
+  const format = options.format ?? "json";
+  if (format === "json") {
+    const bundle = {
+      version: CONTEXT_BUNDLE_VERSION,
+      exportedAt: new Date().toISOString().replace(/\.\d{3}Z$/, "Z"),
+      ...(options.agent ? { agent: options.agent } : {}),
+      ...(options.sessionId ? { sessionId: options.sessionId } : {}),
+      entries,
+    };
+    return Buffer.from(JSON.stringify(bundle, null, 2));
+  }
+  if (format === "tar") {
+    const mtime = Math.floor(Date.now() / 1000);
+    const blocks: Buffer[] = [];
+    for (const e of entries) {
+      const content = Buffer.from(e.content);
+      blocks.push(tarHeader(e.path, content.length, mtime), content, Buffer.alloc((512 - (content.length % 512)) % 512));
+    }
+    blocks.push(Buffer.alloc(1024));
+    return gzipSync(Buffer.concat(blocks));
+  }
+  throw new Error(`Unknown context export format "${format}" (use json or tar)`);
+}
+
+/**
+ * Decode an export in either format, told apart by the gzip magic number,
+ * and check that every entry in it is one.
+ */
+function readContextBundle(data: Buffer): ContextBundleEntry[] {
+  const entries = decodeContextBundle(data);
+  for (const e of entries) {
+    if (typeof e.path !== "string" || !validContextBundlePath(e.path) || !String(e.content).startsWith("---\n")) {
+      throw new Error(`invalid context export: "${e.path}" is not a context entry`);
+    }
+  }
+  return entries;
+}
+
+function decodeContextBundle(data: Buffer): ContextBundleEntry[] {
+  if (data[0] !== 0x1f || data[1] !== 0x8b) {
+    let bundle: { version?: number; entries?: ContextBundleEntry[] };
+    try {
+      bundle = JSON.parse(data.toString("utf-8"));
+    } catch (err) {
+      throw new Error(`invalid context export: ${(err as Error).message}`);
+    }
+    if (bundle.version !== CONTEXT_BUNDLE_VERSION) {
+      throw new Error(`unsupported context export version ${bundle.version}`);
+    }
+    return bundle.entries ?? [];
+  }
+
+  let tar: Buffer;
+  try {
+    tar = gunzipSync(data);
+  } catch (err) {
+    throw new Error(`invalid context export: ${(err as Error).message}`);
+  }
+  const entries: ContextBundleEntry[] = [];
+  const text = (offset: number, length: number) => tar.toString("utf-8", offset, offset + length).replace(/\0.*$/s, "");
+  // A PAX extended header ("x") may carry the path of the file after it
+  let paxPath = "";
+  for (let offset = 0; offset + 512 <= tar.length; ) {
+    const name = text(offset, 100);
+    if (!name) break;
+    const size = parseInt(text(offset + 124, 12).trim() || "0", 8);
+    const type = text(offset + 156, 1);
+    const prefix = text(offset + 345, 155);
+    if (Number.isNaN(size) || offset + 512 + size > tar.length) throw new Error("invalid context export: truncated tarball");
+    const body = tar.toString("utf-8", offset + 512, offset + 512 + size);
+    if (type === "x") {
+      paxPath = /(?:^|\n)\d+ path=([^\n]*)\n/.exec(body)?.[1] ?? "";
+    } else {
+      if (type === "0" || type === "") {
+        entries.push({ path: paxPath || (prefix ? `${prefix}/${name}` : name), content: body });
+      }
+      paxPath = "";
+    }
+    offset += 512 + Math.ceil(size / 512) * 512;
+  }
+  return entries;
+}
+
+/**
+ * Whether path is where an entry may live: <agent>/<file>.md or
+ * <agent>/<session>/<file>.md, with nothing that leaves the store.
+ */
+function validContextBundlePath(path: string): boolean {
+  if (path !== posix.normalize(path) || posix.isAbsolute(path) || !path.endsWith(".md")) return false;
+  const parts = path.split("/");
+  if (parts.length < 2 || parts.length > 3) return false;
+  return parts.every((part) => part !== "" && !part.startsWith(".") && !part.includes("\\"));
+}
+
+/**
+ * Write the entries of an export (see exportContext) into the store and
+ * index them. Entries whose path the store already has are left as they
+ * are. Returns the absolute paths of the entries written.
+ */
+export function importContext(storePath: string, data: Buffer | Uint8Array): string[] {
+  const entries = readContextBundle(Buffer.from(data));
+  const imported: string[] = [];
+  for (const e of entries) {
+    const dest = join(storePath, ...e.path.split("/"));
+    if (existsSync(dest)) continue;
+    mkdirSync(dirname(dest), { recursive: true });
+    writeFileSync(dest, e.content);
+    indexContextFile(dest);
+    imported.push(dest);
+  }
+  return imported;
+}
+
+/**
+ * Where an agent's context entries are kept: a directory on this machine
+ * by default, or a remote store that agents on several machines or in
+ * several containers share (see openContextStore).
+ */
+export interface ContextStore {
+  /** Store an entry and return where it was written. */
+  write(input: WriteContextInput, agentName: string, sessionId: string | undefined): Promise<string>;
+  /** The entry at a path, or with an ID. */
+  read(pathOrId: string): Promise<ContextEntry>;
+  /** The attachment name of the entry at path. */
+  readAttachment(path: string, name: string): Promise<Uint8Array>;
+  search(query: SearchContextInput): Promise<ContextEntry[]>;
+  exportBundle(options?: ContextExportOptions): Promise<Buffer>;
+  /** Store the entries of an export the store lacks and return where they were written. */
+  importBundle(data: Buffer | Uint8Array): Promise<string[]>;
+  /** Apply edit to the entry at path, writing it back if edit reports a change. */
+  rewrite(path: string, edit: (doc: ContextDocument) => boolean): Promise<void>;
+  /** The path of the entry at path relative to the store root, as links name it. */
+  entryPath(path: string): string;
+}
+
+/**
+ * The URL of the remote context store, or undefined for a local one.
+ * Priority: SFA_CONTEXT_STORE_URL env → SFA_CONTEXT_STORE env (local) →
+ * config contextStore.url
+ */
+export function resolveContextStoreUrl(config: SfaConfig): string | undefined {
+  if (process.env.SFA_CONTEXT_STORE_URL) return process.env.SFA_CONTEXT_STORE_URL;
+  if (process.env.SFA_CONTEXT_STORE) return undefined;
+  return config.contextStore?.url || undefined;
+}
+
+/** Prefix of an entry body encrypted with contextStore.encrypt. */
+const CONTEXT_SEAL_PREFIX = "sfa-enc:v1:";
+
+/**
+ * The 256-bit key of encrypted entries, base64 in SFA_CONTEXT_KEY. The Go
+ * SDK also reads it from the keychain and creates it there.
+ */
+function contextKey(): Buffer {
+  const encoded = process.env.SFA_CONTEXT_KEY;
+  if (!encoded) throw new Error("the context store encryption key is not set (SFA_CONTEXT_KEY)");
+  const key = Buffer.from(encoded.trim(), "base64");
+  if (key.length !== 32) throw new Error("SFA_CONTEXT_KEY: not a base64 256-bit key");
+  return key;
+}
+
+/** An entry body or attachment encrypted with AES-256-GCM: nonce, ciphertext, then tag, in base64. */
+function sealContextContent(content: string | Uint8Array): string {
+  const nonce = randomBytes(12);
+  const cipher = createCipheriv("aes-256-gcm", contextKey(), nonce);
+  const plain = typeof content === "string" ? Buffer.from(content, "utf-8") : content;
+  const sealed = Buffer.concat([nonce, cipher.update(plain), cipher.final(), cipher.getAuthTag()]);
+  return CONTEXT_SEAL_PREFIX + sealed.toString("base64");
+}
+
+/** Decrypt what sealContextContent returned. */
+function unsealContextContent(sealed: string): Buffer {
+  const key = contextKey();
+  const raw = Buffer.from(sealed.slice(CONTEXT_SEAL_PREFIX.length), "base64");
+  if (raw.length < 12 + 16) throw new Error("malformed encrypted entry");
+  try {
+    const decipher = createDecipheriv("aes-256-gcm", key, raw.subarray(0, 12));
+    decipher.setAuthTag(raw.subarray(raw.length - 16));
+    return Buffer.concat([decipher.update(raw.subarray(12, raw.length - 16)), decipher.final()]);
+  } catch {
+    throw new Error("cannot decrypt entry: encrypted with a different context key");
+  }
+}
+
+/** Decrypt an entry body; one that is not encrypted is returned as it is. */
+function openContextContent(content: string): string {
+  const trimmed = content.trim();
+  if (!trimmed.startsWith(CONTEXT_SEAL_PREFIX)) return content;
+  return unsealContextContent(trimmed).toString("utf-8");
+}
+
+/** Decrypt an attachment; one that is not encrypted is returned as it is. */
+function openContextAttachment(data: Uint8Array): Uint8Array {
+  const text = Buffer.from(data).toString("utf-8").trim();
+  return text.startsWith(CONTEXT_SEAL_PREFIX) ? unsealContextContent(text) : data;
+}
+
+/** An entry with its content decrypted. */
+function openContextEntry(entry: ContextEntry): ContextEntry {
+  try {
+    return { ...entry, content: openContextContent(entry.content) };
+  } catch (err) {
+    throw new Error(`${entry.filePath}: ${(err as Error).message}`);
+  }
+}
+
+/**
+ * Wrap a store so that the bodies and attachments of the entries written
+ * to it are encrypted and those read from it decrypted. Frontmatter stays in
+ * plaintext, so searches select entries by it, then decrypt them to match
+ * and rank the text of the query. Similarity searches rank by relevance,
+ * as the provider would be sent the plaintext.
+ */
+function sealedContextStore(store: ContextStore): ContextStore {
+  return {
+    ...store,
+    write: (input, agentName, sessionId) => {
+      const attachments = input.attachments?.map((a) => ({
+        name: a.name,
+        bytes: Buffer.from(sealContextContent(contextAttachmentBytes(a))),
+      }));
+      return store.write({ ...input, content: sealContextContent(input.content), attachments }, agentName, sessionId);
+    },
+    read: async (pathOrId) => openContextEntry(await store.read(pathOrId)),
+    async readAttachment(path, name) {
+      const data = await store.readAttachment(path, name);
+      try {
+        return openContextAttachment(data);
+      } catch (err) {
+        throw new Error(`${name} of ${path}: ${(err as Error).message}`);
+      }
+    },
+    async search(query) {
+      const sort = query.sort ?? "newest";
+      if (sort !== "newest" && sort !== "relevance" && sort !== "similarity") {
+        throw new Error(`Unknown context sort "${sort}" (use newest, relevance, or similarity)`);
+      }
+      if (!query.query) return (await store.search(query)).map(openContextEntry);
+      const filter: SearchContextInput = { ...query, query: undefined, sort: "newest", offset: 0, limit: 0 };
+      const entries = (await store.search(filter)).map(openContextEntry);
+      const terms = contextTerms(query.query);
+      if (sort !== "newest" && terms.length > 0) {
+        return finishContextResults(rankContextResults(entries, terms), { ...query, sort: "relevance" });
+      }
+      return finishContextResults(entries.filter((e) => matchesQuery(e, query)), { ...query, sort: "newest" });
+    },
+    rewrite: (path, edit) =>
+      store.rewrite(path, (doc) => {
+        doc.content = openContextContent(doc.content);
+        if (!edit(doc)) return false;
+        doc.content = sealContextContent(doc.content);
+        return true;
+      }),
+  };
+}
+
+/**
+ * Open the context store the config selects: the local directory, indexed,
+ * held to its quota, and pruned past its retention, or with a URL, a
+ * remote store over HTTP(S) or in S3. With contextStore.encrypt, entry
+ * bodies are encrypted.
+ */
+export function openContextStore(config: SfaConfig): ContextStore {
+  const store = openContextBackend(config);
+  return config.contextStore?.encrypt ? sealedContextStore(store) : store;
+}
+
+/** The local or remote store the config selects. */
+function openContextBackend(config: SfaConfig): ContextStore {
+  const raw = resolveContextStoreUrl(config);
+  if (!raw) {
+    const storePath = resolveContextStorePath(config);
+    const retention = resolveContextRetention(config);
+    const quota = config.contextStore?.quota;
+    return {
+      write: async (input, agentName, sessionId) => writeContext(input, agentName, sessionId, storePath, retention, quota),
+      search: (query) => searchContextSimilar(query, storePath),
+      exportBundle: async (options) => exportContext(storePath, options),
+      importBundle: async (data) => importContext(storePath, data),
+      read: async (pathOrId) => readContext(pathOrId, storePath),
+      readAttachment: async (path, name) => readContextAttachment(path, name, storePath),
+      rewrite: async (path, edit) => rewriteContextEntry(storePath, path, edit),
+      entryPath: (path) => contextEntryPath(storePath, path),
+    };
+  }
+
+  let url: URL;
+  try {
+    url = new URL(raw);
+  } catch {
+    throw new Error(`Invalid context store URL "${raw}"`);
+  }
+  const base = raw.replace(/\/+$/, "");
+  switch (url.protocol) {
+    case "http:":
+    case "https:":
+      return remoteContextStore(base, httpObjectBackend(base, process.env.SFA_CONTEXT_STORE_TOKEN || undefined));
+    case "s3:":
+      return remoteContextStore(base, s3ObjectBackend(url, config));
+    default:
+      throw new Error(`Unsupported context store URL "${raw}" (use http, https, or s3)`);
+  }
+}
+
+/** An entry file held by a remote store, at its path relative to the store root. */
+interface ContextObject {
+  path: string;
+  size: number;
+  modified: string;
+}
+
+/** Holds the files of a remote context store by path. */
+interface ObjectBackend {
+  put(path: string, data: string | Uint8Array): Promise<void>;
+  /** put, but false rather than replace an object already at path. */
+  create(path: string, data: string): Promise<boolean>;
+  /** The file at path, or null if there is none. */
+  get(path: string): Promise<string | null>;
+  /** get, as bytes. */
+  getBytes(path: string): Promise<Uint8Array | null>;
+  /** The objects whose paths start with prefix. */
+  list(prefix: string): Promise<ContextObject[]>;
+}
+
+/**
+ * A store keeping entries, in the same layout and format as a local store,
+ * in an ObjectBackend. Searches read every entry under the queried agent,
+ * as there is no index; quotas and retention are left to the backend.
+ */
+function remoteContextStore(base: string, backend: ObjectBackend): ContextStore {
+  const entries = async (prefix: string, keep: (path: string) => boolean): Promise<ContextBundleEntry[]> => {
+    const found: ContextBundleEntry[] = [];
+    for (const o of await backend.list(prefix)) {
+      if (!validContextBundlePath(o.path) || !keep(o.path)) continue;
+      const content = await backend.get(o.path);
+      // null: removed since it was listed
+      if (content !== null) found.push({ path: o.path, content });
+    }
+    return found.sort((a, b) => (a.path < b.path ? -1 : a.path > b.path ? 1 : 0));
+  };
+
+  return {
+    async write(input, agentName, sessionId) {
+      const now = new Date();
+      const dir = `${agentName}/${sessionId ? `${sessionId}/` : ""}`;
+      const frontmatter = generateFrontmatter({
+        agent: agentName,
+        sessionId,
+        timestamp: now.toISOString(),
+        type: input.type,
+        tags: input.tags,
+        links: input.links,
+        attachments: input.attachments?.map((a) => a.name),
+        metadata: input.metadata,
+      });
+      for (let attempt = 0; ; attempt++) {
+        const path = dir + contextEntryName(now, input.slug, attempt);
+        if (await backend.create(path, frontmatter + "\n" + input.content + "\n")) {
+          for (const a of input.attachments ?? []) {
+            try {
+              await backend.put(`${contextAttachmentDir(path)}/${a.name}`, contextAttachmentBytes(a));
+            } catch (err) {
+              throw new Error(`failed to write attachment ${a.name}: ${(err as Error).message}`);
+            }
+          }
+          return `${base}/${path}`;
+        }
+        if (attempt >= CONTEXT_NAME_ATTEMPTS) throw new Error(`failed to write context entry: ${path} already exists`);
+      }
+    },
+
+    async search(query) {
+      const sort = query.sort ?? "newest";
+      if (sort !== "newest" && sort !== "relevance" && sort !== "similarity") {
+        throw new Error(`Unknown context sort "${sort}" (use newest, relevance, or similarity)`);
+      }
+      // Similarity filters like relevance, and ranks by it without a provider
+      const similar = sort === "similarity" && contextTerms(query.query ?? "").length > 0;
+      if (sort !== "newest" && contextTerms(query.query ?? "").length === 0) {
+        query = { ...query, sort: "newest" };
+      } else if (similar) {
+        query = { ...query, sort: "relevance" };
+      }
+
+      // Relevance ranking matches words below instead
+      const filter = query.sort === "relevance" ? { ...query, query: undefined } : query;
+      const results: ContextEntry[] = [];
+      for (const e of await entries(query.agent ? `${query.agent}/` : "", () => true)) {
+        const parsed = parseContextText(`${base}/${e.path}`, e.content);
+        if (parsed && matchesQuery(parsed, filter)) results.push(parsed);
+      }
+
+      const provider = embeddingsFromEnv();
+      if (similar && provider && results.length > 0) {
+        try {
+          const vectors = await embed(provider, results.map((r) => r.content));
+          const ranked = await sortBySimilarity(provider, results, vectors, query.query ?? "");
+          return finishContextResults(ranked, { ...query, sort: "similarity" });
+        } catch (err) {
+          stderrLog.warn(`similarity search failed, ranking by relevance instead: ${err instanceof Error ? err.message : err}`);
+        }
+      }
+      if (query.sort === "relevance") {
+        return finishContextResults(rankContextResults(results, contextTerms(query.query ?? "")), query);
+      }
+      results.sort((a, b) => b.timestamp.localeCompare(a.timestamp));
+      return finishContextResults(results, query);
+    },
+
+    async exportBundle(options = {}) {
+      const found = await entries(options.agent ? `${options.agent}/` : "", (p) => inContextSession(p, options.sessionId));
+      return encodeContextBundle(found, options);
+    },
+
+    async importBundle(data) {
+      const bundle = readContextBundle(Buffer.from(data));
+      const have = new Set((await backend.list("")).map((o) => o.path));
+      const imported: string[] = [];
+      for (const e of bundle) {
+        if (have.has(e.path)) continue;
+        await backend.put(e.path, e.content);
+        have.add(e.path);
+        imported.push(`${base}/${e.path}`);
+      }
+      return imported;
+    },
+
+    async read(pathOrId) {
+      const rel = isContextId(pathOrId)
+        ? matchContextId(pathOrId, (await backend.list("")).map((o) => o.path))
+        : this.entryPath(pathOrId);
+      const text = await backend.get(rel);
+      if (text === null) throw new Error(`Context entry not found: ${pathOrId}`);
+      const entry = parseContextText(`${base}/${rel}`, text);
+      if (!entry) throw new Error(`Invalid context file format: ${pathOrId}`);
+      return entry;
+    },
+
+    async readAttachment(path, name) {
+      if (!CONTEXT_SLUG_PATTERN.test(name)) throw new Error(`Context attachment not found: ${name}`);
+      const data = await backend.getBytes(`${contextAttachmentDir(this.entryPath(path))}/${name}`);
+      if (data === null) throw new Error(`Context attachment not found: ${name} of ${path}`);
+      return data;
+    },
+
+    entryPath(path) {
+      const rel = path.startsWith(`${base}/`) ? path.slice(base.length + 1) : path;
+      if (!validContextBundlePath(rel)) {
+        throw new Error(`${path} is not an entry of the context store`);
+      }
+      return rel;
+    },
+
+    // Two agents rewriting one entry at once may lose one of the changes
+    async rewrite(path, edit) {
+      const rel = this.entryPath(path);
+      const text = await backend.get(rel);
+      if (text === null) throw new Error(`Context entry not found: ${path}`);
+      const doc = parseContextDocument(text);
+      if (!doc) throw new Error(`Invalid context file format: ${path}`);
+      if (edit(doc)) await backend.put(rel, formatContextDocument(doc));
+    },
+  };
+}
+
+/** fetch, with failures to connect reported as the store being unavailable. */
+async function fetchContextStore(url: string, init: RequestInit): Promise<Response> {
+  try {
+    return await fetch(url, { ...init, signal: AbortSignal.timeout(30_000) });
+  } catch (err) {
+    throw new Error(`context store is unavailable: ${err instanceof Error ? err.message : err}`);
+  }
+}
+
+/**
+ * A context store served over HTTP: PUT and GET on <url>/<path> store and
+ * read an entry or attachment, and GET <url>/?prefix=<prefix> lists entries as
+ * {"objects": [{"path", "size", "modified"}]}. A PUT with If-None-Match: *
+ * must not replace an entry. A token, when set, is sent as a bearer token.
+ */
+function httpObjectBackend(base: string, token?: string): ObjectBackend {
+  const auth: Record<string, string> = token ? { Authorization: `Bearer ${token}` } : {};
+  const objectUrl = (path: string) => `${base}/${path.split("/").map(encodeURIComponent).join("/")}`;
+  const failed = (res: Response) => new Error(`context store returned ${res.status} ${res.statusText}`);
+  return {
+    async put(path, data) {
+      const res = await fetchContextStore(objectUrl(path), {
+        method: "PUT",
+        headers: {
+          ...auth,
+          "Content-Type": path.endsWith(".md") ? "text/markdown; charset=utf-8" : "application/octet-stream",
+        },
+        body: data,
+      });
+      if (!res.ok) throw failed(res);
+    },
+    async create(path, data) {
+      const res = await fetchContextStore(objectUrl(path), {
+        method: "PUT",
+        headers: { ...auth, "Content-Type": "text/markdown; charset=utf-8", "If-None-Match": "*" },
+        body: data,
+      });
+      if (res.status === 412) return false;
+      if (!res.ok) throw failed(res);
+      return true;
+    },
+    async get(path) {
+      const res = await fetchContextStore(objectUrl(path), { headers: auth });
+      if (res.status === 404) return null;
+      if (!res.ok) throw failed(res);
+      return res.text();
+    },
+    async getBytes(path) {
+      const res = await fetchContextStore(objectUrl(path), { headers: auth });
+      if (res.status === 404) return null;
+      if (!res.ok) throw failed(res);
+      return new Uint8Array(await res.arrayBuffer());
+    },
+    async list(prefix) {
+      const res = await fetchContextStore(`${base}/?prefix=${encodeURIComponent(prefix)}`, { headers: auth });
+      if (!res.ok) throw failed(res);
+      let body: { objects?: ContextObject[] };
+      try {
+        body = (await res.json()) as { objects?: ContextObject[] };
+      } catch (err) {
+        throw new Error(`invalid context store listing: ${err instanceof Error ? err.message : err}`);
+      }
+      return body.objects ?? [];
+    },
+  };
+}
+
+/** Credentials that sign requests to an S3 context store. */
+interface S3Credentials {
+  accessKeyId: string;
+  secretAccessKey: string;
+  sessionToken?: string;
+}
+
+/**
+ * SFA_CONTEXT_STORE_ACCESS_KEY_ID, SFA_CONTEXT_STORE_SECRET_ACCESS_KEY, and
+ * SFA_CONTEXT_STORE_SESSION_TOKEN, falling back to their AWS_ equivalents.
+ * Only the SFA_ variables reach subagents.
+ */
+function s3CredentialsFromEnv(): S3Credentials {
+  const env = (name: string) => process.env[`SFA_CONTEXT_STORE_${name}`] || process.env[`AWS_${name}`] || "";
+  return {
+    accessKeyId: env("ACCESS_KEY_ID"),
+    secretAccessKey: env("SECRET_ACCESS_KEY"),
+    sessionToken: env("SESSION_TOKEN") || undefined,
+  };
+}
+
+/** Percent-encode everything but unreserved characters, as signature version 4 requires. */
+function s3Escape(s: string): string {
+  let out = "";
+  for (const byte of Buffer.from(s, "utf-8")) {
+    const c = String.fromCharCode(byte);
+    out += /[A-Za-z0-9\-_.~]/.test(c) ? c : `%${byte.toString(16).toUpperCase().padStart(2, "0")}`;
+  }
+  return out;
+}
+
+/**
+ * Sign a request with AWS signature version 4, covering the host and every
+ * header in headers, which gains the signature. url's path and query must
+ * already be escaped with s3Escape, the query sorted by name.
+ */
+function signS3Request(
+  method: string,
+  url: URL,
+  headers: Record<string, string>,
+  body: string | Uint8Array,
+  creds: S3Credentials,
+  region: string,
+  now: Date = new Date(),
+): void {
+  const amzDate = now.toISOString().replace(/[-:]/g, "").replace(/\.\d{3}Z$/, "Z");
+  const day = amzDate.slice(0, 8);
+  const payloadHash = createHash("sha256").update(body).digest("hex");
+  headers["X-Amz-Date"] = amzDate;
+  headers["X-Amz-Content-Sha256"] = payloadHash;
+  if (creds.sessionToken) headers["X-Amz-Security-Token"] = creds.sessionToken;
+
+  const canonical: Record<string, string> = { host: url.host };
+  for (const [name, value] of Object.entries(headers)) canonical[name.toLowerCase()] = value.trim();
+  const names = Object.keys(canonical).sort();
+  const signedHeaders = names.join(";");
+  const request = [
+    method,
+    url.pathname,
+    url.search.slice(1),
+    names.map((name) => `${name}:${canonical[name]}\n`).join(""),
+    signedHeaders,
+    payloadHash,
+  ].join("\n");
+
+  const scope = `${day}/${region}/s3/aws4_request`;
+  const toSign = ["AWS4-HMAC-SHA256", amzDate, scope, createHash("sha256").update(request).digest("hex")].join("\n");
+  const mac = (key: string | Buffer, data: string) => createHmac("sha256", key).update(data).digest();
+  const key = mac(mac(mac(mac(`AWS4${creds.secretAccessKey}`, day), region), "s3"), "aws4_request");
+  const signature = createHmac("sha256", key).update(toSign).digest("hex");
+  headers.Authorization =
+    `AWS4-HMAC-SHA256 Credential=${creds.accessKeyId}/${scope}, SignedHeaders=${signedHeaders}, Signature=${signature}`;
+}
+
+/**
+ * A context store in an S3 bucket, or a bucket of any S3-compatible
+ * service, at s3://<bucket>/<prefix>. The region is contextStore.region,
+ * AWS_REGION, or AWS_DEFAULT_REGION, by default us-east-1.
+ * contextStore.endpoint, AWS_ENDPOINT_URL_S3, or AWS_ENDPOINT_URL points
+ * it at an S3-compatible service, addressed path-style.
+ */
+function s3ObjectBackend(url: URL, config: SfaConfig): ObjectBackend {
+  const bucket = url.host;
+  const trimmed = decodeURIComponent(url.pathname).replace(/^\/+|\/+$/g, "");
+  const keyPrefix = trimmed ? `${trimmed}/` : "";
+  const region = config.contextStore?.region || process.env.AWS_REGION || process.env.AWS_DEFAULT_REGION || "us-east-1";
+  const creds = s3CredentialsFromEnv();
+  if (!creds.accessKeyId || !creds.secretAccessKey) {
+    throw new Error(
+      `Context store ${url.href} needs credentials: set SFA_CONTEXT_STORE_ACCESS_KEY_ID and SFA_CONTEXT_STORE_SECRET_ACCESS_KEY`,
+    );
+  }
+
+  let endpoint = `https://${bucket}.s3.${region}.amazonaws.com`;
+  let pathStyle = false;
+  const custom = config.contextStore?.endpoint || process.env.AWS_ENDPOINT_URL_S3 || process.env.AWS_ENDPOINT_URL;
+  if (custom) {
+    let e: URL;
+    try {
+      e = new URL(custom);
+    } catch {
+      throw new Error(`Invalid context store endpoint "${custom}"`);
+    }
+    if (e.protocol !== "http:" && e.protocol !== "https:") throw new Error(`Invalid context store endpoint "${custom}"`);
+    endpoint = `${e.protocol}//${e.host}`;
+    pathStyle = true;
+  }
+
+  const request = async (
+    method: string,
+    key: string,
+    query: Record<string, string> = {},
+    body: string | Uint8Array = "",
+    extra: Record<string, string> = {},
+  ) => {
+    let path = pathStyle ? `/${bucket}/` : "/";
+    if (key) path += key.split("/").map(s3Escape).join("/");
+    const search = Object.keys(query)
+      .sort()
+      .map((name) => `${s3Escape(name)}=${s3Escape(query[name])}`)
+      .join("&");
+    const target = new URL(endpoint + path + (search ? `?${search}` : ""));
+    const headers: Record<string, string> = { ...extra };
+    signS3Request(method, target, headers, body, creds, region);
+    return fetchContextStore(target.href, { method, headers, ...(method === "PUT" ? { body } : {}) });
+  };
+  const failed = async (res: Response) => {
+    const text = await res.text().catch(() => "");
+    const code = /<Code>([^<]*)<\/Code>/.exec(text)?.[1];
+    const message = /<Message>([^<]*)<\/Message>/.exec(text)?.[1] ?? "";
+    return new Error(`context store returned ${res.status} ${res.statusText}${code ? `: ${code}: ${message}` : ""}`);
+  };
+
+  return {
+    async put(path, data) {
+      const res = await request("PUT", keyPrefix + path, {}, data);
+      if (!res.ok) throw await failed(res);
+    },
+    async create(path, data) {
+      // A conditional PutObject: 412 if the key exists, 409 if another
+      // conditional write to it is in flight
+      const res = await request("PUT", keyPrefix + path, {}, data, { "If-None-Match": "*" });
+      if (res.status === 412 || res.status === 409) return false;
+      if (!res.ok) throw await failed(res);
+      return true;
+    },
+    async get(path) {
+      const res = await request("GET", keyPrefix + path);
+      if (res.status === 404) return null;
+      if (!res.ok) throw await failed(res);
+      return res.text();
+    },
+    async getBytes(path) {
+      const res = await request("GET", keyPrefix + path);
+      if (res.status === 404) return null;
+      if (!res.ok) throw await failed(res);
+      return new Uint8Array(await res.arrayBuffer());
+    },
+    async list(prefix) {
+      const objects: ContextObject[] = [];
+      const query: Record<string, string> = { "list-type": "2", prefix: keyPrefix + prefix };
+      const unescape = (s: string) =>
+        s.replace(/&lt;/g, "<").replace(/&gt;/g, ">").replace(/&quot;/g, '"').replace(/&apos;/g, "'").replace(/&amp;/g, "&");
+      for (;;) {
+        const res = await request("GET", "", query);
+        if (!res.ok) throw await failed(res);
+        const xml = await res.text();
+        for (const [, contents] of xml.matchAll(/<Contents>([\s\S]*?)<\/Contents>/g)) {
+          const field = (name: string) => new RegExp(`<${name}>([^<]*)</${name}>`).exec(contents)?.[1] ?? "";
+          objects.push({
+            path: unescape(field("Key")).slice(keyPrefix.length),
+            size: Number(field("Size")),
+            modified: field("LastModified"),
+          });
+        }
+        const token = /<NextContinuationToken>([^<]*)<\/NextContinuationToken>/.exec(xml)?.[1];
+        if (!/<IsTruncated>true<\/IsTruncated>/.test(xml) || !token) break;
+        query["continuation-token"] = unescape(token);
+      }
+      return objects.sort((a, b) => (a.path < b.path ? -1 : a.path > b.path ? 1 : 0));
+    },
+  };
 }
@@ -27,6 +27,7 @@ export function generateHelp(def: AgentDefinition): string {
   lines.push("  --no-log               Suppress execution logging");
   lines.push("  --max-depth <n>        Maximum subagent invocation depth (default: 5)");
   lines.push("  --services-down        Tear down docker compose services and exit");
+  lines.push("  --services-profile P   Also start services in compose profile P");
   lines.push("  --yes                  Auto-confirm prompts");
   lines.push("  --non-interactive      Disable interactive prompts");
   lines.push("  --mcp                  Run as MCP server over stdio");
@@ -108,6 +109,7 @@ export function generateDescribe(def: AgentDefinition): Record<string, unknown>
 
   if (def.services) {
     describe.services = Object.keys(def.services);
+    if (Object.values(def.services).some((svc) => svc.gpus)) describe.requiresGPU = true;
   }
 
   if (def.mcpSupported && def.tools) {
@@ -1,19 +1,31 @@
 export type {
   AgentDefinition,
   AgentResult,
+  StructuredError,
   ExecuteContext,
   EnvDeclaration,
   ServiceDefinition,
   ContextEntry,
+  ContextAttachment,
   InvokeResult,
   InvokeOptions,
   WriteContextInput,
+  UpdateContextInput,
   SearchContextInput,
+  ContextExportOptions,
+  SummarizeSessionOptions,
+  ContextGraph,
+  ContextNode,
+  ContextSession,
+  ContextEdge,
+  ContextSchema,
   AgentOption,
   McpToolDefinition,
   TrustLevel,
   ContextType,
+  ContextSort,
   ServiceLifecycle,
+  DependsOnCondition,
   OutputFormat,
 } from "./types";
 
@@ -23,20 +35,68 @@ export type { SfaConfig, AgentNamespaceConfig } from "./config";
 export { loadConfig, saveConfig, getConfigPath, mergeConfig, applyEnvOverrides } from "./config";
 export { resolveEnv, validateEnv, injectEnv, maskSecrets, buildSubagentEnv, runSetup } from "./env";
 export { initSafety, checkDepthLimit, checkLoop, buildSubagentSafetyEnv } from "./safety";
-export { resolveLoggingConfig, createLogEntry, writeLogEntry } from "./logging";
-export type { LogEntry, LoggingConfig } from "./logging";
-export { resolveContextStorePath, writeContext, searchContext, updateContext, addContextLink } from "./context";
+export {
+  resolveLoggingConfig,
+  createLogEntry,
+  writeLogEntry,
+  queryLogs,
+  flushLogs,
+  attachLogSinks,
+  closeLogSinks,
+  aggregateLogs,
+  upgradeLogEntry,
+  LOG_SCHEMA_VERSION,
+} from "./logging";
+export type { LogEntry, LogInvocation, LogQuery, LoggingConfig, LogSink, LogSinkConfig, LogStats } from "./logging";
+export {
+  resolveContextStorePath,
+  writeContext,
+  readContext,
+  readContextAttachment,
+  searchContext,
+  searchContextSimilar,
+  updateContext,
+  addContextLink,
+  exportContext,
+  importContext,
+  resolveContextStoreUrl,
+  openContextStore,
+  relatedContext,
+  listSessions,
+  watchContext,
+  summarizeSession,
+  ContextEntryError,
+} from "./context";
+export type { ContextStore, ContextDocument } from "./context";
 export { invoke } from "./invoke";
-export { startServices, stopServices, composeDown, handleServicesDown, checkDockerAvailability } from "./services";
+export {
+  startServices,
+  stopServices,
+  composeDown,
+  handleServicesDown,
+  checkContainerEngine,
+  checkDockerAvailability,
+  holdSessionServices,
+  endSessionServices,
+  resolveServiceProfiles,
+  activeServices,
+} from "./services";
+export type { ContainerEngine } from "./services";
 export { serveMcp } from "./mcp";
 
 import type { AgentDefinition, AgentResult, ExecuteContext } from "./types";
-import type { WriteContextInput, SearchContextInput } from "./types";
+import type {
+  WriteContextInput,
+  UpdateContextInput,
+  SearchContextInput,
+  ContextExportOptions,
+  SummarizeSessionOptions,
+} from "./types";
 import { ExitCode } from "./types";
 import { parseArgs } from "./cli";
 import { generateHelp, generateDescribe } from "./help";
 import { readInput } from "./input";
-import { writeResult, exitWithError, emitProgress } from "./output";
+import { writeResult, writeFailure, exitWithError, emitProgress, configureStderrLog } from "./output";
 import { loadConfig, applyEnvOverrides, mergeConfig } from "./config";
 import {
   resolveEnv,
@@ -47,14 +107,44 @@ import {
   runSetup,
 } from "./env";
 import { initSafety, setupTimeout, setupSignalHandlers } from "./safety";
-import { resolveLoggingConfig, createLogEntry, writeLogEntry } from "./logging";
 import {
-  resolveContextStorePath,
-  writeContext as writeContextImpl,
-  searchContext as searchContextImpl,
+  resolveLoggingConfig,
+  createLogEntry,
+  writeLogEntry,
+  queryLogs,
+  setLogMeta,
+  attachLogSinks,
+  closeLogSinks,
+  createInvocationLog,
+  writeInvocationReport,
+} from "./logging";
+import type { LogQuery } from "./logging";
+import {
+  openContextStore,
+  editContextEntry,
+  appendContextContent,
+  updateContextDocument,
+  replaceContextTags,
+  relatedContext,
+  listSessions,
+  watchContext,
+  findDuplicateContext,
+  summarizeSession,
+  validateContextEntry,
+  validateContextUpdate,
+  validateContextTags,
+  type ContextStore,
 } from "./context";
 import { invoke as invokeSubagent } from "./invoke";
-import { startServices, stopServices, handleServicesDown } from "./services";
+import {
+  startServices,
+  stopServices,
+  handleServicesDown,
+  endSessionServices,
+  createServiceMonitor,
+  resolveServiceProfiles,
+  isServiceExternallyConfigured,
+} from "./services";
 import { serveMcp } from "./mcp";
 
 /**
@@ -77,6 +167,7 @@ export function defineAgent(definition: AgentDefinition): void {
 async function runAgent(def: AgentDefinition): Promise<void> {
   const startTime = Date.now();
   const args = parseArgs(process.argv.slice(2), def.options);
+  configureStderrLog(args.flags);
 
   // Handle unknown flags
   if (args.unknown.length > 0) {
@@ -165,14 +256,20 @@ async function runAgent(def: AgentDefinition): Promise<void> {
 
     const safety = initSafety(def.name, args.flags["max-depth"]);
     const loggingConfig = resolveLoggingConfig(config, args.flags["no-log"]);
-    const contextStorePath = resolveContextStorePath(config);
+    attachLogSinks(loggingConfig, def.logSinks);
+    let contextStore: ContextStore;
+    try {
+      contextStore = openContextStore(config);
+    } catch (err: unknown) {
+      exitWithError((err as Error).message, ExitCode.INVALID_USAGE);
+    }
 
     // 10.1: Switch to MCP server mode — does not return
     await serveMcp({
       def,
       safety,
       loggingConfig,
-      contextStorePath,
+      contextStore,
       resolvedEnv,
       mergedConfig,
       quiet: args.flags.quiet,
@@ -187,16 +284,44 @@ async function runAgent(def: AgentDefinition): Promise<void> {
 
   // --- Section 6: Resolve logging config ---
   const loggingConfig = resolveLoggingConfig(config, args.flags["no-log"]);
+  attachLogSinks(loggingConfig, def.logSinks);
 
-  // --- Section 7: Resolve context store path ---
-  const contextStorePath = resolveContextStorePath(config);
+  // --- Section 7: Open the context store ---
+  let contextStore: ContextStore;
+  try {
+    contextStore = openContextStore(config);
+  } catch (err: unknown) {
+    exitWithError((err as Error).message, ExitCode.INVALID_USAGE);
+  }
 
   // Track context files written during this invocation (for log cross-reference)
   const contextFilesWritten: string[] = [];
+  const logMeta: Record<string, unknown> = {};
+  // Record the subagents invoked, and report them to a parent as this agent exits
+  const invocations = createInvocationLog(startTime);
+  process.once("exit", () => writeInvocationReport(def.name, def.version, invocations.list()));
+  const executionMeta = () => {
+    const invoked = invocations.list();
+    const meta = {
+      ...logMeta,
+      ...(contextFilesWritten.length > 0 ? { contextFiles: contextFilesWritten } : {}),
+      ...(invoked.length > 0 ? { invocations: invoked } : {}),
+    };
+    return Object.keys(meta).length > 0 ? meta : undefined;
+  };
 
   // --- Section 9: Start services if declared ---
+  // Session services outlive this invocation until the root agent exits
+  let heldServices: string | null = null;
+  const profiles = def.services ? await resolveServiceProfiles(def, args.flags["services-profile"]) : [];
+  const services = createServiceMonitor(
+    def,
+    Object.keys(def.services ?? {}).every((name) => isServiceExternallyConfigured(name)),
+    profiles,
+  );
   if (def.services && Object.keys(def.services).length > 0) {
-    await startServices(def, process.env as Record<string, string | undefined>);
+    await startServices(def, process.env as Record<string, string | undefined>, profiles);
+    if (def.serviceLifecycle === "session") heldServices = def.name;
   }
 
   // Read input context
@@ -246,15 +371,56 @@ async function runAgent(def: AgentDefinition): Promise<void> {
       const elapsed = Date.now() - startTime;
       const totalTimeoutMs = args.flags.timeout * 1000;
       const remainingMs = totalTimeoutMs - elapsed;
-      return invokeSubagent(targetAgent, safety, remainingMs > 0 ? remainingMs : undefined, ac.signal, invokeOpts);
+      return invokeSubagent(
+        targetAgent,
+        safety,
+        remainingMs > 0 ? remainingMs : undefined,
+        ac.signal,
+        invokeOpts,
+        invocations,
+      );
     },
     writeContext: async (entry: WriteContextInput): Promise<string> => {
-      const filePath = writeContextImpl(entry, def.name, safety.sessionId, contextStorePath);
+      validateContextEntry(entry, def.contextSchema);
+      if (!entry.allowDuplicate) {
+        const existing = await findDuplicateContext(contextStore, entry, def.name, safety.sessionId);
+        if (existing) return existing;
+      }
+      const filePath = await contextStore.write(entry, def.name, safety.sessionId);
       contextFilesWritten.push(filePath);
       return filePath;
     },
+    appendContext: (path: string, content: string) =>
+      editContextEntry(contextStore, path, def.name, (doc) => appendContextContent(doc, content)),
+    updateContext: async (path: string, entry: UpdateContextInput) => {
+      validateContextUpdate(entry);
+      await editContextEntry(contextStore, path, def.name, (doc) => updateContextDocument(doc, entry));
+    },
+    replaceTags: async (path: string, tags: string[]) => {
+      validateContextTags(tags);
+      await editContextEntry(contextStore, path, def.name, (doc) => replaceContextTags(doc, tags));
+    },
+    readContext: (pathOrId: string) => contextStore.read(pathOrId),
+    readAttachment: (path: string, name: string) => contextStore.readAttachment(path, name),
     searchContext: async (query: SearchContextInput): Promise<import("./types").ContextEntry[]> => {
-      return searchContextImpl(query, contextStorePath);
+      return contextStore.search(query);
+    },
+    relatedContext: (path: string, depth: number) => relatedContext(contextStore, path, depth),
+    listSessions: () => listSessions(contextStore),
+    watchContext: (query: SearchContextInput, signal?: AbortSignal) =>
+      watchContext(contextStore, query, signal ? AbortSignal.any([signal, ac.signal]) : ac.signal),
+    exportContext: async (options?: ContextExportOptions): Promise<Buffer> => contextStore.exportBundle(options),
+    importContext: async (data: Buffer | Uint8Array): Promise<string[]> => {
+      const filePaths = await contextStore.importBundle(data);
+      contextFilesWritten.push(...filePaths);
+      return filePaths;
+    },
+    summarizeSession: (options?: SummarizeSessionOptions) => summarizeSession(ctx, contextStore, options),
+    queryLogs: async (query?: LogQuery) => queryLogs(loggingConfig, query),
+    setMeta: (key: string, value: unknown) => setLogMeta(logMeta, key, value),
+    serviceLogs: (name: string, tail?: number) => services.logs(name, tail),
+    onServiceUnhealthy: (fn) => {
+      services.onUnhealthy(fn);
     },
   };
 
@@ -266,11 +432,13 @@ async function runAgent(def: AgentDefinition): Promise<void> {
   } catch (err: unknown) {
     cleanupTimeout();
     cleanupSignals();
+    services.stop();
 
     // Tear down ephemeral services on failure
     if (def.services && Object.keys(def.services).length > 0) {
       await stopServices(def.name, def.serviceLifecycle, def.services);
     }
+    await endSessionServices(safety.sessionId, heldServices, safety.depth === 0);
 
     if (ac.signal.aborted) {
       exitCode = ExitCode.TIMEOUT;
@@ -283,11 +451,14 @@ async function runAgent(def: AgentDefinition): Promise<void> {
         depth: safety.depth,
         callChain: safety.callChain,
         sessionId: safety.sessionId,
+        resolvedEnv,
         input,
         output: "Execution timed out",
-        meta: contextFilesWritten.length > 0 ? { contextFiles: contextFilesWritten } : undefined,
+        meta: executionMeta(),
       });
-      writeLogEntry(entry, loggingConfig);
+      await writeLogEntry(entry, loggingConfig);
+      await closeLogSinks(loggingConfig);
+      writeFailure({ code: "timeout", message: "execution timed out", retryable: true }, args.flags["output-format"]);
       process.exit(exitCode);
     }
 
@@ -305,22 +476,28 @@ async function runAgent(def: AgentDefinition): Promise<void> {
       depth: safety.depth,
       callChain: safety.callChain,
       sessionId: safety.sessionId,
+      resolvedEnv,
       input,
       output: (err as Error).message ?? String(err),
-      meta: contextFilesWritten.length > 0 ? { contextFiles: contextFilesWritten } : undefined,
+      meta: executionMeta(),
     });
-    writeLogEntry(entry, loggingConfig);
+    await writeLogEntry(entry, loggingConfig);
+    await closeLogSinks(loggingConfig);
 
-    exitWithError((err as Error).message ?? String(err), exitCode);
+    const message = (err as Error).message ?? String(err);
+    writeFailure({ code: "execution_failed", message, retryable: false }, args.flags["output-format"]);
+    exitWithError(message, exitCode);
   }
 
   cleanupTimeout();
   cleanupSignals();
+  services.stop();
 
   // Tear down ephemeral services on success
   if (def.services && Object.keys(def.services).length > 0) {
     await stopServices(def.name, def.serviceLifecycle, def.services);
   }
+  await endSessionServices(safety.sessionId, heldServices, safety.depth === 0);
 
   // Progress: completed
   if (!args.flags.quiet) {
@@ -340,11 +517,13 @@ async function runAgent(def: AgentDefinition): Promise<void> {
     depth: safety.depth,
     callChain: safety.callChain,
     sessionId: safety.sessionId,
+    resolvedEnv,
     input,
     output: outputStr,
-    meta: contextFilesWritten.length > 0 ? { contextFiles: contextFilesWritten } : undefined,
+    meta: executionMeta(),
   });
-  writeLogEntry(logEntry, loggingConfig);
+  await writeLogEntry(logEntry, loggingConfig);
+  await closeLogSinks(loggingConfig);
 
   // Write result to stdout
   writeResult(result, args.flags["output-format"]);
@@ -1,7 +1,12 @@
+import { tmpdir } from "node:os";
+import { join } from "node:path";
+import { rmSync } from "node:fs";
 import type { InvokeOptions, InvokeResult } from "./types";
 import type { SafetyState } from "./safety";
 import { checkDepthLimit, checkLoop, buildSubagentSafetyEnv } from "./safety";
 import { buildSubagentEnv } from "./env";
+import type { InvocationLog } from "./logging";
+import { readInvocationReport } from "./logging";
 
 /**
  * Active child processes tracked for cleanup on parent termination.
@@ -45,6 +50,7 @@ function installCleanupHandler(): void {
  * @param parentTimeoutMs - Remaining parent timeout in ms (for default subagent timeout)
  * @param signal - Parent AbortSignal for cancellation propagation
  * @param options - Context, args, and timeout override
+ * @param invocations - Records the invocation for the parent's log entry
  */
 export async function invoke(
   agentName: string,
@@ -52,6 +58,7 @@ export async function invoke(
   parentTimeoutMs: number | undefined,
   signal: AbortSignal,
   options: InvokeOptions = {},
+  invocations?: InvocationLog,
 ): Promise<InvokeResult> {
   // 8.5: Depth limit check before spawning
   checkDepthLimit(safety);
@@ -69,6 +76,10 @@ export async function invoke(
   const safetyEnv = buildSubagentSafetyEnv(safety);
   const env = { ...baseEnv, ...safetyEnv };
 
+  // The child reports its version and its own invocations via a file
+  const reportFile = join(tmpdir(), `sfa-invocation-${process.pid}-${crypto.randomUUID()}.json`);
+  env.SFA_INVOCATION_FILE = reportFile;
+
   // Build the command
   const cmd = [agentName, ...(options.args ?? [])];
 
@@ -78,6 +89,7 @@ export async function invoke(
     : parentTimeoutMs;
 
   // Spawn the subprocess
+  const record = invocations?.begin(agentName);
   const proc = Bun.spawn(cmd, {
     env: env as Record<string, string>,
     stdin: options.context ? new Blob([options.context]) : "ignore",
@@ -145,10 +157,13 @@ export async function invoke(
   signal.removeEventListener("abort", onAbort);
   activeChildren.delete(proc);
 
-  return {
+  const result = {
     ok: exitCode === 0,
     exitCode: timedOut ? 3 : exitCode,
     output: stdout,
     stderr,
   };
+  record?.(result.exitCode, readInvocationReport(reportFile));
+  rmSync(reportFile, { force: true });
+  return result;
 }
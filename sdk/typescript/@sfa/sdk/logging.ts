@@ -1,17 +1,72 @@
 import { join, dirname, basename } from "node:path";
-import { mkdirSync, statSync, renameSync, readdirSync, unlinkSync, openSync, writeSync, closeSync, constants } from "node:fs";
-import { homedir } from "node:os";
+import { gunzipSync, gzipSync } from "node:zlib";
+import { createSocket } from "node:dgram";
+import { connect } from "node:net";
+import { hostname } from "node:os";
+import {
+  mkdirSync,
+  existsSync,
+  statSync,
+  renameSync,
+  readdirSync,
+  readFileSync,
+  unlinkSync,
+  writeFileSync,
+  openSync,
+  writeSync,
+  closeSync,
+  constants,
+} from "node:fs";
 import type { SfaConfig } from "./config";
+import { maskSecrets } from "./env";
+import type { ResolvedEnv } from "./env";
+import { stderrLog } from "./output";
+import { parseDuration } from "./services";
+import { dataDir } from "./paths";
 
-const DEFAULT_LOG_DIR = join(homedir(), ".local", "share", "single-file-agents", "logs");
-const DEFAULT_LOG_FILE = join(DEFAULT_LOG_DIR, "executions.jsonl");
 const DEFAULT_MAX_SIZE_BYTES = 50 * 1024 * 1024; // 50MB
 const DEFAULT_RETAIN_COUNT = 5;
+const DEFAULT_FLUSH_INTERVAL_MS = 1000;
+const LOG_BUFFER_BYTES = 64 * 1024; // flush at once when this much is pending
+
+/**
+ * The version of the log entry schema the SDK writes, as each entry's
+ * schemaVersion. Entries from before the field was added are version 1.
+ *
+ * Adding an optional field keeps the version: readers ignore fields they do
+ * not know. Renaming, removing, or changing the type or meaning of a field
+ * bumps it, with a migration in LOG_MIGRATIONS that upgrades entries of the
+ * previous version, so that readers keep reading older files.
+ */
+export const LOG_SCHEMA_VERSION = 2;
+
+/** LOG_MIGRATIONS[i] upgrades an entry of schema version i + 1 to version i + 2, in place. */
+const LOG_MIGRATIONS: Array<(fields: Record<string, unknown>) => void> = [
+  // 1 → 2: callChain is always a list, empty rather than null or missing
+  (fields) => {
+    if (fields.callChain == null) fields.callChain = [];
+  },
+];
+
+/**
+ * Upgrade a parsed entry of an older schema version to LOG_SCHEMA_VERSION.
+ * An entry of a newer version is returned as is.
+ */
+export function upgradeLogEntry(fields: Record<string, unknown>): LogEntry {
+  const version = typeof fields.schemaVersion === "number" ? fields.schemaVersion : 1;
+  if (version < LOG_SCHEMA_VERSION) {
+    for (let v = Math.max(version, 1); v < LOG_SCHEMA_VERSION; v++) LOG_MIGRATIONS[v - 1](fields);
+    fields.schemaVersion = LOG_SCHEMA_VERSION;
+  }
+  return fields as unknown as LogEntry;
+}
 
 /**
  * JSONL log entry schema.
  */
 export interface LogEntry {
+  /** See LOG_SCHEMA_VERSION */
+  schemaVersion: number;
   timestamp: string;
   agent: string;
   version: string;
@@ -25,6 +80,20 @@ export interface LogEntry {
   meta?: Record<string, unknown>;
 }
 
+/**
+ * Selects the entries queryLogs returns. Fields left out match every entry.
+ */
+export interface LogQuery {
+  agent?: string;
+  sessionId?: string;
+  /** Only entries logged at or after this time */
+  since?: Date | string;
+  /** Only entries of executions that exited with this code */
+  exitCode?: number;
+  /** At most the newest this many entries (default all) */
+  limit?: number;
+}
+
 /**
  * Logging configuration resolved from env/config/defaults.
  */
@@ -37,8 +106,42 @@ export interface LoggingConfig {
   maxSizeBytes: number;
   /** Number of rotated files to retain */
   retainCount: number;
+  /** Also log each agent's entries to its own file (logging.perAgent) */
+  perAgent?: boolean;
+  /** Log them to the agent's file instead of filePath (logging.perAgent: "only") */
+  perAgentOnly?: boolean;
+  /** Where else entries are written: logging.sinks, then the agent's logSinks */
+  sinks?: LogSink[];
+  /** Buffer entries, appending them at most this long after they are logged; 0 appends each at once (logging.buffer) */
+  flushIntervalMs?: number;
+}
+
+/**
+ * Receives an agent's execution log entries. The JSONL log file is the
+ * default sink; logging.sinks in the shared config and the agent's logSinks
+ * add others, such as a collector over HTTP or an in-memory sink for tests.
+ * Sinks are best-effort: their errors are warned on stderr and never change
+ * the exit code, naming the sink by its name, or else its class.
+ */
+export interface LogSink {
+  /** Names the sink in warnings */
+  name?: string;
+  /** Receive an entry as an execution ends. */
+  write(entry: LogEntry): void | Promise<void>;
+  /** Deliver the entries the sink holds; flushLogs calls it. */
+  flush?(): void | Promise<void>;
+  /** Flush the sink and release it. The SDK calls it once, as the agent exits. */
+  close?(): void | Promise<void>;
 }
 
+/**
+ * A destination besides the log file that entries are shipped to, resolved
+ * from one entry of logging.sinks.
+ */
+export type LogSinkConfig =
+  | { type: "otlp"; endpoint: string; headers: Record<string, string> }
+  | { type: "syslog"; network?: "udp" | "tcp" | "unix"; address?: string; facility: string; tag?: string };
+
 /**
  * Resolve logging configuration from environment, config, and defaults.
  * Priority: SFA_LOG_FILE env → config logging.file → default path
@@ -49,7 +152,7 @@ export function resolveLoggingConfig(config: SfaConfig, noLogFlag: boolean): Log
   const filePath =
     process.env.SFA_LOG_FILE ??
     config.logging?.file ??
-    DEFAULT_LOG_FILE;
+    dataDir("logs", "executions.jsonl");
 
   const maxSizeBytes = config.logging?.maxSize
     ? config.logging.maxSize * 1024 * 1024
@@ -57,7 +160,53 @@ export function resolveLoggingConfig(config: SfaConfig, noLogFlag: boolean): Log
 
   const retainCount = config.logging?.retainFiles ?? DEFAULT_RETAIN_COUNT;
 
-  return { filePath, suppressed, maxSizeBytes, retainCount };
+  return {
+    filePath,
+    suppressed,
+    maxSizeBytes,
+    retainCount,
+    ...resolveLogPerAgent(config),
+    sinks: suppressed ? [] : resolveLogSinks(config),
+    flushIntervalMs: resolveLogBuffer(config),
+  };
+}
+
+/**
+ * Read logging.buffer: true buffers entries for up to a second, and a
+ * duration such as "250ms" for that long. 0 writes each entry as it is
+ * logged.
+ */
+function resolveLogBuffer(config: SfaConfig): number {
+  const v = config.logging?.buffer;
+  if (v === true) return DEFAULT_FLUSH_INTERVAL_MS;
+  if (typeof v === "string") {
+    const ms = parseDuration(v);
+    if (ms) return ms;
+    stderrLog.warn(`ignoring invalid logging.buffer "${v}" (use true, false, or a duration such as 500ms)`);
+  }
+  return 0;
+}
+
+/**
+ * Read logging.perAgent: true logs each agent's entries to its own file as
+ * well as the shared log, and "only" to its own file alone.
+ */
+function resolveLogPerAgent(config: SfaConfig): { perAgent: boolean; perAgentOnly: boolean } {
+  const v = config.logging?.perAgent;
+  if (v === "only") return { perAgent: true, perAgentOnly: true };
+  if (typeof v === "string") {
+    stderrLog.warn(`ignoring invalid logging.perAgent "${v}" (use true, false, or "only")`);
+  }
+  return { perAgent: v === true, perAgentOnly: false };
+}
+
+/**
+ * Return the file an agent's entries are logged to with logging.perAgent:
+ * a directory named for the agent beside the shared log, holding a file of
+ * the same name, as in logs/<agent>/executions.jsonl.
+ */
+function agentLogFile(filePath: string, agent: string): string {
+  return join(dirname(filePath), agent, basename(filePath));
 }
 
 /**
@@ -69,7 +218,9 @@ function truncate(text: string, maxLen: number): string {
 }
 
 /**
- * Create a log entry from execution data.
+ * Create a log entry from execution data, masking the agent's secret
+ * values in the input and output summaries and the metadata when given
+ * resolvedEnv.
  */
 export function createLogEntry(params: {
   agent: string;
@@ -82,8 +233,12 @@ export function createLogEntry(params: {
   input: string;
   output: string;
   meta?: Record<string, unknown>;
+  resolvedEnv?: ResolvedEnv;
 }): LogEntry {
+  const resolved = params.resolvedEnv;
+  const mask = (text: string) => (resolved ? maskSecrets(text, resolved) : text);
   return {
+    schemaVersion: LOG_SCHEMA_VERSION,
     timestamp: new Date().toISOString(),
     agent: params.agent,
     version: params.version,
@@ -91,13 +246,146 @@ export function createLogEntry(params: {
     durationMs: Date.now() - params.startTime,
     depth: params.depth,
     callChain: params.callChain,
-    inputSummary: truncate(params.input, 500),
-    outputSummary: truncate(params.output, 500),
+    inputSummary: truncate(mask(params.input), 500),
+    outputSummary: truncate(mask(params.output), 500),
     sessionId: params.sessionId,
-    ...(params.meta ? { meta: params.meta } : {}),
+    ...(params.meta ? { meta: maskLogValue(params.meta, mask) as Record<string, unknown> } : {}),
+  };
+}
+
+/**
+ * Record key in an execution's log entry metadata, as ctx.setMeta does. A
+ * value that cannot be encoded as JSON is refused with a warning, as it
+ * would keep the entry from being written.
+ */
+export function setLogMeta(meta: Record<string, unknown>, key: string, value: unknown): void {
+  let encoded: string | undefined;
+  try {
+    encoded = JSON.stringify(value);
+  } catch (err) {
+    stderrLog.warn(`ignoring log metadata "${key}": ${(err as Error).message}`);
+    return;
+  }
+  if (encoded === undefined) {
+    stderrLog.warn(`ignoring log metadata "${key}": ${typeof value} is not JSON`);
+    return;
+  }
+  meta[key] = value;
+}
+
+/**
+ * One subagent invocation in its parent's log entry, under
+ * meta.invocations, with the subagent's own invocations nested.
+ */
+export interface LogInvocation {
+  /** Order the invocation started in among its parent's */
+  seq: number;
+  agent: string;
+  /** The subagent's version, when it reported one */
+  version?: string;
+  exitCode: number;
+  durationMs: number;
+  /** Milliseconds from the start of the parent's execution */
+  offsetMs: number;
+  invocations?: LogInvocation[];
+}
+
+/**
+ * What a subagent writes to SFA_INVOCATION_FILE as it exits, for its parent
+ * to complete the subagent's LogInvocation.
+ */
+export interface InvocationReport {
+  agent: string;
+  version: string;
+  invocations?: LogInvocation[];
+}
+
+/**
+ * The subagent invocations of one execution.
+ */
+export interface InvocationLog {
+  /**
+   * Number an invocation of agent as it starts, returning the function that
+   * records it once the subagent exits, completed by the subagent's report
+   * if it wrote one.
+   */
+  begin(agent: string): (exitCode: number, report?: InvocationReport) => void;
+  /** The invocations recorded so far, in the order they started */
+  list(): LogInvocation[];
+}
+
+/**
+ * Create the invocation log of an execution that started at startTime.
+ */
+export function createInvocationLog(startTime: number): InvocationLog {
+  let invoked = 0;
+  const recorded: LogInvocation[] = [];
+  return {
+    begin(agent) {
+      const start = Date.now();
+      const invocation: LogInvocation = {
+        seq: ++invoked,
+        agent,
+        exitCode: 0,
+        durationMs: 0,
+        offsetMs: start - startTime,
+      };
+      return (exitCode, report) => {
+        invocation.exitCode = exitCode;
+        invocation.durationMs = Date.now() - start;
+        if (report) {
+          if (report.agent) invocation.agent = report.agent;
+          invocation.version = report.version;
+          if (report.invocations?.length) invocation.invocations = report.invocations;
+        }
+        recorded.push(invocation);
+      };
+    },
+    list: () => [...recorded].sort((a, b) => a.seq - b.seq),
   };
 }
 
+/**
+ * Write the agent's version and invocations to SFA_INVOCATION_FILE, if set,
+ * so the parent agent can record them.
+ */
+export function writeInvocationReport(agent: string, version: string, invocations: LogInvocation[]): void {
+  const path = process.env.SFA_INVOCATION_FILE;
+  if (!path) return;
+  const report: InvocationReport = { agent, version, ...(invocations.length > 0 ? { invocations } : {}) };
+  try {
+    writeFileSync(path, JSON.stringify(report), { mode: 0o600 });
+  } catch (err) {
+    stderrLog.warn(`failed to write invocation report: ${(err as Error).message}`);
+  }
+}
+
+/**
+ * Read the report a subagent's writeInvocationReport wrote, or undefined if
+ * it wrote none.
+ */
+export function readInvocationReport(path: string): InvocationReport | undefined {
+  try {
+    const data = readFileSync(path, "utf-8");
+    return data ? (JSON.parse(data) as InvocationReport) : undefined;
+  } catch {
+    return undefined;
+  }
+}
+
+/**
+ * Mask the strings of a metadata value, however deeply they are nested in
+ * objects and arrays.
+ */
+function maskLogValue(value: unknown, mask: (text: string) => string): unknown {
+  if (typeof value === "string") return mask(value);
+  if (Array.isArray(value)) return value.map((v) => maskLogValue(v, mask));
+  if (value && typeof value === "object" && Object.getPrototypeOf(value) === Object.prototype) {
+    return Object.fromEntries(Object.entries(value).map(([k, v]) => [k, maskLogValue(v, mask)]));
+  }
+  return value;
+}
+
 /**
  * Ensure the log directory exists.
  */
@@ -106,34 +394,88 @@ function ensureLogDir(filePath: string): void {
   mkdirSync(dir, { recursive: true });
 }
 
+/** How old a rotation lock must be to be taken as left behind by a crashed writer. */
+const LOG_ROTATE_LOCK_STALE_MS = 30_000;
+
 /**
- * Check if the log file needs rotation and rotate if so.
+ * Rotate the log file once it has reached the maximum size. Writers in any
+ * process and either SDK may reach the size at once, so it rotates only
+ * while holding `<log>.rotate.lock`, which it creates exclusively, and only
+ * if the file is still full once the lock is held. A writer that finds the
+ * lock held leaves the rotation to its holder. Rotated files are gzipped,
+ * as name-<time>.jsonl.gz. Throws if the rotation fails, for the caller to
+ * append all the same.
  */
-function rotateIfNeeded(config: LoggingConfig): void {
-  try {
-    const stat = statSync(config.filePath);
-    if (stat.size < config.maxSizeBytes) return;
-  } catch {
-    // File doesn't exist yet, no rotation needed
-    return;
-  }
+function rotateIfNeeded(filePath: string, config: LoggingConfig): void {
+  const full = () => {
+    try {
+      return statSync(filePath).size >= config.maxSizeBytes;
+    } catch {
+      // File doesn't exist yet, no rotation needed
+      return false;
+    }
+  };
+  if (!full()) return;
 
-  // Rotate: rename current file with timestamp suffix
-  const now = new Date().toISOString().replace(/[:.]/g, "").slice(0, 15);
-  const dir = dirname(config.filePath);
-  const base = basename(config.filePath, ".jsonl");
-  const rotatedName = `${base}-${now}.jsonl`;
-  const rotatedPath = join(dir, rotatedName);
+  const lockPath = `${filePath}.rotate.lock`;
+  const takeLock = () => {
+    try {
+      closeSync(openSync(lockPath, "wx"));
+      return true;
+    } catch (err) {
+      if ((err as NodeJS.ErrnoException).code === "EEXIST") return false;
+      throw err;
+    }
+  };
+  if (!takeLock()) {
+    try {
+      if (Date.now() - statSync(lockPath).mtimeMs < LOG_ROTATE_LOCK_STALE_MS) return;
+      unlinkSync(lockPath);
+    } catch {
+      return;
+    }
+    if (!takeLock()) return;
+  }
 
   try {
-    renameSync(config.filePath, rotatedPath);
-  } catch {
-    // If rename fails, skip rotation
-    return;
-  }
+    // Another writer may have rotated it before the lock was taken
+    if (!full()) return;
 
-  // Clean up old rotated files
-  cleanupRotatedFiles(dir, base, config.retainCount);
+    // Rotate: rename current file with timestamp suffix. A later rotation in
+    // the same second takes a numbered name, which sorts after the first and
+    // before the next second's.
+    const now = new Date().toISOString().replace(/[:.]/g, "").slice(0, 15);
+    const dir = dirname(filePath);
+    const base = basename(filePath, ".jsonl");
+    let rotatedPath = join(dir, `${base}-${now}.jsonl`);
+    for (let i = 1; existsSync(rotatedPath) || existsSync(rotatedPath + ".gz"); i++) {
+      rotatedPath = join(dir, `${base}-${now}_${String(i).padStart(3, "0")}.jsonl`);
+    }
+    renameSync(filePath, rotatedPath);
+
+    try {
+      // A writer that opened the file before it was rotated may still
+      // append to it, so compress until nothing more has arrived
+      let data = readFileSync(rotatedPath);
+      writeFileSync(rotatedPath + ".gz", gzipSync(data));
+      while (statSync(rotatedPath).size > data.length) {
+        data = readFileSync(rotatedPath);
+        writeFileSync(rotatedPath + ".gz", gzipSync(data));
+      }
+      unlinkSync(rotatedPath);
+    } catch (err) {
+      stderrLog.warn(`failed to compress rotated log: ${(err as Error).message}`);
+    }
+
+    // Clean up old rotated files
+    cleanupRotatedFiles(dir, base, config.retainCount);
+  } finally {
+    try {
+      unlinkSync(lockPath);
+    } catch {
+      // Best effort
+    }
+  }
 }
 
 /**
@@ -142,7 +484,7 @@ function rotateIfNeeded(config: LoggingConfig): void {
 function cleanupRotatedFiles(dir: string, basePrefix: string, retainCount: number): void {
   try {
     const files = readdirSync(dir)
-      .filter((f) => f.startsWith(basePrefix + "-") && f.endsWith(".jsonl"))
+      .filter((f) => f.startsWith(basePrefix + "-") && (f.endsWith(".jsonl") || f.endsWith(".jsonl.gz")))
       .sort()
       .reverse();
 
@@ -160,18 +502,92 @@ function cleanupRotatedFiles(dir: string, basePrefix: string, retainCount: numbe
 }
 
 /**
- * Write a log entry to the JSONL file using O_APPEND for atomic writes.
+ * Write a log entry to each sink of config: the log file, then the sinks of
+ * logging.sinks and the agent's logSinks. The returned promise settles once
+ * the sinks have the entry.
  * This is best-effort: failures emit a warning to stderr but never affect exit code.
  */
-export function writeLogEntry(entry: LogEntry, config: LoggingConfig): void {
+export async function writeLogEntry(entry: LogEntry, config: LoggingConfig): Promise<void> {
   if (config.suppressed) return;
 
+  for (const sink of logSinks(config)) {
+    try {
+      await sink.write(entry);
+    } catch (err) {
+      stderrLog.warn(`failed to write execution log to ${sinkName(sink)}: ${(err as Error).message}`);
+    }
+  }
+}
+
+/**
+ * The default sink: appends each entry to the JSONL file, and to the
+ * agent's own file with logging.perAgent, using O_APPEND for atomic writes
+ * and buffered with logging.buffer. Each file rotates on its own.
+ */
+function fileLogSink(config: LoggingConfig): LogSink {
+  return {
+    name: config.filePath,
+    write(entry) {
+      const line = JSON.stringify(entry) + "\n";
+      const write = config.flushIntervalMs ? bufferLogEntry : appendLogEntry;
+      if (!config.perAgentOnly) write(config.filePath, line, config);
+      if (config.perAgent && entry.agent) write(agentLogFile(config.filePath, entry.agent), line, config);
+    },
+    flush: flushLogBuffer,
+    close: flushLogBuffer,
+  };
+}
+
+/** The logging config of the running agent, whose sinks flushLogs flushes. */
+let activeLoggingConfig: LoggingConfig | undefined;
+
+/**
+ * Add an agent's logSinks to config, unless logging is suppressed, and make
+ * it the config flushLogs flushes the sinks of.
+ */
+export function attachLogSinks(config: LoggingConfig, sinks: LogSink[] = []): void {
+  if (!config.suppressed) config.sinks = [...(config.sinks ?? []), ...sinks];
+  activeLoggingConfig = config;
+}
+
+/** The sinks entries of config are written to: the log file first, then config.sinks. */
+function logSinks(config: LoggingConfig): LogSink[] {
+  return [fileLogSink(config), ...(config.sinks ?? [])];
+}
+
+/**
+ * Close the sinks of config, as the agent exits.
+ */
+export async function closeLogSinks(config: LoggingConfig): Promise<void> {
+  if (config.suppressed) return;
+  for (const sink of logSinks(config)) {
+    try {
+      await sink.close?.();
+    } catch (err) {
+      stderrLog.warn(`failed to close execution log sink ${sinkName(sink)}: ${(err as Error).message}`);
+    }
+  }
+}
+
+/** Name a sink in warnings. */
+function sinkName(sink: LogSink): string {
+  return sink.name || sink.constructor?.name || "sink";
+}
+
+/**
+ * Append serialized entries to the log file at filePath, rotating it first
+ * once it has reached the maximum size.
+ */
+function appendLogEntry(filePath: string, line: string, config: LoggingConfig): void {
   try {
-    ensureLogDir(config.filePath);
-    rotateIfNeeded(config);
+    ensureLogDir(filePath);
+    try {
+      rotateIfNeeded(filePath, config);
+    } catch (err: unknown) {
+      stderrLog.warn(`failed to rotate execution log, appending to it: ${(err as Error).message}`);
+    }
 
-    const line = JSON.stringify(entry) + "\n";
-    const fd = openSync(config.filePath, constants.O_WRONLY | constants.O_CREAT | constants.O_APPEND);
+    const fd = openSync(filePath, constants.O_WRONLY | constants.O_CREAT | constants.O_APPEND);
     try {
       writeSync(fd, line);
     } finally {
@@ -179,8 +595,412 @@ export function writeLogEntry(entry: LogEntry, config: LoggingConfig): void {
     }
   } catch (err: unknown) {
     // Non-blocking: warn on stderr, never fail
-    process.stderr.write(
-      `[sfa] warning: failed to write execution log: ${(err as Error).message}\n`,
-    );
+    stderrLog.warn(`failed to write execution log: ${(err as Error).message}`);
   }
 }
+
+/** Lines waiting to be appended with logging.buffer, by file. */
+const pendingLogs = new Map<string, { data: string; config: LoggingConfig }>();
+let pendingBytes = 0;
+let flushTimer: ReturnType<typeof setTimeout> | undefined;
+let flushOnExit = false;
+
+/**
+ * Queue a line for filePath, flushing after the config's flush interval,
+ * once LOG_BUFFER_BYTES are pending, or as the process exits. Each flush
+ * appends a file's pending lines with one write, opening the file and
+ * checking it for rotation once a batch rather than once an entry.
+ */
+function bufferLogEntry(filePath: string, line: string, config: LoggingConfig): void {
+  const pending = pendingLogs.get(filePath);
+  pendingLogs.set(filePath, { data: (pending?.data ?? "") + line, config });
+  pendingBytes += Buffer.byteLength(line);
+  if (!flushOnExit) {
+    process.once("exit", flushLogBuffer);
+    flushOnExit = true;
+  }
+  if (pendingBytes >= LOG_BUFFER_BYTES) {
+    flushLogBuffer();
+  } else if (!flushTimer) {
+    flushTimer = setTimeout(flushLogBuffer, config.flushIntervalMs);
+    flushTimer.unref?.();
+  }
+}
+
+/**
+ * Write the execution log entries buffered with logging.buffer, and flush
+ * the running agent's other sinks. The SDK flushes as the process exits and
+ * the log file before queryLogs reads it; call it to make entries visible
+ * to other processes sooner.
+ */
+export async function flushLogs(): Promise<void> {
+  flushLogBuffer();
+  for (const sink of activeLoggingConfig?.sinks ?? []) {
+    try {
+      await sink.flush?.();
+    } catch (err) {
+      stderrLog.warn(`failed to flush execution log sink ${sinkName(sink)}: ${(err as Error).message}`);
+    }
+  }
+}
+
+/** Append the entries buffered with logging.buffer to their files. */
+function flushLogBuffer(): void {
+  if (flushTimer) {
+    clearTimeout(flushTimer);
+    flushTimer = undefined;
+  }
+  const pending = [...pendingLogs].sort(([a], [b]) => (a < b ? -1 : a > b ? 1 : 0));
+  pendingLogs.clear();
+  pendingBytes = 0;
+  for (const [filePath, { data, config }] of pending) appendLogEntry(filePath, data, config);
+}
+
+/**
+ * Read the entries of the execution log of config and of its rotated files
+ * that match query, oldest first, after flushing buffered entries.
+ * Malformed lines are skipped, and a missing log has no entries.
+ *
+ * With logging.perAgent, a query for one agent reads the agent's own file,
+ * which may keep entries the shared log has rotated away. With "only", the
+ * shared log is read as well for entries from before it was set, and a
+ * query for every agent reads every agent's file.
+ */
+export function queryLogs(config: LoggingConfig, query: LogQuery = {}): LogEntry[] {
+  flushLogBuffer();
+  const filePath = config.filePath;
+  let logs = [filePath];
+  if (config.perAgent && query.agent) {
+    logs = config.perAgentOnly ? [filePath, agentLogFile(filePath, query.agent)] : [agentLogFile(filePath, query.agent)];
+  } else if (config.perAgentOnly) {
+    try {
+      for (const d of readdirSync(dirname(filePath), { withFileTypes: true })) {
+        if (d.isDirectory()) logs.push(agentLogFile(filePath, d.name));
+      }
+    } catch {
+      // No log directory yet
+    }
+  }
+
+  const files: string[] = [];
+  for (const log of logs) {
+    // Rotated files are name-<time>.jsonl, or name.<time>.jsonl as the Go
+    // SDK names them, each gzipped with .gz after it
+    const dir = dirname(log);
+    const base = basename(log, ".jsonl");
+    try {
+      files.push(
+        ...readdirSync(dir)
+          .filter((f) => f.startsWith(base + "-") || f.startsWith(base + "."))
+          .filter((f) => f.endsWith(".jsonl") || f.endsWith(".jsonl.gz"))
+          .filter((f) => f !== basename(log))
+          .map((f) => join(dir, f)),
+      );
+    } catch {
+      // No log directory yet
+    }
+    files.push(log);
+  }
+
+  const since = query.since !== undefined ? new Date(query.since).getTime() : undefined;
+  const entries: LogEntry[] = [];
+  for (const file of files) {
+    let text: string;
+    try {
+      const data = readFileSync(file);
+      text = (file.endsWith(".gz") ? gunzipSync(data) : data).toString("utf-8");
+    } catch {
+      continue;
+    }
+    for (const line of text.split("\n")) {
+      let e: LogEntry;
+      try {
+        const fields = JSON.parse(line);
+        if (!fields || typeof fields !== "object" || Array.isArray(fields)) continue;
+        e = upgradeLogEntry(fields);
+      } catch {
+        continue;
+      }
+      if (query.agent && e.agent !== query.agent) continue;
+      if (query.sessionId && e.sessionId !== query.sessionId) continue;
+      if (query.exitCode !== undefined && e.exitCode !== query.exitCode) continue;
+      if (since !== undefined && !(Date.parse(e.timestamp) >= since)) continue;
+      entries.push(e);
+    }
+  }
+  // Stable, so entries logged in the same instant keep their order
+  entries.sort((a, b) => (Date.parse(a.timestamp) || 0) - (Date.parse(b.timestamp) || 0));
+  return query.limit && query.limit > 0 ? entries.slice(-query.limit) : entries;
+}
+
+/**
+ * A summary of the execution log entries of one agent, or of one agent in
+ * one session.
+ */
+export interface LogStats {
+  agent: string;
+  /** Set when grouped by session */
+  sessionId?: string;
+  executions: number;
+  failures: number;
+  successRate: number;
+  p50DurationMs: number;
+  p95DurationMs: number;
+  /** Executions by UTC day, as 2026-02-21 */
+  perDay: Record<string, number>;
+  /** Non-zero exit codes, most common first */
+  failureCodes: Array<{ exitCode: number; count: number }>;
+}
+
+/**
+ * Summarize execution log entries, such as those queryLogs returns, per
+ * agent, or per agent and session with bySession, sorted by agent and then
+ * session. Durations are nearest-rank percentiles, and entries whose
+ * timestamp cannot be parsed are left out of perDay.
+ */
+export function aggregateLogs(entries: LogEntry[], options: { bySession?: boolean } = {}): LogStats[] {
+  const groups = new Map<string, { stats: LogStats; durations: number[]; codes: Map<number, number> }>();
+  for (const e of entries) {
+    const sessionId = options.bySession ? e.sessionId : undefined;
+    const key = JSON.stringify([e.agent, sessionId ?? ""]);
+    let g = groups.get(key);
+    if (!g) {
+      const stats: LogStats = {
+        agent: e.agent,
+        ...(sessionId !== undefined ? { sessionId } : {}),
+        executions: 0,
+        failures: 0,
+        successRate: 0,
+        p50DurationMs: 0,
+        p95DurationMs: 0,
+        perDay: {},
+        failureCodes: [],
+      };
+      g = { stats, durations: [], codes: new Map() };
+      groups.set(key, g);
+    }
+    g.stats.executions++;
+    g.durations.push(e.durationMs);
+    if (e.exitCode !== 0) {
+      g.stats.failures++;
+      g.codes.set(e.exitCode, (g.codes.get(e.exitCode) ?? 0) + 1);
+    }
+    const ts = new Date(e.timestamp);
+    if (!Number.isNaN(ts.getTime())) {
+      const day = ts.toISOString().slice(0, 10);
+      g.stats.perDay[day] = (g.stats.perDay[day] ?? 0) + 1;
+    }
+  }
+
+  const percentile = (sorted: number[], p: number) => sorted[Math.max(Math.ceil((p / 100) * sorted.length), 1) - 1];
+  const stats = [...groups.values()].map(({ stats, durations, codes }) => {
+    durations.sort((a, b) => a - b);
+    stats.successRate = (stats.executions - stats.failures) / stats.executions;
+    stats.p50DurationMs = percentile(durations, 50);
+    stats.p95DurationMs = percentile(durations, 95);
+    stats.failureCodes = [...codes]
+      .map(([exitCode, count]) => ({ exitCode, count }))
+      .sort((a, b) => b.count - a.count || a.exitCode - b.exitCode);
+    return stats;
+  });
+  const cmp = (a: string, b: string) => (a < b ? -1 : a > b ? 1 : 0);
+  return stats.sort((a, b) => cmp(a.agent, b.agent) || cmp(a.sessionId ?? "", b.sessionId ?? ""));
+}
+
+const SYSLOG_FACILITIES: Record<string, number> = {
+  user: 1,
+  daemon: 3,
+  local0: 16,
+  local1: 17,
+  local2: 18,
+  local3: 19,
+  local4: 20,
+  local5: 21,
+  local6: 22,
+  local7: 23,
+};
+
+/**
+ * Read logging.sinks, warning about and skipping any sink that cannot be
+ * used. An otlp sink without an endpoint uses SFA_OTEL_ENDPOINT.
+ */
+function resolveLogSinks(config: SfaConfig): LogSink[] {
+  const list = config.logging?.sinks;
+  if (list === undefined) return [];
+  if (!Array.isArray(list)) {
+    stderrLog.warn("ignoring logging.sinks: it must be a list");
+    return [];
+  }
+  const sinks: LogSink[] = [];
+  list.forEach((m, i) => {
+    try {
+      const sink = newLogSink(m ?? {});
+      sinks.push({
+        name: sink.type === "otlp" ? sink.endpoint : sink.address ? `syslog at ${sink.address}` : "syslog",
+        write: (entry) => sendLogEntry(sink, entry),
+      });
+    } catch (err) {
+      stderrLog.warn(`ignoring logging.sinks[${i}]: ${(err as Error).message}`);
+    }
+  });
+  return sinks;
+}
+
+function newLogSink(m: Record<string, unknown>): LogSinkConfig {
+  switch (m.type) {
+    case "otlp": {
+      let endpoint = (typeof m.endpoint === "string" && m.endpoint) || process.env.SFA_OTEL_ENDPOINT;
+      if (!endpoint) throw new Error("an otlp sink needs an endpoint, or SFA_OTEL_ENDPOINT");
+      endpoint = endpoint.replace(/\/$/, "");
+      if (!endpoint.endsWith("/v1/logs")) endpoint += "/v1/logs";
+      const headers: Record<string, string> = {};
+      for (const [k, v] of Object.entries((m.headers as Record<string, unknown>) ?? {})) headers[k] = String(v);
+      return { type: "otlp", endpoint, headers };
+    }
+    case "syslog": {
+      const sink: Extract<LogSinkConfig, { type: "syslog" }> = { type: "syslog", facility: "user" };
+      if (typeof m.address === "string" && m.address) {
+        const match = /^(udp|tcp):\/\/(.+)$|^unix:\/\/(\/.+)$/.exec(m.address);
+        if (!match) {
+          throw new Error(`invalid address "${m.address}" (use udp://host:port, tcp://host:port, or unix:///path)`);
+        }
+        sink.network = match[3] ? "unix" : (match[1] as "udp" | "tcp");
+        sink.address = match[3] ?? match[2];
+      }
+      if (typeof m.facility === "string" && m.facility) {
+        if (!(m.facility in SYSLOG_FACILITIES)) throw new Error(`unknown facility "${m.facility}"`);
+        sink.facility = m.facility;
+      }
+      if (typeof m.tag === "string" && m.tag) sink.tag = m.tag;
+      return sink;
+    }
+    case undefined:
+    case "":
+      throw new Error("missing type (otlp or syslog)");
+    default:
+      throw new Error(`unknown type "${m.type}" (use otlp or syslog)`);
+  }
+}
+
+/**
+ * Ship one entry to a sink: to OTLP as a log record over HTTP in the JSON
+ * encoding, INFO for a successful execution and ERROR otherwise; to syslog
+ * as the entry's JSON line, through the logger command when the sink has
+ * no address.
+ */
+async function sendLogEntry(sink: LogSinkConfig, entry: LogEntry): Promise<void> {
+  const failed = entry.exitCode !== 0;
+  if (sink.type === "otlp") {
+    const res = await fetch(sink.endpoint, {
+      method: "POST",
+      headers: { "Content-Type": "application/json", ...sink.headers },
+      body: JSON.stringify(otlpLogPayload(entry)),
+      signal: AbortSignal.timeout(3000),
+    });
+    if (!res.ok) throw new Error(`HTTP ${res.status}`);
+    return;
+  }
+
+  const tag = sink.tag ?? entry.agent;
+  const data = JSON.stringify(entry);
+  if (!sink.network) {
+    const proc = Bun.spawn(["logger", "-t", tag, "-p", `${sink.facility}.${failed ? "err" : "info"}`, "--", data], {
+      stdout: "ignore",
+      stderr: "pipe",
+    });
+    if ((await proc.exited) !== 0) throw new Error((await new Response(proc.stderr).text()).trim() || "logger failed");
+    return;
+  }
+
+  const pri = SYSLOG_FACILITIES[sink.facility] * 8 + (failed ? 3 : 6);
+  const msg =
+    sink.network === "unix"
+      ? `<${pri}>${syslogStamp(new Date())} ${tag}[${process.pid}]: ${data}`
+      : `<${pri}>${new Date().toISOString()} ${hostname()} ${tag}[${process.pid}]: ${data}`;
+  const address = sink.address!;
+  if (sink.network === "udp") {
+    const sep = address.lastIndexOf(":");
+    const host = address.slice(0, sep).replace(/^\[|\]$/g, "");
+    const socket = createSocket(host.includes(":") ? "udp6" : "udp4");
+    try {
+      await new Promise<void>((resolve, reject) =>
+        socket.send(msg, Number(address.slice(sep + 1)), host, (err) => (err ? reject(err) : resolve())),
+      );
+    } finally {
+      socket.close();
+    }
+    return;
+  }
+  await new Promise<void>((resolve, reject) => {
+    const sep = address.lastIndexOf(":");
+    const socket =
+      sink.network === "unix"
+        ? connect(address)
+        : connect(Number(address.slice(sep + 1)), address.slice(0, sep).replace(/^\[|\]$/g, ""));
+    socket.setTimeout(3000, () => socket.destroy(new Error("timed out")));
+    socket.on("error", reject);
+    socket.on("connect", () => socket.end(sink.network === "tcp" ? msg + "\n" : msg, () => resolve()));
+  });
+}
+
+/**
+ * Format a time as syslog's local timestamps are: "Feb 21 14:30:22".
+ */
+function syslogStamp(d: Date): string {
+  const month = d.toLocaleString("en-US", { month: "short" });
+  const pad = (n: number) => String(n).padStart(2, "0");
+  return `${month} ${String(d.getDate()).padStart(2, " ")} ${pad(d.getHours())}:${pad(d.getMinutes())}:${pad(d.getSeconds())}`;
+}
+
+/**
+ * Build an OTLP ExportLogsServiceRequest holding entry as one record, with
+ * the entry's fields as attributes.
+ */
+function otlpLogPayload(entry: LogEntry): Record<string, unknown> {
+  const attribute = (key: string, value: unknown) => ({
+    key,
+    value:
+      typeof value === "number" && Number.isInteger(value)
+        ? { intValue: String(value) }
+        : { stringValue: String(value) },
+  });
+  const failed = entry.exitCode !== 0;
+  const nanos = (ms: number) => (BigInt(ms) * 1_000_000n).toString();
+  const attributes = [
+    attribute("schemaVersion", entry.schemaVersion),
+    attribute("agent", entry.agent),
+    attribute("version", entry.version),
+    attribute("exitCode", entry.exitCode),
+    attribute("durationMs", entry.durationMs),
+    attribute("depth", entry.depth),
+    attribute("callChain", entry.callChain.join(",")),
+    attribute("inputSummary", entry.inputSummary),
+    attribute("outputSummary", entry.outputSummary),
+    attribute("sessionId", entry.sessionId),
+  ];
+  if (entry.meta) attributes.push(attribute("meta", JSON.stringify(entry.meta)));
+  return {
+    resourceLogs: [
+      {
+        resource: {
+          attributes: [attribute("service.name", entry.agent), attribute("service.version", entry.version)],
+        },
+        scopeLogs: [
+          {
+            scope: { name: "sfa-sdk-typescript" },
+            logRecords: [
+              {
+                timeUnixNano: nanos(Date.parse(entry.timestamp) || Date.now()),
+                observedTimeUnixNano: nanos(Date.now()),
+                severityNumber: failed ? 17 : 9,
+                severityText: failed ? "ERROR" : "INFO",
+                body: { stringValue: `${entry.agent} exited ${entry.exitCode} in ${entry.durationMs}ms` },
+                attributes,
+              },
+            ],
+          },
+        ],
+      },
+    ],
+  };
+}
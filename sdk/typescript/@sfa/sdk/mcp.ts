@@ -4,22 +4,50 @@ import type {
   ExecuteContext,
   McpToolDefinition,
   WriteContextInput,
+  UpdateContextInput,
   SearchContextInput,
+  ContextExportOptions,
+  SummarizeSessionOptions,
   InvokeOptions,
 } from "./types";
 import { ExitCode } from "./types";
 import type { SafetyState } from "./safety";
-import type { LoggingConfig } from "./logging";
+import type { LogQuery, LoggingConfig } from "./logging";
 import type { ResolvedEnv } from "./env";
-import { createLogEntry, writeLogEntry } from "./logging";
+import {
+  createLogEntry,
+  writeLogEntry,
+  queryLogs,
+  setLogMeta,
+  closeLogSinks,
+  createInvocationLog,
+} from "./logging";
 import { emitProgress } from "./output";
 import { maskSecrets } from "./env";
 import { invoke as invokeSubagent } from "./invoke";
 import {
-  writeContext as writeContextImpl,
-  searchContext as searchContextImpl,
+  editContextEntry,
+  appendContextContent,
+  updateContextDocument,
+  replaceContextTags,
+  relatedContext,
+  listSessions,
+  watchContext,
+  findDuplicateContext,
+  summarizeSession,
+  validateContextEntry,
+  validateContextUpdate,
+  validateContextTags,
+  type ContextStore,
 } from "./context";
-import { startServices, stopServices } from "./services";
+import {
+  startServices,
+  stopServices,
+  endSessionServices,
+  createServiceMonitor,
+  resolveServiceProfiles,
+  isServiceExternallyConfigured,
+} from "./services";
 
 // -------------------------------------------------------------------
 // JSON-RPC 2.0 types
@@ -188,7 +216,7 @@ interface McpServerOptions {
   def: AgentDefinition;
   safety: SafetyState;
   loggingConfig: LoggingConfig;
-  contextStorePath: string;
+  contextStore: ContextStore;
   resolvedEnv: ResolvedEnv;
   mergedConfig: Record<string, unknown>;
   quiet: boolean;
@@ -213,11 +241,17 @@ interface McpServerOptions {
  * - 10.12: ping handler
  */
 export async function serveMcp(opts: McpServerOptions): Promise<never> {
-  const { def, safety, loggingConfig, contextStorePath, resolvedEnv, mergedConfig, quiet, timeoutSeconds } = opts;
+  const { def, safety, loggingConfig, contextStore, resolvedEnv, mergedConfig, quiet, timeoutSeconds } = opts;
 
   // 10.9: Start services on MCP server init
+  const profiles = def.services ? await resolveServiceProfiles(def) : [];
+  const services = createServiceMonitor(
+    def,
+    Object.keys(def.services ?? {}).every((name) => isServiceExternallyConfigured(name)),
+    profiles,
+  );
   if (def.services && Object.keys(def.services).length > 0) {
-    await startServices(def, process.env as Record<string, string | undefined>);
+    await startServices(def, process.env as Record<string, string | undefined>, profiles);
   }
 
   if (!quiet) {
@@ -255,11 +289,19 @@ export async function serveMcp(opts: McpServerOptions): Promise<never> {
       await new Promise((resolve) => setTimeout(resolve, 100));
     }
 
-    // Tear down services if ephemeral
-    if (def.services && Object.keys(def.services).length > 0) {
+    // Tear down services if ephemeral, or with the session if this is its root
+    services.stop();
+    const hasServices = !!def.services && Object.keys(def.services).length > 0;
+    if (hasServices) {
       await stopServices(def.name, def.serviceLifecycle, def.services);
     }
+    await endSessionServices(
+      safety.sessionId,
+      hasServices && def.serviceLifecycle === "session" ? def.name : null,
+      safety.depth === 0,
+    );
 
+    await closeLogSinks(loggingConfig);
     serverAc.abort();
     process.exit(ExitCode.SUCCESS);
   };
@@ -333,9 +375,12 @@ export async function serveMcp(opts: McpServerOptions): Promise<never> {
 
         inFlightCount++;
         const callStart = Date.now();
+        const logMeta: Record<string, unknown> = {};
+        const invocations = createInvocationLog(callStart);
 
         // 10.8: Per-tool-call safety guardrails (timeout)
         const callAc = new AbortController();
+        const unregister: Array<() => void> = [];
         const callTimeout = setTimeout(() => {
           callAc.abort();
         }, timeoutSeconds * 1000);
@@ -361,15 +406,57 @@ export async function serveMcp(opts: McpServerOptions): Promise<never> {
           invoke: async (targetAgent: string, invokeOpts?: InvokeOptions) => {
             const elapsed = Date.now() - callStart;
             const remainingMs = timeoutSeconds * 1000 - elapsed;
-            return invokeSubagent(targetAgent, safety, remainingMs > 0 ? remainingMs : undefined, callAc.signal, invokeOpts);
+            return invokeSubagent(
+              targetAgent,
+              safety,
+              remainingMs > 0 ? remainingMs : undefined,
+              callAc.signal,
+              invokeOpts,
+              invocations,
+            );
           },
           writeContext: async (entry: WriteContextInput): Promise<string> => {
-            const filePath = writeContextImpl(entry, def.name, safety.sessionId, contextStorePath);
+            validateContextEntry(entry, def.contextSchema);
+            if (!entry.allowDuplicate) {
+              const existing = await findDuplicateContext(contextStore, entry, def.name, safety.sessionId);
+              if (existing) return existing;
+            }
+            const filePath = await contextStore.write(entry, def.name, safety.sessionId);
             contextFilesWritten.push(filePath);
             return filePath;
           },
+          appendContext: (path: string, content: string) =>
+            editContextEntry(contextStore, path, def.name, (doc) => appendContextContent(doc, content)),
+          updateContext: async (path: string, entry: UpdateContextInput) => {
+            validateContextUpdate(entry);
+            await editContextEntry(contextStore, path, def.name, (doc) => updateContextDocument(doc, entry));
+          },
+          replaceTags: async (path: string, tags: string[]) => {
+            validateContextTags(tags);
+            await editContextEntry(contextStore, path, def.name, (doc) => replaceContextTags(doc, tags));
+          },
+          readContext: (pathOrId: string) => contextStore.read(pathOrId),
+          readAttachment: (path: string, name: string) => contextStore.readAttachment(path, name),
           searchContext: async (query: SearchContextInput) => {
-            return searchContextImpl(query, contextStorePath);
+            return contextStore.search(query);
+          },
+          relatedContext: (path: string, depth: number) => relatedContext(contextStore, path, depth),
+          listSessions: () => listSessions(contextStore),
+          watchContext: (query: SearchContextInput, signal?: AbortSignal) =>
+            watchContext(contextStore, query, signal ? AbortSignal.any([signal, callAc.signal]) : callAc.signal),
+          exportContext: async (options?: ContextExportOptions) => contextStore.exportBundle(options),
+          importContext: async (data: Buffer | Uint8Array) => {
+            const filePaths = await contextStore.importBundle(data);
+            contextFilesWritten.push(...filePaths);
+            return filePaths;
+          },
+          summarizeSession: (options?: SummarizeSessionOptions) => summarizeSession(ctx, contextStore, options),
+          queryLogs: async (query?: LogQuery) => queryLogs(loggingConfig, query),
+          setMeta: (key: string, value: unknown) => setLogMeta(logMeta, key, value),
+          serviceLogs: (name: string, tail?: number) => services.logs(name, tail),
+          // Callbacks last only as long as the tool call
+          onServiceUnhealthy: (fn) => {
+            unregister.push(services.onUnhealthy(fn));
           },
         };
 
@@ -394,14 +481,17 @@ export async function serveMcp(opts: McpServerOptions): Promise<never> {
             depth: safety.depth,
             callChain: safety.callChain,
             sessionId: safety.sessionId,
+            resolvedEnv,
             input: (toolArgs.context as string) ?? "",
             output: outputStr,
             meta: {
+              ...logMeta,
               mcpTool: toolName,
               ...(contextFilesWritten.length > 0 ? { contextFiles: [...contextFilesWritten] } : {}),
+              ...(invocations.list().length > 0 ? { invocations: invocations.list() } : {}),
             },
           });
-          writeLogEntry(logEntry, loggingConfig);
+          await writeLogEntry(logEntry, loggingConfig);
 
           // Send MCP result
           const content = typeof result.result === "string" ? result.result : JSON.stringify(result.result);
@@ -428,11 +518,16 @@ export async function serveMcp(opts: McpServerOptions): Promise<never> {
             depth: safety.depth,
             callChain: safety.callChain,
             sessionId: safety.sessionId,
+            resolvedEnv,
             input: (toolArgs.context as string) ?? "",
             output: errorMsg,
-            meta: { mcpTool: toolName },
+            meta: {
+              ...logMeta,
+              mcpTool: toolName,
+              ...(invocations.list().length > 0 ? { invocations: invocations.list() } : {}),
+            },
           });
-          writeLogEntry(logEntry, loggingConfig);
+          await writeLogEntry(logEntry, loggingConfig);
 
           sendResponse({
             jsonrpc: "2.0",
@@ -445,6 +540,7 @@ export async function serveMcp(opts: McpServerOptions): Promise<never> {
         } finally {
           inFlightCount--;
           contextFilesWritten.length = 0;
+          for (const fn of unregister) fn();
         }
 
         break;
@@ -1,16 +1,17 @@
-import type { AgentResult, OutputFormat } from "./types";
+import type { AgentResult, OutputFormat, StructuredError } from "./types";
 import { ExitCode } from "./types";
 
 /**
  * Write the agent result to stdout in the specified format.
- * Diagnostics always go to stderr.
+ * Diagnostics always go to stderr. In JSON mode the result's error string
+ * is reported as a structured error with code execution_failed.
  */
 export function writeResult(result: AgentResult, format: OutputFormat): void {
   if (format === "json") {
     const output: Record<string, unknown> = { result: result.result };
     if (result.metadata) output.metadata = result.metadata;
     if (result.warnings && result.warnings.length > 0) output.warnings = result.warnings;
-    if (result.error) output.error = result.error;
+    if (result.error) output.error = { code: "execution_failed", message: result.error, retryable: false };
     process.stdout.write(JSON.stringify(output) + "\n");
   } else {
     // Text mode: write result as string
@@ -20,23 +21,99 @@ export function writeResult(result: AgentResult, format: OutputFormat): void {
 }
 
 /**
- * Write a diagnostic/error message to stderr.
+ * Write a failed execution's result to stdout: in JSON mode, a null result
+ * with a structured error; in text mode nothing, as the error goes to stderr.
  */
-export function writeDiagnostic(message: string): void {
-  process.stderr.write(message + "\n");
+export function writeFailure(error: StructuredError, format: OutputFormat): void {
+  if (format !== "json") return;
+  process.stdout.write(JSON.stringify({ result: null, error }) + "\n");
 }
 
+export type LogLevel = "debug" | "info" | "warn" | "error";
+
+// Levels are numbered as slog's, so that the two SDKs filter alike
+const LOG_LEVELS: Record<LogLevel, number> = { debug: -4, info: 0, warn: 4, error: 8 };
+
+let stderrLevel = LOG_LEVELS.info;
+let stderrFormat: "text" | "json" = "text";
+
+/**
+ * Set the level of stderrLog from the flags and SFA_LOG_LEVEL (debug, info,
+ * warn, or error), and its format from SFA_LOG_FORMAT: text, the default,
+ * or json for one JSON object a line. --verbose gives debug and --quiet
+ * warn, whatever SFA_LOG_LEVEL says.
+ */
+export function configureStderrLog(flags: { verbose?: boolean; quiet?: boolean }): void {
+  const warnings: string[] = [];
+  let level = LOG_LEVELS.info;
+  const envLevel = process.env.SFA_LOG_LEVEL;
+  if (envLevel) {
+    const name = envLevel.toLowerCase();
+    if (Object.hasOwn(LOG_LEVELS, name)) {
+      level = LOG_LEVELS[name as LogLevel];
+    } else {
+      warnings.push(`ignoring invalid SFA_LOG_LEVEL "${envLevel}" (use debug, info, warn, or error)`);
+    }
+  }
+  if (flags.verbose) level = LOG_LEVELS.debug;
+  else if (flags.quiet) level = LOG_LEVELS.warn;
+  stderrLevel = level;
+
+  const format = process.env.SFA_LOG_FORMAT ?? "";
+  if (format === "" || format === "text") stderrFormat = "text";
+  else if (format === "json") stderrFormat = "json";
+  else warnings.push(`ignoring invalid SFA_LOG_FORMAT "${format}" (use text or json)`);
+  for (const w of warnings) stderrLog.warn(w);
+}
+
+/**
+ * Write a record to stderr if its level is enabled. Text records are
+ * written as "error: " or "warning: " and the message for errors and
+ * warnings, "[agent:<name>] " and the message for other records of an
+ * agent, and any further attributes as key=value after the message.
+ */
+function writeStderrRecord(level: LogLevel, message: string, attrs: Record<string, unknown> = {}): void {
+  if (LOG_LEVELS[level] < stderrLevel) return;
+  if (stderrFormat === "json") {
+    process.stderr.write(JSON.stringify({ time: new Date().toISOString(), level: level.toUpperCase(), msg: message, ...attrs }) + "\n");
+    return;
+  }
+  let prefix = "";
+  if (level === "error") prefix = "error: ";
+  else if (level === "warn") prefix = "warning: ";
+  else if (attrs.agent !== undefined) prefix = `[agent:${attrs.agent}] `;
+  let extra = "";
+  for (const [key, value] of Object.entries(attrs)) {
+    if (key === "agent" || value === undefined) continue;
+    let v = String(value);
+    if (v === "" || /[\s"=]/.test(v)) v = JSON.stringify(v);
+    extra += ` ${key}=${v}`;
+  }
+  process.stderr.write(prefix + message + extra + "\n");
+}
+
+/**
+ * The SDK's logger on stderr: progress, notes, warnings, and errors.
+ * Records about an agent carry its name as the agent attribute.
+ */
+export const stderrLog = {
+  debug: (message: string, attrs?: Record<string, unknown>) => writeStderrRecord("debug", message, attrs),
+  info: (message: string, attrs?: Record<string, unknown>) => writeStderrRecord("info", message, attrs),
+  warn: (message: string, attrs?: Record<string, unknown>) => writeStderrRecord("warn", message, attrs),
+  error: (message: string, attrs?: Record<string, unknown>) => writeStderrRecord("error", message, attrs),
+};
+
 /**
- * Write an error and exit with the appropriate code.
+ * Log an error and exit with the appropriate code.
  */
 export function exitWithError(message: string, code: number = ExitCode.FAILURE): never {
-  writeDiagnostic(`error: ${message}`);
+  stderrLog.error(message);
   process.exit(code);
 }
 
 /**
- * Emit a progress message to stderr in the standard format.
+ * Log a progress message of the agent, at info level.
  */
 export function emitProgress(agentName: string, message: string): void {
-  process.stderr.write(`[agent:${agentName}] ${message}\n`);
+  stderrLog.info(message, { agent: agentName });
 }
@@ -0,0 +1,31 @@
+import { isAbsolute, join } from "node:path";
+import { homedir } from "node:os";
+
+const APP_DIR = "single-file-agents";
+
+/**
+ * Resolve a path under the SFA data directory.
+ * Priority: SFA_DATA_HOME env → $XDG_DATA_HOME/single-file-agents → ~/.local/share/single-file-agents
+ */
+export function dataDir(...parts: string[]): string {
+  const base = process.env.SFA_DATA_HOME || xdgDir("XDG_DATA_HOME", ".local", "share");
+  return join(base, ...parts);
+}
+
+/**
+ * Resolve the directory holding the shared config file.
+ * Priority: $XDG_CONFIG_HOME/single-file-agents → ~/.config/single-file-agents
+ */
+export function configDir(): string {
+  return xdgDir("XDG_CONFIG_HOME", ".config");
+}
+
+/**
+ * SFA's directory under an XDG base directory. A relative value is ignored,
+ * as the XDG spec requires.
+ */
+function xdgDir(env: string, ...fallback: string[]): string {
+  const base = process.env[env];
+  if (base && isAbsolute(base)) return join(base, APP_DIR);
+  return join(homedir(), ...fallback, APP_DIR);
+}
@@ -1,16 +1,19 @@
 import type { AgentDefinition, ServiceDefinition, ServiceLifecycle } from "./types";
 import { ExitCode } from "./types";
-import { emitProgress, exitWithError } from "./output";
+import { emitProgress, exitWithError, stderrLog } from "./output";
+import { dataDir } from "./paths";
+import { loadConfig, type RemoteEngineConfig, type SfaConfig } from "./config";
 
-import { connect, type Socket } from "node:net";
-
-const DATA_DIR = `${process.env.HOME}/.local/share/single-file-agents/services`;
+import { createHash } from "node:crypto";
+import { existsSync, readdirSync, readFileSync, renameSync, statSync, unlinkSync, writeFileSync } from "node:fs";
+import { connect, isIP, type Socket } from "node:net";
+import { resolve as resolvePath } from "node:path";
 
 /**
  * Get the compose file directory for an agent.
  */
 function composeDir(agentName: string): string {
-  return `${DATA_DIR}/${agentName}`;
+  return dataDir("services", agentName);
 }
 
 /** Supported compose filenames in priority order (modern first). */
@@ -54,7 +57,7 @@ function serviceEnvName(serviceName: string): string {
  * Check if a service has pre-configured connection vars in the environment.
  * Returns true if SFA_SVC_<NAME>_URL or (SFA_SVC_<NAME>_HOST and SFA_SVC_<NAME>_PORT) are set.
  */
-function isServiceExternallyConfigured(serviceName: string): boolean {
+export function isServiceExternallyConfigured(serviceName: string): boolean {
   const prefix = `SFA_SVC_${serviceEnvName(serviceName)}`;
   if (process.env[`${prefix}_URL`]) return true;
   if (process.env[`${prefix}_HOST`] && process.env[`${prefix}_PORT`]) return true;
@@ -113,43 +116,191 @@ async function verifyServiceReachable(host: string, port: number, timeoutMs: num
 }
 
 // -------------------------------------------------------------------
-// 9.1: Docker/docker-compose availability check
+// 9.1: Container engine (Docker or Podman) availability check
 // -------------------------------------------------------------------
 
 /**
- * Check that docker and docker compose are available.
- * Exits with code 1 if not found.
+ * A container engine: its CLI, also its name, its compose command, and the
+ * host services' published ports are reached on.
  */
-export async function checkDockerAvailability(): Promise<void> {
+export interface ContainerEngine {
+  name: "docker" | "podman";
+  compose: string[];
+  host: string;
+}
+
+/** The variables pointing each engine at a daemon address and a named context. */
+const ENGINE_REMOTE_VARS: Record<ContainerEngine["name"], { host: string; context: string }> = {
+  docker: { host: "DOCKER_HOST", context: "DOCKER_CONTEXT" },
+  podman: { host: "CONTAINER_HOST", context: "CONTAINER_CONNECTION" },
+};
+
+/** Each engine's compose commands in preference order. */
+const ENGINE_COMPOSE: Record<ContainerEngine["name"], string[][]> = {
+  docker: [["docker", "compose"]],
+  podman: [["podman", "compose"], ["podman-compose"]],
+};
+
+const ENGINE_TITLES: Record<ContainerEngine["name"], string> = { docker: "Docker", podman: "Podman" };
+
+let resolvedEngine: ContainerEngine | null = null;
+
+/** Whether argv runs successfully. */
+async function commandWorks(argv: string[]): Promise<boolean> {
   try {
-    const docker = Bun.spawn(["docker", "--version"], {
-      stdout: "pipe",
-      stderr: "pipe",
-    });
-    const dockerExit = await docker.exited;
-    if (dockerExit !== 0) throw new Error("docker not available");
+    const proc = Bun.spawn(argv, { stdout: "pipe", stderr: "pipe" });
+    return (await proc.exited) === 0;
+  } catch {
+    return false;
+  }
+}
+
+/**
+ * The daemon address or context an agent's services run on: services.host or
+ * services.context from its namespace, then defaults, then the top-level
+ * services section.
+ */
+function remoteEngineSetting(config: SfaConfig, agentName?: string): RemoteEngineConfig {
+  const sections = [
+    agentName ? config.agents?.[agentName]?.services : undefined,
+    config.defaults?.services,
+    config.services,
+  ] as (RemoteEngineConfig | undefined)[];
+  for (const section of sections) {
+    const { host, context } = section ?? {};
+    if (host && context) throw new Error("services.host and services.context are both set; use one");
+    if (host || context) return { host, context };
+  }
+  return {};
+}
+
+/** The daemon address of an engine context, or of the current one if context is "". */
+async function contextEndpoint(engine: ContainerEngine["name"], context: string): Promise<string | null> {
+  const argv =
+    engine === "podman"
+      ? ["podman", "system", "connection", "list", "--format", "{{.Name}}\t{{.URI}}\t{{.Default}}"]
+      : ["docker", "context", "inspect", "--format", "{{.Endpoints.docker.Host}}", ...(context ? [context] : [])];
+  try {
+    const proc = Bun.spawn(argv, { stdout: "pipe", stderr: "pipe" });
+    const out = (await new Response(proc.stdout).text()).trim();
+    if ((await proc.exited) !== 0) return null;
+    if (engine === "docker") return out;
+    for (const line of out.split("\n")) {
+      const [name, uri, isDefault] = line.split("\t");
+      if (name === context || (!context && isDefault === "true")) return uri;
+    }
+    return null;
   } catch {
+    return null;
+  }
+}
+
+/** The machine a daemon address such as tcp://10.0.0.5:2376 names, or localhost for a socket. */
+function endpointHost(endpoint: string): string {
+  try {
+    const url = new URL(endpoint);
+    if (url.protocol === "unix:" || url.protocol === "npipe:" || !url.hostname) return "localhost";
+    return url.hostname.replace(/^\[(.*)\]$/, "$1");
+  } catch {
+    return "localhost";
+  }
+}
+
+/** Whether host is this machine. */
+function isLocalHost(host: string): boolean {
+  return host === "localhost" || host === "::1" || host.startsWith("127.");
+}
+
+/**
+ * Find the container engine to run services with: SFA_CONTAINER_ENGINE, then
+ * config services.engine, otherwise Docker if available and Podman if not.
+ * A configured services.host or services.context for agentName is set in the
+ * process environment, so every engine command reaches that daemon.
+ * Exits with code 1 if none is available.
+ */
+export async function checkContainerEngine(agentName?: string): Promise<ContainerEngine> {
+  if (resolvedEngine) return resolvedEngine;
+
+  const config = await loadConfig();
+  let name = process.env.SFA_CONTAINER_ENGINE || "";
+  let source = "SFA_CONTAINER_ENGINE";
+  if (!name) {
+    name = config.services?.engine ?? "";
+    source = "services.engine";
+  }
+  if (name && !(name in ENGINE_COMPOSE)) {
+    exitWithError(`${source}: unknown container engine "${name}" (use docker or podman)`, ExitCode.FAILURE);
+  }
+  let remote: RemoteEngineConfig = {};
+  try {
+    remote = remoteEngineSetting(config, agentName);
+  } catch (err) {
+    exitWithError((err as Error).message, ExitCode.FAILURE);
+  }
+
+  const candidates = (name ? [name] : ["docker", "podman"]) as ContainerEngine["name"][];
+  let withoutCompose: ContainerEngine["name"] | null = null;
+  for (const engine of candidates) {
+    if (remote.host || remote.context) {
+      // Clear the other variable so an inherited one cannot override it
+      process.env[ENGINE_REMOTE_VARS[engine].host] = remote.host ?? "";
+      process.env[ENGINE_REMOTE_VARS[engine].context] = remote.context ?? "";
+    }
+    if (!(await commandWorks([engine, "version"]))) continue;
+    for (const compose of ENGINE_COMPOSE[engine]) {
+      if (await commandWorks([...compose, "version"])) {
+        const vars = ENGINE_REMOTE_VARS[engine];
+        const context = process.env[vars.context] ?? "";
+        let host = process.env[vars.host] ? endpointHost(process.env[vars.host]!) : null;
+        if (host === null) {
+          const endpoint = await contextEndpoint(engine, context);
+          if (endpoint === null && context) {
+            exitWithError(`Failed to inspect ${ENGINE_TITLES[engine]} context ${context}.`, ExitCode.FAILURE);
+          }
+          host = endpoint ? endpointHost(endpoint) : "localhost";
+        }
+        resolvedEngine = { name: engine, compose, host };
+        return resolvedEngine;
+      }
+    }
+    withoutCompose ??= engine;
+  }
+
+  if (remote.host || remote.context) {
     exitWithError(
-      "This agent requires Docker for its service dependencies.\n" +
-        "Install Docker: https://docs.docker.com/get-docker/",
+      remote.host
+        ? `Cannot reach the container engine at ${remote.host}. Check that it is running and reachable from here.`
+        : `Cannot reach the container engine for context ${remote.context}. Check that the context exists and its daemon is reachable.`,
       ExitCode.FAILURE,
     );
   }
 
-  try {
-    const compose = Bun.spawn(["docker", "compose", "version"], {
-      stdout: "pipe",
-      stderr: "pipe",
-    });
-    const composeExit = await compose.exited;
-    if (composeExit !== 0) throw new Error("docker compose not available");
-  } catch {
+  if (withoutCompose) {
     exitWithError(
-      "This agent requires Docker Compose for its service dependencies.\n" +
-        "Install Docker Compose: https://docs.docker.com/compose/install/",
+      `This agent requires ${ENGINE_TITLES[withoutCompose]} Compose for its service dependencies.`,
       ExitCode.FAILURE,
     );
   }
+  if (name) {
+    exitWithError(
+      `${ENGINE_TITLES[name as ContainerEngine["name"]]} is not installed or not running. Install it, or unset ${source}, to use service dependencies.`,
+      ExitCode.FAILURE,
+    );
+  }
+  exitWithError(
+    "This agent requires Docker or Podman for its service dependencies.\n" +
+      "Install Docker: https://docs.docker.com/get-docker/",
+    ExitCode.FAILURE,
+  );
+}
+
+/**
+ * Check that a container engine and its compose command are available.
+ * Exits with code 1 if not found.
+ * @deprecated Use checkContainerEngine, which also accepts Podman.
+ */
+export async function checkDockerAvailability(): Promise<void> {
+  await checkContainerEngine();
 }
 
 // -------------------------------------------------------------------
@@ -157,19 +308,22 @@ export async function checkDockerAvailability(): Promise<void> {
 // -------------------------------------------------------------------
 
 /**
- * Interpolate ${VAR} references in a string from the environment.
- * Throws on unresolved variables.
+ * Collect the values of ${VAR} references in a compose template from the
+ * environment. Compose fills them in from the .env file beside the template,
+ * so values (secrets included) never appear in the template itself.
+ * Exits on unresolved variables.
  */
-function interpolateVars(template: string, env: Record<string, string | undefined>): string {
+function referencedVars(template: string, env: Record<string, string | undefined>): Record<string, string> {
+  const vars: Record<string, string> = {};
   const unresolved: string[] = [];
-  const result = template.replace(/\$\{([^}]+)\}/g, (match, varName) => {
+  for (const [, varName] of template.matchAll(/\$\{([^}]+)\}/g)) {
     const value = env[varName];
     if (value === undefined) {
-      unresolved.push(varName);
-      return match;
+      if (!unresolved.includes(varName)) unresolved.push(varName);
+    } else {
+      vars[varName] = value;
     }
-    return value;
-  });
+  }
 
   if (unresolved.length > 0) {
     exitWithError(
@@ -179,13 +333,749 @@ function interpolateVars(template: string, env: Record<string, string | undefine
     );
   }
 
-  return result;
+  return vars;
+}
+
+/**
+ * Format variables as a compose .env file. Single quotes keep a value
+ * literal; values that contain one are double-quoted with escapes.
+ */
+function formatDotenv(vars: Record<string, string>): string {
+  return Object.keys(vars)
+    .sort()
+    .map((name) => {
+      const v = vars[name];
+      if (!/['\n]/.test(v)) return `${name}='${v}'\n`;
+      const escaped = v.replace(/\\/g, "\\\\").replace(/"/g, '\\"').replace(/\n/g, "\\n").replace(/\$/g, "\\$");
+      return `${name}="${escaped}"\n`;
+    })
+    .join("");
+}
+
+// -------------------------------------------------------------------
+// Service dependencies (depends_on)
+// -------------------------------------------------------------------
+
+/**
+ * Order service names so each comes after the services it depends on,
+ * otherwise by name. Throws on an undeclared dependency, an unknown
+ * condition, a service_healthy dependency without a healthcheck, or a cycle.
+ */
+export function serviceStartOrder(services: Record<string, ServiceDefinition>): string[] {
+  const names = Object.keys(services).sort();
+  for (const name of names) {
+    for (const [dep, cond] of Object.entries(services[name].dependsOn ?? {})) {
+      const depSvc = services[dep];
+      if (!depSvc) throw new Error(`service ${name} depends on undeclared service ${dep}`);
+      const condition = cond || "service_started";
+      if (condition === "service_healthy" && !depSvc.healthcheck) {
+        throw new Error(`service ${name} waits for ${dep} to be healthy, but ${dep} has no healthcheck`);
+      }
+      if (!["service_started", "service_healthy", "service_completed_successfully"].includes(condition)) {
+        throw new Error(`service ${name}: unknown depends-on condition "${condition}" for ${dep}`);
+      }
+    }
+  }
+
+  const order: string[] = [];
+  const state = new Map<string, "visiting" | "done">();
+  const visit = (name: string, path: string[]): void => {
+    if (state.get(name) === "done") return;
+    if (state.get(name) === "visiting") {
+      throw new Error(`services have a dependency cycle: ${[...path, name].join(" -> ")}`);
+    }
+    state.set(name, "visiting");
+    for (const dep of Object.keys(services[name].dependsOn ?? {}).sort()) {
+      visit(dep, [...path, name]);
+    }
+    state.set(name, "done");
+    order.push(name);
+  };
+  for (const name of names) visit(name, []);
+  return order;
+}
+
+// -------------------------------------------------------------------
+// Automatic host ports
+// -------------------------------------------------------------------
+
+/** Marks a port mapping whose host port the SDK picks, e.g. "auto:5432". */
+const AUTO_PORT_PREFIX = "auto:";
+
+/** A TCP port nothing is listening on now; compose binds it moments later. */
+async function freeHostPort(): Promise<number> {
+  const { createServer } = await import("node:net");
+  return new Promise((resolve, reject) => {
+    const server = createServer();
+    server.once("error", reject);
+    server.listen(0, () => {
+      const { port } = server.address() as { port: number };
+      server.close(() => resolve(port));
+    });
+  });
+}
+
+/**
+ * Replace each "auto:<container port>" mapping with "<host port>:<container port>".
+ * Host ports are saved in the agent's ports.json and reused on later runs, so
+ * persistent services keep their port; new ones are picked from ports free now.
+ */
+export async function allocateAutoPorts(
+  agentName: string,
+  services: Record<string, ServiceDefinition>,
+): Promise<Record<string, ServiceDefinition>> {
+  const path = `${composeDir(agentName)}/ports.json`;
+  let saved: Record<string, number> = {};
+  const file = Bun.file(path);
+  if (await file.exists()) {
+    try {
+      saved = JSON.parse(await file.text());
+    } catch (err) {
+      stderrLog.warn(`ignoring unreadable ${path}: ${(err as Error).message}`);
+    }
+  }
+
+  const allocated: Record<string, number> = {};
+  const resolved: Record<string, ServiceDefinition> = {};
+  for (const name of Object.keys(services).sort()) {
+    const svc = services[name];
+    if (!svc.ports?.some((p) => p.startsWith(AUTO_PORT_PREFIX))) {
+      resolved[name] = svc;
+      continue;
+    }
+    const ports: string[] = [];
+    for (const p of svc.ports) {
+      if (!p.startsWith(AUTO_PORT_PREFIX)) {
+        ports.push(p);
+        continue;
+      }
+      const target = p.slice(AUTO_PORT_PREFIX.length);
+      const n = Number(target.replace(/\/tcp$/, ""));
+      if (!Number.isInteger(n) || n < 1 || n > 65535) {
+        throw new Error(`service ${name}: invalid port "${p}" (use auto:<container port>, e.g. auto:5432)`);
+      }
+      const key = `${name}/${target}`;
+      const host = saved[key] ?? (await freeHostPort());
+      allocated[key] = host;
+      ports.push(`${host}:${target}`);
+    }
+    resolved[name] = { ...svc, ports };
+  }
+
+  if (Object.keys(allocated).length > 0) {
+    const { mkdirSync, chmodSync } = await import("node:fs");
+    mkdirSync(composeDir(agentName), { recursive: true });
+    chmodSync(composeDir(agentName), 0o700);
+    await Bun.write(path, JSON.stringify(allocated, null, 2) + "\n");
+  }
+  return resolved;
+}
+
+// -------------------------------------------------------------------
+// Host port conflicts
+// -------------------------------------------------------------------
+
+/**
+ * The TCP host ports in mappings such as "5432:5432" and "127.0.0.1:8080:80".
+ * A bare container port gets an ephemeral host port and UDP mappings are not
+ * probed, so both are skipped.
+ */
+function publishedPorts(ports: string[] = []): { ip?: string; port: number; target: string }[] {
+  const published: { ip?: string; port: number; target: string }[] = [];
+  for (const p of ports) {
+    if (p.endsWith("/udp")) continue;
+    const parts = p.replace(/\/tcp$/, "").split(":");
+    const mapping =
+      parts.length === 2 ? { port: Number(parts[0]), target: parts[1] }
+      : parts.length === 3 ? { ip: parts[0], port: Number(parts[1]), target: parts[2] }
+      : null;
+    if (mapping && Number.isInteger(mapping.port) && mapping.port > 0) published.push(mapping);
+  }
+  return published;
+}
+
+/**
+ * Ask compose which host port each service was actually published on, and
+ * return the services with their first TCP mapping rewritten to it. This
+ * covers a bare container port, which gets an ephemeral host port, and
+ * anything remapped since the compose file was written. A service compose
+ * reports nothing for keeps its declared ports.
+ */
+async function discoverPublishedPorts(
+  engine: ContainerEngine,
+  agentName: string,
+  services: Record<string, ServiceDefinition>,
+): Promise<Record<string, ServiceDefinition>> {
+  const discovered: Record<string, ServiceDefinition> = {};
+  for (const name of Object.keys(services).sort()) {
+    const svc = services[name];
+    discovered[name] = svc;
+    const ports = svc.ports ?? [];
+    const i = ports.findIndex((p) => !p.endsWith("/udp") && Number(p.replace(/\/tcp$/, "").split(":").pop()) > 0);
+    if (i < 0) continue;
+    const target = ports[i].replace(/\/tcp$/, "").split(":").pop()!;
+
+    const proc = Bun.spawn([...engine.compose, "port", name, target], {
+      cwd: composeDir(agentName),
+      stdout: "pipe",
+      stderr: "pipe",
+    });
+    // One line per address family, e.g. 0.0.0.0:54321 and [::]:54321
+    const addr = (await new Response(proc.stdout).text()).trim().split("\n")[0].trim();
+    const hostPort = addr.slice(addr.lastIndexOf(":") + 1);
+    if ((await proc.exited) !== 0 || !(Number(hostPort) > 0)) {
+      if (publishedPorts(ports).length === 0) stderrLog.warn(`could not find the host port of service ${name}`);
+      continue;
+    }
+
+    const ip = publishedPorts([ports[i]])[0]?.ip;
+    const rewritten = [...ports];
+    rewritten[i] = `${ip ? `${ip}:` : ""}${hostPort}:${target}`;
+    discovered[name] = { ...svc, ports: rewritten };
+  }
+  return discovered;
+}
+
+/** Whether something already listens on the host port. */
+async function portInUse(port: number, ip?: string): Promise<boolean> {
+  const { createServer } = await import("node:net");
+  return new Promise((resolve) => {
+    const server = createServer();
+    server.once("error", () => resolve(true));
+    server.listen(port, ip, () => server.close(() => resolve(false)));
+  });
+}
+
+/**
+ * What holds a host port: a container, a process, or null if that cannot be
+ * told. "ours" is the agent's own container.
+ */
+async function portOwner(engine: ContainerEngine, agentName: string, port: number): Promise<string | null> {
+  const run = async (argv: string[]): Promise<string> => {
+    try {
+      const proc = Bun.spawn(argv, { stdout: "pipe", stderr: "pipe" });
+      const out = await new Response(proc.stdout).text();
+      return (await proc.exited) === 0 ? out.trim() : "";
+    } catch {
+      return "";
+    }
+  };
+  const publish = `publish=${port}`;
+  if (await run([engine.name, "ps", "-q", "--filter", `label=sfa.agent=${agentName}`, "--filter", publish])) {
+    return "ours";
+  }
+  const container = await run([engine.name, "ps", "--filter", publish, "--format", "{{.Names}}"]);
+  if (container) return `container ${container.split(/\s+/)[0]}`;
+  const lsof = await run(["lsof", "-nP", `-iTCP:${port}`, "-sTCP:LISTEN", "-Fpc"]);
+  const pid = lsof.match(/^p(.+)$/m)?.[1];
+  const command = lsof.match(/^c(.+)$/m)?.[1];
+  if (pid) return command ? `process ${command} (pid ${pid})` : `process ${pid}`;
+  return null;
+}
+
+/**
+ * Exit with a message naming each taken host port, what holds it, and the
+ * ways around it, instead of leaving compose up to fail on the bind.
+ */
+async function checkPortConflicts(
+  engine: ContainerEngine,
+  agentName: string,
+  services: Record<string, ServiceDefinition>,
+): Promise<void> {
+  // A remote engine's ports cannot be checked from here
+  if (!isLocalHost(engine.host)) return;
+  const conflicts: string[] = [];
+  for (const name of Object.keys(services).sort()) {
+    for (const { ip, port, target } of publishedPorts(services[name].ports)) {
+      if (!(await portInUse(port, ip))) continue;
+      const owner = await portOwner(engine, agentName, port);
+      if (owner === "ours") continue;
+      conflicts.push(
+        `port ${port} for service ${name} is in use by ${owner ?? "another process"}; stop it, ` +
+          `set SFA_SVC_${serviceEnvName(name)}_URL to use an existing ${name} instead, ` +
+          `or map the port as ${AUTO_PORT_PREFIX}${target} to pick a free one`,
+      );
+    }
+  }
+  if (conflicts.length > 0) {
+    exitWithError(`Cannot start services, host ports are taken:\n  • ${conflicts.join("\n  • ")}`, ExitCode.FAILURE);
+  }
+}
+
+// -------------------------------------------------------------------
+// Readiness probes
+// -------------------------------------------------------------------
+
+/** The probe URL with ${host} and ${port} filled in from the first published port. */
+function readyProbeUrl(svc: ServiceDefinition, host = "localhost"): string {
+  const port = publishedPorts(svc.ports)[0]?.port;
+  return svc.ready!.url.replace(/\$\{host\}/g, host).replace(/\$\{port\}/g, port ? String(port) : "");
+}
+
+/** Reject a probe the SDK cannot run, before Docker is invoked. */
+function checkReadyProbe(name: string, svc: ServiceDefinition): void {
+  if (!svc.ready) return;
+  if (svc.ready.url.includes("${port}") && publishedPorts(svc.ports).length === 0) {
+    throw new Error(`service ${name}: ready probe uses \${port}, but the service publishes no host port`);
+  }
+  let url: URL;
+  try {
+    url = new URL(readyProbeUrl(svc));
+  } catch {
+    throw new Error(`service ${name}: invalid ready probe "${svc.ready.url}" (use tcp://host:port or http://host:port/path)`);
+  }
+  if (!["tcp:", "http:", "https:"].includes(url.protocol)) {
+    throw new Error(`service ${name}: unsupported ready probe scheme "${url.protocol.slice(0, -1)}" (use tcp, http, or https)`);
+  }
+  if (svc.ready.status !== undefined && url.protocol === "tcp:") {
+    throw new Error(`service ${name}: a tcp ready probe cannot expect an HTTP status`);
+  }
+}
+
+/** Run a service's probe once, returning why it is not ready, or null. */
+async function probeReady(svc: ServiceDefinition, host: string): Promise<string | null> {
+  const target = readyProbeUrl(svc, host);
+  const url = new URL(target);
+  if (url.protocol === "tcp:") {
+    const ok = await verifyServiceReachable(url.hostname, Number(url.port), 2000);
+    return ok ? null : `${url.host} refused the connection`;
+  }
+  try {
+    const resp = await fetch(target, { signal: AbortSignal.timeout(2000) });
+    const want = svc.ready!.status;
+    if (want !== undefined ? resp.status === want : resp.ok) return null;
+    return want !== undefined ? `${target} answered ${resp.status}, want ${want}` : `${target} answered ${resp.status}`;
+  } catch (err) {
+    return (err as Error).message;
+  }
+}
+
+/**
+ * Poll the services' ready probes until all pass. Exits with code 1 and the
+ * last reason for each service still not ready once the timeout passes.
+ */
+async function waitForReady(
+  agentName: string,
+  host: string,
+  services: Record<string, ServiceDefinition>,
+  timeoutSeconds: number,
+): Promise<void> {
+  const start = Date.now();
+  const deadline = start + timeoutSeconds * 1000;
+  const pending = new Map<string, string | null>(
+    Object.keys(services).filter((name) => services[name].ready).sort().map((name) => [name, null]),
+  );
+  while (pending.size > 0) {
+    for (const name of [...pending.keys()]) {
+      const reason = await probeReady(services[name], host);
+      if (reason === null) {
+        pending.delete(name);
+        emitProgress(agentName, `service ${name} ready after ${Math.round((Date.now() - start) / 1000)}s`);
+      } else pending.set(name, reason);
+    }
+    if (pending.size === 0) return;
+    if (Date.now() > deadline) {
+      const reasons = [...pending].map(([name, reason]) => `${name}: ${reason}`).join("\n  • ");
+      const bundle = await dumpLogsAndTearDown(agentName, composeDir(agentName), "Service ready probes failed.");
+      exitWithError(
+        `Services failed their ready probes within ${timeoutSeconds}s:\n  • ${reasons}` +
+          (bundle ? `\ndiagnostics saved to ${bundle}` : ""),
+        ExitCode.FAILURE,
+      );
+    }
+    await new Promise((resolve) => setTimeout(resolve, 500));
+  }
+}
+
+// -------------------------------------------------------------------
+// Resource limits
+// -------------------------------------------------------------------
+
+/**
+ * Reject limits Docker would refuse, so the error names the service instead
+ * of surfacing from compose up.
+ */
+function checkResourceLimits(service: string, limits: NonNullable<ServiceDefinition["resources"]>): void {
+  if (limits.cpus !== undefined) {
+    const cpus = Number(limits.cpus);
+    if (!(cpus > 0)) {
+      throw new Error(`service ${service}: invalid CPU limit "${limits.cpus}" (use a positive number of cores, e.g. 1.5)`);
+    }
+  }
+  if (limits.memory !== undefined && !/^[0-9]+(\.[0-9]+)?[bkmg]?$/.test(limits.memory.toLowerCase())) {
+    throw new Error(`service ${service}: invalid memory limit "${limits.memory}" (use a size such as 512m or 2g)`);
+  }
+  if (limits.pids !== undefined && (!Number.isInteger(limits.pids) || limits.pids < 0)) {
+    throw new Error(`service ${service}: invalid pids limit ${limits.pids}`);
+  }
+}
+
+/** The limits Docker accepts, as setrlimit names them. */
+const ULIMIT_NAMES = new Set([
+  "core", "cpu", "data", "fsize", "locks", "memlock", "msgqueue", "nice",
+  "nofile", "nproc", "rss", "rtprio", "rttime", "sigpending", "stack",
+]);
+
+/**
+ * Reject a restart policy, extra host, or ulimit compose would refuse, so
+ * the error names the service.
+ */
+function checkContainerOptions(service: string, svc: ServiceDefinition): void {
+  const restart = svc.restart;
+  if (
+    restart !== undefined &&
+    !["no", "always", "on-failure", "unless-stopped"].includes(restart) &&
+    !/^on-failure:[1-9][0-9]*$/.test(restart)
+  ) {
+    throw new Error(
+      `service ${service}: invalid restart policy "${restart}" (use no, always, on-failure, on-failure:<retries>, or unless-stopped)`,
+    );
+  }
+  for (const entry of svc.extraHosts ?? []) {
+    const i = entry.indexOf(":");
+    const ip = entry.slice(i + 1);
+    if (i <= 0 || (ip !== "host-gateway" && isIP(ip) === 0)) {
+      throw new Error(`service ${service}: invalid extra host "${entry}" (use host:ip or host:host-gateway)`);
+    }
+  }
+  for (const [limit, u] of Object.entries(svc.ulimits ?? {})) {
+    if (!ULIMIT_NAMES.has(limit)) throw new Error(`service ${service}: unknown ulimit "${limit}"`);
+    const { soft, hard } = typeof u === "number" ? { soft: u, hard: u } : u;
+    if (!Number.isInteger(soft) || !Number.isInteger(hard) || soft < -1 || hard < -1) {
+      throw new Error(`service ${service}: ulimit ${limit}: limits are -1 for unlimited or a count`);
+    }
+    // -1 is unlimited, above any count
+    if (hard !== -1 && (soft === -1 || soft > hard)) {
+      throw new Error(`service ${service}: ulimit ${limit}: soft limit ${soft} exceeds hard limit ${hard}`);
+    }
+  }
+}
+
+// -------------------------------------------------------------------
+// Shared networks
+// -------------------------------------------------------------------
+
+/** What a shared network may be called: a Docker network name without the sfa- prefix. */
+const NETWORK_NAME = /^[a-z0-9][a-z0-9_.-]*$/;
+
+/** The Docker network for a declared shared network. */
+function sharedNetworkName(network: string): string {
+  return `sfa-${network}`;
+}
+
+/**
+ * Return services with the agent-wide networks added to each one.
+ */
+export function withAgentNetworks(
+  services: Record<string, ServiceDefinition>,
+  networks: string[] = [],
+): Record<string, ServiceDefinition> {
+  if (networks.length === 0) return services;
+  const joined: Record<string, ServiceDefinition> = {};
+  for (const [name, svc] of Object.entries(services)) {
+    joined[name] = { ...svc, networks: [...new Set([...networks, ...(svc.networks ?? [])])] };
+  }
+  return joined;
+}
+
+/**
+ * The shared networks the services join, sorted. Throws on an invalid name.
+ */
+export function sharedNetworks(services: Record<string, ServiceDefinition>): string[] {
+  const networks = new Set<string>();
+  for (const name of Object.keys(services).sort()) {
+    for (const network of services[name].networks ?? []) {
+      if (network === "default" || !NETWORK_NAME.test(network)) {
+        throw new Error(
+          `service ${name}: invalid network name "${network}" (use lowercase letters, digits, '.', '_', and '-')`,
+        );
+      }
+      networks.add(network);
+    }
+  }
+  return [...networks].sort();
+}
+
+/**
+ * Create the shared networks that do not exist yet, labelled so
+ * `sfa services prune` can find them.
+ */
+async function ensureNetworks(engine: ContainerEngine, networks: string[]): Promise<void> {
+  const exists = async (name: string): Promise<boolean> => {
+    const proc = Bun.spawn([engine.name, "network", "inspect", name], { stdout: "pipe", stderr: "pipe" });
+    return (await proc.exited) === 0;
+  };
+  for (const network of networks) {
+    const name = sharedNetworkName(network);
+    if (await exists(name)) continue;
+    const args = [engine.name, "network", "create", "--label", `sfa.network=${network}`];
+    if (process.env.SFA_SESSION_ID) args.push("--label", `sfa.session=${process.env.SFA_SESSION_ID}`);
+    const proc = Bun.spawn([...args, name], { stdout: "pipe", stderr: "pipe" });
+    const stderr = await new Response(proc.stderr).text();
+    // Another agent may have created it in the meantime
+    if ((await proc.exited) !== 0 && !(await exists(name))) {
+      throw new Error(`failed to create network ${name}: ${stderr.trim()}`);
+    }
+  }
+}
+
+// -------------------------------------------------------------------
+// Named volumes
+// -------------------------------------------------------------------
+
+/** What Docker accepts as a volume name. */
+const VOLUME_NAME = /^[a-zA-Z0-9][a-zA-Z0-9_.-]*$/;
+
+/** The engine volume for an agent's named volume. */
+function serviceVolumeName(agentName: string, volume: string): string {
+  return `sfa-${agentName}-${volume}`;
+}
+
+/**
+ * The volume name a mount such as "pgdata:/var/lib/postgresql/data" uses, or
+ * null for a bind mount ("./init:/x", "/srv/data:/data") or anonymous volume.
+ */
+function namedVolumeSource(mount: string): string | null {
+  const i = mount.indexOf(":");
+  if (i <= 0) return null;
+  const source = mount.slice(0, i);
+  if ("/.~$".includes(source[0]) || source.includes("/")) return null;
+  return source;
+}
+
+/**
+ * Each named volume the services mount, with the services that mount it.
+ * Throws on a name Docker would refuse.
+ */
+export function namedVolumes(services: Record<string, ServiceDefinition>): Map<string, string[]> {
+  const volumes = new Map<string, string[]>();
+  for (const name of Object.keys(services).sort()) {
+    for (const mount of services[name].volumes ?? []) {
+      const volume = namedVolumeSource(mount);
+      if (!volume) continue;
+      if (!VOLUME_NAME.test(volume)) {
+        throw new Error(`service ${name}: invalid volume name "${volume}" (use letters, digits, '.', '_', and '-')`);
+      }
+      const users = volumes.get(volume) ?? [];
+      if (users[users.length - 1] !== name) users.push(name);
+      volumes.set(volume, users);
+    }
+  }
+  return new Map([...volumes].sort(([a], [b]) => a.localeCompare(b)));
+}
+
+/**
+ * Create the agent's named volumes that do not exist yet, labelled with the
+ * agent and the services that mount them. They are external to the compose
+ * project, so `compose down -v` leaves the data for the next run.
+ */
+async function ensureVolumes(engine: ContainerEngine, agentName: string, volumes: Map<string, string[]>): Promise<void> {
+  for (const [volume, users] of volumes) {
+    const name = serviceVolumeName(agentName, volume);
+    const inspect = Bun.spawn([engine.name, "volume", "inspect", name], { stdout: "pipe", stderr: "pipe" });
+    if ((await inspect.exited) === 0) continue;
+    const proc = Bun.spawn(
+      [
+        engine.name, "volume", "create",
+        "--label", `sfa.agent=${agentName}`,
+        "--label", `sfa.service=${users.join(",")}`,
+        "--label", `sfa.volume=${volume}`,
+        name,
+      ],
+      { stdout: "pipe", stderr: "pipe" },
+    );
+    const stderr = await new Response(proc.stderr).text();
+    if ((await proc.exited) !== 0) {
+      throw new Error(`failed to create volume ${name}: ${stderr.trim()}`);
+    }
+  }
+}
+
+/**
+ * Remove an agent's named volumes when its services are torn down for good,
+ * as ephemeral and session services are. Failures are warned and ignored.
+ */
+async function removeServiceVolumes(agentName: string): Promise<void> {
+  try {
+    const engine = await checkContainerEngine(agentName);
+    const ls = Bun.spawn([engine.name, "volume", "ls", "-q", "--filter", `label=sfa.agent=${agentName}`], {
+      stdout: "pipe",
+      stderr: "pipe",
+    });
+    const names = (await new Response(ls.stdout).text()).split(/\s+/).filter(Boolean).sort();
+    await ls.exited;
+    if (names.length === 0) return;
+    const rm = Bun.spawn([engine.name, "volume", "rm", ...names], { stdout: "pipe", stderr: "pipe" });
+    const stderr = await new Response(rm.stderr).text();
+    if ((await rm.exited) !== 0) {
+      stderrLog.warn(`failed to remove volumes for ${agentName}: ${stderr.trim()}`);
+    }
+  } catch {
+    // No container engine; nothing to remove
+  }
+}
+
+// -------------------------------------------------------------------
+// GPU reservations
+// -------------------------------------------------------------------
+
+/**
+ * Read a service's gpus: "all", a positive count such as "1", or
+ * "device=0,2" for specific GPUs, as docker run --gpus takes them.
+ */
+function parseGpuRequest(name: string, gpus: string): { count?: string; deviceIds?: string[] } {
+  if (gpus.startsWith("device=")) {
+    const deviceIds = gpus.slice("device=".length).split(",").map((id) => id.trim()).filter(Boolean);
+    if (deviceIds.length > 0) return { deviceIds };
+  } else if (gpus === "all" || (/^\d+$/.test(gpus) && Number(gpus) > 0)) {
+    return { count: gpus };
+  }
+  throw new Error(`service ${name}: invalid gpus "${gpus}" (use "all", a count such as "1", or "device=0,2")`);
+}
+
+/**
+ * Whether the engine can give containers NVIDIA GPUs: Docker has the nvidia
+ * runtime registered, or Podman has an NVIDIA CDI spec.
+ */
+async function gpuRuntimeAvailable(engine: ContainerEngine): Promise<boolean> {
+  if (engine.name === "podman") {
+    const { readdirSync } = await import("node:fs");
+    return ["/etc/cdi", "/var/run/cdi"].some((dir) => {
+      try {
+        return readdirSync(dir).some((f) => f.startsWith("nvidia"));
+      } catch {
+        return false;
+      }
+    });
+  }
+  const proc = Bun.spawn(["docker", "info", "--format", "{{json .Runtimes}}"], { stdout: "pipe", stderr: "pipe" });
+  const out = await new Response(proc.stdout).text();
+  return (await proc.exited) === 0 && out.includes('"nvidia"');
+}
+
+/** Exit naming the services that request GPUs if the engine has no GPU runtime. */
+async function checkGpuRuntime(engine: ContainerEngine, services: Record<string, ServiceDefinition>): Promise<void> {
+  const requesting = Object.keys(services).filter((name) => services[name].gpus).sort();
+  if (requesting.length === 0 || (await gpuRuntimeAvailable(engine))) return;
+  const title = ENGINE_TITLES[engine.name];
+  const who = requesting.length === 1 ? `service ${requesting[0]} requests` : `services ${requesting.join(", ")} request`;
+  exitWithError(
+    `${who} GPUs, but ${title} has no NVIDIA GPU runtime. Install the NVIDIA Container Toolkit ` +
+      `(https://docs.nvidia.com/datacenter/cloud-native/container-toolkit/) and restart ${title}, or run on a host with GPUs.`,
+    ExitCode.FAILURE,
+  );
+}
+
+// -------------------------------------------------------------------
+// Compose profiles
+// -------------------------------------------------------------------
+
+/** What Docker Compose accepts as a profile name. */
+const PROFILE_NAME = /^[a-zA-Z0-9][a-zA-Z0-9_.-]*$/;
+
+/**
+ * The compose profiles to enable: those passed with --services-profile
+ * (comma-separated) and the agent's services.profiles config, a list or a
+ * comma-separated string. Profiles no service declares are warned about.
+ */
+export async function resolveServiceProfiles(def: AgentDefinition, flagged?: string): Promise<string[]> {
+  const config = await loadConfig();
+  const names = (flagged ?? "").split(",");
+  for (const section of [config.defaults?.services, config.agents?.[def.name]?.services]) {
+    const configured = (section as { profiles?: string | string[] } | undefined)?.profiles;
+    if (configured !== undefined) names.push(...(Array.isArray(configured) ? configured : configured.split(",")));
+  }
+  const declared = new Set(Object.values(def.services ?? {}).flatMap((svc) => svc.profiles ?? []));
+  const profiles = new Set<string>();
+  for (const name of names.map((n) => n.trim()).filter(Boolean)) {
+    if (declared.has(name)) profiles.add(name);
+    else stderrLog.warn(`no service has profile ${name}`);
+  }
+  return [...profiles].sort();
+}
+
+/** Whether compose starts svc with profiles enabled: it has none, or an enabled one. */
+function startsWithProfiles(svc: ServiceDefinition, profiles: string[]): boolean {
+  return !svc.profiles?.length || svc.profiles.some((p) => profiles.includes(p));
+}
+
+/**
+ * The services compose starts with profiles enabled. Throws if one depends
+ * on a service that is not started.
+ */
+export function activeServices(
+  services: Record<string, ServiceDefinition>,
+  profiles: string[],
+): Record<string, ServiceDefinition> {
+  const active = Object.fromEntries(Object.entries(services).filter(([, svc]) => startsWithProfiles(svc, profiles)));
+  for (const name of Object.keys(active).sort()) {
+    for (const dep of Object.keys(active[name].dependsOn ?? {}).sort()) {
+      if (services[dep] && !active[dep]) {
+        const needed = services[dep].profiles!;
+        throw new Error(
+          `service ${name} depends on ${dep}, which starts only with profile ${needed.join(" or ")} (pass --services-profile ${needed[0]})`,
+        );
+      }
+    }
+  }
+  return active;
 }
 
 // -------------------------------------------------------------------
 // 9.2: Compose template materialization
 // -------------------------------------------------------------------
 
+type BuildDefinition = NonNullable<ServiceDefinition["build"]>;
+
+/** The image a service runs: its image, or sfa-<agent>-<service> for a build without one. */
+function serviceImage(agentName: string, name: string, svc: ServiceDefinition): string {
+  return svc.image || (svc.build ? `sfa-${agentName}-${name}` : "");
+}
+
+function buildContextDir(build: BuildDefinition): string {
+  return resolvePath(build.context || ".");
+}
+
+/** Reject a build whose context or Dockerfile is missing, before Docker is invoked. */
+function checkBuild(name: string, build: BuildDefinition): void {
+  const dir = buildContextDir(build);
+  if (!existsSync(dir) || !statSync(dir).isDirectory()) {
+    throw new Error(`service ${name}: build context ${dir} is not a directory`);
+  }
+  const dockerfile = resolvePath(dir, build.dockerfile || "Dockerfile");
+  if (!existsSync(dockerfile)) {
+    throw new Error(`service ${name}: Dockerfile ${dockerfile} not found`);
+  }
+}
+
+/**
+ * Hash what a build depends on: the Dockerfile path, the build args, and
+ * the path and content of each file in the context, .git excepted. Matches
+ * the Go SDK, so either rebuilds only when the inputs change.
+ */
+function buildInputsHash(build: BuildDefinition): string {
+  const dir = buildContextDir(build);
+  const hasher = createHash("sha256");
+  hasher.update(`dockerfile ${build.dockerfile ?? ""}\n`);
+  for (const k of Object.keys(build.args ?? {}).sort()) hasher.update(`arg ${k}=${build.args![k]}\n`);
+  const walk = (rel: string) => {
+    const entries = readdirSync(rel ? `${dir}/${rel}` : dir, { withFileTypes: true }).sort((a, b) =>
+      a.name < b.name ? -1 : a.name > b.name ? 1 : 0,
+    );
+    for (const entry of entries) {
+      const path = rel ? `${rel}/${entry.name}` : entry.name;
+      if (entry.isDirectory()) {
+        if (entry.name !== ".git") walk(path);
+      } else if (entry.isFile()) {
+        hasher.update(`file ${path}\n`);
+        hasher.update(readFileSync(`${dir}/${path}`));
+      }
+    }
+  };
+  walk("");
+  return hasher.digest("hex").slice(0, 16);
+}
+
 /**
  * Convert agent service definitions to a docker compose YAML string.
  * Adds sfa.agent and sfa.version labels to all services.
@@ -194,14 +1084,90 @@ function buildComposeYaml(
   services: Record<string, ServiceDefinition>,
   agentName: string,
   agentVersion: string,
-  env: Record<string, string | undefined>,
 ): string {
   const lines: string[] = [];
   lines.push("services:");
 
-  for (const [name, svc] of Object.entries(services)) {
-    lines.push(`  ${name}:`);
-    lines.push(`    image: ${svc.image}`);
+  // Services are written in start order, so dependencies come first
+  for (const name of serviceStartOrder(services)) {
+    const svc = services[name];
+    lines.push(`  ${name}:`);
+    lines.push(`    image: ${serviceImage(agentName, name, svc)}`);
+
+    // Build from source; the inputs hash labels the image, and changes the
+    // template hash so the service is recreated after a rebuild
+    if (svc.build) {
+      const context = buildContextDir(svc.build);
+      checkBuild(name, svc.build);
+      lines.push("    build:");
+      lines.push(`      context: ${JSON.stringify(context)}`);
+      if (svc.build.dockerfile) lines.push(`      dockerfile: ${JSON.stringify(svc.build.dockerfile)}`);
+      const args = Object.keys(svc.build.args ?? {}).sort();
+      if (args.length > 0) {
+        lines.push("      args:");
+        for (const k of args) lines.push(`        ${k}: ${JSON.stringify(svc.build.args![k])}`);
+      }
+      lines.push("      labels:");
+      lines.push(`        sfa.build-hash: "${buildInputsHash(svc.build)}"`);
+    }
+
+    // Profiles
+    if (svc.profiles && svc.profiles.length > 0) {
+      const bad = svc.profiles.find((p) => !PROFILE_NAME.test(p));
+      if (bad !== undefined) {
+        throw new Error(`service ${name}: invalid profile name "${bad}" (use letters, digits, ., _, and -)`);
+      }
+      lines.push("    profiles:");
+      for (const profile of svc.profiles) lines.push(`      - "${profile}"`);
+    }
+
+    // Dependencies
+    const deps = Object.keys(svc.dependsOn ?? {}).sort();
+    if (deps.length > 0) {
+      lines.push("    depends_on:");
+      for (const dep of deps) {
+        lines.push(`      ${dep}:`);
+        lines.push(`        condition: ${svc.dependsOn![dep] || "service_started"}`);
+      }
+    }
+
+    // Resource limits and GPU reservations
+    if (svc.resources || svc.gpus) {
+      lines.push("    deploy:");
+      lines.push("      resources:");
+    }
+    if (svc.resources) {
+      checkResourceLimits(name, svc.resources);
+      lines.push("        limits:");
+      if (svc.resources.cpus !== undefined) lines.push(`          cpus: "${svc.resources.cpus}"`);
+      if (svc.resources.memory) lines.push(`          memory: ${svc.resources.memory}`);
+      if (svc.resources.pids) lines.push(`          pids: ${svc.resources.pids}`);
+    }
+    if (svc.gpus) {
+      const request = parseGpuRequest(name, svc.gpus);
+      lines.push("        reservations:");
+      lines.push("          devices:");
+      lines.push("            - driver: nvidia");
+      if (request.deviceIds) {
+        lines.push("              device_ids:");
+        for (const id of request.deviceIds) lines.push(`                - "${id}"`);
+      } else {
+        lines.push(`              count: ${request.count}`);
+      }
+      lines.push("              capabilities: [gpu]");
+    }
+
+    // Shared networks; listing networks drops the implicit default one, so
+    // keep it for the agent's own services
+    if (svc.networks && svc.networks.length > 0) {
+      lines.push("    networks:");
+      lines.push("      default: {}");
+      for (const network of svc.networks) {
+        lines.push(`      ${network}:`);
+        lines.push("        aliases:");
+        lines.push(`          - "${agentName}-${name}"`);
+      }
+    }
 
     // Labels (9.4)
     lines.push("    labels:");
@@ -241,6 +1207,27 @@ function buildComposeYaml(
       }
     }
 
+    // User, restart policy, extra hosts, and ulimits
+    checkContainerOptions(name, svc);
+    if (svc.user) lines.push(`    user: ${JSON.stringify(svc.user)}`);
+    if (svc.restart) lines.push(`    restart: "${svc.restart}"`);
+    if (svc.extraHosts && svc.extraHosts.length > 0) {
+      lines.push("    extra_hosts:");
+      for (const entry of svc.extraHosts) lines.push(`      - "${entry}"`);
+    }
+    const ulimits = Object.keys(svc.ulimits ?? {}).sort();
+    if (ulimits.length > 0) {
+      lines.push("    ulimits:");
+      for (const limit of ulimits) {
+        const u = svc.ulimits![limit];
+        if (typeof u === "number" || u.soft === u.hard) {
+          lines.push(`      ${limit}: ${typeof u === "number" ? u : u.soft}`);
+        } else {
+          lines.push(`      ${limit}:`, `        soft: ${u.soft}`, `        hard: ${u.hard}`);
+        }
+      }
+    }
+
     // Healthcheck
     if (svc.healthcheck) {
       lines.push("    healthcheck:");
@@ -253,28 +1240,53 @@ function buildComposeYaml(
     }
   }
 
-  const yaml = lines.join("\n") + "\n";
+  // Named volumes are created before compose up, outside the project, so
+  // compose down -v leaves their data
+  const volumes = namedVolumes(services);
+  if (volumes.size > 0) {
+    lines.push("volumes:");
+    for (const volume of volumes.keys()) {
+      lines.push(`  ${volume}:`);
+      lines.push(`    name: ${serviceVolumeName(agentName, volume)}`);
+      lines.push("    external: true");
+    }
+  }
+
+  // Shared networks are created before compose up, outside the project, so
+  // taking the services down leaves them for other agents
+  const networks = sharedNetworks(services);
+  if (networks.length > 0) {
+    lines.push("networks:");
+    for (const network of networks) {
+      lines.push(`  ${network}:`);
+      lines.push(`    name: ${sharedNetworkName(network)}`);
+      lines.push("    external: true");
+    }
+  }
 
-  // Interpolate variables from the agent environment
-  return interpolateVars(yaml, env);
+  return lines.join("\n") + "\n";
 }
 
 /**
- * Materialize the compose template to disk.
- * Creates the directory with 0700 permissions (9.13).
+ * Materialize the compose template to disk, with the enabled profiles in its
+ * .env file. Creates the directory with 0700 permissions (9.13).
  */
 export async function materializeCompose(
   services: Record<string, ServiceDefinition>,
   agentName: string,
   agentVersion: string,
   env: Record<string, string | undefined>,
+  profiles: string[] = [],
 ): Promise<string> {
   const dir = composeDir(agentName);
   const filePath = composeFilePath(agentName);
-  const yaml = buildComposeYaml(services, agentName, agentVersion, env);
+  const yaml = buildComposeYaml(services, agentName, agentVersion);
+  const vars = referencedVars(yaml, env);
+  // Later compose commands (ps, logs, down) then see the same services as up
+  if (profiles.length > 0) vars.COMPOSE_PROFILES = profiles.join(",");
 
   // Create directory with 0700 permissions (9.13)
-  const { mkdirSync, chmodSync, unlinkSync } = await import("node:fs");
+  const { mkdirSync, chmodSync, unlinkSync, writeFileSync } = await import("node:fs");
   mkdirSync(dir, { recursive: true });
   chmodSync(dir, 0o700);
 
@@ -284,8 +1296,12 @@ export async function materializeCompose(
     try { unlinkSync(legacyPath); } catch { /* not present */ }
   }
 
-  // Write compose file
-  await Bun.write(filePath, yaml);
+  // Write the compose file with ${VAR} references, and their values (secrets
+  // included) to the .env file compose reads, both readable by the user alone
+  for (const [path, content] of [[filePath, yaml], [`${dir}/.env`, formatDotenv(vars)]]) {
+    writeFileSync(path, content, { mode: 0o600 });
+    chmodSync(path, 0o600);
+  }
 
   return filePath;
 }
@@ -305,12 +1321,13 @@ async function hashTemplate(content: string): Promise<string> {
 
 /**
  * Read the current materialized compose file hash, or null if not present.
+ * The hash is the file's first line; the Go SDK adds per-service hashes after it.
  */
 async function readCurrentTemplateHash(agentName: string): Promise<string | null> {
   const hashFile = `${composeDir(agentName)}/.template-hash`;
   const file = Bun.file(hashFile);
   if (await file.exists()) {
-    return (await file.text()).trim();
+    return (await file.text()).trim().split("\n")[0];
   }
   return null;
 }
@@ -330,10 +1347,10 @@ async function writeTemplateHash(agentName: string, hash: string): Promise<void>
 /**
  * Check if services are already running for this agent with matching labels.
  */
-async function checkRunningServices(agentName: string): Promise<boolean> {
+async function checkRunningServices(engine: ContainerEngine, agentName: string): Promise<boolean> {
   try {
     const proc = Bun.spawn(
-      ["docker", "ps", "--filter", `label=sfa.agent=${agentName}`, "--format", "{{.ID}}"],
+      [engine.name, "ps", "--filter", `label=sfa.agent=${agentName}`, "--format", "{{.ID}}"],
       { stdout: "pipe", stderr: "pipe" },
     );
     const output = await new Response(proc.stdout).text();
@@ -348,12 +1365,90 @@ async function checkRunningServices(agentName: string): Promise<boolean> {
 // 9.4: Docker compose up
 // -------------------------------------------------------------------
 
+/**
+ * Pull the images the services need and the engine does not have, all at
+ * once rather than one after another as Podman's compose does, reporting each
+ * service's pull as a progress line. Services sharing an image pull it once;
+ * services built from source are left to buildServices.
+ */
+async function pullServiceImages(
+  engine: ContainerEngine,
+  agentName: string,
+  services: Record<string, ServiceDefinition>,
+): Promise<void> {
+  const byImage = new Map<string, string[]>();
+  for (const name of Object.keys(services).sort()) {
+    const image = services[name].image;
+    if (image && !services[name].build) byImage.set(image, [...(byImage.get(image) ?? []), name]);
+  }
+
+  const failed: string[] = [];
+  await Promise.all(
+    [...byImage].map(async ([image, names]) => {
+      const inspect = Bun.spawn([engine.name, "image", "inspect", image], { stdout: "ignore", stderr: "ignore" });
+      if ((await inspect.exited) === 0) return;
+
+      const label = names.join(", ");
+      const start = Date.now();
+      emitProgress(agentName, `service ${label}: pulling ${image}`);
+      const pull = Bun.spawn([engine.name, "pull", image], { stdout: "pipe", stderr: "pipe" });
+      const output = (await new Response(pull.stdout).text()) + (await new Response(pull.stderr).text());
+      if ((await pull.exited) !== 0) {
+        failed.push(`service ${label}: failed to pull ${image}: ${output.trim()}`);
+        return;
+      }
+      emitProgress(agentName, `service ${label}: pulled ${image} in ${Math.round((Date.now() - start) / 1000)}s`);
+    }),
+  );
+
+  if (failed.length > 0) {
+    throw new Error(`Cannot start services:\n  • ${failed.sort().join("\n  • ")}`);
+  }
+}
+
+/**
+ * Run compose build for the services built from source whose image is
+ * missing or carries another sfa.build-hash label than their inputs.
+ */
+async function buildServices(
+  engine: ContainerEngine,
+  agentName: string,
+  services: Record<string, ServiceDefinition>,
+): Promise<void> {
+  const stale: string[] = [];
+  for (const name of Object.keys(services).sort()) {
+    const svc = services[name];
+    if (!svc.build) continue;
+    const inspect = Bun.spawn(
+      [engine.name, "image", "inspect", "--format", '{{index .Config.Labels "sfa.build-hash"}}', serviceImage(agentName, name, svc)],
+      { stdout: "pipe", stderr: "ignore" },
+    );
+    const label = (await new Response(inspect.stdout).text()).trim();
+    if ((await inspect.exited) !== 0 || label !== buildInputsHash(svc.build)) stale.push(name);
+  }
+  if (stale.length === 0) return;
+
+  const names = stale.join(", ");
+  const start = Date.now();
+  emitProgress(agentName, `service ${names}: building`);
+  const proc = Bun.spawn([...engine.compose, "build", ...stale], {
+    cwd: composeDir(agentName),
+    stdout: "pipe",
+    stderr: "pipe",
+  });
+  const stderr = await new Response(proc.stderr).text();
+  if ((await proc.exited) !== 0) {
+    throw new Error(`service ${names}: failed to build:\n${stderr}`);
+  }
+  emitProgress(agentName, `service ${names}: built in ${Math.round((Date.now() - start) / 1000)}s`);
+}
+
 /**
  * Run docker compose up -d for the agent.
  */
-async function composeUp(agentName: string): Promise<void> {
+async function composeUp(engine: ContainerEngine, agentName: string, profiles: string[]): Promise<void> {
   const dir = composeDir(agentName);
-  const proc = Bun.spawn(["docker", "compose", "up", "-d"], {
+  const proc = Bun.spawn([...engine.compose, ...profiles.flatMap((p) => ["--profile", p]), "up", "-d"], {
     cwd: dir,
     stdout: "pipe",
     stderr: "pipe",
@@ -361,7 +1456,64 @@ async function composeUp(agentName: string): Promise<void> {
   const stderr = await new Response(proc.stderr).text();
   const exitCode = await proc.exited;
   if (exitCode !== 0) {
-    throw new Error(`docker compose up failed:\n${stderr}`);
+    throw new Error(`${engine.compose.join(" ")} up failed:\n${stderr}`);
+  }
+}
+
+/** Beside compose.yaml, what the SDK last brought up, for sfa services list to compare with the engine. */
+const SERVICE_STATE_FILE = "state.json";
+
+/**
+ * Write state.json for the services just brought up: the compose hash, when
+ * they started, and each service's container and published ports. Failing
+ * to is only warned about.
+ */
+async function writeServiceState(
+  engine: ContainerEngine,
+  agentName: string,
+  composeHash: string,
+  services: Record<string, ServiceDefinition>,
+): Promise<void> {
+  const dir = composeDir(agentName);
+  const proc = Bun.spawn([...engine.compose, "ps", "-a", "--format", "{{.Service}}\t{{.ID}}\t{{.Name}}"], {
+    cwd: dir,
+    stdout: "pipe",
+    stderr: "pipe",
+  });
+  const out = await new Response(proc.stdout).text();
+  if ((await proc.exited) !== 0) {
+    stderrLog.warn("failed to record service state");
+    return;
+  }
+  const containers = new Map<string, [string, string]>();
+  for (const line of out.trim().split("\n")) {
+    const fields = line.trim().split("\t");
+    if (fields.length === 3 && fields[0]) containers.set(fields[0], [fields[1], fields[2]]);
+  }
+
+  const state = {
+    agent: agentName,
+    host: engine.host,
+    composeHash,
+    startedAt: new Date().toISOString().replace(/\.\d+Z$/, "Z"),
+    services: Object.fromEntries(
+      Object.keys(services)
+        .sort()
+        .map((name) => {
+          const [container, containerName] = containers.get(name) ?? ["", ""];
+          const ports = services[name].ports;
+          return [
+            name,
+            { container, ...(containerName ? { name: containerName } : {}), ...(ports?.length ? { ports } : {}) },
+          ];
+        }),
+    ),
+  };
+  try {
+    writeFileSync(`${dir}/${SERVICE_STATE_FILE}.tmp`, `${JSON.stringify(state, null, 2)}\n`, { mode: 0o600 });
+    renameSync(`${dir}/${SERVICE_STATE_FILE}.tmp`, `${dir}/${SERVICE_STATE_FILE}`);
+  } catch (err) {
+    stderrLog.warn(`failed to record service state: ${(err as Error).message}`);
   }
 }
 
@@ -370,19 +1522,134 @@ async function composeUp(agentName: string): Promise<void> {
 // -------------------------------------------------------------------
 
 /**
- * Wait for all services to be healthy.
- * Polls docker compose ps until all services report "healthy" or "running" (no healthcheck).
+ * Seconds to wait for services: SFA_SERVICE_TIMEOUT > serviceStartTimeout >
+ * the older serviceHealthTimeout > 60.
+ */
+export function serviceStartTimeout(def: AgentDefinition): number {
+  const override = process.env.SFA_SERVICE_TIMEOUT;
+  if (override) {
+    const n = Number(override);
+    if (Number.isInteger(n) && n > 0) return n;
+    stderrLog.warn(`ignoring invalid SFA_SERVICE_TIMEOUT "${override}" (want a number of seconds)`);
+  }
+  const legacy = (def as AgentDefinition & { serviceHealthTimeout?: number }).serviceHealthTimeout;
+  return def.serviceStartTimeout ?? legacy ?? 60;
+}
+
+/** A container event for one of an agent's services. */
+interface ServiceEvent {
+  service: string;
+  action: string;
+  exitCode: number;
+}
+
+/**
+ * Parse a line of engine events --format '{{json .}}': Docker gives the
+ * action and labels under Action and Actor.Attributes, Podman under Status
+ * and Attributes, and calls die "died".
+ */
+function parseServiceEvent(line: string): ServiceEvent | null {
+  try {
+    const raw = JSON.parse(line);
+    const attrs: Record<string, string> = raw.Actor?.Attributes ?? raw.Attributes ?? {};
+    // Docker appends the result, e.g. "health_status: healthy"
+    let action = String(raw.Action || raw.Status || "").split(":")[0];
+    if (action === "died") action = "die";
+    const service = attrs["com.docker.compose.service"];
+    if (!service || !action) return null;
+    const exitCode = Number.parseInt(attrs.exitCode ?? "", 10);
+    return { service, action, exitCode: Number.isNaN(exitCode) ? (raw.ContainerExitCode ?? 0) : exitCode };
+  } catch {
+    return null;
+  }
+}
+
+/**
+ * Stream the container events of an agent's services. wait resolves on the
+ * next event or after the fallback poll interval, 5s while events stream
+ * and 2s if the engine cannot stream them. Containers that died are kept
+ * in died, so one restarted between two checks is not missed.
+ */
+function watchServiceEvents(engine: ContainerEngine, agentName: string) {
+  const died = new Map<string, number>();
+  let wake: (() => void) | null = null;
+  let streaming = false;
+  let proc: ReturnType<typeof Bun.spawn> | null = null;
+  try {
+    proc = Bun.spawn(
+      [engine.name, "events", "--filter", "type=container", "--filter", `label=sfa.agent=${agentName}`, "--format", "{{json .}}"],
+      { stdout: "pipe", stderr: "ignore" },
+    );
+    streaming = true;
+    (async () => {
+      const decoder = new TextDecoder();
+      let buffered = "";
+      for await (const chunk of proc!.stdout as ReadableStream<Uint8Array>) {
+        buffered += decoder.decode(chunk, { stream: true });
+        const lines = buffered.split("\n");
+        buffered = lines.pop() ?? "";
+        for (const line of lines) {
+          const ev = parseServiceEvent(line);
+          if (!ev) continue;
+          if (ev.action === "die") died.set(ev.service, ev.exitCode);
+          wake?.();
+        }
+      }
+    })()
+      .catch(() => {})
+      .finally(() => {
+        streaming = false;
+      });
+  } catch {
+    // Fall back to polling
+  }
+  return {
+    died,
+    wait: () =>
+      new Promise<void>((resolve) => {
+        const timer = setTimeout(() => {
+          wake = null;
+          resolve();
+        }, streaming ? 5000 : 2000);
+        wake = () => {
+          clearTimeout(timer);
+          wake = null;
+          resolve();
+        };
+      }),
+    stop: () => proc?.kill(),
+  };
+}
+
+/**
+ * Wait for all services to be ready.
+ * Checks docker compose ps, on each engine event for the agent's containers
+ * and as a fallback poll, until every service reports "healthy" (with a
+ * healthcheck) or "running" (without one). With `services`, a service another
+ * depends on with `service_completed_successfully` is ready once it exits 0,
+ * and any other exited service, or one that died and was restarted, fails
+ * at once.
  */
 export async function waitForHealthy(
   agentName: string,
   timeoutSeconds: number = 60,
+  services?: Record<string, ServiceDefinition>,
 ): Promise<void> {
+  const engine = await checkContainerEngine(agentName);
   const dir = composeDir(agentName);
-  const deadline = Date.now() + timeoutSeconds * 1000;
+  const start = Date.now();
+  const deadline = start + timeoutSeconds * 1000;
+  const elapsed = () => `${Math.round((Date.now() - start) / 1000)}s`;
+  const ready = new Set<string>();
+  let lastReport = start;
+  let waiting = "";
+  // Engine events wake the loop as soon as a container dies or changes
+  // health; compose ps stays the source of truth, polled as a fallback.
+  const events = watchServiceEvents(engine, agentName);
 
   while (Date.now() < deadline) {
     const proc = Bun.spawn(
-      ["docker", "compose", "ps", "--format", "json"],
+      [...engine.compose, "ps", "-a", "--format", "json"],
       { cwd: dir, stdout: "pipe", stderr: "pipe" },
     );
     const output = await new Response(proc.stdout).text();
@@ -404,25 +1671,159 @@ export async function waitForHealthy(
         .filter(Boolean);
 
       if (containers.length > 0) {
-        const allHealthy = containers.every((c: { Health?: string; State?: string }) => {
+        const oneShot = new Set<string>();
+        for (const svc of Object.values(services ?? {})) {
+          for (const [dep, cond] of Object.entries(svc.dependsOn ?? {})) {
+            if (cond === "service_completed_successfully") oneShot.add(dep);
+          }
+        }
+        type Container = { Service?: string; Health?: string; State?: string; ExitCode?: number };
+        // A container that died counts as exited even if it was restarted
+        // before this check.
+        for (const c of containers as Container[]) {
+          const exitCode = events.died.get(c.Service ?? "");
+          if (exitCode !== undefined) Object.assign(c, { State: "exited", ExitCode: exitCode });
+        }
+        const failed = containers.find(
+          (c: Container) => c.State === "exited" && !(oneShot.has(c.Service ?? "") && c.ExitCode === 0),
+        ) as Container | undefined;
+        if (failed) {
+          events.stop();
+          const reason = `Service ${failed.Service} exited with code ${failed.ExitCode}.`;
+          const bundle = await dumpLogsAndTearDown(agentName, dir, reason);
+          exitWithError(`${reason}${bundle ? `\ndiagnostics saved to ${bundle}` : ""}`, ExitCode.FAILURE);
+        }
+
+        const isReady = (c: Container) => {
+          // A completed one-shot service (e.g. migrations) is done
+          if (c.State === "exited") return true;
           // If service has a healthcheck, it must report "healthy"
           // If no healthcheck, "running" is sufficient
           if (c.Health) {
             return c.Health === "healthy";
           }
           return c.State === "running";
-        });
-
-        if (allHealthy) return;
+        };
+        const state = (c: Container) =>
+          c.State === "exited" ? "completed" : c.State === "running" && c.Health ? c.Health : (c.State ?? "unknown");
+
+        for (const c of containers as Container[]) {
+          if (isReady(c) && c.Service && !ready.has(c.Service)) {
+            ready.add(c.Service);
+            emitProgress(agentName, `service ${c.Service} ${state(c)} after ${elapsed()}`);
+          }
+        }
+        const pending = (containers as Container[]).filter((c) => !isReady(c));
+        if (pending.length === 0) {
+          events.stop();
+          return;
+        }
+
+        waiting = pending.map((c) => `${c.Service} (${state(c)})`).join(", ");
+        if (Date.now() - lastReport >= 10_000) {
+          lastReport = Date.now();
+          emitProgress(agentName, `waiting for services (${elapsed()} of ${timeoutSeconds}s): ${waiting}`);
+        }
       }
     }
 
-    // Wait 2s before next poll
-    await new Promise((resolve) => setTimeout(resolve, 2000));
+    await events.wait();
   }
+  events.stop();
 
   // Timeout — dump logs and tear down
-  const logProc = Bun.spawn(["docker", "compose", "logs", "--tail=50"], {
+  const bundle = await dumpLogsAndTearDown(agentName, dir, `Service health check timeout (${timeoutSeconds}s).`);
+
+  exitWithError(
+    `Services failed to become healthy within ${timeoutSeconds}s${waiting ? ` (waiting on ${waiting})` : ""}. ` +
+      "Raise serviceStartTimeout or set SFA_SERVICE_TIMEOUT to wait longer." +
+      (bundle ? `\ndiagnostics saved to ${bundle}` : ""),
+    ExitCode.FAILURE,
+  );
+}
+
+/** Log lines a diagnostics bundle keeps per service, and bundles an agent keeps. */
+const DIAGNOSTICS_LOG_LINES = 200;
+const DIAGNOSTICS_KEPT = 5;
+
+/**
+ * Replace the values in the Config.Env of docker inspect output with
+ * "<redacted>", keeping the names, so a bundle can be shared without the
+ * passwords services are configured with.
+ */
+function redactInspectEnv(out: string): string {
+  try {
+    const containers = JSON.parse(out) as { Config?: { Env?: string[] } }[];
+    for (const c of containers) {
+      if (c.Config?.Env) c.Config.Env = c.Config.Env.map((e) => `${e.split("=")[0]}=<redacted>`);
+    }
+    return `${JSON.stringify(containers, null, 2)}\n`;
+  } catch {
+    return out;
+  }
+}
+
+/**
+ * Save what is needed to debug services that did not become healthy to a
+ * timestamped directory under the agent's services directory, and return
+ * its path: the compose file, compose ps, and for each service its inspect
+ * output with environment values redacted, its healthcheck history, and its
+ * last log lines. Older bundles beyond DIAGNOSTICS_KEPT are removed.
+ */
+async function writeServiceDiagnostics(engine: ContainerEngine, dir: string): Promise<string | null> {
+  const { mkdirSync, readdirSync, rmSync } = await import("node:fs");
+  const root = `${dir}/diagnostics`;
+  const bundle = `${root}/${new Date().toISOString().replace(/[-:]/g, "").replace(/\.\d+Z$/, "Z")}`;
+  try {
+    mkdirSync(bundle, { recursive: true, mode: 0o700 });
+  } catch (err) {
+    stderrLog.warn(`failed to create diagnostics directory: ${(err as Error).message}`);
+    return null;
+  }
+  const run = async (argv: string[]) => {
+    const proc = Bun.spawn(argv, { cwd: dir, stdout: "pipe", stderr: "pipe" });
+    const out = (await new Response(proc.stdout).text()) + (await new Response(proc.stderr).text());
+    return (await proc.exited) === 0 ? out : "";
+  };
+  const write = (name: string, data: string) => {
+    if (data) writeFileSync(`${bundle}/${name}`, data, { mode: 0o600 });
+  };
+
+  const existing = COMPOSE_FILENAMES.map((f) => `${dir}/${f}`).find((f) => existsSync(f));
+  if (existing) write("compose.yaml", readFileSync(existing, "utf-8"));
+  write("ps.txt", await run([...engine.compose, "ps", "-a"]));
+
+  const ps = await run([...engine.compose, "ps", "-a", "--format", "{{.Service}}\t{{.ID}}"]);
+  for (const line of ps.trim().split("\n")) {
+    const [service, id] = line.trim().split("\t");
+    if (!service || !id) continue;
+    const inspect = await run([engine.name, "inspect", id]);
+    write(`${service}.inspect.json`, redactInspectEnv(inspect));
+    try {
+      const health = (JSON.parse(inspect) as { State?: { Health?: unknown } }[])[0]?.State?.Health;
+      if (health) write(`${service}.health.json`, `${JSON.stringify(health, null, 2)}\n`);
+    } catch {
+      // No inspect output
+    }
+    write(`${service}.log`, await run([...engine.compose, "logs", "--no-color", "--tail", String(DIAGNOSTICS_LOG_LINES), service]));
+  }
+
+  // Bundle names are timestamps, so they sort oldest first
+  const bundles = readdirSync(root).sort();
+  for (const old of bundles.slice(0, Math.max(0, bundles.length - DIAGNOSTICS_KEPT))) {
+    rmSync(`${root}/${old}`, { recursive: true, force: true });
+  }
+  return bundle;
+}
+
+/**
+ * Write the services' recent logs to stderr after `reason`, save a
+ * diagnostics bundle, then tear them down. Returns the bundle's path, or
+ * null if it could not be saved.
+ */
+async function dumpLogsAndTearDown(agentName: string, dir: string, reason: string): Promise<string | null> {
+  const engine = await checkContainerEngine(agentName);
+  const logProc = Bun.spawn([...engine.compose, "logs", "--tail=50"], {
     cwd: dir,
     stdout: "pipe",
     stderr: "pipe",
@@ -430,21 +1831,56 @@ export async function waitForHealthy(
   const logs = await new Response(logProc.stdout).text();
   await logProc.exited;
 
-  process.stderr.write(`Service health check timeout (${timeoutSeconds}s). Recent logs:\n${logs}\n`);
+  process.stderr.write(`${reason} Recent logs:\n${logs}\n`);
 
-  // Tear down
+  const bundle = await writeServiceDiagnostics(engine, dir);
   await composeDown(agentName);
-
-  exitWithError(
-    `Services failed to become healthy within ${timeoutSeconds}s.`,
-    ExitCode.FAILURE,
-  );
+  return bundle;
 }
 
 // -------------------------------------------------------------------
 // 9.6: Connection string injection
 // -------------------------------------------------------------------
 
+/**
+ * Fill in a service's connectionString: ${host} and ${port} from its published
+ * address, and any other ${VAR} from the service's environment, then the
+ * process environment. Returns the placeholders it could not fill.
+ */
+function renderConnectionString(
+  svc: ServiceDefinition,
+  host: string,
+  port: string | undefined,
+): { url: string; unresolved: string[] } {
+  const unresolved: string[] = [];
+  const url = svc.connectionString!.replace(/\$\{([^}]+)\}/g, (match, name: string) => {
+    if (name === "host") return host;
+    if (name === "port") {
+      if (port) return port;
+    } else if (svc.environment && name in svc.environment) {
+      return svc.environment[name].replace(/\$\{([^}]+)\}/g, (m, v: string) => process.env[v] ?? m);
+    } else if (process.env[name] !== undefined) {
+      return process.env[name]!;
+    }
+    unresolved.push(name);
+    return match;
+  });
+  return { url, unresolved };
+}
+
+/** Reject a connection string with placeholders the SDK cannot fill, before Docker is invoked. */
+function checkConnectionString(name: string, svc: ServiceDefinition): void {
+  if (!svc.connectionString) return;
+  const port = publishedPorts(svc.ports)[0]?.port;
+  const { unresolved } = renderConnectionString(svc, "localhost", port ? String(port) : undefined);
+  if (unresolved.length > 0) {
+    throw new Error(
+      `service ${name}: connection string: unresolved \${${unresolved.join("}, ${")}} ` +
+        "(set it in the environment or the service's environment; ${port} needs a published port)",
+    );
+  }
+}
+
 /**
  * Read published ports for a service and inject SFA_SVC_* env vars.
  */
@@ -453,42 +1889,24 @@ async function injectServiceConnectionVars(
   serviceName: string,
   svcDef: ServiceDefinition,
 ): Promise<void> {
-  const dir = composeDir(agentName);
+  const engine = await checkContainerEngine(agentName);
   const envName = serviceName.toUpperCase().replace(/-/g, "_");
 
-  if (!svcDef.ports || svcDef.ports.length === 0) return;
-
-  // Get the first port mapping to determine the published port
-  const firstPort = svcDef.ports[0];
-  const containerPort = firstPort.includes(":") ? firstPort.split(":")[1] : firstPort;
-
-  const proc = Bun.spawn(
-    ["docker", "compose", "port", serviceName, containerPort],
-    { cwd: dir, stdout: "pipe", stderr: "pipe" },
-  );
-  const output = await new Response(proc.stdout).text();
-  await proc.exited;
-
-  const trimmed = output.trim();
-  if (!trimmed) return;
-
-  // Output is like "0.0.0.0:32768" or ":::32768"
-  const parts = trimmed.split(":");
-  const port = parts[parts.length - 1];
-  const host = "localhost";
+  // The ports were rewritten to the published ones by discoverPublishedPorts
+  const published = publishedPorts(svcDef.ports)[0];
+  if (!published) return;
+  const port = String(published.port);
+  const host = engine.host;
 
   process.env[`SFA_SVC_${envName}_HOST`] = host;
   process.env[`SFA_SVC_${envName}_PORT`] = port;
 
-  // 9.7: Custom connection string template
+  // 9.7: Custom connection string template, checked before compose up
   if (svcDef.connectionString) {
-    const url = svcDef.connectionString
-      .replace(/\$\{host\}/g, host)
-      .replace(/\$\{port\}/g, port);
-    process.env[`SFA_SVC_${envName}_URL`] = url;
+    process.env[`SFA_SVC_${envName}_URL`] = renderConnectionString(svcDef, host, port).url;
   } else {
     // Default URL based on image name heuristic
-    const image = svcDef.image.toLowerCase();
+    const image = (svcDef.image ?? "").toLowerCase();
     let protocol = "tcp";
     if (image.includes("postgres") || image.includes("pgvector")) protocol = "postgresql";
     else if (image.includes("redis")) protocol = "redis";
@@ -512,12 +1930,20 @@ export async function composeDown(agentName: string): Promise<void> {
   const existing = await findExistingComposeFile(agentName);
   if (!existing) return;
 
-  const proc = Bun.spawn(["docker", "compose", "-f", existing, "down", "-v"], {
+  const engine = await checkContainerEngine(agentName);
+  const proc = Bun.spawn([...engine.compose, "-f", existing, "down", "-v"], {
     cwd: dir,
     stdout: "pipe",
     stderr: "pipe",
   });
   await proc.exited;
+  for (const file of [LAST_USED_FILE, SERVICE_STATE_FILE]) {
+    try {
+      unlinkSync(`${dir}/${file}`);
+    } catch {
+      // Never recorded
+    }
+  }
 }
 
 // -------------------------------------------------------------------
@@ -528,7 +1954,7 @@ export async function composeDown(agentName: string): Promise<void> {
  * Handle the --services-down flag: tear down services and exit.
  */
 export async function handleServicesDown(agentName: string): Promise<never> {
-  const running = await checkRunningServices(agentName);
+  const running = await checkRunningServices(await checkContainerEngine(agentName), agentName);
   if (!running) {
     process.stderr.write(`No services running for ${agentName}.\n`);
     process.exit(ExitCode.SUCCESS);
@@ -539,6 +1965,97 @@ export async function handleServicesDown(agentName: string): Promise<never> {
   process.exit(ExitCode.SUCCESS);
 }
 
+// -------------------------------------------------------------------
+// Session lifecycle: refcount file at sessions/<id>.services.json
+// -------------------------------------------------------------------
+
+/** How many running invocations use each agent's session services. */
+interface SessionServices {
+  agents: Record<string, number>;
+  rootExited?: boolean;
+}
+
+function sessionServicesPath(sessionId: string): string {
+  return dataDir("sessions", `${sessionId}.services.json`);
+}
+
+/**
+ * Apply fn to the refcount file under an exclusive lock (a lock file
+ * created with O_EXCL), removing the file if fn returns true. Failures are
+ * warned to stderr and ignored.
+ */
+async function updateSessionServices(sessionId: string, fn: (s: SessionServices) => boolean): Promise<void> {
+  const { mkdirSync, openSync, closeSync, unlinkSync, readFileSync, writeFileSync, renameSync } = await import("node:fs");
+  const path = sessionServicesPath(sessionId);
+  const lockPath = `${path}.lock`;
+  try {
+    mkdirSync(dataDir("sessions"), { recursive: true });
+    const deadline = Date.now() + 10_000;
+    let fd: number | null = null;
+    while (fd === null) {
+      try {
+        fd = openSync(lockPath, "wx");
+      } catch (err) {
+        if ((err as NodeJS.ErrnoException).code !== "EEXIST" || Date.now() > deadline) throw err;
+        await new Promise((resolve) => setTimeout(resolve, 50));
+      }
+    }
+    try {
+      let s: SessionServices = { agents: {} };
+      try {
+        s = JSON.parse(readFileSync(path, "utf8")) as SessionServices;
+        s.agents ??= {};
+      } catch (err) {
+        if ((err as NodeJS.ErrnoException).code !== "ENOENT") throw err;
+      }
+      if (fn(s) || Object.keys(s.agents).length === 0) {
+        try { unlinkSync(path); } catch { /* not present */ }
+      } else {
+        writeFileSync(`${path}.tmp`, JSON.stringify(s, null, 2) + "\n");
+        renameSync(`${path}.tmp`, path);
+      }
+    } finally {
+      closeSync(fd);
+      unlinkSync(lockPath);
+    }
+  } catch (err) {
+    stderrLog.warn(`failed to update session services: ${(err as Error).message}`);
+  }
+}
+
+/**
+ * Count one more running invocation using agentName's session services.
+ */
+export async function holdSessionServices(sessionId: string, agentName: string): Promise<void> {
+  await updateSessionServices(sessionId, (s) => {
+    s.agents[agentName] = (s.agents[agentName] ?? 0) + 1;
+    return false;
+  });
+}
+
+/**
+ * Drop this invocation's hold on agentName's session services, if any, and
+ * record the root agent's exit. Once the root agent has exited and nothing
+ * holds any services, tear down every agent's session services.
+ */
+export async function endSessionServices(sessionId: string, agentName: string | null, root: boolean): Promise<void> {
+  // Most sessions start no session services; don't leave files for them
+  if (!(await Bun.file(sessionServicesPath(sessionId)).exists())) return;
+
+  let teardown: string[] = [];
+  await updateSessionServices(sessionId, (s) => {
+    if (agentName && (s.agents[agentName] ?? 0) > 0) s.agents[agentName]--;
+    if (root) s.rootExited = true;
+    if (!s.rootExited || Object.values(s.agents).some((n) => n > 0)) return false;
+    teardown = Object.keys(s.agents).sort();
+    return true;
+  });
+  for (const agent of teardown) {
+    await composeDown(agent);
+    await removeServiceVolumes(agent);
+  }
+}
+
 // -------------------------------------------------------------------
 // Main lifecycle: startServices / stopServices
 // -------------------------------------------------------------------
@@ -551,11 +2068,18 @@ export async function handleServicesDown(agentName: string): Promise<never> {
 export async function startServices(
   def: AgentDefinition,
   env: Record<string, string | undefined>,
+  profiles: string[] = [],
 ): Promise<void> {
   if (!def.services || Object.keys(def.services).length === 0) return;
 
   const agentName = def.name;
-  const allServiceNames = Object.keys(def.services);
+  let active: Record<string, ServiceDefinition> = {};
+  try {
+    active = activeServices(def.services, profiles);
+  } catch (err) {
+    exitWithError((err as Error).message, ExitCode.FAILURE);
+  }
+  const allServiceNames = Object.keys(active);
 
   // Partition: externally configured vs needs-Docker
   const externalServices: string[] = [];
@@ -593,22 +2117,53 @@ export async function startServices(
     return;
   }
 
-  // 9.1: Check docker availability (only needed for Docker-managed services)
-  await checkDockerAvailability();
+  // 9.1: Find Docker or Podman (only needed for engine-managed services)
+  const engine = await checkContainerEngine(agentName);
+
+  // Tear down other agents' persistent services left idle past their TTL
+  await reapIdleServices(agentName);
 
   emitProgress(agentName, `starting ${dockerServices.length}/${allServiceNames.length} services via Docker`);
 
+  // Reject bad depends_on, limit, port, and network declarations before
+  // touching Docker, and pick host ports for auto:<port> mappings
+  // The compose file has every service; the rest of the steps concern only
+  // those the enabled profiles start
+  let all = withAgentNetworks(def.services, def.networks);
+  let services: Record<string, ServiceDefinition> = {};
+  try {
+    all = await allocateAutoPorts(agentName, all);
+    serviceStartOrder(all);
+    for (const [name, svc] of Object.entries(all)) {
+      if (svc.resources) checkResourceLimits(name, svc.resources);
+      checkContainerOptions(name, svc);
+      if (svc.gpus) parseGpuRequest(name, svc.gpus);
+      checkReadyProbe(name, svc);
+      checkConnectionString(name, svc);
+    }
+    await ensureNetworks(engine, sharedNetworks(all));
+    await ensureVolumes(engine, agentName, namedVolumes(all));
+    services = Object.fromEntries(Object.entries(all).filter(([name]) => name in active));
+  } catch (err) {
+    exitWithError((err as Error).message, ExitCode.FAILURE);
+  }
+
+  // Fail clearly on a missing GPU runtime rather than with compose's device error
+  await checkGpuRuntime(engine, services);
+
   // 9.2: Materialize compose template (full template — Docker ignores services
   // that are already running, and external services won't have containers)
-  await materializeCompose(def.services, agentName, def.version, env);
+  await materializeCompose(all, agentName, def.version, env, profiles);
 
   // 9.9: Compute template hash for change detection
-  const composeContent = await Bun.file(composeFilePath(agentName)).text();
+  // The .env values count too, so a rotated password recreates services
+  const composeContent =
+    (await Bun.file(composeFilePath(agentName)).text()) + (await Bun.file(`${composeDir(agentName)}/.env`).text());
   const currentHash = await hashTemplate(composeContent);
   const previousHash = await readCurrentTemplateHash(agentName);
 
   // 9.8: Check for running services
-  const isRunning = await checkRunningServices(agentName);
+  const isRunning = await checkRunningServices(engine, agentName);
 
   if (isRunning) {
     if (previousHash === currentHash) {
@@ -617,32 +2172,166 @@ export async function startServices(
     } else {
       // Template changed — recreate
       emitProgress(agentName, "compose template changed, recreating services");
+      await pullServiceImages(engine, agentName, services);
+      await buildServices(engine, agentName, services);
       await composeDown(agentName);
       // Re-materialize (compose down may have cleaned up)
-      await materializeCompose(def.services, agentName, def.version, env);
-      await composeUp(agentName);
+      await materializeCompose(all, agentName, def.version, env, profiles);
+      await checkPortConflicts(engine, agentName, services);
+      emitProgress(agentName, `starting ${serviceStartOrder(services).join(", ")}`);
+      await composeUp(engine, agentName, profiles);
     }
   } else {
-    // 9.4: Start services
-    await composeUp(agentName);
+    // 9.4: Start services, failing clearly on taken ports; missing images are
+    // pulled concurrently first, and compose starts independent services in
+    // parallel
+    await checkPortConflicts(engine, agentName, services);
+    await pullServiceImages(engine, agentName, services);
+    await buildServices(engine, agentName, services);
+    emitProgress(agentName, `starting ${serviceStartOrder(services).join(", ")}`);
+    await composeUp(engine, agentName, profiles);
   }
 
   // Save template hash
   await writeTemplateHash(agentName, currentHash);
 
+  // From here on, services are reached on the host ports compose actually
+  // published, not the ones declared
+  services = await discoverPublishedPorts(engine, agentName, services);
+  await writeServiceState(engine, agentName, currentHash, services);
+
   // 9.5: Wait for health checks
-  const healthTimeout = (def as AgentDefinition & { serviceHealthTimeout?: number }).serviceHealthTimeout ?? 60;
-  await waitForHealthy(agentName, healthTimeout);
+  const healthTimeout = serviceStartTimeout(def);
+  await waitForHealthy(agentName, healthTimeout, active);
+  await waitForReady(agentName, engine.host, services, healthTimeout);
 
   // 9.6 / 9.7: Inject connection strings only for Docker-managed services
   // (external services already have their vars set)
   for (const serviceName of dockerServices) {
-    await injectServiceConnectionVars(agentName, serviceName, def.services[serviceName]);
+    await injectServiceConnectionVars(agentName, serviceName, services[serviceName]);
   }
 
+  if (def.serviceLifecycle === "session" && process.env.SFA_SESSION_ID) {
+    await holdSessionServices(process.env.SFA_SESSION_ID, agentName);
+  }
+  // Persistent services count as used from start to exit, so the idle TTL
+  // runs from the end of the last run
+  if ((def.serviceLifecycle ?? "persistent") === "persistent") recordServiceUse(agentName);
+
   emitProgress(agentName, "services ready");
 }
 
+// -------------------------------------------------------------------
+// Service logs and health during execute
+// -------------------------------------------------------------------
+
+/** Backs ctx.serviceLogs and ctx.onServiceUnhealthy for an agent's services. */
+export interface ServiceMonitor {
+  logs(name: string, tail?: number): Promise<string>;
+  /** Register fn; the returned function unregisters it */
+  onUnhealthy(fn: (service: string, state: string) => void): () => void;
+  stop(): void;
+}
+
+/**
+ * Create the monitor for an agent's services. `external` is whether every
+ * service was externally configured before startServices, so none run, and
+ * services the enabled `profiles` do not start are not watched.
+ * Polling starts with the first onUnhealthy callback and stops with stop().
+ */
+export function createServiceMonitor(def: AgentDefinition, external: boolean, profiles: string[] = []): ServiceMonitor {
+  const services = Object.fromEntries(
+    Object.entries(def.services ?? {}).filter(([, svc]) => startsWithProfiles(svc, profiles)),
+  );
+  const callbacks: Array<(service: string, state: string) => void> = [];
+  const reported = new Set<string>();
+  let timer: ReturnType<typeof setInterval> | null = null;
+
+  const oneShot = new Set<string>();
+  for (const svc of Object.values(services)) {
+    for (const [dep, cond] of Object.entries(svc.dependsOn ?? {})) {
+      if (cond === "service_completed_successfully") oneShot.add(dep);
+    }
+  }
+
+  const check = async () => {
+    const engine = await checkContainerEngine(def.name);
+    const proc = Bun.spawn([...engine.compose, "ps", "-a", "--format", "json"], {
+      cwd: composeDir(def.name),
+      stdout: "pipe",
+      stderr: "pipe",
+    });
+    const output = await new Response(proc.stdout).text();
+    if ((await proc.exited) !== 0) return;
+    type Container = { Service?: string; Health?: string; State?: string; ExitCode?: number };
+    const containers = new Map<string, Container>();
+    for (const line of output.trim().split("\n")) {
+      try {
+        const c = JSON.parse(line) as Container;
+        if (c.Service) containers.set(c.Service, c);
+      } catch {
+        // not a container line
+      }
+    }
+
+    for (const name of Object.keys(services).sort()) {
+      const c = containers.get(name);
+      let state = "";
+      if (!c) state = "removed";
+      else if (c.State === "exited" || c.State === "dead") {
+        if (!(oneShot.has(name) && c.ExitCode === 0)) state = `exited with code ${c.ExitCode}`;
+      } else if (c.State === "restarting") state = "restarting";
+      else if (c.Health === "unhealthy") state = "unhealthy";
+
+      if (!state) {
+        reported.delete(name);
+        continue;
+      }
+      if (reported.has(name)) continue;
+      reported.add(name);
+      emitProgress(def.name, `service ${name} ${state}`);
+      for (const fn of callbacks) fn(name, state);
+    }
+  };
+
+  return {
+    async logs(name: string, tail = 100): Promise<string> {
+      if (!(name in services)) {
+        throw new Error(`service ${name}: not declared (declared: ${Object.keys(services).sort().join(", ")})`);
+      }
+      if (external) {
+        throw new Error(`service ${name}: externally configured, so its logs are not available to the agent`);
+      }
+      const engine = await checkContainerEngine(def.name);
+      const proc = Bun.spawn(
+        [...engine.compose, "logs", "--no-color", "--tail", String(tail > 0 ? tail : 100), name],
+        { cwd: composeDir(def.name), stdout: "pipe", stderr: "pipe" },
+      );
+      const [out, err] = [await new Response(proc.stdout).text(), await new Response(proc.stderr).text()];
+      if ((await proc.exited) !== 0) {
+        throw new Error(`service ${name}: failed to read logs: ${err.trim()}`);
+      }
+      return out;
+    },
+    onUnhealthy(fn) {
+      if (external) return () => {};
+      callbacks.push(fn);
+      if (!timer) {
+        timer = setInterval(() => void check().catch(() => {}), 5000);
+        timer.unref?.();
+      }
+      return () => {
+        const i = callbacks.indexOf(fn);
+        if (i >= 0) callbacks.splice(i, 1);
+      };
+    },
+    stop() {
+      if (timer) clearInterval(timer);
+      timer = null;
+    },
+  };
+}
+
 /**
  * Stop services after agent execution (for ephemeral lifecycle).
  * Only tears down Docker-managed services. If all services were external,
@@ -653,8 +2342,13 @@ export async function stopServices(
   lifecycle: ServiceLifecycle = "persistent",
   services?: Record<string, ServiceDefinition>,
 ): Promise<void> {
-  // 9.11: Persistent — do nothing, leave running
-  if (lifecycle === "persistent") return;
+  // 9.11: Persistent — leave running, noting the use for the idle TTL;
+  // session services are left to endSessionServices
+  if (lifecycle === "persistent") {
+    recordServiceUse(agentName);
+    return;
+  }
+  if (lifecycle === "session") return;
 
   // If services info provided, check whether Docker was used at all
   if (services) {
@@ -665,4 +2359,81 @@ export async function stopServices(
   // 9.10: Ephemeral — tear down
   emitProgress(agentName, "stopping ephemeral services");
   await composeDown(agentName);
+  await removeServiceVolumes(agentName);
+}
+
+// -------------------------------------------------------------------
+// Idle teardown of persistent services
+// -------------------------------------------------------------------
+
+/** In an agent's services directory, when its persistent services were last used (RFC 3339). */
+const LAST_USED_FILE = "last-used";
+
+/** Note that an agent's persistent services are in use now. */
+function recordServiceUse(agentName: string): void {
+  const path = `${composeDir(agentName)}/${LAST_USED_FILE}`;
+  try {
+    writeFileSync(`${path}.tmp`, `${new Date().toISOString().replace(/\.\d+Z$/, "Z")}\n`, { mode: 0o600 });
+    renameSync(`${path}.tmp`, path);
+  } catch {
+    // Best effort: the services simply go unrecorded
+  }
+}
+
+/** Milliseconds in a Go-style duration such as "2h" or "1h30m", or null if invalid. */
+export function parseDuration(value: string): number | null {
+  const units: Record<string, number> = { ms: 1, s: 1000, m: 60_000, h: 3_600_000 };
+  if (!/^(\d+(\.\d+)?(ms|s|m|h))+$/.test(value)) return null;
+  let total = 0;
+  for (const [, n, , unit] of value.matchAll(/(\d+(\.\d+)?)(ms|s|m|h)/g)) total += Number(n) * units[unit];
+  return total;
+}
+
+/**
+ * How long an agent's persistent services may go unused, in milliseconds:
+ * services.idleTTL in its namespace, else in defaults. 0, for no limit, if
+ * unset or invalid.
+ */
+function serviceIdleTtl(config: SfaConfig, agentName: string): number {
+  for (const section of [config.agents?.[agentName]?.services, config.defaults?.services]) {
+    const value = (section as { idleTTL?: unknown } | undefined)?.idleTTL;
+    if (typeof value !== "string") continue;
+    const ttl = parseDuration(value);
+    if (!ttl) {
+      stderrLog.warn(`ignoring invalid services.idleTTL "${value}" for ${agentName} (want a duration such as 2h)`);
+      return 0;
+    }
+    return ttl;
+  }
+  return 0;
+}
+
+/**
+ * Tear down the persistent services of other agents left unused for longer
+ * than their idle TTL. Named volumes are kept, as with --services-down.
+ */
+async function reapIdleServices(agentName: string): Promise<void> {
+  const root = dataDir("services");
+  let names: string[];
+  try {
+    names = readdirSync(root, { withFileTypes: true })
+      .filter((e) => e.isDirectory() && e.name !== agentName)
+      .map((e) => e.name);
+  } catch {
+    return;
+  }
+  const config = await loadConfig();
+  const now = Date.now();
+  for (const name of names.sort()) {
+    let lastUsed: number;
+    try {
+      lastUsed = Date.parse(readFileSync(`${root}/${name}/${LAST_USED_FILE}`, "utf-8").trim());
+    } catch {
+      continue;
+    }
+    const ttl = serviceIdleTtl(config, name);
+    if (!ttl || Number.isNaN(lastUsed) || now - lastUsed < ttl) continue;
+    emitProgress(agentName, `stopping idle services of ${name} (unused for ${Math.round((now - lastUsed) / 60_000)}m)`);
+    await composeDown(name);
+  }
 }
@@ -1,3 +1,5 @@
+import type { LogEntry, LogQuery, LogSink } from "../logging";
+
 /**
  * Trust level declaration for the agent.
  * Indicates what system access the agent requires.
@@ -9,10 +11,27 @@ export type TrustLevel = "sandboxed" | "local" | "network" | "privileged";
  */
 export type ContextType = "finding" | "decision" | "artifact" | "reference" | "summary";
 
+/**
+ * Context search order: most recent first (the default), best match for
+ * `query` first by BM25, or closest in meaning to `query` first by
+ * embeddings (relevance without an embeddings provider).
+ */
+export type ContextSort = "newest" | "relevance" | "similarity";
+
 /**
  * Service lifecycle mode.
  */
-export type ServiceLifecycle = "persistent" | "ephemeral";
+/** "session" keeps services up until the session's root agent exits */
+export type ServiceLifecycle = "persistent" | "ephemeral" | "session";
+
+/**
+ * Condition a service waits for in a dependency, as in docker compose.
+ * `service_started` is the default.
+ */
+export type DependsOnCondition =
+  | "service_started"
+  | "service_healthy"
+  | "service_completed_successfully";
 
 /**
  * Output format for agent results.
@@ -39,7 +58,16 @@ export interface EnvDeclaration {
  * Docker compose service definition embedded in an agent.
  */
 export interface ServiceDefinition {
-  image: string;
+  /** Image to run; with `build`, the tag for the built image (default sfa-<agent>-<service>) */
+  image?: string;
+  /** Build the image from a Dockerfile instead of pulling it; a relative context is taken from the working directory */
+  build?: {
+    /** Default "." */
+    context?: string;
+    /** Relative to the context; default "Dockerfile" */
+    dockerfile?: string;
+    args?: Record<string, string>;
+  };
   ports?: string[];
   environment?: Record<string, string>;
   healthcheck?: {
@@ -51,8 +79,40 @@ export interface ServiceDefinition {
   };
   volumes?: string[];
   command?: string | string[];
-  /** Custom connection string template, e.g. "postgres://${USER}:${PASS}@${HOST}:${PORT}/db" */
+  /** SFA_SVC_<NAME>_URL template: ${host}, ${port}, and ${VAR} from the service's environment or the process, e.g. "postgres://${POSTGRES_USER}@${host}:${port}/db" */
   connectionString?: string;
+  /** Services to start first, by name, with the condition to wait for (e.g. migrations before the app) */
+  dependsOn?: Record<string, DependsOnCondition | "">;
+  /** Shared networks to join besides the agent's own (Docker network `sfa-<name>`) */
+  networks?: string[];
+  /**
+   * Readiness probe the SDK polls after compose up, for images without a
+   * healthcheck: tcp://host:port must accept a connection; http(s)://host:port/path
+   * must answer with `status` (any 2xx if omitted). ${host} and ${port} are the
+   * service's published host and port.
+   */
+  ready?: { url: string; status?: number };
+  /** Compose profiles; the service starts only when one is enabled with --services-profile or config services.profiles */
+  profiles?: string[];
+  /** GPUs to reserve: "all", a count such as "1", or "device=0,2" (needs the NVIDIA Container Toolkit) */
+  gpus?: string;
+  /** User to run as, a name or "uid[:gid]" */
+  user?: string;
+  /** Restart policy; default "no" */
+  restart?: "no" | "always" | "on-failure" | `on-failure:${number}` | "unless-stopped";
+  /** /etc/hosts entries, "host:ip" or "host:host-gateway" */
+  extraHosts?: string[];
+  /** Per-container limits by name, e.g. { nofile: 65536 } or { nproc: { soft: 4096, hard: 8192 } }; -1 is unlimited */
+  ulimits?: Record<string, number | { soft: number; hard: number }>;
+  /** Container limits, written to the compose file as deploy.resources.limits */
+  resources?: {
+    /** CPU cores, e.g. 1.5 */
+    cpus?: number | string;
+    /** e.g. "512m" or "2g" */
+    memory?: string;
+    /** Maximum number of processes */
+    pids?: number;
+  };
 }
 
 /**
@@ -101,12 +161,18 @@ export interface AgentDefinition {
   trustLevel?: TrustLevel;
   /** Whether context input is required */
   contextRequired?: boolean;
+  /** Constraints on the content of each type of the agent's context entries, checked as they are written */
+  contextSchema?: Partial<Record<ContextType, ContextSchema>>;
   /** Environment variable declarations */
   env?: EnvDeclaration[];
   /** Docker compose service definitions */
   services?: Record<string, ServiceDefinition>;
   /** Service lifecycle mode */
   serviceLifecycle?: ServiceLifecycle;
+  /** Seconds to wait for services to become healthy and ready (default 60; SFA_SERVICE_TIMEOUT overrides) */
+  serviceStartTimeout?: number;
+  /** Shared networks every service joins, so other agents' services can reach them */
+  networks?: string[];
   /** Custom CLI options */
   options?: AgentOption[];
   /** Usage examples for --help output */
@@ -117,6 +183,8 @@ export interface AgentDefinition {
   tools?: McpToolDefinition[];
   /** Context retention preference hint */
   contextRetention?: "none" | "session" | "permanent";
+  /** Receive execution log entries besides the log file and logging.sinks */
+  logSinks?: LogSink[];
   /** The agent's execute function */
   execute: (ctx: ExecuteContext) => Promise<AgentResult>;
 }
@@ -149,8 +217,38 @@ export interface ExecuteContext {
   invoke: (agentName: string, options?: InvokeOptions) => Promise<InvokeResult>;
   /** Write a context entry to the store */
   writeContext: (entry: WriteContextInput) => Promise<string>;
+  /** Add content to an entry; path as writeContext returned it, or relative to the store */
+  appendContext: (path: string, content: string) => Promise<void>;
+  /** Replace the type, tags, links, and content that entry sets */
+  updateContext: (path: string, entry: UpdateContextInput) => Promise<void>;
+  /** Replace an entry's tags */
+  replaceTags: (path: string, tags: string[]) => Promise<void>;
+  /** Read an entry by the path writeContext returned, a path relative to the store, or its ID (file name without .md) */
+  readContext: (pathOrId: string) => Promise<ContextEntry>;
+  /** The attachment name of the entry at path */
+  readAttachment: (path: string, name: string) => Promise<Uint8Array>;
   /** Search the context store */
   searchContext: (query: SearchContextInput) => Promise<ContextEntry[]>;
+  /** An entry and those within depth links of it, either way; a negative depth follows every link */
+  relatedContext: (path: string, depth: number) => Promise<ContextGraph>;
+  /** Sessions with entries in the store, most recently active first; searchContext({ sessionId }) lists one's entries */
+  listSessions: () => Promise<ContextSession[]>;
+  /** Entries matching query as they are written, until signal or the execution is aborted */
+  watchContext: (query: SearchContextInput, signal?: AbortSignal) => AsyncIterable<ContextEntry>;
+  /** Bundle context entries as a JSON document or gzipped tarball */
+  exportContext: (options?: ContextExportOptions) => Promise<Buffer>;
+  /** Write an export's entries, skipping paths already present; returns those written */
+  importContext: (data: Buffer | Uint8Array) => Promise<string[]>;
+  /** Execution log entries matching query, oldest first; this execution is logged as it ends */
+  queryLogs: (query?: LogQuery) => Promise<LogEntry[]>;
+  /** Record key in this execution's log entry meta; the SDK's own keys win */
+  setMeta: (key: string, value: unknown) => void;
+  /** Write a summary entry of the session's context entries, linking to each; returns its path */
+  summarizeSession: (options?: SummarizeSessionOptions) => Promise<string>;
+  /** A declared service's last `tail` log lines (default 100) */
+  serviceLogs: (name: string, tail?: number) => Promise<string>;
+  /** Register a callback for when a service turns unhealthy or exits during execute */
+  onServiceUnhealthy: (fn: (service: string, state: string) => void) => void;
 }
 
 /**
@@ -193,6 +291,20 @@ export interface AgentResult {
   error?: string;
 }
 
+/**
+ * A failure as reported in the JSON output's "error" field.
+ */
+export interface StructuredError {
+  /** Stable machine-readable identifier, e.g. "timeout" */
+  code: string;
+  /** Human-readable description (same as the stderr diagnostic) */
+  message: string;
+  /** Optional agent-supplied details */
+  details?: Record<string, unknown>;
+  /** Whether retrying the same invocation may succeed */
+  retryable: boolean;
+}
+
 /**
  * Input for writing a context entry.
  */
@@ -207,6 +319,41 @@ export interface WriteContextInput {
   content: string;
   /** Links to other context entries */
   links?: string[];
+  /** Further frontmatter keys, with JSON values; not the keys the store sets. updateContext merges them, null removing a key */
+  metadata?: Record<string, unknown>;
+  /** Files to keep with the entry, such as diffs, screenshots, or CSVs */
+  attachments?: ContextAttachment[];
+  /** Write even if the agent's session has an entry with this type, tags, and content (default false) */
+  allowDuplicate?: boolean;
+}
+
+/**
+ * A file kept with a context entry, such as a diff, a screenshot, or a CSV.
+ */
+export interface ContextAttachment {
+  /** File name: letters, digits, and . _ -, not ending in .md */
+  name: string;
+  /** To write, a file to copy; in results, where the attachment is kept */
+  path?: string;
+  /** To write, the content, if path is not set */
+  bytes?: Uint8Array;
+}
+
+/**
+ * Fields of a context entry to replace with updateContext. Fields left out
+ * are kept; the slug names the file and cannot be changed, and attachments
+ * are kept as they were written.
+ */
+export type UpdateContextInput = Partial<Omit<WriteContextInput, "slug" | "attachments">>;
+
+/**
+ * Constraints on the content of one type of context entry.
+ */
+export interface ContextSchema {
+  /** Headings the content must have, at any level, in any order */
+  sections?: string[];
+  /** Further checks; throw to refuse the entry, the message being the reason */
+  validate?: (content: string) => void;
 }
 
 /**
@@ -215,12 +362,64 @@ export interface WriteContextInput {
 export interface SearchContextInput {
   /** Filter by agent name */
   agent?: string;
+  /** Only entries of this session (default every session, and entries outside one) */
+  sessionId?: string;
   /** Filter by tags */
   tags?: string[];
+  /** Entries must have every one of tags, not just one (default false) */
+  allTags?: boolean;
   /** Filter by context type */
   type?: ContextType;
   /** Free-text search query */
   query?: string;
+  /** Only entries written at or after this time */
+  since?: Date | string;
+  /** Only entries written before this time */
+  until?: Date | string;
+  /** Result order; "relevance" needs a query (default "newest") */
+  sort?: ContextSort;
+  /** Results to skip, for paging (default 0) */
+  offset?: number;
+  /** At most this many results (default all) */
+  limit?: number;
+}
+
+/**
+ * A session with entries in the context store, as listSessions returns it.
+ */
+export interface ContextSession {
+  id: string;
+  entries: number;
+  /** Timestamp of its oldest entry */
+  first: string;
+  /** Timestamp of its newest entry */
+  last: string;
+  /** Agents that wrote its entries, sorted */
+  agents: string[];
+}
+
+/**
+ * Which context entries an export bundles, and how.
+ */
+export interface ContextExportOptions {
+  /** Only this agent's entries (default every agent's) */
+  agent?: string;
+  /** Only entries of this session (default all, including those outside a session) */
+  sessionId?: string;
+  /** "json" (default), or "tar" for a gzipped tarball of the entry files */
+  format?: "json" | "tar";
+}
+
+/**
+ * How summarizeSession summarizes the session's context entries.
+ */
+export interface SummarizeSessionOptions {
+  /** Agent given the entries on stdin, whose output is the summary (default config contextStore.summarizer, else a digest of the entries) */
+  summarizer?: string;
+  /** Default "session-summary" */
+  slug?: string;
+  /** Besides "session-summary", which every summary has */
+  tags?: string[];
 }
 
 /**
@@ -241,8 +440,45 @@ export interface ContextEntry {
   tags: string[];
   /** Links to other entries */
   links: string[];
+  /** Further frontmatter keys; absent if none */
+  metadata?: Record<string, unknown>;
+  /** Name and path of each attachment; read them with readAttachment. Absent if none */
+  attachments?: ContextAttachment[];
   /** Markdown content body */
   content: string;
+  /** Text around the first match of the query, matches in **bold**; absent without a query */
+  snippet?: string;
+}
+
+/**
+ * An entry and the entries linked to it, following links both ways, as
+ * relatedContext returns it.
+ */
+export interface ContextGraph {
+  /** The entry's path relative to the store root */
+  root: string;
+  /** The root first, then by distance and path */
+  nodes: ContextNode[];
+  /** Links between nodes, and to entries the store lacks */
+  edges: ContextEdge[];
+}
+
+/**
+ * An entry in a ContextGraph.
+ */
+export interface ContextNode extends ContextEntry {
+  /** Relative to the store root, as links name it */
+  path: string;
+  /** Links from the root, in either direction */
+  depth: number;
+}
+
+/**
+ * A link in a ContextGraph: the entry at `from` links to `to`.
+ */
+export interface ContextEdge {
+  from: string;
+  to: string;
 }
 
 /**
@@ -1,13 +1,34 @@
 import { test, expect, describe, beforeEach, afterEach } from "bun:test";
 import { tmpdir } from "node:os";
 import { join } from "node:path";
-import { mkdirSync, rmSync, readFileSync } from "node:fs";
+import { mkdirSync, rmSync, readFileSync, existsSync, readdirSync, writeFileSync } from "node:fs";
 import {
   resolveContextStorePath,
   writeContext,
   searchContext,
   updateContext,
   addContextLink,
+  resolveContextRetention,
+  pruneContextStore,
+  enforceContextQuota,
+  exportContext,
+  importContext,
+  resolveContextStoreUrl,
+  appendContextContent,
+  updateContextDocument,
+  replaceContextTags,
+  editContextEntry,
+  openContextStore,
+  type ContextDocument,
+  relatedContext,
+  readContext,
+  ContextEntryError,
+  validateContextEntry,
+  validateContextTags,
+  validateContextUpdate,
+  validateContextMetadata,
+  listSessions,
+  readContextAttachment,
 } from "../../sdk/typescript/@sfa/sdk/context";
 import type { SfaConfig } from "../../sdk/typescript/@sfa/sdk/config";
 
@@ -311,3 +332,419 @@ describe("addContextLink", () => {
     }).toThrow(/not found/);
   });
 });
+
+describe("searchContext ranking", () => {
+  test("sort relevance ranks entries by the query's terms", () => {
+    writeContext({ type: "finding", slug: "once", content: "a token mention" }, "my-agent", undefined, tmpDir);
+    const often = "token token: the token expired";
+    writeContext({ type: "finding", slug: "often", content: often }, "my-agent", undefined, tmpDir);
+    writeContext({ type: "finding", slug: "none", content: "unrelated" }, "my-agent", undefined, tmpDir);
+
+    const ranked = searchContext({ query: "token", sort: "relevance" }, tmpDir);
+    expect(ranked.map((e) => e.content.trim())).toEqual([often, "a token mention"]);
+    expect(searchContext({ query: "token", sort: "relevance", limit: 1 }, tmpDir)).toHaveLength(1);
+    expect(() => searchContext({ sort: "oldest" as "newest" }, tmpDir)).toThrow('Unknown context sort "oldest"');
+  });
+});
+
+async function captureStderr(fn: () => unknown): Promise<string> {
+  const write = process.stderr.write;
+  let out = "";
+  process.stderr.write = ((chunk: string | Uint8Array) => {
+    out += String(chunk);
+    return true;
+  }) as typeof process.stderr.write;
+  try {
+    await fn();
+  } finally {
+    process.stderr.write = write;
+  }
+  return out;
+}
+
+describe("context retention", () => {
+  test("resolveContextRetention parses periods and warns about invalid ones", async () => {
+    let retention: Record<string, number> = {};
+    const stderr = await captureStderr(() => {
+      retention = resolveContextRetention({
+        contextStore: { retention: { finding: "30d", artifact: "1h30m", decision: "forever", summary: "soon" } },
+      });
+    });
+    expect(retention).toEqual({ finding: 30 * 86_400_000, artifact: 5_400_000, decision: 0 });
+    expect(stderr).toContain('ignoring contextStore.retention.summary: invalid retention "soon"');
+  });
+
+  test("pruneContextStore removes entries past their type's retention", () => {
+    const finding = writeContext({ type: "finding", slug: "old", content: "x" }, "my-agent", "s1", tmpDir);
+    const decision = writeContext({ type: "decision", slug: "kept", content: "y" }, "my-agent", "s1", tmpDir);
+    const artifact = writeContext({ type: "artifact", slug: "art", content: "z" }, "my-agent", undefined, tmpDir);
+    const later = new Date(Date.now() + 2 * 86_400_000);
+
+    const removed = pruneContextStore(tmpDir, { finding: 86_400_000, default: 3 * 86_400_000 }, later);
+    expect(removed).toEqual([finding]);
+    expect(existsSync(decision) && existsSync(artifact)).toBe(true);
+    expect(searchContext({ type: "finding" }, tmpDir)).toHaveLength(0);
+
+    expect(pruneContextStore(tmpDir, {}, new Date(Date.now() + 365 * 86_400_000))).toEqual([]);
+  });
+});
+
+/** The entry files under dir, relative to it. */
+function entryFiles(dir: string): string[] {
+  return (readdirSync(dir, { recursive: true }) as string[]).filter((f) => f.endsWith(".md")).sort();
+}
+
+describe("enforceContextQuota", () => {
+  test("evicts the lowest priority entries first", () => {
+    const reference = writeContext({ type: "reference", slug: "ref", content: "a" }, "my-agent", undefined, tmpDir);
+    writeContext({ type: "decision", slug: "dec", content: "we chose" }, "my-agent", undefined, tmpDir);
+    writeContext({ type: "finding", slug: "find", content: "we found" }, "my-agent", undefined, tmpDir);
+
+    enforceContextQuota(tmpDir, "my-agent", undefined, 10, { agent: { maxEntries: 3 } });
+    expect(existsSync(reference)).toBe(false);
+    expect(entryFiles(tmpDir)).toHaveLength(2);
+    expect(entryFiles(tmpDir).some((f) => f.endsWith("-dec.md"))).toBe(true);
+  });
+
+  test("limits a session apart from the agent", () => {
+    writeContext({ type: "finding", slug: "outside", content: "x" }, "my-agent", undefined, tmpDir);
+    const inSession = writeContext({ type: "finding", slug: "inside", content: "x" }, "my-agent", "s1", tmpDir);
+    enforceContextQuota(tmpDir, "my-agent", "s1", 10, { session: { maxEntries: 1 } });
+    expect(existsSync(inSession)).toBe(false);
+    expect(entryFiles(tmpDir)).toHaveLength(1);
+  });
+
+  test("refuses the write with onExceeded reject, or an entry over maxBytes", () => {
+    writeContext({ type: "finding", slug: "one", content: "x" }, "my-agent", undefined, tmpDir);
+    expect(() =>
+      enforceContextQuota(tmpDir, "my-agent", undefined, 10, { agent: { maxEntries: 1 }, onExceeded: "reject" }),
+    ).toThrow("context store quota exceeded: agent my-agent holds 1 entries");
+    expect(() => enforceContextQuota(tmpDir, "my-agent", undefined, 2048, { agent: { maxBytes: 1024 } })).toThrow(
+      "context store quota exceeded: agent my-agent: entry of 2048 bytes is larger than maxBytes 1024",
+    );
+    expect(entryFiles(tmpDir)).toHaveLength(1);
+  });
+
+  test("writeContext applies the quota", () => {
+    const quota = { agent: { maxEntries: 2 } };
+    for (const slug of ["a", "b", "c"]) {
+      writeContext({ type: "finding", slug, content: slug }, "my-agent", undefined, tmpDir, undefined, quota);
+    }
+    expect(entryFiles(tmpDir)).toHaveLength(2);
+  });
+});
+
+describe("exportContext and importContext", () => {
+  for (const format of ["json", "tar"] as const) {
+    test(`round-trips entries as ${format}`, () => {
+      writeContext({ type: "finding", tags: ["bug"], slug: "one", content: "First" }, "my-agent", "s1", tmpDir);
+      writeContext({ type: "decision", slug: "two", content: "Second" }, "other-agent", undefined, tmpDir);
+
+      const bundle = exportContext(tmpDir, { agent: "my-agent", format });
+      const dest = join(tmpDir, "imported");
+      const imported = importContext(dest, bundle);
+      expect(imported).toHaveLength(1);
+      expect(imported[0]).toStartWith(join(dest, "my-agent", "s1"));
+      const [entry] = searchContext({ tags: ["bug"] }, dest);
+      expect(entry.content.trim()).toBe("First");
+
+      expect(importContext(dest, bundle)).toEqual([]);
+    });
+  }
+
+  test("refuses a bundle with paths outside the store", () => {
+    const bundle = { version: 1, entries: [{ path: "../escape.md", content: "---\n---\nx" }] };
+    expect(() => importContext(tmpDir, Buffer.from(JSON.stringify(bundle)))).toThrow(
+      'invalid context export: "../escape.md" is not a context entry',
+    );
+    expect(() => importContext(tmpDir, Buffer.from('{"version":99}'))).toThrow("unsupported context export version 99");
+    expect(() => exportContext(tmpDir, { format: "zip" as "json" })).toThrow('Unknown context export format "zip"');
+  });
+});
+
+describe("resolveContextStoreUrl", () => {
+  test("resolveContextStoreUrl prefers SFA_CONTEXT_STORE_URL, then a local SFA_CONTEXT_STORE", () => {
+    delete process.env.SFA_CONTEXT_STORE_URL;
+    delete process.env.SFA_CONTEXT_STORE;
+    const config: SfaConfig = { contextStore: { url: "s3://bucket/context" } };
+    expect(resolveContextStoreUrl(config)).toBe("s3://bucket/context");
+    expect(resolveContextStoreUrl({})).toBeUndefined();
+
+    process.env.SFA_CONTEXT_STORE = "/local/context";
+    expect(resolveContextStoreUrl(config)).toBeUndefined();
+
+    process.env.SFA_CONTEXT_STORE_URL = "https://context.example.com";
+    expect(resolveContextStoreUrl(config)).toBe("https://context.example.com");
+  });
+});
+
+function doc(overrides: Partial<ContextDocument> = {}): ContextDocument {
+  return {
+    agent: "my-agent",
+    timestamp: "2026-02-21T14:30:22Z",
+    type: "finding",
+    tags: ["bug"],
+    links: [],
+    content: "Found a bug",
+    changelog: [],
+    ...overrides,
+  };
+}
+
+describe("context document edits", () => {
+  test("appendContextContent adds a paragraph", () => {
+    const d = doc();
+    expect(appendContextContent(d, "  More detail  ")).toBe("Appended to the content");
+    expect(d.content).toBe("Found a bug\n\nMore detail");
+    expect(appendContextContent(d, "   ")).toBe("");
+  });
+
+  test("updateContextDocument reports only the fields that changed", () => {
+    const d = doc({ metadata: { severity: "low", owner: "me" } });
+    expect(updateContextDocument(d, { type: "finding", tags: ["bug"] })).toBe("");
+    expect(
+      updateContextDocument(d, {
+        type: "decision",
+        links: ["my-agent/other.md"],
+        metadata: { severity: "high", owner: null },
+        content: "Decided",
+      }),
+    ).toBe("Updated the type, links, metadata, content");
+    expect(d).toMatchObject({ type: "decision", links: ["my-agent/other.md"], metadata: { severity: "high" } });
+    expect(updateContextDocument(d, { links: [] })).toBe("Updated the links");
+  });
+
+  test("replaceContextTags describes the new tags", () => {
+    const d = doc();
+    expect(replaceContextTags(d, ["bug"])).toBe("");
+    expect(replaceContextTags(d, ["fixed", "auth"])).toBe("Replaced the tags with [fixed, auth]");
+    expect(d.tags).toEqual(["fixed", "auth"]);
+  });
+
+  test("editContextEntry records the change in the changelog", async () => {
+    const store = openContextStore({ contextStore: { path: tmpDir } });
+    const input = { type: "finding" as const, tags: ["bug"], slug: "edit-me", content: "Found" };
+    const filePath = await store.write(input, "my-agent", undefined);
+
+    await editContextEntry(store, filePath, "fixer", (d) => replaceContextTags(d, ["fixed"]));
+    await editContextEntry(store, filePath, "fixer", (d) => replaceContextTags(d, ["fixed"]));
+    const text = readFileSync(filePath, "utf-8");
+    expect(text).toMatch(/## Changelog\n\n- \S+ \[fixer\]: Replaced the tags with \[fixed\]\n$/);
+    expect((await store.read(filePath)).tags).toEqual(["fixed"]);
+  });
+});
+
+describe("relatedContext", () => {
+  test("relatedContext follows links and backlinks to the depth given", async () => {
+    const store = openContextStore({ contextStore: { path: tmpDir } });
+    const write = (slug: string, links: string[] = []) =>
+      store.write({ type: "finding", slug, content: slug, links }, "my-agent", undefined);
+    const c = await write("c");
+    const b = await write("b", [store.entryPath(c)]);
+    const a = await write("a", [store.entryPath(b), "my-agent/missing.md"]);
+    const d = await write("d", [store.entryPath(a)]);
+
+    const near = await relatedContext(store, b, 1);
+    expect(near.root).toBe(store.entryPath(b));
+    expect(near.nodes[0]).toMatchObject({ path: store.entryPath(b), depth: 0 });
+    expect(near.nodes.slice(1).map((n) => n.path)).toEqual([store.entryPath(a), store.entryPath(c)].sort());
+    expect(near.nodes.slice(1).every((n) => n.depth === 1)).toBe(true);
+    expect(near.edges).toContainEqual({ from: store.entryPath(a), to: "my-agent/missing.md" });
+
+    const all = await relatedContext(store, b, -1);
+    expect(all.nodes.find((n) => n.path === store.entryPath(d))?.depth).toBe(2);
+    await expect(relatedContext(store, "my-agent/missing.md", 1)).rejects.toThrow("Context entry not found");
+  });
+});
+
+describe("readContext", () => {
+  test("reads an entry by path or ID", () => {
+    const filePath = writeContext({ type: "finding", slug: "leak", content: "The leak" }, "my-agent", "s1", tmpDir);
+    const id = filePath.slice(filePath.lastIndexOf("/") + 1, -".md".length);
+
+    expect(readContext(filePath, tmpDir).content.trim()).toBe("The leak");
+    expect(readContext(`my-agent/s1/${id}.md`, tmpDir).filePath).toBe(filePath);
+    expect(readContext(id, tmpDir).filePath).toBe(filePath);
+
+    expect(() => readContext("nope", tmpDir)).toThrow("Context entry not found: nope");
+    expect(() => readContext("../../etc/passwd.md", tmpDir)).toThrow("is not an entry of the context store");
+  });
+
+  test("refuses an ambiguous ID", () => {
+    const first = writeContext({ type: "finding", slug: "same", content: "a" }, "agent-a", undefined, tmpDir);
+    const id = first.slice(first.lastIndexOf("/") + 1, -".md".length);
+    mkdirSync(join(tmpDir, "agent-b"));
+    writeFileSync(join(tmpDir, "agent-b", `${id}.md`), readFileSync(first, "utf-8"));
+    expect(() => readContext(id, tmpDir)).toThrow(`Context entry ID ${id} is ambiguous`);
+  });
+
+  // The Go SDK's tests read the same file (contextfrontmatter_test.go).
+  test("reads frontmatter as the Go SDK does", () => {
+    const fixtures = join(import.meta.dir, "../../sdk/golang/sfa/testdata");
+    const want = JSON.parse(readFileSync(join(fixtures, "context-frontmatter.json"), "utf-8"));
+    const filePath = join(tmpDir, "code-reviewer", "0042", "20260221T143022-leak.md");
+    mkdirSync(join(tmpDir, "code-reviewer", "0042"), { recursive: true });
+    writeFileSync(filePath, readFileSync(join(fixtures, "context-frontmatter.md")));
+
+    const read = () => {
+      const { agent, sessionId, timestamp, type, tags, links, metadata, content } = readContext(filePath, tmpDir);
+      return { agent, sessionId, timestamp, type, tags, links, metadata, content };
+    };
+    expect(read()).toEqual(want);
+
+    addContextLink(filePath, join(tmpDir, "code-reviewer", "other.md"), tmpDir);
+    expect(read()).toEqual({ ...want, links: [...want.links, "code-reviewer/other.md"] });
+  });
+});
+
+/** The error fn throws, or undefined if it does not. */
+function thrown(fn: () => unknown): unknown {
+  try {
+    fn();
+  } catch (err) {
+    return err;
+  }
+  return undefined;
+}
+
+describe("context entry validation", () => {
+  test("accepts a well-formed entry", () => {
+    validateContextEntry({
+      type: "finding",
+      slug: "auth-bug",
+      tags: ["security", "owasp:a01", "area/auth"],
+      content: "Token not checked",
+    });
+  });
+
+  test("names the field an entry is refused for", () => {
+    const cases: [Parameters<typeof validateContextEntry>[0], string][] = [
+      [{ type: "finding", slug: "../escape", content: "x" }, "slug"],
+      [{ type: "rumor" as "finding", slug: "s", content: "x" }, "type"],
+      [{ type: "finding", slug: "s", tags: ["Has Space"], content: "x" }, "tags"],
+      [{ type: "finding", slug: "s", content: "  \n" }, "content"],
+    ];
+    for (const [input, field] of cases) {
+      const err = thrown(() => validateContextEntry(input));
+      expect(err).toBeInstanceOf(ContextEntryError);
+      expect((err as ContextEntryError).field).toBe(field);
+      expect((err as Error).message).toStartWith(`invalid context entry: ${field} `);
+    }
+  });
+
+  test("checks content against the schema for its type", () => {
+    const schemas = {
+      decision: {
+        sections: ["Context", "Decision"],
+        validate: (content: string) => {
+          if (!content.includes("ADR-")) throw new Error("needs an ADR number");
+        },
+      },
+    };
+    const input = { type: "decision" as const, slug: "use-sqlite", content: "# Context\n\nWhy\n" };
+    expect(() => validateContextEntry(input, schemas)).toThrow('has no "Decision" section');
+    expect(() => validateContextEntry({ ...input, content: "## context\n\n## DECISION\n" }, schemas)).toThrow(
+      "needs an ADR number",
+    );
+    validateContextEntry({ ...input, content: "## Context\n\n## Decision\n\nADR-7\n" }, schemas);
+    validateContextEntry({ ...input, type: "finding" }, schemas);
+  });
+
+  test("validates tags and updates on their own", () => {
+    expect(() => validateContextTags(["ok", "-leading"])).toThrow(ContextEntryError);
+    expect(() => validateContextTags(["x".repeat(65)])).toThrow(ContextEntryError);
+    expect(() => validateContextUpdate({ type: "rumor" as "finding" })).toThrow(ContextEntryError);
+    validateContextUpdate({ tags: ["fixed"] });
+  });
+});
+
+describe("context metadata", () => {
+  test("accepts JSON-like metadata", () => {
+    validateContextMetadata({ severity: "high", score: 7.5, nested: { ok: [1, 2] } });
+    validateContextEntry({ type: "finding", slug: "s", metadata: { severity: "high" }, content: "x" });
+  });
+
+  test("refuses reserved or empty keys and values JSON cannot hold", () => {
+    expect(() => validateContextMetadata({ type: "decision" })).toThrow(ContextEntryError);
+    expect(() => validateContextMetadata({ "": 1 })).toThrow(ContextEntryError);
+    const cyclic: Record<string, unknown> = {};
+    cyclic.self = cyclic;
+    expect(() => validateContextMetadata({ cyclic })).toThrow('"cyclic" must be JSON-like');
+  });
+});
+
+describe("listSessions", () => {
+  test("listSessions summarizes each session, the most recent first", async () => {
+    const store = openContextStore({ contextStore: { path: tmpDir } });
+    await store.write({ type: "finding", slug: "a", content: "a" }, "agent-b", "s1");
+    await store.write({ type: "finding", slug: "b", content: "b" }, "agent-a", "s1");
+    await store.write({ type: "finding", slug: "c", content: "c" }, "agent-a", undefined);
+    await Bun.sleep(5);
+    await store.write({ type: "finding", slug: "d", content: "d" }, "agent-a", "s2");
+
+    const sessions = await listSessions(store);
+    expect(sessions.map((s) => s.id)).toEqual(["s2", "s1"]);
+    expect(sessions[1]).toMatchObject({ entries: 2, agents: ["agent-a", "agent-b"] });
+    expect(sessions[1].first <= sessions[1].last).toBe(true);
+  });
+});
+
+describe("searchContext paging", () => {
+  test("offset skips results after ranking", () => {
+    writeContext({ type: "finding", slug: "once", content: "a token mention" }, "my-agent", undefined, tmpDir);
+    writeContext({ type: "finding", slug: "often", content: "token token token" }, "my-agent", undefined, tmpDir);
+
+    const rest = searchContext({ query: "token", sort: "relevance", offset: 1 }, tmpDir);
+    expect(rest.map((e) => e.content.trim())).toEqual(["a token mention"]);
+    expect(searchContext({ query: "token", sort: "relevance", offset: 2 }, tmpDir)).toEqual([]);
+  });
+});
+
+describe("encrypted context store", () => {
+  test("an encrypted store keeps bodies sealed on disk and searchable", async () => {
+    delete process.env.SFA_CONTEXT_STORE_URL;
+    delete process.env.SFA_CONTEXT_STORE;
+    process.env.SFA_CONTEXT_KEY = Buffer.alloc(32, 7).toString("base64");
+    const store = openContextStore({ contextStore: { path: tmpDir, encrypt: true } });
+    const input = { type: "finding" as const, tags: ["secret"], slug: "s", content: "the password is hunter2" };
+    const filePath = await store.write(input, "my-agent", undefined);
+
+    expect(readFileSync(filePath, "utf-8")).not.toContain("hunter2");
+    expect(readFileSync(filePath, "utf-8")).toContain("sfa-enc:v1:");
+    const [found] = await store.search({ query: "hunter2" });
+    expect(found.content.trim()).toBe("the password is hunter2");
+    expect((await store.search({ query: "swordfish" })).length).toBe(0);
+
+    process.env.SFA_CONTEXT_KEY = Buffer.alloc(32, 8).toString("base64");
+    await expect(store.read(filePath)).rejects.toThrow("encrypted with a different context key");
+  });
+});
+
+describe("context attachments", () => {
+  test("stores attachments beside the entry and reads them back", () => {
+    const filePath = writeContext(
+      {
+        type: "artifact",
+        slug: "patch",
+        content: "The fix",
+        attachments: [{ name: "fix.diff", bytes: new TextEncoder().encode("+fixed\n") }],
+      },
+      "my-agent",
+      "s1",
+      tmpDir,
+    );
+
+    expect(readContext(filePath, tmpDir).attachments?.map((a) => a.name)).toEqual(["fix.diff"]);
+    expect(new TextDecoder().decode(readContextAttachment(filePath, "fix.diff", tmpDir))).toBe("+fixed\n");
+    expect(() => readContextAttachment(filePath, "../secret", tmpDir)).toThrow("Context attachment not found");
+  });
+
+  test("refuses attachments named like entries or without content", () => {
+    const refused = [{ name: "notes.md", bytes: new Uint8Array() }, { name: "a.txt" }];
+    for (const attachment of refused) {
+      expect(() =>
+        validateContextEntry({ type: "finding", slug: "s", attachments: [attachment], content: "x" }),
+      ).toThrow("invalid context entry: attachments");
+    }
+  });
+});
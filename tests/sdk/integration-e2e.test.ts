@@ -242,6 +242,37 @@ defineAgent({
     expect(output.result.subagentExitCode).toBe(0);
   });
 
+  test("failed execution writes a structured error in JSON mode", async () => {
+    const agentPath = join(tmpDir, "failing-agent.ts");
+    const code = `
+import { defineAgent } from "${join(process.cwd(), "sdk/typescript/@sfa/sdk/index")}";
+
+defineAgent({
+  name: "failing-agent",
+  version: "1.0.0",
+  description: "Agent that always fails",
+  execute: async () => {
+    throw new Error("boom");
+  },
+});
+`;
+    writeFileSync(agentPath, code);
+
+    const proc = Bun.spawn(["bun", agentPath, "--output-format", "json"], {
+      stdout: "pipe",
+      stderr: "pipe",
+      env: { ...process.env, SFA_NO_LOG: "1" },
+    });
+
+    const stdout = await new Response(proc.stdout).text();
+    const exitCode = await proc.exited;
+
+    expect(exitCode).toBe(1);
+    const output = JSON.parse(stdout.trim());
+    expect(output.result).toBeNull();
+    expect(output.error).toEqual({ code: "execution_failed", message: "boom", retryable: false });
+  });
+
   test("agent exits with code 2 for unknown flags", async () => {
     const agentPath = createE2EAgent();
 
@@ -1,12 +1,27 @@
 import { test, expect, describe, beforeEach, afterEach } from "bun:test";
 import { tmpdir } from "node:os";
 import { join } from "node:path";
-import { mkdirSync, rmSync, readFileSync, writeFileSync, readdirSync, statSync } from "node:fs";
+import { mkdirSync, rmSync, readFileSync, writeFileSync, readdirSync, statSync, existsSync } from "node:fs";
+import { gzipSync } from "node:zlib";
+import { createServer } from "node:http";
+import type { AddressInfo } from "node:net";
 import {
   resolveLoggingConfig,
   createLogEntry,
   writeLogEntry,
+  queryLogs,
+  LOG_SCHEMA_VERSION,
+  setLogMeta,
+  flushLogs,
+  aggregateLogs,
+  upgradeLogEntry,
+  attachLogSinks,
+  closeLogSinks,
+  createInvocationLog,
+  writeInvocationReport,
+  readInvocationReport,
 } from "../../sdk/typescript/@sfa/sdk/logging";
+import type { LogEntry, LoggingConfig, LogSink } from "../../sdk/typescript/@sfa/sdk/logging";
 import type { SfaConfig } from "../../sdk/typescript/@sfa/sdk/config";
 
 let tmpDir: string;
@@ -304,3 +319,353 @@ describe("writeLogEntry", () => {
     expect(() => writeLogEntry(entry, config)).not.toThrow();
   });
 });
+
+function fileConfig(filePath: string, extra: Partial<LoggingConfig> = {}): LoggingConfig {
+  return { filePath, suppressed: false, maxSizeBytes: 50 * 1024 * 1024, retainCount: 5, ...extra };
+}
+
+function logEntry(fields: Partial<LogEntry>): LogEntry {
+  return {
+    schemaVersion: LOG_SCHEMA_VERSION,
+    timestamp: "2026-02-21T10:00:00.000Z",
+    agent: "agent",
+    version: "1.0.0",
+    exitCode: 0,
+    durationMs: 10,
+    depth: 0,
+    callChain: [],
+    inputSummary: "",
+    outputSummary: "",
+    sessionId: "s",
+    ...fields,
+  };
+}
+
+describe("queryLogs", () => {
+  test("filters by agent, session, exit code, and time, oldest first", () => {
+    const logFile = join(tmpDir, "executions.jsonl");
+    const lines = [
+      logEntry({ agent: "a", sessionId: "s1", timestamp: "2026-02-21T10:00:02.000Z" }),
+      logEntry({ agent: "b", sessionId: "s1", timestamp: "2026-02-21T10:00:01.000Z", exitCode: 1 }),
+      logEntry({ agent: "a", sessionId: "s2", timestamp: "2026-02-20T10:00:00.000Z" }),
+    ].map((e) => JSON.stringify(e));
+    writeFileSync(logFile, [...lines, "not json", "[1]"].join("\n") + "\n");
+    const config = fileConfig(logFile);
+
+    expect(queryLogs(config).map((e) => e.agent)).toEqual(["a", "b", "a"]);
+    expect(queryLogs(config, { agent: "a" }).map((e) => e.sessionId)).toEqual(["s2", "s1"]);
+    expect(queryLogs(config, { sessionId: "s1" })).toHaveLength(2);
+    expect(queryLogs(config, { exitCode: 1 }).map((e) => e.agent)).toEqual(["b"]);
+    expect(queryLogs(config, { since: "2026-02-21T00:00:00Z" })).toHaveLength(2);
+    expect(queryLogs(config, { limit: 1 }).map((e) => e.agent)).toEqual(["a"]);
+  });
+
+  test("a missing log has no entries", () => {
+    expect(queryLogs(fileConfig(join(tmpDir, "missing", "executions.jsonl")))).toEqual([]);
+  });
+});
+
+describe("logging.perAgent", () => {
+  test("reads an agent's own file with logging.perAgent", async () => {
+    const logFile = join(tmpDir, "executions.jsonl");
+    const shared = fileConfig(logFile, { perAgent: true });
+    await writeLogEntry(logEntry({ agent: "reviewer" }), shared);
+    expect(existsSync(join(tmpDir, "reviewer", "executions.jsonl"))).toBe(true);
+    expect(queryLogs(shared, { agent: "reviewer" })).toHaveLength(1);
+
+    const only = fileConfig(join(tmpDir, "only", "executions.jsonl"), { perAgent: true, perAgentOnly: true });
+    await writeLogEntry(logEntry({ agent: "writer" }), only);
+    expect(existsSync(only.filePath)).toBe(false);
+    expect(queryLogs(only).map((e) => e.agent)).toEqual(["writer"]);
+  });
+});
+
+describe("rotated logs", () => {
+  test("reads rotated and gzipped files of either SDK's naming", () => {
+    const logFile = join(tmpDir, "executions.jsonl");
+    const line = (agent: string, timestamp: string) => JSON.stringify(logEntry({ agent, timestamp })) + "\n";
+    const rotated = gzipSync(line("ts-rotated", "2026-02-19T10:00:00Z"));
+    writeFileSync(join(tmpDir, "executions-20260219T100000.jsonl.gz"), rotated);
+    writeFileSync(join(tmpDir, "executions.20260220T100000.jsonl"), line("go-rotated", "2026-02-20T10:00:00Z"));
+    writeFileSync(logFile, line("current", "2026-02-21T10:00:00Z"));
+
+    expect(queryLogs(fileConfig(logFile)).map((e) => e.agent)).toEqual(["ts-rotated", "go-rotated", "current"]);
+  });
+});
+
+/** Capture what stderrLog writes while fn runs. */
+async function captureStderr(fn: () => unknown): Promise<string> {
+  const write = process.stderr.write;
+  let out = "";
+  process.stderr.write = ((chunk: string | Uint8Array) => {
+    out += String(chunk);
+    return true;
+  }) as typeof process.stderr.write;
+  try {
+    await fn();
+  } finally {
+    process.stderr.write = write;
+  }
+  return out;
+}
+
+describe("logging.sinks", () => {
+  test("skips logging.sinks entries that cannot be used", async () => {
+    delete process.env.SFA_OTEL_ENDPOINT;
+    let config: LoggingConfig | undefined;
+    const stderr = await captureStderr(() => {
+      config = resolveLoggingConfig(
+        {
+          logging: {
+            sinks: [
+              { type: "otlp" },
+              { type: "kafka" },
+              { type: "syslog", address: "ftp://host" },
+              { type: "syslog", address: "udp://127.0.0.1:514", facility: "local3" },
+            ],
+          },
+        },
+        false,
+      );
+    });
+    expect(config!.sinks!.map((s) => s.name)).toEqual(["syslog at 127.0.0.1:514"]);
+    expect(stderr).toContain("ignoring logging.sinks[0]: an otlp sink needs an endpoint");
+    expect(stderr).toContain('ignoring logging.sinks[1]: unknown type "kafka"');
+    expect(stderr).toContain('ignoring logging.sinks[2]: invalid address "ftp://host"');
+  });
+
+  test("ships entries to an otlp endpoint", async () => {
+    let path = "";
+    let body: any;
+    const server = createServer((req, res) => {
+      path = req.url ?? "";
+      let data = "";
+      req.on("data", (chunk) => (data += chunk));
+      req.on("end", () => {
+        body = JSON.parse(data);
+        res.end("{}");
+      });
+    });
+    await new Promise<void>((resolve) => server.listen(0, "127.0.0.1", resolve));
+    try {
+      const { port } = server.address() as AddressInfo;
+      const sinks = [{ type: "otlp", endpoint: `http://127.0.0.1:${port}` }];
+      const config = resolveLoggingConfig({ logging: { file: join(tmpDir, "otlp.jsonl"), sinks } }, false);
+      await writeLogEntry(logEntry({ agent: "shipped", exitCode: 1 }), config);
+    } finally {
+      server.close();
+    }
+
+    expect(path).toBe("/v1/logs");
+    const record = body.resourceLogs[0].scopeLogs[0].logRecords[0];
+    expect(record.severityText).toBe("ERROR");
+    expect(record.attributes).toContainEqual({ key: "agent", value: { stringValue: "shipped" } });
+    expect(record.attributes).toContainEqual({ key: "exitCode", value: { intValue: "1" } });
+  });
+});
+
+describe("secret masking", () => {
+  test("createLogEntry masks secrets in nested metadata", () => {
+    const entry = createLogEntry({
+      agent: "a", version: "1.0.0", exitCode: 0, startTime: Date.now(), depth: 0, callChain: [],
+      sessionId: "s", input: "key=hunter2", output: "", meta: { request: { headers: ["Bearer hunter2"] }, count: 2 },
+      resolvedEnv: { values: { API_KEY: "hunter2" }, secrets: new Set(["API_KEY"]) },
+    });
+    expect(entry.inputSummary).toBe("key=***");
+    expect(entry.meta).toEqual({ request: { headers: ["Bearer ***"] }, count: 2 });
+  });
+});
+
+describe("setLogMeta", () => {
+  test("records JSON values and refuses others", async () => {
+    const meta: Record<string, unknown> = {};
+    const stderr = await captureStderr(() => {
+      setLogMeta(meta, "files", ["a.ts"]);
+      setLogMeta(meta, "big", 1n);
+      setLogMeta(meta, "fn", () => {});
+    });
+    expect(meta).toEqual({ files: ["a.ts"] });
+    expect(stderr).toContain('ignoring log metadata "big"');
+    expect(stderr).toContain('ignoring log metadata "fn": function is not JSON');
+  });
+});
+
+describe("logging.buffer", () => {
+  test("appends buffered entries once flushed", async () => {
+    const logFile = join(tmpDir, "buffered.jsonl");
+    const config = fileConfig(logFile, { flushIntervalMs: 60_000 });
+
+    await writeLogEntry(logEntry({ agent: "first" }), config);
+    await writeLogEntry(logEntry({ agent: "second" }), config);
+    expect(existsSync(logFile)).toBe(false);
+
+    await flushLogs();
+    const lines = readFileSync(logFile, "utf-8").trim().split("\n");
+    expect(lines.map((l) => JSON.parse(l).agent)).toEqual(["first", "second"]);
+  });
+
+  test("queryLogs flushes first", async () => {
+    const logFile = join(tmpDir, "buffered.jsonl");
+    const config = fileConfig(logFile, { flushIntervalMs: 60_000 });
+    await writeLogEntry(logEntry({ agent: "pending" }), config);
+    expect(queryLogs(config).map((e) => e.agent)).toEqual(["pending"]);
+  });
+
+  test("resolves true, a duration, and invalid values", async () => {
+    expect(resolveLoggingConfig({ logging: { buffer: true } }, false).flushIntervalMs).toBe(1000);
+    expect(resolveLoggingConfig({ logging: { buffer: "250ms" } }, false).flushIntervalMs).toBe(250);
+    let config: LoggingConfig | undefined;
+    const stderr = await captureStderr(() => {
+      config = resolveLoggingConfig({ logging: { buffer: "soon" } }, false);
+    });
+    expect(config!.flushIntervalMs).toBe(0);
+    expect(stderr).toContain('invalid logging.buffer "soon"');
+  });
+});
+
+describe("aggregateLogs", () => {
+  test("summarizes each agent's executions", () => {
+    const entries = [
+      logEntry({ agent: "b", durationMs: 5 }),
+      logEntry({ agent: "a", durationMs: 40, exitCode: 1 }),
+      logEntry({ agent: "a", durationMs: 10, timestamp: "2026-02-22T09:00:00Z" }),
+      logEntry({ agent: "a", durationMs: 30, exitCode: 3 }),
+      logEntry({ agent: "a", durationMs: 20, exitCode: 1, timestamp: "not a time" }),
+    ];
+    const [a, b] = aggregateLogs(entries);
+
+    expect(a.agent).toBe("a");
+    expect(a.executions).toBe(4);
+    expect(a.failures).toBe(3);
+    expect(a.successRate).toBe(0.25);
+    expect(a.p50DurationMs).toBe(20);
+    expect(a.p95DurationMs).toBe(40);
+    expect(a.perDay).toEqual({ "2026-02-21": 2, "2026-02-22": 1 });
+    expect(a.failureCodes).toEqual([
+      { exitCode: 1, count: 2 },
+      { exitCode: 3, count: 1 },
+    ]);
+    expect(a.sessionId).toBeUndefined();
+    expect(b).toMatchObject({ agent: "b", executions: 1, successRate: 1, p50DurationMs: 5, failureCodes: [] });
+  });
+
+  test("groups by session with bySession", () => {
+    const entries = [
+      logEntry({ agent: "a", sessionId: "s2" }),
+      logEntry({ agent: "a", sessionId: "s1" }),
+      logEntry({ agent: "a", sessionId: "s2" }),
+    ];
+    const stats = aggregateLogs(entries, { bySession: true });
+    expect(stats.map((s) => [s.sessionId, s.executions])).toEqual([
+      ["s1", 1],
+      ["s2", 2],
+    ]);
+  });
+});
+
+describe("upgradeLogEntry", () => {
+  test("upgrades a version 1 entry", () => {
+    const entry = upgradeLogEntry({ agent: "old", callChain: null });
+    expect(entry.schemaVersion).toBe(LOG_SCHEMA_VERSION);
+    expect(entry.callChain).toEqual([]);
+  });
+
+  test("leaves entries of the current or a newer version as they are", () => {
+    const fields = { schemaVersion: LOG_SCHEMA_VERSION + 1, agent: "new", callChain: null };
+    expect(upgradeLogEntry({ ...fields })).toEqual(fields);
+  });
+});
+
+describe("log sinks", () => {
+  test("writes entries to the agent's sinks after the log file", async () => {
+    const logFile = join(tmpDir, "sinks.jsonl");
+    const config = fileConfig(logFile);
+    const seen: string[] = [];
+    const calls: string[] = [];
+    const sink: LogSink = {
+      name: "memory",
+      write: (entry) => {
+        expect(existsSync(logFile)).toBe(true);
+        seen.push(entry.agent);
+      },
+      flush: () => {
+        calls.push("flush");
+      },
+      close: () => {
+        calls.push("close");
+      },
+    };
+    attachLogSinks(config, [sink]);
+
+    await writeLogEntry(logEntry({ agent: "sunk" }), config);
+    await flushLogs();
+    await closeLogSinks(config);
+
+    expect(seen).toEqual(["sunk"]);
+    expect(calls).toEqual(["flush", "close"]);
+  });
+
+  test("warns about a failing sink and still writes to the others", async () => {
+    const config = fileConfig(join(tmpDir, "sinks.jsonl"));
+    const seen: string[] = [];
+    class BrokenSink implements LogSink {
+      write(): void {
+        throw new Error("collector down");
+      }
+    }
+    attachLogSinks(config, [new BrokenSink(), { write: (entry) => void seen.push(entry.agent) }]);
+
+    const stderr = await captureStderr(() => writeLogEntry(logEntry({ agent: "resilient" }), config));
+    expect(stderr).toContain("failed to write execution log to BrokenSink: collector down");
+    expect(seen).toEqual(["resilient"]);
+  });
+
+  test("suppressed logging ignores the agent's sinks", async () => {
+    const config = fileConfig(join(tmpDir, "sinks.jsonl"), { suppressed: true });
+    let written = false;
+    attachLogSinks(config, [{ write: () => void (written = true) }]);
+    await writeLogEntry(logEntry({}), config);
+    expect(written).toBe(false);
+  });
+});
+
+describe("invocation log", () => {
+  test("records invocations in start order with the subagents' reports", async () => {
+    const log = createInvocationLog(Date.now());
+    const finishFirst = log.begin("sfa-first");
+    const finishSecond = log.begin("sfa-second");
+    await new Promise((resolve) => setTimeout(resolve, 5));
+    finishSecond(1);
+    finishFirst(0, {
+      agent: "first",
+      version: "2.1.0",
+      invocations: [{ seq: 1, agent: "leaf", exitCode: 0, durationMs: 1, offsetMs: 0 }],
+    });
+
+    const [first, second] = log.list();
+    expect(first).toMatchObject({ seq: 1, agent: "first", version: "2.1.0", exitCode: 0 });
+    expect(first.invocations).toHaveLength(1);
+    expect(second).toMatchObject({ seq: 2, agent: "sfa-second", exitCode: 1 });
+    expect(second.version).toBeUndefined();
+    expect(second.durationMs).toBeGreaterThanOrEqual(4);
+  });
+
+  test("writes the report to SFA_INVOCATION_FILE for the parent to read", () => {
+    const path = join(tmpDir, "invocation.json");
+    delete process.env.SFA_INVOCATION_FILE;
+    writeInvocationReport("child", "1.2.3", []);
+    expect(existsSync(path)).toBe(false);
+
+    process.env.SFA_INVOCATION_FILE = path;
+    writeInvocationReport("child", "1.2.3", []);
+    expect(readInvocationReport(path)).toEqual({ agent: "child", version: "1.2.3" });
+    expect(statSync(path).mode & 0o777).toBe(0o600);
+  });
+
+  test("reads no report from a missing or empty file", () => {
+    const empty = join(tmpDir, "empty.json");
+    writeFileSync(empty, "");
+    expect(readInvocationReport(empty)).toBeUndefined();
+    expect(readInvocationReport(join(tmpDir, "missing.json"))).toBeUndefined();
+  });
+});
@@ -1,15 +1,26 @@
 import { test, expect, describe, beforeEach, afterEach } from "bun:test";
 import { tmpdir } from "node:os";
 import { join } from "node:path";
-import { mkdirSync, rmSync, readFileSync, statSync } from "node:fs";
+import { mkdirSync, rmSync, readFileSync, statSync, writeFileSync } from "node:fs";
 
 // Services module interacts heavily with Docker and Bun.spawn, so these tests
 // focus on the pure functions and template generation logic. Integration tests
 // requiring Docker are in the integration test file (12.12).
 
 // We test materializeCompose by importing and checking the generated YAML.
-import { materializeCompose } from "../../sdk/typescript/@sfa/sdk/services";
-import type { ServiceDefinition } from "../../sdk/typescript/@sfa/sdk/types";
+import {
+  materializeCompose,
+  serviceStartOrder,
+  withAgentNetworks,
+  sharedNetworks,
+  allocateAutoPorts,
+  serviceStartTimeout,
+  namedVolumes,
+  activeServices,
+  resolveServiceProfiles,
+  parseDuration,
+} from "../../sdk/typescript/@sfa/sdk/services";
+import type { AgentDefinition, ServiceDefinition } from "../../sdk/typescript/@sfa/sdk/types";
 
 let tmpDir: string;
 
@@ -128,7 +139,7 @@ describe("materializeCompose", () => {
     expect(content).toContain('command: ["redis-server", "--appendonly", "yes"]');
   });
 
-  test("interpolates ${VAR} from environment", async () => {
+  test("keeps ${VAR} in the template and writes its value to .env", async () => {
     const services: Record<string, ServiceDefinition> = {
       db: {
         image: "postgres:16",
@@ -140,8 +151,12 @@ describe("materializeCompose", () => {
     const filePath = await materializeCompose(services, "agent", "1.0.0", env);
 
     const content = readFileSync(filePath, "utf-8");
-    expect(content).toContain("my-secret-password");
-    expect(content).not.toContain("${DB_PASS}");
+    expect(content).toContain("${DB_PASS}");
+    expect(content).not.toContain("my-secret-password");
+
+    const dotenv = join(SERVICES_DIR, "agent", ".env");
+    expect(readFileSync(dotenv, "utf-8")).toBe("DB_PASS='my-secret-password'\n");
+    expect(statSync(dotenv).mode & 0o777).toBe(0o600);
   });
 
   test("creates directory with 0700 permissions", async () => {
@@ -172,3 +187,349 @@ describe("materializeCompose", () => {
     expect(content).toContain("redis:7");
   });
 });
+
+/** Materialize services for test-agent and return the compose file's content. */
+async function composeFor(services: Record<string, ServiceDefinition>): Promise<string> {
+  return readFileSync(await materializeCompose(services, "test-agent", "1.0.0", {}), "utf-8");
+}
+
+describe("serviceStartOrder", () => {
+  test("orders dependencies first, otherwise by name", () => {
+    const order = serviceStartOrder({
+      app: { image: "app", dependsOn: { db: "service_healthy", cache: "" } },
+      db: { image: "postgres", healthcheck: { test: "pg_isready" } },
+      cache: { image: "redis" },
+      zeta: { image: "zeta" },
+    });
+    expect(order).toEqual(["cache", "db", "app", "zeta"]);
+  });
+
+  test("rejects an undeclared dependency", () => {
+    expect(() => serviceStartOrder({ app: { image: "app", dependsOn: { db: "" } } })).toThrow(
+      "service app depends on undeclared service db",
+    );
+  });
+
+  test("rejects service_healthy on a service without a healthcheck", () => {
+    expect(() =>
+      serviceStartOrder({ app: { image: "app", dependsOn: { db: "service_healthy" } }, db: { image: "postgres" } }),
+    ).toThrow("db has no healthcheck");
+  });
+
+  test("rejects an unknown condition", () => {
+    expect(() =>
+      serviceStartOrder({ app: { image: "app", dependsOn: { db: "service_ready" } }, db: { image: "postgres" } }),
+    ).toThrow('unknown depends-on condition "service_ready"');
+  });
+
+  test("rejects a cycle", () => {
+    expect(() =>
+      serviceStartOrder({
+        a: { image: "a", dependsOn: { b: "" } },
+        b: { image: "b", dependsOn: { a: "" } },
+      }),
+    ).toThrow("services have a dependency cycle: a -> b -> a");
+  });
+
+  test("writes services in start order with their conditions", async () => {
+    const content = await composeFor({
+      app: { image: "app", dependsOn: { db: "service_healthy" } },
+      db: { image: "postgres", healthcheck: { test: "pg_isready" } },
+    });
+    expect(content.indexOf("  db:")).toBeLessThan(content.indexOf("  app:"));
+    expect(content).toContain("    depends_on:\n      db:\n        condition: service_healthy\n");
+  });
+});
+
+describe("shared networks", () => {
+  test("withAgentNetworks adds the agent networks to each service once", () => {
+    const joined = withAgentNetworks(
+      { api: { image: "api", networks: ["backend"] }, db: { image: "postgres" } },
+      ["backend", "shared"],
+    );
+    expect(joined.api.networks).toEqual(["backend", "shared"]);
+    expect(joined.db.networks).toEqual(["backend", "shared"]);
+
+    const services = { db: { image: "postgres" } };
+    expect(withAgentNetworks(services)).toBe(services);
+  });
+
+  test("sharedNetworks lists each network once, sorted", () => {
+    expect(
+      sharedNetworks({
+        api: { image: "api", networks: ["shared", "backend"] },
+        db: { image: "postgres", networks: ["backend"] },
+      }),
+    ).toEqual(["backend", "shared"]);
+  });
+
+  test("sharedNetworks rejects default and invalid names", () => {
+    expect(() => sharedNetworks({ api: { image: "api", networks: ["default"] } })).toThrow(
+      'service api: invalid network name "default"',
+    );
+    expect(() => sharedNetworks({ api: { image: "api", networks: ["Back End"] } })).toThrow("invalid network name");
+  });
+
+  test("compose keeps the default network and aliases the service", async () => {
+    const content = await composeFor({ api: { image: "api", networks: ["backend"] } });
+    expect(content).toContain(
+      "    networks:\n      default: {}\n      backend:\n        aliases:\n          - \"test-agent-api\"\n",
+    );
+    expect(content).toContain("networks:\n  backend:\n    name: sfa-backend\n    external: true\n");
+  });
+});
+
+describe("resource limits", () => {
+  test("writes limits under deploy.resources", async () => {
+    const content = await composeFor({ ml: { image: "ml", resources: { cpus: 1.5, memory: "2g", pids: 100 } } });
+    expect(content).toContain("    deploy:\n      resources:\n        limits:\n");
+    expect(content).toContain('          cpus: "1.5"\n          memory: 2g\n          pids: 100\n');
+  });
+
+  test("rejects invalid limits before writing compose", async () => {
+    await expect(composeFor({ x: { image: "x", resources: { cpus: 0 } } })).rejects.toThrow('invalid CPU limit "0"');
+    await expect(composeFor({ x: { image: "x", resources: { memory: "lots" } } })).rejects.toThrow(
+      'invalid memory limit "lots"',
+    );
+    await expect(composeFor({ x: { image: "x", resources: { pids: -1 } } })).rejects.toThrow("invalid pids limit -1");
+  });
+});
+
+describe("allocateAutoPorts", () => {
+  test("picks a host port for auto: mappings and reuses it on later runs", async () => {
+    const services: Record<string, ServiceDefinition> = {
+      db: { image: "postgres", ports: ["auto:5432", "8080:80"] },
+      cache: { image: "redis", ports: ["6379:6379"] },
+    };
+    const first = await allocateAutoPorts("test-agent", services);
+    const [mapping, fixed] = first.db.ports!;
+    expect(mapping).toMatch(/^\d+:5432$/);
+    expect(fixed).toBe("8080:80");
+    expect(first.cache).toBe(services.cache);
+
+    const saved = JSON.parse(readFileSync(join(SERVICES_DIR, "test-agent", "ports.json"), "utf-8"));
+    expect(saved).toEqual({ "db/5432": Number(mapping.split(":")[0]) });
+
+    const second = await allocateAutoPorts("test-agent", services);
+    expect(second.db.ports![0]).toBe(mapping);
+  });
+
+  test("rejects an invalid container port", async () => {
+    await expect(allocateAutoPorts("test-agent", { db: { image: "postgres", ports: ["auto:99999"] } })).rejects.toThrow(
+      'service db: invalid port "auto:99999"',
+    );
+  });
+});
+
+async function captureStderr(fn: () => unknown): Promise<string> {
+  const write = process.stderr.write;
+  let out = "";
+  process.stderr.write = ((chunk: string | Uint8Array) => {
+    out += String(chunk);
+    return true;
+  }) as typeof process.stderr.write;
+  try {
+    await fn();
+  } finally {
+    process.stderr.write = write;
+  }
+  return out;
+}
+
+function agentWithServices(services: Record<string, ServiceDefinition>): AgentDefinition {
+  return { name: "test-agent", version: "1.0.0", description: "test", services } as AgentDefinition;
+}
+
+describe("serviceStartTimeout", () => {
+  const savedTimeout = process.env.SFA_SERVICE_TIMEOUT;
+
+  afterEach(() => {
+    if (savedTimeout === undefined) delete process.env.SFA_SERVICE_TIMEOUT;
+    else process.env.SFA_SERVICE_TIMEOUT = savedTimeout;
+  });
+
+  test("serviceStartTimeout prefers SFA_SERVICE_TIMEOUT, then the definition, then 60", async () => {
+    delete process.env.SFA_SERVICE_TIMEOUT;
+    const def = agentWithServices({});
+    expect(serviceStartTimeout(def)).toBe(60);
+    expect(serviceStartTimeout({ ...def, serviceHealthTimeout: 90 } as AgentDefinition)).toBe(90);
+    expect(serviceStartTimeout({ ...def, serviceStartTimeout: 120 })).toBe(120);
+
+    process.env.SFA_SERVICE_TIMEOUT = "15";
+    expect(serviceStartTimeout({ ...def, serviceStartTimeout: 120 })).toBe(15);
+
+    process.env.SFA_SERVICE_TIMEOUT = "soon";
+    let timeout = 0;
+    const stderr = await captureStderr(() => {
+      timeout = serviceStartTimeout({ ...def, serviceStartTimeout: 120 });
+    });
+    expect(timeout).toBe(120);
+    expect(stderr).toContain('ignoring invalid SFA_SERVICE_TIMEOUT "soon"');
+  });
+});
+
+describe("namedVolumes", () => {
+  test("maps each named volume to the services that mount it", () => {
+    const volumes = namedVolumes({
+      api: { image: "api", volumes: ["cache:/cache", "./src:/src", "/srv/data:/data"] },
+      db: { image: "postgres", volumes: ["pgdata:/var/lib/postgresql/data", "cache:/tmp/cache", "~/x:/x"] },
+    });
+    expect([...volumes.entries()]).toEqual([
+      ["cache", ["api", "db"]],
+      ["pgdata", ["db"]],
+    ]);
+  });
+
+  test("rejects a name Docker would refuse", () => {
+    expect(() => namedVolumes({ db: { image: "postgres", volumes: ["-data:/data"] } })).toThrow(
+      'service db: invalid volume name "-data"',
+    );
+  });
+
+  test("compose declares named volumes as external, per agent", async () => {
+    const content = await composeFor({ db: { image: "postgres", volumes: ["pgdata:/var/lib/postgresql/data"] } });
+    expect(content).toContain("volumes:\n  pgdata:\n    name: sfa-test-agent-pgdata\n    external: true\n");
+  });
+});
+
+describe("compose profiles", () => {
+  let configPath: string;
+  const savedConfig = process.env.SFA_CONFIG;
+
+  beforeEach(() => {
+    configPath = join(tmpDir, "config.json");
+    process.env.SFA_CONFIG = configPath;
+  });
+
+  afterEach(() => {
+    if (savedConfig === undefined) delete process.env.SFA_CONFIG;
+    else process.env.SFA_CONFIG = savedConfig;
+  });
+
+  const services: Record<string, ServiceDefinition> = {
+    db: { image: "postgres" },
+    ui: { image: "adminer", profiles: ["debug"], dependsOn: { db: "" } },
+    seed: { image: "seed", profiles: ["dev", "debug"] },
+  };
+
+  test("activeServices keeps services without profiles or with an enabled one", () => {
+    expect(Object.keys(activeServices(services, [])).sort()).toEqual(["db"]);
+    expect(Object.keys(activeServices(services, ["dev"])).sort()).toEqual(["db", "seed"]);
+    expect(Object.keys(activeServices(services, ["debug"])).sort()).toEqual(["db", "seed", "ui"]);
+  });
+
+  test("activeServices rejects a dependency on a service that is not started", () => {
+    expect(() =>
+      activeServices(
+        { app: { image: "app", dependsOn: { ui: "" } }, ui: { image: "adminer", profiles: ["debug"] } },
+        [],
+      ),
+    ).toThrow("service app depends on ui, which starts only with profile debug (pass --services-profile debug)");
+  });
+
+  test("resolveServiceProfiles merges the flag and config and warns about unknown profiles", async () => {
+    writeFileSync(
+      configPath,
+      JSON.stringify({
+        defaults: { services: { profiles: "dev" } },
+        agents: { "test-agent": { services: { profiles: ["nope"] } } },
+      }),
+    );
+    let profiles: string[] = [];
+    const stderr = await captureStderr(async () => {
+      profiles = await resolveServiceProfiles(agentWithServices(services), "debug, ,dev");
+    });
+    expect(profiles).toEqual(["debug", "dev"]);
+    expect(stderr).toContain("no service has profile nope");
+  });
+
+  test("materializeCompose writes profiles and COMPOSE_PROFILES", async () => {
+    const filePath = await materializeCompose(services, "test-agent", "1.0.0", {}, ["debug", "dev"]);
+    expect(readFileSync(filePath, "utf-8")).toContain('    profiles:\n      - "dev"\n      - "debug"\n');
+    expect(readFileSync(join(SERVICES_DIR, "test-agent", ".env"), "utf-8")).toContain("COMPOSE_PROFILES='debug,dev'");
+  });
+
+  test("materializeCompose rejects an invalid profile name", async () => {
+    await expect(composeFor({ db: { image: "postgres", profiles: ["-x"] } })).rejects.toThrow(
+      'service db: invalid profile name "-x"',
+    );
+  });
+});
+
+describe("GPU requests", () => {
+  test("writes device requests by ID or count", async () => {
+    const content = await composeFor({ ml: { image: "ml", gpus: "device=0,2" }, gpu: { image: "gpu", gpus: "all" } });
+    expect(content).toContain("    deploy:\n      resources:\n        reservations:\n");
+    expect(content).toContain('              device_ids:\n                - "0"\n                - "2"\n');
+    expect(content).toContain("              count: all\n");
+    expect(content).toContain("              capabilities: [gpu]\n");
+  });
+
+  test("rejects an invalid request", async () => {
+    for (const gpus of ["some", "0", "device="]) {
+      await expect(composeFor({ x: { image: "x", gpus } })).rejects.toThrow(`invalid gpus "${gpus}"`);
+    }
+  });
+});
+
+describe("build", () => {
+  test("builds from a context and labels the image with its inputs hash", async () => {
+    const context = join(tmpDir, "app");
+    mkdirSync(context);
+    writeFileSync(join(context, "Dockerfile"), "FROM scratch\n");
+    const build = { context, args: { VERSION: "1" } };
+    const content = await composeFor({ app: { build } });
+    expect(content).toContain("    image: sfa-test-agent-app\n");
+    expect(content).toContain(`      context: ${JSON.stringify(context)}\n      args:\n        VERSION: "1"\n`);
+    const hash = content.match(/sfa\.build-hash: "([0-9a-f]{16})"/)![1];
+
+    writeFileSync(join(context, "main.go"), "package main\n");
+    expect(await composeFor({ app: { build } })).not.toContain(hash);
+
+    rmSync(join(context, "Dockerfile"));
+    await expect(composeFor({ app: { build } })).rejects.toThrow("Dockerfile");
+  });
+});
+
+describe("parseDuration", () => {
+  test("parseDuration reads Go-style durations", () => {
+    expect(parseDuration("2h")).toBe(7_200_000);
+    expect(parseDuration("1h30m")).toBe(5_400_000);
+    expect(parseDuration("1.5s")).toBe(1500);
+    expect(parseDuration("250ms")).toBe(250);
+    expect(parseDuration("2 hours")).toBeNull();
+    expect(parseDuration("")).toBeNull();
+    expect(parseDuration("10")).toBeNull();
+  });
+});
+
+describe("container options", () => {
+  test("writes user, restart, extra hosts, and ulimits", async () => {
+    const content = await composeFor({
+      app: {
+        image: "app",
+        user: "1000:1000",
+        restart: "on-failure:3",
+        extraHosts: ["api.local:host-gateway", "db.local:10.0.0.5"],
+        ulimits: { nofile: { soft: 1024, hard: 4096 }, nproc: 512 },
+      },
+    });
+    expect(content).toContain('    user: "1000:1000"\n    restart: "on-failure:3"\n');
+    expect(content).toContain('    extra_hosts:\n      - "api.local:host-gateway"\n      - "db.local:10.0.0.5"\n');
+    expect(content).toContain(
+      "    ulimits:\n      nofile:\n        soft: 1024\n        hard: 4096\n      nproc: 512\n",
+    );
+  });
+
+  test("rejects invalid options before writing compose", async () => {
+    const cases: [ServiceDefinition, string][] = [
+      [{ image: "x", restart: "sometimes" }, 'invalid restart policy "sometimes"'],
+      [{ image: "x", extraHosts: ["api.local:nowhere"] }, 'invalid extra host "api.local:nowhere"'],
+      [{ image: "x", ulimits: { cores: 1 } }, 'unknown ulimit "cores"'],
+      [{ image: "x", ulimits: { nofile: { soft: 10, hard: 5 } } }, "soft limit 10 exceeds hard limit 5"],
+    ];
+    for (const [svc, message] of cases) {
+      await expect(composeFor({ x: svc })).rejects.toThrow(message);
+    }
+  });
+});